@@ -0,0 +1,294 @@
+// Package apierr defines the errors the HTTP API reports to clients: a stable
+// machine-readable code, the HTTP status it maps to, a human-readable message and
+// optional details. Services return them where they know what went wrong; handlers turn
+// any error into one with Wrap or From and render it with Body.
+package apierr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Code is a stable, machine-readable error code. Clients branch on codes, never on
+// messages, so a code must not change once published.
+type Code string
+
+// Request errors.
+const (
+	CodeInvalidRequest   Code = "INVALID_REQUEST"
+	CodeValidationFailed Code = "VALIDATION_FAILED"
+	CodePayloadTooLarge  Code = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMedia Code = "UNSUPPORTED_MEDIA_TYPE"
+	CodeRequestTimeout   Code = "REQUEST_TIMEOUT"
+	CodeRateLimited      Code = "RATE_LIMITED"
+	CodeConcurrentLimit  Code = "CONCURRENT_LIMIT_REACHED"
+	CodeQuotaExceeded    Code = "QUOTA_EXCEEDED"
+	CodePromptTooLarge   Code = "PROMPT_TOO_LARGE"
+	CodeNoSpeech         Code = "NO_SPEECH"
+)
+
+// Qiniu credential errors: the caller's Qiniu token is missing or was rejected upstream.
+const (
+	CodeTokenMissing Code = "TOKEN_MISSING"
+	CodeTokenInvalid Code = "TOKEN_INVALID"
+)
+
+// Authentication and account errors.
+const (
+	CodeAuthRequired         Code = "AUTH_REQUIRED"
+	CodeAuthDisabled         Code = "AUTH_DISABLED"
+	CodeAuthUnavailable      Code = "AUTH_UNAVAILABLE"
+	CodeAccessTokenMissing   Code = "ACCESS_TOKEN_MISSING"
+	CodeAccessTokenMalformed Code = "ACCESS_TOKEN_MALFORMED"
+	CodeAccessTokenExpired   Code = "ACCESS_TOKEN_EXPIRED"
+	CodeAccessTokenInvalid   Code = "ACCESS_TOKEN_INVALID"
+	CodeAPIKeyInvalid        Code = "API_KEY_INVALID"
+	CodeAPIKeyNotFound       Code = "API_KEY_NOT_FOUND"
+	CodeInsufficientScope    Code = "INSUFFICIENT_SCOPE"
+	CodeInvalidAccount       Code = "INVALID_ACCOUNT"
+	CodeInvalidCredentials   Code = "INVALID_CREDENTIALS"
+	CodeLoginLocked          Code = "LOGIN_LOCKED"
+	CodeUsernameTaken        Code = "USERNAME_TAKEN"
+	CodeEmailTaken           Code = "EMAIL_TAKEN"
+	CodeUserNotFound         Code = "USER_NOT_FOUND"
+	CodeWrongPassword        Code = "WRONG_PASSWORD"
+	CodeRefreshExpired       Code = "REFRESH_TOKEN_EXPIRED"
+	CodeRefreshReused        Code = "REFRESH_TOKEN_REUSED"
+	CodeRefreshInvalid       Code = "REFRESH_TOKEN_INVALID"
+	CodeVerificationDisabled Code = "VERIFICATION_DISABLED"
+	CodeVerificationExpired  Code = "VERIFICATION_EXPIRED"
+	CodeVerificationUsed     Code = "VERIFICATION_USED"
+	CodeVerificationInvalid  Code = "VERIFICATION_INVALID"
+	CodeEmailMissing         Code = "EMAIL_MISSING"
+	CodeEmailVerified        Code = "EMAIL_ALREADY_VERIFIED"
+	CodeTicketInvalid        Code = "TICKET_INVALID"
+	CodeTicketExpired        Code = "TICKET_EXPIRED"
+	CodeTicketUsed           Code = "TICKET_USED"
+	CodeAdminDisabled        Code = "ADMIN_DISABLED"
+	CodeAdminTokenInvalid    Code = "ADMIN_TOKEN_INVALID"
+)
+
+// Resource errors.
+const (
+	CodeRoleNotFound   Code = "ROLE_NOT_FOUND"
+	CodeRoleNameTaken  Code = "ROLE_NAME_TAKEN"
+	CodeNotOwner       Code = "NOT_OWNER"
+	CodeFlagNotFound   Code = "FLAG_NOT_FOUND"
+	CodeMemoryNotFound Code = "MEMORY_NOT_FOUND"
+	CodeBatchNotFound  Code = "BATCH_NOT_FOUND"
+	CodeVoiceNotFound  Code = "VOICE_NOT_FOUND"
+)
+
+// Server and dependency errors.
+const (
+	CodeInternal            Code = "INTERNAL_ERROR"
+	CodeFeatureDisabled     Code = "FEATURE_DISABLED"
+	CodeStorageDisabled     Code = "STORAGE_DISABLED"
+	CodeDependencyDown      Code = "DEPENDENCY_UNAVAILABLE"
+	CodeDraining            Code = "SERVER_DRAINING"
+	CodeCapacity            Code = "CAPACITY_EXCEEDED"
+	CodeUpstream            Code = "UPSTREAM_ERROR"
+	CodeUpstreamTimeout     Code = "UPSTREAM_TIMEOUT"
+	CodeUpstreamRateLimited Code = "UPSTREAM_RATE_LIMITED"
+	CodeUpstreamUnavailable Code = "UPSTREAM_UNAVAILABLE"
+)
+
+var statuses = map[Code]int{
+	CodeInvalidRequest:   http.StatusBadRequest,
+	CodeValidationFailed: http.StatusUnprocessableEntity,
+	CodePayloadTooLarge:  http.StatusRequestEntityTooLarge,
+	CodeUnsupportedMedia: http.StatusUnsupportedMediaType,
+	CodeRequestTimeout:   http.StatusRequestTimeout,
+	CodeRateLimited:      http.StatusTooManyRequests,
+	CodeConcurrentLimit:  http.StatusTooManyRequests,
+	CodeQuotaExceeded:    http.StatusPaymentRequired,
+	CodePromptTooLarge:   http.StatusBadRequest,
+	CodeNoSpeech:         http.StatusUnprocessableEntity,
+
+	CodeTokenMissing: http.StatusBadRequest,
+	CodeTokenInvalid: http.StatusUnauthorized,
+
+	CodeAuthRequired:         http.StatusUnauthorized,
+	CodeAuthDisabled:         http.StatusServiceUnavailable,
+	CodeAuthUnavailable:      http.StatusServiceUnavailable,
+	CodeAccessTokenMissing:   http.StatusUnauthorized,
+	CodeAccessTokenMalformed: http.StatusUnauthorized,
+	CodeAccessTokenExpired:   http.StatusUnauthorized,
+	CodeAccessTokenInvalid:   http.StatusUnauthorized,
+	CodeAPIKeyInvalid:        http.StatusUnauthorized,
+	CodeAPIKeyNotFound:       http.StatusNotFound,
+	CodeInsufficientScope:    http.StatusForbidden,
+	CodeInvalidAccount:       http.StatusUnprocessableEntity,
+	CodeInvalidCredentials:   http.StatusUnauthorized,
+	CodeLoginLocked:          http.StatusTooManyRequests,
+	CodeUsernameTaken:        http.StatusConflict,
+	CodeEmailTaken:           http.StatusConflict,
+	CodeUserNotFound:         http.StatusNotFound,
+	CodeWrongPassword:        http.StatusForbidden,
+	CodeRefreshExpired:       http.StatusUnauthorized,
+	CodeRefreshReused:        http.StatusUnauthorized,
+	CodeRefreshInvalid:       http.StatusUnauthorized,
+	CodeVerificationDisabled: http.StatusServiceUnavailable,
+	CodeVerificationExpired:  http.StatusBadRequest,
+	CodeVerificationUsed:     http.StatusConflict,
+	CodeVerificationInvalid:  http.StatusBadRequest,
+	CodeEmailMissing:         http.StatusBadRequest,
+	CodeEmailVerified:        http.StatusConflict,
+	CodeTicketInvalid:        http.StatusUnauthorized,
+	CodeTicketExpired:        http.StatusUnauthorized,
+	CodeTicketUsed:           http.StatusUnauthorized,
+	CodeAdminDisabled:        http.StatusForbidden,
+	CodeAdminTokenInvalid:    http.StatusUnauthorized,
+
+	CodeRoleNotFound:   http.StatusNotFound,
+	CodeRoleNameTaken:  http.StatusConflict,
+	CodeNotOwner:       http.StatusForbidden,
+	CodeFlagNotFound:   http.StatusNotFound,
+	CodeMemoryNotFound: http.StatusNotFound,
+	CodeBatchNotFound:  http.StatusNotFound,
+	CodeVoiceNotFound:  http.StatusNotFound,
+
+	CodeInternal:            http.StatusInternalServerError,
+	CodeFeatureDisabled:     http.StatusServiceUnavailable,
+	CodeStorageDisabled:     http.StatusServiceUnavailable,
+	CodeDependencyDown:      http.StatusServiceUnavailable,
+	CodeDraining:            http.StatusServiceUnavailable,
+	CodeCapacity:            http.StatusServiceUnavailable,
+	CodeUpstream:            http.StatusBadGateway,
+	CodeUpstreamTimeout:     http.StatusGatewayTimeout,
+	CodeUpstreamRateLimited: http.StatusTooManyRequests,
+	CodeUpstreamUnavailable: http.StatusServiceUnavailable,
+}
+
+// Status returns the HTTP status code maps to, 500 for unknown codes.
+func Status(code Code) int {
+	if status, ok := statuses[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error reported to API clients.
+type Error struct {
+	Code    Code
+	Message string
+	// Detail carries technical context for debugging, typically the underlying error.
+	Detail string
+	// Fields are endpoint-specific members of the response body, such as retry_after.
+	Fields map[string]any
+
+	err error
+}
+
+// New returns an error with code and message.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap reports err to clients as message. The code is the one of the most specific
+// classification found in err's chain: an *Error, a context deadline (UPSTREAM_TIMEOUT) or
+// an oversized request body (PAYLOAD_TOO_LARGE); code only applies when there is none.
+// err's text becomes the detail and err stays reachable through errors.Is and errors.As.
+func Wrap(err error, code Code, message string) *Error {
+	if err == nil {
+		return New(code, message)
+	}
+	if classified, ok := classify(err); ok {
+		code = classified
+	}
+	return &Error{Code: code, Message: message, Detail: err.Error(), err: err}
+}
+
+// From returns the *Error in err's chain, or reports err as an internal error without
+// exposing its text.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return &Error{Code: CodeInternal, Message: "internal error", err: err}
+}
+
+// CodeOf returns the code err would be reported with, fallback when it is unclassified.
+func CodeOf(err error, fallback Code) Code {
+	if code, ok := classify(err); ok {
+		return code
+	}
+	return fallback
+}
+
+func classify(err error) (Code, bool) {
+	var apiErr *Error
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Code, true
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return CodeUpstreamTimeout, true
+	case errors.As(err, &tooLarge):
+		return CodePayloadTooLarge, true
+	}
+	return "", false
+}
+
+// Error returns the wrapped error's text when there is one, so logs keep the full cause,
+// and the message otherwise.
+func (e *Error) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Status returns the HTTP status of the error's code.
+func (e *Error) Status() int {
+	return Status(e.Code)
+}
+
+// WithDetail returns a copy of e with detail set.
+func (e *Error) WithDetail(detail string) *Error {
+	copied := *e
+	copied.Detail = detail
+	return &copied
+}
+
+// With returns a copy of e with the body field key set to value.
+func (e *Error) With(key string, value any) *Error {
+	copied := *e
+	copied.Fields = make(map[string]any, len(e.Fields)+1)
+	for k, v := range e.Fields {
+		copied.Fields[k] = v
+	}
+	copied.Fields[key] = value
+	return &copied
+}
+
+// Body renders the response envelope:
+//
+//	{"code": "ROLE_NOT_FOUND", "message": "role not found", "error": "role not found",
+//	 "request_id": "...", "detail": "..."}
+//
+// error repeats message for clients written before codes existed; detail and request_id
+// are omitted when empty.
+func (e *Error) Body(requestID string) map[string]any {
+	body := make(map[string]any, len(e.Fields)+5)
+	for k, v := range e.Fields {
+		body[k] = v
+	}
+	body["code"] = e.Code
+	body["message"] = e.Message
+	body["error"] = e.Message
+	if requestID != "" {
+		body["request_id"] = requestID
+	}
+	if e.Detail != "" {
+		body["detail"] = e.Detail
+	}
+	return body
+}
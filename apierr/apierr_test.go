@@ -0,0 +1,166 @@
+package apierr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	cases := []struct {
+		code   Code
+		status int
+	}{
+		{CodeInvalidRequest, http.StatusBadRequest},
+		{CodeValidationFailed, http.StatusUnprocessableEntity},
+		{CodePayloadTooLarge, http.StatusRequestEntityTooLarge},
+		{CodeUnsupportedMedia, http.StatusUnsupportedMediaType},
+		{CodeRequestTimeout, http.StatusRequestTimeout},
+		{CodeRateLimited, http.StatusTooManyRequests},
+		{CodeConcurrentLimit, http.StatusTooManyRequests},
+		{CodeQuotaExceeded, http.StatusPaymentRequired},
+		{CodePromptTooLarge, http.StatusBadRequest},
+		{CodeNoSpeech, http.StatusUnprocessableEntity},
+		{CodeTokenMissing, http.StatusBadRequest},
+		{CodeTokenInvalid, http.StatusUnauthorized},
+		{CodeAuthRequired, http.StatusUnauthorized},
+		{CodeAuthDisabled, http.StatusServiceUnavailable},
+		{CodeAuthUnavailable, http.StatusServiceUnavailable},
+		{CodeAccessTokenMissing, http.StatusUnauthorized},
+		{CodeAccessTokenMalformed, http.StatusUnauthorized},
+		{CodeAccessTokenExpired, http.StatusUnauthorized},
+		{CodeAccessTokenInvalid, http.StatusUnauthorized},
+		{CodeAPIKeyInvalid, http.StatusUnauthorized},
+		{CodeAPIKeyNotFound, http.StatusNotFound},
+		{CodeInsufficientScope, http.StatusForbidden},
+		{CodeInvalidAccount, http.StatusUnprocessableEntity},
+		{CodeInvalidCredentials, http.StatusUnauthorized},
+		{CodeLoginLocked, http.StatusTooManyRequests},
+		{CodeUsernameTaken, http.StatusConflict},
+		{CodeEmailTaken, http.StatusConflict},
+		{CodeUserNotFound, http.StatusNotFound},
+		{CodeWrongPassword, http.StatusForbidden},
+		{CodeRefreshExpired, http.StatusUnauthorized},
+		{CodeRefreshReused, http.StatusUnauthorized},
+		{CodeRefreshInvalid, http.StatusUnauthorized},
+		{CodeVerificationDisabled, http.StatusServiceUnavailable},
+		{CodeVerificationExpired, http.StatusBadRequest},
+		{CodeVerificationUsed, http.StatusConflict},
+		{CodeVerificationInvalid, http.StatusBadRequest},
+		{CodeEmailMissing, http.StatusBadRequest},
+		{CodeEmailVerified, http.StatusConflict},
+		{CodeTicketInvalid, http.StatusUnauthorized},
+		{CodeTicketExpired, http.StatusUnauthorized},
+		{CodeTicketUsed, http.StatusUnauthorized},
+		{CodeAdminDisabled, http.StatusForbidden},
+		{CodeAdminTokenInvalid, http.StatusUnauthorized},
+		{CodeRoleNotFound, http.StatusNotFound},
+		{CodeRoleNameTaken, http.StatusConflict},
+		{CodeNotOwner, http.StatusForbidden},
+		{CodeFlagNotFound, http.StatusNotFound},
+		{CodeMemoryNotFound, http.StatusNotFound},
+		{CodeBatchNotFound, http.StatusNotFound},
+		{CodeVoiceNotFound, http.StatusNotFound},
+		{CodeInternal, http.StatusInternalServerError},
+		{CodeFeatureDisabled, http.StatusServiceUnavailable},
+		{CodeStorageDisabled, http.StatusServiceUnavailable},
+		{CodeDependencyDown, http.StatusServiceUnavailable},
+		{CodeDraining, http.StatusServiceUnavailable},
+		{CodeCapacity, http.StatusServiceUnavailable},
+		{CodeUpstream, http.StatusBadGateway},
+		{CodeUpstreamTimeout, http.StatusGatewayTimeout},
+		{CodeUpstreamRateLimited, http.StatusTooManyRequests},
+		{CodeUpstreamUnavailable, http.StatusServiceUnavailable},
+		{Code("NOT_A_CODE"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		t.Run(string(tc.code), func(t *testing.T) {
+			if got := Status(tc.code); got != tc.status {
+				t.Errorf("Status(%s) = %d, want %d", tc.code, got, tc.status)
+			}
+			if got := New(tc.code, "message").Status(); got != tc.status {
+				t.Errorf("New(%s).Status() = %d, want %d", tc.code, got, tc.status)
+			}
+		})
+	}
+	// every mapped code is listed above, so adding a code without a test fails here
+	if len(statuses) != len(cases)-1 {
+		t.Errorf("statuses maps %d codes, the table covers %d", len(statuses), len(cases)-1)
+	}
+}
+
+func TestWrapClassification(t *testing.T) {
+	sentinel := errors.New("boom")
+	tooLarge := &http.MaxBytesError{Limit: 10}
+	cases := []struct {
+		name string
+		err  error
+		code Code
+	}{
+		{"plain error keeps the given code", sentinel, CodeUpstream},
+		{"nested api error wins", fmt.Errorf("load: %w", New(CodeRoleNotFound, "role not found")), CodeRoleNotFound},
+		{"deadline", fmt.Errorf("call: %w", context.DeadlineExceeded), CodeUpstreamTimeout},
+		{"canceled", context.Canceled, CodeUpstreamTimeout},
+		{"body too large", fmt.Errorf("read: %w", tooLarge), CodePayloadTooLarge},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			wrapped := Wrap(tc.err, CodeUpstream, "upstream failed")
+			if wrapped.Code != tc.code {
+				t.Errorf("Wrap code = %s, want %s", wrapped.Code, tc.code)
+			}
+			if !errors.Is(wrapped, tc.err) {
+				t.Error("wrapped error is not reachable with errors.Is")
+			}
+			if wrapped.Detail != tc.err.Error() {
+				t.Errorf("detail = %q, want the wrapped error text", wrapped.Detail)
+			}
+			if got := CodeOf(tc.err, CodeUpstream); got != tc.code {
+				t.Errorf("CodeOf = %s, want %s", got, tc.code)
+			}
+		})
+	}
+
+	if got := Wrap(nil, CodeInvalidRequest, "bad"); got.Code != CodeInvalidRequest || got.Detail != "" {
+		t.Errorf("Wrap(nil) = %+v", got)
+	}
+	if got := From(sentinel); got.Code != CodeInternal || got.Message == sentinel.Error() {
+		t.Errorf("From(plain error) = %+v, want an internal error hiding the text", got)
+	}
+}
+
+func TestBody(t *testing.T) {
+	err := New(CodeRateLimited, "slow down").With("retry_after", 3).WithDetail("bucket empty")
+	body := err.Body("req-1")
+	want := map[string]any{
+		"code":        CodeRateLimited,
+		"message":     "slow down",
+		"error":       "slow down",
+		"request_id":  "req-1",
+		"detail":      "bucket empty",
+		"retry_after": 3,
+	}
+	if len(body) != len(want) {
+		t.Fatalf("Body = %v, want %v", body, want)
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("Body[%q] = %v, want %v", key, body[key], value)
+		}
+	}
+
+	bare := New(CodeInternal, "internal error").Body("")
+	for _, key := range []string{"request_id", "detail"} {
+		if _, ok := bare[key]; ok {
+			t.Errorf("Body without %s still has it: %v", key, bare)
+		}
+	}
+	// With and WithDetail copy, leaving the original untouched
+	base := New(CodeInternal, "x")
+	_ = base.With("k", 1).WithDetail("d")
+	if base.Fields != nil || base.Detail != "" {
+		t.Errorf("With modified the receiver: %+v", base)
+	}
+}
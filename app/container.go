@@ -0,0 +1,402 @@
+// Package app assembles the server: Container builds every service and handler from the
+// configuration and the database clients, and RegisterRoutes wires them into a router.
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/wuwenbin0122/wwb.ai/auth"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/flags"
+	"github.com/wuwenbin0122/wwb.ai/handlers"
+	"github.com/wuwenbin0122/wwb.ai/jobs"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"github.com/wuwenbin0122/wwb.ai/mailer"
+	"github.com/wuwenbin0122/wwb.ai/memory"
+	"github.com/wuwenbin0122/wwb.ai/metrics"
+	"github.com/wuwenbin0122/wwb.ai/payloadlog"
+	"github.com/wuwenbin0122/wwb.ai/quota"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
+	"github.com/wuwenbin0122/wwb.ai/redact"
+	"github.com/wuwenbin0122/wwb.ai/services"
+	"github.com/wuwenbin0122/wwb.ai/storage"
+	"github.com/wuwenbin0122/wwb.ai/workers"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// Clients are the connections a Container is built on. The caller opens them and closes
+// them after shutdown; Mongo and Redis dial lazily and may not be reachable yet.
+type Clients struct {
+	Postgres *pgxpool.Pool
+	// Replica is an optional read replica; nil when none is configured or reachable.
+	Replica *pgxpool.Pool
+	Mongo   *mongo.Client
+	Redis   *redis.Client
+	// Roles replaces the Postgres role repository when set, as testsupport does with a
+	// db.MemoryRoleRepository.
+	Roles db.RoleRepository
+}
+
+// Container owns everything the server runs on. Handlers receive their dependencies here,
+// through their constructors, rather than from the request context.
+type Container struct {
+	Config  *config.Config
+	Logger  *zap.SugaredLogger
+	Clients Clients
+
+	// Supervisor runs the background workers (usage flushes, session writes); the caller
+	// starts and stops it.
+	Supervisor *workers.Supervisor
+	Metrics    *metrics.HTTP
+	// Jobs runs queued background work; it is supervised like the other workers and drains
+	// when they stop.
+	Jobs *jobs.Queue
+	// MongoStatus and RedisStatus track the optional dependencies: features that need them
+	// degrade while they are down and resume once their monitor sees them back.
+	MongoStatus *db.Dependency
+	RedisStatus *db.Dependency
+
+	AuthService *auth.Service
+	FlagService *flags.Service
+	Quotas      *quota.Service
+	TTSService  *services.TTSService
+	// ChatSlots caps the chats each caller runs at once; nil when unlimited.
+	ChatSlots *ratelimit.Concurrency
+	// Breakers guard the Qiniu endpoint classes; their state shows in /health/ready and
+	// /metrics.
+	Breakers []*services.CircuitBreaker
+	// Blobs stores uploaded avatars and audio; nil when the blob store is unusable.
+	Blobs storage.BlobStore
+
+	Health   *handlers.HealthHandler
+	Auth     *handlers.AuthHandler
+	Roles    *handlers.RoleHandler
+	NLP      *handlers.NLPHandler
+	Audio    *handlers.AudioHandler
+	TTSBatch *handlers.TTSBatchHandler
+	Flags    *handlers.FlagsHandler
+	Voice    *handlers.VoiceHandler
+	Usage    *handlers.UsageHandler
+	Quota    *handlers.QuotaHandler
+	Privacy  *handlers.PrivacyHandler
+	Memories *handlers.MemoryHandler
+	Debug    *handlers.DebugHandler
+	Stats    *handlers.StatsHandler
+
+	// AudioUploads presigns direct audio uploads to Blobs.
+	AudioUploads *handlers.AudioUploadHandler
+	// CustomVoices registers voices cloned upstream from reference audio.
+	CustomVoices *handlers.CustomVoiceHandler
+}
+
+// New builds a Container. It does no I/O beyond preparing the blob directory, so it can
+// run against clients that are not connected yet.
+func New(cfg *config.Config, logger *zap.SugaredLogger, clients Clients) *Container {
+	c := &Container{
+		Config:     cfg,
+		Logger:     logger,
+		Clients:    clients,
+		Supervisor: workers.NewSupervisor(logger, workers.Options{}),
+		Metrics:    metrics.NewHTTP(),
+	}
+
+	c.MongoStatus = db.NewDependency("mongo", func(ctx context.Context) error {
+		return clients.Mongo.Ping(ctx, nil)
+	}, logger)
+	c.RedisStatus = db.NewDependency("redis", func(ctx context.Context) error {
+		if clients.Redis == nil {
+			return errors.New("redis is not configured")
+		}
+		return clients.Redis.Ping(ctx).Err()
+	}, logger)
+	for _, dependency := range []*db.Dependency{c.MongoStatus, c.RedisStatus} {
+		c.Supervisor.Add(workers.Func(dependency.Name()+"-monitor", func(ctx context.Context) error {
+			dependency.Monitor(ctx, time.Duration(cfg.DependencyCheckSeconds)*time.Second)
+			return ctx.Err()
+		}))
+	}
+
+	redisKV := kv.New(clients.Redis, cfg.RedisKeyPrefix)
+	redisKV.TrackAvailability(c.RedisStatus.Available)
+	c.Jobs = jobs.New(redisKV, jobs.Options{Observer: metrics.NewJobs(c.Metrics.Registry())}, logger)
+
+	pools := db.NewPoolRouter(clients.Postgres, clients.Replica, logger)
+	if clients.Replica != nil {
+		c.Supervisor.Add(workers.Func("postgres-replica-monitor", func(ctx context.Context) error {
+			pools.MonitorReplica(ctx, 10*time.Second)
+			return ctx.Err()
+		}))
+	}
+
+	poolStats := metrics.NewPoolStats(c.Metrics.Registry(), map[string]*pgxpool.Pool{
+		"primary": clients.Postgres,
+		"replica": clients.Replica,
+	})
+	c.Supervisor.Add(workers.Func("postgres-pool-stats", func(ctx context.Context) error {
+		return poolStats.Run(ctx, 10*time.Second)
+	}))
+
+	c.AuthService = auth.NewService(cfg.JWTSecret,
+		time.Duration(cfg.AccessTokenTTLMinutes)*time.Minute,
+		time.Duration(cfg.RefreshTokenTTLHours)*time.Hour,
+		db.NewPgUserStore(pools))
+	if c.AuthService == nil {
+		logger.Warn("JWT_SECRET is not set, routes that require a signed-in user are disabled")
+	} else {
+		// no SMTP integration yet: verification links are written to the log
+		c.AuthService.EnableEmailVerification(mailer.NewLogMailer(logger), cfg.EmailVerifyURL,
+			time.Duration(cfg.EmailVerifyTTLMinutes)*time.Minute)
+		c.AuthService.EnableTickets(redisKV)
+	}
+	loginLockout := ratelimit.LockoutPolicy{
+		Base: time.Duration(cfg.LoginLockoutSeconds) * time.Second,
+		Max:  time.Duration(cfg.LoginLockoutMaxSeconds) * time.Second,
+	}
+	accountPolicy, ipPolicy := loginLockout, loginLockout
+	accountPolicy.Threshold = cfg.LoginLockoutThreshold
+	ipPolicy.Threshold = cfg.LoginIPLockoutThreshold
+	c.Auth = handlers.NewAuthHandler(c.AuthService,
+		ratelimit.NewLockout(redisKV, "login:account", accountPolicy, logger),
+		ratelimit.NewLockout(redisKV, "login:ip", ipPolicy, logger),
+		logger)
+
+	pgRoles := db.NewPgRoleRepository(pools)
+	var roleEmbeddingJobs *jobs.Queue
+	if embeddings := services.NewEmbeddingService(cfg, logger); embeddings != nil {
+		pgRoles.EnableSemanticSearch(embeddings)
+		c.Jobs.Register(handlers.RoleEmbeddingJobType, handlers.RoleEmbeddingJob(pgRoles), jobs.TypeOptions{Workers: 1})
+		roleEmbeddingJobs = c.Jobs
+	}
+	var roleSource db.RoleRepository = pgRoles
+	if clients.Roles != nil {
+		roleSource = clients.Roles
+	}
+	roleRepo := db.NewCachedRoleRepository(roleSource, redisKV,
+		time.Duration(cfg.RoleByIDCacheTTLSeconds)*time.Second, logger)
+	roleCache := db.NewRoleListCache(redisKV, time.Duration(cfg.RoleCacheTTLSeconds)*time.Second)
+	if blobs, err := blobStore(cfg); err != nil {
+		logger.Warnf("blob storage unavailable, avatar and audio uploads disabled: %v", err)
+	} else {
+		c.Blobs = blobs
+	}
+	flushInterval := time.Duration(cfg.RoleUsageFlushSeconds) * time.Second
+	if flushInterval <= 0 {
+		flushInterval = 30 * time.Second
+	}
+	roleStats := db.NewRoleStatsStore(pools, redisKV, flushInterval, time.Duration(cfg.RoleUsageHalfLifeHours)*time.Hour, logger)
+	c.Supervisor.Add(workers.Func("role-usage-flusher", roleStats.Run))
+	c.Quotas = quota.NewService(redisKV, pools, quota.Limits{
+		TokensPerMonth:        int64(cfg.QuotaTokensPerMonth),
+		TTSCharactersPerMonth: int64(cfg.QuotaTTSCharactersPerMonth),
+		ASRMinutesPerMonth:    int64(cfg.QuotaASRMinutesPerMonth),
+	}, logger)
+	reconcileInterval := time.Duration(cfg.QuotaReconcileSeconds) * time.Second
+	if reconcileInterval <= 0 {
+		reconcileInterval = 5 * time.Minute
+	}
+	c.Supervisor.Add(workers.Func("quota-reconciler", func(ctx context.Context) error {
+		return c.Quotas.Run(ctx, reconcileInterval)
+	}))
+	c.Quota = handlers.NewQuotaHandler(c.Quotas, logger)
+	c.ChatSlots = ratelimit.NewConcurrency(redisKV, cfg.ChatMaxConcurrent, cfg.ChatMaxConcurrentOverrides, logger)
+
+	usage := db.NewUsageStore(pools, c.Quotas, logger)
+	c.Supervisor.Add(workers.Func("usage-writer", usage.Run))
+	c.Usage = handlers.NewUsageHandler(usage, logger)
+
+	nlpService := services.NewNLPService(cfg, logger)
+	payloads := c.payloadRecorder()
+	nlpService.RecordPayloads(payloads)
+	nlpService.ObservePromptGuard(metrics.NewPromptGuard(c.Metrics.Registry()))
+	c.Debug = handlers.NewDebugHandler(payloads, logger)
+	transcripts := transcriptRedactor(cfg, logger)
+	memoryStore := db.NewMemoryStore(clients.Mongo, cfg.MongoDatabase)
+	memoryStore.RedactWith(transcripts)
+	memoryStore.TrackAvailability(c.MongoStatus.Available)
+	memories := memory.NewService(memoryStore, services.NewMemoryExtractor(nlpService), cfg.MemoryTopK, logger)
+	if cfg.MemoryTopK > 0 {
+		c.Supervisor.Add(workers.Func("memory-extractor", memories.Run))
+		// the extractor creates the indexes on start, which fails while Mongo is down
+		c.MongoStatus.OnRecover(func(ctx context.Context) {
+			if err := memoryStore.EnsureIndexes(ctx); err != nil {
+				logger.Warnf("ensure memory indexes: %v", err)
+			}
+		})
+	}
+	c.Memories = handlers.NewMemoryHandler(memoryStore, logger)
+	c.Roles = handlers.NewRoleHandler(cfg, roleRepo, roleCache, c.Blobs, roleStats, services.NewSkillEnricher(nlpService), services.NewRoleRecommender(roleEmbedder(cfg)), roleEmbeddingJobs, logger)
+	c.Roles.ManageGlobalPrompt(nlpService.GlobalPrompt())
+	if cfg.RoleReloadSeconds > 0 {
+		c.Supervisor.Add(workers.Func("role-cache-refresh", func(ctx context.Context) error {
+			return c.Roles.RunCacheRefresh(ctx, time.Duration(cfg.RoleReloadSeconds)*time.Second)
+		}))
+	}
+	c.Roles.EvaluatePersonas(services.NewPersonaEvaluator(nlpService, nlpService, cfg.PersonaEvalConcurrency, time.Duration(cfg.PersonaEvalTimeoutSeconds)*time.Second))
+	suggestions := services.NewSuggestionGenerator(nlpService, redisKV, time.Duration(cfg.SuggestionTimeoutMS)*time.Millisecond, logger)
+	sentiment := services.NewSentimentClassifier(cfg.SentimentClassifier, nlpService, cfg.QiniuAPIKey, time.Duration(cfg.SentimentTimeoutMS)*time.Millisecond, logger)
+	c.NLP = handlers.NewNLPHandler(cfg, roleRepo, roleStats, nlpService, usage, memories, suggestions, sentiment, c.AuthService, logger)
+
+	asrService := services.NewASRService(cfg, logger)
+	c.TTSService = services.NewTTSService(cfg, logger)
+	asrSessions := db.NewASRSessionStore(clients.Mongo, cfg.MongoDatabase, logger)
+	asrSessions.RedactWith(transcripts)
+	asrSessions.TrackAvailability(c.MongoStatus.Available)
+	c.Supervisor.Add(workers.Func("asr-session-writer", asrSessions.Run))
+	var asrResume *db.ASRResumeStore
+	if cfg.ASRResumeTTLSeconds > 0 {
+		asrResume = db.NewASRResumeStore(redisKV, time.Duration(cfg.ASRResumeTTLSeconds)*time.Second)
+	}
+	voiceCatalog := services.NewVoiceCatalog(c.TTSService, redisKV, logger)
+	audioLimiter := ratelimit.New(redisKV, cfg.AudioRatePerMinute, cfg.AudioRateBurst, logger)
+	c.Audio = handlers.NewAudioHandler(cfg, roleRepo, asrService, c.TTSService, asrSessions, asrResume, voiceCatalog, audioLimiter, usage, logger)
+	c.CustomVoices = handlers.NewCustomVoiceHandler(cfg, db.NewPgCustomVoiceStore(pools), services.NewVoiceCloneClient(cfg), roleRepo, c.TTSService, logger)
+	c.Audio.ListCustomVoices(c.CustomVoices)
+	c.AudioUploads = handlers.NewAudioUploadHandler(cfg, c.Blobs, logger)
+	c.TTSBatch = handlers.NewTTSBatchHandler(cfg, c.TTSService, db.NewTTSBatchStore(redisKV, 24*time.Hour), c.Jobs, audioLimiter, usage, logger)
+	c.Jobs.Register(handlers.TTSBatchJobType, c.TTSBatch.ProcessJob, jobs.TypeOptions{MaxAttempts: 3, Timeout: handlers.TTSBatchJobTimeout})
+	c.Privacy = handlers.NewPrivacyHandler([]handlers.NamedUserDataStore{
+		{Name: "asr_sessions", Store: asrSessions},
+		{Name: "usage_events", Store: usage},
+		{Name: "memories", Store: memoryStore},
+	}, db.NewDataDeletionLog(pools), logger)
+
+	c.FlagService = flags.NewService(redisKV, logger)
+	c.Flags = handlers.NewFlagsHandler(c.FlagService, logger)
+
+	voicePipeline := services.NewVoicePipeline(asrService, nlpService, c.TTSService)
+	voicePipeline.ObserveFailures(metrics.NewVoicePipeline(c.Metrics.Registry()))
+	c.Voice = handlers.NewVoiceHandler(cfg, roleRepo, voicePipeline, usage, audioLimiter, c.AuthService, logger)
+
+	c.Breakers = append(nlpService.Breakers(), asrService.Breaker(), c.TTSService.Breaker())
+	states := make(map[string]func() float64, len(c.Breakers))
+	for _, breaker := range c.Breakers {
+		states[breaker.Name()] = func() float64 { return float64(breaker.State()) }
+	}
+	metrics.RegisterCircuitStates(c.Metrics.Registry(), states)
+	c.Stats = handlers.NewStatsHandler(c.Metrics.Stats(), c.Breakers, usage, logger)
+
+	// added last so it stops first, draining its jobs while the stores they write to still run
+	c.Supervisor.Add(workers.Func("jobs", c.Jobs.Run))
+
+	c.Health = handlers.NewHealthHandler(c.healthChecks(), time.Second, 2*time.Second, logger)
+
+	return c
+}
+
+// WarmUp checks Mongo and Redis once before the server takes traffic, so features start
+// in the right mode. Unreachable dependencies are logged and left to their monitors.
+func (c *Container) WarmUp(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, dependency := range []*db.Dependency{c.MongoStatus, c.RedisStatus} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := dependency.Check(ctx); err != nil {
+				c.Logger.Warnf("%s unreachable at startup, starting without it: %v", dependency.Name(), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// payloadRecorder builds the recorder of upstream payloads. Its sink is the server log
+// unless DEBUG_PAYLOAD_LOG_PATH names a file of its own.
+func (c *Container) payloadRecorder() *payloadlog.Recorder {
+	cfg := c.Config
+	redactor, err := payloadlog.NewRedactor(cfg.DebugRedactPatterns)
+	if err != nil {
+		// validated on load; fall back to the built-in masks
+		c.Logger.Warnf("invalid redact patterns, using defaults: %v", err)
+		redactor, _ = payloadlog.NewRedactor(nil)
+	}
+	sink := c.Logger.Desugar().Named("payloads")
+	if cfg.DebugPayloadLogPath != "" {
+		sinkConfig := zap.NewProductionConfig()
+		sinkConfig.OutputPaths = []string{cfg.DebugPayloadLogPath}
+		if fileSink, err := sinkConfig.Build(); err != nil {
+			c.Logger.Warnf("payload log %s unavailable, using the server log: %v", cfg.DebugPayloadLogPath, err)
+		} else {
+			sink = fileSink
+		}
+	}
+	if cfg.DebugPayloadLog {
+		c.Logger.Warn("DEBUG_PAYLOAD_LOG is on: every upstream chat payload is recorded")
+	}
+	return payloadlog.New(cfg.DebugPayloadLog, cfg.DebugPayloadBuffer, redactor, sink)
+}
+
+// roleEmbedder returns the embedder of role recommendations, nil for keyword matching only.
+// blobStore builds the store BLOB_BACKEND selects.
+func blobStore(cfg *config.Config) (storage.BlobStore, error) {
+	if cfg.BlobBackend == "local" {
+		return storage.NewLocalStore(cfg.BlobDir, "/static")
+	}
+	endpoint := cfg.BlobS3Endpoint
+	if endpoint == "" && cfg.BlobBackend == "kodo" {
+		endpoint = storage.KodoEndpoint(cfg.BlobS3Region)
+	}
+	return storage.NewS3Store(storage.S3Config{
+		Endpoint:       endpoint,
+		Region:         cfg.BlobS3Region,
+		Bucket:         cfg.BlobS3Bucket,
+		AccessKey:      cfg.BlobS3AccessKey,
+		SecretKey:      cfg.BlobS3SecretKey,
+		PathStyle:      cfg.BlobS3PathStyle,
+		PublicBaseURL:  cfg.BlobPublicBaseURL,
+		DownloadExpiry: time.Duration(cfg.BlobDownloadExpirySeconds) * time.Second,
+	})
+}
+
+// transcriptRedactor returns the redactor applied to conversation text before it is
+// stored, nil when TRANSCRIPT_REDACTION is off.
+func transcriptRedactor(cfg *config.Config, logger *zap.SugaredLogger) *redact.Redactor {
+	if !cfg.TranscriptRedaction {
+		return nil
+	}
+	redactor, err := redact.New(cfg.TranscriptRedactPatterns)
+	if err != nil {
+		logger.Warnf("invalid transcript redact patterns, using built-in rules: %v", err)
+		redactor, _ = redact.New(nil)
+	}
+	return redactor
+}
+
+func roleEmbedder(cfg *config.Config) services.Embedder {
+	if cfg.RoleRecommendEmbedder == "none" {
+		return nil
+	}
+	return services.BagOfWordsEmbedder{}
+}
+
+func (c *Container) healthChecks() []handlers.HealthCheck {
+	pingPostgres := func(context.Context) error { return errors.New("postgres is not configured") }
+	if c.Clients.Postgres != nil {
+		pingPostgres = c.Clients.Postgres.Ping
+	}
+	checks := []handlers.HealthCheck{
+		{Name: "postgres", Required: true, Ping: pingPostgres},
+		{Name: "mongo", Required: c.Config.ReadyMongoRequired, Ping: c.MongoStatus.Check},
+		{Name: "redis", Required: c.Config.ReadyRedisRequired, Ping: c.RedisStatus.Check},
+	}
+	if c.Clients.Replica != nil {
+		// replica reads fall back to the primary, so a lost replica only degrades
+		checks = append(checks, handlers.HealthCheck{Name: "postgres_replica", Ping: c.Clients.Replica.Ping})
+	}
+	for _, breaker := range c.Breakers {
+		// an open circuit fails fast on its own endpoints; the rest of the API still serves
+		name := breaker.Name()
+		switch name {
+		case services.BreakerChat, services.BreakerASR, services.BreakerTTS:
+			name = "qiniu_" + name
+		}
+		checks = append(checks, handlers.HealthCheck{Name: name, Ping: breaker.Ping})
+	}
+	return checks
+}
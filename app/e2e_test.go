@@ -0,0 +1,471 @@
+package app_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/testsupport"
+)
+
+// TestEndToEnd drives the server through the harness: the chat, TTS and ASR happy paths,
+// the streamed chat and ASR WebSockets, how upstream failures reach the client, response
+// compression and revalidation, and persona evaluations with scripted replies and verdicts.
+// Every scenario runs against a fresh harness seeded with testsupport.ScenarioRole.
+func TestEndToEnd(t *testing.T) {
+	scenarios := []struct {
+		name string
+		run  func(t *testing.T, h *testsupport.Harness)
+	}{
+		{"chat replies with the upstream completion", chatHappyPath},
+		{"chat websocket streams the upstream deltas", chatStreamHappyPath},
+		{"tts returns the synthesized audio", ttsHappyPath},
+		{"asr transcribes an audio url", asrURLHappyPath},
+		{"asr transcribes inline pcm over the upstream websocket", asrInlineHappyPath},
+		{"asr websocket streams transcripts and closes with stats", asrStreamHappyPath},
+		{"chat upstream error is a 502 UPSTREAM_ERROR", chatUpstreamFailure},
+		{"tts rejected credential is a 401 TOKEN_INVALID", ttsCredentialFailure},
+		{"large role list is compressed and revalidates with its etag", roleListCompressed},
+		{"responses below the compression threshold go out as written", smallResponseUncompressed},
+		{"persona evaluation scores every probe with the judge's verdict", personaEvaluation},
+		{"persona evaluation dry run lists the probes without calling upstream", personaEvaluationDryRun},
+		{"persona evaluation reports an unreadable verdict per probe", personaEvaluationBadVerdict},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			h, err := testsupport.NewHarness(nil, testsupport.ScenarioRole)
+			if err != nil {
+				t.Fatalf("NewHarness: %v", err)
+			}
+			defer h.Close()
+			scenario.run(t, h)
+		})
+	}
+}
+
+// do sends a request through the harness, failing the test when it cannot be made.
+func do(t *testing.T, h *testsupport.Harness, method, path string, body any, header http.Header) *testsupport.Response {
+	t.Helper()
+	resp, err := h.Do(method, path, body, header)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp
+}
+
+// dial opens a WebSocket through the harness with a five second read deadline.
+func dial(t *testing.T, h *testsupport.Harness, path string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, err := h.Dial(path, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// decode reads resp as JSON into v after checking the status is 200.
+func decode(t *testing.T, resp *testsupport.Response, v any) {
+	t.Helper()
+	if resp.Status != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", resp.Status, truncate(resp.Body))
+	}
+	if err := resp.JSON(v); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+}
+
+func truncate(body []byte) string {
+	const limit = 300
+	if len(body) > limit {
+		return string(body[:limit]) + "..."
+	}
+	return string(body)
+}
+
+func chatRequest(message string) map[string]any {
+	return map[string]any{
+		"role_id":  testsupport.ScenarioRole.ID,
+		"messages": []map[string]string{{"role": "user", "content": message}},
+	}
+}
+
+func chatHappyPath(t *testing.T, h *testsupport.Harness) {
+	h.Qiniu.SetChatReplies("Patience is the answer.")
+	var body struct {
+		Reply struct {
+			Content string `json:"content"`
+		} `json:"reply"`
+		SystemPrompt string `json:"system_prompt"`
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/nlp/chat", chatRequest("What is the answer?"), nil), &body)
+	if body.Reply.Content != "Patience is the answer." {
+		t.Errorf("reply %q, want the scripted one", body.Reply.Content)
+	}
+	if !strings.Contains(body.SystemPrompt, testsupport.ScenarioRole.Name) {
+		t.Errorf("system prompt does not name the role: %q", body.SystemPrompt)
+	}
+
+	calls := h.Qiniu.Calls(testsupport.EndpointChat)
+	if len(calls) != 1 {
+		t.Fatalf("upstream got %d chat calls, want 1", len(calls))
+	}
+	if got := calls[0].Header.Get("Authorization"); got != "Bearer "+testsupport.HarnessQiniuKey {
+		t.Errorf("upstream authorization %q, want the server key", got)
+	}
+	if !bytes.Contains(calls[0].Body, []byte("What is the answer?")) {
+		t.Errorf("upstream request lacks the user message: %s", truncate(calls[0].Body))
+	}
+}
+
+func chatStreamHappyPath(t *testing.T, h *testsupport.Harness) {
+	h.Qiniu.SetChatReplies("one two three")
+	conn := dial(t, h, "/api/nlp/chat/ws", nil)
+	if err := conn.WriteJSON(chatRequest("Count to three.")); err != nil {
+		t.Fatal(err)
+	}
+
+	var streamed strings.Builder
+	var done map[string]any
+	for done == nil {
+		var event map[string]any
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("read chat event: %v", err)
+		}
+		switch event["type"] {
+		case "delta":
+			content, _ := event["content"].(string)
+			streamed.WriteString(content)
+		case "done":
+			done = event
+		default:
+			t.Fatalf("unexpected chat event %v", event)
+		}
+	}
+	if streamed.String() != "one two three" {
+		t.Errorf("streamed %q, want the scripted reply", streamed.String())
+	}
+	if reason, _ := done["finish_reason"].(string); reason != "stop" {
+		t.Errorf("finish_reason %q, want stop", reason)
+	}
+	if calls := h.Qiniu.Calls(testsupport.EndpointChat); len(calls) != 1 || !bytes.Contains(calls[0].Body, []byte(`"stream":true`)) {
+		t.Errorf("upstream was not asked to stream")
+	}
+}
+
+func ttsHappyPath(t *testing.T, h *testsupport.Harness) {
+	audio := []byte("scripted-audio-bytes")
+	h.Qiniu.SetAudio(audio)
+	var body struct {
+		Audio string `json:"audio"`
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/audio/tts", map[string]any{"text": "hello there", "voice_type": "fake_voice"}, nil), &body)
+	decoded, err := base64.StdEncoding.DecodeString(body.Audio)
+	if err != nil {
+		t.Fatalf("decode audio: %v", err)
+	}
+	if !bytes.Equal(decoded, audio) {
+		t.Errorf("audio %q, want the scripted bytes", decoded)
+	}
+	if calls := h.Qiniu.Calls(testsupport.EndpointTTS); len(calls) != 1 || !bytes.Contains(calls[0].Body, []byte("hello there")) {
+		t.Errorf("upstream did not get the text to synthesize")
+	}
+}
+
+func asrURLHappyPath(t *testing.T, h *testsupport.Harness) {
+	h.Qiniu.SetTranscript("the quick brown fox")
+	var body struct {
+		Text string `json:"text"`
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/audio/asr", map[string]any{"audio_url": "https://example.com/speech.mp3"}, nil), &body)
+	if body.Text != "the quick brown fox" {
+		t.Errorf("text %q, want the scripted transcript", body.Text)
+	}
+	if calls := h.Qiniu.Calls(testsupport.EndpointASR); len(calls) != 1 || !bytes.Contains(calls[0].Body, []byte("https://example.com/speech.mp3")) {
+		t.Errorf("upstream did not get the audio url")
+	}
+}
+
+func asrInlineHappyPath(t *testing.T, h *testsupport.Harness) {
+	h.Qiniu.SetTranscript("inline audio works")
+	// a second of 16 kHz 16-bit mono silence, sent upstream in ten chunks
+	pcm := make([]byte, 32000)
+	var body struct {
+		Text string `json:"text"`
+	}
+	decode(t, do(t, h, http.MethodPost, "/api/audio/asr", map[string]any{
+		"audio_base64": base64.StdEncoding.EncodeToString(pcm),
+		"format":       "pcm",
+	}, nil), &body)
+	if body.Text != "inline audio works" {
+		t.Errorf("text %q, want the scripted transcript", body.Text)
+	}
+	if calls := h.Qiniu.Calls(testsupport.EndpointASRStream); len(calls) != 1 {
+		t.Errorf("upstream got %d asr streams, want 1", len(calls))
+	}
+}
+
+func asrStreamHappyPath(t *testing.T, h *testsupport.Harness) {
+	h.Qiniu.SetTranscript("streaming speech recognized")
+	conn := dial(t, h, "/ws/audio/asr", nil)
+	if err := conn.WriteJSON(map[string]any{"type": "start", "sampleRate": 16000}); err != nil {
+		t.Fatal(err)
+	}
+	readEvent(t, conn, "ready")
+	chunk := make([]byte, 3200)
+	for range 3 {
+		if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.WriteJSON(map[string]any{"type": "stop"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var final string
+	for final == "" {
+		event := readEvent(t, conn, "transcript")
+		if isFinal, _ := event["is_final"].(bool); isFinal {
+			final, _ = event["text"].(string)
+		}
+	}
+	if final != "streaming speech recognized" {
+		t.Errorf("final transcript %q, want the scripted one", final)
+	}
+	stats, _ := readEvent(t, conn, "closed")["stats"].(map[string]any)
+	if chunks, _ := stats["audio_chunks"].(float64); chunks != 3 {
+		t.Errorf("closed event reports %v audio chunks, want 3", stats["audio_chunks"])
+	}
+}
+
+// readEvent reads events until one of type want arrives, failing on error events.
+func readEvent(t *testing.T, conn *websocket.Conn, want string) map[string]any {
+	t.Helper()
+	for {
+		var event map[string]any
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("waiting for %s event: %v", want, err)
+		}
+		switch event["type"] {
+		case want:
+			return event
+		case "error":
+			t.Fatalf("error event while waiting for %s: %v", want, event["error"])
+		}
+	}
+}
+
+func chatUpstreamFailure(t *testing.T, h *testsupport.Harness) {
+	h.Qiniu.Fail(testsupport.EndpointChat, testsupport.Failure{Status: http.StatusInternalServerError})
+	resp := do(t, h, http.MethodPost, "/api/nlp/chat", chatRequest("Hello?"), nil)
+	if resp.Status != http.StatusBadGateway || resp.ErrorCode() != "UPSTREAM_ERROR" {
+		t.Fatalf("got %d %s, want 502 UPSTREAM_ERROR: %s", resp.Status, resp.ErrorCode(), truncate(resp.Body))
+	}
+
+	// the failure was one-shot, so the next chat goes through
+	resp = do(t, h, http.MethodPost, "/api/nlp/chat", chatRequest("Hello again?"), nil)
+	if resp.Status != http.StatusOK {
+		t.Errorf("chat after the failure: status %d, want 200: %s", resp.Status, truncate(resp.Body))
+	}
+}
+
+func ttsCredentialFailure(t *testing.T, h *testsupport.Harness) {
+	h.Qiniu.Fail(testsupport.EndpointTTS, testsupport.Failure{Status: http.StatusUnauthorized, Body: `{"error":{"message":"invalid token"}}`})
+	resp := do(t, h, http.MethodPost, "/api/audio/tts", map[string]any{"text": "hello", "voice_type": "fake_voice"}, nil)
+	if resp.Status != http.StatusUnauthorized || resp.ErrorCode() != "TOKEN_INVALID" {
+		t.Errorf("got %d %s, want 401 TOKEN_INVALID: %s", resp.Status, resp.ErrorCode(), truncate(resp.Body))
+	}
+}
+
+func roleListCompressed(t *testing.T, h *testsupport.Harness) {
+	bio := strings.Repeat("A sage with a long and winding backstory. ", 40)
+	for i := range 10 {
+		role := models.Role{Name: fmt.Sprintf("Sage %d", i), Domain: "testing", Bio: bio, Languages: []string{"en"}}
+		if _, err := h.Roles.Create(context.Background(), role); err != nil {
+			t.Fatalf("seed role: %v", err)
+		}
+	}
+
+	resp := do(t, h, http.MethodGet, "/api/roles", nil, http.Header{"Accept-Encoding": {"gzip"}})
+	if resp.Status != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", resp.Status, truncate(resp.Body))
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding %q, want gzip", got)
+	}
+	if !strings.Contains(strings.Join(resp.Header.Values("Vary"), ","), "Accept-Encoding") {
+		t.Errorf("Vary %q does not name Accept-Encoding", resp.Header.Values("Vary"))
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(resp.Body))
+	if err != nil {
+		t.Fatalf("gzip body: %v", err)
+	}
+	plain, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("gzip body: %v", err)
+	}
+	if len(resp.Body) >= len(plain) {
+		t.Errorf("compressed body of %d bytes is not smaller than %d", len(resp.Body), len(plain))
+	}
+	if !bytes.Contains(plain, []byte("Sage 9")) {
+		t.Errorf("decompressed role list lacks the seeded roles: %s", truncate(plain))
+	}
+
+	etag := resp.Header.Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("ETag %q, want a weak one", etag)
+	}
+	resp = do(t, h, http.MethodGet, "/api/roles", nil, http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etag}})
+	if resp.Status != http.StatusNotModified || len(resp.Body) != 0 {
+		t.Errorf("revalidation got %d with %d bytes, want an empty 304", resp.Status, len(resp.Body))
+	}
+
+	// deflate serves the same content under the same tag
+	resp = do(t, h, http.MethodGet, "/api/roles", nil, http.Header{"Accept-Encoding": {"deflate"}})
+	if got := resp.Header.Get("Content-Encoding"); got != "deflate" {
+		t.Fatalf("Content-Encoding %q, want deflate", got)
+	}
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(resp.Body)))
+	if err != nil {
+		t.Fatalf("deflate body: %v", err)
+	}
+	if !bytes.Equal(inflated, plain) || resp.Header.Get("ETag") != etag {
+		t.Errorf("deflate response differs from the gzip one")
+	}
+}
+
+func smallResponseUncompressed(t *testing.T, h *testsupport.Harness) {
+	resp := do(t, h, http.MethodGet, "/health/live", nil, http.Header{"Accept-Encoding": {"gzip, deflate"}})
+	if len(resp.Body) >= h.Config.HTTPCompressMinBytes {
+		t.Fatalf("liveness body of %d bytes is not below the %d byte threshold", len(resp.Body), h.Config.HTTPCompressMinBytes)
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding %q on a %d byte body, want none", got, len(resp.Body))
+	}
+	var body map[string]any
+	decode(t, resp, &body)
+}
+
+// personaJudgeMatch picks out the judge calls of a persona evaluation: only the judge
+// prompt names the score fields.
+const personaJudgeMatch = "persona_consistency"
+
+var adminHeader = http.Header{"X-Admin-Token": {testsupport.HarnessAdminToken}}
+
+var evaluatePath = fmt.Sprintf("/api/admin/roles/%d/evaluate", testsupport.ScenarioRole.ID)
+
+type personaEvaluationBody struct {
+	Language string `json:"language"`
+	Probes   []struct {
+		Probe struct {
+			ID     string `json:"id"`
+			Kind   string `json:"kind"`
+			Prompt string `json:"prompt"`
+		} `json:"probe"`
+		Reply  string              `json:"reply"`
+		Scores *map[string]float64 `json:"scores"`
+		Error  string              `json:"error"`
+	} `json:"probes"`
+	Scores   map[string]float64 `json:"scores"`
+	Scored   int                `json:"scored"`
+	TimedOut bool               `json:"timed_out"`
+}
+
+func personaEvaluation(t *testing.T, h *testsupport.Harness) {
+	h.Qiniu.SetChatReplies("Greetings, I am the Test Sage.")
+	h.Qiniu.ReplyTo(personaJudgeMatch, "Verdict:\n```json\n"+
+		`{"persona_consistency": 4, "constraint_adherence": 7, "language_correctness": 5, "comment": "in character"}`+"\n```")
+	var evaluation personaEvaluationBody
+	decode(t, do(t, h, http.MethodPost, evaluatePath, nil, adminHeader), &evaluation)
+	if evaluation.Language != "en" || len(evaluation.Probes) != 4 {
+		t.Fatalf("got %d probes in %q, want the 4 default ones in the role's language", len(evaluation.Probes), evaluation.Language)
+	}
+	for _, probe := range evaluation.Probes {
+		if probe.Error != "" || probe.Scores == nil {
+			t.Errorf("probe %s was not scored: %s", probe.Probe.ID, probe.Error)
+			continue
+		}
+		if probe.Reply != "Greetings, I am the Test Sage." {
+			t.Errorf("probe %s transcript has reply %q, want the scripted one", probe.Probe.ID, probe.Reply)
+		}
+		// the out of range constraint score is clamped to 5
+		if scores := *probe.Scores; scores["persona_consistency"] != 4 || scores["constraint_adherence"] != 5 || scores["language_correctness"] != 5 {
+			t.Errorf("probe %s scores %v, want the clamped verdict", probe.Probe.ID, scores)
+		}
+	}
+	if evaluation.Scored != 4 || evaluation.TimedOut || evaluation.Scores["persona_consistency"] != 4 {
+		t.Errorf("summary scored %d (timed out %t) with averages %v", evaluation.Scored, evaluation.TimedOut, evaluation.Scores)
+	}
+
+	judged := 0
+	for _, call := range h.Qiniu.Calls(testsupport.EndpointChat) {
+		if bytes.Contains(call.Body, []byte(personaJudgeMatch)) {
+			judged++
+			if !bytes.Contains(call.Body, []byte("Greetings, I am the Test Sage.")) {
+				t.Errorf("judge call lacks the reply under review: %s", truncate(call.Body))
+			}
+		}
+	}
+	if calls := len(h.Qiniu.Calls(testsupport.EndpointChat)); calls != 8 || judged != 4 {
+		t.Errorf("upstream got %d chat calls with %d judgements, want 4 of each", calls, judged)
+	}
+}
+
+func personaEvaluationDryRun(t *testing.T, h *testsupport.Harness) {
+	type dryRun struct {
+		Language string `json:"language"`
+		Probes   []struct {
+			ID     string `json:"id"`
+			Kind   string `json:"kind"`
+			Prompt string `json:"prompt"`
+		} `json:"probes"`
+		DryRun bool `json:"dry_run"`
+	}
+
+	var listed dryRun
+	decode(t, do(t, h, http.MethodPost, evaluatePath+"?dry_run=1", nil, adminHeader), &listed)
+	if !listed.DryRun || listed.Language != "en" || len(listed.Probes) != 4 || listed.Probes[3].Kind != "off_topic" {
+		t.Errorf("default probes listed as %+v in %q", listed.Probes, listed.Language)
+	}
+
+	listed = dryRun{}
+	decode(t, do(t, h, http.MethodPost, evaluatePath+"?dry_run=1", map[string]any{
+		"language": "zh",
+		"probes":   []map[string]string{{"prompt": "Tell me a riddle."}},
+	}, adminHeader), &listed)
+	if listed.Language != "zh" || len(listed.Probes) != 1 || listed.Probes[0].ID != "probe_1" || listed.Probes[0].Kind != "custom" {
+		t.Errorf("custom probes listed as %+v in %q", listed.Probes, listed.Language)
+	}
+	if calls := h.Qiniu.Calls(testsupport.EndpointChat); len(calls) != 0 {
+		t.Errorf("dry runs made %d chat calls, want none", len(calls))
+	}
+}
+
+func personaEvaluationBadVerdict(t *testing.T, h *testsupport.Harness) {
+	h.Qiniu.ReplyTo(personaJudgeMatch, "I would rather not grade this.")
+	var evaluation personaEvaluationBody
+	decode(t, do(t, h, http.MethodPost, evaluatePath, map[string]any{
+		"probes": []map[string]string{{"id": "riddle", "prompt": "Tell me a riddle."}},
+	}, adminHeader), &evaluation)
+	if len(evaluation.Probes) != 1 {
+		t.Fatalf("got %d probes, want the one supplied", len(evaluation.Probes))
+	}
+	probe := evaluation.Probes[0]
+	if probe.Scores != nil || !strings.Contains(probe.Error, "judge failed") || probe.Reply == "" {
+		t.Errorf("probe kept scores %v, error %q and reply %q; want a judge error and the transcript", probe.Scores, probe.Error, probe.Reply)
+	}
+	if evaluation.Scored != 0 || evaluation.Scores != nil {
+		t.Errorf("summary scored %d with averages %v, want none", evaluation.Scored, evaluation.Scores)
+	}
+}
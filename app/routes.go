@@ -0,0 +1,158 @@
+package app
+
+import (
+	"expvar"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/auth"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/handlers"
+	"github.com/wuwenbin0122/wwb.ai/quota"
+	"github.com/wuwenbin0122/wwb.ai/storage"
+)
+
+// RegisterRoutes installs the middleware stack and every route of c on router.
+func RegisterRoutes(router *gin.Engine, c *Container) {
+	cfg, logger := c.Config, c.Logger
+
+	// Recovery runs innermost so the access log and metrics still record a panicking
+	// request as a 500 with its request ID.
+	router.Use(handlers.RequestID(), handlers.AccessLog(logger), c.Metrics.Middleware(), handlers.Recovery(logger, c.Metrics.RecordPanic))
+	router.GET("/metrics", c.Metrics.Handler(cfg.MetricsUsername, cfg.MetricsPassword))
+
+	router.Use(cors.New(cors.Config{
+		AllowOrigins:     []string{"*"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key", "X-Admin-Token", "X-Admin-Actor", ctxlog.Header},
+		ExposeHeaders:    []string{"Content-Length", ctxlog.Header},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}))
+
+	// JSON and text responses are compressed; small and streamed ones go out as written
+	router.Use(handlers.Compress(cfg.HTTPCompressMinBytes))
+
+	// registered after CORS so a 408 or 413 still carries the CORS headers
+	router.Use(handlers.BodyLimit(int64(cfg.MaxBodyBytes), handlers.RouteLimits[int64]{
+		"POST /api/audio/asr":        int64(cfg.UploadMaxBytes),
+		"POST /api/roles/import":     int64(cfg.UploadMaxBytes),
+		"POST /api/roles/:id/avatar": int64(cfg.AvatarMaxBytes) + 64<<10,
+	}))
+	longTimeout := time.Duration(cfg.LongHandlerTimeoutSeconds) * time.Second
+	router.Use(handlers.HandlerTimeout(time.Duration(cfg.HandlerTimeoutSeconds)*time.Second, handlers.RouteLimits[time.Duration]{
+		"POST /api/audio/asr":                longTimeout,
+		"POST /api/audio/tts":                longTimeout,
+		"POST /api/audio/tts/batch":          longTimeout,
+		"POST /api/nlp/chat":                 longTimeout,
+		"POST /api/voice/chat":               longTimeout,
+		"POST /api/admin/roles/:id/evaluate": longTimeout,
+	}))
+
+	authService := c.AuthService
+	router.Use(handlers.OptionalUser(authService), handlers.DebugPayloads(cfg))
+
+	// /health stays as an alias of the liveness probe for existing deployments
+	router.GET("/health", c.Health.Live)
+	router.GET("/health/live", c.Health.Live)
+	router.GET("/health/ready", c.Health.Ready)
+
+	router.POST("/api/auth/register", c.Auth.Register)
+	router.POST("/api/auth/login", c.Auth.Login)
+	router.POST("/api/auth/refresh", c.Auth.Refresh)
+	router.POST("/api/auth/logout", c.Auth.Logout)
+	// API keys authenticate as their owner but never carry the account scope, so account
+	// management needs an access token
+	scopeRead := handlers.RequireScope(auth.ScopeRead)
+	scopeWrite := handlers.RequireScope(auth.ScopeWrite)
+	scopeChat := handlers.RequireScope(auth.ScopeChat)
+	scopeAudio := handlers.RequireScope(auth.ScopeAudio)
+	account := router.Group("/api/auth", handlers.RequireUser(authService), handlers.RequireScope(auth.ScopeAccount))
+	account.GET("/me", c.Auth.Me)
+	account.PUT("/me", c.Auth.UpdateMe)
+	account.POST("/password", c.Auth.ChangePassword)
+	account.POST("/verify/request", c.Auth.RequestVerification)
+	account.GET("/apikeys", c.Auth.ListAPIKeys)
+	account.POST("/apikeys", c.Auth.CreateAPIKey)
+	account.DELETE("/apikeys/:id", c.Auth.RevokeAPIKey)
+	router.POST("/api/auth/verify/confirm", c.Auth.ConfirmVerification)
+
+	if _, local := c.Blobs.(*storage.LocalStore); local {
+		static := router.Group("/static", func(ctx *gin.Context) {
+			// blob keys are unique per upload, so the files never change
+			ctx.Header("Cache-Control", "public, max-age=604800, immutable")
+		})
+		static.Static("/avatars", filepath.Join(cfg.BlobDir, "avatars"))
+	}
+	router.GET("/api/roles", scopeRead, c.Roles.GetRoles)
+	router.GET("/api/roles/featured", scopeRead, c.Roles.GetFeaturedRoles)
+	router.GET("/api/roles/tags", scopeRead, c.Roles.GetRoleTags)
+	router.POST("/api/roles/recommend", scopeRead, c.Roles.RecommendRoles)
+	router.GET("/api/roles/export", handlers.RequireAdmin(cfg), c.Roles.ExportRoles)
+	router.POST("/api/roles/import", handlers.RequireAdmin(cfg), c.Roles.ImportRoles)
+	router.GET("/api/roles/:id", scopeRead, c.Roles.GetRole)
+	router.POST("/api/roles", handlers.RequireUserOrAdmin(cfg, authService), scopeWrite, c.Roles.CreateRole)
+	router.PUT("/api/roles/:id", handlers.RequireUserOrAdmin(cfg, authService), scopeWrite, c.Roles.UpdateRole)
+	router.DELETE("/api/roles/:id", handlers.RequireUserOrAdmin(cfg, authService), scopeWrite, c.Roles.DeleteRole)
+	router.POST("/api/roles/:id/avatar", handlers.RequireUserOrAdmin(cfg, authService), scopeWrite, c.Roles.UploadAvatar)
+	router.PUT("/api/roles/:id/featured", handlers.RequireAdmin(cfg), c.Roles.SetRoleFeatured)
+
+	// quotas are checked before the upstream call; the call that crosses a limit is still served
+	chatQuota := handlers.RequireQuota(c.Quotas, quota.MetricTokens)
+	ttsQuota := handlers.RequireQuota(c.Quotas, quota.MetricTTSCharacters)
+	asrQuota := handlers.RequireQuota(c.Quotas, quota.MetricASRMinutes)
+	voiceQuota := handlers.RequireQuota(c.Quotas, quota.MetricASRMinutes, quota.MetricTokens, quota.MetricTTSCharacters)
+	// streaming chats hold a slot until they end, so one caller cannot starve the rest
+	chatSlots := handlers.LimitConcurrentChats(cfg, c.ChatSlots)
+
+	router.POST("/api/nlp/chat", scopeChat, chatQuota, chatSlots, c.NLP.HandleChat)
+	router.GET("/api/nlp/chat/ws", handlers.AcceptTicket(authService, auth.ScopeChat), scopeChat, chatQuota, chatSlots, c.NLP.HandleChatWebsocket)
+	router.POST("/api/nlp/suggestions", scopeChat, chatQuota, c.NLP.HandleSuggestions)
+	router.POST("/api/nlp/validate", c.NLP.HandleValidate)
+
+	router.POST("/api/audio/token", handlers.RequireUser(authService), c.Auth.IssueTicket)
+	router.GET("/ws/audio/asr", handlers.AcceptTicket(authService, auth.ScopeAudio), scopeAudio, asrQuota, c.Audio.HandleASRWebsocket)
+	router.POST("/api/audio/asr", scopeAudio, asrQuota, c.Audio.HandleASR)
+	router.POST("/api/audio/uploads", handlers.RequireUser(authService), scopeAudio, c.AudioUploads.CreateUpload)
+	router.GET("/api/audio/asr/sessions", handlers.RequireUser(authService), scopeRead, c.Audio.HandleListASRSessions)
+	router.POST("/api/audio/tts", scopeAudio, ttsQuota, c.Audio.HandleTTS)
+	router.POST("/api/audio/tts/batch", scopeAudio, ttsQuota, c.TTSBatch.HandleBatch)
+	router.GET("/api/audio/tts/batch/:id", scopeRead, c.TTSBatch.GetBatch)
+	router.GET("/api/audio/voices", scopeRead, c.Audio.HandleVoiceList)
+	customVoices := router.Group("/api/audio/voices/custom", handlers.RequireAdmin(cfg))
+	customVoices.POST("", c.CustomVoices.CreateCustomVoice)
+	customVoices.GET("", c.CustomVoices.ListCustomVoices)
+	customVoices.GET("/:voice_type", c.CustomVoices.GetCustomVoice)
+	customVoices.DELETE("/:voice_type", c.CustomVoices.DeleteCustomVoice)
+
+	router.GET("/api/capabilities", c.Flags.GetCapabilities)
+	router.GET("/api/usage/me", handlers.RequireUser(authService), scopeRead, c.Usage.GetMyUsage)
+	router.GET("/api/memories", handlers.RequireUser(authService), scopeRead, c.Memories.ListMemories)
+	router.DELETE("/api/memories", handlers.RequireUser(authService), scopeWrite, c.Memories.DeleteMemories)
+	router.DELETE("/api/memories/:id", handlers.RequireUser(authService), scopeWrite, c.Memories.DeleteMemory)
+	// erasing everything is account management, so API keys cannot do it
+	router.DELETE("/api/me/data", handlers.RequireUser(authService), handlers.RequireScope(auth.ScopeAccount), c.Privacy.DeleteMyData)
+
+	router.POST("/api/voice/chat", scopeChat, handlers.RequireFeature(c.FlagService, "voice.chat"), voiceQuota, chatSlots, c.Voice.HandleVoiceChat)
+	router.GET("/api/voice/session", handlers.AcceptTicket(authService, auth.ScopeChat), handlers.RequireUser(authService), scopeChat, handlers.RequireFeature(c.FlagService, "voice.session"), voiceQuota, chatSlots, c.Voice.HandleVoiceSession)
+
+	admin := router.Group("/api/admin", handlers.RequireAdmin(cfg))
+	admin.GET("/flags", c.Flags.ListFlags)
+	admin.GET("/flags/audit", c.Flags.ListFlagAudit)
+	admin.PUT("/flags/:key", c.Flags.SetFlag)
+	admin.DELETE("/flags/:key", c.Flags.ClearFlag)
+	admin.POST("/roles/:id/enrich", c.Roles.EnrichRole)
+	admin.POST("/roles/:id/evaluate", c.Roles.EvaluateRole)
+	admin.POST("/reload", c.Roles.ReloadRoleCaches)
+	admin.GET("/usage", c.Usage.ListUsage)
+	admin.GET("/stats/overview", c.Stats.GetOverview)
+	admin.GET("/stats/roles", c.Stats.GetRoleStats)
+	admin.GET("/stats/latency", c.Stats.GetLatency)
+	admin.GET("/quotas/:user_id", c.Quota.GetQuota)
+	admin.PUT("/quotas/:user_id", c.Quota.SetQuota)
+	admin.DELETE("/quotas/:user_id", c.Quota.ClearQuota)
+	admin.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	admin.GET("/debug/requests", c.Debug.ListRequests)
+}
@@ -0,0 +1,172 @@
+package app_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/testsupport"
+)
+
+// guard is the authentication a route requires.
+type guard int
+
+const (
+	public      guard = iota // anyone, attaching the user when one is signed in
+	user                     // RequireUser
+	admin                    // RequireAdmin
+	userOrAdmin              // RequireUserOrAdmin
+)
+
+// routeGuards is every route the server registers, with the guard it must sit behind.
+var routeGuards = map[string]guard{
+	"GET /metrics":      public,
+	"GET /health":       public,
+	"GET /health/live":  public,
+	"GET /health/ready": public,
+
+	"POST /api/auth/register":       public,
+	"POST /api/auth/login":          public,
+	"POST /api/auth/refresh":        public,
+	"POST /api/auth/logout":         public,
+	"GET /api/auth/me":              user,
+	"PUT /api/auth/me":              user,
+	"POST /api/auth/password":       user,
+	"POST /api/auth/verify/request": user,
+	"GET /api/auth/apikeys":         user,
+	"POST /api/auth/apikeys":        user,
+	"DELETE /api/auth/apikeys/:id":  user,
+	"POST /api/auth/verify/confirm": public,
+
+	"GET /static/avatars/*filepath":  public,
+	"HEAD /static/avatars/*filepath": public,
+
+	"GET /api/roles":                     public,
+	"GET /api/roles/featured":            public,
+	"GET /api/roles/tags":                public,
+	"POST /api/roles/recommend":          public,
+	"GET /api/roles/export":              admin,
+	"POST /api/roles/import":             admin,
+	"GET /api/roles/:id":                 public,
+	"POST /api/roles":                    userOrAdmin,
+	"PUT /api/roles/:id":                 userOrAdmin,
+	"DELETE /api/roles/:id":              userOrAdmin,
+	"POST /api/roles/:id/avatar":         userOrAdmin,
+	"PUT /api/roles/:id/featured":        admin,
+	"POST /api/nlp/chat":                 public,
+	"GET /api/nlp/chat/ws":               public,
+	"POST /api/nlp/suggestions":          public,
+	"POST /api/nlp/validate":             public,
+	"POST /api/audio/token":              user,
+	"GET /ws/audio/asr":                  public,
+	"POST /api/audio/asr":                public,
+	"POST /api/audio/uploads":            user,
+	"GET /api/audio/asr/sessions":        user,
+	"POST /api/audio/tts":                public,
+	"POST /api/audio/tts/batch":          public,
+	"GET /api/audio/tts/batch/:id":       public,
+	"GET /api/audio/voices":              public,
+	"POST /api/audio/voices/custom":      admin,
+	"GET /api/audio/voices/custom":       admin,
+	"GET /api/capabilities":              public,
+	"GET /api/usage/me":                  user,
+	"GET /api/memories":                  user,
+	"DELETE /api/memories":               user,
+	"DELETE /api/memories/:id":           user,
+	"DELETE /api/me/data":                user,
+	"POST /api/voice/chat":               public,
+	"GET /api/voice/session":             user,
+	"GET /api/admin/flags":               admin,
+	"GET /api/admin/flags/audit":         admin,
+	"PUT /api/admin/flags/:key":          admin,
+	"DELETE /api/admin/flags/:key":       admin,
+	"POST /api/admin/roles/:id/enrich":   admin,
+	"POST /api/admin/roles/:id/evaluate": admin,
+	"POST /api/admin/reload":             admin,
+	"GET /api/admin/usage":               admin,
+	"GET /api/admin/stats/overview":      admin,
+	"GET /api/admin/stats/roles":         admin,
+	"GET /api/admin/stats/latency":       admin,
+	"GET /api/admin/quotas/:user_id":     admin,
+	"PUT /api/admin/quotas/:user_id":     admin,
+	"DELETE /api/admin/quotas/:user_id":  admin,
+	"GET /api/admin/debug/vars":          admin,
+	"GET /api/admin/debug/requests":      admin,
+
+	"GET /api/audio/voices/custom/:voice_type":    admin,
+	"DELETE /api/audio/voices/custom/:voice_type": admin,
+}
+
+// TestRouteGuards checks that every route is registered, and probes each one without
+// credentials and with the credential its guard accepts.
+func TestRouteGuards(t *testing.T) {
+	h, err := testsupport.NewHarness(func(cfg *config.Config) { cfg.JWTSecret = "route-test-secret" }, testsupport.ScenarioRole)
+	if err != nil {
+		t.Fatalf("NewHarness: %v", err)
+	}
+	defer h.Close()
+	token, _, err := h.Container.AuthService.IssueToken("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registered := make(map[string]bool, len(h.Routes))
+	for _, route := range h.Routes {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		if _, ok := routeGuards[key]; !ok {
+			t.Errorf("route %s is registered but has no expected guard", key)
+		}
+	}
+	for key := range routeGuards {
+		if !registered[key] {
+			t.Errorf("route %s is not registered", key)
+		}
+	}
+
+	for key, want := range routeGuards {
+		method, template, _ := strings.Cut(key, " ")
+		if method == http.MethodHead {
+			continue
+		}
+		path := strings.NewReplacer(":id", "1", ":key", "voice.chat", ":user_id", "42", ":voice_type", "v1", "*filepath", "missing.png").Replace(template)
+
+		t.Run(key, func(t *testing.T) {
+			anonymous, err := h.Do(method, path, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := anonymous.ErrorCode()
+			switch want {
+			case public:
+				if strings.HasPrefix(code, "ACCESS_TOKEN_") || strings.HasPrefix(code, "ADMIN_") {
+					t.Errorf("anonymous request = %d %s, want the public route to let it in", anonymous.Status, code)
+				}
+				return
+			case user, userOrAdmin:
+				if anonymous.Status != http.StatusUnauthorized || code != "ACCESS_TOKEN_MISSING" {
+					t.Errorf("anonymous request = %d %s, want 401 ACCESS_TOKEN_MISSING", anonymous.Status, code)
+				}
+			case admin:
+				if anonymous.Status != http.StatusUnauthorized || code != "ADMIN_TOKEN_INVALID" {
+					t.Errorf("anonymous request = %d %s, want 401 ADMIN_TOKEN_INVALID", anonymous.Status, code)
+				}
+			}
+
+			header := http.Header{}
+			if want == admin || want == userOrAdmin {
+				header.Set("X-Admin-Token", testsupport.HarnessAdminToken)
+			} else {
+				header.Set("Authorization", "Bearer "+token)
+			}
+			authorized, err := h.Do(method, path, nil, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code := authorized.ErrorCode(); strings.HasPrefix(code, "ACCESS_TOKEN_") || strings.HasPrefix(code, "ADMIN_") || code == "INSUFFICIENT_SCOPE" {
+				t.Errorf("authorized request = %d %s, want it past the guard", authorized.Status, code)
+			}
+		})
+	}
+}
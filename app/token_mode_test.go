@@ -0,0 +1,132 @@
+package app_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/testsupport"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
+)
+
+// tokenRoute is an endpoint calling Qiniu, with the upstream endpoints it reaches.
+type tokenRoute struct {
+	path      string
+	body      func() map[string]any
+	endpoints []string
+}
+
+var tokenRoutes = []tokenRoute{
+	{"/api/audio/tts", func() map[string]any {
+		return map[string]any{"text": "hello", "voice_type": "fake_voice"}
+	}, []string{testsupport.EndpointTTS}},
+	{"/api/nlp/chat", func() map[string]any {
+		return chatRequest("Hello?")
+	}, []string{testsupport.EndpointChat}},
+	{"/api/voice/chat", func() map[string]any {
+		return map[string]any{"role_id": testsupport.ScenarioRole.ID, "audio_url": "https://example.com/speech.mp3"}
+	}, []string{testsupport.EndpointASR, testsupport.EndpointChat, testsupport.EndpointTTS}},
+}
+
+// TestTokenModes calls the audio, chat and voice endpoints under each QINIU_TOKEN_MODE and
+// checks which credential reaches Qiniu, or that the request is refused before any call.
+func TestTokenModes(t *testing.T) {
+	type tokenCase struct {
+		name      string
+		bodyToken string
+		header    string
+		want      string // the upstream token, "" when the request is refused
+	}
+	modes := []struct {
+		mode      string
+		serverKey string
+		cases     []tokenCase
+	}{
+		{tokenresolver.ModeServer, testsupport.HarnessQiniuKey, []tokenCase{
+			{"no client token", "", "", testsupport.HarnessQiniuKey},
+			{"body token ignored", "body-token", "", testsupport.HarnessQiniuKey},
+			{"header token ignored", "", "Bearer header-token", testsupport.HarnessQiniuKey},
+		}},
+		{tokenresolver.ModeServer, "", []tokenCase{
+			{"no server key", "body-token", "", ""},
+		}},
+		{tokenresolver.ModeClient, testsupport.HarnessQiniuKey, []tokenCase{
+			{"no client token", "", "", ""},
+			{"body token", "body-token", "", "body-token"},
+			{"header token", "", "Bearer header-token", "header-token"},
+			{"body token wins over the header", "body-token", "Bearer header-token", "body-token"},
+		}},
+		{tokenresolver.ModeEither, testsupport.HarnessQiniuKey, []tokenCase{
+			{"no client token", "", "", testsupport.HarnessQiniuKey},
+			{"body token", "body-token", "", "body-token"},
+			{"header token", "", "Bearer header-token", "header-token"},
+		}},
+		{tokenresolver.ModeEither, "", []tokenCase{
+			{"no token at all", "", "", ""},
+		}},
+	}
+
+	for _, mode := range modes {
+		name := mode.mode
+		if mode.serverKey == "" {
+			name += " without a server key"
+		}
+		t.Run(name, func(t *testing.T) {
+			h, err := testsupport.NewHarness(func(cfg *config.Config) {
+				cfg.QiniuTokenMode = mode.mode
+				cfg.QiniuAPIKey = mode.serverKey
+			}, testsupport.ScenarioRole)
+			if err != nil {
+				t.Fatalf("NewHarness: %v", err)
+			}
+			defer h.Close()
+			h.Qiniu.SetTranscript("hello")
+			h.Qiniu.SetChatReplies("Hello back.")
+			h.Qiniu.SetAudio([]byte("speech"))
+
+			for _, route := range tokenRoutes {
+				for _, tc := range mode.cases {
+					t.Run(route.path+"/"+tc.name, func(t *testing.T) {
+						before := make(map[string]int, len(route.endpoints))
+						for _, endpoint := range route.endpoints {
+							before[endpoint] = len(h.Qiniu.Calls(endpoint))
+						}
+						body := route.body()
+						if tc.bodyToken != "" {
+							body["token"] = tc.bodyToken
+						}
+						var header http.Header
+						if tc.header != "" {
+							header = http.Header{"Authorization": {tc.header}}
+						}
+						resp := do(t, h, http.MethodPost, route.path, body, header)
+
+						if tc.want == "" {
+							if resp.Status != http.StatusBadRequest || resp.ErrorCode() != "TOKEN_MISSING" {
+								t.Errorf("got %d %s, want 400 TOKEN_MISSING: %s", resp.Status, resp.ErrorCode(), truncate(resp.Body))
+							}
+							for _, endpoint := range route.endpoints {
+								if n := len(h.Qiniu.Calls(endpoint)) - before[endpoint]; n != 0 {
+									t.Errorf("%d %s calls for a refused request", n, endpoint)
+								}
+							}
+							return
+						}
+						if resp.Status != http.StatusOK {
+							t.Fatalf("status %d, want 200: %s", resp.Status, truncate(resp.Body))
+						}
+						for _, endpoint := range route.endpoints {
+							calls := h.Qiniu.Calls(endpoint)[before[endpoint]:]
+							if len(calls) != 1 {
+								t.Fatalf("%d %s calls, want 1", len(calls), endpoint)
+							}
+							if got := calls[0].Header.Get("Authorization"); got != "Bearer "+tc.want {
+								t.Errorf("%s called with %q, want Bearer %s", endpoint, got, tc.want)
+							}
+						}
+					})
+				}
+			}
+		})
+	}
+}
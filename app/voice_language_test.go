@@ -0,0 +1,54 @@
+package app_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/wuwenbin0122/wwb.ai/testsupport"
+)
+
+// TestVoiceChatLanguage checks that /api/voice/chat negotiates the reply language as chat
+// does, rather than taking the role's first language, and reports where it came from.
+func TestVoiceChatLanguage(t *testing.T) {
+	h, err := testsupport.NewHarness(nil, testsupport.ScenarioRole)
+	if err != nil {
+		t.Fatalf("NewHarness: %v", err)
+	}
+	defer h.Close()
+	h.Qiniu.SetTranscript("hello")
+	h.Qiniu.SetChatReplies("Hello back.")
+	h.Qiniu.SetAudio([]byte("speech"))
+
+	cases := []struct {
+		name           string
+		language       string
+		acceptLanguage string
+		want           string
+		wantSource     string
+	}{
+		{"request language", "ja", "fr", "ja", "request"},
+		{"accept-language by q-value", "", "fr;q=0.5, zh-TW", "zh", "accept_language"},
+		{"unknown accept-language", "", "xx", "en", "role"},
+		{"role language", "", "", "en", "role"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var header http.Header
+			if tc.acceptLanguage != "" {
+				header = http.Header{"Accept-Language": {tc.acceptLanguage}}
+			}
+			var body struct {
+				Language       string `json:"language"`
+				LanguageSource string `json:"language_source"`
+			}
+			decode(t, do(t, h, http.MethodPost, "/api/voice/chat", map[string]any{
+				"role_id":   testsupport.ScenarioRole.ID,
+				"language":  tc.language,
+				"audio_url": "https://example.com/speech.mp3",
+			}, header), &body)
+			if body.Language != tc.want || body.LanguageSource != tc.wantSource {
+				t.Errorf("replied in %q from %s, want %q from %s", body.Language, body.LanguageSource, tc.want, tc.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package app_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wuwenbin0122/wwb.ai/testsupport"
+)
+
+// TestVoiceFailureMetrics fails voice turns at different stages and checks /metrics counts
+// each by stage and error code.
+func TestVoiceFailureMetrics(t *testing.T) {
+	h, err := testsupport.NewHarness(nil, testsupport.ScenarioRole)
+	if err != nil {
+		t.Fatalf("NewHarness: %v", err)
+	}
+	defer h.Close()
+	h.Qiniu.SetChatReplies("Hello back.")
+	h.Qiniu.SetAudio([]byte("speech"))
+	turn := map[string]any{"role_id": testsupport.ScenarioRole.ID, "audio_url": "https://example.com/speech.mp3"}
+
+	// silence fails the asr stage
+	h.Qiniu.SetTranscript("")
+	if resp := do(t, h, http.MethodPost, "/api/voice/chat", turn, nil); resp.Status == http.StatusOK {
+		t.Fatalf("silent turn succeeded: %s", truncate(resp.Body))
+	}
+	h.Qiniu.SetTranscript("hello")
+	h.Qiniu.Fail(testsupport.EndpointTTS, testsupport.Failure{Status: http.StatusUnauthorized, Body: `{"error":{"message":"invalid token"}}`})
+	if resp := do(t, h, http.MethodPost, "/api/voice/chat", turn, nil); resp.Status == http.StatusOK {
+		t.Fatalf("turn with a rejected tts token succeeded: %s", truncate(resp.Body))
+	}
+
+	resp := do(t, h, http.MethodGet, "/metrics", nil, nil)
+	if resp.Status != http.StatusOK {
+		t.Fatalf("scrape = %d, want 200", resp.Status)
+	}
+	for _, series := range []string{
+		`voice_pipeline_failures_total{code="NO_SPEECH",stage="asr"} 1`,
+		`voice_pipeline_failures_total{code="TOKEN_INVALID",stage="tts"} 1`,
+	} {
+		if !strings.Contains(string(resp.Body), series) {
+			t.Errorf("scrape lacks %s", series)
+		}
+	}
+	if strings.Contains(string(resp.Body), `stage="chat"`) {
+		t.Error("chat stage counted a failure, want none")
+	}
+}
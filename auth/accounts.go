@@ -0,0 +1,409 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/locale"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account errors. Refresh failures deliberately do not say whether a token existed.
+var (
+	ErrAccountsDisabled   = errors.New("accounts are not configured")
+	ErrInvalidAccount     = errors.New("invalid account details")
+	ErrUsernameTaken      = errors.New("username already exists")
+	ErrEmailTaken         = errors.New("email already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrWrongPassword      = errors.New("current password is incorrect")
+	ErrRefreshInvalid     = errors.New("refresh token invalid")
+	ErrRefreshExpired     = errors.New("refresh token expired")
+	// ErrRefreshReused means a rotated or revoked refresh token was presented again; the
+	// whole token family has been revoked and the user must sign in again.
+	ErrRefreshReused = errors.New("refresh token reused")
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores input past 72 bytes
+	maxPasswordBytes = 72
+	maxDeviceLength  = 200
+	maxEmailLength   = 254
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// dummyHash is compared against when a username is unknown, so failed logins take the
+// same time whether or not the account exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+// Device describes the client a refresh token was issued to.
+type Device struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+// Session is the token pair returned by Register, Login and Refresh.
+type Session struct {
+	User             *models.User `json:"user,omitempty"`
+	AccessToken      string       `json:"access_token"`
+	TokenType        string       `json:"token_type"`
+	ExpiresIn        int          `json:"expires_in"`
+	ExpiresAt        time.Time    `json:"expires_at"`
+	RefreshToken     string       `json:"refresh_token"`
+	RefreshExpiresAt time.Time    `json:"refresh_expires_at"`
+}
+
+// Register creates an account and signs it in.
+func (s *Service) Register(ctx context.Context, username, password string, device Device) (*Session, error) {
+	if s.users == nil {
+		return nil, ErrAccountsDisabled
+	}
+	username = strings.TrimSpace(username)
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.CreateUser(ctx, username, hash)
+	if err != nil {
+		return nil, accountWriteError("register", err)
+	}
+	return s.startSession(ctx, user, device)
+}
+
+// Profile returns the account of userID, the subject of an access token.
+func (s *Service) Profile(ctx context.Context, userID string) (*models.User, error) {
+	if s.users == nil {
+		return nil, ErrAccountsDisabled
+	}
+	return s.loadUser(ctx, userID)
+}
+
+// PreferredLanguage returns the reply language userID prefers, "" when the user has no
+// preference, does not exist or accounts are disabled. A nil service reports none.
+func (s *Service) PreferredLanguage(ctx context.Context, userID string) (string, error) {
+	if s == nil || s.users == nil || userID == "" {
+		return "", nil
+	}
+	user, err := s.loadUser(ctx, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return user.PreferredLanguage, nil
+}
+
+// ProfileUpdate lists the profile fields to change; nil fields are left alone and an
+// empty email removes it.
+type ProfileUpdate struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+	// PreferredLanguage is an ISO 639-1 code, region subtags allowed (zh-TW is stored as
+	// zh); "" removes the preference.
+	PreferredLanguage *string `json:"preferred_language"`
+}
+
+// UpdateProfile applies update to the account of userID.
+func (s *Service) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) (*models.User, error) {
+	if s.users == nil {
+		return nil, ErrAccountsDisabled
+	}
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	username, email, language := user.Username, user.Email, user.PreferredLanguage
+	if update.Username != nil {
+		username = strings.TrimSpace(*update.Username)
+		if err := validateUsername(username); err != nil {
+			return nil, err
+		}
+	}
+	if update.Email != nil {
+		email = strings.TrimSpace(*update.Email)
+		if err := validateEmail(email); err != nil {
+			return nil, err
+		}
+	}
+
+	if update.PreferredLanguage != nil {
+		language = ""
+		if strings.TrimSpace(*update.PreferredLanguage) != "" {
+			base, ok := locale.Normalize(*update.PreferredLanguage)
+			if !ok {
+				return nil, fmt.Errorf("%w: preferred_language must be a known ISO 639-1 code", ErrInvalidAccount)
+			}
+			language = base
+		}
+	}
+
+	updated, err := s.users.UpdateUserProfile(ctx, user.ID, username, email, language)
+	if err != nil {
+		return nil, accountWriteError("update profile", err)
+	}
+	return updated, nil
+}
+
+// ChangePassword replaces the password of userID after checking the current one. Every
+// refresh token of the user is revoked, so other devices must sign in again; the returned
+// session replaces the one of the calling device.
+func (s *Service) ChangePassword(ctx context.Context, userID, current, next string, device Device) (*Session, error) {
+	if s.users == nil {
+		return nil, ErrAccountsDisabled
+	}
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)); err != nil {
+		return nil, ErrWrongPassword
+	}
+	hash, err := hashPassword(next)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.UpdatePasswordHash(ctx, user.ID, hash); err != nil {
+		return nil, fmt.Errorf("change password: %w", err)
+	}
+	return s.startSession(ctx, user, device)
+}
+
+func (s *Service) loadUser(ctx context.Context, userID string) (*models.User, error) {
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil || id <= 0 {
+		return nil, ErrUserNotFound
+	}
+	user, err := s.users.GetUserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("load user: %w", err)
+	}
+	return user, nil
+}
+
+func validateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("%w: username must be 3-32 letters, digits, '.', '_' or '-'", ErrInvalidAccount)
+	}
+	return nil
+}
+
+// validateEmail accepts "" (no email) or a bare address such as a@example.com.
+func validateEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > maxEmailLength {
+		return fmt.Errorf("%w: email must be a valid address", ErrInvalidAccount)
+	}
+	return nil
+}
+
+// hashPassword enforces the password rule and returns the bcrypt hash.
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || len(password) > maxPasswordBytes {
+		return "", fmt.Errorf("%w: password must be %d-%d characters", ErrInvalidAccount, minPasswordLength, maxPasswordBytes)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func accountWriteError(op string, err error) error {
+	switch {
+	case errors.Is(err, db.ErrUsernameTaken):
+		return ErrUsernameTaken
+	case errors.Is(err, db.ErrEmailTaken):
+		return ErrEmailTaken
+	case errors.Is(err, pgx.ErrNoRows):
+		return ErrUserNotFound
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// Login checks a username and password and starts a new token family.
+func (s *Service) Login(ctx context.Context, username, password string, device Device) (*Session, error) {
+	if s.users == nil {
+		return nil, ErrAccountsDisabled
+	}
+	user, err := s.users.GetUserByUsername(ctx, strings.TrimSpace(username))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("login: %w", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return s.startSession(ctx, user, device)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token. The
+// presented token stops working; presenting it again revokes its whole family.
+func (s *Service) Refresh(ctx context.Context, refreshToken string, device Device) (*Session, error) {
+	if s.users == nil {
+		return nil, ErrAccountsDisabled
+	}
+	stored, err := s.lookupRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if stored.ReplacedAt != nil {
+		return nil, s.revokeReused(ctx, stored)
+	}
+	if stored.RevokedAt != nil {
+		return nil, ErrRefreshInvalid
+	}
+	if !s.now().Before(stored.ExpiresAt) {
+		return nil, ErrRefreshExpired
+	}
+
+	next, raw, err := s.newRefreshToken(stored.UserID, stored.FamilyID, device)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.ReplaceRefreshToken(ctx, stored.ID, next); err != nil {
+		if errors.Is(err, db.ErrRefreshTokenSpent) {
+			// lost a race with another refresh of the same token: a replay as well
+			return nil, s.revokeReused(ctx, stored)
+		}
+		return nil, fmt.Errorf("refresh: %w", err)
+	}
+	return s.session(nil, stored.UserID, raw, next.ExpiresAt)
+}
+
+// Logout revokes the family of refreshToken. Unknown or already revoked tokens are not an
+// error, so logging out twice succeeds.
+func (s *Service) Logout(ctx context.Context, refreshToken string) error {
+	if s.users == nil {
+		return ErrAccountsDisabled
+	}
+	stored, err := s.lookupRefreshToken(ctx, refreshToken)
+	if errors.Is(err, ErrRefreshInvalid) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.users.RevokeRefreshFamily(ctx, stored.FamilyID); err != nil {
+		return fmt.Errorf("logout: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) lookupRefreshToken(ctx context.Context, refreshToken string) (*models.RefreshToken, error) {
+	refreshToken = strings.TrimSpace(refreshToken)
+	if refreshToken == "" {
+		return nil, ErrRefreshInvalid
+	}
+	stored, err := s.users.GetRefreshToken(ctx, hashRefreshToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRefreshInvalid
+		}
+		return nil, fmt.Errorf("refresh token lookup: %w", err)
+	}
+	return stored, nil
+}
+
+func (s *Service) revokeReused(ctx context.Context, stored *models.RefreshToken) error {
+	if err := s.users.RevokeRefreshFamily(ctx, stored.FamilyID); err != nil {
+		return fmt.Errorf("revoke reused refresh token family: %w", err)
+	}
+	return ErrRefreshReused
+}
+
+func (s *Service) startSession(ctx context.Context, user *models.User, device Device) (*Session, error) {
+	family, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	token, raw, err := s.newRefreshToken(user.ID, family, device)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.CreateRefreshToken(ctx, token); err != nil {
+		return nil, fmt.Errorf("start session: %w", err)
+	}
+	return s.session(user, user.ID, raw, token.ExpiresAt)
+}
+
+func (s *Service) session(user *models.User, userID int64, refreshToken string, refreshExpires time.Time) (*Session, error) {
+	access, expires, err := s.IssueToken(strconv.FormatInt(userID, 10))
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		User:             user,
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(s.ttl / time.Second),
+		ExpiresAt:        expires,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpires,
+	}, nil
+}
+
+func (s *Service) newRefreshToken(userID int64, family string, device Device) (models.RefreshToken, string, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return models.RefreshToken{}, "", err
+	}
+	return models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  family,
+		TokenHash: hashRefreshToken(raw),
+		Device:    truncate(device.Name, maxDeviceLength),
+		UserAgent: truncate(device.UserAgent, maxDeviceLength),
+		IP:        device.IP,
+		ExpiresAt: s.now().Add(s.refreshTTL),
+	}, raw, nil
+}
+
+func hashRefreshToken(raw string) []byte {
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:]
+}
+
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func truncate(value string, limit int) string {
+	value = strings.TrimSpace(value)
+	if len(value) <= limit {
+		return value
+	}
+	return strings.ToValidUTF8(value[:limit], "")
+}
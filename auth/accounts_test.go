@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/db"
+)
+
+// fakeClock is a settable time source for Service.now.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestService returns an account service over an in-memory store, driven by the clock.
+func newTestService(t *testing.T) (*Service, *db.MemoryUserStore, *fakeClock) {
+	t.Helper()
+	users := db.NewMemoryUserStore()
+	svc := NewService("test-secret", time.Minute, time.Hour, users)
+	clock := &fakeClock{now: time.Now()}
+	svc.now = clock.Now
+	return svc, users, clock
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t)
+	first, err := svc.Register(ctx, "alice", "correct horse", Device{Name: "laptop"})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	second, err := svc.Refresh(ctx, first.RefreshToken, Device{Name: "laptop"})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("Refresh returned the presented refresh token")
+	}
+	claims, err := svc.VerifyToken(second.AccessToken)
+	if err != nil || claims.UserID() != "1" {
+		t.Fatalf("refreshed access token = %v, %v; want a token for user 1", claims, err)
+	}
+	if _, err := svc.Refresh(ctx, second.RefreshToken, Device{}); err != nil {
+		t.Errorf("rotated token does not refresh: %v", err)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t)
+	laptop, err := svc.Register(ctx, "alice", "correct horse", Device{Name: "laptop"})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	phone, err := svc.Login(ctx, "alice", "correct horse", Device{Name: "phone"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	rotated, err := svc.Refresh(ctx, laptop.RefreshToken, Device{})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	// a stolen copy of the spent token is replayed
+	if _, err := svc.Refresh(ctx, laptop.RefreshToken, Device{}); !errors.Is(err, ErrRefreshReused) {
+		t.Fatalf("replayed token = %v, want ErrRefreshReused", err)
+	}
+	if _, err := svc.Refresh(ctx, rotated.RefreshToken, Device{}); !errors.Is(err, ErrRefreshReused) && !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("latest token of the replayed family = %v, want it revoked", err)
+	}
+	// other sign-ins are separate families and keep working
+	if _, err := svc.Refresh(ctx, phone.RefreshToken, Device{}); err != nil {
+		t.Errorf("token of another family = %v, want it to refresh", err)
+	}
+}
+
+func TestRefreshConcurrentRotationCountsAsReuse(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t)
+	session, err := svc.Register(ctx, "alice", "correct horse", Device{})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	const callers = 8
+	results := make(chan error, callers)
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.Refresh(ctx, session.RefreshToken, Device{})
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrRefreshReused):
+			t.Errorf("Refresh = %v, want success or ErrRefreshReused", err)
+		}
+	}
+	if succeeded > 1 {
+		t.Errorf("%d concurrent refreshes of one token succeeded, want at most 1", succeeded)
+	}
+}
+
+func TestRefreshExpiry(t *testing.T) {
+	ctx := context.Background()
+	svc, _, clock := newTestService(t)
+	session, err := svc.Register(ctx, "alice", "correct horse", Device{})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, err := svc.VerifyToken(session.AccessToken); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("access token after its TTL = %v, want ErrTokenExpired", err)
+	}
+	refreshed, err := svc.Refresh(ctx, session.RefreshToken, Device{})
+	if err != nil {
+		t.Fatalf("Refresh within the refresh TTL: %v", err)
+	}
+	if _, err := svc.VerifyToken(refreshed.AccessToken); err != nil {
+		t.Errorf("refreshed access token: %v", err)
+	}
+
+	clock.Advance(time.Hour)
+	if _, err := svc.Refresh(ctx, refreshed.RefreshToken, Device{}); !errors.Is(err, ErrRefreshExpired) {
+		t.Errorf("refresh token after its TTL = %v, want ErrRefreshExpired", err)
+	}
+}
+
+func TestRefreshInvalidAndLogout(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t)
+	session, err := svc.Register(ctx, "alice", "correct horse", Device{})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	for _, raw := range []string{"", "  ", "never-issued"} {
+		if _, err := svc.Refresh(ctx, raw, Device{}); !errors.Is(err, ErrRefreshInvalid) {
+			t.Errorf("Refresh(%q) = %v, want ErrRefreshInvalid", raw, err)
+		}
+	}
+
+	if err := svc.Logout(ctx, session.RefreshToken); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if err := svc.Logout(ctx, session.RefreshToken); err != nil {
+		t.Errorf("second Logout = %v, want nil", err)
+	}
+	if _, err := svc.Refresh(ctx, session.RefreshToken, Device{}); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("Refresh after Logout = %v, want ErrRefreshInvalid", err)
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t)
+	laptop, err := svc.Register(ctx, "alice", "correct horse", Device{Name: "laptop"})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if _, err := svc.ChangePassword(ctx, "1", "wrong password", "battery staple", Device{}); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("ChangePassword with a wrong current password = %v, want ErrWrongPassword", err)
+	}
+	phone, err := svc.ChangePassword(ctx, "1", "correct horse", "battery staple", Device{Name: "phone"})
+	if err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if _, err := svc.Refresh(ctx, laptop.RefreshToken, Device{}); !errors.Is(err, ErrRefreshInvalid) {
+		t.Errorf("token issued before the change = %v, want ErrRefreshInvalid", err)
+	}
+	if _, err := svc.Refresh(ctx, phone.RefreshToken, Device{}); err != nil {
+		t.Errorf("token issued by the change = %v, want it to refresh", err)
+	}
+	if _, err := svc.Login(ctx, "alice", "correct horse", Device{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login with the old password = %v, want ErrInvalidCredentials", err)
+	}
+}
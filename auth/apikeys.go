@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// API key scopes. Access tokens carry every scope; an API key only the ones it was
+// created with.
+const (
+	// ScopeRead covers reading roles, tags and voices, including the owner's private roles.
+	ScopeRead = "read"
+	// ScopeWrite covers creating, editing and deleting the owner's roles.
+	ScopeWrite = "write"
+	// ScopeChat covers text and voice chat, which spend LLM quota.
+	ScopeChat = "chat"
+	// ScopeAudio covers TTS and ASR calls, which spend speech quota.
+	ScopeAudio = "audio"
+	// ScopeAccount covers managing the account itself (profile, password, API keys). It
+	// cannot be granted to an API key, so those routes need an access token.
+	ScopeAccount = "account"
+)
+
+// APIKeyScopes lists the scopes an API key may be created with.
+var APIKeyScopes = []string{ScopeRead, ScopeWrite, ScopeChat, ScopeAudio}
+
+// API key errors.
+var (
+	ErrAPIKeyInvalid  = errors.New("api key invalid")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+const (
+	apiKeyPrefix      = "wwb_"
+	apiKeyShownPrefix = 12
+	maxAPIKeyLabel    = 100
+	// apiKeyTouchInterval limits last_used_at writes for busy keys.
+	apiKeyTouchInterval = time.Minute
+)
+
+// CreatedAPIKey is returned once when a key is created; Key is never shown again.
+type CreatedAPIKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey issues a key for userID limited to scopes (read only when empty).
+func (s *Service) CreateAPIKey(ctx context.Context, userID, label string, scopes []string) (*CreatedAPIKey, error) {
+	if s.users == nil {
+		return nil, ErrAccountsDisabled
+	}
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	scopes, err = normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	raw := apiKeyPrefix + secret
+	key, err := s.users.CreateAPIKey(ctx, models.APIKey{
+		UserID:  user.ID,
+		Label:   truncate(label, maxAPIKeyLabel),
+		Prefix:  raw[:apiKeyShownPrefix],
+		KeyHash: hashRefreshToken(raw),
+		Scopes:  scopes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create api key: %w", err)
+	}
+	return &CreatedAPIKey{APIKey: *key, Key: raw}, nil
+}
+
+// ListAPIKeys returns the live keys of userID without their secrets.
+func (s *Service) ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	if s.users == nil {
+		return nil, ErrAccountsDisabled
+	}
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.users.ListAPIKeys(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes key keyID of userID; requests with it fail from then on.
+func (s *Service) RevokeAPIKey(ctx context.Context, userID string, keyID int64) error {
+	if s.users == nil {
+		return ErrAccountsDisabled
+	}
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.users.RevokeAPIKey(ctx, user.ID, keyID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrAPIKeyNotFound
+		}
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+	return nil
+}
+
+// AuthenticateAPIKey resolves a presented key to its owner (as a user ID string, like
+// Claims.UserID) and scopes.
+func (s *Service) AuthenticateAPIKey(ctx context.Context, raw string) (string, []string, error) {
+	if s.users == nil {
+		return "", nil, ErrAccountsDisabled
+	}
+	raw = strings.TrimSpace(raw)
+	if !strings.HasPrefix(raw, apiKeyPrefix) {
+		return "", nil, ErrAPIKeyInvalid
+	}
+	key, err := s.users.GetAPIKey(ctx, hashRefreshToken(raw))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil, ErrAPIKeyInvalid
+		}
+		return "", nil, fmt.Errorf("authenticate api key: %w", err)
+	}
+	if key.RevokedAt != nil {
+		return "", nil, ErrAPIKeyInvalid
+	}
+
+	now := s.now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		// best effort: a failed touch must not fail the request
+		_ = s.users.TouchAPIKey(ctx, key.ID, now)
+	}
+	return strconv.FormatInt(key.UserID, 10), key.Scopes, nil
+}
+
+// HasScope reports whether scopes grant scope.
+func HasScope(scopes []string, scope string) bool {
+	for _, granted := range scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return []string{ScopeRead}, nil
+	}
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !HasScope(APIKeyScopes, scope) {
+			return nil, fmt.Errorf("%w: unknown scope %q, expected one of %s", ErrInvalidAccount, scope, strings.Join(APIKeyScopes, ", "))
+		}
+		if !HasScope(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t)
+	if _, err := svc.Register(ctx, "alice", "correct horse", Device{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		scopes []string
+		want   []string
+		err    error
+	}{
+		{"default is read only", nil, []string{ScopeRead}, nil},
+		{"normalized and deduplicated", []string{" Chat", "read", "CHAT"}, []string{ScopeChat, ScopeRead}, nil},
+		{"every grantable scope", APIKeyScopes, APIKeyScopes, nil},
+		{"account cannot be granted", []string{ScopeRead, ScopeAccount}, nil, ErrInvalidAccount},
+		{"unknown scope", []string{"admin"}, nil, ErrInvalidAccount},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			created, err := svc.CreateAPIKey(ctx, "1", "ci", tc.scopes)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("CreateAPIKey = %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateAPIKey: %v", err)
+			}
+			if !strings.HasPrefix(created.Key, created.Prefix) || !strings.HasPrefix(created.Key, "wwb_") {
+				t.Errorf("key %q does not start with its shown prefix %q", created.Key, created.Prefix)
+			}
+
+			userID, scopes, err := svc.AuthenticateAPIKey(ctx, created.Key)
+			if err != nil {
+				t.Fatalf("AuthenticateAPIKey: %v", err)
+			}
+			if userID != "1" || !reflect.DeepEqual(scopes, tc.want) {
+				t.Errorf("key authenticates as %q with %v, want 1 with %v", userID, scopes, tc.want)
+			}
+			if HasScope(scopes, ScopeAccount) {
+				t.Error("an API key carries the account scope")
+			}
+		})
+	}
+}
+
+func TestAPIKeyRevocation(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t)
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := svc.Register(ctx, name, "correct horse", Device{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revoked, err := svc.CreateAPIKey(ctx, "1", "old", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := svc.CreateAPIKey(ctx, "1", "new", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.RevokeAPIKey(ctx, "2", revoked.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("revoking another user's key = %v, want ErrAPIKeyNotFound", err)
+	}
+	if _, _, err := svc.AuthenticateAPIKey(ctx, revoked.Key); err != nil {
+		t.Fatalf("key revoked by another user stopped working: %v", err)
+	}
+
+	if err := svc.RevokeAPIKey(ctx, "1", revoked.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, _, err := svc.AuthenticateAPIKey(ctx, revoked.Key); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("revoked key = %v, want ErrAPIKeyInvalid", err)
+	}
+	if _, _, err := svc.AuthenticateAPIKey(ctx, kept.Key); err != nil {
+		t.Errorf("other key of the user = %v, want it to keep working", err)
+	}
+	if err := svc.RevokeAPIKey(ctx, "1", 999); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("revoking an unknown key = %v, want ErrAPIKeyNotFound", err)
+	}
+
+	keys, err := svc.ListAPIKeys(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].ID != kept.ID {
+		t.Errorf("ListAPIKeys = %+v, want only the live key", keys)
+	}
+
+	for _, raw := range []string{"", "not-a-key", "wwb_unknown", strings.TrimPrefix(kept.Key, "wwb_")} {
+		if _, _, err := svc.AuthenticateAPIKey(ctx, raw); !errors.Is(err, ErrAPIKeyInvalid) {
+			t.Errorf("AuthenticateAPIKey(%q) = %v, want ErrAPIKeyInvalid", raw, err)
+		}
+	}
+}
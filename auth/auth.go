@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"github.com/wuwenbin0122/wwb.ai/mailer"
+)
+
+const issuer = "wwb.ai"
+
+// Token verification failures. Each maps to a machine-readable code via ErrorCode.
+var (
+	ErrTokenMissing   = errors.New("access token missing")
+	ErrTokenMalformed = errors.New("access token malformed")
+	ErrTokenExpired   = errors.New("access token expired")
+	ErrTokenInvalid   = errors.New("access token invalid")
+)
+
+// ErrorCode returns the code sent to clients for a verification error, so they can tell a
+// token that should be refreshed from one that should be discarded.
+func ErrorCode(err error) apierr.Code {
+	switch {
+	case errors.Is(err, ErrTokenMissing):
+		return apierr.CodeAccessTokenMissing
+	case errors.Is(err, ErrTokenMalformed):
+		return apierr.CodeAccessTokenMalformed
+	case errors.Is(err, ErrTokenExpired):
+		return apierr.CodeAccessTokenExpired
+	default:
+		return apierr.CodeAccessTokenInvalid
+	}
+}
+
+// Claims are the JWT claims of an access token; the subject is the user ID.
+type Claims struct {
+	jwt.RegisteredClaims
+}
+
+// UserID returns the user the token was issued to.
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+// Default token lifetimes used when NewService is given a non-positive one.
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// Service issues and verifies HS256 access tokens and, given a user store, manages
+// accounts and their rotating refresh tokens.
+type Service struct {
+	secret     []byte
+	ttl        time.Duration
+	refreshTTL time.Duration
+	users      db.UserStore
+	now        func() time.Time
+
+	// set by EnableEmailVerification
+	mailer    mailer.Mailer
+	verifyURL string
+	verifyTTL time.Duration
+
+	// set by EnableTickets
+	tickets *kv.Store
+}
+
+// NewService returns a service signing with secret, or nil when secret is empty so callers
+// can treat authentication as disabled. users may be nil when only access tokens are
+// needed; the account methods then return ErrAccountsDisabled.
+func NewService(secret string, accessTTL, refreshTTL time.Duration, users db.UserStore) *Service {
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return nil
+	}
+	if accessTTL <= 0 {
+		accessTTL = DefaultAccessTTL
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTTL
+	}
+	return &Service{secret: []byte(secret), ttl: accessTTL, refreshTTL: refreshTTL, users: users, now: time.Now}
+}
+
+// IssueToken signs an access token for userID and returns it with its expiry.
+func (s *Service) IssueToken(userID string) (string, time.Time, error) {
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return "", time.Time{}, errors.New("issue token: user id is required")
+	}
+	now := s.now()
+	expires := now.Add(s.ttl)
+	claims := Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Subject:   userID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expires),
+	}}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("issue token: %w", err)
+	}
+	return signed, expires, nil
+}
+
+// VerifyToken checks the signature, algorithm, issuer and validity window of token and
+// returns its claims. Errors wrap one of the ErrToken* sentinels.
+func (s *Service) VerifyToken(token string) (*Claims, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrTokenMissing
+	}
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(s.now),
+	)
+	switch {
+	case err == nil:
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return nil, fmt.Errorf("%w: %v", ErrTokenMalformed, err)
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, fmt.Errorf("%w: %v", ErrTokenExpired, err)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrTokenInvalid)
+	}
+	return &claims, nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// TicketTTL is how long a WebSocket ticket stays redeemable.
+const TicketTTL = 60 * time.Second
+
+// TicketScopes lists the scopes a ticket may carry: those of the WebSocket routes.
+var TicketScopes = []string{ScopeAudio, ScopeChat}
+
+// Ticket errors.
+var (
+	ErrTicketsDisabled = errors.New("tickets are not configured")
+	ErrTicketInvalid   = errors.New("ticket invalid")
+	ErrTicketExpired   = errors.New("ticket expired")
+	ErrTicketUsed      = errors.New("ticket already used")
+	// ErrTicketScope covers a ticket presented to a route outside its scopes.
+	ErrTicketScope = errors.New("ticket lacks the required scope")
+)
+
+// Ticket is the payload of a WebSocket ticket.
+type Ticket struct {
+	ID      string   `json:"jti"`
+	UserID  string   `json:"sub"`
+	Scopes  []string `json:"scopes"`
+	Expires int64    `json:"exp"`
+}
+
+// EnableTickets turns on WebSocket tickets; store records redeemed tickets so each can
+// be used once, across replicas.
+func (s *Service) EnableTickets(store *kv.Store) {
+	s.tickets = store
+}
+
+// IssueTicket signs a single-use ticket for userID limited to scopes, a subset of
+// TicketScopes, and returns it with its expiry. The ticket is its payload and HMAC,
+// both base64url-encoded and joined by a dot.
+func (s *Service) IssueTicket(userID string, scopes []string) (string, time.Time, error) {
+	if s.tickets == nil {
+		return "", time.Time{}, ErrTicketsDisabled
+	}
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return "", time.Time{}, errors.New("issue ticket: user id is required")
+	}
+	for _, scope := range scopes {
+		if !HasScope(TicketScopes, scope) {
+			return "", time.Time{}, fmt.Errorf("%w: %q", ErrTicketScope, scope)
+		}
+	}
+	id, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("issue ticket: %w", err)
+	}
+
+	expires := s.now().Add(TicketTTL)
+	payload, err := json.Marshal(Ticket{ID: id, UserID: userID, Scopes: scopes, Expires: expires.Unix()})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("issue ticket: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.signTicket(encoded)), expires, nil
+}
+
+// RedeemTicket verifies raw, checks that it carries scope and consumes it. Only the
+// first redemption of a ticket succeeds; a ticket presented with the wrong scope is not
+// consumed.
+func (s *Service) RedeemTicket(ctx context.Context, raw, scope string) (*Ticket, error) {
+	if s.tickets == nil {
+		return nil, ErrTicketsDisabled
+	}
+	encoded, signature, ok := strings.Cut(strings.TrimSpace(raw), ".")
+	if !ok {
+		return nil, ErrTicketInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.signTicket(encoded)) {
+		return nil, ErrTicketInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrTicketInvalid
+	}
+	var ticket Ticket
+	if err := json.Unmarshal(payload, &ticket); err != nil || ticket.ID == "" || ticket.UserID == "" {
+		return nil, ErrTicketInvalid
+	}
+
+	remaining := time.Unix(ticket.Expires, 0).Sub(s.now())
+	if remaining <= 0 {
+		return nil, ErrTicketExpired
+	}
+	if !HasScope(ticket.Scopes, scope) {
+		return nil, ErrTicketScope
+	}
+	if !s.tickets.Available() {
+		// without the marker a ticket could be replayed, so fail closed
+		return nil, fmt.Errorf("redeem ticket: %w", kv.ErrUnavailable)
+	}
+	// the marker only has to outlive the ticket itself
+	fresh, err := s.tickets.Client().SetNX(ctx, s.tickets.Key(kv.Tickets, ticket.ID), ticket.UserID, remaining).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redeem ticket: %w", err)
+	}
+	if !fresh {
+		return nil, ErrTicketUsed
+	}
+	return &ticket, nil
+}
+
+func (s *Service) signTicket(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("ws-ticket:" + encoded))
+	return mac.Sum(nil)
+}
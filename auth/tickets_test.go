@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// newTicketService returns a test service with tickets recorded in an in-process Redis.
+func newTicketService(t *testing.T) (*Service, *fakeClock, *miniredis.Miniredis) {
+	t.Helper()
+	svc, _, clock := newTestService(t)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	svc.EnableTickets(kv.New(client, "test"))
+	return svc, clock, server
+}
+
+func TestTicketSingleUse(t *testing.T) {
+	ctx := context.Background()
+	svc, _, server := newTicketService(t)
+	raw, expires, err := svc.IssueTicket("42", []string{ScopeAudio})
+	if err != nil {
+		t.Fatalf("IssueTicket: %v", err)
+	}
+	if until := time.Until(expires); until <= 0 || until > TicketTTL {
+		t.Errorf("ticket expires in %s, want within %s", until, TicketTTL)
+	}
+
+	ticket, err := svc.RedeemTicket(ctx, raw, ScopeAudio)
+	if err != nil {
+		t.Fatalf("RedeemTicket: %v", err)
+	}
+	if ticket.UserID != "42" {
+		t.Errorf("ticket user = %q, want 42", ticket.UserID)
+	}
+	key := "test:" + string(kv.Tickets) + ":" + ticket.ID
+	if ttl := server.TTL(key); ttl <= 0 || ttl > TicketTTL {
+		t.Errorf("redeemed marker TTL = %s, want at most the ticket's lifetime", ttl)
+	}
+	if _, err := svc.RedeemTicket(ctx, raw, ScopeAudio); !errors.Is(err, ErrTicketUsed) {
+		t.Errorf("second RedeemTicket = %v, want ErrTicketUsed", err)
+	}
+}
+
+func TestTicketConcurrentRedeemsOnce(t *testing.T) {
+	svc, _, _ := newTicketService(t)
+	raw, _, err := svc.IssueTicket("42", []string{ScopeChat})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.RedeemTicket(context.Background(), raw, ScopeChat)
+			switch {
+			case err == nil:
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			case !errors.Is(err, ErrTicketUsed):
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if redeemed != 1 {
+		t.Errorf("%d of %d concurrent redemptions succeeded, want 1", redeemed, attempts)
+	}
+}
+
+func TestTicketExpiry(t *testing.T) {
+	svc, clock, _ := newTicketService(t)
+	raw, _, err := svc.IssueTicket("42", []string{ScopeAudio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(TicketTTL)
+	if _, err := svc.RedeemTicket(context.Background(), raw, ScopeAudio); !errors.Is(err, ErrTicketExpired) {
+		t.Errorf("RedeemTicket after %s = %v, want ErrTicketExpired", TicketTTL, err)
+	}
+}
+
+func TestTicketScopeMismatchIsNotConsumed(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTicketService(t)
+	raw, _, err := svc.IssueTicket("42", []string{ScopeAudio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.RedeemTicket(ctx, raw, ScopeChat); !errors.Is(err, ErrTicketScope) {
+		t.Fatalf("RedeemTicket for another scope = %v, want ErrTicketScope", err)
+	}
+	if _, err := svc.RedeemTicket(ctx, raw, ScopeAudio); err != nil {
+		t.Errorf("RedeemTicket after a scope mismatch = %v, want the ticket still redeemable", err)
+	}
+
+	if _, _, err := svc.IssueTicket("42", []string{ScopeWrite}); !errors.Is(err, ErrTicketScope) {
+		t.Errorf("IssueTicket with a non-WebSocket scope = %v, want ErrTicketScope", err)
+	}
+}
+
+func TestTicketRejectsTampering(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTicketService(t)
+	raw, _, err := svc.IssueTicket("42", []string{ScopeAudio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, signature, _ := strings.Cut(raw, ".")
+	other, _, _ := newTicketService(t)
+	other.secret = []byte("other-secret")
+	forged, _, err := other.IssueTicket("1", []string{ScopeAudio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	// correctly signed payloads that are not tickets
+	signed := func(payload string) string {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+		return encoded + "." + base64.RawURLEncoding.EncodeToString(svc.signTicket(encoded))
+	}
+
+	for name, candidate := range map[string]string{
+		"empty":            "",
+		"no signature":     encoded,
+		"bad signature":    encoded + ".AAAA",
+		"swapped payload":  forgedPayload + "." + signature,
+		"other secret":     forged,
+		"garbage":          "not.a-ticket",
+		"payload not json": signed("not json"),
+		"no ticket id":     signed(`{"sub":"42","scopes":["audio"],"exp":9999999999}`),
+	} {
+		if _, err := svc.RedeemTicket(ctx, candidate, ScopeAudio); !errors.Is(err, ErrTicketInvalid) {
+			t.Errorf("%s: RedeemTicket = %v, want ErrTicketInvalid", name, err)
+		}
+	}
+	if _, err := svc.RedeemTicket(ctx, raw, ScopeAudio); err != nil {
+		t.Errorf("genuine ticket rejected after the forgeries: %v", err)
+	}
+}
+
+func TestTicketRedisDownFailsClosed(t *testing.T) {
+	svc, _, server := newTicketService(t)
+	raw, _, err := svc.IssueTicket("42", []string{ScopeAudio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if _, err := svc.RedeemTicket(context.Background(), raw, ScopeAudio); err == nil {
+		t.Error("ticket redeemed without Redis to record it")
+	}
+
+	disabled, _, _ := newTestService(t)
+	if _, _, err := disabled.IssueTicket("42", nil); !errors.Is(err, ErrTicketsDisabled) {
+		t.Errorf("IssueTicket without a store = %v, want ErrTicketsDisabled", err)
+	}
+}
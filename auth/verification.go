@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/mailer"
+)
+
+// Email verification errors.
+var (
+	ErrVerificationDisabled = errors.New("email verification is not configured")
+	ErrEmailMissing         = errors.New("account has no email")
+	ErrEmailAlreadyVerified = errors.New("email already verified")
+	// ErrVerificationInvalid covers unknown tokens and tokens sent to an address the
+	// account no longer uses.
+	ErrVerificationInvalid = errors.New("verification token invalid")
+	ErrVerificationExpired = errors.New("verification token expired")
+	ErrVerificationUsed    = errors.New("verification token already used")
+)
+
+// DefaultVerificationTTL is used when EnableEmailVerification is given a non-positive TTL.
+const DefaultVerificationTTL = 24 * time.Hour
+
+// EnableEmailVerification turns on the verification endpoints. Tokens are delivered by m
+// as a link to linkURL with a token query parameter, and expire after ttl.
+func (s *Service) EnableEmailVerification(m mailer.Mailer, linkURL string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultVerificationTTL
+	}
+	s.mailer, s.verifyURL, s.verifyTTL = m, strings.TrimSpace(linkURL), ttl
+}
+
+// RequestEmailVerification mails a one-time verification token to the email of userID
+// and returns when it expires. Earlier tokens stay valid until they expire or one of
+// them is used.
+func (s *Service) RequestEmailVerification(ctx context.Context, userID string) (time.Time, error) {
+	if s.users == nil {
+		return time.Time{}, ErrAccountsDisabled
+	}
+	if s.mailer == nil {
+		return time.Time{}, ErrVerificationDisabled
+	}
+	user, err := s.loadUser(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if user.Email == "" {
+		return time.Time{}, ErrEmailMissing
+	}
+	if user.EmailVerified {
+		return time.Time{}, ErrEmailAlreadyVerified
+	}
+
+	raw, err := randomToken(32)
+	if err != nil {
+		return time.Time{}, err
+	}
+	expires := s.now().Add(s.verifyTTL)
+	token := models.VerificationToken{
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: s.signVerificationToken(raw),
+		ExpiresAt: expires,
+	}
+	if err := s.users.CreateVerificationToken(ctx, token); err != nil {
+		return time.Time{}, fmt.Errorf("request verification: %w", err)
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to confirm your email address. It expires at %s.\n\n%s\n",
+			user.Username, expires.UTC().Format(time.RFC1123), s.verificationLink(raw)),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return time.Time{}, fmt.Errorf("request verification: send mail: %w", err)
+	}
+	return expires, nil
+}
+
+// ConfirmEmailVerification spends a mailed token and marks the address it was sent to
+// verified.
+func (s *Service) ConfirmEmailVerification(ctx context.Context, rawToken string) (*models.User, error) {
+	if s.users == nil {
+		return nil, ErrAccountsDisabled
+	}
+	rawToken = strings.TrimSpace(rawToken)
+	if rawToken == "" {
+		return nil, ErrVerificationInvalid
+	}
+
+	token, err := s.users.GetVerificationToken(ctx, s.signVerificationToken(rawToken))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrVerificationInvalid
+		}
+		return nil, fmt.Errorf("confirm verification: %w", err)
+	}
+	if token.UsedAt != nil {
+		return nil, ErrVerificationUsed
+	}
+	if !s.now().Before(token.ExpiresAt) {
+		return nil, ErrVerificationExpired
+	}
+
+	if err := s.users.ConfirmEmail(ctx, token.ID); err != nil {
+		switch {
+		case errors.Is(err, db.ErrVerificationTokenSpent):
+			return nil, ErrVerificationUsed
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrVerificationInvalid
+		}
+		return nil, fmt.Errorf("confirm verification: %w", err)
+	}
+	user, err := s.users.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("confirm verification: %w", err)
+	}
+	return user, nil
+}
+
+// signVerificationToken returns the HMAC of raw under the signing secret; only the HMAC
+// is stored, so neither a database dump nor a guessed token can confirm an address.
+func (s *Service) signVerificationToken(raw string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("email-verification:" + raw))
+	return mac.Sum(nil)
+}
+
+func (s *Service) verificationLink(raw string) string {
+	if s.verifyURL == "" {
+		return raw
+	}
+	link, err := url.Parse(s.verifyURL)
+	if err != nil {
+		return s.verifyURL + raw
+	}
+	query := link.Query()
+	query.Set("token", raw)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/mailer"
+)
+
+// outbox is a mailer.Mailer that keeps what it sends.
+type outbox struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (o *outbox) Send(_ context.Context, msg mailer.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = append(o.sent, msg)
+	return nil
+}
+
+// lastToken returns the token of the link in the last mail sent.
+func (o *outbox) lastToken(t *testing.T) string {
+	t.Helper()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.sent) == 0 {
+		t.Fatal("no mail was sent")
+	}
+	for _, field := range strings.Fields(o.sent[len(o.sent)-1].Body) {
+		if link, err := url.Parse(field); err == nil && link.Query().Get("token") != "" {
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("mail has no verification link: %q", o.sent[len(o.sent)-1].Body)
+	return ""
+}
+
+// newVerifyingService returns a service with verification enabled for alice (user 1),
+// whose email is set and unverified.
+func newVerifyingService(t *testing.T) (*Service, *outbox, *fakeClock) {
+	t.Helper()
+	ctx := context.Background()
+	svc, _, clock := newTestService(t)
+	mail := &outbox{}
+	svc.EnableEmailVerification(mail, "https://app.example.com/verify", time.Hour)
+	if _, err := svc.Register(ctx, "alice", "correct horse", Device{}); err != nil {
+		t.Fatal(err)
+	}
+	email := "alice@example.com"
+	if _, err := svc.UpdateProfile(ctx, "1", ProfileUpdate{Email: &email}); err != nil {
+		t.Fatal(err)
+	}
+	return svc, mail, clock
+}
+
+func TestEmailVerificationConfirmsOnce(t *testing.T) {
+	ctx := context.Background()
+	svc, mail, _ := newVerifyingService(t)
+
+	if _, err := svc.RequestEmailVerification(ctx, "1"); err != nil {
+		t.Fatalf("RequestEmailVerification: %v", err)
+	}
+	if to := mail.sent[0].To; to != "alice@example.com" {
+		t.Errorf("mail sent to %q", to)
+	}
+	token := mail.lastToken(t)
+
+	user, err := svc.ConfirmEmailVerification(ctx, token)
+	if err != nil {
+		t.Fatalf("ConfirmEmailVerification: %v", err)
+	}
+	if !user.EmailVerified {
+		t.Error("user is not verified after confirming")
+	}
+	if _, err := svc.ConfirmEmailVerification(ctx, token); !errors.Is(err, ErrVerificationUsed) {
+		t.Errorf("replayed token = %v, want ErrVerificationUsed", err)
+	}
+	if _, err := svc.RequestEmailVerification(ctx, "1"); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Errorf("request for a verified email = %v, want ErrEmailAlreadyVerified", err)
+	}
+}
+
+func TestEmailVerificationExpiry(t *testing.T) {
+	ctx := context.Background()
+	svc, mail, clock := newVerifyingService(t)
+
+	expires, err := svc.RequestEmailVerification(ctx, "1")
+	if err != nil {
+		t.Fatalf("RequestEmailVerification: %v", err)
+	}
+	if want := clock.Now().Add(time.Hour); !expires.Equal(want) {
+		t.Errorf("token expires at %s, want %s", expires, want)
+	}
+	clock.Advance(time.Hour)
+	if _, err := svc.ConfirmEmailVerification(ctx, mail.lastToken(t)); !errors.Is(err, ErrVerificationExpired) {
+		t.Errorf("token at its expiry = %v, want ErrVerificationExpired", err)
+	}
+	// a fresh token still works after an earlier one expired
+	if _, err := svc.RequestEmailVerification(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ConfirmEmailVerification(ctx, mail.lastToken(t)); err != nil {
+		t.Errorf("fresh token: %v", err)
+	}
+}
+
+func TestEmailVerificationInvalidTokens(t *testing.T) {
+	ctx := context.Background()
+	svc, mail, _ := newVerifyingService(t)
+
+	if _, err := svc.RequestEmailVerification(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	token := mail.lastToken(t)
+	for _, raw := range []string{"", "unknown-token", token + "x"} {
+		if _, err := svc.ConfirmEmailVerification(ctx, raw); !errors.Is(err, ErrVerificationInvalid) {
+			t.Errorf("ConfirmEmailVerification(%q) = %v, want ErrVerificationInvalid", raw, err)
+		}
+	}
+
+	// a token mailed to an address the account no longer uses confirms nothing
+	email := "alice@example.org"
+	if _, err := svc.UpdateProfile(ctx, "1", ProfileUpdate{Email: &email}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ConfirmEmailVerification(ctx, token); !errors.Is(err, ErrVerificationInvalid) {
+		t.Errorf("token for the old address = %v, want ErrVerificationInvalid", err)
+	}
+	// tokens are bound to the signing secret
+	other := NewService("another-secret", 0, 0, svc.users)
+	if _, err := svc.RequestEmailVerification(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.ConfirmEmailVerification(ctx, mail.lastToken(t)); !errors.Is(err, ErrVerificationInvalid) {
+		t.Errorf("token checked under another secret = %v, want ErrVerificationInvalid", err)
+	}
+}
+
+func TestEmailVerificationPreconditions(t *testing.T) {
+	ctx := context.Background()
+	svc, _, _ := newTestService(t)
+	if _, err := svc.Register(ctx, "alice", "correct horse", Device{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.RequestEmailVerification(ctx, "1"); !errors.Is(err, ErrVerificationDisabled) {
+		t.Errorf("request without a mailer = %v, want ErrVerificationDisabled", err)
+	}
+	svc.EnableEmailVerification(&outbox{}, "", 0)
+	if _, err := svc.RequestEmailVerification(ctx, "1"); !errors.Is(err, ErrEmailMissing) {
+		t.Errorf("request without an email = %v, want ErrEmailMissing", err)
+	}
+}
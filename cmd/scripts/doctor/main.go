@@ -0,0 +1,16 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/wuwenbin0122/wwb.ai/doctor"
+)
+
+// doctor checks the configuration and every dependency the server needs, printing a
+// report with hints; it exits non-zero when the server could not work.
+//
+//	go run cmd/scripts/doctor/main.go
+func main() {
+	os.Exit(doctor.Main(context.Background(), os.Stdout))
+}
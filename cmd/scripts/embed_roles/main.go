@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/services"
+	"go.uber.org/zap"
+)
+
+// embed_roles backfills the role embeddings used by GET /api/roles?search=semantic. It
+// embeds the roles that have no embedding of EMBEDDING_MODEL yet, or every role with -all,
+// for instance after switching models. Migration 0018 must have run.
+//
+//	go run cmd/scripts/embed_roles/main.go -dry-run
+//	go run cmd/scripts/embed_roles/main.go -all
+func main() {
+	var (
+		all    = flag.Bool("all", false, "re-embed every role, not only the stale ones")
+		dryRun = flag.Bool("dry-run", false, "list the roles to embed without calling the model")
+	)
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	embeddings := services.NewEmbeddingService(cfg, zap.NewNop().Sugar())
+	if embeddings == nil {
+		log.Fatal("EMBEDDING_MODEL is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := db.NewPostgresPool(ctx, cfg.DBURL, db.PostgresOptions{})
+	if err != nil {
+		log.Fatalf("connect postgres: %v", err)
+	}
+	defer pool.Close()
+
+	repo := db.NewPgRoleRepository(db.NewPoolRouter(pool, nil, nil))
+	repo.EnableSemanticSearch(embeddings)
+
+	ids, err := repo.StaleEmbeddings(ctx, *all)
+	if err != nil {
+		log.Fatalf("list roles: %v", err)
+	}
+
+	embedded, failed := 0, 0
+	for _, id := range ids {
+		if *dryRun {
+			log.Printf("%-9s #%d", "stale", id)
+			continue
+		}
+		if err := repo.UpdateEmbedding(ctx, id); err != nil {
+			log.Printf("skip role %d: %v", id, err)
+			failed++
+			continue
+		}
+		embedded++
+	}
+	log.Printf("embedded=%d failed=%d of %d model=%s dry_run=%t", embedded, failed, len(ids), embeddings.Model(), *dryRun)
+}
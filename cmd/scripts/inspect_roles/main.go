@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/wuwenbin0122/wwb.ai/config"
 	"github.com/wuwenbin0122/wwb.ai/db"
@@ -15,29 +16,23 @@ func main() {
 	}
 
 	ctx := context.Background()
-	pool, err := db.NewPostgresPool(ctx, cfg.DBURL)
+	pool, err := db.NewPostgresPool(ctx, cfg.DBURL, db.PostgresOptions{})
 	if err != nil {
 		panic(err)
 	}
 	defer pool.Close()
 
-	const query = `SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = 'public' AND table_name = 'roles' ORDER BY ordinal_position`
-	rows, err := pool.Query(ctx, query)
+	columns, err := db.RoleColumns(ctx, pool)
 	if err != nil {
 		panic(err)
 	}
-	defer rows.Close()
 
 	fmt.Println("columns:")
-	for rows.Next() {
-		var name, dataType string
-		if err := rows.Scan(&name, &dataType); err != nil {
-			panic(err)
-		}
-		fmt.Printf("- %s (%s)\n", name, dataType)
+	for _, column := range columns {
+		fmt.Printf("- %s (%s)\n", column.Name, column.DataType)
 	}
 
-	if rows.Err() != nil {
-		panic(rows.Err())
+	if missing := db.MissingRoleColumns(columns); len(missing) > 0 {
+		fmt.Printf("missing or outdated: %s\n", strings.Join(missing, ", "))
 	}
 }
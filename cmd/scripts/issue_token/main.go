@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/auth"
+	"github.com/wuwenbin0122/wwb.ai/config"
+)
+
+// issue_token prints an access token signed with JWT_SECRET, for calling routes that
+// require a signed-in user during development.
+//
+//	go run cmd/scripts/issue_token/main.go -user alice -ttl 1h
+func main() {
+	var (
+		user = flag.String("user", "", "user ID to put in the token subject")
+		ttl  = flag.Duration("ttl", 24*time.Hour, "token lifetime")
+	)
+	flag.Parse()
+	if *user == "" {
+		log.Fatal("-user is required")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	svc := auth.NewService(cfg.JWTSecret, *ttl, 0, nil)
+	if svc == nil {
+		log.Fatal("JWT_SECRET is not set")
+	}
+
+	token, expires, err := svc.IssueToken(*user)
+	if err != nil {
+		log.Fatalf("issue token: %v", err)
+	}
+	log.Printf("token for %s expires at %s", *user, expires.Format(time.RFC3339))
+	fmt.Println(token)
+}
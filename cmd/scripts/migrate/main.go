@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/migrations"
+)
+
+// migrate applies the versioned schema migrations in db/migrations to DB_URL.
+//
+//	go run cmd/scripts/migrate/main.go up        # apply every pending migration
+//	go run cmd/scripts/migrate/main.go status    # list migrations and when they were applied
+//	go run cmd/scripts/migrate/main.go to 9      # apply or roll back until version 9 is the latest
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: migrate up | status | to <version>")
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+
+	ctx := context.Background()
+	pool, err := db.NewPostgresPool(ctx, cfg.DBURL, db.PostgresOptions{})
+	if err != nil {
+		log.Fatalf("connect postgres: %v", err)
+	}
+	defer pool.Close()
+
+	switch flag.Arg(0) {
+	case "up":
+		applied, err := migrations.Up(ctx, pool)
+		report("applied", applied)
+		if err != nil {
+			log.Fatalf("migrate up: %v", err)
+		}
+	case "to":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		target, err := strconv.Atoi(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("invalid version %q", flag.Arg(1))
+		}
+		done, err := migrations.To(ctx, pool, target)
+		report("migrated", done)
+		if err != nil {
+			log.Fatalf("migrate to %d: %v", target, err)
+		}
+	case "status":
+		statuses, err := migrations.Statuses(ctx, pool)
+		if err != nil {
+			log.Fatalf("migration status: %v", err)
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-28s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func report(verb string, versions []int) {
+	if len(versions) == 0 {
+		log.Println("schema is up to date")
+		return
+	}
+	log.Printf("%s migrations %v", verb, versions)
+}
@@ -8,6 +8,7 @@ import (
 
     "github.com/wuwenbin0122/wwb.ai/config"
     "github.com/wuwenbin0122/wwb.ai/db"
+    "github.com/wuwenbin0122/wwb.ai/db/migrations"
 )
 
 func main() {
@@ -17,38 +18,18 @@ func main() {
     }
 
     ctx := context.Background()
-    pool, err := db.NewPostgresPool(ctx, cfg.DBURL)
+    pool, err := db.NewPostgresPool(ctx, cfg.DBURL, db.PostgresOptions{})
     if err != nil {
         log.Fatalf("connect postgres: %v", err)
     }
     defer pool.Close()
 
-    stmts := []string{
-        `ALTER TABLE roles ADD COLUMN IF NOT EXISTS personality JSONB DEFAULT '{}'::jsonb`,
-        `ALTER TABLE roles ADD COLUMN IF NOT EXISTS background TEXT`,
-        `ALTER TABLE roles ADD COLUMN IF NOT EXISTS languages TEXT[] DEFAULT ARRAY['zh','en']`,
-        `ALTER TABLE roles ADD COLUMN IF NOT EXISTS skills JSONB DEFAULT '[]'::jsonb`,
-        // backfill defaults for existing rows that may have NULL languages/skills
-        `UPDATE roles SET languages = ARRAY['zh','en'] WHERE languages IS NULL`,
-        `UPDATE roles SET skills = '[]'::jsonb WHERE skills IS NULL`,
-        `UPDATE roles SET personality = '{}'::jsonb WHERE personality IS NULL`,
-    }
-
-    tx, err := pool.Begin(ctx)
+    // kept for existing docs and habits: the changes now live in db/migrations
+    applied, err := migrations.Up(ctx, pool)
     if err != nil {
-        log.Fatalf("begin tx: %v", err)
-    }
-    defer tx.Rollback(ctx)
-
-    for i, stmt := range stmts {
-        if _, err := tx.Exec(ctx, stmt); err != nil {
-            log.Fatalf("exec migration %d failed: %v\nSQL: %s", i+1, err, stmt)
-        }
-    }
-
-    if err := tx.Commit(ctx); err != nil {
-        log.Fatalf("commit migration: %v", err)
+        log.Fatalf("migrate: %v", err)
     }
+    fmt.Printf("applied migrations: %v\n", applied)
 
     // quick verify
     verify := `SELECT column_name, data_type FROM information_schema.columns WHERE table_schema='public' AND table_name='roles' ORDER BY ordinal_position`
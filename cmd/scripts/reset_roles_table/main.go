@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"log"
+	"strings"
 
 	"github.com/wuwenbin0122/wwb.ai/config"
 	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/migrations"
 )
 
 func main() {
@@ -15,32 +17,37 @@ func main() {
 	}
 
 	ctx := context.Background()
-	pool, err := db.NewPostgresPool(ctx, cfg.DBURL)
+	pool, err := db.NewPostgresPool(ctx, cfg.DBURL, db.PostgresOptions{})
 	if err != nil {
 		log.Fatalf("connect postgres: %v", err)
 	}
 	defer pool.Close()
 
-	stmts := []string{
-		"DROP TABLE IF EXISTS roles CASCADE",
-		`CREATE TABLE roles (
-            id SERIAL PRIMARY KEY,
-            name VARCHAR(255) NOT NULL,
-            domain VARCHAR(255),
-            tags VARCHAR(255),
-            bio TEXT,
-            personality JSONB DEFAULT '{}'::jsonb,
-            background TEXT,
-            languages TEXT[] DEFAULT ARRAY['zh', 'en'],
-            skills JSONB DEFAULT '[]'::jsonb
-        )`,
-	}
-
-	for _, stmt := range stmts {
-		if _, err := pool.Exec(ctx, stmt); err != nil {
-			log.Fatalf("exec stmt %q: %v", stmt, err)
+	all, err := migrations.All()
+	if err != nil {
+		log.Fatalf("load migrations: %v", err)
+	}
+	var roleVersions []int
+	for _, m := range all {
+		if strings.Contains(m.Name, "role") {
+			roleVersions = append(roleVersions, m.Version)
 		}
 	}
 
+	if _, err := pool.Exec(ctx, "DROP TABLE IF EXISTS role_stats_flushes, role_stats, roles CASCADE"); err != nil {
+		log.Fatalf("drop roles: %v", err)
+	}
+	// recreate the tables through the migrations so schema_migrations stays truthful
+	if err := migrations.Forget(ctx, pool, roleVersions...); err != nil {
+		log.Fatalf("forget role migrations: %v", err)
+	}
+	if _, err := migrations.Up(ctx, pool); err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+
+	if err := db.InvalidateRoleListCache(ctx, cfg.RedisURL, cfg.RedisKeyPrefix); err != nil {
+		log.Printf("invalidate role list cache: %v", err)
+	}
+
 	log.Println("roles table recreated")
 }
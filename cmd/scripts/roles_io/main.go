@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/roles"
+)
+
+// roles_io exports roles to, or imports them from, the JSON bundle format used by
+// GET /api/roles/export and POST /api/roles/import.
+//
+//	go run cmd/scripts/roles_io/main.go -export -file roles.json
+//	go run cmd/scripts/roles_io/main.go -import -file roles.json -dry-run
+func main() {
+	var (
+		doExport        = flag.Bool("export", false, "write every role to -file (stdout when empty)")
+		doImport        = flag.Bool("import", false, "upsert the roles in -file (stdin when empty) by name")
+		file            = flag.String("file", "", "bundle path")
+		dryRun          = flag.Bool("dry-run", false, "with -import, report changes without writing")
+		includeArchived = flag.Bool("include-archived", false, "with -export, include archived roles")
+	)
+	flag.Parse()
+	if *doExport == *doImport {
+		log.Fatal("exactly one of -export or -import is required")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+
+	ctx := context.Background()
+	pool, err := db.NewPostgresPool(ctx, cfg.DBURL, db.PostgresOptions{})
+	if err != nil {
+		log.Fatalf("connect postgres: %v", err)
+	}
+	defer pool.Close()
+
+	repo := db.NewPgRoleRepository(db.NewPoolRouter(pool, nil, nil))
+
+	if *doExport {
+		defs, err := roles.Export(ctx, repo, *includeArchived)
+		if err != nil {
+			log.Fatalf("export roles: %v", err)
+		}
+		out := io.Writer(os.Stdout)
+		if *file != "" {
+			f, err := os.Create(*file)
+			if err != nil {
+				log.Fatalf("create %s: %v", *file, err)
+			}
+			defer f.Close()
+			out = f
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(defs); err != nil {
+			log.Fatalf("write bundle: %v", err)
+		}
+		log.Printf("exported %d roles", len(defs))
+		return
+	}
+
+	in := io.Reader(os.Stdin)
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("open %s: %v", *file, err)
+		}
+		defer f.Close()
+		in = f
+	}
+	var defs []roles.Definition
+	if err := json.NewDecoder(in).Decode(&defs); err != nil {
+		log.Fatalf("read bundle: %v", err)
+	}
+
+	report, err := roles.Import(ctx, repo, defs, *dryRun)
+	if err != nil {
+		var fieldErrs roles.FieldErrors
+		if errors.As(err, &fieldErrs) {
+			for _, fe := range fieldErrs {
+				log.Printf("%s: %s", fe.Field, fe.Message)
+			}
+			log.Fatalf("bundle rejected: %d problems", len(fieldErrs))
+		}
+		log.Fatalf("import roles: %v", err)
+	}
+
+	for _, result := range report.Results {
+		log.Printf("%-9s %s", result.Action, result.Name)
+	}
+	if !*dryRun && report.Created+report.Updated > 0 {
+		if err := db.InvalidateRoleListCache(ctx, cfg.RedisURL, cfg.RedisKeyPrefix); err != nil {
+			log.Printf("invalidate role list cache: %v", err)
+		}
+	}
+	log.Printf("created=%d updated=%d unchanged=%d dry_run=%t", report.Created, report.Updated, report.Unchanged, report.DryRun)
+}
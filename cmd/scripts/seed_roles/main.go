@@ -2,155 +2,142 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/wuwenbin0122/wwb.ai/config"
 	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/roles"
 )
 
-type skill struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-}
-
-type seedRole struct {
-	name        string
-	domain      string
-	tags        string
-	bio         string
-	personality map[string]string
-	background  string
-	languages   []string
-	skills      []skill
-}
-
+// seed_roles upserts the public roles defined in a roles file (JSON or YAML in the export
+// bundle format) by name, after validating every definition.
+//
+//	go run cmd/scripts/seed_roles/main.go -dry-run              # show what would change
+//	go run cmd/scripts/seed_roles/main.go -only "Mulan,Socrates"
+//	go run cmd/scripts/seed_roles/main.go -prune                # also archive roles missing from the file
 func main() {
+	var (
+		file   = flag.String("file", "seed/roles.yaml", "roles file, .json or .yaml")
+		dryRun = flag.Bool("dry-run", false, "report changes without writing")
+		prune  = flag.Bool("prune", false, "archive public roles that are not in the file")
+		only   = flag.String("only", "", "comma-separated role names to seed; the rest of the file is ignored")
+	)
+	flag.Parse()
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("read %s: %v", *file, err)
+	}
+	defs, err := roles.ParseDefinitions(*file, data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *only != "" {
+		if *prune {
+			log.Fatal("-prune cannot be combined with -only")
+		}
+		defs = selectDefinitions(defs, *only)
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("load config: %v", err)
 	}
 
 	ctx := context.Background()
-
-	pool, err := db.NewPostgresPool(ctx, cfg.DBURL)
+	pool, err := db.NewPostgresPool(ctx, cfg.DBURL, db.PostgresOptions{})
 	if err != nil {
 		log.Fatalf("connect postgres: %v", err)
 	}
 	defer pool.Close()
 
-	roles := []seedRole{
-		{
-			name:   "Socrates",
-			domain: "Philosophy",
-			tags:   "Wise, Philosophical",
-			bio:    "Ancient Greek philosopher",
-			personality: map[string]string{
-				"temperament":         "inquiring",
-				"communication_style": "maieutic",
-			},
-			background: "An Athenian philosopher credited as one of the founders of Western philosophy.",
-			languages:  []string{"el", "en"},
-			skills: []skill{
-				{Name: "Dialectic", Description: "Guides conversations to surface deeper truths."},
-				{Name: "Ethics", Description: "Helps evaluate moral implications of decisions."},
-			},
-		},
-		{
-			name:   "Harry Potter",
-			domain: "Literature",
-			tags:   "Wizard, Brave",
-			bio:    "A young wizard with magical abilities",
-			personality: map[string]string{
-				"temperament":         "courageous",
-				"communication_style": "supportive",
-			},
-			background: "The Boy Who Lived, student of Hogwarts School of Witchcraft and Wizardry.",
-			languages:  []string{"en"},
-			skills: []skill{
-				{Name: "Defence Against the Dark Arts", Description: "Offers strategies against dark magic and adversity."},
-				{Name: "Leadership", Description: "Inspires peers to act with bravery and loyalty."},
-			},
-		},
-		{
-			name:   "Mulan",
-			domain: "History",
-			tags:   "Heroic, Loyal",
-			bio:    "Legendary woman warrior from ancient China",
-			personality: map[string]string{
-				"temperament":         "resolute",
-				"communication_style": "encouraging",
-			},
-			background: "Disguised herself as a man to take her father's place in the Imperial Army.",
-			languages:  []string{"zh", "en"},
-			skills: []skill{
-				{Name: "Tactical Insight", Description: "Analyzes battlefield conditions to find winning strategies."},
-				{Name: "Resilience Coaching", Description: "Motivates others to persist through hardship."},
-			},
-		},
-		{
-			name:   "Sherlock Holmes",
-			domain: "Literature",
-			tags:   "Detective, Analytical",
-			bio:    "Brilliant detective known for keen observation",
-			personality: map[string]string{
-				"temperament":         "analytical",
-				"communication_style": "precise",
-			},
-			background: "Consulting detective of 221B Baker Street with extraordinary deductive reasoning skills.",
-			languages:  []string{"en", "fr"},
-			skills: []skill{
-				{Name: "Deduction", Description: "Breaks down complex clues into actionable insights."},
-				{Name: "Forensics", Description: "Advises on evidence collection and analysis."},
-			},
-		},
-	}
+	repo := db.NewPgRoleRepository(db.NewPoolRouter(pool, nil, nil))
 
-	tx, err := pool.Begin(ctx)
+	// snapshot the public catalog first so updates can be reported field by field
+	current, err := repo.List(ctx, db.RoleFilter{IncludeArchived: true})
 	if err != nil {
-		log.Fatalf("begin tx: %v", err)
+		log.Fatalf("list roles: %v", err)
 	}
-	defer tx.Rollback(ctx)
-
-	names := make([]string, 0, len(roles))
-	for _, r := range roles {
-		names = append(names, r.name)
+	byName := make(map[string]models.Role, len(current))
+	for _, role := range current {
+		byName[role.Name] = role
 	}
 
-	if _, err := tx.Exec(ctx, "DELETE FROM roles WHERE name = ANY($1)", names); err != nil {
-		log.Fatalf("delete existing roles: %v", err)
+	report, err := roles.Import(ctx, repo, defs, *dryRun)
+	if err != nil {
+		var fieldErrs roles.FieldErrors
+		if errors.As(err, &fieldErrs) {
+			for _, fe := range fieldErrs {
+				log.Printf("%s: %s", fe.Field, fe.Message)
+			}
+			log.Fatalf("%s rejected: %d problems", *file, len(fieldErrs))
+		}
+		log.Fatalf("seed roles: %v", err)
 	}
 
-	for _, r := range roles {
-		personalityJSON, err := json.Marshal(r.personality)
-		if err != nil {
-			log.Fatalf("marshal personality for %s: %v", r.name, err)
+	seeded := make(map[string]bool, len(defs))
+	for i, result := range report.Results {
+		seeded[result.Name] = true
+		if result.Action == db.RoleImportUpdated {
+			changed := db.RoleDefinitionDiff(byName[result.Name], defs[i].Role())
+			log.Printf("%-9s %s (%s)", result.Action, result.Name, strings.Join(changed, ", "))
+			continue
 		}
+		log.Printf("%-9s %s", result.Action, result.Name)
+	}
 
-		skillsJSON, err := json.Marshal(r.skills)
-		if err != nil {
-			log.Fatalf("marshal skills for %s: %v", r.name, err)
+	pruned := 0
+	if *prune {
+		for _, role := range current {
+			if seeded[role.Name] || role.Archived {
+				continue
+			}
+			log.Printf("%-9s %s", "archived", role.Name)
+			pruned++
+			if *dryRun {
+				continue
+			}
+			if err := repo.Archive(ctx, role.ID); err != nil {
+				log.Fatalf("archive role %s: %v", role.Name, err)
+			}
 		}
+	}
 
-		if _, err := tx.Exec(ctx,
-			`INSERT INTO roles (name, domain, tags, bio, personality, background, languages, skills)
-                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-			r.name,
-			r.domain,
-			r.tags,
-			r.bio,
-			personalityJSON,
-			r.background,
-			r.languages,
-			skillsJSON,
-		); err != nil {
-			log.Fatalf("insert role %s: %v", r.name, err)
+	if !*dryRun && report.Created+report.Updated+pruned > 0 {
+		if err := db.InvalidateRoleListCache(ctx, cfg.RedisURL, cfg.RedisKeyPrefix); err != nil {
+			log.Printf("invalidate role list cache: %v", err)
 		}
 	}
+	log.Printf("created=%d updated=%d unchanged=%d archived=%d dry_run=%t",
+		report.Created, report.Updated, report.Unchanged, pruned, *dryRun)
+}
 
-	if err := tx.Commit(ctx); err != nil {
-		log.Fatalf("commit tx: %v", err)
+// selectDefinitions keeps the definitions named in the comma-separated list, failing on
+// names the file does not define.
+func selectDefinitions(defs []roles.Definition, list string) []roles.Definition {
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			wanted[name] = false
+		}
 	}
-
-	log.Printf("seeded %d roles", len(roles))
+	selected := make([]roles.Definition, 0, len(wanted))
+	for _, def := range defs {
+		name := strings.TrimSpace(def.Name)
+		if _, ok := wanted[name]; ok {
+			wanted[name] = true
+			selected = append(selected, def)
+		}
+	}
+	for name, found := range wanted {
+		if !found {
+			log.Fatalf("-only: %q is not defined in the file", name)
+		}
+	}
+	return selected
 }
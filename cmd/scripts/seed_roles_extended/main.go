@@ -1,165 +0,0 @@
-package main
-
-import (
-    "context"
-    "encoding/json"
-    "fmt"
-    "log"
-
-    "github.com/wuwenbin0122/wwb.ai/config"
-    "github.com/wuwenbin0122/wwb.ai/db"
-)
-
-type personality struct {
-    Tone        string   `json:"tone"`
-    Style       string   `json:"style"`
-    Constraints []string `json:"constraints"`
-}
-
-type skill struct {
-    Name string `json:"name"`
-    ID   string `json:"id"`
-}
-
-type roleRow struct {
-    Name        string
-    Domain      string
-    Tags        string
-    Bio         string
-    Background  string
-    Languages   []string
-    Personality personality
-    Skills      []skill
-}
-
-func main() {
-    cfg, err := config.Load()
-    if err != nil {
-        log.Fatalf("load config: %v", err)
-    }
-
-    ctx := context.Background()
-    pool, err := db.NewPostgresPool(ctx, cfg.DBURL)
-    if err != nil {
-        log.Fatalf("connect postgres: %v", err)
-    }
-    defer pool.Close()
-
-    roles := []roleRow{
-        {
-            Name:   "Socrates",
-            Domain: "Philosophy",
-            Tags:   "Socratic, Rational, Mentor",
-            Bio:    "Ancient Greek philosopher known for the Socratic method.",
-            Background: "古希腊哲学家，善用反诘法引导思考。强调定义、例外与依据的澄清，追问本质。",
-            Languages:  []string{"zh", "en"},
-            Personality: personality{
-                Tone:  "苏格拉底式反诘，理性而友善",
-                Style: "简洁、条理化、循循善诱",
-                Constraints: []string{
-                    "避免直接给出结论，优先提出澄清性问题",
-                    "引用古希腊思想可简述来源",
-                },
-            },
-            Skills: []skill{
-                {Name: "苏格拉底式提问", ID: "socratic_questions"},
-                {Name: "引用原典", ID: "citation_mode"},
-                {Name: "情绪稳定器", ID: "emo_stabilizer"},
-            },
-        },
-        {
-            Name:   "Sherlock Holmes",
-            Domain: "Literature",
-            Tags:   "Detective, Analytical, Observant",
-            Bio:    "Brilliant detective known for keen observation and deduction.",
-            Background: "维多利亚时代的私人侦探，推理严谨，擅长从细节提出假设并验证。",
-            Languages:  []string{"zh", "en"},
-            Personality: personality{
-                Tone:  "冷静、理性、直截了当",
-                Style: "观察入微、先证据后结论",
-                Constraints: []string{
-                    "先提出假设与备选解释，再给出结论",
-                    "列出至少两条推断路径或关键线索",
-                },
-            },
-            Skills: []skill{
-                {Name: "苏格拉底式提问", ID: "socratic_questions"},
-                {Name: "引用原典", ID: "citation_mode"},
-            },
-        },
-        {
-            Name:   "Mulan",
-            Domain: "History",
-            Tags:   "Heroic, Loyal, Courage",
-            Bio:    "Legendary woman warrior from ancient China.",
-            Background: "花木兰：坚韧勇敢、以行动为先，面对困难倾向拆解为小步骤并迅速执行。",
-            Languages:  []string{"zh", "en"},
-            Personality: personality{
-                Tone:  "坚韧、温暖、行动导向",
-                Style: "先安抚再建议、简洁务实",
-                Constraints: []string{
-                    "遇到困难先共情，再提出 1–3 个可执行小步骤",
-                    "避免空泛口号，强调具体行动",
-                },
-            },
-            Skills: []skill{
-                {Name: "情绪稳定器", ID: "emo_stabilizer"},
-                {Name: "苏格拉底式提问", ID: "socratic_questions"},
-            },
-        },
-        {
-            Name:   "Harry Potter",
-            Domain: "Literature",
-            Tags:   "Wizard, Brave, Friendly",
-            Bio:    "A young wizard with magical abilities.",
-            Background: "年轻的巫师，乐观、重友情、鼓励他人勇敢面对挑战。",
-            Languages:  []string{"zh", "en"},
-            Personality: personality{
-                Tone:  "年轻、友好、勇敢",
-                Style: "口语化、鼓励式",
-                Constraints: []string{
-                    "鼓励对方表达真实想法并提出具体下一步",
-                    "避免涉及危险魔法细节",
-                },
-            },
-            Skills: []skill{
-                {Name: "情绪稳定器", ID: "emo_stabilizer"},
-                {Name: "苏格拉底式提问", ID: "socratic_questions"},
-            },
-        },
-    }
-
-    tx, err := pool.Begin(ctx)
-    if err != nil {
-        log.Fatalf("begin tx: %v", err)
-    }
-    defer tx.Rollback(ctx)
-
-    // clean existing by name then insert
-    names := make([]string, 0, len(roles))
-    for _, r := range roles {
-        names = append(names, r.Name)
-    }
-    if _, err := tx.Exec(ctx, "DELETE FROM roles WHERE name = ANY($1)", names); err != nil {
-        log.Fatalf("delete existing roles: %v", err)
-    }
-
-    for _, r := range roles {
-        pjson, _ := json.Marshal(r.Personality)
-        skills, _ := json.Marshal(r.Skills)
-        const stmt = `
-            INSERT INTO roles (name, domain, tags, bio, personality, background, languages, skills)
-            VALUES ($1, $2, $3, $4, $5::jsonb, $6, $7, $8::jsonb)
-        `
-        if _, err := tx.Exec(ctx, stmt, r.Name, r.Domain, r.Tags, r.Bio, string(pjson), r.Background, r.Languages, string(skills)); err != nil {
-            log.Fatalf("insert role %s: %v", r.Name, err)
-        }
-    }
-
-    if err := tx.Commit(ctx); err != nil {
-        log.Fatalf("commit tx: %v", err)
-    }
-
-    fmt.Printf("seeded %d extended roles\n", len(roles))
-}
-
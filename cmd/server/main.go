@@ -3,22 +3,30 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/wuwenbin0122/wwb.ai/app"
 	"github.com/wuwenbin0122/wwb.ai/config"
 	"github.com/wuwenbin0122/wwb.ai/db"
-	"github.com/wuwenbin0122/wwb.ai/handlers"
-	"github.com/wuwenbin0122/wwb.ai/services"
+	"github.com/wuwenbin0122/wwb.ai/db/migrations"
+	"github.com/wuwenbin0122/wwb.ai/doctor"
 	"go.uber.org/zap"
 )
 
 func main() {
+	check := flag.Bool("check", false, "run the startup self-check (see cmd/scripts/doctor) and exit")
+	flag.Parse()
+	if *check {
+		os.Exit(doctor.Main(context.Background(), os.Stdout))
+	}
+
 	logger, err := zap.NewProduction()
 	if err != nil {
 		panic(err)
@@ -32,17 +40,44 @@ func main() {
 		sugar.Fatalf("load configuration: %v", err)
 	}
 
-	baseCtx := context.Background()
+	baseCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
 
-	pgPool, err := db.NewPostgresPool(baseCtx, cfg.DBURL)
+	pgOptions := db.PostgresOptions{
+		SlowQueryThreshold: time.Duration(cfg.DBSlowQueryMS) * time.Millisecond,
+		Logger:             sugar,
+	}
+	pgPool, err := db.NewPostgresPool(baseCtx, cfg.DBURL, pgOptions)
 	if err != nil {
 		sugar.Fatalf("connect postgres: %v", err)
 	}
 	defer pgPool.Close()
 
-	mongoClient, err := db.NewMongoClient(baseCtx, cfg.MongoURI)
+	if cfg.DBAutoMigrate {
+		applied, err := migrations.Up(baseCtx, pgPool)
+		if err != nil {
+			sugar.Fatalf("migrate postgres: %v", err)
+		}
+		if len(applied) > 0 {
+			sugar.Infow("applied postgres migrations", "versions", applied)
+		}
+	}
+
+	var replicaPool *pgxpool.Pool
+	if cfg.DBReplicaURL != "" {
+		replicaPool, err = db.NewPostgresPool(baseCtx, cfg.DBReplicaURL, pgOptions)
+		if err != nil {
+			sugar.Warnf("connect postgres replica, replica reads will use the primary: %v", err)
+		} else {
+			defer replicaPool.Close()
+		}
+	}
+
+	// Mongo and Redis are optional at startup: their clients dial lazily and the container
+	// degrades the features that need them until they are reachable
+	mongoClient, err := db.OpenMongoClient(baseCtx, cfg.MongoURI)
 	if err != nil {
-		sugar.Fatalf("connect mongo: %v", err)
+		sugar.Fatalf("open mongo client: %v", err)
 	}
 	defer func() {
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -52,9 +87,9 @@ func main() {
 		}
 	}()
 
-	redisClient, err := db.NewRedisClient(baseCtx, cfg.RedisURL)
+	redisClient, err := db.OpenRedisClient(cfg.RedisURL)
 	if err != nil {
-		sugar.Fatalf("connect redis: %v", err)
+		sugar.Fatalf("open redis client: %v", err)
 	}
 	defer func() {
 		if err := redisClient.Close(); err != nil {
@@ -62,47 +97,37 @@ func main() {
 		}
 	}()
 
-	router := gin.Default()
-
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
-
-	router.Use(func(c *gin.Context) {
-		c.Set("postgres", pgPool)
-		c.Set("mongo", mongoClient)
-		c.Set("redis", redisClient)
-		c.Next()
+	container := app.New(cfg, sugar, app.Clients{
+		Postgres: pgPool,
+		Replica:  replicaPool,
+		Mongo:    mongoClient,
+		Redis:    redisClient,
 	})
-
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
-
-	roleHandler := handlers.NewRoleHandler(pgPool)
-	router.GET("/api/roles", roleHandler.GetRoles)
-
-	nlpService := services.NewNLPService(cfg, sugar)
-	nlpHandler := handlers.NewNLPHandler(cfg, pgPool, nlpService, sugar)
-	router.POST("/api/nlp/chat", nlpHandler.HandleChat)
-
-	asrService := services.NewASRService(cfg, sugar)
-	ttsService := services.NewTTSService(cfg, sugar)
-	audioHandler := handlers.NewAudioHandler(cfg, asrService, ttsService, sugar)
-	router.GET("/ws/audio/asr", audioHandler.HandleASRWebsocket)
-	router.POST("/api/audio/tts", audioHandler.HandleTTS)
-	router.GET("/api/audio/voices", audioHandler.HandleVoiceList)
+	container.WarmUp(baseCtx)
+	router := gin.New()
+	app.RegisterRoutes(router, container)
+
+	if cfg.QiniuAPIKey != "" {
+		go func() {
+			validateCtx, cancel := context.WithTimeout(baseCtx, 30*time.Second)
+			defer cancel()
+			if err := container.TTSService.ValidateDefaultVoice(validateCtx, cfg.QiniuAPIKey); err != nil {
+				sugar.Warnf("validate default tts voice: %v", err)
+			}
+		}()
+	}
 
 	server := &http.Server{
-		Addr:    cfg.ServerAddr,
-		Handler: router,
+		Addr:              cfg.ServerAddr,
+		Handler:           router,
+		ReadHeaderTimeout: time.Duration(cfg.HTTPReadHeaderTimeoutSeconds) * time.Second,
+		ReadTimeout:       time.Duration(cfg.HTTPReadTimeoutSeconds) * time.Second,
+		WriteTimeout:      time.Duration(cfg.HTTPWriteTimeoutSeconds) * time.Second,
+		IdleTimeout:       time.Duration(cfg.HTTPIdleTimeoutSeconds) * time.Second,
 	}
 
+	container.Supervisor.Start(baseCtx)
+
 	go func() {
 		sugar.Infof("backend server listening on %s", cfg.ServerAddr)
 		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -119,9 +144,18 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// drain before closing listeners: server.Shutdown does not wait for hijacked WebSocket
+	// connections, and upgrades arriving meanwhile are answered with 503
+	if err := container.Audio.Shutdown(shutdownCtx); err != nil {
+		sugar.Errorf("drain asr sessions: %v", err)
+	}
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		sugar.Errorf("server shutdown: %v", err)
 	}
 
+	if err := container.Supervisor.Stop(shutdownCtx); err != nil {
+		sugar.Errorf("stop background workers: %v", err)
+	}
+
 	sugar.Info("server exited cleanly")
 }
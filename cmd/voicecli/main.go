@@ -0,0 +1,86 @@
+// Command voicecli talks to a role from a laptop microphone: speech is streamed to Qiniu ASR,
+// interim and final transcripts are printed, and each final transcript is answered by the chat model.
+//
+// Build with -tags capture (miniaudio via malgo) or -tags raylib; only QINIU_API_KEY is required
+// unless -role-id loads a role from Postgres.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/services"
+	"go.uber.org/zap"
+)
+
+func main() {
+	backend := flag.String("backend", "capture", "microphone backend: capture or raylib")
+	roleID := flag.Int64("role-id", 0, "load the role from Postgres (requires DB_URL)")
+	roleName := flag.String("role-name", "助手", "role name used when -role-id is not set")
+	lang := flag.String("lang", "zh", "conversation language")
+	sampleRate := flag.Int("sample-rate", 16000, "capture sample rate in Hz")
+	channels := flag.Int("channels", 1, "capture channels")
+	threshold := flag.Float64("silence-threshold", 0.02, "RMS level (0..1) below which audio counts as silence")
+	silenceMs := flag.Int("silence-ms", 800, "silence duration that ends an utterance")
+	device := flag.String("device", "", "pick the capture device whose name contains this substring")
+	flag.Parse()
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+	sugar := logger.Sugar()
+
+	// database settings are only needed with -role-id, so a validation error alone is not fatal
+	cfg, err := config.Load()
+	if cfg == nil {
+		sugar.Fatalf("load configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	role := models.Role{Name: *roleName}
+	if *roleID > 0 {
+		pool, err := db.NewPostgresPool(ctx, cfg.DBURL, db.PostgresOptions{})
+		if err != nil {
+			sugar.Fatalf("connect postgres: %v", err)
+		}
+		loaded, err := db.GetRoleByID(ctx, pool, *roleID)
+		pool.Close()
+		if err != nil {
+			sugar.Fatalf("load role %d: %v", *roleID, err)
+		}
+		role = *loaded
+	}
+
+	rc := services.RaylibASRConfig{
+		SampleRate:       *sampleRate,
+		Channels:         *channels,
+		Bits:             16,
+		SilenceThreshold: *threshold,
+		SilenceMs:        *silenceMs,
+		DeviceHint:       *device,
+	}
+	nlp := services.NewNLPService(cfg, sugar)
+
+	switch *backend {
+	case "capture":
+		err = services.RunCaptureASR(ctx, cfg, nlp, role, *lang, rc, sugar)
+	case "raylib":
+		err = services.RunRaylibASR(ctx, cfg, nlp, role, *lang, rc, sugar)
+	default:
+		err = fmt.Errorf("unknown backend %q", *backend)
+	}
+	if err != nil {
+		sugar.Fatalf("voice loop: %v", err)
+	}
+}
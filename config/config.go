@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -13,8 +15,12 @@ import (
 
 type Config struct {
 	ServerAddr        string
+	AdminToken        string
+	JWTSecret         string
 	DBURL             string
+	DBReplicaURL      string
 	MongoURI          string
+	MongoDatabase     string
 	RedisURL          string
 	QiniuAPIBaseURL   string
 	QiniuAPIKey       string
@@ -22,6 +28,264 @@ type Config struct {
 	QiniuTTSFormat    string
 	QiniuASRModel     string
 	QiniuNLPModel     string
+
+	// RedisKeyPrefix namespaces every Redis key, so deployments can share one instance.
+	RedisKeyPrefix string
+
+	// ASRPartialIntervalMS is the minimum spacing between interim transcript events sent to clients.
+	ASRPartialIntervalMS int
+	// ASRStorePartials keeps interim transcripts alongside each archived utterance.
+	ASRStorePartials bool
+	// ASRResumeTTLSeconds bounds how long an interrupted streaming session can be resumed; 0 disables resume.
+	ASRResumeTTLSeconds int
+	// ASRMaxStreams caps concurrent upstream ASR WebSockets opened by this instance.
+	ASRMaxStreams int
+	// ASRProvider selects the speech recognition backend: "qiniu" (default) or "openai",
+	// an OpenAI-compatible /audio/transcriptions endpoint such as whisper-server,
+	// configured by the ASROpenAI settings.
+	ASRProvider      string
+	ASROpenAIBaseURL string
+	ASROpenAIAPIKey  string
+	ASROpenAIModel   string
+	// NLPMaxPromptTokens rejects chat requests whose estimated prompt exceeds it; 0 disables the check.
+	NLPMaxPromptTokens int
+	// NLPHistoryStrategy picks the history messages kept verbatim once a conversation is
+	// summarized: "recent" (default) keeps the latest, "importance" the exchanges scored
+	// most important. NLPHistoryTokenBudget caps the tokens "importance" keeps; 0 caps
+	// only the message count.
+	NLPHistoryStrategy    string
+	NLPHistoryTokenBudget int
+	// TTSMaxChars is the longest text sent in one TTS call; longer text is synthesized in chunks.
+	TTSMaxChars int
+	// TTSProvider selects the speech synthesis backend: "qiniu" (default) or "openai", an
+	// OpenAI-compatible /audio/speech endpoint such as an Edge TTS bridge, configured by
+	// the TTSOpenAI settings. TTSOpenAIVoices lists the voices it offers, which it cannot
+	// report itself.
+	TTSProvider      string
+	TTSOpenAIBaseURL string
+	TTSOpenAIAPIKey  string
+	TTSOpenAIModel   string
+	TTSOpenAIVoice   string
+	TTSOpenAIVoices  []string
+	// TTSVoiceAliases maps, per provider name, the voice names roles store to the
+	// provider's own, so voice_type values survive a provider switch. It is read from
+	// TTS_VOICE_ALIASES_<PROVIDER> as "role_voice=provider_voice,...".
+	TTSVoiceAliases map[string]map[string]string
+	// AudioRatePerMinute and AudioRateBurst limit TTS and ASR REST calls per caller; 0 disables limiting.
+	AudioRatePerMinute int
+	AudioRateBurst     int
+	// RoleCacheTTLSeconds is how long GET /api/roles responses stay in Redis; 0 disables the cache.
+	RoleCacheTTLSeconds int
+	// RoleByIDCacheTTLSeconds is how long a role looked up by ID for chat stays in Redis; 0
+	// disables that cache.
+	RoleByIDCacheTTLSeconds int
+	// RoleReloadSeconds is how often the role caches are dropped and the global prompt files
+	// re-read, as POST /api/admin/reload does; 0 leaves it to that endpoint.
+	RoleReloadSeconds int
+	// BlobDir is where uploaded files (role avatars) are stored; they are served under /static/.
+	BlobDir string
+	// AvatarMaxBytes caps the size of an uploaded avatar image.
+	AvatarMaxBytes int
+	// RoleUsageFlushSeconds is how often chat counts buffered in Redis are written to role_stats.
+	RoleUsageFlushSeconds int
+	// RoleUsageHalfLifeHours is the half-life of a role's usage score in the featured ranking.
+	RoleUsageHalfLifeHours int
+	// AccessTokenTTLMinutes and RefreshTokenTTLHours are the lifetimes of issued JWTs and
+	// of the refresh tokens that renew them.
+	AccessTokenTTLMinutes int
+	RefreshTokenTTLHours  int
+	// LoginLockoutThreshold and LoginIPLockoutThreshold are the failed logins per username and
+	// per client IP that trigger a lockout of LoginLockoutSeconds, doubling on each further
+	// failure up to LoginLockoutMaxSeconds; 0 disables that limit.
+	LoginLockoutThreshold   int
+	LoginIPLockoutThreshold int
+	LoginLockoutSeconds     int
+	LoginLockoutMaxSeconds  int
+	// EmailVerifyURL is the page linked from verification mails (the token is appended as
+	// ?token=); EmailVerifyTTLMinutes is how long such a link stays valid.
+	EmailVerifyURL        string
+	EmailVerifyTTLMinutes int
+	// ReadyMongoRequired and ReadyRedisRequired decide whether /health/ready fails (503) or
+	// only reports "degraded" when Mongo or Redis is unreachable. Postgres is always required.
+	ReadyMongoRequired bool
+	ReadyRedisRequired bool
+	// DependencyCheckSeconds is how often Mongo and Redis are pinged, so features that need
+	// them degrade while they are down and resume once they are back.
+	DependencyCheckSeconds int
+	// MetricsUsername and MetricsPassword protect /metrics with basic auth; it is open when
+	// the username is empty.
+	MetricsUsername string
+	MetricsPassword string
+	// MaxBodyBytes caps request bodies; UploadMaxBytes replaces it on the ASR upload and role
+	// import routes. 0 disables the limit.
+	MaxBodyBytes   int
+	UploadMaxBytes int
+	// HandlerTimeoutSeconds bounds how long a handler may run before the client gets a 408;
+	// LongHandlerTimeoutSeconds applies to the routes that wait on speech and chat models.
+	// WebSocket routes are exempt.
+	HandlerTimeoutSeconds     int
+	LongHandlerTimeoutSeconds int
+	// HTTPReadHeaderTimeoutSeconds, HTTPReadTimeoutSeconds, HTTPWriteTimeoutSeconds and
+	// HTTPIdleTimeoutSeconds configure the http.Server; the write timeout must outlast the
+	// longest handler timeout.
+	HTTPReadHeaderTimeoutSeconds int
+	HTTPReadTimeoutSeconds       int
+	HTTPWriteTimeoutSeconds      int
+	HTTPIdleTimeoutSeconds       int
+	// HTTPCompressMinBytes is the size from which JSON and text responses are gzip or
+	// deflate encoded for clients accepting it; 0 disables compression.
+	HTTPCompressMinBytes int
+	// DBAutoMigrate applies pending db/migrations when the server starts.
+	DBAutoMigrate bool
+	// DBSlowQueryMS logs Postgres queries running at least this long; 0 disables the log.
+	DBSlowQueryMS int
+
+	// Default monthly quotas of signed-in users, overridable per user; 0 means unlimited.
+	QuotaTokensPerMonth        int
+	QuotaTTSCharactersPerMonth int
+	QuotaASRMinutesPerMonth    int
+	// QuotaReconcileSeconds is how often the Redis quota counters are rebuilt from the
+	// usage table.
+	QuotaReconcileSeconds int
+
+	// ChatMaxConcurrent caps the chats and voice sessions one caller runs at once; 0 means
+	// unlimited. ChatMaxConcurrentOverrides sets the cap of single users by user ID.
+	ChatMaxConcurrent          int
+	ChatMaxConcurrentOverrides map[string]int
+
+	// PersonaEvalConcurrency caps the probes a role evaluation runs at once and
+	// PersonaEvalTimeoutSeconds bounds the whole evaluation; it should stay below
+	// LongHandlerTimeoutSeconds so probes cut off are still reported.
+	PersonaEvalConcurrency    int
+	PersonaEvalTimeoutSeconds int
+
+	// QiniuBreakerThreshold consecutive failures of a Qiniu endpoint class (chat, asr, tts)
+	// open its circuit for QiniuBreakerCooldownSeconds; a threshold of 0 disables breaking.
+	QiniuBreakerThreshold       int
+	QiniuBreakerCooldownSeconds int
+
+	// MemoryTopK is how many remembered facts about a signed-in user are injected into each
+	// chat prompt; 0 turns memory off, including the extraction call after each reply.
+	MemoryTopK int
+
+	// QiniuNLPJSONMode sends response_format json_object upstream for structured chat
+	// replies; enable it only when the model supports JSON mode.
+	QiniuNLPJSONMode bool
+
+	// QiniuNLPTemperature, QiniuNLPMaxTokens and QiniuNLPTopP are the sampling parameters
+	// of chat requests that leave them unset; 0 leaves them to the provider.
+	QiniuNLPTemperature float64
+	QiniuNLPMaxTokens   int
+	QiniuNLPTopP        float64
+
+	// QiniuTokenMode is where requests take their Qiniu token from: "server" always uses
+	// QiniuAPIKey and ignores client tokens, "client" requires a client token and
+	// "either" (default) prefers a client token, falling back to QiniuAPIKey.
+	QiniuTokenMode string
+
+	// DebugPayloadLog records every upstream chat payload; without it only requests with
+	// X-Debug-Payload from an admin are recorded. Payloads are redacted of emails, phone
+	// numbers and DebugRedactPatterns matches, written to DebugPayloadLogPath (the server
+	// log when empty) and the last DebugPayloadBuffer kept for GET /api/admin/debug/requests.
+	DebugPayloadLog     bool
+	DebugPayloadLogPath string
+	DebugPayloadBuffer  int
+	// DebugRedactPatterns are regular expressions separated by ";;" in DEBUG_REDACT_PATTERNS.
+	DebugRedactPatterns []string
+
+	// TranscriptRedaction scrubs phone numbers, emails, ID card numbers and
+	// TranscriptRedactPatterns matches from conversation text before it reaches Mongo
+	// (remembered facts and ASR transcripts); replies sent to the client are untouched.
+	TranscriptRedaction bool
+	// TranscriptRedactPatterns are regular expressions separated by ";;" in
+	// TRANSCRIPT_REDACT_PATTERNS.
+	TranscriptRedactPatterns []string
+
+	// TTSBatchConcurrency is how many lines of a /api/audio/tts/batch request are
+	// synthesized at once.
+	TTSBatchConcurrency int
+
+	// EmbeddingModel enables role embeddings for GET /api/roles?search=semantic; empty
+	// disables them. The OpenAI-compatible /embeddings endpoint defaults to Qiniu's, called
+	// with QiniuAPIKey.
+	EmbeddingModel   string
+	EmbeddingBaseURL string
+	EmbeddingAPIKey  string
+
+	// RoleRecommendEmbedder adds embedding similarity to /api/roles/recommend: "bow"
+	// (default) compares bags of words, "none" matches keywords only.
+	RoleRecommendEmbedder string
+
+	// SuggestionTimeoutMS bounds the completion call proposing follow-up questions.
+	SuggestionTimeoutMS int
+
+	// SentimentClassifier scores how the user sounds in chat: "lexicon" (default) matches
+	// cue words, "llm" asks the chat model with QINIU_API_KEY, "none" turns it off.
+	SentimentClassifier string
+	// SentimentAutoSkillThreshold, in percent, is how negative the rolling sentiment must
+	// be for emo_stabilizer to turn itself on; 0 never turns it on.
+	SentimentAutoSkillThreshold int
+	// SentimentTimeoutMS bounds the llm sentiment call.
+	SentimentTimeoutMS int
+
+	// PromptGuard screens user messages for prompt injection: "quote" (default) wraps
+	// suspicious messages in untrusted-content markers, "always" wraps every message,
+	// "detect" only flags them and "off" skips detection.
+	PromptGuard string
+	// PromptGuardNormalize strips invisible characters and long punctuation runs from
+	// user messages.
+	PromptGuardNormalize bool
+
+	// PromptGlobalPrefix and PromptGlobalSuffix go before and after the role prompt of every
+	// chat, for deployment-wide guardrails. The File variants name files holding them
+	// instead, for multi-line text; files are read again on POST /api/admin/reload.
+	PromptGlobalPrefix     string
+	PromptGlobalPrefixFile string
+	PromptGlobalSuffix     string
+	PromptGlobalSuffixFile string
+
+	// BlobBackend selects where blobs (avatars, uploaded audio) are kept: "local" (default)
+	// writes under BlobDir, "s3" uses an S3-compatible bucket and "kodo" a Qiniu Kodo bucket
+	// through its S3-compatible API.
+	BlobBackend string
+	// BlobS3Endpoint, BlobS3Region, BlobS3Bucket and the keys configure the bucket of the s3
+	// and kodo backends; kodo derives the endpoint from the region when it is empty.
+	BlobS3Endpoint  string
+	BlobS3Region    string
+	BlobS3Bucket    string
+	BlobS3AccessKey string
+	BlobS3SecretKey string
+	// BlobS3PathStyle puts the bucket in the URL path instead of the host name.
+	BlobS3PathStyle bool
+	// BlobPublicBaseURL serves bucket objects publicly (a CDN domain or a public bucket);
+	// without it download URLs are presigned for BlobDownloadExpirySeconds.
+	BlobPublicBaseURL         string
+	BlobDownloadExpirySeconds int
+	// AudioUploadMaxBytes caps audio uploaded through POST /api/audio/uploads, whose
+	// presigned URLs stay valid for AudioUploadExpirySeconds.
+	AudioUploadMaxBytes      int
+	AudioUploadExpirySeconds int
+
+	// ChatProviders are OpenAI-compatible chat backends available besides Qiniu, listed by
+	// name in CHAT_PROVIDERS and configured by CHAT_PROVIDER_<NAME>_* variables.
+	ChatProviders []ChatProviderConfig
+	// ChatProviderDefault serves chat requests that do not select a provider.
+	ChatProviderDefault string
+}
+
+// ChatProviderConfig configures an OpenAI-compatible chat backend.
+type ChatProviderConfig struct {
+	Name    string
+	BaseURL string
+	Model   string
+	// APIKey authenticates every call; when empty the caller's token is sent instead.
+	APIKey string
+	// AuthHeader carries the credential as "<AuthScheme> <credential>", or the bare
+	// credential when AuthScheme is empty; an empty AuthHeader sends no credential.
+	AuthHeader string
+	AuthScheme string
+	// JSONMode sends response_format json_object for structured replies.
+	JSONMode bool
 }
 
 var (
@@ -37,33 +301,179 @@ func Load() (*Config, error) {
 			return
 		}
 
-		apiBase := strings.TrimSpace(os.Getenv("QINIU_API_BASE_URL"))
-		if apiBase == "" {
-			apiBase = strings.TrimSpace(os.Getenv("QINIU_API_ENDPOINT"))
-		}
-		if apiBase == "" {
-			apiBase = "https://openai.qiniu.com/v1"
-		}
-
-		cfg = &Config{
-			ServerAddr:        getEnv("SERVER_ADDR", ":8080"),
-			DBURL:             strings.TrimSpace(os.Getenv("DB_URL")),
-			MongoURI:          strings.TrimSpace(os.Getenv("MONGO_URI")),
-			RedisURL:          strings.TrimSpace(os.Getenv("REDIS_URL")),
-			QiniuAPIBaseURL:   strings.TrimRight(apiBase, "/"),
-			QiniuAPIKey:       strings.TrimSpace(os.Getenv("QINIU_API_KEY")),
-			QiniuTTSVoiceType: strings.TrimSpace(os.Getenv("QINIU_TTS_VOICE_TYPE")),
-			QiniuTTSFormat:    getEnv("QINIU_TTS_FORMAT", "mp3"),
-			QiniuASRModel:     getEnv("QINIU_ASR_MODEL", "asr"),
-			QiniuNLPModel:     getEnv("QINIU_NLP_MODEL", "doubao-1.5-vision-pro"),
-		}
-
+		cfg = FromEnv()
 		loadErr = cfg.validate()
 	})
 
 	return cfg, loadErr
 }
 
+// FromEnv reads the configuration from the process environment as Load does, but without
+// config/.env, caching or validation, for harnesses that fill in the rest themselves.
+func FromEnv() *Config {
+	apiBase := strings.TrimSpace(os.Getenv("QINIU_API_BASE_URL"))
+	if apiBase == "" {
+		apiBase = strings.TrimSpace(os.Getenv("QINIU_API_ENDPOINT"))
+	}
+	if apiBase == "" {
+		apiBase = "https://openai.qiniu.com/v1"
+	}
+
+	return &Config{
+		ServerAddr:        getEnv("SERVER_ADDR", ":8080"),
+		AdminToken:        strings.TrimSpace(os.Getenv("ADMIN_TOKEN")),
+		JWTSecret:         strings.TrimSpace(os.Getenv("JWT_SECRET")),
+		DBURL:             strings.TrimSpace(os.Getenv("DB_URL")),
+		DBReplicaURL:      strings.TrimSpace(os.Getenv("DB_REPLICA_URL")),
+		MongoURI:          strings.TrimSpace(os.Getenv("MONGO_URI")),
+		MongoDatabase:     getEnv("MONGO_DATABASE", "wwb_ai"),
+		RedisURL:          strings.TrimSpace(os.Getenv("REDIS_URL")),
+		QiniuAPIBaseURL:   strings.TrimRight(apiBase, "/"),
+		QiniuAPIKey:       strings.TrimSpace(os.Getenv("QINIU_API_KEY")),
+		QiniuTTSVoiceType: strings.TrimSpace(os.Getenv("QINIU_TTS_VOICE_TYPE")),
+		QiniuTTSFormat:    getEnv("QINIU_TTS_FORMAT", "mp3"),
+		QiniuASRModel:     getEnv("QINIU_ASR_MODEL", "asr"),
+		QiniuNLPModel:     getEnv("QINIU_NLP_MODEL", "doubao-1.5-vision-pro"),
+
+		RedisKeyPrefix: strings.TrimSpace(os.Getenv("REDIS_KEY_PREFIX")),
+
+		ASRPartialIntervalMS: getEnvInt("ASR_PARTIAL_INTERVAL_MS", 200),
+		ASRStorePartials:     getEnvBool("ASR_STORE_PARTIALS", false),
+		ASRResumeTTLSeconds:  getEnvInt("ASR_RESUME_TTL_SECONDS", 120),
+		ASRMaxStreams:        getEnvInt("ASR_MAX_STREAMS", 100),
+		ASRProvider:          strings.ToLower(getEnv("ASR_PROVIDER", "qiniu")),
+		ASROpenAIBaseURL:     getEnv("ASR_OPENAI_BASE_URL", ""),
+		ASROpenAIAPIKey:      getEnv("ASR_OPENAI_API_KEY", ""),
+		ASROpenAIModel:       getEnv("ASR_OPENAI_MODEL", "whisper-1"),
+		NLPMaxPromptTokens:   getEnvInt("NLP_MAX_PROMPT_TOKENS", 12000),
+		TTSMaxChars:          getEnvInt("TTS_MAX_CHARS", 300),
+		TTSProvider:          strings.ToLower(getEnv("TTS_PROVIDER", "qiniu")),
+		TTSOpenAIBaseURL:     getEnv("TTS_OPENAI_BASE_URL", ""),
+		TTSOpenAIAPIKey:      getEnv("TTS_OPENAI_API_KEY", ""),
+		TTSOpenAIModel:       getEnv("TTS_OPENAI_MODEL", "tts-1"),
+		TTSOpenAIVoice:       getEnv("TTS_OPENAI_VOICE", "alloy"),
+		TTSOpenAIVoices:      splitList(getEnv("TTS_OPENAI_VOICES", "alloy,ash,coral,echo,fable,nova,onyx,sage,shimmer")),
+		TTSVoiceAliases: map[string]map[string]string{
+			"qiniu":  parseAliases(getEnv("TTS_VOICE_ALIASES_QINIU", "")),
+			"openai": parseAliases(getEnv("TTS_VOICE_ALIASES_OPENAI", "")),
+		},
+		AudioRatePerMinute:  getEnvInt("AUDIO_RATE_PER_MINUTE", 30),
+		AudioRateBurst:      getEnvInt("AUDIO_RATE_BURST", 10),
+		RoleCacheTTLSeconds: getEnvInt("ROLE_CACHE_TTL_SECONDS", 60),
+		BlobDir:             getEnv("BLOB_DIR", "data/uploads"),
+		AvatarMaxBytes:      getEnvInt("AVATAR_MAX_BYTES", 2<<20),
+
+		RoleByIDCacheTTLSeconds: getEnvInt("ROLE_BY_ID_CACHE_TTL_SECONDS", 30),
+		RoleReloadSeconds:       getEnvInt("ROLE_RELOAD_SECONDS", 0),
+
+		RoleUsageFlushSeconds:  getEnvInt("ROLE_USAGE_FLUSH_SECONDS", 30),
+		RoleUsageHalfLifeHours: getEnvInt("ROLE_USAGE_HALF_LIFE_HOURS", 72),
+		AccessTokenTTLMinutes:  getEnvInt("ACCESS_TOKEN_TTL_MINUTES", 15),
+		RefreshTokenTTLHours:   getEnvInt("REFRESH_TOKEN_TTL_HOURS", 720),
+
+		LoginLockoutThreshold:   getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+		LoginIPLockoutThreshold: getEnvInt("LOGIN_IP_LOCKOUT_THRESHOLD", 20),
+		LoginLockoutSeconds:     getEnvInt("LOGIN_LOCKOUT_SECONDS", 60),
+		LoginLockoutMaxSeconds:  getEnvInt("LOGIN_LOCKOUT_MAX_SECONDS", 3600),
+
+		EmailVerifyURL:        getEnv("EMAIL_VERIFY_URL", "http://localhost:5173/verify-email"),
+		EmailVerifyTTLMinutes: getEnvInt("EMAIL_VERIFY_TTL_MINUTES", 1440),
+
+		ReadyMongoRequired:     getEnvBool("READY_MONGO_REQUIRED", false),
+		ReadyRedisRequired:     getEnvBool("READY_REDIS_REQUIRED", false),
+		DependencyCheckSeconds: getEnvInt("DEPENDENCY_CHECK_SECONDS", 5),
+		MetricsUsername:        getEnv("METRICS_USERNAME", ""),
+		MetricsPassword:        getEnv("METRICS_PASSWORD", ""),
+
+		MaxBodyBytes:              getEnvInt("MAX_BODY_BYTES", 1<<20),
+		UploadMaxBytes:            getEnvInt("UPLOAD_MAX_BYTES", 32<<20),
+		HandlerTimeoutSeconds:     getEnvInt("HANDLER_TIMEOUT_SECONDS", 30),
+		LongHandlerTimeoutSeconds: getEnvInt("LONG_HANDLER_TIMEOUT_SECONDS", 120),
+
+		HTTPReadHeaderTimeoutSeconds: getEnvInt("HTTP_READ_HEADER_TIMEOUT_SECONDS", 10),
+		HTTPReadTimeoutSeconds:       getEnvInt("HTTP_READ_TIMEOUT_SECONDS", 60),
+		HTTPWriteTimeoutSeconds:      getEnvInt("HTTP_WRITE_TIMEOUT_SECONDS", 150),
+		HTTPIdleTimeoutSeconds:       getEnvInt("HTTP_IDLE_TIMEOUT_SECONDS", 120),
+
+		HTTPCompressMinBytes: getEnvInt("HTTP_COMPRESS_MIN_BYTES", 1024),
+
+		DBAutoMigrate: getEnvBool("DB_AUTO_MIGRATE", false),
+		DBSlowQueryMS: getEnvInt("DB_SLOW_QUERY_MS", 200),
+
+		QuotaTokensPerMonth:        getEnvInt("QUOTA_TOKENS_PER_MONTH", 0),
+		QuotaTTSCharactersPerMonth: getEnvInt("QUOTA_TTS_CHARACTERS_PER_MONTH", 0),
+		QuotaASRMinutesPerMonth:    getEnvInt("QUOTA_ASR_MINUTES_PER_MONTH", 0),
+		QuotaReconcileSeconds:      getEnvInt("QUOTA_RECONCILE_SECONDS", 300),
+
+		ChatMaxConcurrent:          getEnvInt("CHAT_MAX_CONCURRENT", 4),
+		ChatMaxConcurrentOverrides: parseLimits(getEnv("CHAT_MAX_CONCURRENT_OVERRIDES", "")),
+
+		PersonaEvalConcurrency:    getEnvInt("PERSONA_EVAL_CONCURRENCY", 2),
+		PersonaEvalTimeoutSeconds: getEnvInt("PERSONA_EVAL_TIMEOUT_SECONDS", 90),
+
+		QiniuBreakerThreshold:       getEnvInt("QINIU_BREAKER_THRESHOLD", 5),
+		QiniuBreakerCooldownSeconds: getEnvInt("QINIU_BREAKER_COOLDOWN_SECONDS", 30),
+
+		MemoryTopK: getEnvInt("MEMORY_TOP_K", 0),
+
+		QiniuNLPJSONMode: getEnvBool("QINIU_NLP_JSON_MODE", false),
+
+		QiniuNLPTemperature: getEnvFloat("QINIU_NLP_TEMPERATURE", 0),
+		QiniuNLPMaxTokens:   getEnvInt("QINIU_NLP_MAX_TOKENS", 0),
+		QiniuNLPTopP:        getEnvFloat("QINIU_NLP_TOP_P", 0),
+
+		NLPHistoryStrategy:    strings.ToLower(getEnv("NLP_HISTORY_STRATEGY", "recent")),
+		NLPHistoryTokenBudget: getEnvInt("NLP_HISTORY_TOKEN_BUDGET", 0),
+
+		QiniuTokenMode: strings.ToLower(getEnv("QINIU_TOKEN_MODE", "either")),
+
+		DebugPayloadLog:     getEnvBool("DEBUG_PAYLOAD_LOG", false),
+		DebugPayloadLogPath: getEnv("DEBUG_PAYLOAD_LOG_PATH", ""),
+		DebugPayloadBuffer:  getEnvInt("DEBUG_PAYLOAD_BUFFER", 200),
+		DebugRedactPatterns: splitPatterns(os.Getenv("DEBUG_REDACT_PATTERNS")),
+
+		TranscriptRedaction:      getEnvBool("TRANSCRIPT_REDACTION", true),
+		TranscriptRedactPatterns: splitPatterns(os.Getenv("TRANSCRIPT_REDACT_PATTERNS")),
+
+		TTSBatchConcurrency: getEnvInt("TTS_BATCH_CONCURRENCY", 4),
+
+		EmbeddingModel:   strings.TrimSpace(os.Getenv("EMBEDDING_MODEL")),
+		EmbeddingBaseURL: strings.TrimRight(getEnv("EMBEDDING_BASE_URL", apiBase), "/"),
+		EmbeddingAPIKey:  getEnv("EMBEDDING_API_KEY", strings.TrimSpace(os.Getenv("QINIU_API_KEY"))),
+
+		RoleRecommendEmbedder: strings.ToLower(getEnv("ROLE_RECOMMEND_EMBEDDER", "bow")),
+
+		SuggestionTimeoutMS: getEnvInt("SUGGESTION_TIMEOUT_MS", 5000),
+
+		SentimentClassifier:         strings.ToLower(getEnv("SENTIMENT_CLASSIFIER", "lexicon")),
+		SentimentAutoSkillThreshold: getEnvInt("SENTIMENT_AUTO_SKILL_THRESHOLD", 40),
+		SentimentTimeoutMS:          getEnvInt("SENTIMENT_TIMEOUT_MS", 2000),
+
+		PromptGuard:          strings.ToLower(getEnv("PROMPT_GUARD", "quote")),
+		PromptGuardNormalize: getEnvBool("PROMPT_GUARD_NORMALIZE", true),
+
+		PromptGlobalPrefix:     getEnv("PROMPT_GLOBAL_PREFIX", ""),
+		PromptGlobalPrefixFile: getEnv("PROMPT_GLOBAL_PREFIX_FILE", ""),
+		PromptGlobalSuffix:     getEnv("PROMPT_GLOBAL_SUFFIX", ""),
+		PromptGlobalSuffixFile: getEnv("PROMPT_GLOBAL_SUFFIX_FILE", ""),
+
+		BlobBackend:               strings.ToLower(getEnv("BLOB_BACKEND", "local")),
+		BlobS3Endpoint:            strings.TrimRight(getEnv("BLOB_S3_ENDPOINT", ""), "/"),
+		BlobS3Region:              getEnv("BLOB_S3_REGION", ""),
+		BlobS3Bucket:              getEnv("BLOB_S3_BUCKET", ""),
+		BlobS3AccessKey:           getEnv("BLOB_S3_ACCESS_KEY", ""),
+		BlobS3SecretKey:           getEnv("BLOB_S3_SECRET_KEY", ""),
+		BlobS3PathStyle:           getEnvBool("BLOB_S3_PATH_STYLE", false),
+		BlobPublicBaseURL:         strings.TrimRight(getEnv("BLOB_PUBLIC_BASE_URL", ""), "/"),
+		BlobDownloadExpirySeconds: getEnvInt("BLOB_DOWNLOAD_EXPIRY_SECONDS", 3600),
+		AudioUploadMaxBytes:       getEnvInt("AUDIO_UPLOAD_MAX_BYTES", 100<<20),
+		AudioUploadExpirySeconds:  getEnvInt("AUDIO_UPLOAD_EXPIRY_SECONDS", 900),
+
+		ChatProviders:       loadChatProviders(getEnv("CHAT_PROVIDERS", "")),
+		ChatProviderDefault: strings.ToLower(getEnv("CHAT_PROVIDER_DEFAULT", "qiniu")),
+	}
+}
+
 func loadEnvFiles() error {
 	if err := godotenv.Load("config/.env"); err != nil {
 		var pathErr *fs.PathError
@@ -93,13 +503,310 @@ func (c *Config) validate() error {
 		missing = append(missing, "REDIS_URL")
 	}
 
+	for _, provider := range c.ChatProviders {
+		prefix := chatProviderPrefix(provider.Name)
+		if provider.BaseURL == "" {
+			missing = append(missing, prefix+"BASE_URL")
+		}
+		if provider.Model == "" {
+			missing = append(missing, prefix+"MODEL")
+		}
+	}
+
+	switch c.ASRProvider {
+	case "qiniu":
+	case "openai":
+		if c.ASROpenAIBaseURL == "" {
+			missing = append(missing, "ASR_OPENAI_BASE_URL")
+		}
+	default:
+		return fmt.Errorf("ASR_PROVIDER must be qiniu or openai, got %q", c.ASRProvider)
+	}
+
+	switch c.QiniuTokenMode {
+	case "either", "client":
+	case "server":
+		if c.QiniuAPIKey == "" {
+			missing = append(missing, "QINIU_API_KEY")
+		}
+	default:
+		return fmt.Errorf("QINIU_TOKEN_MODE must be server, client or either, got %q", c.QiniuTokenMode)
+	}
+
+	for _, pattern := range c.DebugRedactPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("DEBUG_REDACT_PATTERNS: %w", err)
+		}
+	}
+	for _, pattern := range c.TranscriptRedactPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("TRANSCRIPT_REDACT_PATTERNS: %w", err)
+		}
+	}
+
+	switch c.RoleRecommendEmbedder {
+	case "bow", "none":
+	default:
+		return fmt.Errorf("ROLE_RECOMMEND_EMBEDDER must be bow or none, got %q", c.RoleRecommendEmbedder)
+	}
+
+	switch c.SentimentClassifier {
+	case "lexicon", "llm", "none":
+	default:
+		return fmt.Errorf("SENTIMENT_CLASSIFIER must be lexicon, llm or none, got %q", c.SentimentClassifier)
+	}
+	if c.SentimentAutoSkillThreshold < 0 || c.SentimentAutoSkillThreshold > 100 {
+		return fmt.Errorf("SENTIMENT_AUTO_SKILL_THRESHOLD must be between 0 and 100, got %d", c.SentimentAutoSkillThreshold)
+	}
+
+	if c.HTTPCompressMinBytes < 0 {
+		return fmt.Errorf("HTTP_COMPRESS_MIN_BYTES must not be negative, got %d", c.HTTPCompressMinBytes)
+	}
+
+	if c.ChatMaxConcurrent < 0 {
+		return fmt.Errorf("CHAT_MAX_CONCURRENT must not be negative, got %d", c.ChatMaxConcurrent)
+	}
+
+	if c.PersonaEvalConcurrency <= 0 {
+		return fmt.Errorf("PERSONA_EVAL_CONCURRENCY must be positive, got %d", c.PersonaEvalConcurrency)
+	}
+
+	if c.PersonaEvalTimeoutSeconds <= 0 {
+		return fmt.Errorf("PERSONA_EVAL_TIMEOUT_SECONDS must be positive, got %d", c.PersonaEvalTimeoutSeconds)
+	}
+
+	if c.QiniuNLPTemperature < 0 || c.QiniuNLPTemperature > 2 {
+		return fmt.Errorf("QINIU_NLP_TEMPERATURE must be between 0 and 2, got %g", c.QiniuNLPTemperature)
+	}
+	if c.QiniuNLPMaxTokens < 0 {
+		return fmt.Errorf("QINIU_NLP_MAX_TOKENS must not be negative, got %d", c.QiniuNLPMaxTokens)
+	}
+	if c.QiniuNLPTopP < 0 || c.QiniuNLPTopP > 1 {
+		return fmt.Errorf("QINIU_NLP_TOP_P must be between 0 and 1, got %g", c.QiniuNLPTopP)
+	}
+
+	switch c.NLPHistoryStrategy {
+	case "recent", "importance":
+	default:
+		return fmt.Errorf("NLP_HISTORY_STRATEGY must be recent or importance, got %q", c.NLPHistoryStrategy)
+	}
+	if c.NLPHistoryTokenBudget < 0 {
+		return fmt.Errorf("NLP_HISTORY_TOKEN_BUDGET must not be negative, got %d", c.NLPHistoryTokenBudget)
+	}
+
+	switch c.PromptGuard {
+	case "quote", "always", "detect", "off":
+	default:
+		return fmt.Errorf("PROMPT_GUARD must be quote, always, detect or off, got %q", c.PromptGuard)
+	}
+
+	if c.PromptGlobalPrefix != "" && c.PromptGlobalPrefixFile != "" {
+		return errors.New("set PROMPT_GLOBAL_PREFIX or PROMPT_GLOBAL_PREFIX_FILE, not both")
+	}
+	if c.PromptGlobalSuffix != "" && c.PromptGlobalSuffixFile != "" {
+		return errors.New("set PROMPT_GLOBAL_SUFFIX or PROMPT_GLOBAL_SUFFIX_FILE, not both")
+	}
+	if _, _, err := c.GlobalPrompt(); err != nil {
+		return err
+	}
+
+	switch c.BlobBackend {
+	case "local":
+	case "s3", "kodo":
+		if c.BlobS3Endpoint == "" && c.BlobBackend == "s3" {
+			missing = append(missing, "BLOB_S3_ENDPOINT")
+		}
+		if c.BlobS3Region == "" {
+			missing = append(missing, "BLOB_S3_REGION")
+		}
+		if c.BlobS3Bucket == "" {
+			missing = append(missing, "BLOB_S3_BUCKET")
+		}
+		if c.BlobS3AccessKey == "" || c.BlobS3SecretKey == "" {
+			missing = append(missing, "BLOB_S3_ACCESS_KEY/BLOB_S3_SECRET_KEY")
+		}
+	default:
+		return fmt.Errorf("BLOB_BACKEND must be local, s3 or kodo, got %q", c.BlobBackend)
+	}
+
+	switch c.TTSProvider {
+	case "qiniu":
+	case "openai":
+		if c.TTSOpenAIBaseURL == "" {
+			missing = append(missing, "TTS_OPENAI_BASE_URL")
+		}
+	default:
+		return fmt.Errorf("TTS_PROVIDER must be qiniu or openai, got %q", c.TTSProvider)
+	}
+
 	if len(missing) > 0 {
 		return fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
 	}
 
+	known := c.ChatProviderDefault == "qiniu"
+	for _, provider := range c.ChatProviders {
+		if provider.Name == "qiniu" {
+			return errors.New("CHAT_PROVIDERS must not list qiniu, it is configured by the QINIU_* variables")
+		}
+		known = known || provider.Name == c.ChatProviderDefault
+	}
+	if !known {
+		return fmt.Errorf("CHAT_PROVIDER_DEFAULT %q is not a configured chat provider", c.ChatProviderDefault)
+	}
+
 	return nil
 }
 
+// loadChatProviders reads the providers named in the comma-separated list names. The
+// auth header and scheme default to "Authorization" and "Bearer"; "none" clears them.
+func loadChatProviders(names string) []ChatProviderConfig {
+	var providers []ChatProviderConfig
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		prefix := chatProviderPrefix(name)
+		providers = append(providers, ChatProviderConfig{
+			Name:       name,
+			BaseURL:    getEnv(prefix+"BASE_URL", ""),
+			Model:      getEnv(prefix+"MODEL", ""),
+			APIKey:     getEnv(prefix+"API_KEY", ""),
+			AuthHeader: noneToEmpty(getEnv(prefix+"AUTH_HEADER", "Authorization")),
+			AuthScheme: noneToEmpty(getEnv(prefix+"AUTH_SCHEME", "Bearer")),
+			JSONMode:   getEnvBool(prefix+"JSON_MODE", false),
+		})
+	}
+	return providers
+}
+
+func chatProviderPrefix(name string) string {
+	return "CHAT_PROVIDER_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name)) + "_"
+}
+
+// splitList splits a comma-separated list, dropping blank entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitPatterns splits regular expressions on ";;", which unlike a comma cannot be part
+// of a useful pattern.
+func splitPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ";;") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// parseAliases reads "from=to" pairs separated by commas; entries without both names
+// are skipped.
+func parseAliases(value string) map[string]string {
+	aliases := make(map[string]string)
+	for _, entry := range splitList(value) {
+		from, to, ok := strings.Cut(entry, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if ok && from != "" && to != "" {
+			aliases[from] = to
+		}
+	}
+	return aliases
+}
+
+// parseLimits reads "user_id=limit" pairs separated by commas; entries whose limit is not
+// a non-negative integer are skipped.
+func parseLimits(value string) map[string]int {
+	limits := make(map[string]int)
+	for from, to := range parseAliases(value) {
+		if limit, err := strconv.Atoi(to); err == nil && limit >= 0 {
+			limits[from] = limit
+		}
+	}
+	return limits
+}
+
+func noneToEmpty(value string) string {
+	if strings.EqualFold(value, "none") {
+		return ""
+	}
+	return value
+}
+
+func getEnvInt(key string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+// GlobalPrompt returns the global prompt prefix and suffix, reading the files that hold
+// them when set.
+func (c *Config) GlobalPrompt() (prefix, suffix string, err error) {
+	if prefix, err = readPromptText(c.PromptGlobalPrefix, c.PromptGlobalPrefixFile, "PROMPT_GLOBAL_PREFIX_FILE"); err != nil {
+		return "", "", err
+	}
+	if suffix, err = readPromptText(c.PromptGlobalSuffix, c.PromptGlobalSuffixFile, "PROMPT_GLOBAL_SUFFIX_FILE"); err != nil {
+		return "", "", err
+	}
+	return prefix, suffix, nil
+}
+
+func readPromptText(value, path, key string) (string, error) {
+	if path == "" {
+		return strings.TrimSpace(value), nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", key, err)
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
 func getEnv(key, fallback string) string {
 	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
 		return value
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// setRequired sets the variables validate insists on, so a test only varies the ones it
+// is about.
+func setRequired(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_URL", "postgres://localhost/test")
+	t.Setenv("MONGO_URI", "mongodb://localhost")
+	t.Setenv("REDIS_URL", "redis://localhost:6379")
+}
+
+func TestFromEnvSampling(t *testing.T) {
+	setRequired(t)
+	t.Setenv("QINIU_NLP_TEMPERATURE", "0.35")
+	t.Setenv("QINIU_NLP_MAX_TOKENS", "512")
+	t.Setenv("QINIU_NLP_TOP_P", " 0.9 ")
+
+	cfg := FromEnv()
+	if cfg.QiniuNLPTemperature != 0.35 || cfg.QiniuNLPMaxTokens != 512 || cfg.QiniuNLPTopP != 0.9 {
+		t.Errorf("sampling defaults %g/%d/%g, want 0.35/512/0.9", cfg.QiniuNLPTemperature, cfg.QiniuNLPMaxTokens, cfg.QiniuNLPTopP)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+}
+
+func TestValidateSamplingRanges(t *testing.T) {
+	cases := []struct {
+		name    string
+		key     string
+		value   string
+		wantErr string
+	}{
+		{"temperature unset", "QINIU_NLP_TEMPERATURE", "", ""},
+		{"temperature zero", "QINIU_NLP_TEMPERATURE", "0", ""},
+		{"temperature at the top", "QINIU_NLP_TEMPERATURE", "2", ""},
+		{"temperature negative", "QINIU_NLP_TEMPERATURE", "-0.1", "QINIU_NLP_TEMPERATURE must be between 0 and 2"},
+		{"temperature too high", "QINIU_NLP_TEMPERATURE", "2.5", "QINIU_NLP_TEMPERATURE must be between 0 and 2"},
+		{"max tokens zero", "QINIU_NLP_MAX_TOKENS", "0", ""},
+		{"max tokens negative", "QINIU_NLP_MAX_TOKENS", "-1", "QINIU_NLP_MAX_TOKENS must not be negative"},
+		{"top p at the top", "QINIU_NLP_TOP_P", "1", ""},
+		{"top p negative", "QINIU_NLP_TOP_P", "-0.5", "QINIU_NLP_TOP_P must be between 0 and 1"},
+		{"top p too high", "QINIU_NLP_TOP_P", "1.5", "QINIU_NLP_TOP_P must be between 0 and 1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setRequired(t)
+			t.Setenv(tc.key, tc.value)
+
+			err := FromEnv().validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("validate = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRequiresConnections(t *testing.T) {
+	setRequired(t)
+	t.Setenv("DB_URL", "")
+	t.Setenv("REDIS_URL", "")
+
+	err := FromEnv().validate()
+	if err == nil || !strings.Contains(err.Error(), "DB_URL") || !strings.Contains(err.Error(), "REDIS_URL") || strings.Contains(err.Error(), "MONGO_URI") {
+		t.Fatalf("validate = %v, want DB_URL and REDIS_URL reported missing", err)
+	}
+}
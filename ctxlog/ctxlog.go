@@ -0,0 +1,38 @@
+// Package ctxlog carries the request ID through a context so every layer handling a
+// request can tag its log lines with it.
+package ctxlog
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// Header is the HTTP header the request ID is read from, returned in and forwarded to
+// upstream APIs with.
+const Header = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// From returns logger tagged with the request ID of ctx, or logger itself when ctx has
+// none.
+func From(ctx context.Context, logger *zap.SugaredLogger) *zap.SugaredLogger {
+	if id := RequestID(ctx); id != "" && logger != nil {
+		return logger.With("request_id", id)
+	}
+	return logger
+}
@@ -0,0 +1,130 @@
+package ctxlog_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/handlers"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+func TestFrom(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core).Sugar()
+
+	ctxlog.From(context.Background(), logger).Info("untagged")
+	ctx := ctxlog.WithRequestID(context.Background(), "req-1")
+	ctxlog.From(ctx, logger).Info("tagged")
+
+	entries := logs.All()
+	if _, ok := entries[0].ContextMap()["request_id"]; ok {
+		t.Error("a line without a request ID was tagged")
+	}
+	if got := entries[1].ContextMap()["request_id"]; got != "req-1" {
+		t.Errorf("request_id = %v, want req-1", got)
+	}
+	if ctxlog.RequestID(nil) != "" || ctxlog.From(ctx, nil) != nil {
+		t.Error("a nil context or logger is not passed through")
+	}
+}
+
+// TestRequestIDPropagation sends a chat through the RequestID middleware, NLPHandler and
+// NLPService to a failing upstream, and checks the request ID is echoed to the client,
+// forwarded upstream and tagged on the log lines of both the handler and the service.
+func TestRequestIDPropagation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var mu sync.Mutex
+	var upstreamIDs []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		upstreamIDs = append(upstreamIDs, r.Header.Get(ctxlog.Header))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"message":"model overloaded"}}`))
+	}))
+	defer upstream.Close()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core).Sugar()
+	cfg := &config.Config{QiniuAPIBaseURL: upstream.URL, QiniuAPIKey: "server-key", QiniuTokenMode: "server"}
+	roles := db.NewMemoryRoleRepository(models.Role{ID: 1, Name: "Socrates", Languages: []string{"en"}})
+	h := handlers.NewNLPHandler(cfg, roles, nil, services.NewNLPService(cfg, logger.Named("nlp_service")), nil, nil, nil, nil, nil, logger.Named("nlp_handler"))
+
+	router := gin.New()
+	router.Use(handlers.RequestID())
+	router.POST("/api/nlp/chat", h.HandleChat)
+
+	cases := []struct {
+		name   string
+		sent   string
+		reused bool
+	}{
+		{"client request ID", "client-req-42", true},
+		{"generated request ID", "", false},
+		{"unsafe request ID replaced", "bad id\nwith newline", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs.TakeAll()
+			mu.Lock()
+			upstreamIDs = nil
+			mu.Unlock()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/nlp/chat", strings.NewReader(`{"role_id":1,"messages":[{"role":"user","content":"Hello?"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.sent != "" {
+				req.Header.Set(ctxlog.Header, tc.sent)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code == http.StatusOK {
+				t.Fatalf("chat succeeded against a failing upstream: %s", rec.Body)
+			}
+			id := rec.Header().Get(ctxlog.Header)
+			if id == "" || (id == tc.sent) != tc.reused {
+				t.Fatalf("response request ID = %q for %q sent, want reused: %t", id, tc.sent, tc.reused)
+			}
+
+			mu.Lock()
+			forwarded := append([]string(nil), upstreamIDs...)
+			mu.Unlock()
+			if len(forwarded) == 0 {
+				t.Fatal("upstream never called")
+			}
+			for _, got := range forwarded {
+				if got != id {
+					t.Errorf("upstream got request ID %q, want %q", got, id)
+				}
+			}
+
+			tagged := map[string]bool{}
+			for _, entry := range logs.All() {
+				if entry.Level < zapcore.WarnLevel {
+					continue
+				}
+				if got := entry.ContextMap()["request_id"]; got != id {
+					t.Errorf("%s logged %q with request_id %v, want %q", entry.LoggerName, entry.Message, got, id)
+				}
+				tagged[entry.LoggerName] = true
+			}
+			if !tagged["nlp_handler"] || !tagged["nlp_service"] {
+				t.Errorf("warnings logged by %v, want both the handler and the service", tagged)
+			}
+		})
+	}
+}
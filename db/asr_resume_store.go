@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// ASRStreamSnapshot is the externalized state of a streaming ASR session: enough to
+// reopen an equivalent upstream stream on any instance and replay the final segments.
+// Interim (non-final) text is deliberately not captured.
+type ASRStreamSnapshot struct {
+	SessionID  string    `json:"session_id"`
+	UserID     string    `json:"user_id,omitempty"`
+	RoleID     int64     `json:"role_id,omitempty"`
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Bits       int       `json:"bits"`
+	Language   string    `json:"language,omitempty"`
+	Hotwords   []string  `json:"hotwords,omitempty"`
+	Segments   []string  `json:"segments"`
+	StartedAt  time.Time `json:"started_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ASRResumeStore keeps short-lived ASR stream snapshots in Redis keyed by session id.
+type ASRResumeStore struct {
+	kv  *kv.Store
+	ttl time.Duration
+}
+
+// NewASRResumeStore builds a store whose snapshots expire after ttl of inactivity.
+func NewASRResumeStore(store *kv.Store, ttl time.Duration) *ASRResumeStore {
+	if ttl <= 0 {
+		ttl = 2 * time.Minute
+	}
+	return &ASRResumeStore{kv: store, ttl: ttl}
+}
+
+// TTL reports how long an untouched snapshot survives.
+func (s *ASRResumeStore) TTL() time.Duration {
+	return s.ttl
+}
+
+// Save writes snapshot and resets its expiry.
+func (s *ASRResumeStore) Save(ctx context.Context, snapshot ASRStreamSnapshot) error {
+	if snapshot.SessionID == "" {
+		return errors.New("snapshot session id is empty")
+	}
+	snapshot.UpdatedAt = time.Now().UTC()
+
+	if err := s.kv.SetJSON(ctx, s.kv.Key(kv.ASRResume, snapshot.SessionID), snapshot, s.ttl); err != nil {
+		return fmt.Errorf("store asr snapshot: %w", err)
+	}
+	return nil
+}
+
+// Load fetches the snapshot for sessionID. The boolean is false when it expired or never existed.
+func (s *ASRResumeStore) Load(ctx context.Context, sessionID string) (ASRStreamSnapshot, bool, error) {
+	var snapshot ASRStreamSnapshot
+
+	found, err := s.kv.GetJSON(ctx, s.kv.Key(kv.ASRResume, sessionID), &snapshot)
+	if err != nil {
+		return snapshot, false, fmt.Errorf("load asr snapshot: %w", err)
+	}
+	return snapshot, found, nil
+}
+
+// Delete drops the snapshot once a session ends cleanly.
+func (s *ASRResumeStore) Delete(ctx context.Context, sessionID string) error {
+	if err := s.kv.Del(ctx, s.kv.Key(kv.ASRResume, sessionID)); err != nil {
+		return fmt.Errorf("delete asr snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/redact"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	asrSessionCollection = "asr_sessions"
+	asrSessionQueueSize  = 256
+	asrSessionWriteLimit = 5 * time.Second
+	// asrSessionDeleteBatch bounds the documents one delete removes, so wiping a long
+	// history does not hold the collection for long.
+	asrSessionDeleteBatch = 500
+)
+
+// ASRSessionStore archives ASR sessions in MongoDB. Saves are queued and written by Run
+// in the background so the live transcription path never waits on (or fails because of) Mongo.
+type ASRSessionStore struct {
+	collection *mongo.Collection
+	queue      chan models.ASRSession
+	redactor   *redact.Redactor
+	available  func() bool
+	logger     *zap.SugaredLogger
+}
+
+// NewASRSessionStore binds the store to the asr_sessions collection of database.
+func NewASRSessionStore(client *mongo.Client, database string, logger *zap.SugaredLogger) *ASRSessionStore {
+	return &ASRSessionStore{
+		collection: client.Database(database).Collection(asrSessionCollection),
+		queue:      make(chan models.ASRSession, asrSessionQueueSize),
+		logger:     logger,
+	}
+}
+
+// RedactWith makes the store scrub transcripts with redactor before writing them.
+func (s *ASRSessionStore) RedactWith(redactor *redact.Redactor) {
+	s.redactor = redactor
+}
+
+// TrackAvailability makes the store fail fast with ErrUnavailable while available reports
+// Mongo down; sessions finished meanwhile are dropped rather than queued behind an outage.
+func (s *ASRSessionStore) TrackAvailability(available func() bool) {
+	s.available = available
+}
+
+// Enqueue schedules a session for persistence. It never blocks; when the queue is full
+// the session is dropped and a warning logged.
+func (s *ASRSessionStore) Enqueue(session models.ASRSession) {
+	select {
+	case s.queue <- session:
+	default:
+		s.logger.Warnf("asr session queue full, dropping session %s", session.SessionID)
+	}
+}
+
+// Run drains the write queue until ctx is cancelled, then flushes what is left.
+func (s *ASRSessionStore) Run(ctx context.Context) error {
+	for {
+		select {
+		case session := <-s.queue:
+			s.write(context.Background(), session)
+		case <-ctx.Done():
+			for {
+				select {
+				case session := <-s.queue:
+					s.write(context.Background(), session)
+				default:
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// ListByUser returns the most recent sessions for userID, newest first.
+func (s *ASRSessionStore) ListByUser(ctx context.Context, userID string, limit int) ([]models.ASRSession, error) {
+	if userID == "" {
+		return nil, errors.New("user id is required")
+	}
+	if err := checkAvailable(s.available); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := s.collection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("query asr sessions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	sessions := make([]models.ASRSession, 0, limit)
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, fmt.Errorf("decode asr sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// DeleteByUser removes every archived session of userID in batches and returns how many
+// were removed, including those removed before an error.
+func (s *ASRSessionStore) DeleteByUser(ctx context.Context, userID string) (int64, error) {
+	if userID == "" {
+		return 0, errors.New("user id is required")
+	}
+	if err := checkAvailable(s.available); err != nil {
+		return 0, err
+	}
+	var deleted int64
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(asrSessionDeleteBatch)
+	for {
+		cursor, err := s.collection.Find(ctx, bson.M{"user_id": userID}, opts)
+		if err != nil {
+			return deleted, fmt.Errorf("query asr sessions: %w", err)
+		}
+		var batch []struct {
+			ID string `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &batch); err != nil {
+			return deleted, fmt.Errorf("decode asr sessions: %w", err)
+		}
+		if len(batch) == 0 {
+			return deleted, nil
+		}
+		ids := make([]string, len(batch))
+		for i, doc := range batch {
+			ids[i] = doc.ID
+		}
+		result, err := s.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return deleted, fmt.Errorf("delete asr sessions: %w", err)
+		}
+		deleted += result.DeletedCount
+		if len(batch) < asrSessionDeleteBatch {
+			return deleted, nil
+		}
+	}
+}
+
+func (s *ASRSessionStore) write(parent context.Context, session models.ASRSession) {
+	if err := checkAvailable(s.available); err != nil {
+		s.logger.Warnf("persist asr session %s skipped: %v", session.SessionID, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(parent, asrSessionWriteLimit)
+	defer cancel()
+
+	session = s.redact(session)
+	opts := options.Replace().SetUpsert(true)
+	if _, err := s.collection.ReplaceOne(ctx, bson.M{"_id": session.SessionID}, session, opts); err != nil {
+		s.logger.Warnf("persist asr session %s failed: %v", session.SessionID, err)
+	}
+}
+
+// redact scrubs the transcript and utterances of session, copying what it changes so the
+// caller's session is left as it was.
+func (s *ASRSessionStore) redact(session models.ASRSession) models.ASRSession {
+	if s.redactor == nil {
+		return session
+	}
+	session.Transcript = s.redactor.Redact(session.Transcript)
+	utterances := make([]models.ASRUtterance, len(session.Utterances))
+	for i, utterance := range session.Utterances {
+		utterance.Text = s.redactor.Redact(utterance.Text)
+		utterance.Partials = s.redactor.RedactAll(append([]string(nil), utterance.Partials...))
+		utterances[i] = utterance
+	}
+	if session.Utterances != nil {
+		session.Utterances = utterances
+	}
+	return session
+}
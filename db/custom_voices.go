@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrCustomVoiceNotFound is returned for a voice_type with no custom_voices row.
+var ErrCustomVoiceNotFound = errors.New("custom voice not found")
+
+// CustomVoice is a voice cloned upstream, optionally made for one role.
+type CustomVoice struct {
+	VoiceType string `json:"voice_type"`
+	Name      string `json:"name"`
+	AudioURL  string `json:"audio_url"`
+	RoleID    *int64 `json:"role_id,omitempty"`
+	// Status is pending, ready or failed; Message explains a failure.
+	Status    string    `json:"status"`
+	Message   string    `json:"message,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CustomVoiceStore persists custom voices.
+type CustomVoiceStore interface {
+	// Create inserts voice, replacing a record left behind for the same voice_type.
+	Create(ctx context.Context, voice CustomVoice) (*CustomVoice, error)
+	// Get returns voiceType, or ErrCustomVoiceNotFound.
+	Get(ctx context.Context, voiceType string) (*CustomVoice, error)
+	// List returns the custom voices, newest first; status filters them when set.
+	List(ctx context.Context, status string) ([]CustomVoice, error)
+	// SetStatus records the upstream state of voiceType and returns the updated voice.
+	SetStatus(ctx context.Context, voiceType, status, message string) (*CustomVoice, error)
+	// Delete removes voiceType, or returns ErrCustomVoiceNotFound.
+	Delete(ctx context.Context, voiceType string) error
+}
+
+// PgCustomVoiceStore keeps custom voices in the custom_voices table.
+type PgCustomVoiceStore struct {
+	pools *PoolRouter
+}
+
+// NewPgCustomVoiceStore builds a store reading and writing through pools.
+func NewPgCustomVoiceStore(pools *PoolRouter) *PgCustomVoiceStore {
+	return &PgCustomVoiceStore{pools: pools}
+}
+
+const customVoiceColumns = `voice_type, name, audio_url, role_id, status, message, created_by, created_at, updated_at`
+
+func scanCustomVoice(row pgx.Row) (*CustomVoice, error) {
+	var voice CustomVoice
+	if err := row.Scan(&voice.VoiceType, &voice.Name, &voice.AudioURL, &voice.RoleID, &voice.Status,
+		&voice.Message, &voice.CreatedBy, &voice.CreatedAt, &voice.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &voice, nil
+}
+
+// Create inserts voice, replacing a row left behind for the same voice_type.
+func (s *PgCustomVoiceStore) Create(ctx context.Context, voice CustomVoice) (*CustomVoice, error) {
+	row := s.pools.Primary().QueryRow(ctx, `INSERT INTO custom_voices (voice_type, name, audio_url, role_id, status, message, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (voice_type) DO UPDATE SET name = EXCLUDED.name, audio_url = EXCLUDED.audio_url,
+			role_id = EXCLUDED.role_id, status = EXCLUDED.status, message = EXCLUDED.message,
+			created_by = EXCLUDED.created_by, created_at = now(), updated_at = now()
+		RETURNING `+customVoiceColumns,
+		voice.VoiceType, voice.Name, voice.AudioURL, voice.RoleID, voice.Status, voice.Message, voice.CreatedBy)
+	created, err := scanCustomVoice(row)
+	if err != nil {
+		return nil, fmt.Errorf("insert custom voice: %w", err)
+	}
+	return created, nil
+}
+
+// Get returns the custom voice voiceType.
+func (s *PgCustomVoiceStore) Get(ctx context.Context, voiceType string) (*CustomVoice, error) {
+	row := s.pools.Primary().QueryRow(ctx, `SELECT `+customVoiceColumns+` FROM custom_voices WHERE voice_type = $1`, voiceType)
+	voice, err := scanCustomVoice(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCustomVoiceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get custom voice: %w", err)
+	}
+	return voice, nil
+}
+
+// List returns the custom voices, newest first; status filters them when set.
+func (s *PgCustomVoiceStore) List(ctx context.Context, status string) ([]CustomVoice, error) {
+	pool := s.pools.Pool(ReadPreferenceReplica, "list custom voices")
+	rows, err := pool.Query(ctx, `SELECT `+customVoiceColumns+` FROM custom_voices
+		WHERE $1 = '' OR status = $1 ORDER BY created_at DESC`, status)
+	if err != nil {
+		return nil, fmt.Errorf("list custom voices: %w", err)
+	}
+	defer rows.Close()
+
+	voices := make([]CustomVoice, 0)
+	for rows.Next() {
+		voice, err := scanCustomVoice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan custom voice: %w", err)
+		}
+		voices = append(voices, *voice)
+	}
+	return voices, rows.Err()
+}
+
+// SetStatus records the upstream state of voiceType and returns the updated voice.
+func (s *PgCustomVoiceStore) SetStatus(ctx context.Context, voiceType, status, message string) (*CustomVoice, error) {
+	row := s.pools.Primary().QueryRow(ctx, `UPDATE custom_voices SET status = $2, message = $3, updated_at = now()
+		WHERE voice_type = $1 RETURNING `+customVoiceColumns, voiceType, status, message)
+	voice, err := scanCustomVoice(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCustomVoiceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("update custom voice: %w", err)
+	}
+	return voice, nil
+}
+
+// Delete removes voiceType.
+func (s *PgCustomVoiceStore) Delete(ctx context.Context, voiceType string) error {
+	tag, err := s.pools.Primary().Exec(ctx, `DELETE FROM custom_voices WHERE voice_type = $1`, voiceType)
+	if err != nil {
+		return fmt.Errorf("delete custom voice: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrCustomVoiceNotFound
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryCustomVoiceStore is an in-process CustomVoiceStore for handler tests and local demos
+// without Postgres.
+type MemoryCustomVoiceStore struct {
+	mu     sync.Mutex
+	voices map[string]CustomVoice
+	// order breaks ties between voices created within the same clock tick
+	order map[string]int64
+	seq   int64
+}
+
+// NewMemoryCustomVoiceStore returns an empty store.
+func NewMemoryCustomVoiceStore() *MemoryCustomVoiceStore {
+	return &MemoryCustomVoiceStore{voices: make(map[string]CustomVoice), order: make(map[string]int64)}
+}
+
+// Create implements CustomVoiceStore.
+func (s *MemoryCustomVoiceStore) Create(_ context.Context, voice CustomVoice) (*CustomVoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	voice.CreatedAt, voice.UpdatedAt = now, now
+	s.seq++
+	s.voices[voice.VoiceType] = voice
+	s.order[voice.VoiceType] = s.seq
+	return &voice, nil
+}
+
+// Get implements CustomVoiceStore.
+func (s *MemoryCustomVoiceStore) Get(_ context.Context, voiceType string) (*CustomVoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	voice, ok := s.voices[voiceType]
+	if !ok {
+		return nil, ErrCustomVoiceNotFound
+	}
+	return &voice, nil
+}
+
+// List implements CustomVoiceStore.
+func (s *MemoryCustomVoiceStore) List(_ context.Context, status string) ([]CustomVoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	voices := make([]CustomVoice, 0, len(s.voices))
+	for _, voice := range s.voices {
+		if status == "" || voice.Status == status {
+			voices = append(voices, voice)
+		}
+	}
+	sort.Slice(voices, func(i, j int) bool {
+		return s.order[voices[i].VoiceType] > s.order[voices[j].VoiceType]
+	})
+	return voices, nil
+}
+
+// SetStatus implements CustomVoiceStore.
+func (s *MemoryCustomVoiceStore) SetStatus(_ context.Context, voiceType, status, message string) (*CustomVoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	voice, ok := s.voices[voiceType]
+	if !ok {
+		return nil, ErrCustomVoiceNotFound
+	}
+	voice.Status, voice.Message, voice.UpdatedAt = status, message, time.Now()
+	s.voices[voiceType] = voice
+	return &voice, nil
+}
+
+// Delete implements CustomVoiceStore.
+func (s *MemoryCustomVoiceStore) Delete(_ context.Context, voiceType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.voices[voiceType]; !ok {
+		return ErrCustomVoiceNotFound
+	}
+	delete(s.voices, voiceType)
+	delete(s.order, voiceType)
+	return nil
+}
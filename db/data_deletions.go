@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DataDeletion is the audit record of a user erasing their data: the rows or documents
+// removed per store and the error of each store that failed.
+type DataDeletion struct {
+	UserID      string            `json:"user_id"`
+	Deleted     map[string]int64  `json:"deleted"`
+	Failed      map[string]string `json:"failed"`
+	RequestedAt time.Time         `json:"requested_at"`
+}
+
+// DataDeletionLog appends DataDeletion records to the data_deletions table.
+type DataDeletionLog struct {
+	pools *PoolRouter
+}
+
+// NewDataDeletionLog builds a log writing through pools.
+func NewDataDeletionLog(pools *PoolRouter) *DataDeletionLog {
+	return &DataDeletionLog{pools: pools}
+}
+
+// Record appends entry.
+func (l *DataDeletionLog) Record(ctx context.Context, entry DataDeletion) error {
+	if entry.Deleted == nil {
+		entry.Deleted = map[string]int64{}
+	}
+	if entry.Failed == nil {
+		entry.Failed = map[string]string{}
+	}
+	if entry.RequestedAt.IsZero() {
+		entry.RequestedAt = time.Now()
+	}
+	if _, err := l.pools.Primary().Exec(ctx, `INSERT INTO data_deletions (user_id, deleted, failed, requested_at)
+		VALUES ($1, $2, $3, $4)`, entry.UserID, entry.Deleted, entry.Failed, entry.RequestedAt); err != nil {
+		return fmt.Errorf("record data deletion: %w", err)
+	}
+	return nil
+}
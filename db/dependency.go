@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrUnavailable is returned by stores whose optional backing service was unreachable at
+// its last check, instead of waiting on a connection that is known to be down.
+var ErrUnavailable = errors.New("dependency unavailable")
+
+const dependencyPingTimeout = 2 * time.Second
+
+// Dependency tracks whether an optional service such as Mongo or Redis answered its last
+// ping. Its clients dial lazily, so the server starts without the service and features
+// that need it degrade until Monitor sees it come back. A nil Dependency is unavailable.
+type Dependency struct {
+	name    string
+	ping    func(context.Context) error
+	healthy atomic.Bool
+	logger  *zap.SugaredLogger
+
+	mu        sync.Mutex
+	recovered []func(context.Context)
+}
+
+// NewDependency returns a dependency checked with ping, unavailable until its first check.
+func NewDependency(name string, ping func(context.Context) error, logger *zap.SugaredLogger) *Dependency {
+	return &Dependency{name: name, ping: ping, logger: logger}
+}
+
+// Name returns the name the dependency was created with.
+func (d *Dependency) Name() string {
+	return d.name
+}
+
+// Available reports whether the last check succeeded.
+func (d *Dependency) Available() bool {
+	return d != nil && d.healthy.Load()
+}
+
+// OnRecover registers fn to run in its own goroutine each time the dependency becomes
+// available, including the first successful check.
+func (d *Dependency) OnRecover(fn func(context.Context)) {
+	d.mu.Lock()
+	d.recovered = append(d.recovered, fn)
+	d.mu.Unlock()
+}
+
+// Check pings the dependency once and records the result; it is the Ping of the
+// dependency's readiness check, so probes also notice a recovery early.
+func (d *Dependency) Check(ctx context.Context) error {
+	pingCtx, cancel := context.WithTimeout(ctx, dependencyPingTimeout)
+	err := d.ping(pingCtx)
+	cancel()
+
+	healthy := err == nil
+	if previous := d.healthy.Swap(healthy); previous == healthy {
+		return err
+	}
+	if !healthy {
+		d.logger.Warnf("%s unreachable, dependent features degraded: %v", d.name, err)
+		return err
+	}
+	d.logger.Infof("%s available; dependent features restored", d.name)
+	d.mu.Lock()
+	recovered := slices.Clone(d.recovered)
+	d.mu.Unlock()
+	for _, fn := range recovered {
+		go fn(context.WithoutCancel(ctx))
+	}
+	return nil
+}
+
+// Monitor checks the dependency every interval until ctx is done.
+func (d *Dependency) Monitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = d.Check(ctx)
+		}
+	}
+}
+
+// checkAvailable returns ErrUnavailable when available is set and reports the backing
+// service down.
+func checkAvailable(available func() bool) error {
+	if available != nil && !available() {
+		return ErrUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/redact"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	memoryCollection = "memories"
+	// memoryMaxPerRole bounds the facts kept per user and role; the least seen, oldest
+	// facts go first.
+	memoryMaxPerRole = 100
+)
+
+// memoryRank orders memories most relevant first: facts extracted from more exchanges,
+// then recently confirmed ones.
+var memoryRank = bson.D{{Key: "seen", Value: -1}, {Key: "updated_at", Value: -1}}
+
+// MemoryKey normalizes a fact for deduplication: case, spacing and punctuation are
+// ignored, so "用户叫小李。" and "用户叫小李" are one fact.
+func MemoryKey(fact string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(fact) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// MemoryStore keeps the facts roles remember about users in the memories collection,
+// one document per user, role and distinct fact.
+type MemoryStore struct {
+	collection *mongo.Collection
+	redactor   *redact.Redactor
+	available  func() bool
+}
+
+// NewMemoryStore binds the store to the memories collection of database.
+func NewMemoryStore(client *mongo.Client, database string) *MemoryStore {
+	return &MemoryStore{collection: client.Database(database).Collection(memoryCollection)}
+}
+
+// RedactWith makes Remember scrub facts with redactor before storing them.
+func (s *MemoryStore) RedactWith(redactor *redact.Redactor) {
+	s.redactor = redactor
+}
+
+// TrackAvailability makes the store fail fast with ErrUnavailable while available reports
+// Mongo down, instead of waiting for server selection to time out.
+func (s *MemoryStore) TrackAvailability(available func() bool) {
+	s.available = available
+}
+
+// EnsureIndexes creates the deduplication and ranking indexes.
+func (s *MemoryStore) EnsureIndexes(ctx context.Context) error {
+	if err := checkAvailable(s.available); err != nil {
+		return err
+	}
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "role_id", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: append(bson.D{{Key: "user_id", Value: 1}, {Key: "role_id", Value: 1}}, memoryRank...)},
+	})
+	if err != nil {
+		return fmt.Errorf("create memory indexes: %w", err)
+	}
+	return nil
+}
+
+// Remember stores facts for userID and roleID. A fact already known, after MemoryKey
+// normalization, is not stored again; it counts as seen once more instead. It returns how
+// many facts were new.
+func (s *MemoryStore) Remember(ctx context.Context, userID string, roleID int64, facts []string) (int, error) {
+	if userID == "" {
+		return 0, errors.New("user id is required")
+	}
+	if err := checkAvailable(s.available); err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	added := 0
+	for _, fact := range facts {
+		fact = strings.TrimSpace(s.redactor.Redact(fact))
+		key := MemoryKey(fact)
+		if key == "" {
+			continue
+		}
+		result, err := s.collection.UpdateOne(ctx,
+			bson.M{"user_id": userID, "role_id": roleID, "key": key},
+			bson.M{
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID().Hex(), "fact": fact, "created_at": now},
+				"$set":         bson.M{"updated_at": now},
+				"$inc":         bson.M{"seen": 1},
+			},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return added, fmt.Errorf("store memory: %w", err)
+		}
+		if result.UpsertedCount > 0 {
+			added++
+		}
+	}
+	if added > 0 {
+		if err := s.prune(ctx, userID, roleID); err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
+
+// prune drops the lowest ranked facts of userID and roleID beyond memoryMaxPerRole.
+func (s *MemoryStore) prune(ctx context.Context, userID string, roleID int64) error {
+	filter := bson.M{"user_id": userID, "role_id": roleID}
+	opts := options.Find().SetSort(memoryRank).SetSkip(memoryMaxPerRole).SetProjection(bson.M{"_id": 1})
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("query surplus memories: %w", err)
+	}
+	var surplus []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &surplus); err != nil {
+		return fmt.Errorf("decode surplus memories: %w", err)
+	}
+	if len(surplus) == 0 {
+		return nil
+	}
+	ids := make([]string, len(surplus))
+	for i, doc := range surplus {
+		ids[i] = doc.ID
+	}
+	if _, err := s.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return fmt.Errorf("delete surplus memories: %w", err)
+	}
+	return nil
+}
+
+// Top returns the limit most relevant facts of userID for roleID.
+func (s *MemoryStore) Top(ctx context.Context, userID string, roleID int64, limit int) ([]models.Memory, error) {
+	return s.find(ctx, bson.M{"user_id": userID, "role_id": roleID}, memoryRank, limit)
+}
+
+// List returns the facts of userID, newest first, for roleID or every role when roleID
+// is 0.
+func (s *MemoryStore) List(ctx context.Context, userID string, roleID int64, limit int) ([]models.Memory, error) {
+	filter := bson.M{"user_id": userID}
+	if roleID > 0 {
+		filter["role_id"] = roleID
+	}
+	return s.find(ctx, filter, bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: 1}}, limit)
+}
+
+func (s *MemoryStore) find(ctx context.Context, filter bson.M, sort bson.D, limit int) ([]models.Memory, error) {
+	if filter["user_id"] == "" {
+		return nil, errors.New("user id is required")
+	}
+	if err := checkAvailable(s.available); err != nil {
+		return nil, err
+	}
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetSort(sort).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, fmt.Errorf("query memories: %w", err)
+	}
+	memories := make([]models.Memory, 0, limit)
+	if err := cursor.All(ctx, &memories); err != nil {
+		return nil, fmt.Errorf("decode memories: %w", err)
+	}
+	return memories, nil
+}
+
+// Delete removes the fact id of userID; it returns mongo.ErrNoDocuments when userID has
+// no such fact.
+func (s *MemoryStore) Delete(ctx context.Context, userID, id string) error {
+	if err := checkAvailable(s.available); err != nil {
+		return err
+	}
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("delete memory: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteAll removes the facts of userID for roleID, or for every role when roleID is 0,
+// and returns how many were removed.
+func (s *MemoryStore) DeleteAll(ctx context.Context, userID string, roleID int64) (int64, error) {
+	if userID == "" {
+		return 0, errors.New("user id is required")
+	}
+	if err := checkAvailable(s.available); err != nil {
+		return 0, err
+	}
+	filter := bson.M{"user_id": userID}
+	if roleID > 0 {
+		filter["role_id"] = roleID
+	}
+	result, err := s.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("delete memories: %w", err)
+	}
+	return result.DeletedCount, nil
+}
+
+// DeleteByUser removes every fact of userID, for DELETE /api/me/data. Facts are capped
+// per role, so one delete is short enough.
+func (s *MemoryStore) DeleteByUser(ctx context.Context, userID string) (int64, error) {
+	return s.DeleteAll(ctx, userID, 0)
+}
@@ -0,0 +1,3 @@
+ALTER TABLE roles
+    DROP COLUMN IF EXISTS speed_ratio,
+    DROP COLUMN IF EXISTS voice_type;
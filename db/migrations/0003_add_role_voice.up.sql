@@ -0,0 +1,3 @@
+ALTER TABLE roles
+    ADD COLUMN IF NOT EXISTS voice_type TEXT,
+    ADD COLUMN IF NOT EXISTS speed_ratio DOUBLE PRECISION;
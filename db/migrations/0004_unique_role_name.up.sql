@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS roles_name_key ON roles (name);
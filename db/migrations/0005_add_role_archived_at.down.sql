@@ -0,0 +1,2 @@
+ALTER TABLE roles
+    DROP COLUMN IF EXISTS archived_at;
@@ -0,0 +1,2 @@
+ALTER TABLE roles
+    ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+ALTER TABLE roles
+    DROP COLUMN IF EXISTS voice_sample_url,
+    DROP COLUMN IF EXISTS avatar_url;
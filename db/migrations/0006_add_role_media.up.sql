@@ -0,0 +1,3 @@
+ALTER TABLE roles
+    ADD COLUMN IF NOT EXISTS avatar_url TEXT,
+    ADD COLUMN IF NOT EXISTS voice_sample_url TEXT;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS role_stats (
+    role_id INTEGER PRIMARY KEY REFERENCES roles (id) ON DELETE CASCADE,
+    score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    total_count BIGINT NOT NULL DEFAULT 0,
+    score_updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    featured BOOLEAN NOT NULL DEFAULT false
+);
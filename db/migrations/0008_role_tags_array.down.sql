@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS roles_tags_idx;
+
+ALTER TABLE roles
+    ALTER COLUMN tags DROP DEFAULT,
+    ALTER COLUMN tags TYPE VARCHAR(255) USING array_to_string(tags, ', ');
@@ -0,0 +1,15 @@
+-- tags were a free-form "a, b; c" string; store them as a normalized array so filters
+-- match whole tags and the distinct set can be listed
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_name = 'roles' AND column_name = 'tags') <> 'ARRAY' THEN
+        ALTER TABLE roles
+            ALTER COLUMN tags TYPE TEXT[]
+                USING COALESCE(array_remove(string_to_array(lower(regexp_replace(btrim(tags), '\s*[,;]\s*', ',', 'g')), ','), ''), '{}');
+    END IF;
+END $$;
+
+ALTER TABLE roles ALTER COLUMN tags SET DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS roles_tags_idx ON roles USING GIN (tags);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS roles_owner_name_key;
+DROP INDEX IF EXISTS roles_owner_id_idx;
+ALTER TABLE roles DROP COLUMN IF EXISTS owner_id;
+CREATE UNIQUE INDEX IF NOT EXISTS roles_name_key ON roles (name);
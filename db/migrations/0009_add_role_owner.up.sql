@@ -0,0 +1,8 @@
+-- roles with an owner are private to that user; NULL keeps a role in the public catalog
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS owner_id TEXT;
+
+CREATE INDEX IF NOT EXISTS roles_owner_id_idx ON roles (owner_id) WHERE owner_id IS NOT NULL;
+
+-- names stay unique within the public catalog and within each user's roles
+DROP INDEX IF EXISTS roles_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS roles_owner_name_key ON roles (COALESCE(owner_id, ''), name);
@@ -0,0 +1,2 @@
+-- the backfilled values are the column defaults, so there is nothing to undo
+SELECT 1;
@@ -0,0 +1,4 @@
+-- rows created before 0002 have NULL where the API expects the column defaults
+UPDATE roles SET languages = ARRAY['zh','en'] WHERE languages IS NULL;
+UPDATE roles SET skills = '[]'::jsonb WHERE skills IS NULL;
+UPDATE roles SET personality = '{}'::jsonb WHERE personality IS NULL;
@@ -0,0 +1,18 @@
+-- upstream usage per user, role, service and UTC day; rows are upserted in batches, so a
+-- row holds the running totals of that day rather than a single call. user_id is '' for
+-- anonymous callers and role_id 0 for calls without a role; neither references its table
+-- so usage outlives deleted users and roles
+CREATE TABLE IF NOT EXISTS usage_events (
+    user_id TEXT NOT NULL DEFAULT '',
+    role_id BIGINT NOT NULL DEFAULT 0,
+    service TEXT NOT NULL,
+    day DATE NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    tts_characters BIGINT NOT NULL DEFAULT 0,
+    asr_ms BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day, service, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS usage_events_day_idx ON usage_events (day);
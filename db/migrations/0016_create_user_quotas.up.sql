@@ -0,0 +1,10 @@
+-- per-user overrides of the monthly quotas; NULL keeps the configured default and 0 means
+-- unlimited
+CREATE TABLE IF NOT EXISTS user_quotas (
+    user_id TEXT PRIMARY KEY,
+    tokens_per_month BIGINT,
+    tts_characters_per_month BIGINT,
+    asr_minutes_per_month BIGINT,
+    updated_by TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
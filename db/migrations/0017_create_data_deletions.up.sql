@@ -0,0 +1,11 @@
+-- audit trail of users erasing their data: what was removed from each store and which
+-- stores failed, kept after the data itself is gone
+CREATE TABLE IF NOT EXISTS data_deletions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    deleted JSONB NOT NULL DEFAULT '{}',
+    failed JSONB NOT NULL DEFAULT '{}',
+    requested_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS data_deletions_user_idx ON data_deletions (user_id, requested_at);
@@ -0,0 +1,3 @@
+-- the extension stays, other tables may use it
+ALTER TABLE roles DROP COLUMN IF EXISTS embedding_model;
+ALTER TABLE roles DROP COLUMN IF EXISTS embedding;
@@ -0,0 +1,16 @@
+-- role embeddings for semantic search. pgvector is optional: where the extension is not
+-- available, or may not be created, the columns are skipped and searches stay lexical.
+-- The vector has no fixed dimension so any embedding model fits; embedding_model tells
+-- which model produced it, as vectors of different models do not compare.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'vector') THEN
+        CREATE EXTENSION IF NOT EXISTS vector;
+        ALTER TABLE roles ADD COLUMN IF NOT EXISTS embedding vector;
+        ALTER TABLE roles ADD COLUMN IF NOT EXISTS embedding_model TEXT;
+    ELSE
+        RAISE NOTICE 'pgvector is not available, role embeddings disabled';
+    END IF;
+EXCEPTION WHEN insufficient_privilege THEN
+    RAISE NOTICE 'not allowed to create the pgvector extension, role embeddings disabled';
+END $$;
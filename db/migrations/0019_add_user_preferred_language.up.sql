@@ -0,0 +1,2 @@
+-- the language replies default to for the user, an ISO 639-1 code or '' for none
+ALTER TABLE users ADD COLUMN IF NOT EXISTS preferred_language TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- custom voices cloned upstream from reference audio; voice_type is the provider's name for
+-- the voice, usable wherever a voice is chosen once status is 'ready'
+CREATE TABLE IF NOT EXISTS custom_voices (
+    voice_type TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    audio_url TEXT NOT NULL,
+    role_id BIGINT REFERENCES roles (id) ON DELETE SET NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    message TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,8 @@
+-- role usage batches already folded into role_stats, so a batch flushed again after its
+-- Redis hash failed to delete is skipped instead of counted twice
+CREATE TABLE IF NOT EXISTS role_stats_flushes (
+    batch_id TEXT PRIMARY KEY,
+    flushed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS role_stats_flushes_flushed_at_idx ON role_stats_flushes (flushed_at);
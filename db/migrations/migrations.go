@@ -0,0 +1,274 @@
+// Package migrations versions the Postgres schema. Each change is a pair of numbered SQL
+// files, NNNN_name.up.sql and NNNN_name.down.sql, embedded into the binary; applied
+// versions are recorded in the schema_migrations table.
+//
+// Every up migration is idempotent, so a database built with the older ad-hoc scripts is
+// adopted by simply running Up against it.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// lockKey is the advisory lock held while migrating, so instances starting together do not
+// apply the same migration twice.
+const lockKey = 7_305_218_331
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied, and when.
+type Status struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// All returns the embedded migrations ordered by version.
+func All() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := files.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	all := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		all = append(all, *m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all, nil
+}
+
+// Latest returns the highest embedded version.
+func Latest() (int, error) {
+	all, err := All()
+	if err != nil || len(all) == 0 {
+		return 0, err
+	}
+	return all[len(all)-1].Version, nil
+}
+
+// Up applies every migration that has not been applied yet, in version order, and returns
+// the versions it applied.
+func Up(ctx context.Context, pool *pgxpool.Pool) ([]int, error) {
+	latest, err := Latest()
+	if err != nil {
+		return nil, err
+	}
+	return To(ctx, pool, latest)
+}
+
+// To migrates the schema to target: pending migrations up to target are applied, and
+// applied migrations above it are rolled back newest first. It returns the versions it
+// applied or rolled back, in the order it did so. Each migration runs in its own
+// transaction together with its schema_migrations bookkeeping.
+func To(ctx context.Context, pool *pgxpool.Pool, target int) ([]int, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if target < 0 {
+		return nil, fmt.Errorf("invalid target version %d", target)
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return nil, fmt.Errorf("lock schema_migrations: %w", err)
+	}
+	defer func() {
+		// the session may be broken if ctx was canceled; the lock dies with it then
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+	}()
+
+	if err := ensureTable(ctx, conn.Conn()); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+
+	var done []int
+	for _, m := range all {
+		if m.Version > target || applied[m.Version] != nil {
+			continue
+		}
+		if err := run(ctx, conn.Conn(), m, m.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+			return done, err
+		}
+		done = append(done, m.Version)
+	}
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+		if m.Version <= target || applied[m.Version] == nil {
+			continue
+		}
+		if err := run(ctx, conn.Conn(), m, m.Down, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+			return done, err
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// Statuses lists every embedded migration with the time it was applied, nil when pending.
+func Statuses(ctx context.Context, pool *pgxpool.Pool) ([]Status, error) {
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	if err := ensureTable(ctx, conn.Conn()); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(all))
+	for _, m := range all {
+		statuses = append(statuses, Status{Version: m.Version, Name: m.Name, AppliedAt: applied[m.Version]})
+	}
+	return statuses, nil
+}
+
+// ErrUnknownVersion is returned by Forget for a version that is not embedded.
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// Forget removes versions from schema_migrations without running anything, so the next Up
+// applies them again. It is meant for scripts that drop tables by hand.
+func Forget(ctx context.Context, pool *pgxpool.Pool, versions ...int) error {
+	all, err := All()
+	if err != nil {
+		return err
+	}
+	known := make(map[int]bool, len(all))
+	for _, m := range all {
+		known[m.Version] = true
+	}
+	for _, version := range versions {
+		if !known[version] {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+	}
+	if _, err := pool.Exec(ctx, createTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	if _, err := pool.Exec(ctx, `DELETE FROM schema_migrations WHERE version = ANY($1)`, versions); err != nil {
+		return fmt.Errorf("forget migrations: %w", err)
+	}
+	return nil
+}
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+func ensureTable(ctx context.Context, conn *pgx.Conn) error {
+	if _, err := conn.Exec(ctx, createTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, conn *pgx.Conn) (map[int]*time.Time, error) {
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	applied := make(map[int]*time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("read schema_migrations: %w", err)
+		}
+		applied[version] = &at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func run(ctx context.Context, conn *pgx.Conn, m Migration, sql, record string, args ...any) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("migration %04d_%s: begin: %w", m.Version, m.Name, err)
+	}
+	defer tx.Rollback(ctx)
+
+	// no arguments, so pgx uses the simple protocol and the file may hold several statements
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
+		return fmt.Errorf("migration %04d_%s: record: %w", m.Version, m.Name, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("migration %04d_%s: commit: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
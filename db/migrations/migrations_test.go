@@ -0,0 +1,132 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestAllIsContiguous(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	for i, m := range all {
+		if m.Version != i+1 {
+			t.Errorf("migration %d is %04d_%s, want versions numbered from 1 without gaps", i, m.Version, m.Name)
+		}
+	}
+	latest, err := Latest()
+	if err != nil || latest != len(all) {
+		t.Errorf("Latest = %d, %v; want %d", latest, err, len(all))
+	}
+}
+
+// testPool connects to TEST_POSTGRES_DSN inside a fresh schema that is dropped afterwards,
+// skipping the test when the variable is unset.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	ctx := context.Background()
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+
+	admin, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(admin.Close)
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { _, _ = admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE") })
+
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// public stays on the path for extensions such as pgvector
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestUpDownRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool(t)
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := make([]int, len(all))
+	for i, m := range all {
+		versions[i] = m.Version
+	}
+
+	applied, err := Up(ctx, pool)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if !slices.Equal(applied, versions) {
+		t.Fatalf("Up applied %v, want %v", applied, versions)
+	}
+	if again, err := Up(ctx, pool); err != nil || len(again) != 0 {
+		t.Fatalf("second Up = %v, %v; want nothing to apply", again, err)
+	}
+	statuses, err := Statuses(ctx, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("migration %04d_%s is pending after Up", status.Version, status.Name)
+		}
+	}
+
+	// every up migration is idempotent, so a schema built outside the tracking is adopted
+	if err := Forget(ctx, pool, versions...); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if reapplied, err := Up(ctx, pool); err != nil || !slices.Equal(reapplied, versions) {
+		t.Fatalf("Up over an untracked schema = %v, %v", reapplied, err)
+	}
+
+	rolledBack, err := To(ctx, pool, 0)
+	if err != nil {
+		t.Fatalf("To(0): %v", err)
+	}
+	reversed := slices.Clone(versions)
+	slices.Reverse(reversed)
+	if !slices.Equal(rolledBack, reversed) {
+		t.Errorf("To(0) rolled back %v, want %v", rolledBack, reversed)
+	}
+	var tables int
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'`).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables are left after rolling every migration back", tables)
+	}
+
+	if applied, err := Up(ctx, pool); err != nil || !slices.Equal(applied, versions) {
+		t.Errorf("Up after a full rollback = %v, %v", applied, err)
+	}
+}
+
+func TestForgetRejectsUnknownVersion(t *testing.T) {
+	pool := testPool(t)
+	if err := Forget(context.Background(), pool, 9999); err == nil {
+		t.Error("Forget of an unknown version succeeded")
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// ASRUtterance is one recognized utterance within a streaming ASR session.
+type ASRUtterance struct {
+	Text       string    `json:"text" bson:"text"`
+	DurationMS int       `json:"duration_ms,omitempty" bson:"duration_ms,omitempty"`
+	Confidence float64   `json:"confidence,omitempty" bson:"confidence,omitempty"`
+	Partials   []string  `json:"partials,omitempty" bson:"partials,omitempty"`
+	FinalAt    time.Time `json:"final_at" bson:"final_at"`
+}
+
+// ASRSession is an archived transcription session kept for later review.
+type ASRSession struct {
+	SessionID  string         `json:"session_id" bson:"_id"`
+	UserID     string         `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	RoleID     int64          `json:"role_id,omitempty" bson:"role_id,omitempty"`
+	Language   string         `json:"language,omitempty" bson:"language,omitempty"`
+	StartedAt  time.Time      `json:"started_at" bson:"started_at"`
+	EndedAt    time.Time      `json:"ended_at" bson:"ended_at"`
+	DurationMS int            `json:"duration_ms" bson:"duration_ms"`
+	Transcript string         `json:"transcript" bson:"transcript"`
+	Utterances []ASRUtterance `json:"utterances,omitempty" bson:"utterances,omitempty"`
+}
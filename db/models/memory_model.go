@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Memory is a durable fact about a user that a role remembers across conversations, such
+// as the user's name or goals.
+type Memory struct {
+	ID     string `json:"id" bson:"_id"`
+	UserID string `json:"-" bson:"user_id"`
+	RoleID int64  `json:"role_id" bson:"role_id"`
+	Fact   string `json:"fact" bson:"fact"`
+	// Key is Fact normalized for deduplication.
+	Key string `json:"-" bson:"key"`
+	// Seen counts the exchanges the fact was extracted from.
+	Seen      int       `json:"seen" bson:"seen"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -1,16 +1,78 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
 
 // Role represents a character definition stored in the relational database.
 type Role struct {
 	ID          int64           `json:"id" db:"id"`
 	Name        string          `json:"name" db:"name"`
 	Domain      string          `json:"domain" db:"domain"`
-	Tags        string          `json:"tags" db:"tags"`
+	Tags        TagList         `json:"tags" db:"tags"`
 	Bio         string          `json:"bio" db:"bio"`
 	Personality json.RawMessage `json:"personality" db:"personality"`
 	Background  string          `json:"background" db:"background"`
 	Languages   []string        `json:"languages" db:"languages"`
 	Skills      json.RawMessage `json:"skills" db:"skills"`
+	VoiceType   string          `json:"voice_type,omitempty" db:"voice_type"`
+	SpeedRatio  float64         `json:"speed_ratio,omitempty" db:"speed_ratio"`
+	// AvatarURL and VoiceSampleURL point at the character's image and a short voice preview.
+	AvatarURL      string `json:"avatar_url,omitempty" db:"avatar_url"`
+	VoiceSampleURL string `json:"voice_sample_url,omitempty" db:"voice_sample_url"`
+	// OwnerID is the user who created a private role; public catalog roles have none.
+	OwnerID string `json:"owner_id,omitempty" db:"owner_id"`
+	// ArchivedAt is set when the role was soft-deleted; archived roles stay readable by ID
+	// so old conversations keep rendering.
+	ArchivedAt *time.Time `json:"archived_at,omitempty" db:"archived_at"`
+	Archived   bool       `json:"archived"`
+}
+
+// VisibleTo reports whether userID ("" for anonymous callers) may read and chat with the role.
+func (r Role) VisibleTo(userID string) bool {
+	return r.OwnerID == "" || r.OwnerID == userID
+}
+
+// TagList is the set of tags of a role, stored lowercase and without duplicates. It
+// decodes from a JSON array or, for older clients and bundles, a comma or semicolon
+// separated string.
+type TagList []string
+
+// ParseTags splits a comma or semicolon separated tag string and normalizes the result.
+func ParseTags(raw string) TagList {
+	return NormalizeTags(strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ';'
+	}))
+}
+
+// NormalizeTags trims and lowercases tags, dropping empty and repeated ones. It never
+// returns nil, so an empty list encodes as [].
+func NormalizeTags(tags []string) TagList {
+	normalized := make(TagList, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || slices.Contains(normalized, tag) {
+			continue
+		}
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// UnmarshalJSON accepts ["a", "b"] as well as "a, b".
+func (t *TagList) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		*t = ParseTags(raw)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = NormalizeTags(list)
+	return nil
 }
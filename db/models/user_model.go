@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// User is an account that can sign in and own private roles.
+type User struct {
+	ID            int64  `json:"id" db:"id"`
+	Username      string `json:"username" db:"username"`
+	Email         string `json:"email,omitempty" db:"email"`
+	EmailVerified bool   `json:"email_verified" db:"email_verified"`
+	PasswordHash  string `json:"-" db:"password_hash"`
+	// PreferredLanguage is the ISO 639-1 code replies default to, "" for none.
+	PreferredLanguage string    `json:"preferred_language" db:"preferred_language"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+}
+
+// VerificationToken is a mailed one-time token confirming that UserID owns Email. Only
+// the HMAC of the token is kept.
+type VerificationToken struct {
+	ID        int64      `db:"id"`
+	UserID    int64      `db:"user_id"`
+	Email     string     `db:"email"`
+	TokenHash []byte     `db:"token_hash"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// RefreshToken is a stored refresh token. Only the SHA-256 of the token is kept. Tokens
+// rotated from one login share a FamilyID, so a replayed token can revoke the whole chain.
+type RefreshToken struct {
+	ID         int64      `db:"id"`
+	UserID     int64      `db:"user_id"`
+	FamilyID   string     `db:"family_id"`
+	TokenHash  []byte     `db:"token_hash"`
+	Device     string     `db:"device"`
+	UserAgent  string     `db:"user_agent"`
+	IP         string     `db:"ip"`
+	CreatedAt  time.Time  `db:"created_at"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+	ReplacedAt *time.Time `db:"replaced_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
+
+// APIKey is a long-lived key a user hands to a server for programmatic access, limited
+// to Scopes. Only the SHA-256 of the key is kept; Prefix is its first characters.
+type APIKey struct {
+	ID         int64      `json:"id" db:"id"`
+	UserID     int64      `json:"-" db:"user_id"`
+	Label      string     `json:"label" db:"label"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	KeyHash    []byte     `json:"-" db:"key_hash"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
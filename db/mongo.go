@@ -8,15 +8,20 @@ import (
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-func NewMongoClient(ctx context.Context, uri string) (*mongo.Client, error) {
+// mongoServerSelectionTimeout bounds how long an operation waits for a reachable server,
+// so requests racing an outage fail quickly instead of after the driver's 30s default.
+const mongoServerSelectionTimeout = 5 * time.Second
+
+// OpenMongoClient returns a client for uri without waiting for the server; the driver
+// connects in the background and reconnects on its own.
+func OpenMongoClient(ctx context.Context, uri string) (*mongo.Client, error) {
 	if uri == "" {
 		return nil, errors.New("mongo connection uri is empty")
 	}
 
-	opts := options.Client().ApplyURI(uri)
+	opts := options.Client().ApplyURI(uri).SetServerSelectionTimeout(mongoServerSelectionTimeout)
 
 	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -25,14 +30,5 @@ func NewMongoClient(ctx context.Context, uri string) (*mongo.Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("connect to mongo: %w", err)
 	}
-
-	pingCtx, pingCancel := context.WithTimeout(ctx, 3*time.Second)
-	defer pingCancel()
-
-	if err := client.Ping(pingCtx, readpref.Primary()); err != nil {
-		_ = client.Disconnect(pingCtx)
-		return nil, fmt.Errorf("ping mongo: %w", err)
-	}
-
 	return client, nil
 }
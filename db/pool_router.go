@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// ReadPreference tells the PoolRouter which pool a query may be served from.
+type ReadPreference int
+
+const (
+	// ReadPreferencePrimary pins the query to the primary. Use it for writes and for
+	// reads that must observe a preceding write (read-your-writes).
+	ReadPreferencePrimary ReadPreference = iota
+	// ReadPreferenceReplica allows a possibly lagging replica; used by list and analytics paths.
+	ReadPreferenceReplica
+)
+
+func (p ReadPreference) String() string {
+	if p == ReadPreferenceReplica {
+		return "replica"
+	}
+	return "primary"
+}
+
+// PoolRouter selects between the primary pool and an optional read replica.
+// Replica-preferring reads fall back to the primary when no replica is configured
+// or the last health check failed.
+type PoolRouter struct {
+	primary        *pgxpool.Pool
+	replica        *pgxpool.Pool
+	replicaHealthy atomic.Bool
+	logger         *zap.SugaredLogger
+}
+
+// NewPoolRouter builds a router; replica may be nil.
+func NewPoolRouter(primary, replica *pgxpool.Pool, logger *zap.SugaredLogger) *PoolRouter {
+	router := &PoolRouter{primary: primary, replica: replica, logger: logger}
+	router.replicaHealthy.Store(replica != nil)
+	return router
+}
+
+// Primary returns the primary pool.
+func (r *PoolRouter) Primary() *pgxpool.Pool {
+	return r.primary
+}
+
+// Pool returns the pool that should serve a query with the given preference.
+// label identifies the query in debug logs.
+func (r *PoolRouter) Pool(pref ReadPreference, label string) *pgxpool.Pool {
+	served := ReadPreferencePrimary
+	pool := r.primary
+	if pref == ReadPreferenceReplica && r.replica != nil && r.replicaHealthy.Load() {
+		served = ReadPreferenceReplica
+		pool = r.replica
+	}
+
+	if r.logger != nil {
+		r.logger.Debugf("postgres %s pool serving %s (preferred %s)", served, label, pref)
+	}
+	return pool
+}
+
+// MonitorReplica pings the replica every interval and toggles routing until ctx is done.
+func (r *PoolRouter) MonitorReplica(ctx context.Context, interval time.Duration) {
+	if r.replica == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			err := r.replica.Ping(pingCtx)
+			cancel()
+
+			healthy := err == nil
+			if previous := r.replicaHealthy.Swap(healthy); previous != healthy && r.logger != nil {
+				if healthy {
+					r.logger.Infof("postgres replica recovered; routing replica reads to it again")
+				} else {
+					r.logger.Warnf("postgres replica unhealthy, falling back to primary: %v", err)
+				}
+			}
+		}
+	}
+}
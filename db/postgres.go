@@ -7,9 +7,17 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
 )
 
-func NewPostgresPool(ctx context.Context, url string) (*pgxpool.Pool, error) {
+// PostgresOptions tunes a pool. The zero value installs no tracer.
+type PostgresOptions struct {
+	// SlowQueryThreshold logs queries running at least this long to Logger; 0 disables it.
+	SlowQueryThreshold time.Duration
+	Logger             *zap.SugaredLogger
+}
+
+func NewPostgresPool(ctx context.Context, url string, opts PostgresOptions) (*pgxpool.Pool, error) {
 	if url == "" {
 		return nil, errors.New("postgres connection url is empty")
 	}
@@ -18,6 +26,9 @@ func NewPostgresPool(ctx context.Context, url string) (*pgxpool.Pool, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parse postgres config: %w", err)
 	}
+	if tracer := NewSlowQueryTracer(opts.SlowQueryThreshold, opts.Logger); tracer != nil {
+		cfg.ConnConfig.Tracer = tracer
+	}
 
 	dialCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
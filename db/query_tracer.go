@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"go.uber.org/zap"
+)
+
+// maxLoggedSQL bounds the statement text in slow query logs; bulk inserts can be huge.
+const maxLoggedSQL = 500
+
+// SlowQueryTracer is a pgx.QueryTracer that logs queries running longer than a threshold,
+// tagged with the request ID of the query's context. Arguments are never logged.
+type SlowQueryTracer struct {
+	threshold time.Duration
+	logger    *zap.SugaredLogger
+	now       func() time.Time
+}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	at  time.Time
+	sql string
+}
+
+// NewSlowQueryTracer returns a tracer logging queries slower than threshold, or nil when
+// threshold is not positive.
+func NewSlowQueryTracer(threshold time.Duration, logger *zap.SugaredLogger) *SlowQueryTracer {
+	if threshold <= 0 || logger == nil {
+		return nil
+	}
+	return &SlowQueryTracer{threshold: threshold, logger: logger, now: time.Now}
+}
+
+// TraceQueryStart records when the query started.
+func (t *SlowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{at: t.now(), sql: data.SQL})
+}
+
+// TraceQueryEnd logs the query when it took longer than the threshold.
+func (t *SlowQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	elapsed := t.now().Sub(start.at)
+	if elapsed < t.threshold {
+		return
+	}
+
+	fields := []any{
+		"duration_ms", elapsed.Milliseconds(),
+		"sql", truncateSQL(start.sql),
+		"rows", data.CommandTag.RowsAffected(),
+	}
+	if data.Err != nil {
+		fields = append(fields, "error", data.Err)
+	}
+	ctxlog.From(ctx, t.logger).Warnw("slow query", fields...)
+}
+
+// truncateSQL collapses whitespace so multi-line statements fit on one log line, then cuts
+// the text to maxLoggedSQL bytes.
+func truncateSQL(sql string) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) <= maxLoggedSQL {
+		return sql
+	}
+	cut := maxLoggedSQL
+	for cut > 0 && !utf8.RuneStart(sql[cut]) {
+		cut--
+	}
+	return sql[:cut] + "…"
+}
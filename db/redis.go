@@ -10,12 +10,20 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-func NewRedisClient(ctx context.Context, addr string) (*redis.Client, error) {
+// OpenRedisClient returns a client for addr without contacting the server. go-redis dials
+// lazily and per command, so the client starts working whenever the server is reachable.
+func OpenRedisClient(addr string) (*redis.Client, error) {
 	if strings.TrimSpace(addr) == "" {
 		return nil, errors.New("redis address is empty")
 	}
+	return redis.NewClient(&redis.Options{Addr: addr}), nil
+}
 
-	client := redis.NewClient(&redis.Options{Addr: addr})
+func NewRedisClient(ctx context.Context, addr string) (*redis.Client, error) {
+	client, err := OpenRedisClient(addr)
+	if err != nil {
+		return nil, err
+	}
 
 	pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	roleCacheTimeout = 300 * time.Millisecond
+	// roleEpochTTL bounds how long an invalidation is remembered; it only has to outlive
+	// the slowest load racing it.
+	roleEpochTTL = 10 * time.Minute
+)
+
+// fillRoleScript caches a loaded role unless the role was invalidated since the load began:
+// KEYS are the entry and its epoch, ARGV the role, the epoch read before loading and the TTL
+// in milliseconds.
+var fillRoleScript = redis.NewScript(`
+local epoch = redis.call('GET', KEYS[2]) or ''
+if epoch ~= ARGV[2] then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+return 1
+`)
+
+// invalidateRolesScript deletes cached roles and bumps their epochs: KEYS are entry and
+// epoch pairs, ARGV[1] the epoch TTL in milliseconds.
+var invalidateRolesScript = redis.NewScript(`
+for i = 1, #KEYS, 2 do
+  redis.call('DEL', KEYS[i])
+  redis.call('INCR', KEYS[i + 1])
+  redis.call('PEXPIRE', KEYS[i + 1], ARGV[1])
+end
+return 0
+`)
+
+// CachedRoleRepository is a RoleRepository serving GetByID from Redis, since every chat
+// turn looks its role up. Writes through it delete the cached entry; writes that bypass it
+// (scripts, other services) are only picked up once the short TTL expires. Concurrent
+// misses for the same ID share one database query, and Redis errors fall through to the
+// wrapped repository. Each invalidation bumps a per-role epoch in Redis, and a load only
+// caches its result when the epoch is unchanged, so a load that read the row before a
+// write cannot re-cache the old role after the write dropped it.
+type CachedRoleRepository struct {
+	RoleRepository
+
+	kv     *kv.Store
+	ttl    time.Duration
+	group  singleflight.Group
+	logger *zap.SugaredLogger
+}
+
+// NewCachedRoleRepository wraps repo, or returns repo itself when store is nil or ttl is
+// not positive.
+func NewCachedRoleRepository(repo RoleRepository, store *kv.Store, ttl time.Duration, logger *zap.SugaredLogger) RoleRepository {
+	if store == nil || ttl <= 0 {
+		return repo
+	}
+	return &CachedRoleRepository{RoleRepository: repo, kv: store, ttl: ttl, logger: logger}
+}
+
+// GetByID returns the cached role, loading and caching it on a miss. Lookups that fail,
+// including missing roles, are not cached.
+func (r *CachedRoleRepository) GetByID(ctx context.Context, id int64) (*models.Role, error) {
+	if role, ok := r.get(ctx, id); ok {
+		return role, nil
+	}
+
+	key := strconv.FormatInt(id, 10)
+	loaded, err, _ := r.group.Do(key, func() (interface{}, error) {
+		// detached so one caller giving up does not fail the others waiting on the result
+		loadCtx := context.WithoutCancel(ctx)
+		epoch, epochErr := r.epoch(loadCtx, id)
+		role, err := r.RoleRepository.GetByID(loadCtx, id)
+		if err != nil {
+			return nil, err
+		}
+		if epochErr == nil {
+			r.fill(loadCtx, role, epoch)
+		}
+		return role, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// callers may modify the role, so each gets its own copy
+	role := *loaded.(*models.Role)
+	return &role, nil
+}
+
+// Update updates the role and drops its cached entry.
+func (r *CachedRoleRepository) Update(ctx context.Context, role models.Role) (*models.Role, error) {
+	defer r.invalidate(ctx, role.ID)
+	return r.RoleRepository.Update(ctx, role)
+}
+
+// SetAvatar stores the avatar URL and drops the cached entry.
+func (r *CachedRoleRepository) SetAvatar(ctx context.Context, id int64, url string) (*models.Role, error) {
+	defer r.invalidate(ctx, id)
+	return r.RoleRepository.SetAvatar(ctx, id, url)
+}
+
+// Archive archives the role and drops its cached entry.
+func (r *CachedRoleRepository) Archive(ctx context.Context, id int64) error {
+	defer r.invalidate(ctx, id)
+	return r.RoleRepository.Archive(ctx, id)
+}
+
+// Delete deletes the role and drops its cached entry.
+func (r *CachedRoleRepository) Delete(ctx context.Context, id int64) error {
+	defer r.invalidate(ctx, id)
+	return r.RoleRepository.Delete(ctx, id)
+}
+
+// Import imports roles and drops the cached entries of the roles it updated.
+func (r *CachedRoleRepository) Import(ctx context.Context, roles []models.Role, dryRun bool) ([]RoleImportResult, error) {
+	results, err := r.RoleRepository.Import(ctx, roles, dryRun)
+	if err != nil || dryRun {
+		return results, err
+	}
+	ids := make([]int64, 0, len(results))
+	for _, result := range results {
+		if result.Action == RoleImportUpdated {
+			ids = append(ids, result.ID)
+		}
+	}
+	r.invalidate(ctx, ids...)
+	return results, nil
+}
+
+// Purge drops every cached role, so edits made outside the repository are served right
+// away, and returns how many entries it deleted.
+func (r *CachedRoleRepository) Purge(ctx context.Context) (int, error) {
+	if !r.kv.Available() {
+		return 0, kv.ErrUnavailable
+	}
+	purged := 0
+	iter := r.kv.Client().Scan(ctx, 0, r.kv.Pattern(kv.RoleByID), 200).Iterator()
+	keys := make([]string, 0, 200)
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		deleted, err := r.kv.Client().Del(ctx, keys...).Result()
+		purged += int(deleted)
+		keys = keys[:0]
+		return err
+	}
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == cap(keys) {
+			if err := flush(); err != nil {
+				return purged, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return purged, err
+	}
+	return purged, flush()
+}
+
+func (r *CachedRoleRepository) get(ctx context.Context, id int64) (*models.Role, bool) {
+	ctx, cancel := context.WithTimeout(ctx, roleCacheTimeout)
+	defer cancel()
+
+	body, found, err := r.kv.Get(ctx, r.key(id))
+	if err != nil || !found {
+		return nil, false
+	}
+	var role models.Role
+	if err := json.Unmarshal(body, &role); err != nil {
+		return nil, false
+	}
+	// a nil RawMessage encodes as null; restore it so cached roles match loaded ones
+	if string(role.Personality) == "null" {
+		role.Personality = nil
+	}
+	if string(role.Skills) == "null" {
+		role.Skills = nil
+	}
+	return &role, true
+}
+
+// epoch reads the invalidation epoch of a role, "" when it was never invalidated.
+func (r *CachedRoleRepository) epoch(ctx context.Context, id int64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, roleCacheTimeout)
+	defer cancel()
+	body, _, err := r.kv.Get(ctx, r.epochKey(id))
+	return string(body), err
+}
+
+// fill caches role unless it was invalidated after epoch was read.
+func (r *CachedRoleRepository) fill(ctx context.Context, role *models.Role, epoch string) {
+	body, err := json.Marshal(role)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, roleCacheTimeout)
+	defer cancel()
+	keys := []string{r.key(role.ID), r.epochKey(role.ID)}
+	filled, err := fillRoleScript.Run(ctx, r.kv.Client(), keys, body, epoch, r.ttl.Milliseconds()).Int()
+	switch {
+	case err != nil:
+		r.logger.Debugw("cache role failed", "role_id", role.ID, "error", err)
+	case filled == 0:
+		r.logger.Debugw("role invalidated while loading, not cached", "role_id", role.ID)
+	}
+}
+
+// invalidate deletes the entries even when the write failed, since a failed write may
+// still have been applied.
+func (r *CachedRoleRepository) invalidate(ctx context.Context, ids ...int64) {
+	if len(ids) == 0 {
+		return
+	}
+	keys := make([]string, 0, 2*len(ids))
+	for _, id := range ids {
+		keys = append(keys, r.key(id), r.epochKey(id))
+		// lookups arriving from now on must not share a load that began before the write
+		r.group.Forget(strconv.FormatInt(id, 10))
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), roleCacheTimeout)
+	defer cancel()
+	if !r.kv.Available() {
+		r.logger.Warnw("invalidate cached roles failed", "role_ids", ids, "error", kv.ErrUnavailable)
+		return
+	}
+	if err := invalidateRolesScript.Run(ctx, r.kv.Client(), keys, roleEpochTTL.Milliseconds()).Err(); err != nil {
+		r.logger.Warnw("invalidate cached roles failed", "role_ids", ids, "error", err)
+	}
+}
+
+func (r *CachedRoleRepository) key(id int64) string {
+	return r.kv.Key(kv.RoleByID, strconv.FormatInt(id, 10))
+}
+
+func (r *CachedRoleRepository) epochKey(id int64) string {
+	return r.kv.Key(kv.RoleEpoch, strconv.FormatInt(id, 10))
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// slowRoleRepository counts GetByID calls and, while hold is set, parks each one after it
+// has read the row until release is closed.
+type slowRoleRepository struct {
+	*MemoryRoleRepository
+
+	calls   atomic.Int32
+	hold    bool
+	loaded  chan struct{}
+	release chan struct{}
+}
+
+func newSlowRoleRepository(roles ...models.Role) *slowRoleRepository {
+	return &slowRoleRepository{
+		MemoryRoleRepository: NewMemoryRoleRepository(roles...),
+		loaded:               make(chan struct{}, 16),
+		release:              make(chan struct{}),
+	}
+}
+
+func (r *slowRoleRepository) GetByID(ctx context.Context, id int64) (*models.Role, error) {
+	r.calls.Add(1)
+	role, err := r.MemoryRoleRepository.GetByID(ctx, id)
+	if r.hold {
+		r.loaded <- struct{}{}
+		<-r.release
+	}
+	return role, err
+}
+
+func newTestRoleCache(t *testing.T, repo RoleRepository) *CachedRoleRepository {
+	t.Helper()
+	store, _ := newTestStore(t, "test")
+	return NewCachedRoleRepository(repo, store, time.Minute, zap.NewNop().Sugar()).(*CachedRoleRepository)
+}
+
+func TestCachedRoleConcurrentMissesShareOneLoad(t *testing.T) {
+	repo := newSlowRoleRepository(models.Role{ID: 1, Name: "Sage"})
+	repo.hold = true
+	cache := newTestRoleCache(t, repo)
+
+	const callers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			role, err := cache.GetByID(context.Background(), 1)
+			if err == nil && role.Name != "Sage" {
+				err = errors.New("got role " + role.Name)
+			}
+			errs <- err
+		}()
+	}
+	<-repo.loaded
+	// give the other callers time to join the load in flight
+	time.Sleep(50 * time.Millisecond)
+	close(repo.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if calls := repo.calls.Load(); calls != 1 {
+		t.Errorf("%d concurrent lookups made %d database calls, want 1", callers, calls)
+	}
+
+	if _, err := cache.GetByID(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if calls := repo.calls.Load(); calls != 1 {
+		t.Errorf("a cached lookup reached the database (%d calls)", calls)
+	}
+}
+
+func TestCachedRoleMissesAreNotCached(t *testing.T) {
+	repo := newSlowRoleRepository()
+	cache := newTestRoleCache(t, repo)
+	for range 2 {
+		if _, err := cache.GetByID(context.Background(), 7); !errors.Is(err, pgx.ErrNoRows) {
+			t.Fatalf("GetByID of a missing role = %v, want pgx.ErrNoRows", err)
+		}
+	}
+	if calls := repo.calls.Load(); calls != 2 {
+		t.Errorf("missing role looked up %d times, want 2", calls)
+	}
+}
+
+// TestCachedRoleLoadRacingWriteIsNotCached starts a load that reads the row, then updates the
+// role through another instance before the load finishes: the old role must not be cached.
+func TestCachedRoleLoadRacingWriteIsNotCached(t *testing.T) {
+	ctx := context.Background()
+	repo := newSlowRoleRepository(models.Role{ID: 1, Name: "Old"})
+	store, _ := newTestStore(t, "test")
+	reader := NewCachedRoleRepository(repo, store, time.Minute, zap.NewNop().Sugar())
+	writer := NewCachedRoleRepository(repo.MemoryRoleRepository, store, time.Minute, zap.NewNop().Sugar())
+
+	repo.hold = true
+	done := make(chan *models.Role)
+	go func() {
+		role, err := reader.GetByID(ctx, 1)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- role
+	}()
+	<-repo.loaded
+	if _, err := writer.Update(ctx, models.Role{ID: 1, Name: "New"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	close(repo.release)
+	if stale := <-done; stale.Name != "Old" {
+		t.Fatalf("racing load returned %q, want the row it read", stale.Name)
+	}
+
+	repo.hold = false
+	role, err := reader.GetByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.Name != "New" {
+		t.Errorf("GetByID after the update = %q, want New: the racing load cached the old role", role.Name)
+	}
+	if calls := repo.calls.Load(); calls != 2 {
+		t.Errorf("database calls = %d, want 2", calls)
+	}
+}
+
+func TestCachedRoleWritesInvalidate(t *testing.T) {
+	ctx := context.Background()
+	repo := newSlowRoleRepository(models.Role{ID: 1, Name: "Sage"})
+	cache := newTestRoleCache(t, repo)
+
+	if _, err := cache.GetByID(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Update(ctx, models.Role{ID: 1, Name: "Renamed"}); err != nil {
+		t.Fatal(err)
+	}
+	if role, err := cache.GetByID(ctx, 1); err != nil || role.Name != "Renamed" {
+		t.Fatalf("GetByID after Update = %v, %v", role, err)
+	}
+	if err := cache.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.GetByID(ctx, 1); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("GetByID after Delete = %v, want pgx.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// RoleEmbedder embeds texts with one model; services.EmbeddingService implements it.
+type RoleEmbedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Model() string
+}
+
+// EnableSemanticSearch lets SearchSemantic rank roles by the similarity of their stored
+// embeddings, produced by embedder, to the query. Without it, or before migration 0018
+// has added the embedding column, SearchSemantic is a lexical List.
+func (r *PgRoleRepository) EnableSemanticSearch(embedder RoleEmbedder) {
+	r.embedder = embedder
+}
+
+// RoleEmbeddingText is the text a role is embedded as.
+func RoleEmbeddingText(role models.Role) string {
+	return strings.Join([]string{role.Name, role.Bio, role.Background}, "\n")
+}
+
+// SearchSemantic returns up to filter.Limit roles matching filter, ordered by the cosine
+// distance of their embeddings to filter.Query. Roles without an embedding of the current
+// model are left out. It falls back to List, matching filter.Query with ILIKE, when
+// semantic search is not enabled, pgvector is missing or the query cannot be embedded.
+func (r *PgRoleRepository) SearchSemantic(ctx context.Context, filter RoleFilter) ([]models.Role, error) {
+	if r.embedder == nil || strings.TrimSpace(filter.Query) == "" {
+		return r.List(ctx, filter)
+	}
+	vectors, err := r.embedder.Embed(ctx, []string{filter.Query})
+	if err != nil || len(vectors) != 1 {
+		return r.List(ctx, filter)
+	}
+
+	semantic := filter
+	semantic.Query = ""
+	where, args := semantic.where(roleSchemaLatest)
+	clause := "embedding IS NOT NULL AND embedding_model = $" + strconv.Itoa(len(args)+1)
+	if where == "" {
+		where = " WHERE " + clause
+	} else {
+		where += " AND " + clause
+	}
+	args = append(args, r.embedder.Model(), vectorLiteral(vectors[0]))
+	tail := where + fmt.Sprintf(" ORDER BY embedding <=> $%d::vector, id", len(args))
+	if filter.Limit > 0 {
+		tail += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	pool := r.pools.Pool(ReadPreferenceReplica, "search roles")
+	roles, err := queryRolesWith(ctx, pool, roleSchemaLatest, tail, args)
+	if err != nil {
+		if isSchemaMismatch(err) || isMissingVector(err) {
+			return r.List(ctx, filter)
+		}
+		return nil, fmt.Errorf("search roles: %w", err)
+	}
+	return roles, nil
+}
+
+// UpdateEmbedding embeds the role with id and stores the vector. It does nothing when
+// semantic search is not enabled and returns an error wrapping pgx.ErrNoRows for a
+// missing role.
+func (r *PgRoleRepository) UpdateEmbedding(ctx context.Context, id int64) error {
+	if r.embedder == nil {
+		return nil
+	}
+	role, err := r.GetByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("load role %d: %w", id, err)
+	}
+	vectors, err := r.embedder.Embed(ctx, []string{RoleEmbeddingText(*role)})
+	if err != nil {
+		return fmt.Errorf("embed role %d: %w", id, err)
+	}
+	if len(vectors) != 1 {
+		return fmt.Errorf("embed role %d: got %d vectors", id, len(vectors))
+	}
+	_, err = r.pools.Primary().Exec(ctx, `UPDATE roles SET embedding = $1::vector, embedding_model = $2 WHERE id = $3`,
+		vectorLiteral(vectors[0]), r.embedder.Model(), id)
+	if err != nil {
+		return fmt.Errorf("store role %d embedding: %w", id, err)
+	}
+	return nil
+}
+
+// StaleEmbeddings lists the IDs of roles without an embedding of the current model, or
+// of every role with all, oldest first.
+func (r *PgRoleRepository) StaleEmbeddings(ctx context.Context, all bool) ([]int64, error) {
+	if r.embedder == nil {
+		return nil, errors.New("semantic search is not enabled")
+	}
+	query := `SELECT id FROM roles WHERE embedding IS NULL OR embedding_model IS DISTINCT FROM $1 ORDER BY id`
+	args := []interface{}{r.embedder.Model()}
+	if all {
+		query, args = `SELECT id FROM roles ORDER BY id`, nil
+	}
+	rows, err := r.pools.Primary().Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list stale role embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// vectorLiteral renders v in pgvector's text format, which casts to vector without the
+// pgvector Go types.
+func vectorLiteral(v []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, x := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// isMissingVector reports whether err comes from the vector type or its operators not
+// existing, that is pgvector not being installed.
+func isMissingVector(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == pgerrcode.UndefinedObject || pgErr.Code == pgerrcode.UndefinedFunction
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// RoleImportAction is what an import did, or would do, to one role.
+type RoleImportAction string
+
+const (
+	RoleImportCreated   RoleImportAction = "created"
+	RoleImportUpdated   RoleImportAction = "updated"
+	RoleImportUnchanged RoleImportAction = "unchanged"
+)
+
+// RoleImportResult reports the outcome for one imported role. ID is zero for roles a dry
+// run would create.
+type RoleImportResult struct {
+	Name   string           `json:"name"`
+	ID     int64            `json:"id,omitempty"`
+	Action RoleImportAction `json:"action"`
+}
+
+// Import upserts public roles by name inside one transaction, so a failure leaves the
+// table untouched. Archive state is not changed and private roles are never matched.
+func (r *PgRoleRepository) Import(ctx context.Context, roles []models.Role, dryRun bool) ([]RoleImportResult, error) {
+	tx, err := r.pools.Primary().Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("import roles: begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	results := make([]RoleImportResult, 0, len(roles))
+	for _, role := range roles {
+		existing, err := roleSchemaLatest.scan(tx.QueryRow(ctx, `SELECT `+roleSchemaLatest.columns()+` FROM roles WHERE name = $1 AND owner_id IS NULL FOR UPDATE`, role.Name))
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			result := RoleImportResult{Name: role.Name, Action: RoleImportCreated}
+			if !dryRun {
+				stored, err := roleSchemaLatest.scan(tx.QueryRow(ctx, insertRoleSQL+roleSchemaLatest.columns(), append(roleArgs(role), "")...))
+				if err != nil {
+					return nil, roleWriteError("import role "+role.Name, err)
+				}
+				result.ID = stored.ID
+			}
+			results = append(results, result)
+		case err != nil:
+			return nil, fmt.Errorf("import role %s: %w", role.Name, err)
+		case sameRoleDefinition(*existing, role):
+			results = append(results, RoleImportResult{Name: role.Name, ID: existing.ID, Action: RoleImportUnchanged})
+		default:
+			if !dryRun {
+				if _, err := tx.Exec(ctx, updateRoleSQL+"id", append(roleArgs(role), existing.ID)...); err != nil {
+					return nil, roleWriteError("import role "+role.Name, err)
+				}
+			}
+			results = append(results, RoleImportResult{Name: role.Name, ID: existing.ID, Action: RoleImportUpdated})
+		}
+	}
+
+	if dryRun {
+		return results, nil
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("import roles: commit: %w", err)
+	}
+	return results, nil
+}
+
+// Import implements RoleRepository; the whole batch is applied or none of it.
+func (r *MemoryRoleRepository) Import(_ context.Context, roles []models.Role, dryRun bool) ([]RoleImportResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byName := make(map[string]int64, len(r.roles))
+	for id, role := range r.roles {
+		if role.OwnerID == "" {
+			byName[role.Name] = id
+		}
+	}
+
+	staged := make(map[int64]models.Role)
+	nextID := r.nextID
+	results := make([]RoleImportResult, 0, len(roles))
+	for _, role := range roles {
+		role.Tags = models.NormalizeTags(role.Tags)
+		role.OwnerID = ""
+		id, ok := byName[role.Name]
+		if !ok {
+			result := RoleImportResult{Name: role.Name, Action: RoleImportCreated}
+			if !dryRun {
+				role.ID = nextID
+				nextID++
+				staged[role.ID] = role
+				byName[role.Name] = role.ID
+				result.ID = role.ID
+			}
+			results = append(results, result)
+			continue
+		}
+
+		existing, ok := staged[id]
+		if !ok {
+			existing = r.roles[id]
+		}
+		if sameRoleDefinition(existing, role) {
+			results = append(results, RoleImportResult{Name: role.Name, ID: id, Action: RoleImportUnchanged})
+			continue
+		}
+		role.ID = id
+		role.ArchivedAt, role.Archived = existing.ArchivedAt, existing.Archived
+		if role.AvatarURL == "" {
+			role.AvatarURL = existing.AvatarURL
+		}
+		if role.VoiceSampleURL == "" {
+			role.VoiceSampleURL = existing.VoiceSampleURL
+		}
+		staged[id] = role
+		results = append(results, RoleImportResult{Name: role.Name, ID: id, Action: RoleImportUpdated})
+	}
+
+	if !dryRun {
+		for id, role := range staged {
+			r.roles[id] = role
+		}
+		r.nextID = nextID
+	}
+	return results, nil
+}
+
+// sameRoleDefinition reports whether importing incoming over stored changes nothing.
+func sameRoleDefinition(stored, incoming models.Role) bool {
+	return len(RoleDefinitionDiff(stored, incoming)) == 0
+}
+
+// RoleDefinitionDiff lists, by JSON name, the importable fields an import of incoming would
+// change on stored. JSON columns are compared by value, and empty incoming media URLs
+// match anything, as the update statement keeps the stored ones.
+func RoleDefinitionDiff(stored, incoming models.Role) []string {
+	var changed []string
+	diff := func(field string, same bool) {
+		if !same {
+			changed = append(changed, field)
+		}
+	}
+	diff("name", stored.Name == incoming.Name)
+	diff("domain", stored.Domain == incoming.Domain)
+	diff("tags", slices.Equal(stored.Tags, models.NormalizeTags(incoming.Tags)))
+	diff("bio", stored.Bio == incoming.Bio)
+	diff("personality", sameJSON(stored.Personality, incoming.Personality))
+	diff("background", stored.Background == incoming.Background)
+	diff("languages", slices.Equal(stored.Languages, incoming.Languages))
+	diff("skills", sameJSON(stored.Skills, incoming.Skills))
+	diff("voice_type", stored.VoiceType == incoming.VoiceType)
+	diff("speed_ratio", stored.SpeedRatio == incoming.SpeedRatio)
+	diff("avatar_url", incoming.AvatarURL == "" || stored.AvatarURL == incoming.AvatarURL)
+	diff("voice_sample_url", incoming.VoiceSampleURL == "" || stored.VoiceSampleURL == incoming.VoiceSampleURL)
+	return changed
+}
+
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if err := json.Unmarshal(orNull(a), &va); err != nil {
+		return bytes.Equal(a, b)
+	}
+	if err := json.Unmarshal(orNull(b), &vb); err != nil {
+		return false
+	}
+	ca, _ := json.Marshal(va)
+	cb, _ := json.Marshal(vb)
+	return bytes.Equal(ca, cb)
+}
+
+func orNull(raw json.RawMessage) []byte {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return []byte("null")
+	}
+	return raw
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+const roleListCacheTimeout = 300 * time.Millisecond
+
+// RoleListCache stores serialized GET /api/roles responses in Redis. Entries are namespaced
+// by a version counter, so Invalidate drops every cached listing with a single INCR and the
+// stale entries simply expire. A nil cache never hits.
+type RoleListCache struct {
+	kv  *kv.Store
+	ttl time.Duration
+}
+
+// NewRoleListCache returns a cache with the given entry TTL, or nil when store is nil or
+// ttl is not positive.
+func NewRoleListCache(store *kv.Store, ttl time.Duration) *RoleListCache {
+	if store == nil || ttl <= 0 {
+		return nil
+	}
+	return &RoleListCache{kv: store, ttl: ttl}
+}
+
+// Get returns the cached body for key. Redis errors count as a miss.
+func (c *RoleListCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, roleListCacheTimeout)
+	defer cancel()
+
+	entryKey, err := c.entryKey(ctx, key)
+	if err != nil {
+		return nil, false
+	}
+	body, found, err := c.kv.Get(ctx, entryKey)
+	if err != nil || !found {
+		return nil, false
+	}
+	return body, true
+}
+
+// Set stores body under key for the cache TTL.
+func (c *RoleListCache) Set(ctx context.Context, key string, body []byte) error {
+	if c == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, roleListCacheTimeout)
+	defer cancel()
+
+	entryKey, err := c.entryKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	return c.kv.Set(ctx, entryKey, body, c.ttl)
+}
+
+// Invalidate drops every cached listing. Call it after any write to the roles table.
+func (c *RoleListCache) Invalidate(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	return invalidateRoleList(ctx, c.kv)
+}
+
+func (c *RoleListCache) entryKey(ctx context.Context, key string) (string, error) {
+	version, found, err := c.kv.Get(ctx, c.kv.Key(kv.RoleList, "version"))
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		version = []byte("0")
+	}
+	return c.kv.Key(kv.RoleList, "v"+string(version), key), nil
+}
+
+func invalidateRoleList(ctx context.Context, store *kv.Store) error {
+	ctx, cancel := context.WithTimeout(ctx, roleListCacheTimeout)
+	defer cancel()
+	if _, err := store.Incr(ctx, store.Key(kv.RoleList, "version")); err != nil {
+		return fmt.Errorf("invalidate role list cache: %w", err)
+	}
+	return nil
+}
+
+// InvalidateRoleListCache connects to the Redis at addr and drops the cached role listings
+// of the deployment whose keys start with prefix. It is meant for scripts that write the
+// roles table directly; an empty addr is a no-op.
+func InvalidateRoleListCache(ctx context.Context, addr, prefix string) error {
+	if strings.TrimSpace(addr) == "" {
+		return nil
+	}
+	client, err := NewRedisClient(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return invalidateRoleList(ctx, kv.New(client, prefix))
+}
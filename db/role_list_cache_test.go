@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// newTestStore returns a kv.Store backed by an in-process Redis that is shut down with the test.
+func newTestStore(t *testing.T, prefix string) (*kv.Store, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return kv.New(client, prefix), server
+}
+
+func TestRoleListCacheInvalidate(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t, "test")
+	cache := NewRoleListCache(store, time.Minute)
+
+	if _, ok := cache.Get(ctx, "all"); ok {
+		t.Fatal("empty cache reported a hit")
+	}
+	if err := cache.Set(ctx, "all", []byte(`[1]`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := cache.Set(ctx, "domain=philosophy", []byte(`[2]`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if body, ok := cache.Get(ctx, "all"); !ok || string(body) != `[1]` {
+		t.Fatalf("Get = %q, %t; want the stored listing", body, ok)
+	}
+
+	if err := cache.Invalidate(ctx); err != nil {
+		t.Fatalf("Invalidate: %v", err)
+	}
+	for _, key := range []string{"all", "domain=philosophy"} {
+		if body, ok := cache.Get(ctx, key); ok {
+			t.Errorf("Get(%q) after Invalidate = %q, want a miss", key, body)
+		}
+	}
+
+	if err := cache.Set(ctx, "all", []byte(`[3]`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if body, ok := cache.Get(ctx, "all"); !ok || string(body) != `[3]` {
+		t.Errorf("Get after refill = %q, %t; want the new listing", body, ok)
+	}
+}
+
+func TestRoleListCacheEntriesExpire(t *testing.T) {
+	ctx := context.Background()
+	store, server := newTestStore(t, "test")
+	cache := NewRoleListCache(store, time.Minute)
+
+	if err := cache.Set(ctx, "all", []byte(`[1]`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	server.FastForward(time.Minute + time.Second)
+	if _, ok := cache.Get(ctx, "all"); ok {
+		t.Error("entry outlived its TTL")
+	}
+}
+
+func TestRoleListCacheRedisDownIsMiss(t *testing.T) {
+	ctx := context.Background()
+	store, server := newTestStore(t, "test")
+	cache := NewRoleListCache(store, time.Minute)
+	if err := cache.Set(ctx, "all", []byte(`[1]`)); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	server.Close()
+	if _, ok := cache.Get(ctx, "all"); ok {
+		t.Error("Get hit while Redis was down")
+	}
+	if err := cache.Invalidate(ctx); err == nil {
+		t.Error("Invalidate succeeded while Redis was down")
+	}
+}
+
+func TestInvalidateRoleListCacheFromScript(t *testing.T) {
+	ctx := context.Background()
+	store, server := newTestStore(t, "deploy-a")
+	// a second deployment shares the Redis under its own prefix
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	other := kv.New(client, "deploy-b")
+	cache, otherCache := NewRoleListCache(store, time.Minute), NewRoleListCache(other, time.Minute)
+
+	for _, c := range []*RoleListCache{cache, otherCache} {
+		if err := c.Set(ctx, "all", []byte(`[1]`)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+	if err := InvalidateRoleListCache(ctx, server.Addr(), "deploy-a"); err != nil {
+		t.Fatalf("InvalidateRoleListCache: %v", err)
+	}
+	if _, ok := cache.Get(ctx, "all"); ok {
+		t.Error("listing of the invalidated deployment is still cached")
+	}
+	if _, ok := otherCache.Get(ctx, "all"); !ok {
+		t.Error("listing of another deployment was invalidated too")
+	}
+	if err := InvalidateRoleListCache(ctx, "", "deploy-a"); err != nil {
+		t.Errorf("InvalidateRoleListCache without an address = %v, want a no-op", err)
+	}
+}
+
+func TestNilRoleListCache(t *testing.T) {
+	ctx := context.Background()
+	if cache := NewRoleListCache(nil, time.Minute); cache != nil {
+		t.Fatal("NewRoleListCache without a store returned a cache")
+	}
+	var cache *RoleListCache
+	if err := cache.Set(ctx, "all", []byte(`[1]`)); err != nil {
+		t.Errorf("Set on nil cache = %v", err)
+	}
+	if _, ok := cache.Get(ctx, "all"); ok {
+		t.Error("nil cache reported a hit")
+	}
+	if err := cache.Invalidate(ctx); err != nil {
+		t.Errorf("Invalidate on nil cache = %v", err)
+	}
+}
@@ -4,49 +4,501 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/wuwenbin0122/wwb.ai/db/models"
 )
 
-// GetRoleByID fetches a single role record including extended metadata columns.
+// ErrRoleNameTaken is returned when creating or renaming a role to a name already in use.
+var ErrRoleNameTaken = errors.New("role name already exists")
+
+// RoleFilter selects, orders and pages roles. Empty fields match everything; a zero Limit
+// returns every match.
+type RoleFilter struct {
+	Domain string
+	Tags   []string // any of, matched as whole tags
+	Query  string   // substring of name, bio or background
+	Sort   string   // one of RoleSortKeys; defaults to id
+	Limit  int
+	Offset int
+	// IncludeArchived also returns soft-deleted roles.
+	IncludeArchived bool
+	// Viewer is the calling user, whose private roles are listed alongside the public
+	// ones; "" lists public roles only.
+	Viewer string
+	// Mine lists only the private roles of Viewer.
+	Mine bool
+}
+
+// RoleSortKeys are the accepted RoleFilter.Sort values.
+var RoleSortKeys = []string{"id", "name", "domain"}
+
+// roleSortOrders maps sort keys to ORDER BY clauses; id breaks ties so pages are stable.
+var roleSortOrders = map[string]string{
+	"id":     "id",
+	"name":   "name, id",
+	"domain": "domain, name, id",
+}
+
+// IsRoleSortKey reports whether key is a valid RoleFilter.Sort value.
+func IsRoleSortKey(key string) bool {
+	_, ok := roleSortOrders[key]
+	return ok
+}
+
+// RoleRepository reads and writes roles. Lookups of a missing role return pgx.ErrNoRows
+// (possibly wrapped); writes that would duplicate a name return ErrRoleNameTaken.
+// GetByID returns archived and private roles too; callers check Role.VisibleTo.
+type RoleRepository interface {
+	List(ctx context.Context, filter RoleFilter) ([]models.Role, error)
+	Count(ctx context.Context, filter RoleFilter) (int, error)
+	GetByID(ctx context.Context, id int64) (*models.Role, error)
+	Create(ctx context.Context, role models.Role) (*models.Role, error)
+	Update(ctx context.Context, role models.Role) (*models.Role, error)
+	// SetAvatar stores the avatar URL of a role.
+	SetAvatar(ctx context.Context, id int64, url string) (*models.Role, error)
+	// Archive soft-deletes a role; archiving an archived role keeps its original timestamp.
+	Archive(ctx context.Context, id int64) error
+	// Delete removes the row permanently.
+	Delete(ctx context.Context, id int64) error
+	// Import upserts roles by name atomically; with dryRun nothing is written.
+	Import(ctx context.Context, roles []models.Role, dryRun bool) ([]RoleImportResult, error)
+	// Tags lists the distinct tags of non-archived public roles, most used first.
+	Tags(ctx context.Context) ([]TagCount, error)
+	// SearchSemantic is List ordered by meaning rather than substring match of
+	// filter.Query, where the repository supports it, and List otherwise.
+	SearchSemantic(ctx context.Context, filter RoleFilter) ([]models.Role, error)
+}
+
+// TagCount is a tag with the number of roles carrying it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// PgRoleRepository is the Postgres RoleRepository. Listing tolerates replica lag; single
+// lookups and writes go to the primary.
+type PgRoleRepository struct {
+	pools *PoolRouter
+
+	// set by EnableSemanticSearch
+	embedder RoleEmbedder
+}
+
+// NewPgRoleRepository builds a repository over pools.
+func NewPgRoleRepository(pools *PoolRouter) *PgRoleRepository {
+	return &PgRoleRepository{pools: pools}
+}
+
+// roleSchema is a generation of the roles table. Reads try the newest first and fall back
+// when a column is missing, so the service keeps working before migrations are applied.
+type roleSchema int
+
+const (
+	roleSchemaOwner    roleSchema = iota // 0009: owner_id
+	roleSchemaTagArray                   // 0008: tags TEXT[]
+	roleSchemaMedia                      // 0006: avatar_url, voice_sample_url
+	roleSchemaArchive                    // 0005: archived_at
+	roleSchemaVoice                      // 0003: voice_type, speed_ratio
+	roleSchemaExtended                   // 0002: personality, background, languages, skills
+	roleSchemaLegacy                     // 0001
+
+	roleSchemaLatest = roleSchemaOwner
+)
+
+func (s roleSchema) columns() string {
+	// before 0008 tags is a comma separated VARCHAR; COALESCE with an array fails on it
+	// with a datatype mismatch, which moves reads to the older schema
+	columns := `id, name, domain, COALESCE(tags, '{}'::text[]), bio`
+	if s > roleSchemaTagArray {
+		columns = `id, name, domain, COALESCE(tags, ''), bio`
+	}
+	if s <= roleSchemaExtended {
+		columns += `, personality, background, languages, skills`
+	}
+	if s <= roleSchemaVoice {
+		columns += `, COALESCE(voice_type, ''), COALESCE(speed_ratio, 0)`
+	}
+	if s <= roleSchemaArchive {
+		columns += `, archived_at`
+	}
+	if s <= roleSchemaMedia {
+		columns += `, COALESCE(avatar_url, ''), COALESCE(voice_sample_url, '')`
+	}
+	if s <= roleSchemaOwner {
+		columns += `, COALESCE(owner_id, '')`
+	}
+	return columns
+}
+
+func (s roleSchema) scan(row pgx.Row) (*models.Role, error) {
+	var role models.Role
+	var legacyTags string
+	dest := []interface{}{&role.ID, &role.Name, &role.Domain, (*[]string)(&role.Tags), &role.Bio}
+	if s > roleSchemaTagArray {
+		dest[3] = &legacyTags
+	}
+	if s <= roleSchemaExtended {
+		dest = append(dest, &role.Personality, &role.Background, &role.Languages, &role.Skills)
+	}
+	if s <= roleSchemaVoice {
+		dest = append(dest, &role.VoiceType, &role.SpeedRatio)
+	}
+	if s <= roleSchemaArchive {
+		dest = append(dest, &role.ArchivedAt)
+	}
+	if s <= roleSchemaMedia {
+		dest = append(dest, &role.AvatarURL, &role.VoiceSampleURL)
+	}
+	if s <= roleSchemaOwner {
+		dest = append(dest, &role.OwnerID)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if s > roleSchemaTagArray {
+		role.Tags = models.ParseTags(legacyTags)
+	}
+	role.Archived = role.ArchivedAt != nil
+	return &role, nil
+}
+
+// queryRoles runs SELECT <columns> FROM roles <tail> against the newest schema that has
+// the columns. tail is rebuilt per schema because filters may reference newer columns.
+func queryRoles(ctx context.Context, pool *pgxpool.Pool, tail func(roleSchema) (string, []interface{})) ([]models.Role, error) {
+	var lastErr error
+	for schema := roleSchemaLatest; schema <= roleSchemaLegacy; schema++ {
+		clause, args := tail(schema)
+		roles, err := queryRolesWith(ctx, pool, schema, clause, args)
+		if err == nil {
+			return roles, nil
+		}
+		if !isSchemaMismatch(err) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func queryRolesWith(ctx context.Context, pool *pgxpool.Pool, schema roleSchema, tail string, args []interface{}) ([]models.Role, error) {
+	rows, err := pool.Query(ctx, `SELECT `+schema.columns()+` FROM roles`+tail, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]models.Role, 0)
+	for rows.Next() {
+		role, err := schema.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+	return roles, rows.Err()
+}
+
+// List returns the roles matching filter.
+func (r *PgRoleRepository) List(ctx context.Context, filter RoleFilter) ([]models.Role, error) {
+	order, ok := roleSortOrders[filter.Sort]
+	if !ok {
+		order = roleSortOrders["id"]
+	}
+	page := " ORDER BY " + order
+	if filter.Limit > 0 {
+		page += fmt.Sprintf(" LIMIT %d OFFSET %d", filter.Limit, filter.Offset)
+	}
+
+	pool := r.pools.Pool(ReadPreferenceReplica, "list roles")
+	roles, err := queryRoles(ctx, pool, func(schema roleSchema) (string, []interface{}) {
+		where, args := filter.where(schema)
+		return where + page, args
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list roles: %w", err)
+	}
+	return roles, nil
+}
+
+// Count returns how many roles match filter, ignoring Limit and Offset.
+func (r *PgRoleRepository) Count(ctx context.Context, filter RoleFilter) (int, error) {
+	pool := r.pools.Pool(ReadPreferenceReplica, "count roles")
+	var err error
+	for schema := roleSchemaLatest; schema <= roleSchemaLegacy; schema++ {
+		where, args := filter.where(schema)
+		var total int
+		err = pool.QueryRow(ctx, `SELECT COUNT(*) FROM roles`+where, args...).Scan(&total)
+		if err == nil {
+			return total, nil
+		}
+		if !isSchemaMismatch(err) {
+			break
+		}
+	}
+	return 0, fmt.Errorf("count roles: %w", err)
+}
+
+// GetByID fetches a single role from the primary.
+func (r *PgRoleRepository) GetByID(ctx context.Context, id int64) (*models.Role, error) {
+	return GetRoleByID(ctx, r.pools.Primary(), id)
+}
+
+// insertRoleSQL and updateRoleSQL take roleArgs plus the owner ID for inserts or the role
+// ID for updates, and end in RETURNING, to be followed by the columns to scan. Updates
+// never change the owner.
+const (
+	insertRoleSQL = `INSERT INTO roles (name, domain, tags, bio, personality, background, languages, skills, voice_type, speed_ratio, avatar_url, voice_sample_url, owner_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, 0), NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''))
+		RETURNING `
+	updateRoleSQL = `UPDATE roles SET name = $1, domain = $2, tags = $3, bio = $4, personality = $5, background = $6,
+		languages = $7, skills = $8, voice_type = NULLIF($9, ''), speed_ratio = NULLIF($10, 0),
+		avatar_url = COALESCE(NULLIF($11, ''), avatar_url), voice_sample_url = COALESCE(NULLIF($12, ''), voice_sample_url)
+		WHERE id = $13
+		RETURNING `
+)
+
+// Create inserts role, private to role.OwnerID when set, and returns the stored row. The
+// ID of role is ignored.
+func (r *PgRoleRepository) Create(ctx context.Context, role models.Role) (*models.Role, error) {
+	stored, err := roleSchemaLatest.scan(r.pools.Primary().QueryRow(ctx, insertRoleSQL+roleSchemaLatest.columns(), append(roleArgs(role), role.OwnerID)...))
+	if err != nil {
+		return nil, roleWriteError("insert role", err)
+	}
+	return stored, nil
+}
+
+// Update replaces every column of the role with role.ID and returns the stored row. Empty
+// media URLs keep the stored ones, since uploads set them separately.
+func (r *PgRoleRepository) Update(ctx context.Context, role models.Role) (*models.Role, error) {
+	stored, err := roleSchemaLatest.scan(r.pools.Primary().QueryRow(ctx, updateRoleSQL+roleSchemaLatest.columns(), append(roleArgs(role), role.ID)...))
+	if err != nil {
+		return nil, roleWriteError("update role", err)
+	}
+	return stored, nil
+}
+
+// SetAvatar stores the avatar URL of the role with id and returns the updated row.
+func (r *PgRoleRepository) SetAvatar(ctx context.Context, id int64, url string) (*models.Role, error) {
+	const query = `UPDATE roles SET avatar_url = $1 WHERE id = $2 RETURNING `
+	stored, err := roleSchemaLatest.scan(r.pools.Primary().QueryRow(ctx, query+roleSchemaLatest.columns(), url, id))
+	if err != nil {
+		return nil, roleWriteError("set role avatar", err)
+	}
+	return stored, nil
+}
+
+// Archive soft-deletes the role with id.
+func (r *PgRoleRepository) Archive(ctx context.Context, id int64) error {
+	tag, err := r.pools.Primary().Exec(ctx, `UPDATE roles SET archived_at = COALESCE(archived_at, now()) WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("archive role: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("archive role: %w", pgx.ErrNoRows)
+	}
+	return nil
+}
+
+// Delete removes the role with id.
+func (r *PgRoleRepository) Delete(ctx context.Context, id int64) error {
+	tag, err := r.pools.Primary().Exec(ctx, `DELETE FROM roles WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete role: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("delete role: %w", pgx.ErrNoRows)
+	}
+	return nil
+}
+
+// Tags counts the tags of non-archived roles. Before migration 0008 the tags are split
+// from the legacy column in Go.
+func (r *PgRoleRepository) Tags(ctx context.Context) ([]TagCount, error) {
+	pool := r.pools.Pool(ReadPreferenceReplica, "list role tags")
+	rows, err := pool.Query(ctx, `SELECT tag, COUNT(*) FROM roles, unnest(tags) AS tag
+		WHERE archived_at IS NULL AND owner_id IS NULL
+		GROUP BY tag
+		ORDER BY COUNT(*) DESC, tag`)
+	if err != nil {
+		if isSchemaMismatch(err) {
+			return r.countLegacyTags(ctx)
+		}
+		return nil, fmt.Errorf("list role tags: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make([]TagCount, 0)
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, fmt.Errorf("list role tags: scan: %w", err)
+		}
+		counts = append(counts, tc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list role tags: %w", err)
+	}
+	return counts, nil
+}
+
+func (r *PgRoleRepository) countLegacyTags(ctx context.Context) ([]TagCount, error) {
+	roles, err := r.List(ctx, RoleFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("list role tags: %w", err)
+	}
+	return countTags(roles), nil
+}
+
+// countTags tallies the tags of roles, most used first and then by name.
+func countTags(roles []models.Role) []TagCount {
+	byTag := make(map[string]int)
+	for _, role := range roles {
+		for _, tag := range role.Tags {
+			byTag[tag]++
+		}
+	}
+	counts := make([]TagCount, 0, len(byTag))
+	for tag, n := range byTag {
+		counts = append(counts, TagCount{Tag: tag, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Tag < counts[j].Tag
+	})
+	return counts
+}
+
+// GetRoleByID fetches a single role record including extended metadata columns, falling
+// back to older schemas when columns are missing.
 func GetRoleByID(ctx context.Context, pool *pgxpool.Pool, id int64) (*models.Role, error) {
 	if pool == nil {
 		return nil, errors.New("postgres pool is nil")
 	}
 
-	var role models.Role
-	const queryExt = `SELECT id, name, domain, tags, bio, personality, background, languages, skills FROM roles WHERE id = $1`
-	if err := pool.QueryRow(ctx, queryExt, id).Scan(
-		&role.ID,
-		&role.Name,
-		&role.Domain,
-		&role.Tags,
-		&role.Bio,
-		&role.Personality,
-		&role.Background,
-		&role.Languages,
-		&role.Skills,
-	); err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UndefinedColumn {
-			// Fallback to legacy schema without extended columns
-			const queryLegacy = `SELECT id, name, domain, tags, bio FROM roles WHERE id = $1`
-			if err2 := pool.QueryRow(ctx, queryLegacy, id).Scan(
-				&role.ID,
-				&role.Name,
-				&role.Domain,
-				&role.Tags,
-				&role.Bio,
-			); err2 != nil {
-				return nil, fmt.Errorf("query role by id (legacy): %w", err2)
-			}
-		} else {
-			return nil, fmt.Errorf("query role by id: %w", err)
+	roles, err := queryRoles(ctx, pool, func(roleSchema) (string, []interface{}) {
+		return ` WHERE id = $1`, []interface{}{id}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query role by id: %w", err)
+	}
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("query role by id: %w", pgx.ErrNoRows)
+	}
+	return &roles[0], nil
+}
+
+// where renders the WHERE clause for schema, leaving out columns it does not have yet.
+func (f RoleFilter) where(schema roleSchema) (string, []interface{}) {
+	clauses := make([]string, 0, 4)
+	args := make([]interface{}, 0, len(f.Tags)+3)
+
+	if !f.IncludeArchived && schema <= roleSchemaArchive {
+		clauses = append(clauses, "archived_at IS NULL")
+	}
+
+	// before 0009 every role is public, so there is nothing of the viewer's own to list
+	switch {
+	case f.Mine && (f.Viewer == "" || schema > roleSchemaOwner):
+		clauses = append(clauses, "FALSE")
+	case f.Mine:
+		clauses = append(clauses, fmt.Sprintf("owner_id = $%d", len(args)+1))
+		args = append(args, f.Viewer)
+	case schema > roleSchemaOwner:
+	case f.Viewer == "":
+		clauses = append(clauses, "owner_id IS NULL")
+	default:
+		clauses = append(clauses, fmt.Sprintf("(owner_id IS NULL OR owner_id = $%d)", len(args)+1))
+		args = append(args, f.Viewer)
+	}
+
+	if f.Domain != "" {
+		clauses = append(clauses, fmt.Sprintf("domain ILIKE $%d", len(args)+1))
+		args = append(args, f.Domain)
+	}
+
+	if tags := models.NormalizeTags(f.Tags); len(tags) > 0 && schema <= roleSchemaTagArray {
+		clauses = append(clauses, fmt.Sprintf("tags && $%d::text[]", len(args)+1))
+		args = append(args, []string(tags))
+	} else if len(tags) > 0 {
+		tagClauses := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			tagClauses = append(tagClauses, containsClause("tags", len(args)+1))
+			args = append(args, escapeLike(tag))
 		}
+		clauses = append(clauses, "("+strings.Join(tagClauses, " OR ")+")")
 	}
 
-	return &role, nil
+	if f.Query != "" {
+		columns := []string{"name", "bio"}
+		if schema <= roleSchemaExtended {
+			columns = append(columns, "background")
+		}
+		searchClauses := make([]string, 0, len(columns))
+		for _, column := range columns {
+			searchClauses = append(searchClauses, containsClause(column, len(args)+1))
+		}
+		clauses = append(clauses, "("+strings.Join(searchClauses, " OR ")+")")
+		args = append(args, escapeLike(f.Query))
+	}
+
+	if len(clauses) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+func containsClause(column string, arg int) string {
+	return fmt.Sprintf("%s ILIKE '%%' || $%d || '%%'", column, arg)
+}
+
+// escapeLike makes user input match literally inside an ILIKE pattern.
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(term)
+}
+
+func roleArgs(role models.Role) []interface{} {
+	return []interface{}{
+		role.Name,
+		role.Domain,
+		[]string(models.NormalizeTags(role.Tags)),
+		role.Bio,
+		role.Personality,
+		role.Background,
+		role.Languages,
+		role.Skills,
+		role.VoiceType,
+		role.SpeedRatio,
+		role.AvatarURL,
+		role.VoiceSampleURL,
+	}
+}
+
+func roleWriteError(op string, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return fmt.Errorf("%s: %w", op, ErrRoleNameTaken)
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// isSchemaMismatch reports whether err comes from a query written for a newer roles
+// schema than the database has: a missing column, or a column of its older type.
+func isSchemaMismatch(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Code {
+	case pgerrcode.UndefinedColumn, pgerrcode.DatatypeMismatch, pgerrcode.UndefinedFunction:
+		return true
+	}
+	return false
 }
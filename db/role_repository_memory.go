@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// MemoryRoleRepository is an in-process RoleRepository for handler tests and local demos
+// without Postgres. It mirrors the Postgres semantics: case-insensitive filters, unique
+// names and pgx.ErrNoRows for missing IDs.
+type MemoryRoleRepository struct {
+	mu     sync.RWMutex
+	roles  map[int64]models.Role
+	nextID int64
+}
+
+// NewMemoryRoleRepository returns a repository seeded with roles; zero IDs are assigned.
+func NewMemoryRoleRepository(roles ...models.Role) *MemoryRoleRepository {
+	r := &MemoryRoleRepository{roles: make(map[int64]models.Role), nextID: 1}
+	for _, role := range roles {
+		if role.ID == 0 {
+			role.ID = r.nextID
+		}
+		if role.ID >= r.nextID {
+			r.nextID = role.ID + 1
+		}
+		role.Tags = models.NormalizeTags(role.Tags)
+		r.roles[role.ID] = role
+	}
+	return r
+}
+
+func (r *MemoryRoleRepository) matching(filter RoleFilter) []models.Role {
+	query := strings.ToLower(filter.Query)
+	result := make([]models.Role, 0, len(r.roles))
+	for _, role := range r.roles {
+		if role.Archived && !filter.IncludeArchived {
+			continue
+		}
+		if filter.Mine && (filter.Viewer == "" || role.OwnerID != filter.Viewer) || !role.VisibleTo(filter.Viewer) {
+			continue
+		}
+		if filter.Domain != "" && !strings.EqualFold(role.Domain, filter.Domain) {
+			continue
+		}
+		if len(filter.Tags) > 0 {
+			matched := false
+			for _, tag := range models.NormalizeTags(filter.Tags) {
+				if slices.Contains(role.Tags, tag) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		if query != "" &&
+			!strings.Contains(strings.ToLower(role.Name), query) &&
+			!strings.Contains(strings.ToLower(role.Bio), query) &&
+			!strings.Contains(strings.ToLower(role.Background), query) {
+			continue
+		}
+		result = append(result, role)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		switch filter.Sort {
+		case "name":
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+		case "domain":
+			if a.Domain != b.Domain {
+				return a.Domain < b.Domain
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+		}
+		return a.ID < b.ID
+	})
+	return result
+}
+
+// List implements RoleRepository.
+func (r *MemoryRoleRepository) List(_ context.Context, filter RoleFilter) ([]models.Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := r.matching(filter)
+	if filter.Offset > 0 {
+		if filter.Offset >= len(result) {
+			return []models.Role{}, nil
+		}
+		result = result[filter.Offset:]
+	}
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result, nil
+}
+
+// Count implements RoleRepository.
+func (r *MemoryRoleRepository) Count(_ context.Context, filter RoleFilter) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.matching(filter)), nil
+}
+
+// GetByID implements RoleRepository.
+func (r *MemoryRoleRepository) GetByID(_ context.Context, id int64) (*models.Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	role, ok := r.roles[id]
+	if !ok {
+		return nil, fmt.Errorf("query role by id: %w", pgx.ErrNoRows)
+	}
+	return &role, nil
+}
+
+// Create implements RoleRepository.
+func (r *MemoryRoleRepository) Create(_ context.Context, role models.Role) (*models.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTakenLocked(role.OwnerID, role.Name, 0) {
+		return nil, fmt.Errorf("insert role: %w", ErrRoleNameTaken)
+	}
+	role.ID = r.nextID
+	r.nextID++
+	role.Tags = models.NormalizeTags(role.Tags)
+	r.roles[role.ID] = role
+	return &role, nil
+}
+
+// Update implements RoleRepository.
+func (r *MemoryRoleRepository) Update(_ context.Context, role models.Role) (*models.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.roles[role.ID]
+	if !ok {
+		return nil, fmt.Errorf("update role: %w", pgx.ErrNoRows)
+	}
+	if r.nameTakenLocked(existing.OwnerID, role.Name, role.ID) {
+		return nil, fmt.Errorf("update role: %w", ErrRoleNameTaken)
+	}
+	// like the UPDATE statement, a full replace leaves the owner, archive state and unset media alone
+	role.OwnerID = existing.OwnerID
+	role.ArchivedAt, role.Archived = existing.ArchivedAt, existing.Archived
+	if role.AvatarURL == "" {
+		role.AvatarURL = existing.AvatarURL
+	}
+	if role.VoiceSampleURL == "" {
+		role.VoiceSampleURL = existing.VoiceSampleURL
+	}
+	role.Tags = models.NormalizeTags(role.Tags)
+	r.roles[role.ID] = role
+	return &role, nil
+}
+
+// SetAvatar implements RoleRepository.
+func (r *MemoryRoleRepository) SetAvatar(_ context.Context, id int64, url string) (*models.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	role, ok := r.roles[id]
+	if !ok {
+		return nil, fmt.Errorf("set role avatar: %w", pgx.ErrNoRows)
+	}
+	role.AvatarURL = url
+	r.roles[id] = role
+	return &role, nil
+}
+
+// Archive implements RoleRepository.
+func (r *MemoryRoleRepository) Archive(_ context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	role, ok := r.roles[id]
+	if !ok {
+		return fmt.Errorf("archive role: %w", pgx.ErrNoRows)
+	}
+	if role.ArchivedAt == nil {
+		now := time.Now()
+		role.ArchivedAt = &now
+		role.Archived = true
+		r.roles[id] = role
+	}
+	return nil
+}
+
+// Delete implements RoleRepository.
+func (r *MemoryRoleRepository) Delete(_ context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.roles[id]; !ok {
+		return fmt.Errorf("delete role: %w", pgx.ErrNoRows)
+	}
+	delete(r.roles, id)
+	return nil
+}
+
+// Tags implements RoleRepository.
+func (r *MemoryRoleRepository) Tags(_ context.Context) ([]TagCount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return countTags(r.matching(RoleFilter{})), nil
+}
+
+// SearchSemantic implements RoleRepository with List, as the memory store keeps no
+// embeddings.
+func (r *MemoryRoleRepository) SearchSemantic(ctx context.Context, filter RoleFilter) ([]models.Role, error) {
+	return r.List(ctx, filter)
+}
+
+func (r *MemoryRoleRepository) nameTakenLocked(ownerID, name string, exceptID int64) bool {
+	for id, role := range r.roles {
+		if id != exceptID && role.OwnerID == ownerID && role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	_ RoleRepository = (*PgRoleRepository)(nil)
+	_ RoleRepository = (*MemoryRoleRepository)(nil)
+)
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RoleColumn is a column of the roles table as reported by information_schema.
+type RoleColumn struct {
+	Name     string
+	DataType string
+}
+
+// RequiredRoleColumns are the columns of the latest role schema. Older tables still work
+// through the read fallbacks, but writes of newer fields fail until migrations catch up.
+// embedding and embedding_model are left out: they need pgvector and are optional.
+var RequiredRoleColumns = []string{
+	"id", "name", "domain", "tags", "bio",
+	"personality", "background", "languages", "skills",
+	"voice_type", "speed_ratio",
+	"archived_at",
+	"avatar_url", "voice_sample_url",
+	"owner_id",
+}
+
+// RoleColumns lists the columns of public.roles in table order; an empty result means the
+// table does not exist.
+func RoleColumns(ctx context.Context, pool *pgxpool.Pool) ([]RoleColumn, error) {
+	const query = `SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = 'public' AND table_name = 'roles' ORDER BY ordinal_position`
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query roles columns: %w", err)
+	}
+	defer rows.Close()
+
+	var columns []RoleColumn
+	for rows.Next() {
+		var column RoleColumn
+		if err := rows.Scan(&column.Name, &column.DataType); err != nil {
+			return nil, fmt.Errorf("scan roles column: %w", err)
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// MissingRoleColumns returns the entries of RequiredRoleColumns absent from columns. A
+// tags column that is not an array (before migration 0008) is reported as missing too.
+func MissingRoleColumns(columns []RoleColumn) []string {
+	present := make(map[string]string, len(columns))
+	for _, column := range columns {
+		present[column.Name] = column.DataType
+	}
+	var missing []string
+	for _, name := range RequiredRoleColumns {
+		dataType, ok := present[name]
+		if !ok || (name == "tags" && dataType != "ARRAY") {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,344 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+)
+
+const (
+	roleUsageRedisDeadline = 300 * time.Millisecond
+	roleUsageFlushBatch    = 200
+	// roleUsageBatchField names a claimed hash's batch; role ID fields are numeric, so it
+	// never collides with a count
+	roleUsageBatchField = "batch"
+	// roleUsageFlushRetention is how long applied batch IDs are remembered; a claimed hash
+	// is retried within minutes, so a day is ample
+	roleUsageFlushRetention = 24 * time.Hour
+)
+
+// FeaturedRole is a role with its usage ranking.
+type FeaturedRole struct {
+	models.Role
+	Featured   bool    `json:"featured"`
+	UsageScore float64 `json:"usage_score"`
+}
+
+// DecayScore returns score after elapsed time with exponential decay of the given half-life.
+// A non-positive half-life disables decay.
+func DecayScore(score float64, elapsed, halfLife time.Duration) float64 {
+	if halfLife <= 0 || elapsed <= 0 {
+		return score
+	}
+	return score * math.Exp2(-float64(elapsed)/float64(halfLife))
+}
+
+// RoleStatsStore counts how often each role is chatted with. Counts are incremented in
+// Redis on the hot path and folded into the role_stats table by Run, which keeps a decayed
+// score per role for the featured ranking.
+type RoleStatsStore struct {
+	pools      *PoolRouter
+	kv         *kv.Store
+	redis      *redis.Client
+	pendingKey string
+	halfLife   time.Duration
+	interval   time.Duration
+	logger     *zap.SugaredLogger
+}
+
+// NewRoleStatsStore builds a store flushing every interval with scores halving every halfLife.
+func NewRoleStatsStore(pools *PoolRouter, store *kv.Store, interval, halfLife time.Duration, logger *zap.SugaredLogger) *RoleStatsStore {
+	return &RoleStatsStore{
+		pools:      pools,
+		kv:         store,
+		redis:      store.Client(),
+		pendingKey: store.Key(kv.RoleUsagePending),
+		halfLife:   halfLife,
+		interval:   interval,
+		logger:     logger,
+	}
+}
+
+// RecordUsage counts one served conversation turn for roleID. Failures are logged, never returned.
+func (s *RoleStatsStore) RecordUsage(ctx context.Context, roleID int64) {
+	if s == nil || !s.kv.Available() || roleID <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), roleUsageRedisDeadline)
+	defer cancel()
+	if err := s.redis.HIncrBy(ctx, s.pendingKey, strconv.FormatInt(roleID, 10), 1).Err(); err != nil {
+		s.logger.Warnf("record role %d usage: %v", roleID, err)
+	}
+}
+
+// Run flushes pending counts every interval until ctx is done, then flushes once more.
+func (s *RoleStatsStore) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(ctx); err != nil {
+				s.logger.Warnf("flush role usage: %v", err)
+			}
+		case <-ctx.Done():
+			if err := s.Flush(context.Background()); err != nil {
+				s.logger.Warnf("final role usage flush: %v", err)
+			}
+			return nil
+		}
+	}
+}
+
+// Flush moves the pending counters into role_stats. The pending hash is claimed with an
+// atomic RENAME, so concurrent replicas never flush the same counts; a claimed hash is only
+// deleted after the database commit, and hashes left behind by a crash are claimed again
+// on the next flush. Each claimed hash carries a batch ID that is recorded in
+// role_stats_flushes in the same transaction as its counts, so a hash flushed again after
+// its delete failed is skipped rather than counted twice.
+func (s *RoleStatsStore) Flush(ctx context.Context) error {
+	if !s.kv.Available() {
+		return nil
+	}
+
+	claimed := make([]string, 0, 2)
+	if key, ok, err := s.claim(ctx, s.pendingKey); err != nil {
+		return err
+	} else if ok {
+		claimed = append(claimed, key)
+	}
+
+	// leftovers from a flush that died before its commit or delete; recent ones may still
+	// be in flight on another replica
+	iter := s.redis.Scan(ctx, 0, s.kv.Pattern(kv.RoleUsageFlushing), 100).Iterator()
+	for iter.Next(ctx) {
+		if slices.Contains(claimed, iter.Val()) || !staleClaim(s.kv.Trim(kv.RoleUsageFlushing, iter.Val()), 2*s.interval+time.Minute) {
+			continue
+		}
+		if key, ok, err := s.claim(ctx, iter.Val()); err == nil && ok {
+			claimed = append(claimed, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		s.logger.Warnf("scan stale role usage hashes: %v", err)
+	}
+
+	for _, key := range claimed {
+		// the first claim names the batch; later claims of the same hash keep that name
+		if err := s.redis.HSetNX(ctx, key, roleUsageBatchField, s.kv.Trim(kv.RoleUsageFlushing, key)).Err(); err != nil {
+			return fmt.Errorf("name batch %s: %w", key, err)
+		}
+		counts, err := s.redis.HGetAll(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", key, err)
+		}
+		if err := s.apply(ctx, counts[roleUsageBatchField], counts); err != nil {
+			return err
+		}
+		if err := s.redis.Del(ctx, key).Err(); err != nil {
+			return fmt.Errorf("delete %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// claim renames key to a name unique to this flush; ok is false when key no longer exists.
+func (s *RoleStatsStore) claim(ctx context.Context, key string) (string, bool, error) {
+	var suffix [6]byte
+	_, _ = rand.Read(suffix[:])
+	target := s.kv.Key(kv.RoleUsageFlushing, strconv.FormatInt(time.Now().UnixNano(), 36)+"-"+hex.EncodeToString(suffix[:]))
+	if err := s.redis.Rename(ctx, key, target).Err(); err != nil {
+		if isRedisNoSuchKey(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("claim %s: %w", key, err)
+	}
+	return target, true, nil
+}
+
+// apply folds counts into role_stats in batches within one transaction, unless batchID was
+// applied before. Decay is applied from the stored timestamp up to now before the new
+// counts are added.
+func (s *RoleStatsStore) apply(ctx context.Context, batchID string, counts map[string]string) error {
+	deltas := make(map[int64]int64, len(counts))
+	for field, value := range counts {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			continue
+		}
+		deltas[id] = n
+	}
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	tx, err := s.pools.Primary().Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("flush role usage: begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `INSERT INTO role_stats_flushes (batch_id) VALUES ($1) ON CONFLICT (batch_id) DO NOTHING`, batchID)
+	if err != nil {
+		return fmt.Errorf("flush role usage: record batch: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		s.logger.Infof("role usage batch %s was already flushed, skipping", batchID)
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM role_stats_flushes WHERE flushed_at < now() - make_interval(secs => $1)`, roleUsageFlushRetention.Seconds()); err != nil {
+		return fmt.Errorf("flush role usage: prune batches: %w", err)
+	}
+
+	ids := make([]int64, 0, len(deltas))
+	for id := range deltas {
+		ids = append(ids, id)
+	}
+	for start := 0; start < len(ids); start += roleUsageFlushBatch {
+		end := min(start+roleUsageFlushBatch, len(ids))
+		if err := s.applyBatch(ctx, tx, ids[start:end], deltas); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("flush role usage: commit: %w", err)
+	}
+	return nil
+}
+
+func (s *RoleStatsStore) applyBatch(ctx context.Context, tx pgx.Tx, ids []int64, deltas map[int64]int64) error {
+	rows, err := tx.Query(ctx, `SELECT role_id, score, score_updated_at, now() FROM role_stats WHERE role_id = ANY($1) FOR UPDATE`, ids)
+	if err != nil {
+		return fmt.Errorf("flush role usage: load: %w", err)
+	}
+	type stat struct {
+		score     float64
+		updatedAt time.Time
+		now       time.Time
+	}
+	current := make(map[int64]stat, len(ids))
+	for rows.Next() {
+		var id int64
+		var st stat
+		if err := rows.Scan(&id, &st.score, &st.updatedAt, &st.now); err != nil {
+			rows.Close()
+			return fmt.Errorf("flush role usage: scan: %w", err)
+		}
+		current[id] = st
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("flush role usage: load: %w", err)
+	}
+
+	batch := &pgx.Batch{}
+	for _, id := range ids {
+		delta := deltas[id]
+		score := float64(delta)
+		if st, ok := current[id]; ok {
+			score += DecayScore(st.score, st.now.Sub(st.updatedAt), s.halfLife)
+		}
+		// roles deleted since the chat are skipped rather than failing the whole flush
+		batch.Queue(`INSERT INTO role_stats (role_id, score, total_count, score_updated_at)
+			SELECT $1, $2, $3, now() WHERE EXISTS (SELECT 1 FROM roles WHERE id = $1)
+			ON CONFLICT (role_id) DO UPDATE SET score = EXCLUDED.score,
+				total_count = role_stats.total_count + EXCLUDED.total_count,
+				score_updated_at = EXCLUDED.score_updated_at`, id, score, delta)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("flush role usage: upsert: %w", err)
+	}
+	return nil
+}
+
+// Featured returns up to limit non-archived public roles: manually featured roles first, then by
+// usage score decayed to now.
+func (s *RoleStatsStore) Featured(ctx context.Context, limit int) ([]FeaturedRole, error) {
+	halfLifeSeconds := s.halfLife.Seconds()
+	if halfLifeSeconds <= 0 {
+		halfLifeSeconds = math.Inf(1)
+	}
+	query := `SELECT ` + roleSchemaLatest.columns() + `,
+			COALESCE(s.featured, false),
+			COALESCE(s.score * power(0.5, EXTRACT(EPOCH FROM (now() - s.score_updated_at)) / $1), 0) AS usage_score
+		FROM roles LEFT JOIN role_stats s ON s.role_id = roles.id
+		WHERE roles.archived_at IS NULL AND roles.owner_id IS NULL
+		ORDER BY COALESCE(s.featured, false) DESC, usage_score DESC, roles.id
+		LIMIT $2`
+
+	pool := s.pools.Pool(ReadPreferenceReplica, "featured roles")
+	rows, err := pool.Query(ctx, query, halfLifeSeconds, limit)
+	if err != nil {
+		return nil, fmt.Errorf("featured roles: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]FeaturedRole, 0, limit)
+	for rows.Next() {
+		var fr FeaturedRole
+		role, err := roleSchemaLatest.scan(extraScanner{row: rows, extra: []interface{}{&fr.Featured, &fr.UsageScore}})
+		if err != nil {
+			return nil, fmt.Errorf("featured roles: scan: %w", err)
+		}
+		fr.Role = *role
+		result = append(result, fr)
+	}
+	return result, rows.Err()
+}
+
+// SetFeatured pins or unpins roleID in the featured ranking.
+func (s *RoleStatsStore) SetFeatured(ctx context.Context, roleID int64, featured bool) error {
+	tag, err := s.pools.Primary().Exec(ctx, `INSERT INTO role_stats (role_id, featured)
+		SELECT $1, $2 WHERE EXISTS (SELECT 1 FROM roles WHERE id = $1)
+		ON CONFLICT (role_id) DO UPDATE SET featured = EXCLUDED.featured`, roleID, featured)
+	if err != nil {
+		return fmt.Errorf("set featured: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("set featured: %w", pgx.ErrNoRows)
+	}
+	return nil
+}
+
+// extraScanner appends destinations for columns selected after the role columns.
+type extraScanner struct {
+	row   pgx.Row
+	extra []interface{}
+}
+
+func (e extraScanner) Scan(dest ...interface{}) error {
+	return e.row.Scan(append(dest, e.extra...)...)
+}
+
+// staleClaim reports whether a claimed hash is older than age, judged by the claim
+// timestamp that starts claim, its name within the flushing schema.
+func staleClaim(claim string, age time.Duration) bool {
+	stamp, _, _ := strings.Cut(claim, "-")
+	nanos, err := strconv.ParseInt(stamp, 36, 64)
+	if err != nil {
+		return true
+	}
+	return time.Since(time.Unix(0, nanos)) > age
+}
+
+func isRedisNoSuchKey(err error) bool {
+	return err != nil && !errors.Is(err, redis.Nil) && strings.Contains(err.Error(), "no such key")
+}
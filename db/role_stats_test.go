@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/db/migrations"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// testPostgres connects to TEST_POSTGRES_DSN and migrates it, skipping the test when the
+// variable is unset.
+func testPostgres(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	pool, err := NewPostgresPool(context.Background(), dsn, PostgresOptions{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if _, err := migrations.Up(context.Background(), pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return pool
+}
+
+// TestRoleStatsFlushSkipsReappliedBatch replays a claimed hash whose delete failed after
+// the commit, and checks its counts are folded in only once.
+func TestRoleStatsFlushSkipsReappliedBatch(t *testing.T) {
+	ctx := context.Background()
+	pool := testPostgres(t)
+	store, _ := newTestStore(t, fmt.Sprintf("test-%d", time.Now().UnixNano()))
+
+	var roleID int64
+	name := fmt.Sprintf("flush-test-%d", time.Now().UnixNano())
+	if err := pool.QueryRow(ctx, `INSERT INTO roles (name, domain) VALUES ($1, 'test') RETURNING id`, name).Scan(&roleID); err != nil {
+		t.Fatalf("insert role: %v", err)
+	}
+	t.Cleanup(func() { _, _ = pool.Exec(context.Background(), `DELETE FROM roles WHERE id = $1`, roleID) })
+
+	stats := NewRoleStatsStore(NewPoolRouter(pool, nil, zap.NewNop().Sugar()), store, time.Minute, time.Hour, zap.NewNop().Sugar())
+	for range 3 {
+		stats.RecordUsage(ctx, roleID)
+	}
+
+	// claim by hand and keep a copy of the hash, as if the delete after the commit failed
+	key, ok, err := stats.claim(ctx, stats.pendingKey)
+	if err != nil || !ok {
+		t.Fatalf("claim = %v, %t", err, ok)
+	}
+	batch := store.Trim(kv.RoleUsageFlushing, key)
+	if err := store.Client().HSet(ctx, key, roleUsageBatchField, batch).Err(); err != nil {
+		t.Fatal(err)
+	}
+	leftover, err := store.Client().HGetAll(ctx, key).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Client().Rename(ctx, key, stats.pendingKey).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stats.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	// the leftover is found again under a stale claim name, carrying its original batch
+	stale := store.Key(kv.RoleUsageFlushing, strconv.FormatInt(time.Now().Add(-time.Hour).UnixNano(), 36)+"-leftover")
+	values := make([]any, 0, 2*len(leftover))
+	for field, value := range leftover {
+		values = append(values, field, value)
+	}
+	if err := store.Client().HSet(ctx, stale, values...).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stats.Flush(ctx); err != nil {
+		t.Fatalf("second Flush: %v", err)
+	}
+
+	var total int64
+	if err := pool.QueryRow(ctx, `SELECT total_count FROM role_stats WHERE role_id = $1`, roleID).Scan(&total); err != nil {
+		t.Fatalf("read role_stats: %v", err)
+	}
+	if total != 3 {
+		t.Errorf("total_count = %d after replaying a flushed batch, want 3", total)
+	}
+	if n, _ := store.Client().Exists(ctx, stale).Result(); n != 0 {
+		t.Error("replayed batch was not deleted")
+	}
+}
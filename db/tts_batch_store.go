@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// States of a TTSBatchJob.
+const (
+	TTSBatchPending = "pending"
+	TTSBatchRunning = "running"
+	TTSBatchDone    = "done"
+)
+
+// TTSBatchItem is the outcome of one line of a batch: the audio on success, the error
+// otherwise.
+type TTSBatchItem struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	Audio      string `json:"audio,omitempty"`
+	Encoding   string `json:"encoding,omitempty"`
+	Duration   string `json:"duration,omitempty"`
+	ReqID      string `json:"reqid,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Code       string `json:"code,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// TTSBatchJob is the state of an asynchronous batch. Items are filled in as they finish,
+// so a running job reports partial results.
+type TTSBatchJob struct {
+	ID         string         `json:"id"`
+	UserID     string         `json:"user_id,omitempty"`
+	Status     string         `json:"status"`
+	Total      int            `json:"total"`
+	Succeeded  int            `json:"succeeded"`
+	Failed     int            `json:"failed"`
+	Items      []TTSBatchItem `json:"items"`
+	DurationMS int64          `json:"duration_ms"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// TTSBatchStore keeps batch jobs in Redis keyed by job id until they expire.
+type TTSBatchStore struct {
+	kv  *kv.Store
+	ttl time.Duration
+}
+
+// NewTTSBatchStore builds a store whose jobs expire ttl after their last update.
+func NewTTSBatchStore(store *kv.Store, ttl time.Duration) *TTSBatchStore {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &TTSBatchStore{kv: store, ttl: ttl}
+}
+
+// Save writes job and resets its expiry.
+func (s *TTSBatchStore) Save(ctx context.Context, job TTSBatchJob) error {
+	if job.ID == "" {
+		return errors.New("tts batch job id is empty")
+	}
+	job.UpdatedAt = time.Now().UTC()
+
+	if err := s.kv.SetJSON(ctx, s.kv.Key(kv.TTSBatch, job.ID), job, s.ttl); err != nil {
+		return fmt.Errorf("store tts batch job: %w", err)
+	}
+	return nil
+}
+
+// Load fetches the job with id. The boolean is false when it expired or never existed.
+func (s *TTSBatchStore) Load(ctx context.Context, id string) (TTSBatchJob, bool, error) {
+	var job TTSBatchJob
+
+	found, err := s.kv.GetJSON(ctx, s.kv.Key(kv.TTSBatch, id), &job)
+	if err != nil {
+		return job, false, fmt.Errorf("load tts batch job: %w", err)
+	}
+	return job, found, nil
+}
@@ -0,0 +1,423 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Services billed in usage_events.
+const (
+	UsageServiceChat = "chat"
+	UsageServiceTTS  = "tts"
+	UsageServiceASR  = "asr"
+)
+
+const (
+	usageQueueSize     = 1024
+	usageBatchSize     = 200
+	usageFlushInterval = 5 * time.Second
+	usageWriteLimit    = 10 * time.Second
+	usageDeleteBatch   = 1000
+)
+
+// UsageEvent is one upstream call billed to a user and role. Only the fields of its
+// service are set.
+type UsageEvent struct {
+	UserID           string
+	RoleID           int64
+	Service          string
+	At               time.Time
+	PromptTokens     int64
+	CompletionTokens int64
+	TTSCharacters    int64
+	ASRMillis        int64
+}
+
+// UsageTotals sums usage over some set of usage_events rows.
+type UsageTotals struct {
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	TTSCharacters    int64   `json:"tts_characters"`
+	ASRSeconds       float64 `json:"asr_seconds"`
+}
+
+func (t *UsageTotals) add(o UsageTotals) {
+	t.Requests += o.Requests
+	t.PromptTokens += o.PromptTokens
+	t.CompletionTokens += o.CompletionTokens
+	t.TotalTokens += o.TotalTokens
+	t.TTSCharacters += o.TTSCharacters
+	t.ASRSeconds += o.ASRSeconds
+}
+
+// UsageDay is the usage of one UTC day.
+type UsageDay struct {
+	Day string `json:"day"`
+	UsageTotals
+}
+
+// UsageSummary is a user's usage over a date range, in total, per service and per day.
+type UsageSummary struct {
+	From      string                 `json:"from"`
+	To        string                 `json:"to"`
+	Total     UsageTotals            `json:"total"`
+	ByService map[string]UsageTotals `json:"by_service"`
+	ByDay     []UsageDay             `json:"by_day"`
+}
+
+// UsageGroup is the usage of one user, role or user and role pair, depending on the
+// grouping it was aggregated by.
+type UsageGroup struct {
+	UserID *string `json:"user_id,omitempty"`
+	RoleID *int64  `json:"role_id,omitempty"`
+	UsageTotals
+}
+
+// Usage groupings accepted by UsageStore.Aggregate.
+const (
+	UsageByUser     = "user"
+	UsageByRole     = "role"
+	UsageByUserRole = "user_role"
+)
+
+var usageGroupColumns = map[string]string{
+	UsageByUser:     "user_id",
+	UsageByRole:     "role_id",
+	UsageByUserRole: "user_id, role_id",
+}
+
+// usageSums selects the UsageTotals columns, in the order scanUsageTotals reads them.
+// SUM of a bigint is numeric, so the sums are cast back.
+const usageSums = `COALESCE(SUM(requests), 0)::bigint, COALESCE(SUM(prompt_tokens), 0)::bigint,
+	COALESCE(SUM(completion_tokens), 0)::bigint, COALESCE(SUM(tts_characters), 0)::bigint,
+	COALESCE(SUM(asr_ms), 0)::bigint`
+
+// UsageCounter is told about every recorded event as it is recorded, ahead of the batched
+// table write; quota.Service counts events against monthly quotas this way.
+type UsageCounter interface {
+	Count(event UsageEvent)
+}
+
+// UsageStore records upstream usage in the usage_events table. Record only queues the
+// event; Run folds queued events into per-day rows in batches, so accounting never slows
+// down or fails a request. A nil store records nothing.
+type UsageStore struct {
+	pools   *PoolRouter
+	counter UsageCounter
+	queue   chan UsageEvent
+	logger  *zap.SugaredLogger
+}
+
+// NewUsageStore builds a store writing through pools; counter may be nil.
+func NewUsageStore(pools *PoolRouter, counter UsageCounter, logger *zap.SugaredLogger) *UsageStore {
+	return &UsageStore{pools: pools, counter: counter, queue: make(chan UsageEvent, usageQueueSize), logger: logger}
+}
+
+// Record passes event to the counter and queues it. Queueing never blocks; when the queue
+// is full the event is dropped and a warning logged.
+func (s *UsageStore) Record(event UsageEvent) {
+	if s == nil {
+		return
+	}
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	if s.counter != nil {
+		s.counter.Count(event)
+	}
+	select {
+	case s.queue <- event:
+	default:
+		s.logger.Warnw("usage queue full, dropping event", "service", event.Service, "user_id", event.UserID)
+	}
+}
+
+// Run writes queued events whenever a batch fills up or the flush interval passes. Once
+// ctx is done it drains the queue, writes what is left and returns.
+func (s *UsageStore) Run(ctx context.Context) error {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+
+	pending := make([]UsageEvent, 0, usageBatchSize)
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := s.write(context.Background(), pending); err != nil {
+			s.logger.Warnf("write %d usage events: %v", len(pending), err)
+		}
+		pending = pending[:0]
+	}
+
+	for {
+		select {
+		case event := <-s.queue:
+			pending = append(pending, event)
+			if len(pending) >= usageBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-s.queue:
+					pending = append(pending, event)
+					if len(pending) >= usageBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return nil
+				}
+			}
+		}
+	}
+}
+
+type usageKey struct {
+	userID  string
+	roleID  int64
+	service string
+	day     time.Time
+}
+
+type usageDelta struct {
+	requests, promptTokens, completionTokens, ttsCharacters, asrMillis int64
+}
+
+// write adds events to their day rows, summing events of the same row first so a batch
+// costs one upsert per row.
+func (s *UsageStore) write(parent context.Context, events []UsageEvent) error {
+	deltas := make(map[usageKey]*usageDelta, len(events))
+	keys := make([]usageKey, 0, len(events))
+	for _, event := range events {
+		at := event.At.UTC()
+		key := usageKey{userID: event.UserID, roleID: event.RoleID, service: event.Service,
+			day: time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)}
+		delta, ok := deltas[key]
+		if !ok {
+			delta = &usageDelta{}
+			deltas[key] = delta
+			keys = append(keys, key)
+		}
+		delta.requests++
+		delta.promptTokens += event.PromptTokens
+		delta.completionTokens += event.CompletionTokens
+		delta.ttsCharacters += event.TTSCharacters
+		delta.asrMillis += event.ASRMillis
+	}
+
+	// replicas flushing at once lock rows in the same order, so they cannot deadlock
+	slices.SortFunc(keys, func(a, b usageKey) int {
+		return cmp.Or(cmp.Compare(a.userID, b.userID), a.day.Compare(b.day),
+			cmp.Compare(a.service, b.service), cmp.Compare(a.roleID, b.roleID))
+	})
+	batch := &pgx.Batch{}
+	for _, key := range keys {
+		delta := deltas[key]
+		batch.Queue(`INSERT INTO usage_events (user_id, role_id, service, day, requests, prompt_tokens,
+				completion_tokens, tts_characters, asr_ms)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (user_id, day, service, role_id) DO UPDATE SET
+				requests = usage_events.requests + EXCLUDED.requests,
+				prompt_tokens = usage_events.prompt_tokens + EXCLUDED.prompt_tokens,
+				completion_tokens = usage_events.completion_tokens + EXCLUDED.completion_tokens,
+				tts_characters = usage_events.tts_characters + EXCLUDED.tts_characters,
+				asr_ms = usage_events.asr_ms + EXCLUDED.asr_ms`,
+			key.userID, key.roleID, key.service, key.day,
+			delta.requests, delta.promptTokens, delta.completionTokens, delta.ttsCharacters, delta.asrMillis)
+	}
+
+	ctx, cancel := context.WithTimeout(parent, usageWriteLimit)
+	defer cancel()
+	// a batch sent outside a transaction still runs as one implicit transaction
+	if err := s.pools.Primary().SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("upsert usage: %w", err)
+	}
+	return nil
+}
+
+// ErasedUserPrefix starts the user_id of usage rows whose user erased their data.
+const ErasedUserPrefix = "erased:"
+
+// ErasedUserID is the user_id the usage rows of userID carry once the user erased their
+// data: a hash that no longer names the user, but that quota.Reconcile can still match to
+// them so erasing usage does not reset a quota.
+func ErasedUserID(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return ErasedUserPrefix + hex.EncodeToString(sum[:])
+}
+
+// DeleteByUser anonymizes every usage row of userID in batches, moving its totals onto
+// ErasedUserID(userID), and returns how many rows were moved, including those moved
+// before an error. The rows are kept rather than deleted because the quota counters are
+// rebuilt from them. Events still queued are written under userID afterwards.
+func (s *UsageStore) DeleteByUser(ctx context.Context, userID string) (int64, error) {
+	if userID == "" {
+		return 0, fmt.Errorf("anonymize usage: user id is required")
+	}
+	var moved int64
+	for {
+		tag, err := s.pools.Primary().Exec(ctx, `WITH moved AS (
+				DELETE FROM usage_events WHERE ctid = ANY(ARRAY(
+					SELECT ctid FROM usage_events WHERE user_id = $1 LIMIT $3))
+				RETURNING role_id, service, day, requests, prompt_tokens, completion_tokens,
+					tts_characters, asr_ms)
+			INSERT INTO usage_events (user_id, role_id, service, day, requests, prompt_tokens,
+				completion_tokens, tts_characters, asr_ms)
+			SELECT $2::text, role_id, service, day, requests, prompt_tokens, completion_tokens,
+				tts_characters, asr_ms FROM moved
+			ON CONFLICT (user_id, day, service, role_id) DO UPDATE SET
+				requests = usage_events.requests + EXCLUDED.requests,
+				prompt_tokens = usage_events.prompt_tokens + EXCLUDED.prompt_tokens,
+				completion_tokens = usage_events.completion_tokens + EXCLUDED.completion_tokens,
+				tts_characters = usage_events.tts_characters + EXCLUDED.tts_characters,
+				asr_ms = usage_events.asr_ms + EXCLUDED.asr_ms`,
+			userID, ErasedUserID(userID), usageDeleteBatch)
+		if err != nil {
+			return moved, fmt.Errorf("anonymize usage: %w", err)
+		}
+		moved += tag.RowsAffected()
+		if tag.RowsAffected() < usageDeleteBatch {
+			return moved, nil
+		}
+	}
+}
+
+// Summary returns the usage of userID between the UTC days from and to, both included.
+func (s *UsageStore) Summary(ctx context.Context, userID string, from, to time.Time) (*UsageSummary, error) {
+	pool := s.pools.Pool(ReadPreferenceReplica, "usage summary")
+	rows, err := pool.Query(ctx, `SELECT to_char(day, 'YYYY-MM-DD'), service, `+usageSums+`
+		FROM usage_events WHERE user_id = $1 AND day BETWEEN $2 AND $3
+		GROUP BY day, service ORDER BY day, service`,
+		userID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("usage summary: %w", err)
+	}
+	defer rows.Close()
+
+	summary := &UsageSummary{
+		From:      from.Format(time.DateOnly),
+		To:        to.Format(time.DateOnly),
+		ByService: map[string]UsageTotals{},
+		ByDay:     []UsageDay{},
+	}
+	for rows.Next() {
+		var day, service string
+		totals, err := scanUsageTotals(rows, &day, &service)
+		if err != nil {
+			return nil, fmt.Errorf("usage summary: scan: %w", err)
+		}
+		summary.Total.add(totals)
+		byService := summary.ByService[service]
+		byService.add(totals)
+		summary.ByService[service] = byService
+		if n := len(summary.ByDay); n == 0 || summary.ByDay[n-1].Day != day {
+			summary.ByDay = append(summary.ByDay, UsageDay{Day: day})
+		}
+		summary.ByDay[len(summary.ByDay)-1].add(totals)
+	}
+	return summary, rows.Err()
+}
+
+// Aggregate returns the usage between the UTC days from and to, both included, grouped by
+// one of UsageByUser, UsageByRole or UsageByUserRole, heaviest token users first.
+func (s *UsageStore) Aggregate(ctx context.Context, groupBy string, from, to time.Time, limit int) ([]UsageGroup, error) {
+	columns, ok := usageGroupColumns[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("usage aggregate: unknown grouping %q", groupBy)
+	}
+	pool := s.pools.Pool(ReadPreferenceReplica, "usage aggregate")
+	rows, err := pool.Query(ctx, `SELECT `+columns+`, `+usageSums+`
+		FROM usage_events WHERE day BETWEEN $1 AND $2
+		GROUP BY `+columns+`
+		ORDER BY SUM(prompt_tokens + completion_tokens) DESC, SUM(tts_characters) DESC, SUM(asr_ms) DESC, `+columns+`
+		LIMIT $3`,
+		from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("usage aggregate: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make([]UsageGroup, 0, limit)
+	for rows.Next() {
+		var group UsageGroup
+		var keys []any
+		switch groupBy {
+		case UsageByUser:
+			group.UserID = new(string)
+			keys = []any{group.UserID}
+		case UsageByRole:
+			group.RoleID = new(int64)
+			keys = []any{group.RoleID}
+		default:
+			group.UserID, group.RoleID = new(string), new(int64)
+			keys = []any{group.UserID, group.RoleID}
+		}
+		if group.UsageTotals, err = scanUsageTotals(rows, keys...); err != nil {
+			return nil, fmt.Errorf("usage aggregate: scan: %w", err)
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// RoleChats is how many chat replies a role served.
+type RoleChats struct {
+	RoleID int64  `json:"role_id"`
+	Name   string `json:"name"`
+	Chats  int64  `json:"chats"`
+}
+
+// ChatsByRole returns the chat replies per role between the UTC days from and to, both
+// included, busiest first. It reads the per-day rows through the day index, so its cost
+// follows the range rather than the table. Roles deleted since have an empty name.
+func (s *UsageStore) ChatsByRole(ctx context.Context, from, to time.Time, limit int) ([]RoleChats, error) {
+	pool := s.pools.Pool(ReadPreferenceReplica, "chats by role")
+	rows, err := pool.Query(ctx, `SELECT u.role_id, COALESCE(r.name, ''), SUM(u.requests)::bigint
+		FROM usage_events u LEFT JOIN roles r ON r.id = u.role_id
+		WHERE u.service = $1 AND u.day BETWEEN $2 AND $3
+		GROUP BY u.role_id, r.name
+		ORDER BY 3 DESC, u.role_id
+		LIMIT $4`,
+		UsageServiceChat, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("chats by role: %w", err)
+	}
+	defer rows.Close()
+
+	chats := make([]RoleChats, 0, limit)
+	for rows.Next() {
+		var role RoleChats
+		if err := rows.Scan(&role.RoleID, &role.Name, &role.Chats); err != nil {
+			return nil, fmt.Errorf("chats by role: scan: %w", err)
+		}
+		chats = append(chats, role)
+	}
+	return chats, rows.Err()
+}
+
+// scanUsageTotals scans the leading key columns into keys and the usageSums columns into
+// the returned totals.
+func scanUsageTotals(row pgx.Row, keys ...any) (UsageTotals, error) {
+	var totals UsageTotals
+	var asrMillis int64
+	dest := append(keys, &totals.Requests, &totals.PromptTokens, &totals.CompletionTokens, &totals.TTSCharacters, &asrMillis)
+	if err := row.Scan(dest...); err != nil {
+		return UsageTotals{}, err
+	}
+	totals.TotalTokens = totals.PromptTokens + totals.CompletionTokens
+	totals.ASRSeconds = float64(asrMillis) / 1000
+	return totals, nil
+}
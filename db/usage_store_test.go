@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestErasedUserID(t *testing.T) {
+	id := ErasedUserID("42")
+	if !strings.HasPrefix(id, ErasedUserPrefix) || strings.Contains(id, "42") {
+		t.Errorf("ErasedUserID(42) = %q, want a prefixed hash", id)
+	}
+	if ErasedUserID("42") != id || ErasedUserID("43") == id {
+		t.Error("ErasedUserID is not a deterministic per-user hash")
+	}
+}
+
+// TestUsageDeleteByUserAnonymizes erases a user's usage twice, with usage on the same days
+// recorded in between, and checks the totals move to the erased id instead of vanishing.
+func TestUsageDeleteByUserAnonymizes(t *testing.T) {
+	ctx := context.Background()
+	pool := testPostgres(t)
+	store := NewUsageStore(NewPoolRouter(pool, nil, zap.NewNop().Sugar()), nil, zap.NewNop().Sugar())
+	userID := fmt.Sprintf("erase-test-%d", time.Now().UnixNano())
+	erased := ErasedUserID(userID)
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), `DELETE FROM usage_events WHERE user_id = ANY($1)`, []string{userID, erased})
+	})
+
+	day := time.Date(2026, time.March, 3, 0, 0, 0, 0, time.UTC)
+	insert := func(tokens int64) {
+		t.Helper()
+		err := store.write(ctx, []UsageEvent{
+			{UserID: userID, RoleID: 1, Service: UsageServiceChat, At: day, PromptTokens: tokens},
+			{UserID: userID, Service: UsageServiceTTS, At: day.AddDate(0, 0, 1), TTSCharacters: tokens},
+		})
+		if err != nil {
+			t.Fatalf("write usage: %v", err)
+		}
+	}
+
+	insert(10)
+	if moved, err := store.DeleteByUser(ctx, userID); err != nil || moved != 2 {
+		t.Fatalf("DeleteByUser = %d, %v; want 2 rows", moved, err)
+	}
+	insert(5)
+	if moved, err := store.DeleteByUser(ctx, userID); err != nil || moved != 2 {
+		t.Fatalf("second DeleteByUser = %d, %v; want 2 rows merged", moved, err)
+	}
+
+	var left int
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM usage_events WHERE user_id = $1`, userID).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d rows still name the user", left)
+	}
+	var rows, requests, tokens, characters int64
+	err := pool.QueryRow(ctx, `SELECT count(*), SUM(requests), SUM(prompt_tokens), SUM(tts_characters)
+		FROM usage_events WHERE user_id = $1`, erased).Scan(&rows, &requests, &tokens, &characters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 || requests != 4 || tokens != 15 || characters != 15 {
+		t.Errorf("erased rows = %d with %d requests, %d tokens, %d characters; want 2, 4, 15, 15", rows, requests, tokens, characters)
+	}
+}
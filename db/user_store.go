@@ -0,0 +1,349 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+var (
+	// ErrUsernameTaken is returned when registering a username already in use, ignoring case.
+	ErrUsernameTaken = errors.New("username already exists")
+	// ErrEmailTaken is returned when an email is already used by another account, ignoring case.
+	ErrEmailTaken = errors.New("email already exists")
+	// ErrRefreshTokenSpent is returned when replacing a refresh token that was already
+	// rotated or revoked, typically by a concurrent refresh with the same token.
+	ErrRefreshTokenSpent = errors.New("refresh token already used")
+	// ErrVerificationTokenSpent is returned when confirming a verification token that was
+	// already used.
+	ErrVerificationTokenSpent = errors.New("verification token already used")
+)
+
+// UserStore persists accounts and their refresh tokens. Lookups of a missing row return
+// pgx.ErrNoRows (possibly wrapped).
+type UserStore interface {
+	CreateUser(ctx context.Context, username, passwordHash string) (*models.User, error)
+	GetUserByID(ctx context.Context, id int64) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	// UpdateUserProfile sets the username, email ("" clears it) and preferred language of
+	// user id. Changing the email clears EmailVerified.
+	UpdateUserProfile(ctx context.Context, id int64, username, email, language string) (*models.User, error)
+	// UpdatePasswordHash stores a new password hash and revokes every refresh token of
+	// the user, atomically.
+	UpdatePasswordHash(ctx context.Context, id int64, passwordHash string) error
+	CreateRefreshToken(ctx context.Context, token models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash []byte) (*models.RefreshToken, error)
+	// ReplaceRefreshToken marks the token with oldID rotated and stores next, atomically.
+	ReplaceRefreshToken(ctx context.Context, oldID int64, next models.RefreshToken) error
+	// RevokeRefreshFamily revokes every live token of a family.
+	RevokeRefreshFamily(ctx context.Context, familyID string) error
+	CreateVerificationToken(ctx context.Context, token models.VerificationToken) error
+	GetVerificationToken(ctx context.Context, tokenHash []byte) (*models.VerificationToken, error)
+	// ConfirmEmail marks the token used and the user's email verified, atomically. It returns
+	// ErrVerificationTokenSpent when the token was used already and pgx.ErrNoRows when the
+	// user's email is no longer the one the token was sent to.
+	ConfirmEmail(ctx context.Context, tokenID int64) error
+	CreateAPIKey(ctx context.Context, key models.APIKey) (*models.APIKey, error)
+	// GetAPIKey finds a key by its hash, revoked or not.
+	GetAPIKey(ctx context.Context, keyHash []byte) (*models.APIKey, error)
+	// ListAPIKeys returns the live keys of a user, newest first.
+	ListAPIKeys(ctx context.Context, userID int64) ([]models.APIKey, error)
+	// RevokeAPIKey revokes key id of userID; pgx.ErrNoRows when there is no such live key.
+	RevokeAPIKey(ctx context.Context, userID, id int64) error
+	TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error
+}
+
+// PgUserStore is the Postgres UserStore; everything goes to the primary.
+type PgUserStore struct {
+	pools *PoolRouter
+}
+
+// NewPgUserStore builds a store over pools.
+func NewPgUserStore(pools *PoolRouter) *PgUserStore {
+	return &PgUserStore{pools: pools}
+}
+
+const userColumns = `id, username, COALESCE(email, ''), email_verified, password_hash, preferred_language, created_at`
+
+func scanUser(row pgx.Row) (*models.User, error) {
+	var user models.User
+	if err := row.Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.PasswordHash, &user.PreferredLanguage, &user.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// userWriteError maps unique violations to ErrUsernameTaken or ErrEmailTaken.
+func userWriteError(op string, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		if pgErr.ConstraintName == "users_email_key" {
+			return fmt.Errorf("%s: %w", op, ErrEmailTaken)
+		}
+		return fmt.Errorf("%s: %w", op, ErrUsernameTaken)
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+const refreshTokenColumns = `id, user_id, family_id, token_hash, device, user_agent, ip, created_at, expires_at, replaced_at, revoked_at`
+
+// CreateUser inserts an account.
+func (s *PgUserStore) CreateUser(ctx context.Context, username, passwordHash string) (*models.User, error) {
+	user, err := scanUser(s.pools.Primary().QueryRow(ctx, `INSERT INTO users (username, password_hash) VALUES ($1, $2)
+		RETURNING `+userColumns, username, passwordHash))
+	if err != nil {
+		return nil, userWriteError("create user", err)
+	}
+	return user, nil
+}
+
+// GetUserByID finds an account by ID.
+func (s *PgUserStore) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	user, err := scanUser(s.pools.Primary().QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	return user, nil
+}
+
+// GetUserByUsername finds an account by username, ignoring case.
+func (s *PgUserStore) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	user, err := scanUser(s.pools.Primary().QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE lower(username) = lower($1)`, username))
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	return user, nil
+}
+
+// UpdateUserProfile changes the username, email and preferred language of user id.
+func (s *PgUserStore) UpdateUserProfile(ctx context.Context, id int64, username, email, language string) (*models.User, error) {
+	user, err := scanUser(s.pools.Primary().QueryRow(ctx, `UPDATE users SET username = $2, email = NULLIF($3, ''),
+		email_verified = email_verified AND lower(COALESCE(email, '')) = lower($3), preferred_language = $4
+		WHERE id = $1 RETURNING `+userColumns, id, username, email, language))
+	if err != nil {
+		return nil, userWriteError("update user", err)
+	}
+	return user, nil
+}
+
+// UpdatePasswordHash replaces the password hash of user id and signs out every device.
+func (s *PgUserStore) UpdatePasswordHash(ctx context.Context, id int64, passwordHash string) error {
+	tx, err := s.pools.Primary().Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("update password: begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET password_hash = $2 WHERE id = $1`, id, passwordHash)
+	if err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("update password: %w", pgx.ErrNoRows)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL`, id); err != nil {
+		return fmt.Errorf("update password: revoke refresh tokens: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("update password: commit: %w", err)
+	}
+	return nil
+}
+
+// CreateRefreshToken stores a token issued at login or registration.
+func (s *PgUserStore) CreateRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	if err := insertRefreshToken(ctx, s.pools.Primary(), token); err != nil {
+		return fmt.Errorf("create refresh token: %w", err)
+	}
+	return nil
+}
+
+// GetRefreshToken finds a token by its hash, whatever its state.
+func (s *PgUserStore) GetRefreshToken(ctx context.Context, tokenHash []byte) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	err := s.pools.Primary().QueryRow(ctx, `SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE token_hash = $1`, tokenHash).
+		Scan(&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.Device, &t.UserAgent, &t.IP, &t.CreatedAt, &t.ExpiresAt, &t.ReplacedAt, &t.RevokedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get refresh token: %w", err)
+	}
+	return &t, nil
+}
+
+// ReplaceRefreshToken rotates oldID to next in one transaction. Only one of several
+// concurrent rotations of the same token succeeds; the others get ErrRefreshTokenSpent.
+func (s *PgUserStore) ReplaceRefreshToken(ctx context.Context, oldID int64, next models.RefreshToken) error {
+	tx, err := s.pools.Primary().Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("rotate refresh token: begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE refresh_tokens SET replaced_at = now()
+		WHERE id = $1 AND replaced_at IS NULL AND revoked_at IS NULL`, oldID)
+	if err != nil {
+		return fmt.Errorf("rotate refresh token: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("rotate refresh token: %w", ErrRefreshTokenSpent)
+	}
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		return fmt.Errorf("rotate refresh token: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("rotate refresh token: commit: %w", err)
+	}
+	return nil
+}
+
+// RevokeRefreshFamily revokes every token of familyID that is not revoked yet.
+func (s *PgUserStore) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	if _, err := s.pools.Primary().Exec(ctx, `UPDATE refresh_tokens SET revoked_at = now()
+		WHERE family_id = $1 AND revoked_at IS NULL`, familyID); err != nil {
+		return fmt.Errorf("revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+const verificationTokenColumns = `id, user_id, email, token_hash, created_at, expires_at, used_at`
+
+// CreateVerificationToken stores a token about to be mailed.
+func (s *PgUserStore) CreateVerificationToken(ctx context.Context, t models.VerificationToken) error {
+	if _, err := s.pools.Primary().Exec(ctx, `INSERT INTO verification_tokens (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)`, t.UserID, t.Email, t.TokenHash, t.ExpiresAt); err != nil {
+		return fmt.Errorf("create verification token: %w", err)
+	}
+	return nil
+}
+
+// GetVerificationToken finds a token by its hash, whatever its state.
+func (s *PgUserStore) GetVerificationToken(ctx context.Context, tokenHash []byte) (*models.VerificationToken, error) {
+	var t models.VerificationToken
+	err := s.pools.Primary().QueryRow(ctx, `SELECT `+verificationTokenColumns+` FROM verification_tokens WHERE token_hash = $1`, tokenHash).
+		Scan(&t.ID, &t.UserID, &t.Email, &t.TokenHash, &t.CreatedAt, &t.ExpiresAt, &t.UsedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get verification token: %w", err)
+	}
+	return &t, nil
+}
+
+// ConfirmEmail spends tokenID and verifies the address it was sent to in one transaction.
+// Of several concurrent confirmations only one succeeds.
+func (s *PgUserStore) ConfirmEmail(ctx context.Context, tokenID int64) error {
+	tx, err := s.pools.Primary().Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("confirm email: begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	var email string
+	err = tx.QueryRow(ctx, `UPDATE verification_tokens SET used_at = now()
+		WHERE id = $1 AND used_at IS NULL RETURNING user_id, email`, tokenID).Scan(&userID, &email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("confirm email: %w", ErrVerificationTokenSpent)
+	}
+	if err != nil {
+		return fmt.Errorf("confirm email: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `UPDATE users SET email_verified = true WHERE id = $1 AND lower(email) = lower($2)`, userID, email)
+	if err != nil {
+		return fmt.Errorf("confirm email: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("confirm email: %w", pgx.ErrNoRows)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("confirm email: commit: %w", err)
+	}
+	return nil
+}
+
+const apiKeyColumns = `id, user_id, label, prefix, key_hash, scopes, created_at, last_used_at, revoked_at`
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var k models.APIKey
+	if err := row.Scan(&k.ID, &k.UserID, &k.Label, &k.Prefix, &k.KeyHash, &k.Scopes, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// CreateAPIKey stores a new key.
+func (s *PgUserStore) CreateAPIKey(ctx context.Context, k models.APIKey) (*models.APIKey, error) {
+	created, err := scanAPIKey(s.pools.Primary().QueryRow(ctx, `INSERT INTO api_keys (user_id, label, prefix, key_hash, scopes)
+		VALUES ($1, $2, $3, $4, $5) RETURNING `+apiKeyColumns, k.UserID, k.Label, k.Prefix, k.KeyHash, k.Scopes))
+	if err != nil {
+		return nil, fmt.Errorf("create api key: %w", err)
+	}
+	return created, nil
+}
+
+// GetAPIKey finds a key by its hash.
+func (s *PgUserStore) GetAPIKey(ctx context.Context, keyHash []byte) (*models.APIKey, error) {
+	k, err := scanAPIKey(s.pools.Primary().QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, keyHash))
+	if err != nil {
+		return nil, fmt.Errorf("get api key: %w", err)
+	}
+	return k, nil
+}
+
+// ListAPIKeys returns the keys of userID that are not revoked.
+func (s *PgUserStore) ListAPIKeys(ctx context.Context, userID int64) ([]models.APIKey, error) {
+	rows, err := s.pools.Primary().Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]models.APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list api keys: %w", err)
+		}
+		keys = append(keys, *k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes key id if userID owns it.
+func (s *PgUserStore) RevokeAPIKey(ctx context.Context, userID, id int64) error {
+	tag, err := s.pools.Primary().Exec(ctx, `UPDATE api_keys SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("revoke api key: %w", pgx.ErrNoRows)
+	}
+	return nil
+}
+
+// TouchAPIKey records that key id was used at usedAt.
+func (s *PgUserStore) TouchAPIKey(ctx context.Context, id int64, usedAt time.Time) error {
+	if _, err := s.pools.Primary().Exec(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, id, usedAt); err != nil {
+		return fmt.Errorf("touch api key: %w", err)
+	}
+	return nil
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+func insertRefreshToken(ctx context.Context, conn execer, t models.RefreshToken) error {
+	_, err := conn.Exec(ctx, `INSERT INTO refresh_tokens (user_id, family_id, token_hash, device, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, t.UserID, t.FamilyID, t.TokenHash, t.Device, t.UserAgent, t.IP, t.ExpiresAt)
+	return err
+}
@@ -0,0 +1,287 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// MemoryUserStore is an in-process UserStore for handler tests and local demos without
+// Postgres, with the same uniqueness and rotation rules as PgUserStore.
+type MemoryUserStore struct {
+	mu            sync.Mutex
+	users         []models.User
+	tokens        []models.RefreshToken
+	verifications []models.VerificationToken
+	apiKeys       []models.APIKey
+}
+
+// NewMemoryUserStore returns an empty store.
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{}
+}
+
+// CreateUser implements UserStore.
+func (s *MemoryUserStore) CreateUser(_ context.Context, username, passwordHash string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Username, username) {
+			return nil, fmt.Errorf("create user: %w", ErrUsernameTaken)
+		}
+	}
+	user := models.User{ID: int64(len(s.users) + 1), Username: username, PasswordHash: passwordHash, CreatedAt: time.Now()}
+	s.users = append(s.users, user)
+	return &user, nil
+}
+
+// GetUserByID implements UserStore.
+func (s *MemoryUserStore) GetUserByID(_ context.Context, id int64) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id <= 0 || id > int64(len(s.users)) {
+		return nil, fmt.Errorf("get user: %w", pgx.ErrNoRows)
+	}
+	user := s.users[id-1]
+	return &user, nil
+}
+
+// UpdateUserProfile implements UserStore.
+func (s *MemoryUserStore) UpdateUserProfile(_ context.Context, id int64, username, email, language string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id <= 0 || id > int64(len(s.users)) {
+		return nil, fmt.Errorf("update user: %w", pgx.ErrNoRows)
+	}
+	for _, other := range s.users {
+		if other.ID == id {
+			continue
+		}
+		if strings.EqualFold(other.Username, username) {
+			return nil, fmt.Errorf("update user: %w", ErrUsernameTaken)
+		}
+		if email != "" && strings.EqualFold(other.Email, email) {
+			return nil, fmt.Errorf("update user: %w", ErrEmailTaken)
+		}
+	}
+	user := &s.users[id-1]
+	if !strings.EqualFold(user.Email, email) {
+		user.EmailVerified = false
+	}
+	user.Username, user.Email, user.PreferredLanguage = username, email, language
+	updated := *user
+	return &updated, nil
+}
+
+// UpdatePasswordHash implements UserStore.
+func (s *MemoryUserStore) UpdatePasswordHash(_ context.Context, id int64, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id <= 0 || id > int64(len(s.users)) {
+		return fmt.Errorf("update password: %w", pgx.ErrNoRows)
+	}
+	s.users[id-1].PasswordHash = passwordHash
+	now := time.Now()
+	for i := range s.tokens {
+		if s.tokens[i].UserID == id && s.tokens[i].RevokedAt == nil {
+			s.tokens[i].RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// GetUserByUsername implements UserStore.
+func (s *MemoryUserStore) GetUserByUsername(_ context.Context, username string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Username, username) {
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("get user: %w", pgx.ErrNoRows)
+}
+
+// CreateRefreshToken implements UserStore.
+func (s *MemoryUserStore) CreateRefreshToken(_ context.Context, token models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insertLocked(token)
+	return nil
+}
+
+// GetRefreshToken implements UserStore.
+func (s *MemoryUserStore) GetRefreshToken(_ context.Context, tokenHash []byte) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.tokens {
+		if bytes.Equal(token.TokenHash, tokenHash) {
+			return &token, nil
+		}
+	}
+	return nil, fmt.Errorf("get refresh token: %w", pgx.ErrNoRows)
+}
+
+// ReplaceRefreshToken implements UserStore.
+func (s *MemoryUserStore) ReplaceRefreshToken(_ context.Context, oldID int64, next models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.tokens {
+		old := &s.tokens[i]
+		if old.ID != oldID {
+			continue
+		}
+		if old.ReplacedAt != nil || old.RevokedAt != nil {
+			return fmt.Errorf("rotate refresh token: %w", ErrRefreshTokenSpent)
+		}
+		now := time.Now()
+		old.ReplacedAt = &now
+		s.insertLocked(next)
+		return nil
+	}
+	return fmt.Errorf("rotate refresh token: %w", ErrRefreshTokenSpent)
+}
+
+// RevokeRefreshFamily implements UserStore.
+func (s *MemoryUserStore) RevokeRefreshFamily(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for i := range s.tokens {
+		if s.tokens[i].FamilyID == familyID && s.tokens[i].RevokedAt == nil {
+			s.tokens[i].RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// CreateVerificationToken implements UserStore.
+func (s *MemoryUserStore) CreateVerificationToken(_ context.Context, token models.VerificationToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = int64(len(s.verifications) + 1)
+	token.CreatedAt = time.Now()
+	s.verifications = append(s.verifications, token)
+	return nil
+}
+
+// GetVerificationToken implements UserStore.
+func (s *MemoryUserStore) GetVerificationToken(_ context.Context, tokenHash []byte) (*models.VerificationToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.verifications {
+		if bytes.Equal(token.TokenHash, tokenHash) {
+			return &token, nil
+		}
+	}
+	return nil, fmt.Errorf("get verification token: %w", pgx.ErrNoRows)
+}
+
+// ConfirmEmail implements UserStore.
+func (s *MemoryUserStore) ConfirmEmail(_ context.Context, tokenID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tokenID <= 0 || tokenID > int64(len(s.verifications)) {
+		return fmt.Errorf("confirm email: %w", ErrVerificationTokenSpent)
+	}
+	token := &s.verifications[tokenID-1]
+	if token.UsedAt != nil {
+		return fmt.Errorf("confirm email: %w", ErrVerificationTokenSpent)
+	}
+	if token.UserID <= 0 || token.UserID > int64(len(s.users)) || !strings.EqualFold(s.users[token.UserID-1].Email, token.Email) {
+		return fmt.Errorf("confirm email: %w", pgx.ErrNoRows)
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	s.users[token.UserID-1].EmailVerified = true
+	return nil
+}
+
+// CreateAPIKey implements UserStore.
+func (s *MemoryUserStore) CreateAPIKey(_ context.Context, key models.APIKey) (*models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.ID = int64(len(s.apiKeys) + 1)
+	key.CreatedAt = time.Now()
+	s.apiKeys = append(s.apiKeys, key)
+	return &key, nil
+}
+
+// GetAPIKey implements UserStore.
+func (s *MemoryUserStore) GetAPIKey(_ context.Context, keyHash []byte) (*models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.apiKeys {
+		if bytes.Equal(key.KeyHash, keyHash) {
+			return &key, nil
+		}
+	}
+	return nil, fmt.Errorf("get api key: %w", pgx.ErrNoRows)
+}
+
+// ListAPIKeys implements UserStore.
+func (s *MemoryUserStore) ListAPIKeys(_ context.Context, userID int64) ([]models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]models.APIKey, 0)
+	for i := len(s.apiKeys) - 1; i >= 0; i-- {
+		if key := s.apiKeys[i]; key.UserID == userID && key.RevokedAt == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey implements UserStore.
+func (s *MemoryUserStore) RevokeAPIKey(_ context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id <= 0 || id > int64(len(s.apiKeys)) {
+		return fmt.Errorf("revoke api key: %w", pgx.ErrNoRows)
+	}
+	key := &s.apiKeys[id-1]
+	if key.UserID != userID || key.RevokedAt != nil {
+		return fmt.Errorf("revoke api key: %w", pgx.ErrNoRows)
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	return nil
+}
+
+// TouchAPIKey implements UserStore.
+func (s *MemoryUserStore) TouchAPIKey(_ context.Context, id int64, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id > 0 && id <= int64(len(s.apiKeys)) {
+		s.apiKeys[id-1].LastUsedAt = &usedAt
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) insertLocked(token models.RefreshToken) {
+	token.ID = int64(len(s.tokens) + 1)
+	token.CreatedAt = time.Now()
+	s.tokens = append(s.tokens, token)
+}
@@ -0,0 +1,226 @@
+// Package doctor runs the startup self-check: it loads the configuration, tries every
+// dependency with a short timeout and prints a pass/fail report with hints for fixing what
+// failed. It backs cmd/scripts/doctor and the server's --check flag.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+)
+
+// checkTimeout bounds each check, so an unreachable host fails fast instead of hanging.
+const checkTimeout = 3 * time.Second
+
+// Status is the outcome of a check.
+type Status int
+
+const (
+	Pass Status = iota
+	// Warn marks a failure the server starts with, such as an optional dependency down.
+	Warn
+	// Fail marks a failure the server cannot work with; it makes the exit code non-zero.
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "ok"
+	case Warn:
+		return "warn"
+	default:
+		return "fail"
+	}
+}
+
+// Result is the outcome of one check; Hint tells how to fix a failure.
+type Result struct {
+	Name   string
+	Status Status
+	Detail string
+	Hint   string
+}
+
+// Check runs one self-check.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) Result
+}
+
+// Checks returns the self-checks for cfg in report order.
+func Checks(cfg *config.Config) []Check {
+	return []Check{
+		{Name: "postgres", Run: func(ctx context.Context) Result { return checkPostgres(ctx, cfg) }},
+		{Name: "mongo", Run: func(ctx context.Context) Result { return checkMongo(ctx, cfg) }},
+		{Name: "redis", Run: func(ctx context.Context) Result { return checkRedis(ctx, cfg) }},
+		{Name: "qiniu", Run: func(ctx context.Context) Result { return checkQiniu(ctx, cfg) }},
+	}
+}
+
+// Main loads the configuration, runs every check, writes the report to w and returns the
+// process exit code: 1 when the configuration or a hard check failed, 0 otherwise.
+func Main(ctx context.Context, w io.Writer) int {
+	color := useColor(w)
+	cfg, err := config.Load()
+	if err != nil {
+		writeResult(w, color, Result{
+			Name:   "config",
+			Status: Fail,
+			Detail: err.Error(),
+			Hint:   "set the variables in config/.env or the environment; readme.md lists them all",
+		})
+		return 1
+	}
+	writeResult(w, color, Result{Name: "config", Status: Pass, Detail: "loaded"})
+	return report(ctx, w, color, Checks(cfg))
+}
+
+// report runs checks in order, each under its own deadline, writes a line per result and
+// returns 1 when any of them failed, 0 otherwise.
+func report(ctx context.Context, w io.Writer, color bool, checks []Check) int {
+	code := 0
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout*2)
+		result := check.Run(checkCtx)
+		cancel()
+		result.Name = check.Name
+		writeResult(w, color, result)
+		if result.Status == Fail {
+			code = 1
+		}
+	}
+	return code
+}
+
+func checkPostgres(ctx context.Context, cfg *config.Config) Result {
+	dialCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	pool, err := db.NewPostgresPool(dialCtx, cfg.DBURL, db.PostgresOptions{})
+	if err != nil {
+		return Result{Status: Fail, Detail: err.Error(), Hint: "check DB_URL and that postgres accepts connections from this host"}
+	}
+	defer pool.Close()
+
+	columns, err := db.RoleColumns(ctx, pool)
+	if err != nil {
+		return Result{Status: Fail, Detail: err.Error(), Hint: "check that the DB_URL user may read information_schema"}
+	}
+	if len(columns) == 0 {
+		return Result{Status: Fail, Detail: "connected, but the roles table does not exist", Hint: "run go run cmd/scripts/migrate/main.go up, or set DB_AUTO_MIGRATE=true"}
+	}
+	if missing := db.MissingRoleColumns(columns); len(missing) > 0 {
+		return Result{
+			Status: Fail,
+			Detail: "roles table is missing or has outdated columns: " + strings.Join(missing, ", "),
+			Hint:   "run go run cmd/scripts/migrate/main.go up; go run cmd/scripts/inspect_roles/main.go shows the current columns",
+		}
+	}
+	return Result{Status: Pass, Detail: fmt.Sprintf("connected, roles schema has all %d required columns", len(db.RequiredRoleColumns))}
+}
+
+func checkMongo(ctx context.Context, cfg *config.Config) Result {
+	client, err := db.OpenMongoClient(ctx, cfg.MongoURI)
+	if err != nil {
+		return Result{Status: Warn, Detail: err.Error(), Hint: "check MONGO_URI; memory and ASR session history stay off without mongo"}
+	}
+	defer client.Disconnect(context.WithoutCancel(ctx))
+
+	pingCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	if err := client.Ping(pingCtx, nil); err != nil {
+		return Result{Status: Warn, Detail: err.Error(), Hint: "check MONGO_URI and that mongo is running; the server starts without it and degrades memory and ASR session history"}
+	}
+	return Result{Status: Pass, Detail: "connected"}
+}
+
+func checkRedis(ctx context.Context, cfg *config.Config) Result {
+	client, err := db.OpenRedisClient(cfg.RedisURL)
+	if err != nil {
+		return Result{Status: Warn, Detail: err.Error(), Hint: "check REDIS_URL"}
+	}
+	defer client.Close()
+
+	pingCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	if err := client.Ping(pingCtx).Err(); err != nil {
+		return Result{Status: Warn, Detail: err.Error(), Hint: "check REDIS_URL and that redis is running; the server starts without it, but caches, shared rate limits and the job queue fall back or pause"}
+	}
+	return Result{Status: Pass, Detail: "connected"}
+}
+
+// checkQiniu calls /voice/list, the cheapest authenticated Qiniu endpoint, to verify both
+// the base URL and the key.
+func checkQiniu(ctx context.Context, cfg *config.Config) Result {
+	if cfg.QiniuAPIKey == "" {
+		return Result{Status: Warn, Detail: "QINIU_API_KEY is not set", Hint: "without it every request needs a client token; set QINIU_API_KEY to let the server call Qiniu itself"}
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, cfg.QiniuAPIBaseURL+"/voice/list", nil)
+	if err != nil {
+		return Result{Status: Fail, Detail: err.Error(), Hint: "check QINIU_API_BASE_URL"}
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.QiniuAPIKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Result{Status: Fail, Detail: err.Error(), Hint: "check QINIU_API_BASE_URL and outbound network access"}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return Result{Status: Fail, Detail: fmt.Sprintf("%s rejected the key: %s", cfg.QiniuAPIBaseURL, resp.Status), Hint: "check QINIU_API_KEY; keys are issued in the Qiniu console"}
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return Result{Status: Fail, Detail: fmt.Sprintf("%s/voice/list answered %s", cfg.QiniuAPIBaseURL, resp.Status), Hint: "check QINIU_API_BASE_URL points at the Qiniu AI API"}
+	}
+	return Result{Status: Pass, Detail: "key accepted by " + cfg.QiniuAPIBaseURL}
+}
+
+const (
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorRed    = "\x1b[31m"
+	colorReset  = "\x1b[0m"
+)
+
+func writeResult(w io.Writer, color bool, result Result) {
+	label := fmt.Sprintf("[%-4s]", result.Status)
+	if color {
+		code := colorGreen
+		switch result.Status {
+		case Warn:
+			code = colorYellow
+		case Fail:
+			code = colorRed
+		}
+		label = code + label + colorReset
+	}
+	fmt.Fprintf(w, "%s %-8s %s\n", label, result.Name, result.Detail)
+	if result.Status != Pass && result.Hint != "" {
+		fmt.Fprintf(w, "%15s hint: %s\n", "", result.Hint)
+	}
+}
+
+// useColor reports whether w is a terminal and NO_COLOR is unset.
+func useColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,179 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+)
+
+// fakeCheck returns a check that reports result, leaving its name to the report.
+func fakeCheck(name string, result Result) Check {
+	return Check{Name: name, Run: func(context.Context) Result { return result }}
+}
+
+func TestReport(t *testing.T) {
+	cases := []struct {
+		name     string
+		checks   []Check
+		wantCode int
+		want     []string
+	}{
+		{"all pass", []Check{
+			fakeCheck("postgres", Result{Status: Pass, Detail: "connected", Hint: "never shown"}),
+			fakeCheck("redis", Result{Status: Pass, Detail: "connected"}),
+		}, 0, []string{
+			"[ok  ] postgres connected",
+			"[ok  ] redis    connected",
+		}},
+		{"warnings keep the exit code", []Check{
+			fakeCheck("mongo", Result{Status: Warn, Detail: "connection refused", Hint: "check MONGO_URI"}),
+			fakeCheck("qiniu", Result{Status: Pass, Detail: "key accepted"}),
+		}, 0, []string{
+			"[warn] mongo    connection refused",
+			"                hint: check MONGO_URI",
+			"[ok  ] qiniu    key accepted",
+		}},
+		{"a failure fails the run and later checks still run", []Check{
+			fakeCheck("postgres", Result{Status: Fail, Detail: "roles table missing", Hint: "run the migrations"}),
+			fakeCheck("redis", Result{Status: Warn, Detail: "timeout"}),
+			fakeCheck("qiniu", Result{Status: Pass, Detail: "key accepted"}),
+		}, 1, []string{
+			"[fail] postgres roles table missing",
+			"                hint: run the migrations",
+			"[warn] redis    timeout",
+			"[ok  ] qiniu    key accepted",
+		}},
+		{"the check name wins over the result's", []Check{
+			fakeCheck("redis", Result{Name: "something else", Status: Pass, Detail: "connected"}),
+		}, 0, []string{
+			"[ok  ] redis    connected",
+		}},
+		{"no checks", nil, 0, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if code := report(context.Background(), &out, false, tc.checks); code != tc.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tc.wantCode)
+			}
+			var got []string
+			if out.Len() > 0 {
+				got = strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			}
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("report:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+// TestReportDeadlines checks every check gets its own deadline under the caller's context.
+func TestReportDeadlines(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	var deadlines []time.Time
+	record := Check{Name: "record", Run: func(ctx context.Context) Result {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Error("check ran without a deadline")
+		}
+		deadlines = append(deadlines, deadline)
+		return Result{Status: Pass}
+	}}
+	cancelling := Check{Name: "cancel", Run: func(ctx context.Context) Result {
+		cancel()
+		return Result{Status: Pass}
+	}}
+	cancelled := Check{Name: "cancelled", Run: func(ctx context.Context) Result {
+		if ctx.Err() == nil {
+			return Result{Status: Pass}
+		}
+		return Result{Status: Fail, Detail: ctx.Err().Error()}
+	}}
+
+	start := time.Now()
+	code := report(parent, &bytes.Buffer{}, false, []Check{record, record, cancelling, cancelled})
+	if len(deadlines) != 2 {
+		t.Fatalf("%d deadlines recorded, want 2", len(deadlines))
+	}
+	for _, deadline := range deadlines {
+		if limit := start.Add(checkTimeout * 2); deadline.Before(start) || deadline.After(limit.Add(time.Second)) {
+			t.Errorf("deadline %s, want about %s from the start", deadline.Sub(start), checkTimeout*2)
+		}
+	}
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1 once the caller's context is cancelled", code)
+	}
+}
+
+func TestWriteResultColor(t *testing.T) {
+	cases := []struct {
+		status Status
+		color  string
+	}{
+		{Pass, colorGreen},
+		{Warn, colorYellow},
+		{Fail, colorRed},
+	}
+	for _, tc := range cases {
+		var out bytes.Buffer
+		writeResult(&out, true, Result{Name: "redis", Status: tc.status, Detail: "detail"})
+		want := tc.color + "[" + tc.status.String()
+		if !strings.HasPrefix(out.String(), want) || !strings.Contains(out.String(), colorReset+" redis") {
+			t.Errorf("%s line = %q, want the label wrapped in %q", tc.status, out.String(), tc.color)
+		}
+	}
+	if useColor(&bytes.Buffer{}) {
+		t.Error("color used for a writer that is not a terminal")
+	}
+}
+
+func TestCheckQiniu(t *testing.T) {
+	var gotAuth string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/voice/list" {
+			http.NotFound(w, r)
+			return
+		}
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name    string
+		baseURL string
+		key     string
+		status  int
+		want    Status
+		detail  string
+	}{
+		{"key accepted", server.URL, "server-key", http.StatusOK, Pass, "key accepted"},
+		{"key rejected", server.URL, "server-key", http.StatusUnauthorized, Fail, "rejected the key"},
+		{"key forbidden", server.URL, "server-key", http.StatusForbidden, Fail, "rejected the key"},
+		{"upstream error", server.URL, "server-key", http.StatusBadGateway, Fail, "answered 502"},
+		{"wrong base URL", server.URL + "/v2", "server-key", http.StatusOK, Fail, "answered 404"},
+		{"no key", server.URL, "", http.StatusOK, Warn, "QINIU_API_KEY is not set"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotAuth, status = "", tc.status
+			result := checkQiniu(context.Background(), &config.Config{QiniuAPIBaseURL: tc.baseURL, QiniuAPIKey: tc.key})
+			if result.Status != tc.want || !strings.Contains(result.Detail, tc.detail) {
+				t.Fatalf("got %s %q, want %s containing %q", result.Status, result.Detail, tc.want, tc.detail)
+			}
+			if tc.want != Pass && result.Hint == "" {
+				t.Error("failure without a hint")
+			}
+			if tc.key != "" && tc.baseURL == server.URL && gotAuth != "Bearer "+tc.key {
+				t.Errorf("Authorization = %q, want Bearer %s", gotAuth, tc.key)
+			}
+		})
+	}
+}
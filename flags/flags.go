@@ -0,0 +1,217 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+)
+
+// Definition declares a feature flag and its built-in default.
+type Definition struct {
+	Key         string `json:"key"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+}
+
+// Known flags. Adding a flag here makes it visible to the admin API and the capabilities endpoint.
+var Definitions = []Definition{
+	{Key: "voice.chat", Description: "One-shot POST /api/voice/chat endpoint", Default: true},
+	{Key: "voice.session", Description: "Full-duplex /api/voice/session WebSocket", Default: true},
+}
+
+const (
+	auditMaxLen   = 500
+	redisDeadline = 300 * time.Millisecond
+)
+
+// ErrUnknownFlag is returned when mutating a flag that is not declared in Definitions.
+var ErrUnknownFlag = errors.New("unknown feature flag")
+
+// State is the effective value of a flag together with its override, if any.
+type State struct {
+	Definition
+	Override  *bool `json:"override,omitempty"`
+	Effective bool  `json:"effective"`
+}
+
+// AuditEntry records a single override change.
+type AuditEntry struct {
+	Key    string    `json:"key"`
+	Action string    `json:"action"`
+	Value  *bool     `json:"value,omitempty"`
+	Actor  string    `json:"actor"`
+	At     time.Time `json:"at"`
+}
+
+// Service resolves flags from Redis overrides, falling back to code defaults.
+// Redis failures never disable a feature: reads fail open to the default value.
+type Service struct {
+	kv           *kv.Store
+	client       *redis.Client
+	overridesKey string
+	auditKey     string
+	defs         map[string]Definition
+	logger       *zap.SugaredLogger
+}
+
+// NewService builds a flag service; store may be nil, in which case only defaults apply.
+func NewService(store *kv.Store, logger *zap.SugaredLogger) *Service {
+	defs := make(map[string]Definition, len(Definitions))
+	for _, def := range Definitions {
+		defs[def.Key] = def
+	}
+	return &Service{
+		kv:           store,
+		client:       store.Client(),
+		overridesKey: store.Key(kv.FlagOverrides),
+		auditKey:     store.Key(kv.FlagAudit),
+		defs:         defs,
+		logger:       logger,
+	}
+}
+
+// Enabled reports whether key is on. Unknown keys are off.
+func (s *Service) Enabled(ctx context.Context, key string) bool {
+	def, ok := s.defs[key]
+	if !ok {
+		return false
+	}
+	if !s.kv.Available() {
+		return def.Default
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisDeadline)
+	defer cancel()
+
+	raw, err := s.client.HGet(ctx, s.overridesKey, key).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			s.logger.Warnf("read feature flag %s, using default %t: %v", key, def.Default, err)
+		}
+		return def.Default
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return def.Default
+	}
+	return value
+}
+
+// List returns every declared flag with its override and effective value, sorted by key.
+// Overrides are omitted (defaults reported) when Redis is unavailable.
+func (s *Service) List(ctx context.Context) []State {
+	overrides := map[string]string{}
+	if s.kv.Available() {
+		readCtx, cancel := context.WithTimeout(ctx, redisDeadline)
+		values, err := s.client.HGetAll(readCtx, s.overridesKey).Result()
+		cancel()
+		if err != nil {
+			s.logger.Warnf("read feature flag overrides, using defaults: %v", err)
+		} else {
+			overrides = values
+		}
+	}
+
+	states := make([]State, 0, len(s.defs))
+	for _, def := range s.defs {
+		state := State{Definition: def, Effective: def.Default}
+		if raw, ok := overrides[def.Key]; ok {
+			if value, err := strconv.ParseBool(raw); err == nil {
+				state.Override = &value
+				state.Effective = value
+			}
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	return states
+}
+
+// Effective maps each declared flag to its effective value.
+func (s *Service) Effective(ctx context.Context) map[string]bool {
+	states := s.List(ctx)
+	result := make(map[string]bool, len(states))
+	for _, state := range states {
+		result[state.Key] = state.Effective
+	}
+	return result
+}
+
+// SetOverride forces key to value cluster-wide and records who did it.
+func (s *Service) SetOverride(ctx context.Context, key string, value bool, actor string) error {
+	if _, ok := s.defs[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownFlag, key)
+	}
+	if !s.kv.Available() {
+		return errors.New("feature flag overrides require redis")
+	}
+	if err := s.client.HSet(ctx, s.overridesKey, key, strconv.FormatBool(value)).Err(); err != nil {
+		return fmt.Errorf("store flag override: %w", err)
+	}
+	s.audit(ctx, AuditEntry{Key: key, Action: "set", Value: &value, Actor: actor, At: time.Now().UTC()})
+	return nil
+}
+
+// ClearOverride drops the override so key reverts to its default.
+func (s *Service) ClearOverride(ctx context.Context, key, actor string) error {
+	if _, ok := s.defs[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownFlag, key)
+	}
+	if !s.kv.Available() {
+		return errors.New("feature flag overrides require redis")
+	}
+	if err := s.client.HDel(ctx, s.overridesKey, key).Err(); err != nil {
+		return fmt.Errorf("clear flag override: %w", err)
+	}
+	s.audit(ctx, AuditEntry{Key: key, Action: "clear", Actor: actor, At: time.Now().UTC()})
+	return nil
+}
+
+// Audit returns the most recent override changes, newest first.
+func (s *Service) Audit(ctx context.Context, limit int) ([]AuditEntry, error) {
+	if !s.kv.Available() {
+		return []AuditEntry{}, nil
+	}
+	if limit <= 0 || limit > auditMaxLen {
+		limit = 50
+	}
+
+	raw, err := s.client.LRange(ctx, s.auditKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("read flag audit log: %w", err)
+	}
+
+	entries := make([]AuditEntry, 0, len(raw))
+	for _, item := range raw {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(item), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *Service) audit(ctx context.Context, entry AuditEntry) {
+	s.logger.Infof("feature flag %s %s by %s", entry.Key, entry.Action, entry.Actor)
+
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	pipe := s.client.TxPipeline()
+	pipe.LPush(ctx, s.auditKey, payload)
+	pipe.LTrim(ctx, s.auditKey, 0, auditMaxLen-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.logger.Warnf("append feature flag audit: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+func newTestService(t *testing.T) (*Service, *miniredis.Miniredis, *kv.Store) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := kv.New(client, "test")
+	return NewService(store, zap.NewNop().Sugar()), server, store
+}
+
+func stateOf(t *testing.T, states []State, key string) State {
+	t.Helper()
+	for _, state := range states {
+		if state.Key == key {
+			return state
+		}
+	}
+	t.Fatalf("flag %s not listed", key)
+	return State{}
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	ctx := context.Background()
+	s, server, store := newTestService(t)
+	// another instance sharing the Redis sees the same overrides
+	other := NewService(store, zap.NewNop().Sugar())
+
+	if !s.Enabled(ctx, "voice.chat") {
+		t.Fatal("voice.chat off without an override, want its default on")
+	}
+	if s.Enabled(ctx, "voice.unknown") {
+		t.Error("an undeclared flag is on")
+	}
+
+	if err := s.SetOverride(ctx, "voice.chat", false, "alice"); err != nil {
+		t.Fatalf("SetOverride: %v", err)
+	}
+	if s.Enabled(ctx, "voice.chat") || other.Enabled(ctx, "voice.chat") {
+		t.Fatal("override off lost to the default on")
+	}
+	state := stateOf(t, other.List(ctx), "voice.chat")
+	if state.Override == nil || *state.Override || state.Effective || !state.Default {
+		t.Errorf("listed %+v, want default on overridden off", state)
+	}
+	if !other.Effective(ctx)["voice.session"] {
+		t.Error("overriding voice.chat changed voice.session")
+	}
+
+	if err := s.SetOverride(ctx, "voice.chat", true, "alice"); err != nil {
+		t.Fatalf("SetOverride: %v", err)
+	}
+	if !s.Enabled(ctx, "voice.chat") {
+		t.Error("the latest override does not win")
+	}
+
+	if err := other.ClearOverride(ctx, "voice.chat", "bob"); err != nil {
+		t.Fatalf("ClearOverride: %v", err)
+	}
+	state = stateOf(t, s.List(ctx), "voice.chat")
+	if state.Override != nil || !state.Effective {
+		t.Errorf("listed %+v after clearing, want the default without an override", state)
+	}
+
+	// a value the service did not write is ignored rather than read as off
+	server.HSet(store.Key(kv.FlagOverrides), "voice.session", "maybe")
+	if !s.Enabled(ctx, "voice.session") || stateOf(t, s.List(ctx), "voice.session").Override != nil {
+		t.Error("an unparsable override replaced the default")
+	}
+
+	if err := s.SetOverride(ctx, "voice.unknown", true, "alice"); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("SetOverride of an undeclared flag = %v, want ErrUnknownFlag", err)
+	}
+	if err := s.ClearOverride(ctx, "voice.unknown", "alice"); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("ClearOverride of an undeclared flag = %v, want ErrUnknownFlag", err)
+	}
+
+	entries, err := s.Audit(ctx, 10)
+	if err != nil {
+		t.Fatalf("Audit: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("audit has %d entries, want 3", len(entries))
+	}
+	if latest := entries[0]; latest.Action != "clear" || latest.Actor != "bob" || latest.Key != "voice.chat" {
+		t.Errorf("latest audit entry = %+v, want bob clearing voice.chat", latest)
+	}
+	if first := entries[2]; first.Action != "set" || first.Value == nil || *first.Value {
+		t.Errorf("oldest audit entry = %+v, want the override off", first)
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("redis down", func(t *testing.T) {
+		s, server, _ := newTestService(t)
+		if err := s.SetOverride(ctx, "voice.chat", false, "alice"); err != nil {
+			t.Fatalf("SetOverride: %v", err)
+		}
+		server.Close()
+
+		// the override cannot be read, so the feature keeps its default rather than switching off
+		if !s.Enabled(ctx, "voice.chat") {
+			t.Error("voice.chat off while Redis is down, want its default on")
+		}
+		if state := stateOf(t, s.List(ctx), "voice.chat"); state.Override != nil || !state.Effective {
+			t.Errorf("listed %+v while Redis is down, want the default", state)
+		}
+		if err := s.SetOverride(ctx, "voice.chat", true, "alice"); err == nil {
+			t.Error("SetOverride succeeded while Redis is down")
+		}
+	})
+
+	t.Run("redis marked unavailable", func(t *testing.T) {
+		s, _, store := newTestService(t)
+		if err := s.SetOverride(ctx, "voice.chat", false, "alice"); err != nil {
+			t.Fatalf("SetOverride: %v", err)
+		}
+		store.TrackAvailability(func() bool { return false })
+
+		if !s.Enabled(ctx, "voice.chat") {
+			t.Error("voice.chat off while Redis is unavailable, want its default on")
+		}
+		if state := stateOf(t, s.List(ctx), "voice.chat"); state.Override != nil || !state.Effective {
+			t.Errorf("listed %+v while Redis is unavailable, want the default as Enabled reports", state)
+		}
+		if err := s.ClearOverride(ctx, "voice.chat", "alice"); err == nil {
+			t.Error("ClearOverride succeeded while Redis is unavailable")
+		}
+		if entries, err := s.Audit(ctx, 10); err != nil || len(entries) != 0 {
+			t.Errorf("Audit = %v, %v while Redis is unavailable, want nothing", entries, err)
+		}
+	})
+
+	t.Run("no redis", func(t *testing.T) {
+		s := NewService(nil, zap.NewNop().Sugar())
+		for key, on := range s.Effective(ctx) {
+			if on != s.defs[key].Default || s.Enabled(ctx, key) != on {
+				t.Errorf("%s = %t without Redis, want its default %t", key, on, s.defs[key].Default)
+			}
+		}
+		if err := s.SetOverride(ctx, "voice.chat", false, "alice"); err == nil {
+			t.Error("SetOverride succeeded without Redis")
+		}
+	})
+}
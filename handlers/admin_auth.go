@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/auth"
+	"github.com/wuwenbin0122/wwb.ai/config"
+)
+
+const adminActorKey = "admin_actor"
+
+// RequireAdmin guards operator endpoints with the shared ADMIN_TOKEN secret sent in the
+// X-Admin-Token header. Admin routes are disabled entirely when no token is configured.
+// The optional X-Admin-Actor header names the operator in audit records.
+func RequireAdmin(cfg *config.Config) gin.HandlerFunc {
+	expected := []byte(strings.TrimSpace(cfg.AdminToken))
+
+	return func(c *gin.Context) {
+		if len(expected) == 0 {
+			abortError(c, apierr.New(apierr.CodeAdminDisabled, "admin api disabled"))
+			return
+		}
+
+		provided := []byte(strings.TrimSpace(c.GetHeader("X-Admin-Token")))
+		if subtle.ConstantTimeCompare(provided, expected) != 1 {
+			abortError(c, apierr.New(apierr.CodeAdminTokenInvalid, "invalid admin token"))
+			return
+		}
+
+		actor := strings.TrimSpace(c.GetHeader("X-Admin-Actor"))
+		if actor == "" {
+			actor = "admin"
+		}
+		c.Set(adminActorKey, actor)
+		c.Next()
+	}
+}
+
+func adminActor(c *gin.Context) string {
+	if actor := c.GetString(adminActorKey); actor != "" {
+		return actor
+	}
+	return "admin"
+}
+
+// RequireUserOrAdmin lets through callers presenting the admin token and otherwise applies
+// RequireUser. A wrong X-Admin-Token is rejected rather than treated as a user request.
+func RequireUserOrAdmin(cfg *config.Config, svc *auth.Service) gin.HandlerFunc {
+	admin := RequireAdmin(cfg)
+	user := RequireUser(svc)
+
+	return func(c *gin.Context) {
+		if strings.TrimSpace(c.GetHeader("X-Admin-Token")) != "" {
+			admin(c)
+			return
+		}
+		user(c)
+	}
+}
+
+// isAdmin reports whether RequireAdmin accepted the request.
+func isAdmin(c *gin.Context) bool {
+	_, ok := c.Get(adminActorKey)
+	return ok
+}
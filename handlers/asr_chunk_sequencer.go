@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// asrReorderWindow is how many frames may arrive ahead of a missing one before the
+// sequencer stops waiting for it and reports a gap.
+const asrReorderWindow = 8
+
+var (
+	errChunkHeader   = errors.New("audio frame is shorter than its sequence header")
+	errChunkChecksum = errors.New("audio frame checksum mismatch")
+)
+
+// asrChunkGap is a run of sequence numbers that never arrived, from First to Last inclusive.
+type asrChunkGap struct {
+	First uint32 `json:"first"`
+	Last  uint32 `json:"last"`
+}
+
+// asrChunkStats counts the audio of one ASR session for the final event. Frames are
+// counted by the socket reader while the upstream reader may snapshot them, hence atomics.
+type asrChunkStats struct {
+	receivedFrames atomic.Int64
+	receivedBytes  atomic.Int64
+	chunks         atomic.Int64
+	bytes          atomic.Int64
+	duplicates     atomic.Int64
+	reordered      atomic.Int64
+	checksumErrors atomic.Int64
+	missing        atomic.Int64
+}
+
+// forwarded records a chunk sent upstream.
+func (s *asrChunkStats) forwarded(chunk []byte) {
+	s.chunks.Add(1)
+	s.bytes.Add(int64(len(chunk)))
+}
+
+// Event returns the counters as reported to the client.
+func (s *asrChunkStats) Event() gin.H {
+	return gin.H{
+		"received_frames": s.receivedFrames.Load(),
+		"received_bytes":  s.receivedBytes.Load(),
+		"audio_chunks":    s.chunks.Load(),
+		"audio_bytes":     s.bytes.Load(),
+		"duplicates":      s.duplicates.Load(),
+		"reordered":       s.reordered.Load(),
+		"checksum_errors": s.checksumErrors.Load(),
+		"missing_chunks":  s.missing.Load(),
+	}
+}
+
+// asrChunkSequencer restores the order of audio frames a client numbered, for networks
+// that resend or reorder WebSocket frames. Each frame starts with a big-endian uint32
+// sequence number counting from 0, followed by a big-endian CRC-32 (IEEE) of the audio
+// when checksums are on. Duplicates are dropped, frames arriving early are held until the
+// ones before them come, and a frame missing while window later ones wait is given up.
+type asrChunkSequencer struct {
+	checksum bool
+	window   int
+	stats    *asrChunkStats
+
+	next    uint32
+	pending map[uint32][]byte
+}
+
+func newASRChunkSequencer(checksum bool, window int, stats *asrChunkStats) *asrChunkSequencer {
+	if window <= 0 {
+		window = asrReorderWindow
+	}
+	return &asrChunkSequencer{checksum: checksum, window: window, stats: stats, pending: make(map[uint32][]byte)}
+}
+
+// Decode splits a frame into its sequence number and audio, verifying the checksum.
+func (s *asrChunkSequencer) Decode(frame []byte) (uint32, []byte, error) {
+	header := 4
+	if s.checksum {
+		header = 8
+	}
+	if len(frame) < header {
+		return 0, nil, errChunkHeader
+	}
+	seq := binary.BigEndian.Uint32(frame)
+	chunk := frame[header:]
+	if s.checksum && crc32.ChecksumIEEE(chunk) != binary.BigEndian.Uint32(frame[4:]) {
+		s.stats.checksumErrors.Add(1)
+		return seq, nil, errChunkChecksum
+	}
+	return seq, chunk, nil
+}
+
+// Push accepts chunk numbered seq and returns the chunks now ready to forward, in order,
+// with the gaps given up on to release them. duplicate reports a frame already seen.
+func (s *asrChunkSequencer) Push(seq uint32, chunk []byte) (ready [][]byte, gaps []asrChunkGap, duplicate bool) {
+	if _, held := s.pending[seq]; held || seq < s.next {
+		s.stats.duplicates.Add(1)
+		return nil, nil, true
+	}
+	if seq != s.next {
+		s.stats.reordered.Add(1)
+	}
+	s.pending[seq] = chunk
+
+	ready = s.drain(ready)
+	for len(s.pending) > s.window {
+		gaps = append(gaps, s.skipToOldest())
+		ready = s.drain(ready)
+	}
+	return ready, gaps, false
+}
+
+// Flush gives up on every missing frame, for the end of the audio, and returns the held
+// chunks in order.
+func (s *asrChunkSequencer) Flush() (ready [][]byte, gaps []asrChunkGap) {
+	for len(s.pending) > 0 {
+		gaps = append(gaps, s.skipToOldest())
+		ready = s.drain(ready)
+	}
+	return ready, gaps
+}
+
+// drain appends the held chunks that continue the sequence to ready.
+func (s *asrChunkSequencer) drain(ready [][]byte) [][]byte {
+	for {
+		chunk, ok := s.pending[s.next]
+		if !ok {
+			return ready
+		}
+		delete(s.pending, s.next)
+		ready = append(ready, chunk)
+		s.next++
+	}
+}
+
+// skipToOldest moves past the missing frames before the oldest held one.
+func (s *asrChunkSequencer) skipToOldest() asrChunkGap {
+	held := make([]uint32, 0, len(s.pending))
+	for seq := range s.pending {
+		held = append(held, seq)
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i] < held[j] })
+	gap := asrChunkGap{First: s.next, Last: held[0] - 1}
+	s.stats.missing.Add(int64(gap.Last-gap.First) + 1)
+	s.next = held[0]
+	return gap
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+// asrFrame builds a client frame: the sequence number, the CRC-32 when checksum is set,
+// then the audio.
+func asrFrame(seq uint32, audio string, checksum bool) []byte {
+	frame := binary.BigEndian.AppendUint32(nil, seq)
+	if checksum {
+		frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE([]byte(audio)))
+	}
+	return append(frame, audio...)
+}
+
+// chunkStrings returns chunks as strings, nil when there are none.
+func chunkStrings(chunks [][]byte) []string {
+	if len(chunks) == 0 {
+		return nil
+	}
+	out := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		out[i] = string(chunk)
+	}
+	return out
+}
+
+func TestASRChunkSequencer(t *testing.T) {
+	type push struct {
+		seq       uint32
+		ready     []string
+		gaps      []asrChunkGap
+		duplicate bool
+	}
+	cases := []struct {
+		name   string
+		window int
+		pushes []push
+		// flush is what Flush returns after the pushes
+		flushReady []string
+		flushGaps  []asrChunkGap
+		stats      map[string]int64
+	}{
+		{
+			name:   "in order",
+			window: 4,
+			pushes: []push{{seq: 0, ready: []string{"0"}}, {seq: 1, ready: []string{"1"}}, {seq: 2, ready: []string{"2"}}},
+			stats:  map[string]int64{"duplicates": 0, "reordered": 0, "missing_chunks": 0},
+		},
+		{
+			name:   "swapped frames are reordered",
+			window: 4,
+			pushes: []push{{seq: 1}, {seq: 0, ready: []string{"0", "1"}}, {seq: 3}, {seq: 2, ready: []string{"2", "3"}}},
+			stats:  map[string]int64{"reordered": 2, "missing_chunks": 0},
+		},
+		{
+			name:   "duplicates are dropped",
+			window: 4,
+			pushes: []push{{seq: 0, ready: []string{"0"}}, {seq: 0, duplicate: true}, {seq: 2}, {seq: 2, duplicate: true}, {seq: 1, ready: []string{"1", "2"}}},
+			stats:  map[string]int64{"duplicates": 2, "reordered": 1},
+		},
+		{
+			name:   "gap given up once the window fills",
+			window: 2,
+			pushes: []push{
+				{seq: 0, ready: []string{"0"}},
+				{seq: 3},
+				{seq: 4},
+				{seq: 5, ready: []string{"3", "4", "5"}, gaps: []asrChunkGap{{First: 1, Last: 2}}},
+				// a late frame from the gap counts as a duplicate
+				{seq: 2, duplicate: true},
+				{seq: 6, ready: []string{"6"}},
+			},
+			stats: map[string]int64{"missing_chunks": 2, "duplicates": 1, "reordered": 3},
+		},
+		{
+			name:       "flush gives up on the rest",
+			window:     8,
+			pushes:     []push{{seq: 0, ready: []string{"0"}}, {seq: 2}, {seq: 5}},
+			flushReady: []string{"2", "5"},
+			flushGaps:  []asrChunkGap{{First: 1, Last: 1}, {First: 3, Last: 4}},
+			stats:      map[string]int64{"missing_chunks": 3},
+		},
+		{
+			name:   "default window",
+			window: 0,
+			pushes: []push{{seq: 1}, {seq: 2}, {seq: 3}, {seq: 4}, {seq: 5}, {seq: 6}, {seq: 7}, {seq: 8},
+				{seq: 9, ready: []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}, gaps: []asrChunkGap{{First: 0, Last: 0}}}},
+			stats: map[string]int64{"missing_chunks": 1},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			stats := &asrChunkStats{}
+			s := newASRChunkSequencer(false, tc.window, stats)
+			for _, p := range tc.pushes {
+				ready, gaps, duplicate := s.Push(p.seq, []byte{byte('0' + p.seq)})
+				got := chunkStrings(ready)
+				if !reflect.DeepEqual(got, p.ready) || !reflect.DeepEqual(gaps, p.gaps) || duplicate != p.duplicate {
+					t.Fatalf("Push(%d) = %q, %v, %t; want %q, %v, %t", p.seq, got, gaps, duplicate, p.ready, p.gaps, p.duplicate)
+				}
+			}
+			ready, gaps := s.Flush()
+			if got := chunkStrings(ready); !reflect.DeepEqual(got, tc.flushReady) {
+				t.Errorf("Flush ready = %q, want %q", got, tc.flushReady)
+			}
+			if !reflect.DeepEqual(gaps, tc.flushGaps) {
+				t.Errorf("Flush gaps = %v, want %v", gaps, tc.flushGaps)
+			}
+			event := stats.Event()
+			for name, want := range tc.stats {
+				if got := event[name]; got != want {
+					t.Errorf("%s = %v, want %d", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestASRChunkSequencerDecode(t *testing.T) {
+	stats := &asrChunkStats{}
+	plain := newASRChunkSequencer(false, 0, stats)
+	checked := newASRChunkSequencer(true, 0, stats)
+
+	if seq, chunk, err := plain.Decode(asrFrame(7, "audio", false)); err != nil || seq != 7 || string(chunk) != "audio" {
+		t.Errorf("Decode = %d, %q, %v", seq, chunk, err)
+	}
+	if seq, chunk, err := checked.Decode(asrFrame(1<<31, "audio", true)); err != nil || seq != 1<<31 || string(chunk) != "audio" {
+		t.Errorf("Decode with checksum = %d, %q, %v", seq, chunk, err)
+	}
+	if _, chunk, err := checked.Decode(asrFrame(3, "", true)); err != nil || len(chunk) != 0 {
+		t.Errorf("Decode of an empty chunk = %q, %v", chunk, err)
+	}
+
+	corrupted := asrFrame(4, "audio", true)
+	corrupted[len(corrupted)-1] ^= 0xff
+	if seq, _, err := checked.Decode(corrupted); !errors.Is(err, errChunkChecksum) || seq != 4 {
+		t.Errorf("Decode of a corrupted frame = %d, %v; want seq 4 and errChunkChecksum", seq, err)
+	}
+	if got := stats.checksumErrors.Load(); got != 1 {
+		t.Errorf("checksum errors = %d, want 1", got)
+	}
+
+	for name, frame := range map[string][]byte{"empty": nil, "short": {0, 0, 1}} {
+		if _, _, err := plain.Decode(frame); !errors.Is(err, errChunkHeader) {
+			t.Errorf("%s frame: Decode = %v, want errChunkHeader", name, err)
+		}
+	}
+	if _, _, err := checked.Decode(asrFrame(1, "", false)); !errors.Is(err, errChunkHeader) {
+		t.Errorf("frame without its checksum: Decode = %v, want errChunkHeader", err)
+	}
+}
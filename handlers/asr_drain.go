@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+)
+
+// liveSessions tracks open streaming sessions so a shutdown can let each one finish its
+// current utterance instead of cutting it off. The zero value is ready to use.
+type liveSessions struct {
+	mu       sync.Mutex
+	draining bool
+	drainCtx context.Context
+	nextID   uint64
+	drains   map[uint64]func(context.Context)
+	wg       sync.WaitGroup
+}
+
+// enter admits a new session, or reports false once draining has begun. Every admitted
+// session must call leave.
+func (l *liveSessions) enter() (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.draining {
+		return 0, false
+	}
+	l.nextID++
+	l.wg.Add(1)
+	return l.nextID, true
+}
+
+// onDrain registers how session id drains. If draining started since enter, drain runs
+// right away.
+func (l *liveSessions) onDrain(id uint64, drain func(context.Context)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.draining {
+		go drain(l.drainCtx)
+		return
+	}
+	if l.drains == nil {
+		l.drains = make(map[uint64]func(context.Context))
+	}
+	l.drains[id] = drain
+}
+
+func (l *liveSessions) leave(id uint64) {
+	l.mu.Lock()
+	delete(l.drains, id)
+	l.mu.Unlock()
+	l.wg.Done()
+}
+
+// Drain stops admitting sessions, asks every open one to drain and waits until all have
+// ended or ctx is done. It returns the number of sessions asked to drain.
+func (l *liveSessions) Drain(ctx context.Context) (int, error) {
+	l.mu.Lock()
+	l.draining = true
+	l.drainCtx = ctx
+	drains := make([]func(context.Context), 0, len(l.drains))
+	for _, drain := range l.drains {
+		drains = append(drains, drain)
+	}
+	l.mu.Unlock()
+
+	for _, drain := range drains {
+		go drain(ctx)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return len(drains), nil
+	case <-ctx.Done():
+		return len(drains), ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+const userIDContextKey = "user_id"
+
+// currentUserID returns the authenticated user for the request, or "" for anonymous callers.
+func currentUserID(c *gin.Context) string {
+	return strings.TrimSpace(c.GetString(userIDContextKey))
+}
+
+func newSessionID() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// asrSessionRecorder accumulates the transcript of one streaming ASR session for archival.
+type asrSessionRecorder struct {
+	mu            sync.Mutex
+	session       models.ASRSession
+	partials      []string
+	storePartials bool
+}
+
+func newASRSessionRecorder(userID string, roleID int64, language string, storePartials bool) *asrSessionRecorder {
+	return &asrSessionRecorder{
+		session: models.ASRSession{
+			SessionID: newSessionID(),
+			UserID:    userID,
+			RoleID:    roleID,
+			Language:  language,
+			StartedAt: time.Now().UTC(),
+		},
+		storePartials: storePartials,
+	}
+}
+
+// Resume continues a session restored from a snapshot, keeping its id and final segments.
+func (r *asrSessionRecorder) Resume(sessionID string, startedAt time.Time, segments []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.session.SessionID = sessionID
+	if !startedAt.IsZero() {
+		r.session.StartedAt = startedAt
+	}
+	for _, segment := range segments {
+		r.session.Utterances = append(r.session.Utterances, models.ASRUtterance{Text: segment})
+	}
+	r.session.Transcript = strings.Join(segments, " ")
+}
+
+// SessionID returns the archive and resume key of the session.
+func (r *asrSessionRecorder) SessionID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.session.SessionID
+}
+
+// StartedAt returns when the session (or the session it resumed) began.
+func (r *asrSessionRecorder) StartedAt() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.session.StartedAt
+}
+
+// Segments returns the final utterance texts recognized so far.
+func (r *asrSessionRecorder) Segments() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	segments := make([]string, 0, len(r.session.Utterances))
+	for _, utterance := range r.session.Utterances {
+		segments = append(segments, utterance.Text)
+	}
+	return segments
+}
+
+// Observe records an upstream transcript before any client-side coalescing and
+// reports whether it completed a new final segment.
+func (r *asrSessionRecorder) Observe(t services.Transcript) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.DurationMS > r.session.DurationMS {
+		r.session.DurationMS = t.DurationMS
+	}
+	if !t.IsFinal {
+		if r.storePartials && t.Text != "" {
+			r.partials = append(r.partials, t.Text)
+		}
+		return false
+	}
+
+	text := strings.TrimSpace(t.Text)
+	utterance := models.ASRUtterance{
+		Text:       text,
+		DurationMS: t.DurationMS,
+		Confidence: t.Confidence,
+		Partials:   r.partials,
+		FinalAt:    time.Now().UTC(),
+	}
+	r.partials = nil
+	if text == "" {
+		return false
+	}
+	r.session.Utterances = append(r.session.Utterances, utterance)
+
+	// upstream finals may repeat the whole transcript so far; only append genuinely new text
+	switch {
+	case strings.HasPrefix(text, r.session.Transcript):
+		r.session.Transcript = text
+	case !strings.HasSuffix(r.session.Transcript, text):
+		r.session.Transcript = strings.TrimSpace(r.session.Transcript + " " + text)
+	}
+	return true
+}
+
+// Finish closes the session and reports whether anything was recognized.
+func (r *asrSessionRecorder) Finish() (models.ASRSession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session := r.session
+	session.EndedAt = time.Now().UTC()
+	return session, session.Transcript != "" || len(session.Utterances) > 0
+}
@@ -10,21 +10,42 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
 	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
 	"github.com/wuwenbin0122/wwb.ai/services"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
 	"go.uber.org/zap"
 )
 
 // AudioHandler orchestrates the ASR/TTS HTTP endpoints exposed by the backend.
 type AudioHandler struct {
 	cfg    *config.Config
+	roles  db.RoleRepository
 	asr    *services.ASRService
-	tts    *services.TTSService
-	logger *zap.SugaredLogger
+	tts      *services.TTSService
+	sessions *db.ASRSessionStore
+	resume   *db.ASRResumeStore
+	voices   *services.VoiceCatalog
+	limiter  *ratelimit.Limiter
+	usage    *db.UsageStore
+	tokens   *tokenresolver.Resolver
+	logger   *zap.SugaredLogger
+
+	// live holds the open ASR WebSocket sessions for Shutdown.
+	live liveSessions
+
+	// custom adds the ready custom voices to HandleVoiceList when set.
+	custom *CustomVoiceHandler
 }
 
 var asrUpgrader = websocket.Upgrader{
@@ -35,39 +56,101 @@ var asrUpgrader = websocket.Upgrader{
 	},
 }
 
-// NewAudioHandler builds a new AudioHandler.
-func NewAudioHandler(cfg *config.Config, asr *services.ASRService, tts *services.TTSService, logger *zap.SugaredLogger) *AudioHandler {
-	return &AudioHandler{cfg: cfg, asr: asr, tts: tts, logger: logger}
+// NewAudioHandler builds a new AudioHandler; usage may be nil to skip usage accounting.
+func NewAudioHandler(cfg *config.Config, roles db.RoleRepository, asr *services.ASRService, tts *services.TTSService, sessions *db.ASRSessionStore, resume *db.ASRResumeStore, voices *services.VoiceCatalog, limiter *ratelimit.Limiter, usage *db.UsageStore, logger *zap.SugaredLogger) *AudioHandler {
+	return &AudioHandler{cfg: cfg, roles: roles, asr: asr, tts: tts, sessions: sessions, resume: resume, voices: voices, limiter: limiter, usage: usage, tokens: tokenresolver.New(cfg.QiniuTokenMode, cfg.QiniuAPIKey), logger: logger}
 }
 
 type asrClientMessage struct {
-	Type       string `json:"type"`
-	SampleRate int    `json:"sampleRate"`
-	Channels   int    `json:"channels"`
-	Bits       int    `json:"bits"`
-	Token      string `json:"token"`
+	Type       string   `json:"type"`
+	SampleRate int      `json:"sampleRate"`
+	Channels   int      `json:"channels"`
+	Bits       int      `json:"bits"`
+	Token      string   `json:"token"`
+	Language   string   `json:"language"`
+	Hotwords   []string `json:"hotwords"`
+	// ResumeSessionID continues a session interrupted by a disconnect or proxy restart.
+	ResumeSessionID string `json:"resume_session_id"`
+	// Sequenced declares that every binary frame starts with its sequence number; Checksum
+	// "crc32" adds a CRC-32 of the audio after it.
+	Sequenced bool   `json:"sequenced"`
+	Checksum  string `json:"checksum"`
+}
+
+type asrRequest struct {
+	Token       string   `json:"token"`
+	AudioURL    string   `json:"audio_url"`
+	AudioBase64 string   `json:"audio_base64"`
+	Format      string   `json:"format"`
+	SampleRate  int      `json:"sample_rate"`
+	Channels    int      `json:"channels"`
+	Bits        int      `json:"bits"`
+	Language    string   `json:"language"`
+	Hotwords    []string `json:"hotwords"`
+	RoleID      int64    `json:"role_id"`
+	TimeoutMS   int      `json:"timeout_ms"`
 }
 
 type ttsRequest struct {
 	Token      string  `json:"token"`
 	Text       string  `json:"text"`
+	RoleID     int64   `json:"role_id"`
 	VoiceType  string  `json:"voice_type"`
 	Encoding   string  `json:"encoding"`
 	SpeedRatio float64 `json:"speed_ratio"`
 	TimeoutMS  int     `json:"timeout_ms"`
+	// Target is where the audio will play (web, ios, android; web when empty); encodings the
+	// target cannot play are replaced by one it can, and raw pcm is returned as WAV.
+	Target string `json:"target"`
+
+	PitchRatio  float64 `json:"pitch_ratio"`
+	VolumeRatio float64 `json:"volume_ratio"`
+	Emotion     string  `json:"emotion"`
 }
 
 // HandleASRWebsocket proxies streaming audio to Qiniu's ASR WebSocket endpoint.
 func (h *AudioHandler) HandleASRWebsocket(c *gin.Context) {
 	token := h.resolveTokenFromQuery(c)
 	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "qiniu token is required"})
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
 		return
 	}
 
+	// hotwords derived from the selected role improve recognition of character names
+	var (
+		roleHotwords []string
+		roleID       int64
+	)
+	if raw := strings.TrimSpace(c.Query("role_id")); raw != "" {
+		var err error
+		roleID, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || roleID <= 0 {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid role_id"))
+			return
+		}
+		role, err := h.roles.GetByID(c.Request.Context(), roleID)
+		if err != nil {
+			ctxlog.From(c.Request.Context(), h.logger).Warnf("load role %d for asr hotwords failed: %v", roleID, err)
+		} else if role.VisibleTo(currentUserID(c)) {
+			roleHotwords = services.RoleHotwords(*role)
+		}
+	}
+	queryLanguage := strings.TrimSpace(c.Query("language"))
+	userID := currentUserID(c)
+	// debug=1 forwards frames that fail to decode as upstream_raw events for field debugging
+	debugFrames := c.Query("debug") == "1"
+
+	liveID, admitted := h.live.enter()
+	if !admitted {
+		c.Header("Retry-After", "1")
+		writeError(c, apierr.New(apierr.CodeDraining, "server is shutting down"))
+		return
+	}
+	defer h.live.leave(liveID)
+
 	conn, err := asrUpgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		h.logger.Warnf("asr websocket upgrade failed: %v", err)
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("asr websocket upgrade failed: %v", err)
 		return
 	}
 	defer conn.Close()
@@ -78,24 +161,60 @@ func (h *AudioHandler) HandleASRWebsocket(c *gin.Context) {
 	var (
 		stream       *services.ASRStream
 		streamMu     sync.Mutex
-		writeMu      sync.Mutex
 		upstreamOnce sync.Once
 		upstreamDone = make(chan struct{})
+		recorder     *asrSessionRecorder
+		cleanClose   bool
+		draining     atomic.Bool
+		stopped      atomic.Bool
+		sequencer    *asrChunkSequencer
+		chunkStats   asrChunkStats
 	)
 
+	// saveSnapshot externalizes the session so a reconnect to any instance can resume it.
+	// It runs asynchronously and never affects the live stream.
+	saveSnapshot := func(rec *asrSessionRecorder, snapshot db.ASRStreamSnapshot) {
+		if h.resume == nil {
+			return
+		}
+		current := snapshot
+		current.Segments = rec.Segments()
+		go func() {
+			saveCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := h.resume.Save(saveCtx, current); err != nil {
+				ctxlog.From(c.Request.Context(), h.logger).Warnf("snapshot asr session %s failed: %v", current.SessionID, err)
+			}
+		}()
+	}
+
+	// all client frames go through one bounded queue; interim transcripts are dropped
+	// instead of stalling the upstream reader when the client cannot keep up
+	sendQueue := newWSSendQueue(ctx, conn, defaultSendQueueSize, func(err error) {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("write to asr client failed: %v", err)
+		cancel()
+	})
+	defer func() {
+		sendQueue.Close()
+		if dropped := sendQueue.Dropped(); dropped > 0 {
+			h.logger.Infof("asr websocket dropped %d interim transcripts for a slow client", dropped)
+		}
+	}()
+
 	sendJSON := func(payload interface{}) error {
-		writeMu.Lock()
-		defer writeMu.Unlock()
-		return conn.WriteJSON(payload)
+		if !sendQueue.Send(payload) {
+			return errClientGone
+		}
+		return nil
 	}
 
 	sendError := func(message string, detail error) {
 		errMsg := gin.H{"type": "error", "error": message}
 		if detail != nil {
 			errMsg["detail"] = detail.Error()
-			h.logger.Warnf("asr websocket error: %s: %v", message, detail)
+			ctxlog.From(c.Request.Context(), h.logger).Warnf("asr websocket error: %s: %v", message, detail)
 		} else {
-			h.logger.Warnf("asr websocket error: %s", message)
+			ctxlog.From(c.Request.Context(), h.logger).Warnf("asr websocket error: %s", message)
 		}
 		_ = sendJSON(errMsg)
 	}
@@ -116,59 +235,167 @@ func (h *AudioHandler) HandleASRWebsocket(c *gin.Context) {
 		closeUpstream()
 	}()
 
-	handleUpstream := func(s *services.ASRStream) {
+	// drain ends the session for a server shutdown: the upstream is asked to finalize the
+	// current utterance, its last transcripts are flushed to the client, then a closing
+	// event and a going-away close frame are sent. The snapshot is kept so the client can
+	// resume on another instance.
+	var drainOnce sync.Once
+	h.live.onDrain(liveID, func(drainCtx context.Context) {
+		drainOnce.Do(func() {
+			draining.Store(true)
+			streamMu.Lock()
+			current := stream
+			streamMu.Unlock()
+			if current != nil {
+				if err := current.SendStop(); err != nil {
+					ctxlog.From(c.Request.Context(), h.logger).Warnf("send asr stop on shutdown: %v", err)
+				}
+				select {
+				case <-upstreamDone:
+				case <-drainCtx.Done():
+				case <-ctx.Done():
+				}
+			}
+			_ = sendJSON(gin.H{"type": "closing", "reason": "server_shutdown", "stats": chunkStats.Event()})
+			sendQueue.Close()
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"),
+				time.Now().Add(time.Second))
+			cancel()
+			_ = conn.Close()
+		})
+	})
+
+	defer func() {
+		if recorder == nil {
+			return
+		}
+		if h.resume != nil && cleanClose {
+			deleteCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			if err := h.resume.Delete(deleteCtx, recorder.SessionID()); err != nil {
+				ctxlog.From(c.Request.Context(), h.logger).Warnf("drop asr snapshot failed: %v", err)
+			}
+			cancel()
+		}
+		session, recognized := recorder.Finish()
+		if session.DurationMS > 0 {
+			h.usage.Record(asrUsage(session.UserID, session.RoleID, session.DurationMS))
+		}
+		if h.sessions != nil && recognized {
+			h.sessions.Enqueue(session)
+		}
+	}()
+
+	partialInterval := time.Duration(h.cfg.ASRPartialIntervalMS) * time.Millisecond
+
+	handleUpstream := func(s *services.ASRStream, rec *asrSessionRecorder, persist func()) {
+		coalescer := newTranscriptCoalescer(partialInterval, func(event gin.H) bool {
+			if isFinal, _ := event["is_final"].(bool); isFinal {
+				return sendQueue.Send(event)
+			}
+			return sendQueue.Offer(event)
+		})
+
 		go func() {
 			defer closeUpstream()
+			defer func() {
+				// the closing event of a drain carries the counts instead
+				if !draining.Load() {
+					_ = sendJSON(gin.H{"type": "closed", "stats": chunkStats.Event()})
+				}
+			}()
+			defer coalescer.Close()
 			for {
-				msgType, payload, err := s.Conn.ReadMessage()
+				received, err := s.Recv()
 				if err != nil {
+					if draining.Load() {
+						// the upstream closes after finalizing the stop sent by drain
+						return
+					}
+					if stopped.Load() && websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+						// the upstream ends the session this way after the final result of a
+						// client stop; the closed event tells the client it is over
+						return
+					}
 					if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-						h.logger.Warnf("qiniu asr websocket closed unexpectedly: %v", err)
+						ctxlog.From(c.Request.Context(), h.logger).Warnf("qiniu asr websocket closed unexpectedly: %v", err)
 					}
 					sendError("upstream connection closed", err)
 					return
 				}
 
-				switch msgType {
-				case websocket.BinaryMessage:
-					envelope, raw, err := services.ParseASRWSMessage(payload)
-					if err != nil {
-						sendError("parse upstream payload", err)
-						continue
+				switch {
+				case received.Err != nil:
+					frame := received.Frame
+					services.DebugHexDump(h.logger, "unparseable asr frame", received.Payload)
+					sendError("parse upstream payload", received.Err)
+					if debugFrames {
+						rawEvent := gin.H{"type": "upstream_raw", "base64": base64.StdEncoding.EncodeToString(received.Payload)}
+						if frame != nil {
+							rawEvent["message_type"] = frame.MessageType
+							rawEvent["diagnostics"] = frame.Diagnostics
+							if frame.HasSequence {
+								rawEvent["sequence"] = frame.Sequence
+							}
+						}
+						_ = sendJSON(rawEvent)
+					}
+				case received.Control != "":
+					// Forward text control frames as-is for debugging.
+					_ = sendJSON(gin.H{"type": "upstream", "payload": received.Control})
+				default:
+					transcript := received.Transcript
+					if rec.Observe(transcript) {
+						persist()
 					}
-					text, isFinal, duration := services.ExtractTranscript(envelope)
-					event := gin.H{"type": "transcript", "is_final": isFinal}
-					if text != "" {
-						event["text"] = text
+					event := gin.H{"type": "transcript", "is_final": transcript.IsFinal}
+					if transcript.Text != "" {
+						event["text"] = transcript.Text
 					}
-					if duration > 0 {
-						event["duration_ms"] = duration
+					if transcript.DurationMS > 0 {
+						event["duration_ms"] = transcript.DurationMS
 					}
-					if len(raw) > 0 {
-						event["raw"] = json.RawMessage(raw)
+					if transcript.Confidence > 0 {
+						event["confidence"] = transcript.Confidence
 					}
-					if err := sendJSON(event); err != nil {
-						h.logger.Warnf("send transcript to client failed: %v", err)
-						return
+					if len(transcript.Words) > 0 {
+						event["words"] = transcript.Words
 					}
-				case websocket.TextMessage:
-					// Forward text control frames as-is for debugging.
-					msg := strings.TrimSpace(string(payload))
-					if msg != "" {
-						_ = sendJSON(gin.H{"type": "upstream", "payload": msg})
+					if len(received.Raw) > 0 {
+						event["raw"] = received.Raw
 					}
-				default:
-					// ignore
+					coalescer.Push(event, transcript.Text, transcript.IsFinal)
 				}
 			}
 		}()
 	}
 
+	forwardAudio := func(current *services.ASRStream, chunk []byte) bool {
+		if err := current.SendAudioChunk(chunk); err != nil {
+			sendError("forward audio chunk", err)
+			closeUpstream()
+			return false
+		}
+		chunkStats.forwarded(chunk)
+		return true
+	}
+
+	// audio_gap warnings tell the client which frames were given up on, so it can flag
+	// the transcript around them
+	warnGaps := func(gaps []asrChunkGap) {
+		for _, gap := range gaps {
+			ctxlog.From(c.Request.Context(), h.logger).Debugf("asr audio frames %d-%d missing", gap.First, gap.Last)
+			_ = sendJSON(gin.H{"type": "warning", "code": "audio_gap", "first": gap.First, "last": gap.Last})
+		}
+	}
+
 	for {
 		msgType, payload, err := conn.ReadMessage()
 		if err != nil {
-			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-				h.logger.Warnf("client asr websocket closed: %v", err)
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				cleanClose = true
+			} else if !draining.Load() {
+				ctxlog.From(c.Request.Context(), h.logger).Warnf("client asr websocket closed: %v", err)
 			}
 			break
 		}
@@ -192,8 +419,14 @@ func (h *AudioHandler) HandleASRWebsocket(c *gin.Context) {
 					continue
 				}
 
+				checksum := strings.ToLower(strings.TrimSpace(msg.Checksum))
+				if checksum != "" && (checksum != "crc32" || !msg.Sequenced) {
+					sendError("invalid audio framing", fmt.Errorf("checksum %q needs sequenced frames and must be crc32", msg.Checksum))
+					continue
+				}
+
 				sessionToken := token
-				if candidate := strings.TrimSpace(msg.Token); candidate != "" {
+				if candidate := strings.TrimSpace(msg.Token); candidate != "" && h.tokens.AcceptsClientTokens() {
 					sessionToken = candidate
 				}
 
@@ -210,7 +443,63 @@ func (h *AudioHandler) HandleASRWebsocket(c *gin.Context) {
 					bits = 16
 				}
 
-				upstream, err := h.asr.OpenStream(ctx, sessionToken, sr, ch, bits)
+				language := strings.TrimSpace(msg.Language)
+				if language == "" {
+					language = queryLanguage
+				}
+				hotwords := append(append([]string(nil), msg.Hotwords...), roleHotwords...)
+
+				var resumed *db.ASRStreamSnapshot
+				if resumeID := strings.TrimSpace(msg.ResumeSessionID); resumeID != "" {
+					if h.resume == nil {
+						sendError("session resume is not enabled", nil)
+						continue
+					}
+					prior, found, err := h.resume.Load(ctx, resumeID)
+					if err != nil {
+						sendError("load resumable session", err)
+						continue
+					}
+					// an anonymous session resumes only anonymously, so a signed-in user cannot
+					// take it over
+					if !found || prior.UserID != userID {
+						sendError("resumable session not found or expired", nil)
+						continue
+					}
+					if prior.RoleID > 0 {
+						role, err := h.roles.GetByID(ctx, prior.RoleID)
+						if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+							sendError("load role of resumable session", err)
+							continue
+						}
+						if err != nil || !role.VisibleTo(userID) {
+							sendError("role of resumable session not found", nil)
+							continue
+						}
+					}
+					// reopen upstream with exactly the settings of the interrupted stream
+					resumed = &prior
+					sr, ch, bits = prior.SampleRate, prior.Channels, prior.Bits
+					language, hotwords, roleID = prior.Language, prior.Hotwords, prior.RoleID
+				}
+
+				upstream, err := h.asr.OpenStream(ctx, sessionToken, services.ASRStreamOptions{
+					SampleRate: sr,
+					Channels:   ch,
+					Bits:       bits,
+					Language:   language,
+					Hotwords:   hotwords,
+				})
+				if errors.Is(err, services.ErrTooManyStreams) {
+					ctxlog.From(c.Request.Context(), h.logger).Warnf("asr stream capacity reached (%d active), rejecting client", h.asr.ActiveStreams())
+					_ = sendJSON(gin.H{"type": "error", "code": "capacity", "error": "speech recognition is at capacity, retry shortly"})
+					sendQueue.Close()
+					_ = conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "asr capacity reached"),
+						time.Now().Add(time.Second))
+					closeUpstream()
+					return
+				}
 				if err != nil {
 					sendError("open upstream stream", err)
 					continue
@@ -219,17 +508,64 @@ func (h *AudioHandler) HandleASRWebsocket(c *gin.Context) {
 				streamMu.Lock()
 				stream = upstream
 				streamMu.Unlock()
+				if msg.Sequenced {
+					sequencer = newASRChunkSequencer(checksum != "", asrReorderWindow, &chunkStats)
+				}
 
-				handleUpstream(upstream)
+				recorder = newASRSessionRecorder(userID, roleID, language, h.cfg.ASRStorePartials)
+				if resumed != nil {
+					recorder.Resume(resumed.SessionID, resumed.StartedAt, resumed.Segments)
+				}
+				snapshot := db.ASRStreamSnapshot{
+					SessionID:  recorder.SessionID(),
+					UserID:     userID,
+					RoleID:     roleID,
+					SampleRate: sr,
+					Channels:   ch,
+					Bits:       bits,
+					Language:   language,
+					Hotwords:   hotwords,
+					StartedAt:  recorder.StartedAt(),
+				}
+				sessionRecorder := recorder
+				persist := func() { saveSnapshot(sessionRecorder, snapshot) }
+				persist()
+				if h.resume != nil {
+					go func() {
+						ticker := time.NewTicker(h.resume.TTL() / 3)
+						defer ticker.Stop()
+						for {
+							select {
+							case <-ctx.Done():
+								return
+							case <-ticker.C:
+								persist()
+							}
+						}
+					}()
+				}
+				handleUpstream(upstream, recorder, persist)
 
 				ack := gin.H{
 					"type":       "ready",
+					"session_id": recorder.SessionID(),
 					"sampleRate": sr,
 					"channels":   ch,
 					"bits":       bits,
 				}
+				if sequencer != nil {
+					ack["sequenced"] = true
+					if checksum != "" {
+						ack["checksum"] = checksum
+					}
+				}
+				if resumed != nil {
+					// replay the final segments recognized before the interruption
+					ack["resumed"] = true
+					ack["segments"] = resumed.Segments
+				}
 				if err := sendJSON(ack); err != nil {
-					h.logger.Warnf("send ready event failed: %v", err)
+					ctxlog.From(c.Request.Context(), h.logger).Warnf("send ready event failed: %v", err)
 					closeUpstream()
 					return
 				}
@@ -239,7 +575,20 @@ func (h *AudioHandler) HandleASRWebsocket(c *gin.Context) {
 				current := stream
 				streamMu.Unlock()
 				if current != nil {
-					if err := current.Writer.SendStop(); err != nil {
+					if sequencer != nil {
+						// frames still waiting for a missing one are the end of the audio
+						ready, gaps := sequencer.Flush()
+						warnGaps(gaps)
+						for _, chunk := range ready {
+							if !forwardAudio(current, chunk) {
+								return
+							}
+						}
+					}
+					// set first, as the upstream may close as soon as the stop reaches it
+					stopped.Store(true)
+					if err := current.SendStop(); err != nil {
+						stopped.Store(false)
 						sendError("send stop", err)
 					}
 				}
@@ -259,10 +608,31 @@ func (h *AudioHandler) HandleASRWebsocket(c *gin.Context) {
 				sendError("stream not initialized", errors.New("start message required before audio"))
 				continue
 			}
-			if err := current.Writer.SendAudioChunk(payload); err != nil {
-				sendError("forward audio chunk", err)
-				closeUpstream()
-				return
+			chunkStats.receivedFrames.Add(1)
+			chunkStats.receivedBytes.Add(int64(len(payload)))
+			if sequencer == nil {
+				if !forwardAudio(current, payload) {
+					return
+				}
+				continue
+			}
+
+			seq, chunk, err := sequencer.Decode(payload)
+			if errors.Is(err, errChunkChecksum) {
+				// dropped like a lost frame: a resend fills its place, else it becomes a gap
+				_ = sendJSON(gin.H{"type": "warning", "code": "audio_checksum", "sequence": seq})
+				continue
+			}
+			if err != nil {
+				sendError("invalid audio frame", err)
+				continue
+			}
+			ready, gaps, _ := sequencer.Push(seq, chunk)
+			warnGaps(gaps)
+			for _, chunk := range ready {
+				if !forwardAudio(current, chunk) {
+					return
+				}
 			}
 
 		case websocket.CloseMessage:
@@ -278,37 +648,167 @@ func (h *AudioHandler) HandleASRWebsocket(c *gin.Context) {
 	<-upstreamDone
 }
 
+// Shutdown drains the open ASR WebSocket sessions, letting each deliver its final
+// transcript and a {"type":"closing","reason":"server_shutdown"} event before closing.
+// New sessions are refused with 503 from the first call. It returns when every session
+// has ended or ctx is done.
+func (h *AudioHandler) Shutdown(ctx context.Context) error {
+	drained, err := h.live.Drain(ctx)
+	if drained > 0 {
+		h.logger.Infof("drained %d asr websocket sessions", drained)
+	}
+	return err
+}
+
+// HandleASR transcribes a complete recording, referenced by URL or sent inline as base64 pcm/wav.
+func (h *AudioHandler) HandleASR(c *gin.Context) {
+	var req asrRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+
+	input := services.ASRInput{
+		Format:     req.Format,
+		URL:        strings.TrimSpace(req.AudioURL),
+		SampleRate: req.SampleRate,
+		Channels:   req.Channels,
+		Bits:       req.Bits,
+		Language:   req.Language,
+		Hotwords:   req.Hotwords,
+	}
+	if input.URL == "" {
+		encoded := strings.TrimSpace(req.AudioBase64)
+		if encoded == "" {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, "audio_url or audio_base64 is required"))
+			return
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid audio_base64"))
+			return
+		}
+		input.Data = data
+	}
+
+	token := h.resolveToken(c, req.Token)
+	if token == "" {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+	if !h.allowRequest(c, "asr", token) {
+		return
+	}
+
+	ctx, cancel := h.contextWithTimeout(c.Request.Context(), req.TimeoutMS, 60*time.Second)
+	defer cancel()
+
+	if req.RoleID > 0 {
+		if role, err := h.roles.GetByID(ctx, req.RoleID); err != nil {
+			ctxlog.From(c.Request.Context(), h.logger).Warnf("load role %d for asr hotwords failed: %v", req.RoleID, err)
+		} else if role.VisibleTo(currentUserID(c)) {
+			input.Hotwords = append(input.Hotwords, services.RoleHotwords(*role)...)
+		}
+	}
+
+	result, err := h.asr.Recognize(ctx, token, input)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("asr recognize failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "asr processing failed"))
+		return
+	}
+	h.usage.Record(asrUsage(currentUserID(c), req.RoleID, result.DurationMS))
+
+	c.JSON(http.StatusOK, result)
+}
+
+// HandleListASRSessions returns the caller's archived ASR sessions, newest first.
+func (h *AudioHandler) HandleListASRSessions(c *gin.Context) {
+	userID := MustUserID(c)
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	sessions, err := h.sessions.ListByUser(c.Request.Context(), userID, limit)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("list asr sessions failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to list asr sessions"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
 // HandleTTS forwards text-to-speech requests to Qiniu and returns the synthesized audio.
 func (h *AudioHandler) HandleTTS(c *gin.Context) {
 	var req ttsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request payload", "detail": err.Error()})
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
 		return
 	}
 
 	token := h.resolveToken(c, req.Token)
 	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "qiniu token is required"})
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+	if !h.allowRequest(c, "tts", token) {
 		return
 	}
 
 	if strings.TrimSpace(req.Text) == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "text is required"})
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "text is required"))
+		return
+	}
+
+	encoding, err := services.PlaybackEncoding(req.Target, req.Encoding)
+	if err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid tts options").
+			With("supported_encodings", services.TTSEncodings).
+			With("supported_targets", services.TTSTargets))
+		return
+	}
+
+	speech := services.TTSRequest{
+		Text:        req.Text,
+		VoiceType:   req.VoiceType,
+		Encoding:    encoding,
+		SpeedRatio:  req.SpeedRatio,
+		PitchRatio:  req.PitchRatio,
+		VolumeRatio: req.VolumeRatio,
+		Emotion:     req.Emotion,
+	}
+	if err := speech.Validate(); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid tts options"))
 		return
 	}
 
 	ctx, cancel := h.contextWithTimeout(c.Request.Context(), req.TimeoutMS, 90*time.Second)
 	defer cancel()
 
-	result, err := h.tts.Synthesize(ctx, token, services.TTSRequest{
-		Text:       req.Text,
-		VoiceType:  req.VoiceType,
-		Encoding:   req.Encoding,
-		SpeedRatio: req.SpeedRatio,
-	})
+	// voice priority: explicit request value, then the role's voice, then the configured default
+	var role *models.Role
+	if req.RoleID > 0 {
+		loaded, err := h.roles.GetByID(ctx, req.RoleID)
+		if err != nil {
+			ctxlog.From(c.Request.Context(), h.logger).Warnf("load role %d for tts voice failed: %v", req.RoleID, err)
+		} else if loaded.VisibleTo(currentUserID(c)) {
+			role = loaded
+		}
+	}
+
+	result, err := h.tts.Synthesize(ctx, token, h.tts.ResolveVoice(speech, role))
 	if err != nil {
-		h.logger.Warnf("tts synth failed: %v", err)
-		c.JSON(statusFromError(err), gin.H{"error": "tts processing failed", "detail": err.Error()})
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("tts synth failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "tts processing failed"))
+		return
+	}
+	h.usage.Record(ttsUsage(currentUserID(c), req.RoleID, req.Text))
+	services.PreparePlayback(result)
+
+	if wantsBinaryAudio(c) {
+		c.Header("X-TTS-Reqid", result.ReqID)
+		c.Header("X-TTS-Duration", result.Duration)
+		c.Header("Content-Length", strconv.Itoa(len(result.Audio)))
+		c.Data(http.StatusOK, services.AudioContentType(result.Encoding), result.Audio)
 		return
 	}
 
@@ -317,17 +817,47 @@ func (h *AudioHandler) HandleTTS(c *gin.Context) {
 		"reqid":    result.ReqID,
 		"audio":    encoded,
 		"duration": result.Duration,
+		"encoding": result.Encoding,
 		"raw":      result.Raw,
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// HandleVoiceList proxies the GET /voice/list endpoint.
+// allowRequest applies the per-caller rate limit for scope, keyed by rateLimitKey. It
+// writes the 429 response itself.
+func (h *AudioHandler) allowRequest(c *gin.Context, scope, token string) bool {
+	return allowRateLimited(c, h.limiter, scope, token)
+}
+
+// wantsBinaryAudio reports whether the client asked for the raw audio body via ?format=binary
+// or an audio/* Accept header; JSON stays the default.
+func wantsBinaryAudio(c *gin.Context) bool {
+	if strings.EqualFold(strings.TrimSpace(c.Query("format")), "binary") {
+		return true
+	}
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		if strings.HasPrefix(mediaType, "audio/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ListCustomVoices makes HandleVoiceList merge the ready voices of custom into the
+// public catalog.
+func (h *AudioHandler) ListCustomVoices(custom *CustomVoiceHandler) {
+	h.custom = custom
+}
+
+// HandleVoiceList serves the cached /voice/list catalog with the ready custom voices
+// (category "custom") appended, filtered by ?category=, ?lang= and ?q=. refresh=1 bypasses
+// the cache.
 func (h *AudioHandler) HandleVoiceList(c *gin.Context) {
 	token := h.resolveTokenFromQuery(c)
 	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "qiniu token is required"})
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
 		return
 	}
 
@@ -341,38 +871,39 @@ func (h *AudioHandler) HandleVoiceList(c *gin.Context) {
 	ctx, cancel := h.contextWithTimeout(c.Request.Context(), timeoutMS, 30*time.Second)
 	defer cancel()
 
-	voices, err := h.tts.ListVoices(ctx, token)
+	refresh := c.Query("refresh") == "1"
+	voices, err := h.voices.List(ctx, token, refresh)
 	if err != nil {
-		h.logger.Warnf("list voices failed: %v", err)
-		c.JSON(statusFromError(err), gin.H{"error": "voice list failed", "detail": err.Error()})
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("list voices failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "voice list failed"))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"voices": voices})
-}
-
-func (h *AudioHandler) resolveToken(c *gin.Context, explicit string) string {
-	if token := strings.TrimSpace(explicit); token != "" {
-		return token
+	if custom := h.custom.ReadyVoices(c); len(custom) > 0 {
+		// the catalog is shared with the caches, so merge into a copy
+		voices = append(append(make([]services.VoiceInfo, 0, len(voices)+len(custom)), voices...), custom...)
 	}
 
-	if header := parseAuthorizationToken(c.GetHeader("Authorization")); header != "" {
-		return header
+	voices = services.FilterVoices(voices, services.VoiceFilter{
+		Category: c.Query("category"),
+		Language: c.Query("lang"),
+		Query:    c.Query("q"),
+	})
+
+	body, err := json.Marshal(gin.H{"voices": voices, "total": len(voices)})
+	if err != nil {
+		writeError(c, apierr.New(apierr.CodeInternal, "encode voices failed"))
+		return
 	}
+	writeTaggedJSON(c, body)
+}
 
-	return strings.TrimSpace(h.cfg.QiniuAPIKey)
+func (h *AudioHandler) resolveToken(c *gin.Context, explicit string) string {
+	return resolveQiniuToken(c, h.tokens, h.logger, explicit)
 }
 
 func (h *AudioHandler) resolveTokenFromQuery(c *gin.Context) string {
-	if token := strings.TrimSpace(c.Query("token")); token != "" {
-		return token
-	}
-
-	if header := parseAuthorizationToken(c.GetHeader("Authorization")); header != "" {
-		return header
-	}
-
-	return strings.TrimSpace(h.cfg.QiniuAPIKey)
+	return h.resolveToken(c, c.Query("token"))
 }
 
 func (h *AudioHandler) contextWithTimeout(parent context.Context, timeoutMS int, fallback time.Duration) (context.Context, context.CancelFunc) {
@@ -385,14 +916,15 @@ func (h *AudioHandler) contextWithTimeout(parent context.Context, timeoutMS int,
 	return context.WithTimeout(parent, fallback)
 }
 
+// errClientGone is returned when a frame cannot be queued because the client connection ended.
+var errClientGone = errors.New("client connection closed")
+
+// statusFromError returns the HTTP status a failed upstream call is reported with.
 func statusFromError(err error) int {
 	if err == nil {
 		return http.StatusOK
 	}
-	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-		return http.StatusGatewayTimeout
-	}
-	return http.StatusBadGateway
+	return apierr.Status(apierr.CodeOf(err, apierr.CodeUpstream))
 }
 
 func parseAuthorizationToken(header string) string {
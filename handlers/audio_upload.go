@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/storage"
+	"go.uber.org/zap"
+)
+
+// audioUploadTypes maps the audio content types accepted for presigned uploads to the
+// extension of their key.
+var audioUploadTypes = map[string]string{
+	"audio/wav":   ".wav",
+	"audio/x-wav": ".wav",
+	"audio/wave":  ".wav",
+	"audio/mpeg":  ".mp3",
+	"audio/mp3":   ".mp3",
+	"audio/ogg":   ".ogg",
+	"audio/webm":  ".webm",
+	"audio/aac":   ".aac",
+	"audio/mp4":   ".m4a",
+	"audio/x-m4a": ".m4a",
+	"audio/flac":  ".flac",
+	"audio/amr":   ".amr",
+	"audio/pcm":   ".pcm",
+}
+
+// AudioUploadHandler hands out presigned URLs so clients upload large audio files straight
+// to object storage and pass the download URL to the ASR endpoint as audio_url.
+type AudioUploadHandler struct {
+	cfg    *config.Config
+	blobs  storage.BlobStore
+	logger *zap.SugaredLogger
+}
+
+// NewAudioUploadHandler builds the handler; a nil blobs disables uploads.
+func NewAudioUploadHandler(cfg *config.Config, blobs storage.BlobStore, logger *zap.SugaredLogger) *AudioUploadHandler {
+	return &AudioUploadHandler{cfg: cfg, blobs: blobs, logger: logger}
+}
+
+type audioUploadRequest struct {
+	ContentType string `json:"content_type"`
+	// Size is the exact byte count of the file to upload.
+	Size int64 `json:"size"`
+}
+
+// CreateUpload handles POST /api/audio/uploads behind RequireUser. The returned URL accepts one PUT of
+// exactly size bytes with the given content type until it expires; audio_url is where
+// the ASR endpoint can fetch the file afterwards.
+func (h *AudioUploadHandler) CreateUpload(c *gin.Context) {
+	if h.blobs == nil {
+		writeError(c, apierr.New(apierr.CodeStorageDisabled, "blob storage is not configured"))
+		return
+	}
+
+	var req audioUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid upload request"))
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(strings.TrimSpace(req.ContentType))
+	ext, ok := audioUploadTypes[contentType]
+	if err != nil || !ok {
+		writeError(c, apierr.New(apierr.CodeUnsupportedMedia, "content_type must be an audio type").WithDetail(req.ContentType))
+		return
+	}
+	maxBytes := int64(h.cfg.AudioUploadMaxBytes)
+	if req.Size <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "size must be positive"))
+		return
+	}
+	if req.Size > maxBytes {
+		writeError(c, apierr.New(apierr.CodePayloadTooLarge, "audio too large").With("max_bytes", maxBytes))
+		return
+	}
+
+	// every upload is signed for a user, so stored audio can be traced and erased
+	key := fmt.Sprintf("audio/%s/%s/%s%s", MustUserID(c), time.Now().UTC().Format("20060102"), newSessionID(), ext)
+	upload, err := h.blobs.PresignPut(c.Request.Context(), key, storage.UploadConstraints{
+		ContentType: contentType,
+		Size:        req.Size,
+		Expiry:      time.Duration(h.cfg.AudioUploadExpirySeconds) * time.Second,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrPresignUnsupported) {
+			writeError(c, apierr.New(apierr.CodeStorageDisabled, "blob storage does not support direct uploads; set BLOB_BACKEND to s3 or kodo"))
+			return
+		}
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("presign audio upload %s: %v", key, err)
+		writeError(c, apierr.New(apierr.CodeInternal, "presign upload failed"))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"key":        key,
+		"method":     upload.Method,
+		"upload_url": upload.URL,
+		"headers":    upload.Headers,
+		"expires_at": upload.ExpiresAt,
+		"audio_url":  upload.DownloadURL,
+		"max_bytes":  maxBytes,
+	})
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/storage"
+)
+
+// recordingBlobStore presigns every upload, remembering the key and constraints.
+type recordingBlobStore struct {
+	key         string
+	constraints storage.UploadConstraints
+}
+
+func (s *recordingBlobStore) Put(context.Context, string, io.Reader, string) (string, error) {
+	return "", nil
+}
+
+func (s *recordingBlobStore) Delete(context.Context, string) error { return nil }
+
+func (s *recordingBlobStore) PresignPut(_ context.Context, key string, constraints storage.UploadConstraints) (*storage.PresignedUpload, error) {
+	s.key, s.constraints = key, constraints
+	return &storage.PresignedUpload{Method: http.MethodPut, URL: "https://bucket.example/" + key, DownloadURL: "https://bucket.example/" + key}, nil
+}
+
+func TestCreateUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"accepted", `{"content_type":"audio/wav; codecs=1","size":2048}`, http.StatusCreated, ""},
+		{"not audio", `{"content_type":"text/html","size":2048}`, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE"},
+		{"no size", `{"content_type":"audio/wav"}`, http.StatusBadRequest, "INVALID_REQUEST"},
+		{"too large", `{"content_type":"audio/wav","size":4097}`, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			blobs := &recordingBlobStore{}
+			cfg := &config.Config{AudioUploadMaxBytes: 4096, AudioUploadExpirySeconds: 300}
+			h := NewAudioUploadHandler(cfg, blobs, zap.NewNop().Sugar())
+			router := gin.New()
+			router.POST("/api/audio/uploads", func(c *gin.Context) { c.Set(userIDContextKey, "42") }, h.CreateUpload)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/audio/uploads", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus || responseCode(t, rec) != tc.wantCode {
+				t.Fatalf("got %d %s, want %d %s: %s", rec.Code, responseCode(t, rec), tc.wantStatus, tc.wantCode, rec.Body)
+			}
+			if tc.wantStatus != http.StatusCreated {
+				if blobs.key != "" {
+					t.Errorf("presigned %q for a rejected request", blobs.key)
+				}
+				return
+			}
+			if !strings.HasPrefix(blobs.key, "audio/42/") || !strings.HasSuffix(blobs.key, ".wav") {
+				t.Errorf("key = %q, want a .wav under the user's prefix", blobs.key)
+			}
+			want := storage.UploadConstraints{ContentType: "audio/wav", Size: 2048, Expiry: 5 * time.Minute}
+			if blobs.constraints != want {
+				t.Errorf("constraints = %+v, want %+v", blobs.constraints, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/auth"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
+	"go.uber.org/zap"
+)
+
+// AuthHandler exposes registration, login and the refresh token lifecycle.
+type AuthHandler struct {
+	auth        *auth.Service
+	accountLock *ratelimit.Lockout
+	ipLock      *ratelimit.Lockout
+	logger      *zap.SugaredLogger
+}
+
+// NewAuthHandler builds an AuthHandler; a nil service makes every endpoint answer 503.
+// accountLock and ipLock count failed logins per username and per client IP; either may
+// be nil to disable that limit.
+func NewAuthHandler(svc *auth.Service, accountLock, ipLock *ratelimit.Lockout, logger *zap.SugaredLogger) *AuthHandler {
+	return &AuthHandler{auth: svc, accountLock: accountLock, ipLock: ipLock, logger: logger}
+}
+
+type credentialsPayload struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Device   string `json:"device"`
+}
+
+type refreshPayload struct {
+	RefreshToken string `json:"refresh_token"`
+	Device       string `json:"device"`
+}
+
+func requestDevice(c *gin.Context, name string) auth.Device {
+	return auth.Device{Name: name, UserAgent: c.GetHeader("User-Agent"), IP: c.ClientIP()}
+}
+
+// Register handles POST /api/auth/register and responds 201 with a session.
+func (h *AuthHandler) Register(c *gin.Context) {
+	var payload credentialsPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	session, err := h.auth.Register(c.Request.Context(), payload.Username, payload.Password, requestDevice(c, payload.Device))
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, session)
+}
+
+// Login handles POST /api/auth/login.
+func (h *AuthHandler) Login(c *gin.Context) {
+	var payload credentialsPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	ctx := c.Request.Context()
+	account := strings.ToLower(strings.TrimSpace(payload.Username))
+	ip := c.ClientIP()
+	if wait := max(h.accountLock.Locked(ctx, account), h.ipLock.Locked(ctx, ip)); wait > 0 {
+		writeLockedOut(c, wait)
+		return
+	}
+
+	session, err := h.auth.Login(ctx, payload.Username, payload.Password, requestDevice(c, payload.Device))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			if wait := max(h.accountLock.Fail(ctx, account), h.ipLock.Fail(ctx, ip)); wait > 0 {
+				ctxlog.From(c.Request.Context(), h.logger).Warnf("login locked out for %s after repeated failures (account %q)", wait, account)
+			}
+		}
+		h.writeAuthError(c, err)
+		return
+	}
+	h.accountLock.Reset(ctx, account)
+	h.ipLock.Reset(ctx, ip)
+	c.JSON(http.StatusOK, session)
+}
+
+// writeLockedOut answers a login attempt made during a lockout with 429 and Retry-After.
+func writeLockedOut(c *gin.Context, wait time.Duration) {
+	retryAfter := int((wait + time.Second - 1) / time.Second)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	writeError(c, apierr.New(apierr.CodeLoginLocked, "too many failed login attempts").With("retry_after", retryAfter))
+}
+
+// Refresh handles POST /api/auth/refresh, rotating the refresh token and issuing a new
+// access token.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var payload refreshPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.RefreshToken) == "" {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "refresh_token is required"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	session, err := h.auth.Refresh(c.Request.Context(), payload.RefreshToken, requestDevice(c, payload.Device))
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// Logout handles POST /api/auth/logout, revoking the refresh token. Access tokens already
+// issued stay valid until they expire.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var payload refreshPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.RefreshToken) == "" {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "refresh_token is required"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	if err := h.auth.Logout(c.Request.Context(), payload.RefreshToken); err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Me handles GET /api/auth/me, returning the signed-in user without secrets.
+func (h *AuthHandler) Me(c *gin.Context) {
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+	user, err := h.auth.Profile(c.Request.Context(), MustUserID(c))
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateMe handles PUT /api/auth/me with {username?, email?}.
+func (h *AuthHandler) UpdateMe(c *gin.Context) {
+	var payload auth.ProfileUpdate
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	user, err := h.auth.UpdateProfile(c.Request.Context(), MustUserID(c), payload)
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+type passwordPayload struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	Device          string `json:"device"`
+}
+
+// ChangePassword handles POST /api/auth/password. Other devices are signed out; the
+// response carries a new session for this one.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var payload passwordPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	session, err := h.auth.ChangePassword(c.Request.Context(), MustUserID(c), payload.CurrentPassword, payload.NewPassword, requestDevice(c, payload.Device))
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+type apiKeyPayload struct {
+	Label  string   `json:"label"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreateAPIKey handles POST /api/auth/apikeys with {label, scopes}. The 201 response is
+// the only time the plaintext key is returned.
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	var payload apiKeyPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	key, err := h.auth.CreateAPIKey(c.Request.Context(), MustUserID(c), payload.Label, payload.Scopes)
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, key)
+}
+
+type ticketPayload struct {
+	Scopes []string `json:"scopes"`
+}
+
+// IssueTicket handles POST /api/audio/token, returning a single-use ticket that
+// authenticates one WebSocket handshake as the caller for auth.TicketTTL, so browsers
+// never put an access token or a Qiniu credential in a URL. scopes defaults to every
+// ticket scope the caller holds.
+func (h *AuthHandler) IssueTicket(c *gin.Context) {
+	var payload ticketPayload
+	if err := c.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	scopes := make([]string, 0, len(auth.TicketScopes))
+	if len(payload.Scopes) == 0 {
+		for _, scope := range auth.TicketScopes {
+			if hasScope(c, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	for _, scope := range payload.Scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !auth.HasScope(auth.TicketScopes, scope) {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, "unknown ticket scope").With("scope", scope))
+			return
+		}
+		if !hasScope(c, scope) {
+			writeError(c, apierr.New(apierr.CodeInsufficientScope, "api key lacks the "+scope+" scope"))
+			return
+		}
+		if !auth.HasScope(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		writeError(c, apierr.New(apierr.CodeInsufficientScope, "no scope to grant a ticket for"))
+		return
+	}
+
+	ticket, expires, err := h.auth.IssueTicket(MustUserID(c), scopes)
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"scopes":     scopes,
+		"expires_at": expires,
+		"expires_in": int(auth.TicketTTL / time.Second),
+	})
+}
+
+// ListAPIKeys handles GET /api/auth/apikeys, listing live keys without their secrets.
+func (h *AuthHandler) ListAPIKeys(c *gin.Context) {
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+	keys, err := h.auth.ListAPIKeys(c.Request.Context(), MustUserID(c))
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// RevokeAPIKey handles DELETE /api/auth/apikeys/:id and responds 204.
+func (h *AuthHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid api key id"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	if err := h.auth.RevokeAPIKey(c.Request.Context(), MustUserID(c), id); err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type verifyConfirmPayload struct {
+	Token string `json:"token"`
+}
+
+// RequestVerification handles POST /api/auth/verify/request, mailing a one-time link to
+// the signed-in user's email. It responds 202 with the link's expiry.
+func (h *AuthHandler) RequestVerification(c *gin.Context) {
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+	expires, err := h.auth.RequestEmailVerification(c.Request.Context(), MustUserID(c))
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"status": "sent", "expires_at": expires})
+}
+
+// ConfirmVerification handles POST /api/auth/verify/confirm with {token}. It needs no
+// access token since the link may be opened on another device; it returns the user.
+func (h *AuthHandler) ConfirmVerification(c *gin.Context) {
+	var payload verifyConfirmPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || strings.TrimSpace(payload.Token) == "" {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "token is required"))
+		return
+	}
+	if h.auth == nil {
+		h.writeAuthError(c, auth.ErrAccountsDisabled)
+		return
+	}
+
+	user, err := h.auth.ConfirmEmailVerification(c.Request.Context(), payload.Token)
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// writeAuthError maps account errors to responses with a machine-readable code.
+func (h *AuthHandler) writeAuthError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrAccountsDisabled):
+		writeError(c, apierr.New(apierr.CodeAuthDisabled, "authentication is not configured"))
+	case errors.Is(err, auth.ErrInvalidAccount):
+		writeError(c, apierr.New(apierr.CodeInvalidAccount, err.Error()))
+	case errors.Is(err, auth.ErrUsernameTaken):
+		writeError(c, apierr.New(apierr.CodeUsernameTaken, "username already exists"))
+	case errors.Is(err, auth.ErrEmailTaken):
+		writeError(c, apierr.New(apierr.CodeEmailTaken, "email already exists"))
+	case errors.Is(err, auth.ErrUserNotFound):
+		writeError(c, apierr.New(apierr.CodeUserNotFound, "user not found"))
+	case errors.Is(err, auth.ErrWrongPassword):
+		writeError(c, apierr.New(apierr.CodeWrongPassword, "current password is incorrect"))
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		writeError(c, apierr.New(apierr.CodeInvalidCredentials, "invalid username or password"))
+	case errors.Is(err, auth.ErrRefreshExpired):
+		writeError(c, apierr.New(apierr.CodeRefreshExpired, "refresh token expired"))
+	case errors.Is(err, auth.ErrRefreshReused):
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("refresh token reuse detected from %s, token family revoked", c.ClientIP())
+		writeError(c, apierr.New(apierr.CodeRefreshReused, "refresh token already used, sign in again"))
+	case errors.Is(err, auth.ErrRefreshInvalid):
+		writeError(c, apierr.New(apierr.CodeRefreshInvalid, "refresh token invalid"))
+	case errors.Is(err, auth.ErrAPIKeyNotFound):
+		writeError(c, apierr.New(apierr.CodeAPIKeyNotFound, "api key not found"))
+	case errors.Is(err, auth.ErrVerificationDisabled):
+		writeError(c, apierr.New(apierr.CodeVerificationDisabled, "email verification is not configured"))
+	case errors.Is(err, auth.ErrEmailMissing):
+		writeError(c, apierr.New(apierr.CodeEmailMissing, "set an email before verifying it"))
+	case errors.Is(err, auth.ErrEmailAlreadyVerified):
+		writeError(c, apierr.New(apierr.CodeEmailVerified, "email already verified"))
+	case errors.Is(err, auth.ErrVerificationExpired):
+		writeError(c, apierr.New(apierr.CodeVerificationExpired, "verification token expired"))
+	case errors.Is(err, auth.ErrVerificationUsed):
+		writeError(c, apierr.New(apierr.CodeVerificationUsed, "verification token already used"))
+	case errors.Is(err, auth.ErrTicketsDisabled), errors.Is(err, auth.ErrTicketScope):
+		writeError(c, ticketError(err))
+	case errors.Is(err, auth.ErrVerificationInvalid):
+		writeError(c, apierr.New(apierr.CodeVerificationInvalid, "verification token invalid"))
+	default:
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("auth request failed: %v", err)
+		writeError(c, apierr.New(apierr.CodeInternal, "authentication failed"))
+	}
+}
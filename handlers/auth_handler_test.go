@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/auth"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
+)
+
+func TestLoginLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop().Sugar()
+	svc := auth.NewService(testJWTSecret, time.Minute, time.Hour, db.NewMemoryUserStore())
+	if _, err := svc.Register(t.Context(), "alice", "correct horse", auth.Device{}); err != nil {
+		t.Fatal(err)
+	}
+	policy := ratelimit.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: time.Hour}
+	h := NewAuthHandler(svc, ratelimit.NewLockout(nil, "account", policy, logger), nil, logger)
+	router := gin.New()
+	router.POST("/login", h.Login)
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		body := `{"username":"` + username + `","password":"` + password + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for range 2 {
+		if rec := login("alice", "wrong password"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password = %d, want 401", rec.Code)
+		}
+	}
+	if rec := login("alice", "correct horse"); rec.Code != http.StatusOK {
+		t.Fatalf("correct password below the threshold = %d: %s", rec.Code, rec.Body)
+	}
+
+	// the success reset the count, so two more failures still do not lock
+	for range 2 {
+		login("alice", "wrong password")
+	}
+	if rec := login("alice", "correct horse"); rec.Code != http.StatusOK {
+		t.Fatalf("login after a reset = %d, want 200", rec.Code)
+	}
+
+	for range 3 {
+		login("ALICE", "wrong password")
+	}
+	rec := login("alice", "correct horse")
+	if rec.Code != http.StatusTooManyRequests || responseCode(t, rec) != "LOGIN_LOCKED" {
+		t.Fatalf("correct password while locked = %d %s, want 429 LOGIN_LOCKED", rec.Code, responseCode(t, rec))
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if rec := login("bob", "whatever pw"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("another account = %d, want it unaffected by the lockout", rec.Code)
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/auth"
+)
+
+// bearerToken returns the access token sent with the request: the Authorization Bearer
+// value or, on WebSocket handshakes only, ?access_token=, because browsers cannot set
+// headers there. Elsewhere a query token is ignored so it does not end up in access logs,
+// proxies and browser history.
+func bearerToken(c *gin.Context) string {
+	header := strings.TrimSpace(c.GetHeader("Authorization"))
+	if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if header != "" {
+		// a non-Bearer Authorization header is reported as malformed rather than missing
+		return header
+	}
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		return ""
+	}
+	return strings.TrimSpace(c.Query("access_token"))
+}
+
+// apiKeyScopesKey holds the scopes of the API key or ticket that authenticated the
+// request; it is unset for access tokens, which carry every scope.
+const apiKeyScopesKey = "api_key_scopes"
+
+// ticketContextKey marks a request authenticated by a WebSocket ticket; its Qiniu calls
+// use the server key.
+const ticketContextKey = "ws_ticket"
+
+// apiKey returns the X-API-Key header sent by server-to-server callers.
+func apiKey(c *gin.Context) string {
+	return strings.TrimSpace(c.GetHeader("X-API-Key"))
+}
+
+// OptionalUser attaches the user of a valid access token or X-API-Key to the request and
+// lets every request through, so public routes can personalize their results. Invalid
+// credentials are ignored here; RequireUser reports them.
+func OptionalUser(svc *auth.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if svc != nil {
+			if key := apiKey(c); key != "" {
+				if userID, scopes, err := svc.AuthenticateAPIKey(c.Request.Context(), key); err == nil {
+					c.Set(userIDContextKey, userID)
+					c.Set(apiKeyScopesKey, scopes)
+				}
+			} else if claims, err := svc.VerifyToken(bearerToken(c)); err == nil {
+				c.Set(userIDContextKey, claims.UserID())
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireUser rejects requests without a valid access token with 401 and
+// the error envelope, where code is one of ACCESS_TOKEN_MISSING, ACCESS_TOKEN_MALFORMED,
+// ACCESS_TOKEN_EXPIRED or ACCESS_TOKEN_INVALID. When no JWT secret is configured it answers
+// 503 with code AUTH_DISABLED.
+func RequireUser(svc *auth.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if currentUserID(c) != "" {
+			c.Next()
+			return
+		}
+		if svc == nil {
+			abortError(c, apierr.New(apierr.CodeAuthDisabled, "authentication is not configured"))
+			return
+		}
+
+		if key := apiKey(c); key != "" {
+			userID, scopes, err := svc.AuthenticateAPIKey(c.Request.Context(), key)
+			if err != nil {
+				code := apierr.CodeAPIKeyInvalid
+				if !errors.Is(err, auth.ErrAPIKeyInvalid) {
+					code = apierr.CodeAuthUnavailable
+				}
+				abortError(c, apierr.Wrap(err, code, "authentication required"))
+				return
+			}
+			c.Set(userIDContextKey, userID)
+			c.Set(apiKeyScopesKey, scopes)
+			c.Next()
+			return
+		}
+
+		claims, err := svc.VerifyToken(bearerToken(c))
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			abortError(c, apierr.Wrap(err, auth.ErrorCode(err), "authentication required"))
+			return
+		}
+		c.Set(userIDContextKey, claims.UserID())
+		c.Next()
+	}
+}
+
+// RequireScope rejects requests authenticated by an API key without scope with 403 and
+// code INSUFFICIENT_SCOPE. Access tokens and anonymous requests pass; combine it with
+// RequireUser when the route also needs a user.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasScope(c, scope) {
+			abortError(c, apierr.New(apierr.CodeInsufficientScope, "api key lacks the "+scope+" scope"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// hasScope reports whether the credential of the request grants scope.
+func hasScope(c *gin.Context, scope string) bool {
+	if value, ok := c.Get(apiKeyScopesKey); ok {
+		scopes, _ := value.([]string)
+		return auth.HasScope(scopes, scope)
+	}
+	return true
+}
+
+// AcceptTicket authenticates a WebSocket handshake carrying ?ticket= (issued by
+// POST /api/audio/token) as the ticket's user, limited to its scopes, and consumes the
+// ticket. scope is the one the route needs. Requests without a ticket pass unchanged.
+func AcceptTicket(svc *auth.Service, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := strings.TrimSpace(c.Query("ticket"))
+		if raw == "" {
+			c.Next()
+			return
+		}
+		if svc == nil {
+			abortError(c, apierr.New(apierr.CodeAuthDisabled, "authentication is not configured"))
+			return
+		}
+		ticket, err := svc.RedeemTicket(c.Request.Context(), raw, scope)
+		if err != nil {
+			abortError(c, ticketError(err))
+			return
+		}
+		c.Set(userIDContextKey, ticket.UserID)
+		c.Set(apiKeyScopesKey, ticket.Scopes)
+		c.Set(ticketContextKey, true)
+		c.Next()
+	}
+}
+
+// ticketError maps ticket failures to responses with a machine-readable code.
+func ticketError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrTicketsDisabled):
+		return apierr.New(apierr.CodeAuthDisabled, "tickets are not configured")
+	case errors.Is(err, auth.ErrTicketExpired):
+		return apierr.New(apierr.CodeTicketExpired, "ticket expired")
+	case errors.Is(err, auth.ErrTicketUsed):
+		return apierr.New(apierr.CodeTicketUsed, "ticket already used")
+	case errors.Is(err, auth.ErrTicketScope):
+		return apierr.New(apierr.CodeInsufficientScope, "ticket lacks the required scope")
+	case errors.Is(err, auth.ErrTicketInvalid):
+		return apierr.New(apierr.CodeTicketInvalid, "ticket invalid")
+	default:
+		return apierr.Wrap(err, apierr.CodeAuthUnavailable, "ticket check failed")
+	}
+}
+
+// MustUserID returns the authenticated user of a request that passed RequireUser. It
+// panics when the route was registered without it, which is a wiring bug.
+func MustUserID(c *gin.Context) string {
+	userID := currentUserID(c)
+	if userID == "" {
+		panic("handlers: MustUserID called on a route without RequireUser")
+	}
+	return userID
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/wuwenbin0122/wwb.ai/auth"
+	"github.com/wuwenbin0122/wwb.ai/db"
+)
+
+const testJWTSecret = "test-secret"
+
+// responseCode returns the code of an error envelope, or "" for other bodies.
+func responseCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Code
+}
+
+// signToken signs claims for user with secret the way auth.Service does, so tests can
+// forge expired or foreign tokens.
+func signToken(t *testing.T, secret, user string, issued, expires time.Time) string {
+	t.Helper()
+	claims := jwt.RegisteredClaims{
+		Issuer:    "wwb.ai",
+		Subject:   user,
+		IssuedAt:  jwt.NewNumericDate(issued),
+		NotBefore: jwt.NewNumericDate(issued),
+		ExpiresAt: jwt.NewNumericDate(expires),
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestUserMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := auth.NewService(testJWTSecret, time.Minute, 0, nil)
+	valid, _, err := svc.IssueToken("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	expired := signToken(t, testJWTSecret, "alice", now.Add(-time.Hour), now.Add(-time.Minute))
+	foreign := signToken(t, "another-secret", "alice", now, now.Add(time.Minute))
+	noSubject := signToken(t, testJWTSecret, "", now, now.Add(time.Minute))
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.RegisteredClaims{
+		Issuer: "wwb.ai", Subject: "alice", ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	whoami := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"user": currentUserID(c)}) }
+	router.GET("/required", RequireUser(svc), whoami)
+	router.GET("/optional", OptionalUser(svc), whoami)
+	router.GET("/disabled", RequireUser(nil), whoami)
+
+	cases := []struct {
+		name          string
+		authorization string
+		query         string
+		upgrade       bool   // send the request as a WebSocket handshake
+		code          string // RequireUser's error code, "" when it lets the request in
+	}{
+		{"valid bearer", "Bearer " + valid, "", false, ""},
+		{"lowercase scheme", "bearer " + valid, "", false, ""},
+		{"query token on a websocket handshake", "", "?access_token=" + valid, true, ""},
+		{"query token on a plain request", "", "?access_token=" + valid, false, "ACCESS_TOKEN_MISSING"},
+		{"missing", "", "", false, "ACCESS_TOKEN_MISSING"},
+		{"bearer without token", "Bearer ", "", false, "ACCESS_TOKEN_MALFORMED"},
+		{"malformed", "Bearer not-a-jwt", "", false, "ACCESS_TOKEN_MALFORMED"},
+		{"non-bearer scheme", "Basic YWxpY2U6cHc=", "", false, "ACCESS_TOKEN_MALFORMED"},
+		{"expired", "Bearer " + expired, "", false, "ACCESS_TOKEN_EXPIRED"},
+		{"wrong signature", "Bearer " + foreign, "", false, "ACCESS_TOKEN_INVALID"},
+		{"unsigned", "Bearer " + none, "", false, "ACCESS_TOKEN_INVALID"},
+		{"no subject", "Bearer " + noSubject, "", false, "ACCESS_TOKEN_INVALID"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, path := range []string{"/required", "/optional"} {
+				req := httptest.NewRequest(http.MethodGet, path+tc.query, nil)
+				if tc.authorization != "" {
+					req.Header.Set("Authorization", tc.authorization)
+				}
+				if tc.upgrade {
+					req.Header.Set("Connection", "Upgrade")
+					req.Header.Set("Upgrade", "websocket")
+				}
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+
+				wantUser := ""
+				if tc.code == "" {
+					wantUser = "alice"
+				}
+				if path == "/required" && tc.code != "" {
+					if rec.Code != http.StatusUnauthorized || responseCode(t, rec) != tc.code {
+						t.Errorf("%s = %d %s, want 401 %s", path, rec.Code, responseCode(t, rec), tc.code)
+					}
+					if rec.Header().Get("WWW-Authenticate") == "" {
+						t.Errorf("%s rejected without a WWW-Authenticate header", path)
+					}
+					continue
+				}
+				// OptionalUser lets every request in, attaching only a valid user
+				var body struct {
+					User string `json:"user"`
+				}
+				_ = json.Unmarshal(rec.Body.Bytes(), &body)
+				if rec.Code != http.StatusOK || body.User != wantUser {
+					t.Errorf("%s = %d with user %q, want 200 with %q", path, rec.Code, body.User, wantUser)
+				}
+			}
+		})
+	}
+
+	t.Run("auth disabled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/disabled", nil))
+		if rec.Code != http.StatusServiceUnavailable || responseCode(t, rec) != "AUTH_DISABLED" {
+			t.Errorf("RequireUser without a service = %d %s, want 503 AUTH_DISABLED", rec.Code, responseCode(t, rec))
+		}
+	})
+
+	t.Run("user set earlier passes", func(t *testing.T) {
+		chained := gin.New()
+		chained.GET("/", func(c *gin.Context) { c.Set(userIDContextKey, "bob") }, RequireUser(svc), whoami)
+		rec := httptest.NewRecorder()
+		chained.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("RequireUser after a ticket or API key = %d, want 200", rec.Code)
+		}
+	})
+}
+
+func TestMustUserIDPanicsWithoutRequireUser(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	defer func() {
+		if recover() == nil {
+			t.Error("MustUserID without a user did not panic")
+		}
+	}()
+	MustUserID(c)
+}
+
+func TestAPIKeyScopeEnforcement(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := t.Context()
+	svc := auth.NewService(testJWTSecret, time.Minute, time.Hour, db.NewMemoryUserStore())
+	session, err := svc.Register(ctx, "alice", "correct horse", auth.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := svc.CreateAPIKey(ctx, "1", "reader", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat, err := svc.CreateAPIKey(ctx, "1", "bot", []string{auth.ScopeChat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := svc.CreateAPIKey(ctx, "1", "old", []string{auth.ScopeChat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RevokeAPIKey(ctx, "1", revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.POST("/chat", RequireUser(svc), RequireScope(auth.ScopeChat), ok)
+	router.GET("/roles", OptionalUser(svc), RequireScope(auth.ScopeRead), ok)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		value  string
+		status int
+		code   string
+	}{
+		{"chat key on chat route", http.MethodPost, "/chat", "X-API-Key", chat.Key, http.StatusNoContent, ""},
+		{"read key on chat route", http.MethodPost, "/chat", "X-API-Key", readOnly.Key, http.StatusForbidden, "INSUFFICIENT_SCOPE"},
+		{"revoked key", http.MethodPost, "/chat", "X-API-Key", revoked.Key, http.StatusUnauthorized, "API_KEY_INVALID"},
+		{"unknown key", http.MethodPost, "/chat", "X-API-Key", "wwb_unknown", http.StatusUnauthorized, "API_KEY_INVALID"},
+		{"access token carries every scope", http.MethodPost, "/chat", "Authorization", "Bearer " + session.AccessToken, http.StatusNoContent, ""},
+		{"read key on read route", http.MethodGet, "/roles", "X-API-Key", readOnly.Key, http.StatusNoContent, ""},
+		{"chat key on read route", http.MethodGet, "/roles", "X-API-Key", chat.Key, http.StatusForbidden, "INSUFFICIENT_SCOPE"},
+		{"revoked key on public route is anonymous", http.MethodGet, "/roles", "X-API-Key", revoked.Key, http.StatusNoContent, ""},
+		{"anonymous on public route", http.MethodGet, "/roles", "", "", http.StatusNoContent, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.status || responseCode(t, rec) != tc.code {
+				t.Errorf("%s %s = %d %q, want %d %q", tc.method, tc.path, rec.Code, responseCode(t, rec), tc.status, tc.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
+)
+
+// LimitConcurrentChats rejects callers already running their limit of chats with 429
+// CONCURRENT_LIMIT_REACHED. The slot is held while the rest of the chain runs, so a
+// streamed reply or WebSocket session keeps it until the stream ends or the client goes
+// away and the handler returns.
+func LimitConcurrentChats(cfg *config.Config, slots *ratelimit.Concurrency) gin.HandlerFunc {
+	tokens := tokenresolver.New(cfg.QiniuTokenMode, cfg.QiniuAPIKey)
+	return func(c *gin.Context) {
+		userID := currentUserID(c)
+		release, limit, ok := slots.Acquire(c.Request.Context(), chatSlotKey(c, tokens, userID), userID)
+		if !ok {
+			abortError(c, apierr.New(apierr.CodeConcurrentLimit, "too many chats running at once").With("limit", limit))
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// chatSlotKey identifies the caller the way the rate limits do: by user, else by the
+// client's Qiniu token when QINIU_TOKEN_MODE serves the chat with it, else by address. A
+// token the mode ignores never reaches Qiniu, so it would let a caller claim fresh slots
+// by sending made-up tokens.
+func chatSlotKey(c *gin.Context, tokens *tokenresolver.Resolver, userID string) string {
+	if userID != "" {
+		return "user:" + userID
+	}
+	if !c.GetBool(ticketContextKey) {
+		token, source := tokens.Resolve(c.Query("token"), parseAuthorizationToken(c.GetHeader("Authorization")))
+		if source == tokenresolver.SourceClient {
+			return "token:" + token
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
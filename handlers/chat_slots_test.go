@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
+)
+
+func TestChatSlotKey(t *testing.T) {
+	cases := []struct {
+		name   string
+		mode   string
+		userID string
+		ticket bool
+		target string
+		header string
+		want   string
+	}{
+		{"signed-in user", tokenresolver.ModeEither, "42", false, "/", "Bearer client-token", "user:42"},
+		{"client token in header", tokenresolver.ModeEither, "", false, "/", "Bearer client-token", "token:client-token"},
+		{"client token in query", tokenresolver.ModeClient, "", false, "/?token=client-token", "", "token:client-token"},
+		{"query wins over header", tokenresolver.ModeEither, "", false, "/?token=query-token", "Bearer header-token", "token:query-token"},
+		{"no token", tokenresolver.ModeEither, "", false, "/", "", "ip:203.0.113.7"},
+		{"token ignored in server mode", tokenresolver.ModeServer, "", false, "/", "Bearer made-up", "ip:203.0.113.7"},
+		{"ticket", tokenresolver.ModeEither, "", true, "/?token=client-token", "", "ip:203.0.113.7"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, tc.target, nil)
+			c.Request.RemoteAddr = "203.0.113.7:5000"
+			if tc.header != "" {
+				c.Request.Header.Set("Authorization", tc.header)
+			}
+			if tc.ticket {
+				c.Set(ticketContextKey, true)
+			}
+			if got := chatSlotKey(c, tokenresolver.New(tc.mode, "server-key"), tc.userID); got != tc.want {
+				t.Errorf("chatSlotKey = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestChatSlotsIgnoreUnusedTokens checks an anonymous caller cannot claim extra slots by
+// sending a different made-up token with each request while the server key is used.
+func TestChatSlotsIgnoreUnusedTokens(t *testing.T) {
+	cfg := &config.Config{QiniuTokenMode: tokenresolver.ModeServer, QiniuAPIKey: "server-key"}
+	slots := ratelimit.NewConcurrency(nil, 1, nil, zap.NewNop().Sugar())
+	entered, hold := make(chan struct{}), make(chan struct{})
+	router := gin.New()
+	router.POST("/chat", LimitConcurrentChats(cfg, slots), func(c *gin.Context) {
+		entered <- struct{}{}
+		<-hold
+		c.Status(http.StatusOK)
+	})
+
+	send := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/chat", nil)
+		req.RemoteAddr = "198.51.100.1:1000"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := make(chan int)
+	go func() { first <- send("token-a").Code }()
+	<-entered
+	if rec := send("token-b"); rec.Code != http.StatusTooManyRequests || responseCode(t, rec) != "CONCURRENT_LIMIT_REACHED" {
+		t.Errorf("second chat with another token = %d %s, want 429 CONCURRENT_LIMIT_REACHED", rec.Code, rec.Body)
+	}
+	close(hold)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first chat = %d, want 200", code)
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/memory"
+)
+
+// chatRequestWait bounds how long a chat WebSocket may stay open before its request
+// arrives.
+const chatRequestWait = 30 * time.Second
+
+type chatControlMessage struct {
+	Type string `json:"type"`
+}
+
+// HandleChatWebsocket serves GET /api/nlp/chat/ws for clients that cannot read SSE. The
+// client sends the HandleChat JSON payload as its first text message; the server streams
+// {"type":"delta","content":...} frames as the model generates and ends with
+// {"type":"done","message":...,"usage":...,"finish_reason":...}, or an
+// {"type":"error",...} frame carrying the usual error body. A {"type":"cancel"} message
+// aborts the upstream call, which ends with finish_reason "cancelled". Each connection
+// serves one reply; the Qiniu token may also come in the token query parameter.
+func (h *NLPHandler) HandleChatWebsocket(c *gin.Context) {
+	conn, err := asrUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		h.logger.Warnf("chat websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx := c.Request.Context()
+	requestID := ctxlog.RequestID(ctx)
+	logger := ctxlog.From(ctx, h.logger)
+	fail := func(err *apierr.Error) {
+		body := gin.H(err.Body(requestID))
+		body["type"] = "error"
+		_ = conn.WriteJSON(body)
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(chatRequestWait))
+	msgType, raw, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	var payload nlpRequestPayload
+	if msgType != websocket.TextMessage {
+		fail(apierr.New(apierr.CodeInvalidRequest, "the chat request must be a JSON text message"))
+		return
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		fail(apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	payload.AcceptLanguage = c.GetHeader("Accept-Language")
+
+	userID := currentUserID(c)
+	plan := h.planChat(ctx, userID, payload, false)
+	if len(plan.Errors) > 0 {
+		issue := plan.Errors[0]
+		fail(apierr.New(issue.Code, issue.Message).WithDetail(issue.Detail).With("field", issue.Field))
+		return
+	}
+	explicit := payload.Token
+	if strings.TrimSpace(explicit) == "" {
+		explicit = c.Query("token")
+	}
+	token := h.resolveToken(c, explicit)
+	if token == "" && h.nlp.RequiresToken(payload.Provider) {
+		fail(apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// the reader only cancels; every write happens on this goroutine
+	var cancelled atomic.Bool
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg chatControlMessage
+			if json.Unmarshal(data, &msg) == nil && strings.EqualFold(strings.TrimSpace(msg.Type), "cancel") {
+				cancelled.Store(true)
+				return
+			}
+		}
+	}()
+
+	result, err := h.nlp.StreamReply(streamCtx, token, plan.Request, func(content string) error {
+		return conn.WriteJSON(gin.H{"type": "delta", "content": content})
+	})
+	switch {
+	case err == nil:
+	case streamCtx.Err() != nil && ctx.Err() == nil:
+		// cancelled by the client or lost with it; Qiniu still bills the tokens generated so far
+		h.usage.Record(chatUsage(userID, payload.RoleID, nil))
+		if cancelled.Load() {
+			_ = conn.WriteJSON(gin.H{"type": "done", "usage": nil, "finish_reason": "cancelled"})
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		}
+		return
+	default:
+		logger.Warnf("nlp chat stream failed: %v", err)
+		fail(apierr.Wrap(err, apierr.CodeUpstream, "chat completion failed"))
+		return
+	}
+
+	h.stats.RecordUsage(ctx, payload.RoleID)
+	h.usage.Record(chatUsage(userID, payload.RoleID, result.Usage))
+	h.memories.Remember(memory.Exchange{UserID: userID, RoleID: payload.RoleID, Token: token, UserMessage: plan.Request.UserMessage, Reply: result.Reply.Content})
+	done := gin.H{
+		"type":                "done",
+		"message":             result.Reply,
+		"usage":               result.Usage,
+		"finish_reason":       result.FinishReason,
+		"sampling":            result.Sampling,
+		"structured":          result.Structured != nil,
+		"provider":            result.Provider,
+		"sentiment":           plan.Request.Sentiment,
+		"auto_enabled_skills": autoEnabledSkills(plan.Prompt),
+		"prompt_guard":        promptGuard(plan.Prompt),
+		"language":            plan.Request.Language,
+		"language_source":     plan.LanguageSource,
+	}
+	if result.Structured != nil {
+		done["structured_reply"] = result.Structured
+	}
+	if payload.IncludeSuggestions {
+		done["suggestions"] = h.suggest(ctx, token, plan.Request, result.Reply.Content, result.Structured)
+	}
+	_ = conn.WriteJSON(done)
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
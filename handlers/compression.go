@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// compressibleTypes are the media types Compress encodes; audio, images and event streams
+// are left alone.
+var compressibleTypes = map[string]bool{
+	"application/json":         true,
+	"application/problem+json": true,
+	"application/javascript":   true,
+	"application/xml":          true,
+	"text/plain":               true,
+	"text/html":                true,
+	"text/css":                 true,
+	"text/csv":                 true,
+	"text/markdown":            true,
+	"text/xml":                 true,
+	"image/svg+xml":            true,
+}
+
+var (
+	gzipWriters  = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	flateWriters = sync.Pool{New: func() any {
+		writer, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return writer
+	}}
+)
+
+// Compress encodes responses of at least minBytes with gzip or deflate, whichever the
+// client's Accept-Encoding prefers, when their Content-Type is in compressibleTypes. The
+// body is held until minBytes are written, so smaller responses go out unchanged. A
+// handler that flushes is streaming and is never encoded, nor are WebSocket upgrades. A
+// non-positive minBytes disables compression.
+func Compress(minBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if minBytes <= 0 || websocket.IsWebSocketUpgrade(c.Request) {
+			c.Next()
+			return
+		}
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" {
+			c.Next()
+			return
+		}
+
+		writer := &compressWriter{ResponseWriter: c.Writer, encoding: encoding, minBytes: minBytes}
+		c.Writer = writer
+		// deferred so a panicking handler's partial body still reaches Recovery's writer
+		defer func() {
+			writer.finish()
+			c.Writer = writer.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, preferring gzip
+// on equal weight, or returns "" when the client takes neither.
+func negotiateEncoding(header string) string {
+	best, bestWeight := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			name = "gzip"
+		}
+		if name != "gzip" && name != "deflate" {
+			continue
+		}
+		weight := 1.0
+		if raw, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if weight > bestWeight || (weight == bestWeight && weight > 0 && name == "gzip") {
+			best, bestWeight = name, weight
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the start of a response until it knows whether to encode it: as
+// soon as minBytes are held, or when the handler flushes or finishes.
+type compressWriter struct {
+	gin.ResponseWriter
+
+	encoding string
+	minBytes int
+
+	buf     []byte
+	decided bool
+	gzip    *gzip.Writer
+	flate   *flate.Writer
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		if !w.compressible() {
+			if err := w.start(false); err != nil {
+				return 0, err
+			}
+		} else {
+			w.buf = append(w.buf, data...)
+			if len(w.buf) >= w.minBytes {
+				if err := w.start(true); err != nil {
+					return 0, err
+				}
+			}
+			return len(data), nil
+		}
+	}
+	return w.encoder().Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow commits a response without a body, which is never encoded.
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.decided {
+		_ = w.start(false)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		_ = w.start(false)
+	}
+	switch {
+	case w.gzip != nil:
+		_ = w.gzip.Flush()
+	case w.flate != nil:
+		_ = w.flate.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Written() bool {
+	return len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+// compressible reports whether the response as committed so far may be encoded.
+func (w *compressWriter) compressible() bool {
+	status := w.ResponseWriter.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	header := w.ResponseWriter.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && compressibleTypes[mediaType]
+}
+
+// start settles whether to encode and writes out the held bytes.
+func (w *compressWriter) start(compress bool) error {
+	w.decided = true
+	held := w.buf
+	w.buf = nil
+
+	if compress {
+		header := w.ResponseWriter.Header()
+		header.Set("Content-Encoding", w.encoding)
+		header.Add("Vary", "Accept-Encoding")
+		header.Del("Content-Length")
+		if w.encoding == "gzip" {
+			w.gzip = gzipWriters.Get().(*gzip.Writer)
+			w.gzip.Reset(w.ResponseWriter)
+		} else {
+			w.flate = flateWriters.Get().(*flate.Writer)
+			w.flate.Reset(w.ResponseWriter)
+		}
+	} else if len(held) > 0 {
+		// a response that could have been encoded, had it been larger, still varies
+		w.ResponseWriter.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	if len(held) == 0 {
+		return nil
+	}
+	_, err := w.encoder().Write(held)
+	return err
+}
+
+func (w *compressWriter) encoder() io.Writer {
+	switch {
+	case w.gzip != nil:
+		return w.gzip
+	case w.flate != nil:
+		return w.flate
+	}
+	return w.ResponseWriter
+}
+
+// finish writes whatever the handler left held and closes the encoder.
+func (w *compressWriter) finish() {
+	if !w.decided {
+		_ = w.start(false)
+	}
+	if w.gzip != nil {
+		_ = w.gzip.Close()
+		gzipWriters.Put(w.gzip)
+		w.gzip = nil
+	}
+	if w.flate != nil {
+		_ = w.flate.Close()
+		flateWriters.Put(w.flate)
+		w.flate = nil
+	}
+}
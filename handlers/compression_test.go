@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"br", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0.8, deflate;q=0.9", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"gzip;q=0", ""},
+		{"gzip;q=oops, deflate", "deflate"},
+		{"*", "gzip"},
+		{"br, *;q=0.1", "gzip"},
+	}
+	for _, tc := range cases {
+		if got := negotiateEncoding(tc.header); got != tc.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const minBytes = 64
+	large := `{"text":"` + strings.Repeat("compressible ", 20) + `"}`
+	small := `{"ok":true}`
+
+	cases := []struct {
+		name        string
+		minBytes    int
+		accept      string
+		upgrade     bool
+		contentType string
+		status      int
+		body        string
+		flush       bool
+		want        string
+		wantVary    bool
+	}{
+		{name: "gzip above the threshold", accept: "gzip", body: large, want: "gzip", wantVary: true},
+		{name: "deflate above the threshold", accept: "deflate", body: large, want: "deflate", wantVary: true},
+		{name: "preferred encoding wins", accept: "gzip;q=0.5, deflate", body: large, want: "deflate", wantVary: true},
+		{name: "error bodies are encoded too", accept: "gzip", status: http.StatusBadRequest, body: large, want: "gzip", wantVary: true},
+		{name: "below the threshold", accept: "gzip", body: small, wantVary: true},
+		{name: "exactly the threshold", accept: "gzip", body: large[:minBytes], want: "gzip", wantVary: true},
+		{name: "no accept-encoding", body: large},
+		{name: "unsupported encoding", accept: "br", body: large},
+		{name: "disabled", minBytes: -1, accept: "gzip", body: large},
+		{name: "audio is left alone", accept: "gzip", contentType: "audio/mpeg", body: large},
+		{name: "streaming handler", accept: "gzip", contentType: "text/event-stream", body: large, flush: true},
+		{name: "flushed json", accept: "gzip", body: large, flush: true, wantVary: true},
+		{name: "websocket upgrade", accept: "gzip", upgrade: true, body: large},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			threshold := tc.minBytes
+			if threshold == 0 {
+				threshold = minBytes
+			}
+			contentType := tc.contentType
+			if contentType == "" {
+				contentType = "application/json; charset=utf-8"
+			}
+			status := tc.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			router := gin.New()
+			router.Use(Compress(threshold))
+			router.GET("/", func(c *gin.Context) {
+				c.Header("Content-Type", contentType)
+				c.Status(status)
+				if tc.flush {
+					// a streaming handler flushes before the threshold is reached
+					_, _ = c.Writer.WriteString(tc.body[:10])
+					c.Writer.Flush()
+					_, _ = c.Writer.WriteString(tc.body[10:])
+					return
+				}
+				_, _ = c.Writer.WriteString(tc.body)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept-Encoding", tc.accept)
+			}
+			if tc.upgrade {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != status {
+				t.Fatalf("status = %d, want %d", rec.Code, status)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tc.want {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tc.want)
+			}
+			if got := strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding"); got != tc.wantVary {
+				t.Errorf("Vary = %q, want Accept-Encoding named: %t", rec.Header().Get("Vary"), tc.wantVary)
+			}
+			if got := decodeBody(t, tc.want, rec.Body.Bytes()); got != tc.body {
+				t.Errorf("body = %q, want %q", got, tc.body)
+			}
+		})
+	}
+}
+
+// TestCompressHeaderOnly checks a response committed without a body is not encoded.
+func TestCompressHeaderOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Compress(1))
+	router.GET("/", func(c *gin.Context) {
+		c.Header("Content-Type", "application/json")
+		c.Status(http.StatusNotModified)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("got %d with %d bytes, want an empty 304", rec.Code, rec.Body.Len())
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q on an empty 304, want none", got)
+	}
+}
+
+// decodeBody undoes encoding on body.
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var reader io.Reader
+	switch encoding {
+	case "":
+		return string(body)
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("gzip body: %v", err)
+		}
+		reader = gz
+	case "deflate":
+		reader = flate.NewReader(bytes.NewReader(body))
+	}
+	plain, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("%s body: %v", encoding, err)
+	}
+	return string(plain)
+}
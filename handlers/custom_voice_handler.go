@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/services"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
+	"go.uber.org/zap"
+)
+
+// customVoiceCategory is the category custom voices are listed under in /api/audio/voices.
+const customVoiceCategory = "custom"
+
+// CustomVoiceHandler registers voices cloned by Qiniu from reference audio. Cloning runs
+// asynchronously upstream, so a new voice is pending until GetCustomVoice sees it ready.
+type CustomVoiceHandler struct {
+	voices db.CustomVoiceStore
+	clones *services.VoiceCloneClient
+	roles  db.RoleRepository
+	tts    *services.TTSService
+	tokens *tokenresolver.Resolver
+	logger *zap.SugaredLogger
+}
+
+// NewCustomVoiceHandler builds the handler. Custom voices need the Qiniu TTS provider.
+func NewCustomVoiceHandler(cfg *config.Config, voices db.CustomVoiceStore, clones *services.VoiceCloneClient, roles db.RoleRepository, tts *services.TTSService, logger *zap.SugaredLogger) *CustomVoiceHandler {
+	return &CustomVoiceHandler{voices: voices, clones: clones, roles: roles, tts: tts, tokens: tokenresolver.New(cfg.QiniuTokenMode, cfg.QiniuAPIKey), logger: logger}
+}
+
+type customVoiceRequest struct {
+	Token    string `json:"token"`
+	Name     string `json:"name"`
+	AudioURL string `json:"audio_url"`
+	RoleID   *int64 `json:"role_id"`
+}
+
+// CreateCustomVoice handles POST /api/audio/voices/custom: it asks Qiniu to clone a voice
+// from the reference audio at audio_url and records it, linked to role_id when given.
+// The voice is returned with status pending (202) unless the provider finished at once.
+func (h *CustomVoiceHandler) CreateCustomVoice(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	var req customVoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid custom voice request"))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.AudioURL = strings.TrimSpace(req.AudioURL)
+	if req.Name == "" {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "name is required"))
+		return
+	}
+	if parsed, err := url.Parse(req.AudioURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "audio_url must be an http(s) URL the provider can fetch"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.RoleID != nil {
+		if _, err := h.roles.GetByID(ctx, *req.RoleID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				writeError(c, apierr.New(apierr.CodeRoleNotFound, "role not found"))
+				return
+			}
+			writeError(c, apierr.Wrap(err, apierr.CodeInternal, "load role failed"))
+			return
+		}
+	}
+
+	token := resolveQiniuToken(c, h.tokens, h.logger, req.Token)
+	if token == "" {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+	clone, err := h.clones.Create(ctx, token, req.Name, req.AudioURL)
+	if err != nil {
+		ctxlog.From(ctx, h.logger).Warnf("clone voice %q failed: %v", req.Name, err)
+		writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "voice clone failed"))
+		return
+	}
+
+	voice, err := h.voices.Create(ctx, db.CustomVoice{
+		VoiceType: clone.VoiceType,
+		Name:      req.Name,
+		AudioURL:  req.AudioURL,
+		RoleID:    req.RoleID,
+		Status:    clone.Status,
+		Message:   clone.Message,
+		CreatedBy: adminActor(c),
+	})
+	if err != nil {
+		// the voice exists upstream now; log it so it can be recorded or removed by hand
+		ctxlog.From(ctx, h.logger).Errorw("record custom voice failed", "voice_type", clone.VoiceType, "error", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "record custom voice failed").With("voice_type", clone.VoiceType))
+		return
+	}
+	ctxlog.From(ctx, h.logger).Infow("custom voice requested", "voice_type", voice.VoiceType, "status", voice.Status, "actor", adminActor(c))
+
+	status := http.StatusAccepted
+	if voice.Status != services.VoiceClonePending {
+		status = http.StatusCreated
+	}
+	c.JSON(status, voice)
+}
+
+// ListCustomVoices handles GET /api/audio/voices/custom, optionally filtered by ?status=.
+func (h *CustomVoiceHandler) ListCustomVoices(c *gin.Context) {
+	voices, err := h.voices.List(c.Request.Context(), strings.TrimSpace(c.Query("status")))
+	if err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "list custom voices failed"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"voices": voices, "total": len(voices)})
+}
+
+// GetCustomVoice handles GET /api/audio/voices/custom/:voice_type. A pending voice is
+// polled upstream first and its new status recorded.
+func (h *CustomVoiceHandler) GetCustomVoice(c *gin.Context) {
+	ctx := c.Request.Context()
+	voice, err := h.voices.Get(ctx, c.Param("voice_type"))
+	if err != nil {
+		h.writeStoreError(c, err)
+		return
+	}
+	if voice.Status != services.VoiceClonePending {
+		c.JSON(http.StatusOK, voice)
+		return
+	}
+
+	token := resolveQiniuToken(c, h.tokens, h.logger, c.Query("token"))
+	if token == "" {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+	clone, err := h.clones.Status(ctx, token, voice.VoiceType)
+	if err != nil {
+		ctxlog.From(ctx, h.logger).Warnf("poll custom voice %s failed: %v", voice.VoiceType, err)
+		writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "voice clone status failed"))
+		return
+	}
+	if clone.Status != voice.Status || clone.Message != voice.Message {
+		if voice, err = h.voices.SetStatus(ctx, voice.VoiceType, clone.Status, clone.Message); err != nil {
+			h.writeStoreError(c, err)
+			return
+		}
+		ctxlog.From(ctx, h.logger).Infow("custom voice status changed", "voice_type", voice.VoiceType, "status", voice.Status)
+	}
+	c.JSON(http.StatusOK, voice)
+}
+
+// DeleteCustomVoice handles DELETE /api/audio/voices/custom/:voice_type, removing the
+// voice upstream and then its record.
+func (h *CustomVoiceHandler) DeleteCustomVoice(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	ctx := c.Request.Context()
+	voice, err := h.voices.Get(ctx, c.Param("voice_type"))
+	if err != nil {
+		h.writeStoreError(c, err)
+		return
+	}
+
+	token := resolveQiniuToken(c, h.tokens, h.logger, c.Query("token"))
+	if token == "" {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+	if err := h.clones.Delete(ctx, token, voice.VoiceType); err != nil {
+		ctxlog.From(ctx, h.logger).Warnf("delete custom voice %s upstream failed: %v", voice.VoiceType, err)
+		writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "voice clone delete failed"))
+		return
+	}
+	if err := h.voices.Delete(ctx, voice.VoiceType); err != nil {
+		h.writeStoreError(c, err)
+		return
+	}
+	ctxlog.From(ctx, h.logger).Infow("custom voice deleted", "voice_type", voice.VoiceType, "actor", adminActor(c))
+	c.Status(http.StatusNoContent)
+}
+
+// ReadyVoices returns the custom voices ready for synthesis as catalog entries, for
+// merging into /api/audio/voices; nil when custom voices are unavailable.
+func (h *CustomVoiceHandler) ReadyVoices(c *gin.Context) []services.VoiceInfo {
+	if h == nil || h.tts.Provider() != services.TTSProviderQiniu {
+		return nil
+	}
+	voices, err := h.voices.List(c.Request.Context(), services.VoiceCloneReady)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("list custom voices, serving the public list only: %v", err)
+		return nil
+	}
+	infos := make([]services.VoiceInfo, 0, len(voices))
+	for _, voice := range voices {
+		infos = append(infos, services.VoiceInfo{
+			VoiceName: voice.Name,
+			VoiceType: voice.VoiceType,
+			URL:       voice.AudioURL,
+			Category:  customVoiceCategory,
+			UpdateMS:  voice.UpdatedAt.UnixMilli(),
+		})
+	}
+	return infos
+}
+
+// enabled writes an error and returns false when the TTS provider cannot use custom voices.
+func (h *CustomVoiceHandler) enabled(c *gin.Context) bool {
+	if h.tts.Provider() != services.TTSProviderQiniu {
+		writeError(c, apierr.New(apierr.CodeFeatureDisabled, "custom voices need TTS_PROVIDER=qiniu"))
+		return false
+	}
+	return true
+}
+
+func (h *CustomVoiceHandler) writeStoreError(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrCustomVoiceNotFound) {
+		writeError(c, apierr.New(apierr.CodeVoiceNotFound, "custom voice not found"))
+		return
+	}
+	writeError(c, apierr.Wrap(err, apierr.CodeInternal, "custom voice store failed"))
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+// fakeCloneServer serves Qiniu's /voice/list and voice clone API. A cloned voice reports
+// each of its polls in turn, then the last one for good.
+type fakeCloneServer struct {
+	mu       sync.Mutex
+	polls    map[string][]string
+	created  []map[string]string
+	deleted  []string
+	requests []string
+	auth     []string
+}
+
+func (f *fakeCloneServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	w.Header().Set("Content-Type", "application/json")
+
+	voiceType := strings.TrimPrefix(r.URL.Path, "/voice/clone/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/voice/list":
+		_ = json.NewEncoder(w).Encode([]services.VoiceInfo{
+			{VoiceName: "Gentle", VoiceType: "qiniu_zh_female_gentle", Category: "public"},
+			{VoiceName: "Calm", VoiceType: "qiniu_en_male_calm", Category: "public"},
+		})
+	case r.Method == http.MethodPost && r.URL.Path == "/voice/clone":
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.created = append(f.created, body)
+		_, _ = w.Write([]byte(`{"voice_type":"clone_1","status":"processing"}`))
+	case voiceType == r.URL.Path || f.polls[voiceType] == nil:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"message":"voice not found"}}`))
+	case r.Method == http.MethodGet:
+		polls := f.polls[voiceType]
+		if len(polls) > 1 {
+			f.polls[voiceType] = polls[1:]
+		}
+		_, _ = w.Write([]byte(`{"status":"` + polls[0] + `"}`))
+	case r.Method == http.MethodDelete:
+		delete(f.polls, voiceType)
+		f.deleted = append(f.deleted, voiceType)
+		_, _ = w.Write([]byte(`{}`))
+	}
+}
+
+func (f *fakeCloneServer) calls(request string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, made := range f.requests {
+		if made == request {
+			n++
+		}
+	}
+	return n
+}
+
+// TestCustomVoiceLifecycle clones a voice against a fake clone API, polls it until ready,
+// checks it is merged into /api/audio/voices only then, and deletes it.
+func TestCustomVoiceLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fake := &fakeCloneServer{polls: map[string][]string{"clone_1": {"processing", "success"}}}
+	upstream := httptest.NewServer(fake)
+	defer upstream.Close()
+
+	logger := zap.NewNop().Sugar()
+	cfg := &config.Config{QiniuAPIBaseURL: upstream.URL, QiniuAPIKey: "server-key", QiniuTokenMode: "server", TTSProvider: services.TTSProviderQiniu}
+	roles := db.NewMemoryRoleRepository(models.Role{ID: 1, Name: "Socrates"})
+	tts := services.NewTTSService(cfg, logger)
+	store := db.NewMemoryCustomVoiceStore()
+	custom := NewCustomVoiceHandler(cfg, store, services.NewVoiceCloneClient(cfg), roles, tts, logger)
+	audio := NewAudioHandler(cfg, roles, nil, tts, nil, nil, services.NewVoiceCatalog(tts, nil, logger), nil, nil, logger)
+	audio.ListCustomVoices(custom)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(adminActorKey, "ops") })
+	router.GET("/api/audio/voices", audio.HandleVoiceList)
+	router.POST("/api/audio/voices/custom", custom.CreateCustomVoice)
+	router.GET("/api/audio/voices/custom", custom.ListCustomVoices)
+	router.GET("/api/audio/voices/custom/:voice_type", custom.GetCustomVoice)
+	router.DELETE("/api/audio/voices/custom/:voice_type", custom.DeleteCustomVoice)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	voiceStatus := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		var voice db.CustomVoice
+		if err := json.Unmarshal(rec.Body.Bytes(), &voice); err != nil {
+			t.Fatalf("decode voice: %v: %s", err, rec.Body)
+		}
+		return voice.Status
+	}
+	listed := func(query string) []string {
+		t.Helper()
+		rec := serve(http.MethodGet, "/api/audio/voices"+query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/audio/voices%s = %d: %s", query, rec.Code, rec.Body)
+		}
+		var body struct {
+			Voices []services.VoiceInfo `json:"voices"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode voices: %v", err)
+		}
+		out := make([]string, 0, len(body.Voices))
+		for _, voice := range body.Voices {
+			out = append(out, voice.Category+"/"+voice.VoiceType)
+		}
+		return out
+	}
+	public := "public/qiniu_en_male_calm,public/qiniu_zh_female_gentle"
+
+	// an unknown role or a bad reference URL is refused before anything is cloned
+	if rec := serve(http.MethodPost, "/api/audio/voices/custom", `{"name":"Mine","audio_url":"https://example.com/ref.mp3","role_id":9}`); rec.Code != http.StatusNotFound || responseCode(t, rec) != "ROLE_NOT_FOUND" {
+		t.Errorf("create for an unknown role = %d %s, want 404 ROLE_NOT_FOUND", rec.Code, responseCode(t, rec))
+	}
+	if rec := serve(http.MethodPost, "/api/audio/voices/custom", `{"name":"Mine","audio_url":"file:///etc/passwd"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("create from a file URL = %d, want 400", rec.Code)
+	}
+	if n := fake.calls("POST /voice/clone"); n != 0 {
+		t.Fatalf("%d clone calls for refused requests", n)
+	}
+
+	rec := serve(http.MethodPost, "/api/audio/voices/custom", `{"name":"Socrates' voice","audio_url":"https://example.com/ref.mp3","role_id":1}`)
+	if rec.Code != http.StatusAccepted || voiceStatus(rec) != services.VoiceClonePending {
+		t.Fatalf("create = %d %s, want 202 pending", rec.Code, rec.Body)
+	}
+	if got := fake.created[0]; got["voice_name"] != "Socrates' voice" || got["audio_url"] != "https://example.com/ref.mp3" {
+		t.Errorf("clone request = %v, want the name and reference audio", got)
+	}
+	if got := strings.Join(listed(""), ","); got != public {
+		t.Errorf("voices while pending = %s, want only the public ones", got)
+	}
+
+	// each poll of a pending voice asks upstream; a ready voice is served from the store
+	for i, want := range []string{services.VoiceClonePending, services.VoiceCloneReady, services.VoiceCloneReady} {
+		rec := serve(http.MethodGet, "/api/audio/voices/custom/clone_1", "")
+		if rec.Code != http.StatusOK || voiceStatus(rec) != want {
+			t.Fatalf("poll %d = %d %s, want %s", i+1, rec.Code, rec.Body, want)
+		}
+	}
+	if n := fake.calls("GET /voice/clone/clone_1"); n != 2 {
+		t.Errorf("%d upstream polls, want 2: none once the voice is ready", n)
+	}
+
+	if got := strings.Join(listed(""), ","); got != public+",custom/clone_1" {
+		t.Errorf("voices once ready = %s, want the custom voice after the public ones", got)
+	}
+	if got := strings.Join(listed("?category=custom"), ","); got != "custom/clone_1" {
+		t.Errorf("custom voices = %s, want clone_1", got)
+	}
+
+	if rec := serve(http.MethodDelete, "/api/audio/voices/custom/clone_1", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d %s, want 204", rec.Code, rec.Body)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "clone_1" {
+		t.Errorf("upstream deletes = %v, want clone_1", fake.deleted)
+	}
+	if rec := serve(http.MethodGet, "/api/audio/voices/custom/clone_1", ""); rec.Code != http.StatusNotFound || responseCode(t, rec) != "VOICE_NOT_FOUND" {
+		t.Errorf("get after delete = %d %s, want 404 VOICE_NOT_FOUND", rec.Code, responseCode(t, rec))
+	}
+	if got := strings.Join(listed(""), ","); got != public {
+		t.Errorf("voices after delete = %s, want only the public ones", got)
+	}
+
+	for i, auth := range fake.auth {
+		if auth != "Bearer server-key" {
+			t.Errorf("upstream call %d (%s) sent %q, want the server key", i, fake.requests[i], auth)
+		}
+	}
+}
+
+// TestCustomVoiceCloneFailure records a clone the provider rejects and keeps it out of the
+// catalog.
+func TestCustomVoiceCloneFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fake := &fakeCloneServer{polls: map[string][]string{"clone_1": {"failed"}}}
+	upstream := httptest.NewServer(fake)
+	defer upstream.Close()
+
+	logger := zap.NewNop().Sugar()
+	cfg := &config.Config{QiniuAPIBaseURL: upstream.URL, QiniuAPIKey: "server-key", QiniuTokenMode: "server", TTSProvider: services.TTSProviderQiniu}
+	tts := services.NewTTSService(cfg, logger)
+	store := db.NewMemoryCustomVoiceStore()
+	h := NewCustomVoiceHandler(cfg, store, services.NewVoiceCloneClient(cfg), db.NewMemoryRoleRepository(), tts, logger)
+
+	router := gin.New()
+	router.POST("/api/audio/voices/custom", h.CreateCustomVoice)
+	router.GET("/api/audio/voices/custom/:voice_type", h.GetCustomVoice)
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/api/audio/voices/custom", strings.NewReader(`{"name":"Mine","audio_url":"https://example.com/ref.mp3"}`)),
+		httptest.NewRequest(http.MethodGet, "/api/audio/voices/custom/clone_1", nil),
+	} {
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code >= 300 {
+			t.Fatalf("%s %s = %d: %s", req.Method, req.URL.Path, rec.Code, rec.Body)
+		}
+	}
+
+	voice, err := store.Get(t.Context(), "clone_1")
+	if err != nil || voice.Status != services.VoiceCloneFailed {
+		t.Fatalf("stored voice = %+v (%v), want failed", voice, err)
+	}
+	ready, _ := store.List(t.Context(), services.VoiceCloneReady)
+	if len(ready) != 0 {
+		t.Errorf("ready voices = %+v, want none", ready)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/payloadlog"
+	"go.uber.org/zap"
+)
+
+const maxDebugEntries = 500
+
+// DebugPayloads records the upstream payloads of requests sending X-Debug-Payload: 1
+// together with a valid X-Admin-Token. The header is ignored for everyone else, so users
+// cannot make the server log their conversations.
+func DebugPayloads(cfg *config.Config) gin.HandlerFunc {
+	expected := []byte(strings.TrimSpace(cfg.AdminToken))
+
+	return func(c *gin.Context) {
+		if len(expected) > 0 && strings.TrimSpace(c.GetHeader("X-Debug-Payload")) == "1" {
+			provided := []byte(strings.TrimSpace(c.GetHeader("X-Admin-Token")))
+			if subtle.ConstantTimeCompare(provided, expected) == 1 {
+				c.Request = c.Request.WithContext(payloadlog.Force(c.Request.Context()))
+			}
+		}
+		c.Next()
+	}
+}
+
+// DebugHandler exposes the recorded upstream payloads to operators.
+type DebugHandler struct {
+	payloads *payloadlog.Recorder
+	logger   *zap.SugaredLogger
+}
+
+// NewDebugHandler builds a new DebugHandler.
+func NewDebugHandler(payloads *payloadlog.Recorder, logger *zap.SugaredLogger) *DebugHandler {
+	return &DebugHandler{payloads: payloads, logger: logger}
+}
+
+// ListRequests handles GET /api/admin/debug/requests?limit= and responds with the most
+// recent redacted upstream payloads, newest first.
+func (h *DebugHandler) ListRequests(c *gin.Context) {
+	limit := 50
+	if raw := strings.TrimSpace(c.Query("limit")); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	if limit > maxDebugEntries {
+		limit = maxDebugEntries
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"always":  h.payloads.Always(),
+		"entries": h.payloads.Entries(limit),
+	})
+}
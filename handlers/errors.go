@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// writeError responds with err as the API error envelope (see apierr.Error.Body) tagged
+// with the request ID. Errors that are not an *apierr.Error are reported as internal.
+func writeError(c *gin.Context, err error) {
+	apiErr := apierr.From(dependencyError(err))
+	c.JSON(apiErr.Status(), apiErr.Body(c.GetString(requestIDContextKey)))
+}
+
+// abortError stops the handler chain and responds like writeError; middleware use it.
+func abortError(c *gin.Context, err error) {
+	apiErr := apierr.From(dependencyError(err))
+	c.AbortWithStatusJSON(apiErr.Status(), apiErr.Body(c.GetString(requestIDContextKey)))
+}
+
+// dependencyError reports an internal error caused by Mongo or Redis being down as
+// DEPENDENCY_UNAVAILABLE (503), so clients know to retry later.
+func dependencyError(err error) error {
+	if apierr.CodeOf(err, apierr.CodeInternal) != apierr.CodeInternal {
+		return err
+	}
+	if errors.Is(err, db.ErrUnavailable) || errors.Is(err, kv.ErrUnavailable) {
+		return apierr.Wrap(err, apierr.CodeDependencyDown, "a backing service is temporarily unavailable")
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/auth"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+func TestWriteAuthError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewAuthHandler(nil, nil, nil, zap.NewNop().Sugar())
+	cases := []struct {
+		err    error
+		status int
+		code   apierr.Code
+	}{
+		{auth.ErrAccountsDisabled, http.StatusServiceUnavailable, apierr.CodeAuthDisabled},
+		{fmt.Errorf("%w: bad username", auth.ErrInvalidAccount), http.StatusUnprocessableEntity, apierr.CodeInvalidAccount},
+		{auth.ErrUsernameTaken, http.StatusConflict, apierr.CodeUsernameTaken},
+		{auth.ErrEmailTaken, http.StatusConflict, apierr.CodeEmailTaken},
+		{auth.ErrUserNotFound, http.StatusNotFound, apierr.CodeUserNotFound},
+		{auth.ErrWrongPassword, http.StatusForbidden, apierr.CodeWrongPassword},
+		{auth.ErrInvalidCredentials, http.StatusUnauthorized, apierr.CodeInvalidCredentials},
+		{auth.ErrRefreshExpired, http.StatusUnauthorized, apierr.CodeRefreshExpired},
+		{auth.ErrRefreshReused, http.StatusUnauthorized, apierr.CodeRefreshReused},
+		{auth.ErrRefreshInvalid, http.StatusUnauthorized, apierr.CodeRefreshInvalid},
+		{auth.ErrAPIKeyNotFound, http.StatusNotFound, apierr.CodeAPIKeyNotFound},
+		{auth.ErrVerificationDisabled, http.StatusServiceUnavailable, apierr.CodeVerificationDisabled},
+		{auth.ErrEmailMissing, http.StatusBadRequest, apierr.CodeEmailMissing},
+		{auth.ErrEmailAlreadyVerified, http.StatusConflict, apierr.CodeEmailVerified},
+		{auth.ErrVerificationExpired, http.StatusBadRequest, apierr.CodeVerificationExpired},
+		{auth.ErrVerificationUsed, http.StatusConflict, apierr.CodeVerificationUsed},
+		{auth.ErrVerificationInvalid, http.StatusBadRequest, apierr.CodeVerificationInvalid},
+		{auth.ErrTicketsDisabled, http.StatusServiceUnavailable, apierr.CodeAuthDisabled},
+		{auth.ErrTicketScope, http.StatusForbidden, apierr.CodeInsufficientScope},
+		{errors.New("database exploded"), http.StatusInternalServerError, apierr.CodeInternal},
+	}
+	for _, tc := range cases {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+			h.writeAuthError(c, tc.err)
+			if rec.Code != tc.status || responseCode(t, rec) != string(tc.code) {
+				t.Errorf("writeAuthError(%v) = %d %s, want %d %s", tc.err, rec.Code, responseCode(t, rec), tc.status, tc.code)
+			}
+		})
+	}
+}
+
+func TestWriteErrorReportsDependencyOutages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		code   apierr.Code
+	}{
+		{"mongo down", fmt.Errorf("save: %w", db.ErrUnavailable), http.StatusServiceUnavailable, apierr.CodeDependencyDown},
+		{"redis down", fmt.Errorf("cache: %w", kv.ErrUnavailable), http.StatusServiceUnavailable, apierr.CodeDependencyDown},
+		{"classified error keeps its code", apierr.Wrap(kv.ErrUnavailable, apierr.CodeRateLimited, "slow down"), http.StatusTooManyRequests, apierr.CodeRateLimited},
+		{"unclassified error", errors.New("boom"), http.StatusInternalServerError, apierr.CodeInternal},
+		{"api error", apierr.New(apierr.CodeRoleNotFound, "role not found"), http.StatusNotFound, apierr.CodeRoleNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			writeError(c, tc.err)
+			if rec.Code != tc.status || responseCode(t, rec) != string(tc.code) {
+				t.Errorf("writeError = %d %s, want %d %s", rec.Code, responseCode(t, rec), tc.status, tc.code)
+			}
+		})
+	}
+}
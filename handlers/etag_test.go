@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEtagMatches(t *testing.T) {
+	const etag = `W/"abc123"`
+	cases := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{`W/"abc123"`, true},
+		{`"abc123"`, true},
+		{`W/"other"`, false},
+		{`"other", W/"abc123"`, true},
+		{` "other" ,  "abc123" `, true},
+		{"*", true},
+		{`abc123`, false},
+	}
+	for _, tc := range cases {
+		if got := etagMatches(tc.header, etag); got != tc.want {
+			t.Errorf("etagMatches(%q) = %t, want %t", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestWriteTaggedJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := []byte(`{"roles":[` + strings.Repeat(`{"name":"sage"},`, 20) + `{"name":"last"}]}`)
+	router := gin.New()
+	router.Use(Compress(64))
+	router.GET("/", func(c *gin.Context) { writeTaggedJSON(c, body) })
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get(nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Body.String() != string(body) {
+		t.Fatalf("got %d %q, want 200 with the body", first.Code, first.Body.String())
+	}
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("ETag = %q, want a weak tag", etag)
+	}
+	if got := first.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want no-cache", got)
+	}
+
+	cases := []struct {
+		name       string
+		header     http.Header
+		wantStatus int
+	}{
+		{"matching tag", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"matching strong form", http.Header{"If-None-Match": {strings.TrimPrefix(etag, "W/")}}, http.StatusNotModified},
+		{"tag in a list", http.Header{"If-None-Match": {`"stale", ` + etag}}, http.StatusNotModified},
+		{"wildcard", http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		{"matching tag, compressed", http.Header{"If-None-Match": {etag}, "Accept-Encoding": {"gzip"}}, http.StatusNotModified},
+		{"stale tag", http.Header{"If-None-Match": {`W/"stale"`}}, http.StatusOK},
+		{"no tag", http.Header{"Accept-Encoding": {"gzip"}}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := get(tc.header)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tc.wantStatus)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q whatever the encoding", got, etag)
+			}
+			if tc.wantStatus == http.StatusNotModified {
+				if rec.Body.Len() != 0 || rec.Header().Get("Content-Encoding") != "" {
+					t.Errorf("304 carried %d bytes encoded as %q, want an empty unencoded body", rec.Body.Len(), rec.Header().Get("Content-Encoding"))
+				}
+				return
+			}
+			encoding := rec.Header().Get("Content-Encoding")
+			if got := decodeBody(t, encoding, rec.Body.Bytes()); got != string(body) {
+				t.Errorf("body = %q, want the tagged JSON", got)
+			}
+		})
+	}
+
+	other := []byte(`{"roles":[]}`)
+	sum := func(b []byte) string {
+		router := gin.New()
+		router.GET("/", func(c *gin.Context) { writeTaggedJSON(c, b) })
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Header().Get("ETag")
+	}
+	if sum(other) == etag {
+		t.Errorf("different bodies share the tag %q", etag)
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/flags"
+	"go.uber.org/zap"
+)
+
+// FlagsHandler exposes feature flag state to clients and operators.
+type FlagsHandler struct {
+	flags  *flags.Service
+	logger *zap.SugaredLogger
+}
+
+// NewFlagsHandler builds a new FlagsHandler.
+func NewFlagsHandler(flagService *flags.Service, logger *zap.SugaredLogger) *FlagsHandler {
+	return &FlagsHandler{flags: flagService, logger: logger}
+}
+
+type flagOverrideRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// RequireFeature rejects requests with 503 while the given flag is off.
+func RequireFeature(flagService *flags.Service, key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !flagService.Enabled(c.Request.Context(), key) {
+			abortError(c, apierr.New(apierr.CodeFeatureDisabled, "feature disabled").With("flag", key))
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetCapabilities reports the effective feature flags so clients can adapt their UI.
+func (h *FlagsHandler) GetCapabilities(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"flags": h.flags.Effective(c.Request.Context())})
+}
+
+// ListFlags returns every flag with its default, override and effective value.
+func (h *FlagsHandler) ListFlags(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"flags": h.flags.List(c.Request.Context())})
+}
+
+// SetFlag stores a cluster-wide override for a flag.
+func (h *FlagsHandler) SetFlag(c *gin.Context) {
+	var req flagOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "enabled (bool) is required"))
+		return
+	}
+
+	key := strings.TrimSpace(c.Param("key"))
+	if err := h.flags.SetOverride(c.Request.Context(), key, *req.Enabled, adminActor(c)); err != nil {
+		h.writeFlagError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"key": key, "override": *req.Enabled})
+}
+
+// ClearFlag removes an override so the flag reverts to its default.
+func (h *FlagsHandler) ClearFlag(c *gin.Context) {
+	key := strings.TrimSpace(c.Param("key"))
+	if err := h.flags.ClearOverride(c.Request.Context(), key, adminActor(c)); err != nil {
+		h.writeFlagError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListFlagAudit returns recent override changes, newest first.
+func (h *FlagsHandler) ListFlagAudit(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	entries, err := h.flags.Audit(c.Request.Context(), limit)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("read flag audit failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "read flag audit failed"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+func (h *FlagsHandler) writeFlagError(c *gin.Context, err error) {
+	if errors.Is(err, flags.ErrUnknownFlag) {
+		writeError(c, apierr.New(apierr.CodeFlagNotFound, "unknown feature flag"))
+		return
+	}
+	ctxlog.From(c.Request.Context(), h.logger).Warnf("update feature flag failed: %v", err)
+	writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "update feature flag failed"))
+}
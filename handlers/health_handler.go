@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthTimeout  = time.Second
+	defaultHealthCacheTTL = 2 * time.Second
+)
+
+// HealthCheck is one dependency probed by the readiness endpoint. A failing optional
+// check degrades the instance without taking it out of rotation.
+type HealthCheck struct {
+	Name     string
+	Required bool
+	Ping     func(ctx context.Context) error
+}
+
+// HealthHandler serves liveness and readiness probes.
+type HealthHandler struct {
+	checks   []HealthCheck
+	timeout  time.Duration
+	cacheTTL time.Duration
+	logger   *zap.SugaredLogger
+	now      func() time.Time
+
+	mu     sync.Mutex
+	cached *readiness
+}
+
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	Required  bool    `json:"required"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]dependencyStatus `json:"dependencies"`
+	CheckedAt    time.Time                   `json:"checked_at"`
+	ready        bool
+}
+
+// NewHealthHandler builds a HealthHandler pinging checks with timeout each and reusing a
+// result for cacheTTL, so probes from several load balancers cost one round of pings.
+// Non-positive durations fall back to 1s and 2s.
+func NewHealthHandler(checks []HealthCheck, timeout, cacheTTL time.Duration, logger *zap.SugaredLogger) *HealthHandler {
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	if cacheTTL <= 0 {
+		cacheTTL = defaultHealthCacheTTL
+	}
+	return &HealthHandler{checks: checks, timeout: timeout, cacheTTL: cacheTTL, logger: logger, now: time.Now}
+}
+
+// Live handles GET /health/live: the process is up and serving, whatever its
+// dependencies.
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Ready handles GET /health/ready, reporting each dependency's status and latency. It
+// responds 503 when a required dependency fails; optional failures report "degraded".
+func (h *HealthHandler) Ready(c *gin.Context) {
+	result := h.readiness(c.Request.Context())
+	status := http.StatusOK
+	if !result.ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, result)
+}
+
+// readiness returns the cached result while fresh. The lock is held during the pings so
+// concurrent probes wait for one round instead of starting their own.
+func (h *HealthHandler) readiness(ctx context.Context) *readiness {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cached != nil && h.now().Sub(h.cached.CheckedAt) < h.cacheTTL {
+		return h.cached
+	}
+
+	// a probe's own deadline must not cut the pings short for the probes sharing the result
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.timeout)
+	defer cancel()
+
+	statuses := make([]dependencyStatus, len(h.checks))
+	var wg sync.WaitGroup
+	for i, check := range h.checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := check.Ping(ctx)
+			status := dependencyStatus{
+				Status:    "ok",
+				Required:  check.Required,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				status.Status, status.Error = "error", err.Error()
+			}
+			statuses[i] = status
+		}(i, check)
+	}
+	wg.Wait()
+
+	result := &readiness{Status: "ok", Dependencies: make(map[string]dependencyStatus, len(h.checks)), CheckedAt: h.now(), ready: true}
+	for i, check := range h.checks {
+		status := statuses[i]
+		result.Dependencies[check.Name] = status
+		if status.Status == "ok" {
+			continue
+		}
+		if check.Required {
+			result.ready = false
+			result.Status = "unavailable"
+		} else if result.ready {
+			result.Status = "degraded"
+		}
+		// log transitions only, not every cached probe
+		if h.cached == nil || h.cached.Dependencies[check.Name].Status == "ok" {
+			h.logger.Warnf("health check %s failed: %s", check.Name, status.Error)
+		}
+	}
+	h.cached = result
+	return result
+}
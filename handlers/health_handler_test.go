@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakePinger answers health pings with err, or blocks until the ping's deadline when hang
+// is set.
+type fakePinger struct {
+	mu    sync.Mutex
+	err   error
+	hang  bool
+	calls int
+}
+
+func (p *fakePinger) Ping(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return p.err
+}
+
+func (p *fakePinger) Fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func (p *fakePinger) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+type healthResponse struct {
+	Status       string `json:"status"`
+	Dependencies map[string]struct {
+		Status   string `json:"status"`
+		Required bool   `json:"required"`
+		Error    string `json:"error"`
+	} `json:"dependencies"`
+}
+
+func probe(t *testing.T, router *gin.Engine, path string) (int, healthResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var body healthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %s: %v: %s", path, err, rec.Body)
+	}
+	return rec.Code, body
+}
+
+func newHealthRouter(h *HealthHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/live", h.Live)
+	router.GET("/health/ready", h.Ready)
+	return router
+}
+
+// TestHealthDependencyDown takes each dependency down in turn: liveness never changes, a
+// required dependency takes the instance out of rotation and an optional one degrades it.
+func TestHealthDependencyDown(t *testing.T) {
+	dependencies := []struct {
+		name     string
+		required bool
+	}{
+		{"postgres", true},
+		{"redis", true},
+		{"mongo", false},
+		{"qiniu_chat", false},
+	}
+	cases := []struct {
+		down       string
+		wantStatus int
+		want       string
+	}{
+		{"", http.StatusOK, "ok"},
+		{"postgres", http.StatusServiceUnavailable, "unavailable"},
+		{"redis", http.StatusServiceUnavailable, "unavailable"},
+		{"mongo", http.StatusOK, "degraded"},
+		{"qiniu_chat", http.StatusOK, "degraded"},
+	}
+	for _, tc := range cases {
+		name := tc.down + " down"
+		if tc.down == "" {
+			name = "all up"
+		}
+		t.Run(name, func(t *testing.T) {
+			checks := make([]HealthCheck, 0, len(dependencies))
+			for _, dep := range dependencies {
+				pinger := &fakePinger{}
+				if dep.name == tc.down {
+					pinger.Fail(errors.New(dep.name + " refused the connection"))
+				}
+				checks = append(checks, HealthCheck{Name: dep.name, Required: dep.required, Ping: pinger.Ping})
+			}
+			router := newHealthRouter(NewHealthHandler(checks, time.Second, time.Minute, zap.NewNop().Sugar()))
+
+			if code, body := probe(t, router, "/health/live"); code != http.StatusOK || body.Status != "ok" {
+				t.Errorf("live = %d %s, want 200 ok whatever the dependencies", code, body.Status)
+			}
+			code, body := probe(t, router, "/health/ready")
+			if code != tc.wantStatus || body.Status != tc.want {
+				t.Fatalf("ready = %d %s, want %d %s", code, body.Status, tc.wantStatus, tc.want)
+			}
+			for _, dep := range dependencies {
+				got := body.Dependencies[dep.name]
+				wantStatus := "ok"
+				if dep.name == tc.down {
+					wantStatus = "error"
+				}
+				if got.Status != wantStatus || got.Required != dep.required || (got.Error != "") != (wantStatus == "error") {
+					t.Errorf("%s = %+v, want %s with required %t", dep.name, got, wantStatus, dep.required)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthCacheAndTimeout(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	postgres := &fakePinger{}
+	broker := &fakePinger{hang: true}
+	h := NewHealthHandler([]HealthCheck{
+		{Name: "postgres", Required: true, Ping: postgres.Ping},
+		{Name: "broker", Ping: broker.Ping},
+	}, 20*time.Millisecond, time.Minute, zap.New(core).Sugar())
+	now := time.Now()
+	h.now = func() time.Time { return now }
+	router := newHealthRouter(h)
+
+	// a hanging dependency is cut off at the ping timeout rather than stalling the probe
+	start := time.Now()
+	code, body := probe(t, router, "/health/ready")
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("probe took %s with a hanging dependency, want about the 20ms timeout", took)
+	}
+	if code != http.StatusOK || body.Status != "degraded" || body.Dependencies["broker"].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("ready = %d %+v, want degraded by the broker timing out", code, body)
+	}
+
+	// probes within the cache TTL share the result
+	postgres.Fail(errors.New("connection reset"))
+	if code, _ := probe(t, router, "/health/ready"); code != http.StatusOK || postgres.Calls() != 1 {
+		t.Errorf("cached probe = %d after %d pings, want the cached 200 after 1", code, postgres.Calls())
+	}
+
+	now = now.Add(time.Minute)
+	if code, body := probe(t, router, "/health/ready"); code != http.StatusServiceUnavailable || body.Status != "unavailable" {
+		t.Errorf("ready after the TTL = %d %s, want 503 unavailable", code, body.Status)
+	}
+	now = now.Add(time.Minute)
+	probe(t, router, "/health/ready")
+
+	// each dependency's failure is logged when it starts, not on every round
+	want := map[string]bool{
+		"health check postgres failed: connection reset":                  true,
+		"health check broker failed: " + context.DeadlineExceeded.Error(): true,
+	}
+	for _, entry := range logs.All() {
+		if !want[entry.Message] {
+			t.Errorf("unexpected or repeated warning %q", entry.Message)
+		}
+		delete(want, entry.Message)
+	}
+	if len(want) != 0 {
+		t.Errorf("failures never logged: %v", want)
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/locale"
+	"github.com/wuwenbin0122/wwb.ai/services"
+	"go.uber.org/zap"
+)
+
+// LanguagePreferences looks up the reply language a user saved on their profile;
+// auth.Service implements it.
+type LanguagePreferences interface {
+	PreferredLanguage(ctx context.Context, userID string) (string, error)
+}
+
+// negotiateLanguage picks the reply language of a chat or voice request and where it came
+// from, see locale.Negotiate. The profile is only read when the request sets no language,
+// and a failed lookup falls through to the next source; prefs may be nil to skip it.
+func negotiateLanguage(ctx context.Context, prefs LanguagePreferences, logger *zap.SugaredLogger, userID, requested, acceptLanguage string, roleLanguages []string) (string, string) {
+	in := locale.Inputs{
+		Request:        strings.TrimSpace(requested),
+		AcceptLanguage: acceptLanguage,
+		Role:           roleLanguages,
+		Default:        services.DefaultLanguage,
+	}
+	if in.Request == "" && userID != "" && prefs != nil {
+		preferred, err := prefs.PreferredLanguage(ctx, userID)
+		if err != nil {
+			ctxlog.From(ctx, logger).Warnf("load preferred language: %v", err)
+		}
+		in.Profile = preferred
+	}
+	return locale.Negotiate(in)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// stubLanguagePreferences serves saved languages by user, failing for "broken".
+type stubLanguagePreferences map[string]string
+
+func (s stubLanguagePreferences) PreferredLanguage(_ context.Context, userID string) (string, error) {
+	if userID == "broken" {
+		return "", errors.New("users table unavailable")
+	}
+	return s[userID], nil
+}
+
+// TestVoiceSessionLanguage starts voice sessions and checks the ready event reports the
+// language negotiated from the start message, the profile, the upgrade request's
+// Accept-Language and the role, in that order.
+func TestVoiceSessionLanguage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	roles := db.NewMemoryRoleRepository(models.Role{ID: 1, Name: "Socrates", Languages: []string{"el", "en"}})
+	prefs := stubLanguagePreferences{"fr-user": "fr"}
+	cfg := &config.Config{QiniuTokenMode: "server", QiniuAPIKey: "server-key"}
+	h := NewVoiceHandler(cfg, roles, nil, nil, nil, prefs, zap.NewNop().Sugar())
+
+	router := gin.New()
+	router.GET("/api/voice/session", func(c *gin.Context) {
+		if user := c.Query("user"); user != "" {
+			c.Set(userIDContextKey, user)
+		}
+		h.HandleVoiceSession(c)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	cases := []struct {
+		name           string
+		user           string
+		language       string
+		acceptLanguage string
+		want           string
+		wantSource     string
+	}{
+		{"start message", "fr-user", "ja", "de", "ja", "request"},
+		{"profile", "fr-user", "", "de", "fr", "profile"},
+		{"profile lookup fails", "broken", "", "de;q=0.4, zh-TW;q=0.8", "zh", "accept_language"},
+		{"no profile", "plain-user", "", "de", "de", "accept_language"},
+		{"role", "", "", "", "el", "role"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			if tc.acceptLanguage != "" {
+				header.Set("Accept-Language", tc.acceptLanguage)
+			}
+			url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/voice/session?user=" + tc.user
+			conn, _, err := websocket.DefaultDialer.Dial(url, header)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			if err := conn.WriteJSON(map[string]any{"type": "start", "role_id": 1, "language": tc.language}); err != nil {
+				t.Fatal(err)
+			}
+			for {
+				var event map[string]any
+				if err := conn.ReadJSON(&event); err != nil {
+					t.Fatalf("waiting for ready: %v", err)
+				}
+				if event["type"] == "error" {
+					t.Fatalf("error event: %v", event)
+				}
+				if event["type"] != "ready" {
+					continue
+				}
+				if event["language"] != tc.want || event["language_source"] != tc.wantSource {
+					t.Errorf("ready in %v from %v, want %q from %s", event["language"], event["language_source"], tc.want, tc.wantSource)
+				}
+				return
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+)
+
+// RouteLimits maps "METHOD /route/template" (as in "POST /api/audio/asr") to a limit that
+// replaces the default for that route.
+type RouteLimits[T any] map[string]T
+
+func (l RouteLimits[T]) lookup(c *gin.Context, fallback T) T {
+	if value, ok := l[c.Request.Method+" "+c.FullPath()]; ok {
+		return value
+	}
+	return fallback
+}
+
+// BodyLimit rejects request bodies larger than limit, or the route's override, with 413
+// and PAYLOAD_TOO_LARGE. A declared Content-Length is checked up front; otherwise the
+// body reader fails once the limit is crossed, which handlers report as the same error.
+// A non-positive limit disables the check.
+func BodyLimit(limit int64, overrides RouteLimits[int64]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		max := overrides.lookup(c, limit)
+		if max <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > max {
+			abortError(c, apierr.New(apierr.CodePayloadTooLarge, "request body too large").With("max_bytes", max))
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+		c.Next()
+	}
+}
+
+// HandlerTimeout cancels the request context once a handler has run for timeout, or the
+// route's override, and answers 408 with REQUEST_TIMEOUT unless the handler already
+// started its response; whatever the handler writes after the 408 is discarded. WebSocket
+// upgrades are exempt since their handler lives as long as the connection. A
+// non-positive timeout disables it.
+func HandlerTimeout(timeout time.Duration, overrides RouteLimits[time.Duration]) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := overrides.lookup(c, timeout)
+		if limit <= 0 || websocket.IsWebSocketUpgrade(c.Request) {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithCancelCause(c.Request.Context())
+		defer cancel(nil)
+		c.Request = c.Request.WithContext(ctx)
+
+		writer := &timeoutWriter{ResponseWriter: c.Writer, header: c.Writer.Header().Clone()}
+		c.Writer = writer
+
+		requestID := c.GetString(requestIDContextKey)
+		fired := make(chan struct{})
+		timer := time.AfterFunc(limit, func() {
+			defer close(fired)
+			writer.timeout(requestID)
+			// cancel only once the 408 is out, so the handler's own error cannot win the race
+			cancel(context.DeadlineExceeded)
+		})
+
+		c.Next()
+
+		if !timer.Stop() {
+			// the timer owns the underlying writer until it is done
+			<-fired
+		}
+		c.Writer = writer.ResponseWriter
+	}
+}
+
+// timeoutWriter serializes the handler's writes with the timeout response. Until the
+// handler commits its status, headers go to a private map so the timeout response never
+// races with a handler setting them.
+type timeoutWriter struct {
+	gin.ResponseWriter
+
+	mu       sync.Mutex
+	header   http.Header
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	w.commitHeaderLocked()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	w.commitHeaderLocked()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.commitHeaderLocked()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.commitHeaderLocked()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	w.commitHeaderLocked()
+	w.ResponseWriter.Flush()
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseWriter.Status()
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseWriter.Size()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseWriter.Written()
+}
+
+func (w *timeoutWriter) commitHeaderLocked() {
+	dst := w.ResponseWriter.Header()
+	for key, values := range w.header {
+		dst[key] = values
+	}
+}
+
+// timeout sends the 408 unless the response is already under way. Content-Length is set
+// so the client can read the whole response while the handler is still winding down, and
+// the connection is closed since it stays busy until then.
+func (w *timeoutWriter) timeout(requestID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ResponseWriter.Written() {
+		// too late for a 408; the handler still sees its context canceled
+		return
+	}
+	w.timedOut = true
+
+	apiErr := apierr.New(apierr.CodeRequestTimeout, "request timed out")
+	body, err := json.Marshal(apiErr.Body(requestID))
+	if err != nil {
+		return
+	}
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Connection", "close")
+	w.ResponseWriter.WriteHeader(apiErr.Status())
+	_, _ = w.ResponseWriter.Write(body)
+	w.ResponseWriter.Flush()
+}
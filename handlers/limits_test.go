@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+)
+
+func TestBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(BodyLimit(16, RouteLimits[int64]{"POST /upload": 64, "POST /open": 0}))
+	echo := func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "read body"))
+			return
+		}
+		c.String(http.StatusOK, "%d", len(body))
+	}
+	router.POST("/echo", echo)
+	router.POST("/upload", echo)
+	router.POST("/open", echo)
+
+	cases := []struct {
+		name    string
+		path    string
+		size    int
+		chunked bool
+		status  int
+		code    string
+	}{
+		{"under the limit", "/echo", 16, false, http.StatusOK, ""},
+		{"declared length over the limit", "/echo", 17, false, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE"},
+		{"chunked body over the limit", "/echo", 17, true, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE"},
+		{"route override raises the limit", "/upload", 64, false, http.StatusOK, ""},
+		{"route override still applies", "/upload", 65, true, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE"},
+		{"route override disables the limit", "/open", 1 << 10, false, http.StatusOK, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var body io.Reader = bytes.NewReader(bytes.Repeat([]byte("x"), tc.size))
+			if tc.chunked {
+				// hide the length so only the reader can catch the overflow
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest(http.MethodPost, tc.path, body)
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.status || responseCode(t, rec) != tc.code {
+				t.Errorf("POST %s with %d bytes = %d %q, want %d %q", tc.path, tc.size, rec.Code, responseCode(t, rec), tc.status, tc.code)
+			}
+		})
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(HandlerTimeout(50*time.Millisecond, RouteLimits[time.Duration]{"GET /slow-ok": time.Second}))
+	slow := func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+			// a handler that ignores the timeout and writes anyway must not corrupt the 408
+			c.Header("X-Late", "1")
+			c.String(http.StatusOK, "late")
+		case <-time.After(200 * time.Millisecond):
+			c.String(http.StatusOK, "done")
+		}
+	}
+	router.GET("/slow", slow)
+	router.GET("/slow-ok", slow)
+	router.GET("/fast", func(c *gin.Context) { c.String(http.StatusOK, "fast") })
+	router.GET("/streaming", func(c *gin.Context) {
+		c.String(http.StatusOK, "started")
+		c.Writer.Flush()
+		<-c.Request.Context().Done()
+		c.String(http.StatusOK, " canceled")
+	})
+	router.GET("/ws", func(c *gin.Context) {
+		conn, err := asrUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(150 * time.Millisecond)
+		msg := "still here"
+		if c.Request.Context().Err() != nil {
+			msg = "canceled"
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(msg))
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	get := func(path string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	t.Run("fast handler", func(t *testing.T) {
+		if resp, body := get("/fast"); resp.StatusCode != http.StatusOK || body != "fast" {
+			t.Errorf("GET /fast = %d %q", resp.StatusCode, body)
+		}
+	})
+	t.Run("slow handler gets 408", func(t *testing.T) {
+		resp, body := get("/slow")
+		if resp.StatusCode != http.StatusRequestTimeout || !strings.Contains(body, `"REQUEST_TIMEOUT"`) {
+			t.Errorf("GET /slow = %d %q, want 408 REQUEST_TIMEOUT", resp.StatusCode, body)
+		}
+		if resp.Header.Get("X-Late") != "" {
+			t.Error("header set after the timeout reached the client")
+		}
+	})
+	t.Run("route override", func(t *testing.T) {
+		if resp, body := get("/slow-ok"); resp.StatusCode != http.StatusOK || body != "done" {
+			t.Errorf("GET /slow-ok = %d %q, want the handler's own response", resp.StatusCode, body)
+		}
+	})
+	t.Run("started response is kept", func(t *testing.T) {
+		resp, body := get("/streaming")
+		if resp.StatusCode != http.StatusOK || body != "started canceled" {
+			t.Errorf("GET /streaming = %d %q, want the streamed 200 with the context canceled", resp.StatusCode, body)
+		}
+	})
+	t.Run("websocket upgrade is exempt", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		_, msg, err := conn.ReadMessage()
+		if err != nil || string(msg) != "still here" {
+			t.Errorf("message after the timeout = %q, %v", msg, err)
+		}
+	})
+}
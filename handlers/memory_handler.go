@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMemoryLimit = 50
+	maxMemoryLimit     = 200
+)
+
+// UserMemoryStore reads and deletes remembered facts; *db.MemoryStore implements it.
+// Every call is scoped to userID, so one user can never see or remove another's facts.
+type UserMemoryStore interface {
+	List(ctx context.Context, userID string, roleID int64, limit int) ([]models.Memory, error)
+	// Delete returns mongo.ErrNoDocuments when userID has no fact id.
+	Delete(ctx context.Context, userID, id string) error
+	DeleteAll(ctx context.Context, userID string, roleID int64) (int64, error)
+}
+
+// MemoryHandler lets users inspect and remove the facts roles remember about them.
+type MemoryHandler struct {
+	store  UserMemoryStore
+	logger *zap.SugaredLogger
+}
+
+// NewMemoryHandler builds a new MemoryHandler.
+func NewMemoryHandler(store UserMemoryStore, logger *zap.SugaredLogger) *MemoryHandler {
+	return &MemoryHandler{store: store, logger: logger}
+}
+
+// ListMemories handles GET /api/memories?role_id=&limit=, responding with the caller's
+// remembered facts, newest first, for one role or all of them.
+func (h *MemoryHandler) ListMemories(c *gin.Context) {
+	roleID, ok := parseMemoryRoleID(c)
+	if !ok {
+		return
+	}
+	limit := defaultMemoryLimit
+	if raw := strings.TrimSpace(c.Query("limit")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, "limit must be a positive integer"))
+			return
+		}
+		limit = min(parsed, maxMemoryLimit)
+	}
+
+	memories, err := h.store.List(c.Request.Context(), MustUserID(c), roleID, limit)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("list memories failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to load memories"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"memories": memories})
+}
+
+// DeleteMemory handles DELETE /api/memories/:id.
+func (h *MemoryHandler) DeleteMemory(c *gin.Context) {
+	if err := h.store.Delete(c.Request.Context(), MustUserID(c), strings.TrimSpace(c.Param("id"))); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			writeError(c, apierr.New(apierr.CodeMemoryNotFound, "memory not found"))
+			return
+		}
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("delete memory failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to delete memory"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteMemories handles DELETE /api/memories?role_id=, forgetting every fact of the
+// caller for one role or, without role_id, for all roles.
+func (h *MemoryHandler) DeleteMemories(c *gin.Context) {
+	roleID, ok := parseMemoryRoleID(c)
+	if !ok {
+		return
+	}
+	deleted, err := h.store.DeleteAll(c.Request.Context(), MustUserID(c), roleID)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("delete memories failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to delete memories"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+func parseMemoryRoleID(c *gin.Context) (int64, bool) {
+	raw := strings.TrimSpace(c.Query("role_id"))
+	if raw == "" {
+		return 0, true
+	}
+	roleID, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || roleID <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "role_id must be a positive integer"))
+		return 0, false
+	}
+	return roleID, true
+}
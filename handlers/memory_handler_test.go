@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// fakeMemoryStore filters by owner the way the *db.MemoryStore queries do.
+type fakeMemoryStore struct {
+	mu       sync.Mutex
+	memories []models.Memory
+}
+
+func (s *fakeMemoryStore) List(_ context.Context, userID string, roleID int64, limit int) ([]models.Memory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	listed := make([]models.Memory, 0)
+	for _, memory := range s.memories {
+		if memory.UserID == userID && (roleID == 0 || memory.RoleID == roleID) && len(listed) < limit {
+			listed = append(listed, memory)
+		}
+	}
+	return listed, nil
+}
+
+func (s *fakeMemoryStore) Delete(_ context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, memory := range s.memories {
+		if memory.ID == id && memory.UserID == userID {
+			s.memories = append(s.memories[:i], s.memories[i+1:]...)
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (s *fakeMemoryStore) DeleteAll(_ context.Context, userID string, roleID int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.memories[:0]
+	var deleted int64
+	for _, memory := range s.memories {
+		if memory.UserID == userID && (roleID == 0 || memory.RoleID == roleID) {
+			deleted++
+			continue
+		}
+		kept = append(kept, memory)
+	}
+	s.memories = kept
+	return deleted, nil
+}
+
+func (s *fakeMemoryStore) ids() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make(map[string]bool, len(s.memories))
+	for _, memory := range s.memories {
+		ids[memory.ID] = true
+	}
+	return ids
+}
+
+// TestMemoryOwnerScoping checks a user can list and delete only their own facts, whatever
+// IDs they send.
+func TestMemoryOwnerScoping(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &fakeMemoryStore{memories: []models.Memory{
+		{ID: "a1", UserID: "alice", RoleID: 1, Fact: "用户叫小李"},
+		{ID: "a2", UserID: "alice", RoleID: 2, Fact: "用户在学法语"},
+		{ID: "b1", UserID: "bob", RoleID: 1, Fact: "The user is Bob"},
+		{ID: "b2", UserID: "bob", RoleID: 2, Fact: "The user is 30"},
+	}}
+	h := NewMemoryHandler(store, zap.NewNop().Sugar())
+	router := gin.New()
+	signIn := func(c *gin.Context) { c.Set(userIDContextKey, c.GetHeader("X-Test-User")) }
+	router.GET("/api/memories", signIn, h.ListMemories)
+	router.DELETE("/api/memories", signIn, h.DeleteMemories)
+	router.DELETE("/api/memories/:id", signIn, h.DeleteMemory)
+
+	serve := func(user, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Test-User", user)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("alice", http.MethodGet, "/api/memories")
+	var listed struct {
+		Memories []models.Memory `json:"memories"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed.Memories) != 2 {
+		t.Fatalf("alice lists %s, want her two facts", rec.Body)
+	}
+	for _, memory := range listed.Memories {
+		if memory.ID[0] != 'a' {
+			t.Errorf("alice listed %s", memory.ID)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		user    string
+		path    string
+		status  int
+		removed []string
+	}{
+		{"another user's fact", "alice", "/api/memories/b1", http.StatusNotFound, nil},
+		{"unknown fact", "alice", "/api/memories/zz", http.StatusNotFound, nil},
+		{"own fact", "bob", "/api/memories/b1", http.StatusNoContent, []string{"b1"}},
+		{"own fact again", "bob", "/api/memories/b1", http.StatusNotFound, nil},
+		{"all own facts of a role", "alice", "/api/memories?role_id=2", http.StatusOK, []string{"a2"}},
+		{"all own facts", "bob", "/api/memories", http.StatusOK, []string{"b2"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := store.ids()
+			rec := serve(tc.user, http.MethodDelete, tc.path)
+			if rec.Code != tc.status {
+				t.Fatalf("DELETE %s as %s = %d %s, want %d", tc.path, tc.user, rec.Code, rec.Body, tc.status)
+			}
+			if tc.status == http.StatusNotFound && responseCode(t, rec) != "MEMORY_NOT_FOUND" {
+				t.Errorf("code = %s, want MEMORY_NOT_FOUND", responseCode(t, rec))
+			}
+			after := store.ids()
+			if len(before)-len(after) != len(tc.removed) {
+				t.Fatalf("%d facts removed, want %v", len(before)-len(after), tc.removed)
+			}
+			for _, id := range tc.removed {
+				if after[id] {
+					t.Errorf("%s still stored", id)
+				}
+			}
+		})
+	}
+	if ids := store.ids(); len(ids) != 1 || !ids["a1"] {
+		t.Errorf("left %v, want only a1", ids)
+	}
+}
@@ -1,28 +1,44 @@
 package handlers
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
 	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
 	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/memory"
 	"github.com/wuwenbin0122/wwb.ai/services"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
 	"go.uber.org/zap"
 )
 
 type NLPHandler struct {
-	cfg    *config.Config
-	pool   *pgxpool.Pool
-	nlp    *services.NLPService
-	logger *zap.SugaredLogger
+	cfg         *config.Config
+	roles       db.RoleRepository
+	stats       *db.RoleStatsStore
+	nlp         *services.NLPService
+	usage       *db.UsageStore
+	memories    *memory.Service
+	suggestions *services.SuggestionGenerator
+	sentiment   *services.SentimentClassifier
+	languages   LanguagePreferences
+	tokens      *tokenresolver.Resolver
+	logger      *zap.SugaredLogger
 }
 
-func NewNLPHandler(cfg *config.Config, pool *pgxpool.Pool, nlp *services.NLPService, logger *zap.SugaredLogger) *NLPHandler {
-	return &NLPHandler{cfg: cfg, pool: pool, nlp: nlp, logger: logger}
+// NewNLPHandler builds an NLPHandler; stats may be nil to skip role usage counting, usage
+// nil to skip usage accounting, memories nil to chat without remembered facts,
+// suggestions nil to never suggest follow-up questions, sentiment nil to never
+// auto-enable skills and languages nil to ignore profile language preferences.
+func NewNLPHandler(cfg *config.Config, roles db.RoleRepository, stats *db.RoleStatsStore, nlp *services.NLPService, usage *db.UsageStore, memories *memory.Service, suggestions *services.SuggestionGenerator, sentiment *services.SentimentClassifier, languages LanguagePreferences, logger *zap.SugaredLogger) *NLPHandler {
+	return &NLPHandler{cfg: cfg, roles: roles, stats: stats, nlp: nlp, usage: usage, memories: memories, suggestions: suggestions, sentiment: sentiment, languages: languages, tokens: tokenresolver.New(cfg.QiniuTokenMode, cfg.QiniuAPIKey), logger: logger}
 }
 
 type nlpMessagePayload struct {
@@ -38,53 +54,111 @@ type nlpRequestPayload struct {
 	EnabledSkillIDs   []string            `json:"enabled_skill_ids"`
 	SummaryThreshold  int                 `json:"summary_threshold"`
 	RecentMessageKeep int                 `json:"recent_message_keep"`
-	Temperature       float64             `json:"temperature"`
-	MaxTokens         int                 `json:"max_tokens"`
+	// Temperature, MaxTokens and TopP take the deployment defaults when omitted; an
+	// explicit 0 is sent as is.
+	Temperature *float64 `json:"temperature"`
+	MaxTokens   *int     `json:"max_tokens"`
+	TopP        *float64 `json:"top_p"`
+	// ResponseFormat is "text" (default) or "structured", see services.StructuredReply.
+	ResponseFormat string `json:"response_format"`
+	// IncludeSuggestions adds three follow-up questions to the reply.
+	IncludeSuggestions bool `json:"include_suggestions"`
+	// Provider selects the chat backend by name; empty uses CHAT_PROVIDER_DEFAULT.
+	Provider string `json:"provider"`
+	// AutoSkills set to false keeps the conversation's sentiment from enabling skills.
+	AutoSkills *bool `json:"auto_skills"`
+
+	// AcceptLanguage is the request's Accept-Language header, set by the handler.
+	AcceptLanguage string `json:"-"`
 }
 
-func (h *NLPHandler) HandleChat(c *gin.Context) {
-	var payload nlpRequestPayload
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request payload", "detail": err.Error()})
-		return
+// chatIssue is one validation problem; Code decides the HTTP status HandleChat rejects it with.
+type chatIssue struct {
+	Field   string      `json:"field,omitempty"`
+	Code    apierr.Code `json:"code"`
+	Message string      `json:"message"`
+	Detail  string      `json:"detail,omitempty"`
+}
+
+// chatPlan is the outcome of validating a chat payload and composing its prompt.
+// HandleChat and HandleValidate both build it with planChat so they cannot disagree.
+type chatPlan struct {
+	Request services.NLPRequest
+	// LanguageSource tells where Request.Language came from, one of the locale.Source values.
+	LanguageSource string
+
+	Prompt   *services.NLPPrompt
+	Errors   []chatIssue
+	Warnings []string
+}
+
+func (p *chatPlan) fail(field string, code apierr.Code, message string, detail error) {
+	issue := chatIssue{Field: field, Code: code, Message: message}
+	if detail != nil {
+		issue.Detail = detail.Error()
 	}
+	p.Errors = append(p.Errors, issue)
+}
+
+// planChat runs every chat validation rule, collecting all problems instead of stopping at the first.
+// userID is the caller; private roles of other users are reported as not found. dryRun
+// skips model calls made while planning, such as llm sentiment classification.
+func (h *NLPHandler) planChat(ctx context.Context, userID string, payload nlpRequestPayload, dryRun bool) *chatPlan {
+	plan := &chatPlan{}
 
 	if payload.RoleID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "role_id is required"})
-		return
+		plan.fail("role_id", apierr.CodeInvalidRequest, "role_id is required", nil)
 	}
 
 	messages := normalizeNLPMessages(payload.Messages)
 	if len(messages) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one message is required"})
-		return
+		plan.fail("messages", apierr.CodeInvalidRequest, "at least one message is required", nil)
+	} else if strings.ToLower(messages[len(messages)-1].Role) != "user" {
+		plan.fail("messages", apierr.CodeInvalidRequest, "last message must be from user", nil)
 	}
 
-	last := messages[len(messages)-1]
-	if strings.ToLower(last.Role) != "user" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "last message must be from user"})
-		return
+	if payload.MaxTokens != nil && *payload.MaxTokens < 0 {
+		plan.fail("max_tokens", apierr.CodeInvalidRequest, "max_tokens must not be negative", nil)
+	}
+	if t := payload.Temperature; t != nil && (*t < 0 || *t > 2) {
+		plan.fail("temperature", apierr.CodeInvalidRequest, "temperature must be between 0 and 2", nil)
+	}
+	if p := payload.TopP; p != nil && (*p < 0 || *p > 1) {
+		plan.fail("top_p", apierr.CodeInvalidRequest, "top_p must be between 0 and 1", nil)
+	}
+	if _, err := services.NormalizeResponseFormat(payload.ResponseFormat); err != nil {
+		plan.fail("response_format", apierr.CodeInvalidRequest, err.Error(), nil)
+	}
+	if _, err := h.nlp.Provider(payload.Provider); err != nil {
+		plan.fail("provider", apierr.CodeInvalidRequest, "unknown chat provider", nil)
 	}
 
-	role, err := db.GetRoleByID(c.Request.Context(), h.pool, payload.RoleID)
+	if payload.RoleID <= 0 {
+		return plan
+	}
+	role, err := h.roles.GetByID(ctx, payload.RoleID)
+	if err == nil && !role.VisibleTo(userID) {
+		err = pgx.ErrNoRows
+	}
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "role not found"})
-			return
+			plan.fail("role_id", apierr.CodeRoleNotFound, "role not found", nil)
+		} else {
+			ctxlog.From(ctx, h.logger).Warnf("fetch role failed: %v", err)
+			plan.fail("role_id", apierr.CodeUpstream, "failed to load role", err)
 		}
-		h.logger.Warnf("fetch role failed: %v", err)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to load role", "detail": err.Error()})
-		return
+		return plan
 	}
-
-	language := strings.TrimSpace(payload.Language)
-	if language == "" && len(role.Languages) > 0 {
-		language = strings.TrimSpace(role.Languages[0])
+	if len(plan.Errors) > 0 {
+		return plan
 	}
 
-	history := messages[:len(messages)-1]
+	language, source := negotiateLanguage(ctx, h.languages, h.logger, userID, payload.Language, payload.AcceptLanguage, role.Languages)
+	plan.LanguageSource = source
 
-	req := services.NLPRequest{
+	last := messages[len(messages)-1]
+	history := messages[:len(messages)-1]
+	plan.Request = services.NLPRequest{
 		Role:               *role,
 		Language:           language,
 		History:            history,
@@ -94,35 +168,208 @@ func (h *NLPHandler) HandleChat(c *gin.Context) {
 		RecentMessageCount: payload.RecentMessageKeep,
 		Temperature:        payload.Temperature,
 		MaxTokens:          payload.MaxTokens,
+		TopP:               payload.TopP,
+		Memories:           h.memories.Recall(ctx, userID, payload.RoleID),
+		ResponseFormat:     payload.ResponseFormat,
+		Provider:           payload.Provider,
+		Sentiment:          h.sentiment.Analyze(ctx, history, last.Content, dryRun),
+		DisableAutoSkills:  payload.AutoSkills != nil && !*payload.AutoSkills,
+	}
+
+	prompt, err := h.nlp.ComposePrompt(plan.Request)
+	plan.Prompt = prompt
+	if err != nil {
+		plan.fail("messages", apierr.CodeOf(err, apierr.CodeInvalidRequest), "prompt composition failed", err)
+	}
+	if prompt != nil {
+		if n := len(prompt.UnknownSkillIDs); n > 0 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%d unknown skill ids will be ignored: %s", n, strings.Join(prompt.UnknownSkillIDs, ", ")))
+		}
+		if prompt.SummarizedMessages > 0 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("history will be summarized: %d older messages condensed", prompt.SummarizedMessages))
+		}
+		if prompt.DroppedMemories > 0 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%d remembered facts left out to fit the token budget", prompt.DroppedMemories))
+		}
+	}
+
+	return plan
+}
+
+func (h *NLPHandler) HandleChat(c *gin.Context) {
+	var payload nlpRequestPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	payload.AcceptLanguage = c.GetHeader("Accept-Language")
+
+	plan := h.planChat(c.Request.Context(), currentUserID(c), payload, false)
+	if len(plan.Errors) > 0 {
+		issue := plan.Errors[0]
+		writeError(c, apierr.New(issue.Code, issue.Message).WithDetail(issue.Detail).With("field", issue.Field))
+		return
 	}
+	req := plan.Request
 
 	token := h.resolveToken(c, payload.Token)
-	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "qiniu token is required"})
+	if token == "" && h.nlp.RequiresToken(payload.Provider) {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
 		return
 	}
 
 	result, err := h.nlp.GenerateReply(c.Request.Context(), token, req)
 	if err != nil {
-		h.logger.Warnf("nlp chat failed: %v", err)
-		c.JSON(statusFromError(err), gin.H{"error": "chat completion failed", "detail": err.Error()})
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("nlp chat failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "chat completion failed"))
 		return
 	}
+	h.stats.RecordUsage(c.Request.Context(), payload.RoleID)
+	h.usage.Record(chatUsage(currentUserID(c), payload.RoleID, result.Usage))
+	h.memories.Remember(memory.Exchange{UserID: currentUserID(c), RoleID: payload.RoleID, Token: token, UserMessage: req.UserMessage, Reply: result.Reply.Content})
 
 	response := gin.H{
-		"message":           result.Reply,
-		"reply":             result.Reply,
-		"usage":             result.Usage,
-		"raw":               result.Raw,
-		"prompt_messages":   result.PromptMessages,
-		"system_prompt":     result.SystemPrompt,
-		"history_summary":   result.HistorySummary,
-		"enabled_skill_ids": result.EnabledSkillIDs,
+		"message":             result.Reply,
+		"reply":               result.Reply,
+		"usage":               result.Usage,
+		"raw":                 result.Raw,
+		"prompt_messages":     result.PromptMessages,
+		"system_prompt":       result.SystemPrompt,
+		"history_summary":     result.HistorySummary,
+		"enabled_skill_ids":   result.EnabledSkillIDs,
+		"memories":            result.Memories,
+		"sampling":            result.Sampling,
+		"structured":          result.Structured != nil,
+		"provider":            result.Provider,
+		"sentiment":           req.Sentiment,
+		"auto_enabled_skills": autoEnabledSkills(plan.Prompt),
+		"prompt_guard":        promptGuard(plan.Prompt),
+		"language":            req.Language,
+		"language_source":     plan.LanguageSource,
+	}
+	if result.Structured != nil {
+		response["structured_reply"] = result.Structured
+	}
+	if payload.IncludeSuggestions {
+		response["suggestions"] = h.suggest(c.Request.Context(), token, req, result.Reply.Content, result.Structured)
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// suggest returns the follow-up questions for reply, never nil. The followups of a
+// structured reply are used when there are any, saving the extra call.
+func (h *NLPHandler) suggest(ctx context.Context, token string, req services.NLPRequest, reply string, structured *services.StructuredReply) []string {
+	if structured != nil && len(structured.Followups) > 0 {
+		return structured.Followups
+	}
+	suggestions := h.suggestions.Suggest(ctx, token, req.Language, req.UserMessage, reply)
+	if suggestions == nil {
+		return []string{}
+	}
+	return suggestions
+}
+
+type suggestionsPayload struct {
+	Token    string              `json:"token"`
+	Language string              `json:"language"`
+	Messages []nlpMessagePayload `json:"messages"`
+}
+
+// HandleSuggestions serves POST /api/nlp/suggestions, proposing follow-up questions for
+// the last exchange of messages: its last assistant message and the user message before
+// it. Suggestions are best effort, so a failed call responds with an empty list.
+func (h *NLPHandler) HandleSuggestions(c *gin.Context) {
+	var payload suggestionsPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	messages := normalizeNLPMessages(payload.Messages)
+	last := len(messages) - 1
+	for last >= 0 && !strings.EqualFold(messages[last].Role, "assistant") {
+		last--
+	}
+	if last < 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "an assistant message is required").With("field", "messages"))
+		return
+	}
+	var userMessage string
+	for i := last - 1; i >= 0; i-- {
+		if strings.EqualFold(messages[i].Role, "user") {
+			userMessage = messages[i].Content
+			break
+		}
+	}
+
+	token := h.resolveToken(c, payload.Token)
+	if token == "" && h.nlp.RequiresToken("") {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+	req := services.NLPRequest{Language: payload.Language, UserMessage: userMessage}
+	c.JSON(http.StatusOK, gin.H{"suggestions": h.suggest(c.Request.Context(), token, req, messages[last].Content, nil)})
+}
+
+// HandleValidate dry-runs a chat payload: it applies every HandleChat rule and composes the
+// prompt, reporting all errors, warnings and the estimated prompt size without calling the provider.
+func (h *NLPHandler) HandleValidate(c *gin.Context) {
+	var payload nlpRequestPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	payload.AcceptLanguage = c.GetHeader("Accept-Language")
+
+	plan := h.planChat(c.Request.Context(), currentUserID(c), payload, true)
+	report := gin.H{
+		"valid":             len(plan.Errors) == 0,
+		"errors":            plan.Errors,
+		"warnings":          plan.Warnings,
+		"max_prompt_tokens": h.nlp.MaxPromptTokens(),
+	}
+	if plan.Errors == nil {
+		report["errors"] = []chatIssue{}
+	}
+	if plan.Warnings == nil {
+		report["warnings"] = []string{}
+	}
+	if plan.Prompt != nil {
+		report["estimated_prompt_tokens"] = plan.Prompt.EstimatedTokens
+		report["system_prompt"] = plan.Prompt.SystemPrompt
+		report["global_prompt"] = gin.H{"prefix": plan.Prompt.GlobalPrefix, "suffix": plan.Prompt.GlobalSuffix}
+		report["enabled_skill_ids"] = plan.Prompt.EnabledSkillIDs
+		report["sampling"] = plan.Prompt.Sampling
+		report["auto_enabled_skills"] = autoEnabledSkills(plan.Prompt)
+		report["prompt_guard"] = promptGuard(plan.Prompt)
+		report["sentiment"] = plan.Request.Sentiment
+		report["language"] = plan.Request.Language
+		report["language_source"] = plan.LanguageSource
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// autoEnabledSkills lists the skills the sentiment of the conversation turned on, never nil.
+func autoEnabledSkills(prompt *services.NLPPrompt) []string {
+	if prompt == nil || prompt.AutoEnabledSkillIDs == nil {
+		return []string{}
+	}
+	return prompt.AutoEnabledSkillIDs
+}
+
+// promptGuard reports what the prompt guard found in the user message, with flags never nil.
+func promptGuard(prompt *services.NLPPrompt) services.PromptGuardReport {
+	if prompt == nil {
+		return services.PromptGuardReport{Flags: []string{}}
+	}
+	report := prompt.Guard
+	if report.Flags == nil {
+		report.Flags = []string{}
+	}
+	return report
+}
+
 func normalizeNLPMessages(payload []nlpMessagePayload) []services.NLPMessage {
 	result := make([]services.NLPMessage, 0, len(payload))
 	for _, msg := range payload {
@@ -140,13 +387,5 @@ func normalizeNLPMessages(payload []nlpMessagePayload) []services.NLPMessage {
 }
 
 func (h *NLPHandler) resolveToken(c *gin.Context, explicit string) string {
-	if token := strings.TrimSpace(explicit); token != "" {
-		return token
-	}
-
-	if header := parseAuthorizationToken(c.GetHeader("Authorization")); header != "" {
-		return header
-	}
-
-	return strings.TrimSpace(h.cfg.QiniuAPIKey)
+	return resolveQiniuToken(c, h.tokens, h.logger, explicit)
 }
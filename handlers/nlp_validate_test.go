@@ -0,0 +1,95 @@
+package handlers_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/testsupport"
+)
+
+// TestValidateMatchesChat sends each payload to /api/nlp/validate and /api/nlp/chat:
+// validate must accept exactly what chat accepts, report the error chat rejects with, and
+// compose the same prompt chat sends.
+func TestValidateMatchesChat(t *testing.T) {
+	private := models.Role{ID: 2, Name: "Hidden Sage", Bio: "Someone else's sage.", Languages: []string{"en"}, OwnerID: "another-user"}
+	h, err := testsupport.NewHarness(func(cfg *config.Config) { cfg.NLPMaxPromptTokens = 400 }, testsupport.ScenarioRole, private)
+	if err != nil {
+		t.Fatalf("NewHarness: %v", err)
+	}
+	defer h.Close()
+
+	user := func(content string) map[string]string { return map[string]string{"role": "user", "content": content} }
+	cases := []struct {
+		name    string
+		payload map[string]any
+	}{
+		{"valid", map[string]any{"role_id": 1, "messages": []map[string]string{user("Hello there.")}}},
+		{"valid with sampling", map[string]any{"role_id": 1, "temperature": 0.4, "top_p": 0.9, "max_tokens": 64, "messages": []map[string]string{user("Hi.")}}},
+		{"explicit zero sampling", map[string]any{"role_id": 1, "temperature": 0, "top_p": 0, "max_tokens": 0, "messages": []map[string]string{user("Hi.")}}},
+		{"missing role", map[string]any{"messages": []map[string]string{user("Hello?")}}},
+		{"unknown role", map[string]any{"role_id": 999, "messages": []map[string]string{user("Hello?")}}},
+		{"private role of another user", map[string]any{"role_id": 2, "messages": []map[string]string{user("Hello?")}}},
+		{"no messages", map[string]any{"role_id": 1}},
+		{"last message not from user", map[string]any{"role_id": 1, "messages": []map[string]string{user("Hi."), {"role": "assistant", "content": "Hello."}}}},
+		{"temperature out of range", map[string]any{"role_id": 1, "temperature": 3, "messages": []map[string]string{user("Hi.")}}},
+		{"unknown provider", map[string]any{"role_id": 1, "provider": "nope", "messages": []map[string]string{user("Hi.")}}},
+		{"prompt over budget", map[string]any{"role_id": 1, "messages": []map[string]string{user(strings.Repeat("word ", 2000))}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			validated, err := h.Do(http.MethodPost, "/api/nlp/validate", tc.payload, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var report struct {
+				Valid  bool `json:"valid"`
+				Errors []struct {
+					Code string `json:"code"`
+				} `json:"errors"`
+				SystemPrompt string         `json:"system_prompt"`
+				Sampling     map[string]any `json:"sampling"`
+				Language     string         `json:"language"`
+			}
+			if err := validated.JSON(&report); err != nil {
+				t.Fatal(err)
+			}
+
+			chat, err := h.Do(http.MethodPost, "/api/nlp/chat", tc.payload, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Valid != (chat.Status == http.StatusOK) {
+				t.Fatalf("validate valid=%t but chat answered %d: %s", report.Valid, chat.Status, chat.Body)
+			}
+			if !report.Valid {
+				if len(report.Errors) == 0 {
+					t.Fatal("invalid report lists no errors")
+				}
+				if got := chat.ErrorCode(); got != report.Errors[0].Code {
+					t.Errorf("chat rejected with %s, validate reported %s first", got, report.Errors[0].Code)
+				}
+				return
+			}
+
+			var reply struct {
+				SystemPrompt string         `json:"system_prompt"`
+				Sampling     map[string]any `json:"sampling"`
+				Language     string         `json:"language"`
+			}
+			if err := chat.JSON(&reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.SystemPrompt != report.SystemPrompt {
+				t.Errorf("chat system prompt differs from the validated one:\nchat:     %q\nvalidate: %q", reply.SystemPrompt, report.SystemPrompt)
+			}
+			if !reflect.DeepEqual(reply.Sampling, report.Sampling) || reply.Language != report.Language {
+				t.Errorf("chat sampling %v in %q, validate %v in %q", reply.Sampling, reply.Language, report.Sampling, report.Language)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"go.uber.org/zap"
+)
+
+const (
+	userDataDeleteAttempts = 3
+	userDataDeleteBackoff  = 200 * time.Millisecond
+)
+
+// UserDataStore is a store holding data of users that they may erase.
+type UserDataStore interface {
+	// DeleteByUser removes all data of userID and returns how much was removed, including
+	// what was removed before an error. It must be safe to call again after an error.
+	DeleteByUser(ctx context.Context, userID string) (int64, error)
+}
+
+// NamedUserDataStore is a UserDataStore with the name its count is reported under.
+type NamedUserDataStore struct {
+	Name  string
+	Store UserDataStore
+}
+
+// DataDeletionAuditor records erasures; *db.DataDeletionLog implements it.
+type DataDeletionAuditor interface {
+	Record(ctx context.Context, entry db.DataDeletion) error
+}
+
+// PrivacyHandler lets users erase the data kept about them.
+type PrivacyHandler struct {
+	stores []NamedUserDataStore
+	audit  DataDeletionAuditor
+	logger *zap.SugaredLogger
+}
+
+// NewPrivacyHandler builds a PrivacyHandler erasing from stores, in order, and recording
+// each erasure with audit.
+func NewPrivacyHandler(stores []NamedUserDataStore, audit DataDeletionAuditor, logger *zap.SugaredLogger) *PrivacyHandler {
+	return &PrivacyHandler{stores: stores, audit: audit, logger: logger}
+}
+
+// DeleteMyData handles DELETE /api/me/data, removing the caller's data from every store
+// and responding with the count removed from each. Each store is retried a few times; when
+// one still fails the others are still erased and the response is a 500 listing the
+// counts and the failed stores. Every request is recorded in the audit log.
+func (h *PrivacyHandler) DeleteMyData(c *gin.Context) {
+	ctx := c.Request.Context()
+	logger := ctxlog.From(ctx, h.logger)
+	entry := db.DataDeletion{
+		UserID:      MustUserID(c),
+		Deleted:     make(map[string]int64, len(h.stores)),
+		Failed:      map[string]string{},
+		RequestedAt: time.Now().UTC(),
+	}
+
+	for _, named := range h.stores {
+		deleted, err := deleteWithRetries(ctx, named.Store, entry.UserID)
+		entry.Deleted[named.Name] = deleted
+		if err != nil {
+			logger.Warnf("delete %s of %s failed: %v", named.Name, entry.UserID, err)
+			entry.Failed[named.Name] = err.Error()
+		}
+	}
+
+	// the erasure happened whatever the client does next, so its record must not depend on
+	// the request staying open
+	if err := h.audit.Record(context.WithoutCancel(ctx), entry); err != nil {
+		logger.Warnf("record data deletion of %s failed: %v", entry.UserID, err)
+	}
+	logger.Infow("user data deleted", "user_id", entry.UserID, "deleted", entry.Deleted, "failed", len(entry.Failed))
+
+	if len(entry.Failed) > 0 {
+		failed := make([]string, 0, len(entry.Failed))
+		for _, named := range h.stores {
+			if _, ok := entry.Failed[named.Name]; ok {
+				failed = append(failed, named.Name)
+			}
+		}
+		writeError(c, apierr.New(apierr.CodeInternal, "some data could not be deleted, retry later").
+			With("deleted", entry.Deleted).With("failed", failed))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": entry.Deleted})
+}
+
+// deleteWithRetries calls store until it succeeds or runs out of attempts, adding up what
+// each attempt removed.
+func deleteWithRetries(ctx context.Context, store UserDataStore, userID string) (int64, error) {
+	var total int64
+	var err error
+	for attempt := 1; attempt <= userDataDeleteAttempts; attempt++ {
+		var deleted int64
+		deleted, err = store.DeleteByUser(ctx, userID)
+		total += deleted
+		if err == nil || attempt == userDataDeleteAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return total, err
+		case <-time.After(time.Duration(attempt) * userDataDeleteBackoff):
+		}
+	}
+	return total, err
+}
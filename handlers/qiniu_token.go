@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
+	"go.uber.org/zap"
+)
+
+// qiniuTokenSourceKey holds the tokenresolver source of the token resolveQiniuToken
+// returned for the request.
+const qiniuTokenSourceKey = "qiniu_token_source"
+
+// resolveQiniuToken returns the Qiniu token of a request under QINIU_TOKEN_MODE. Among
+// client tokens, explicit (a body or query field) wins over the Authorization header. The
+// mode and the token's source are logged, never the token. Requests authenticated by a
+// WebSocket ticket always use the server key, which the ticket stands in for.
+func resolveQiniuToken(c *gin.Context, tokens *tokenresolver.Resolver, logger *zap.SugaredLogger, explicit string) string {
+	if c.GetBool(ticketContextKey) {
+		token, source := tokens.Server()
+		c.Set(qiniuTokenSourceKey, source)
+		ctxlog.From(c.Request.Context(), logger).Debugf("qiniu token mode %s, served by %s for a ticket", tokens.Mode(), source)
+		return token
+	}
+	token, source := tokens.Resolve(explicit, parseAuthorizationToken(c.GetHeader("Authorization")))
+	c.Set(qiniuTokenSourceKey, source)
+	ctxlog.From(c.Request.Context(), logger).Debugf("qiniu token mode %s, served by %s", tokens.Mode(), source)
+	return token
+}
+
+// rateLimitKey identifies the caller of a request served with token, which
+// resolveQiniuToken returned: by the signed-in user, else by the token when it is the
+// client's own, else by address. The server key is shared by every anonymous caller, so
+// keying by it would put them all in one bucket.
+func rateLimitKey(c *gin.Context, token string) string {
+	if userID := currentUserID(c); userID != "" {
+		return "user:" + userID
+	}
+	if token != "" && c.GetString(qiniuTokenSourceKey) == tokenresolver.SourceClient {
+		return "token:" + token
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// allowRateLimited takes one token from the caller's bucket for scope, keyed by
+// rateLimitKey. When the bucket is empty it writes the 429 response with Retry-After itself
+// and reports false. A nil limiter allows everything.
+func allowRateLimited(c *gin.Context, limiter *ratelimit.Limiter, scope, token string) bool {
+	decision := limiter.Allow(c.Request.Context(), scope, rateLimitKey(c, token))
+	if decision.Allowed {
+		return true
+	}
+
+	retryAfter := int((decision.RetryAfter + time.Second - 1) / time.Second)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	writeError(c, apierr.New(apierr.CodeRateLimited, "rate limit exceeded").With("retry_after", retryAfter))
+	return false
+}
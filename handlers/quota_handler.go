@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/quota"
+	"go.uber.org/zap"
+)
+
+// RequireQuota rejects signed-in callers who used up their monthly quota of any of metrics
+// with 402 QUOTA_EXCEEDED, reporting the quota, the amount used and when it resets.
+// Anonymous callers are not limited.
+func RequireQuota(quotas *quota.Service, metrics ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := quotas.Check(c.Request.Context(), currentUserID(c), metrics...); err != nil {
+			abortError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// QuotaHandler lets operators inspect and override user quotas.
+type QuotaHandler struct {
+	quotas *quota.Service
+	logger *zap.SugaredLogger
+}
+
+// NewQuotaHandler builds a new QuotaHandler.
+func NewQuotaHandler(quotas *quota.Service, logger *zap.SugaredLogger) *QuotaHandler {
+	return &QuotaHandler{quotas: quotas, logger: logger}
+}
+
+type quotaOverrideRequest struct {
+	TokensPerMonth        *int64 `json:"tokens_per_month"`
+	TTSCharactersPerMonth *int64 `json:"tts_characters_per_month"`
+	ASRMinutesPerMonth    *int64 `json:"asr_minutes_per_month"`
+}
+
+// GetQuota handles GET /api/admin/quotas/:user_id with the user's limits, override and
+// usage this month.
+func (h *QuotaHandler) GetQuota(c *gin.Context) {
+	userID := strings.TrimSpace(c.Param("user_id"))
+	status, err := h.quotas.Status(c.Request.Context(), userID)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("load quota of %s failed: %v", userID, err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to load quota"))
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// SetQuota handles PUT /api/admin/quotas/:user_id. Limits that are omitted or null keep
+// the default and 0 lifts the limit.
+func (h *QuotaHandler) SetQuota(c *gin.Context) {
+	userID := strings.TrimSpace(c.Param("user_id"))
+	var req quotaOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	for _, limit := range []struct {
+		field string
+		value *int64
+	}{
+		{"tokens_per_month", req.TokensPerMonth},
+		{"tts_characters_per_month", req.TTSCharactersPerMonth},
+		{"asr_minutes_per_month", req.ASRMinutesPerMonth},
+	} {
+		if limit.value != nil && *limit.value < 0 {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, limit.field+" must not be negative").With("field", limit.field))
+			return
+		}
+	}
+
+	override, err := h.quotas.SetOverride(c.Request.Context(), userID, quota.Override{
+		TokensPerMonth:        req.TokensPerMonth,
+		TTSCharactersPerMonth: req.TTSCharactersPerMonth,
+		ASRMinutesPerMonth:    req.ASRMinutesPerMonth,
+	}, adminActor(c))
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("set quota of %s failed: %v", userID, err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to store quota"))
+		return
+	}
+	ctxlog.From(c.Request.Context(), h.logger).Infow("quota override set", "user_id", userID, "actor", override.UpdatedBy)
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "override": override})
+}
+
+// ClearQuota handles DELETE /api/admin/quotas/:user_id, returning the user to the default
+// limits.
+func (h *QuotaHandler) ClearQuota(c *gin.Context) {
+	userID := strings.TrimSpace(c.Param("user_id"))
+	if err := h.quotas.ClearOverride(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(c, apierr.New(apierr.CodeUserNotFound, "user has no quota override"))
+			return
+		}
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("clear quota of %s failed: %v", userID, err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to clear quota"))
+		return
+	}
+	ctxlog.From(c.Request.Context(), h.logger).Infow("quota override cleared", "user_id", userID, "actor", adminActor(c))
+	c.Status(http.StatusNoContent)
+}
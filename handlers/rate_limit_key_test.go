@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	cases := []struct {
+		name     string
+		mode     string
+		userID   string
+		ticket   bool
+		explicit string
+		header   string
+		want     string
+	}{
+		{"signed-in user", tokenresolver.ModeEither, "42", false, "client-token", "", "user:42"},
+		{"client token in body", tokenresolver.ModeEither, "", false, "client-token", "", "token:client-token"},
+		{"client token in header", tokenresolver.ModeEither, "", false, "", "Bearer client-token", "token:client-token"},
+		{"server key", tokenresolver.ModeEither, "", false, "", "", "ip:203.0.113.7"},
+		{"client token ignored in server mode", tokenresolver.ModeServer, "", false, "client-token", "", "ip:203.0.113.7"},
+		{"ticket uses the server key", tokenresolver.ModeEither, "", true, "client-token", "", "ip:203.0.113.7"},
+	}
+	logger := zap.NewNop().Sugar()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/audio/tts", nil)
+			c.Request.RemoteAddr = "203.0.113.7:5000"
+			if tc.header != "" {
+				c.Request.Header.Set("Authorization", tc.header)
+			}
+			if tc.userID != "" {
+				c.Set(userIDContextKey, tc.userID)
+			}
+			if tc.ticket {
+				c.Set(ticketContextKey, true)
+			}
+			token := resolveQiniuToken(c, tokenresolver.New(tc.mode, "server-key"), logger, tc.explicit)
+			if got := rateLimitKey(c, token); got != tc.want {
+				t.Errorf("rateLimitKey = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestAnonymousCallersOnServerKeyHaveOwnBuckets checks that anonymous callers served with the
+// shared server key are limited per address rather than all together.
+func TestAnonymousCallersOnServerKeyHaveOwnBuckets(t *testing.T) {
+	h := &AudioHandler{
+		limiter: ratelimit.New(nil, 1, 1, zap.NewNop().Sugar()),
+		tokens:  tokenresolver.New(tokenresolver.ModeServer, "server-key"),
+		logger:  zap.NewNop().Sugar(),
+	}
+	allow := func(addr string) int {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/audio/tts", nil)
+		c.Request.RemoteAddr = addr
+		if !h.allowRequest(c, "tts", h.resolveToken(c, "")) {
+			return rec.Code
+		}
+		return http.StatusOK
+	}
+
+	if got := allow("198.51.100.1:1000"); got != http.StatusOK {
+		t.Fatalf("first caller = %d, want 200", got)
+	}
+	if got := allow("198.51.100.2:1000"); got != http.StatusOK {
+		t.Errorf("second caller = %d, want 200: anonymous callers share the server key's bucket", got)
+	}
+	if got := allow("198.51.100.1:2000"); got != http.StatusTooManyRequests {
+		t.Errorf("first caller again = %d, want 429", got)
+	}
+}
+
+// TestVoiceChatIsRateLimited checks a voice turn is refused once the caller's asr or tts
+// bucket, shared with the audio endpoints, is empty, before any upstream is called.
+func TestVoiceChatIsRateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const caller = "198.51.100.9"
+	for _, scope := range []string{"asr", "tts"} {
+		t.Run(scope, func(t *testing.T) {
+			limiter := ratelimit.New(nil, 1, 1, zap.NewNop().Sugar())
+			// a separate /api/audio call already spent the bucket
+			if !limiter.Allow(context.Background(), scope, "ip:"+caller).Allowed {
+				t.Fatal("fresh bucket refused")
+			}
+			cfg := &config.Config{QiniuTokenMode: tokenresolver.ModeServer, QiniuAPIKey: "server-key"}
+			roles := db.NewMemoryRoleRepository(models.Role{ID: 1, Name: "Socrates", Languages: []string{"en"}})
+			// the pipeline is nil: reaching it would panic
+			h := NewVoiceHandler(cfg, roles, nil, nil, limiter, nil, zap.NewNop().Sugar())
+
+			router := gin.New()
+			router.POST("/api/voice/chat", h.HandleVoiceChat)
+			req := httptest.NewRequest(http.MethodPost, "/api/voice/chat", strings.NewReader(`{"role_id":1,"audio_url":"https://example.com/a.wav"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.RemoteAddr = caller + ":4000"
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusTooManyRequests || responseCode(t, rec) != "RATE_LIMITED" {
+				t.Fatalf("got %d %s, want 429 RATE_LIMITED: %s", rec.Code, responseCode(t, rec), rec.Body)
+			}
+			if rec.Header().Get("Retry-After") == "" {
+				t.Error("no Retry-After on a 429")
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"go.uber.org/zap"
+)
+
+// Recovery turns a handler panic into a 500 with the error envelope
+// {"code": "INTERNAL_ERROR", "error": "internal_error", "request_id": ...} and logs the
+// panic value and stack with the request ID. onPanic, when set, is called with the route
+// template, typically to count panics. Register it after RequestID, AccessLog and the
+// metrics middleware so those still see the request complete with a 500.
+func Recovery(logger *zap.SugaredLogger, onPanic func(route string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// net/http's sentinel for aborting a response; let it do its job
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			log := ctxlog.From(c.Request.Context(), logger)
+			if err, ok := recovered.(error); ok && brokenConnection(err) {
+				// the client went away mid-response; there is nobody to answer
+				log.Warnw("client connection lost", "path", c.Request.URL.Path, "error", err)
+				c.Abort()
+				return
+			}
+
+			route := c.FullPath()
+			log.Errorw("panic recovered",
+				"panic", recovered,
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"route", route,
+				"stack", string(debug.Stack()),
+			)
+			if onPanic != nil {
+				onPanic(route)
+			}
+
+			if c.Writer.Written() {
+				// headers are gone (or the connection was hijacked); only stop the chain
+				c.Abort()
+				return
+			}
+			abortError(c, apierr.New(apierr.CodeInternal, "internal_error"))
+		}()
+		c.Next()
+	}
+}
+
+func brokenConnection(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
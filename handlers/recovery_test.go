@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var mu sync.Mutex
+	var panicked []string
+	router := gin.New()
+	router.Use(RequestID(), Recovery(zap.NewNop().Sugar(), func(route string) {
+		mu.Lock()
+		panicked = append(panicked, route)
+		mu.Unlock()
+	}))
+	router.GET("/boom/:id", func(*gin.Context) { panic("boom") })
+	router.GET("/error", func(*gin.Context) { panic(errors.New("nil map")) })
+	router.GET("/partial", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("after write")
+	})
+	router.GET("/gone", func(*gin.Context) { panic(syscall.EPIPE) })
+	router.GET("/ok", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	get := func(path string) (int, []byte) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	for _, path := range []string{"/boom/1", "/error"} {
+		status, body := get(path)
+		var envelope map[string]any
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			t.Fatalf("GET %s body is not JSON: %q", path, body)
+		}
+		if status != http.StatusInternalServerError || envelope["code"] != "INTERNAL_ERROR" {
+			t.Errorf("GET %s = %d %v, want 500 INTERNAL_ERROR", path, status, envelope)
+		}
+		if envelope["request_id"] == "" || envelope["request_id"] == nil {
+			t.Errorf("GET %s envelope has no request_id: %v", path, envelope)
+		}
+	}
+
+	// a panic after the response started keeps what was sent
+	if status, body := get("/partial"); status != http.StatusOK || string(body) != "partial" {
+		t.Errorf("GET /partial = %d %q, want the written 200 body", status, body)
+	}
+
+	// the server keeps serving after the panics
+	if status, body := get("/ok"); status != http.StatusOK || string(body) != "ok" {
+		t.Errorf("GET /ok after panics = %d %q", status, body)
+	}
+
+	// a lost connection is not a server bug and is not counted
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gone", nil))
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"/boom/:id", "/error", "/partial"}
+	if len(panicked) != len(want) {
+		t.Fatalf("onPanic saw %v, want %v", panicked, want)
+	}
+	for i := range want {
+		if panicked[i] != want[i] {
+			t.Errorf("onPanic saw %v, want %v", panicked, want)
+			break
+		}
+	}
+}
+
+func TestRecoveryRepanicsAbortHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Recovery(zap.NewNop().Sugar(), nil))
+	router.GET("/", func(*gin.Context) { panic(http.ErrAbortHandler) })
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", recovered)
+		}
+	}()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
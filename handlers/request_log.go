@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"go.uber.org/zap"
+)
+
+const (
+	requestIDContextKey = "request_id"
+	maxRequestIDLength  = 128
+)
+
+// RequestID reuses the caller's X-Request-ID when it is sane, or generates one, and makes
+// it available to handlers (c.GetString("request_id")), to services through the request
+// context (ctxlog.RequestID) and to the client in the response header.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(ctxlog.Header)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDContextKey, id)
+		c.Request = c.Request.WithContext(ctxlog.WithRequestID(c.Request.Context(), id))
+		c.Header(ctxlog.Header, id)
+		c.Next()
+	}
+}
+
+// AccessLog writes one structured line per request, replacing gin's text logger. Server
+// errors are logged at error level, everything else at info.
+func AccessLog(logger *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		c.Next()
+
+		status := c.Writer.Status()
+		fields := []interface{}{
+			"method", c.Request.Method,
+			"path", path,
+			"route", c.FullPath(),
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", max(c.Writer.Size(), 0),
+			"client_ip", c.ClientIP(),
+			"request_id", c.GetString(requestIDContextKey),
+		}
+		if userID := currentUserID(c); userID != "" {
+			fields = append(fields, "user_id", userID)
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, "errors", c.Errors.String())
+		}
+
+		if status >= http.StatusInternalServerError {
+			logger.Errorw("request", fields...)
+			return
+		}
+		logger.Infow("request", fields...)
+	}
+}
+
+// validRequestID accepts short IDs of visible ASCII so a client cannot inject line breaks
+// or huge values into logs and upstream headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "req-" + time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(buf)
+}
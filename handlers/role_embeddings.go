@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/jobs"
+)
+
+// RoleEmbeddingJobType is the job type that re-embeds a role after it changed.
+const RoleEmbeddingJobType = "role_embedding"
+
+type roleEmbeddingPayload struct {
+	RoleID int64 `json:"role_id"`
+}
+
+// RoleEmbeddingUpdater stores a fresh embedding of a role; db.PgRoleRepository implements it.
+type RoleEmbeddingUpdater interface {
+	UpdateEmbedding(ctx context.Context, id int64) error
+}
+
+// RoleEmbeddingJob returns the handler of RoleEmbeddingJobType jobs. Roles deleted before
+// the job ran are dropped without retries.
+func RoleEmbeddingJob(updater RoleEmbeddingUpdater) jobs.Handler {
+	return func(ctx context.Context, queued jobs.Job) error {
+		var payload roleEmbeddingPayload
+		if err := queued.Decode(&payload); err != nil {
+			return jobs.Permanent(err)
+		}
+		if err := updater.UpdateEmbedding(ctx, payload.RoleID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return jobs.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// refreshEmbeddings queues the re-embedding of roles after a write. The write already
+// succeeded, so failing to queue is only logged; the backfill script catches up later.
+func (h *RoleHandler) refreshEmbeddings(c *gin.Context, ids ...int64) {
+	if h.embeddings == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	for _, id := range ids {
+		if id <= 0 {
+			continue
+		}
+		if _, err := h.embeddings.Enqueue(ctx, RoleEmbeddingJobType, roleEmbeddingPayload{RoleID: id}); err != nil {
+			ctxlog.From(ctx, h.logger).Warnf("queue role %d embedding: %v", id, err)
+		}
+	}
+}
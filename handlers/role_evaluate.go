@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/locale"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+// maxPersonaProbes caps the probes an evaluation request may supply.
+const maxPersonaProbes = 12
+
+type evaluateRolePayload struct {
+	// Language is the reply language; empty picks the role's first language.
+	Language string `json:"language"`
+	// Probes replace the default battery when set.
+	Probes []services.PersonaProbe `json:"probes"`
+}
+
+// EvaluatePersonas makes EvaluateRole run its probes with evaluator.
+func (h *RoleHandler) EvaluatePersonas(evaluator *services.PersonaEvaluator) {
+	h.evaluator = evaluator
+}
+
+// EvaluateRole handles POST /api/admin/roles/:id/evaluate. It sends a battery of probe
+// messages to the role through the chat pipeline and has the chat model score every reply
+// for persona consistency, constraint adherence and language correctness, returning the
+// transcripts with per-probe and average scores. The optional body sets the language and
+// replaces the default probes; ?dry_run=1 only returns the probes that would be sent.
+// Private roles are reported as missing unless the caller is an admin or their owner.
+func (h *RoleHandler) EvaluateRole(c *gin.Context) {
+	id, err := strconv.ParseInt(strings.TrimSpace(c.Param("id")), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid role id"))
+		return
+	}
+	var payload evaluateRolePayload
+	if err := c.ShouldBindJSON(&payload); err != nil && !errors.Is(err, io.EOF) {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	token := strings.TrimSpace(h.cfg.QiniuAPIKey)
+	if !dryRun && token == "" {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "QINIU_API_KEY is required to evaluate roles"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	role, err := h.roles.GetByID(ctx, id)
+	if err == nil && !isAdmin(c) && !role.VisibleTo(currentUserID(c)) {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		h.writeRoleError(c, err)
+		return
+	}
+	language, _ := locale.Negotiate(locale.Inputs{
+		Request: strings.TrimSpace(payload.Language),
+		Role:    role.Languages,
+		Default: services.DefaultLanguage,
+	})
+	probes := services.DefaultPersonaProbes(language)
+	if len(payload.Probes) > 0 {
+		if probes, err = services.ValidatePersonaProbes(payload.Probes, maxPersonaProbes); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{"role_id": role.ID, "language": language, "probes": probes, "dry_run": true})
+		return
+	}
+	c.JSON(http.StatusOK, h.evaluator.Evaluate(ctx, token, *role, language, probes))
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+// stubPersonaChat replies in character to every probe and grades every reply the same.
+type stubPersonaChat struct {
+	replies   atomic.Int32
+	judgement atomic.Int32
+}
+
+func (s *stubPersonaChat) GenerateReply(_ context.Context, _ string, req services.NLPRequest) (*services.NLPResponse, error) {
+	s.replies.Add(1)
+	return &services.NLPResponse{Reply: services.NLPMessage{Role: "assistant", Content: req.Role.Name + " says hello."}}, nil
+}
+
+func (s *stubPersonaChat) Complete(context.Context, string, []services.NLPMessage) (string, error) {
+	s.judgement.Add(1)
+	return `{"persona_consistency": 4, "constraint_adherence": 5, "language_correctness": 3, "comment": "ok"}`, nil
+}
+
+func TestEvaluateRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := db.NewMemoryRoleRepository(
+		models.Role{ID: 1, Name: "Socrates", Domain: "philosophy", Languages: []string{"en"}},
+		models.Role{ID: 2, Name: "Diary", Domain: "private", Languages: []string{"zh"}, OwnerID: "owner"},
+	)
+
+	cases := []struct {
+		name       string
+		path       string
+		body       string
+		admin      bool
+		userID     string
+		noKey      bool
+		wantStatus int
+		wantCode   string
+		wantCalls  bool
+	}{
+		{name: "admin scores a public role", path: "/api/admin/roles/1/evaluate", admin: true, wantStatus: http.StatusOK, wantCalls: true},
+		{name: "admin scores a private role", path: "/api/admin/roles/2/evaluate", admin: true, wantStatus: http.StatusOK, wantCalls: true},
+		{name: "owner scores their private role", path: "/api/admin/roles/2/evaluate", userID: "owner", wantStatus: http.StatusOK, wantCalls: true},
+		{name: "private role hidden from other users", path: "/api/admin/roles/2/evaluate", userID: "stranger", wantStatus: http.StatusNotFound, wantCode: "ROLE_NOT_FOUND"},
+		{name: "private role hidden from anonymous callers", path: "/api/admin/roles/2/evaluate?dry_run=1", wantStatus: http.StatusNotFound, wantCode: "ROLE_NOT_FOUND"},
+		{name: "missing role", path: "/api/admin/roles/99/evaluate", admin: true, wantStatus: http.StatusNotFound, wantCode: "ROLE_NOT_FOUND"},
+		{name: "invalid id", path: "/api/admin/roles/abc/evaluate", admin: true, wantStatus: http.StatusBadRequest, wantCode: "INVALID_REQUEST"},
+		{name: "malformed body", path: "/api/admin/roles/1/evaluate", body: `{"probes":`, admin: true, wantStatus: http.StatusBadRequest, wantCode: "INVALID_REQUEST"},
+		{name: "probe without prompt", path: "/api/admin/roles/1/evaluate", body: `{"probes":[{"id":"x"}]}`, admin: true, wantStatus: http.StatusBadRequest, wantCode: "INVALID_REQUEST"},
+		{name: "no server key", path: "/api/admin/roles/1/evaluate", admin: true, noKey: true, wantStatus: http.StatusBadRequest, wantCode: "TOKEN_MISSING"},
+		{name: "dry run needs no server key", path: "/api/admin/roles/1/evaluate?dry_run=1", admin: true, noKey: true, wantStatus: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{QiniuAPIKey: "server-key"}
+			if tc.noKey {
+				cfg.QiniuAPIKey = ""
+			}
+			chat := &stubPersonaChat{}
+			h := NewRoleHandler(cfg, repo, nil, nil, nil, nil, nil, nil, zap.NewNop().Sugar())
+			h.EvaluatePersonas(services.NewPersonaEvaluator(chat, chat, 2, time.Minute))
+
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				if tc.admin {
+					c.Set(adminActorKey, "test")
+				}
+				if tc.userID != "" {
+					c.Set(userIDContextKey, tc.userID)
+				}
+			})
+			router.POST("/api/admin/roles/:id/evaluate", h.EvaluateRole)
+
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus || responseCode(t, rec) != tc.wantCode {
+				t.Fatalf("got %d %s, want %d %s: %s", rec.Code, responseCode(t, rec), tc.wantStatus, tc.wantCode, rec.Body)
+			}
+			if called := chat.replies.Load() > 0 || chat.judgement.Load() > 0; called != tc.wantCalls {
+				t.Errorf("upstream called %d/%d times, want calls: %t", chat.replies.Load(), chat.judgement.Load(), tc.wantCalls)
+			}
+			if !tc.wantCalls || rec.Code != http.StatusOK {
+				return
+			}
+
+			var evaluation services.PersonaEvaluation
+			if err := json.Unmarshal(rec.Body.Bytes(), &evaluation); err != nil {
+				t.Fatalf("decode evaluation: %v", err)
+			}
+			if evaluation.Scored != 4 || len(evaluation.Probes) != 4 || chat.replies.Load() != 4 || chat.judgement.Load() != 4 {
+				t.Fatalf("scored %d of %d probes with %d replies and %d judgements, want 4 of each", evaluation.Scored, len(evaluation.Probes), chat.replies.Load(), chat.judgement.Load())
+			}
+			want := services.PersonaScores{PersonaConsistency: 4, ConstraintAdherence: 5, LanguageCorrectness: 3}
+			if evaluation.Scores == nil || *evaluation.Scores != want {
+				t.Errorf("averages %v, want %+v", evaluation.Scores, want)
+			}
+			role, _ := repo.GetByID(context.Background(), evaluation.RoleID)
+			if evaluation.Language != role.Languages[0] || !strings.HasPrefix(evaluation.Probes[0].Reply, role.Name) {
+				t.Errorf("evaluated in %q with reply %q, want the role's language and persona", evaluation.Language, evaluation.Probes[0].Reply)
+			}
+		})
+	}
+}
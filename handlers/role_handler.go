@@ -1,109 +1,709 @@
 package handlers
 
 import (
+    "bytes"
+    "context"
+    "io"
+    "encoding/json"
     "errors"
     "fmt"
     "net/http"
+    "strconv"
     "strings"
+    "time"
+    "unicode/utf8"
 
     "github.com/gin-gonic/gin"
-    "github.com/jackc/pgerrcode"
-    "github.com/jackc/pgx/v5/pgconn"
-    "github.com/jackc/pgx/v5/pgxpool"
+    "github.com/jackc/pgx/v5"
+    "github.com/wuwenbin0122/wwb.ai/apierr"
+    "github.com/wuwenbin0122/wwb.ai/config"
+    "github.com/wuwenbin0122/wwb.ai/ctxlog"
+    "github.com/wuwenbin0122/wwb.ai/db"
     "github.com/wuwenbin0122/wwb.ai/db/models"
+    "github.com/wuwenbin0122/wwb.ai/jobs"
+    "github.com/wuwenbin0122/wwb.ai/roles"
+    "github.com/wuwenbin0122/wwb.ai/services"
+    "github.com/wuwenbin0122/wwb.ai/storage"
+    "go.uber.org/zap"
 )
 
 // RoleHandler provides HTTP handlers for role resources.
 type RoleHandler struct {
-	pool *pgxpool.Pool
+	cfg         *config.Config
+	roles       db.RoleRepository
+	cache       *db.RoleListCache
+	blobs       storage.BlobStore
+	stats       *db.RoleStatsStore
+	enricher    *services.SkillEnricher
+	recommender *services.RoleRecommender
+	embeddings  *jobs.Queue
+	logger      *zap.SugaredLogger
+
+	// globalPrompt is re-read by ReloadRoleCaches when set.
+	globalPrompt *services.GlobalPrompt
+	// evaluator runs the persona evaluations of EvaluateRole.
+	evaluator *services.PersonaEvaluator
+}
+
+// NewRoleHandler builds a RoleHandler. cache may be nil to serve every listing from the
+// repository; blobs may be nil to disable avatar uploads; embeddings may be nil when role
+// embeddings are not kept up to date.
+func NewRoleHandler(cfg *config.Config, roles db.RoleRepository, cache *db.RoleListCache, blobs storage.BlobStore, stats *db.RoleStatsStore, enricher *services.SkillEnricher, recommender *services.RoleRecommender, embeddings *jobs.Queue, logger *zap.SugaredLogger) *RoleHandler {
+	return &RoleHandler{cfg: cfg, roles: roles, cache: cache, blobs: blobs, stats: stats, enricher: enricher, recommender: recommender, embeddings: embeddings, logger: logger}
+}
+
+// ManageGlobalPrompt makes ReloadRoleCaches re-read prompt, the deployment-wide text
+// around every system prompt.
+func (h *RoleHandler) ManageGlobalPrompt(prompt *services.GlobalPrompt) {
+	h.globalPrompt = prompt
 }
 
-func NewRoleHandler(pool *pgxpool.Pool) *RoleHandler {
-	return &RoleHandler{pool: pool}
+const (
+	defaultRolePageSize = 50
+	maxRolePageSize     = 200
+
+	defaultFeaturedRoles = 10
+	maxFeaturedRoles     = 50
+
+	defaultRecommendedRoles = 5
+	maxRecommendedRoles     = 20
+	maxInterestRunes        = 500
+)
+
+func parseRoleFilter(c *gin.Context, envelope bool) (db.RoleFilter, error) {
+	filter := db.RoleFilter{
+		Domain: strings.TrimSpace(c.Query("domain")),
+		Query:  strings.TrimSpace(c.Query("q")),
+		Sort:   strings.ToLower(strings.TrimSpace(c.DefaultQuery("sort", "id"))),
+
+		IncludeArchived: c.Query("include_archived") == "1",
+
+		Viewer: currentUserID(c),
+		Mine:   c.Query("mine") == "1",
+	}
+	for _, tag := range parseTagTerms(strings.TrimSpace(c.Query("tags"))) {
+		if tag != "" {
+			filter.Tags = append(filter.Tags, tag)
+		}
+	}
+
+	if !db.IsRoleSortKey(filter.Sort) {
+		return filter, fmt.Errorf("sort must be one of %s", strings.Join(db.RoleSortKeys, ", "))
+	}
+
+	// the bare-array response stays unpaginated unless a limit is asked for
+	if envelope {
+		filter.Limit = defaultRolePageSize
+	}
+	if raw := strings.TrimSpace(c.Query("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("limit must be a positive integer")
+		}
+		filter.Limit = limit
+	}
+	if filter.Limit > maxRolePageSize {
+		filter.Limit = maxRolePageSize
+	}
+	if raw := strings.TrimSpace(c.Query("offset")); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("offset must be a non-negative integer")
+		}
+		filter.Offset = offset
+	}
+	return filter, nil
 }
 
-// GetRoles responds with roles filtered by optional domain or tags query parameters.
+// GetRoles responds with roles filtered by domain, tags and free-text ?q=, sorted by ?sort=
+// and paged by ?limit=/?offset=. With ?envelope=1 the response is {items, total, next_offset}.
+// Signed-in callers also see their private roles; ?mine=1 lists only those.
+// ?search=semantic ranks the roles by the similarity of their embeddings to ?q= instead;
+// such results are a single page ordered by relevance, ignoring ?sort= and ?offset=.
 func (h *RoleHandler) GetRoles(c *gin.Context) {
-	domain := strings.TrimSpace(c.Query("domain"))
-	tagsParam := strings.TrimSpace(c.Query("tags"))
+	envelope := c.Query("envelope") == "1"
+	filter, err := parseRoleFilter(c, envelope)
+	if err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid query"))
+		return
+	}
+	search := strings.ToLower(strings.TrimSpace(c.DefaultQuery("search", "lexical")))
+	if search != "lexical" && search != "semantic" {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "search must be lexical or semantic"))
+		return
+	}
+	semantic := search == "semantic" && filter.Query != ""
+	if semantic {
+		filter.Offset = 0
+	}
+	if filter.Mine && filter.Viewer == "" {
+		writeError(c, apierr.New(apierr.CodeAuthRequired, "authentication required"))
+		return
+	}
 
-	baseQuery := `SELECT id, name, domain, tags, bio, personality, background, languages, skills FROM roles`
-	clauses := make([]string, 0, 2)
-	args := make([]interface{}, 0, 3)
+	ctx := c.Request.Context()
+	cacheKey := fmt.Sprintf("%s|%s|%s|%s|%d|%d|%t|%t|%s|%t|%t", filter.Domain, strings.Join(filter.Tags, ","), filter.Query, filter.Sort, filter.Limit, filter.Offset, filter.IncludeArchived, envelope, filter.Viewer, filter.Mine, semantic)
+	if body, ok := h.cache.Get(ctx, cacheKey); ok {
+		writeTaggedJSON(c, body)
+		return
+	}
 
-	if domain != "" {
-		clauses = append(clauses, fmt.Sprintf("domain ILIKE $%d", len(args)+1))
-		args = append(args, domain)
+	var roles []models.Role
+	if semantic {
+		roles, err = h.roles.SearchSemantic(ctx, filter)
+	} else {
+		roles, err = h.roles.List(ctx, filter)
+	}
+	if err != nil {
+		writeError(c, apierr.New(apierr.CodeInternal, "query roles failed"))
+		return
 	}
 
-	if tagsParam != "" {
-		tagTerms := parseTagTerms(tagsParam)
-		tagClauses := make([]string, 0, len(tagTerms))
+	var payload interface{} = roles
+	if envelope && semantic {
+		payload = gin.H{"items": roles, "total": len(roles), "next_offset": nil}
+	} else if envelope {
+		total, err := h.roles.Count(ctx, filter)
+		if err != nil {
+			writeError(c, apierr.New(apierr.CodeInternal, "count roles failed"))
+			return
+		}
 
-		for _, tag := range tagTerms {
-			if tag == "" {
-				continue
-			}
+		var nextOffset *int
+		if next := filter.Offset + len(roles); len(roles) > 0 && next < total {
+			nextOffset = &next
+		}
+		payload = gin.H{"items": roles, "total": total, "next_offset": nextOffset}
+	}
 
-			tagClauses = append(tagClauses, fmt.Sprintf("tags ILIKE '%%' || $%d || '%%'", len(args)+1))
-			args = append(args, tag)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		writeError(c, apierr.New(apierr.CodeInternal, "encode roles failed"))
+		return
+	}
+	if err := h.cache.Set(ctx, cacheKey, body); err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("cache role list: %v", err)
+	}
+	writeTaggedJSON(c, body)
+}
+
+// invalidateRoleList drops cached listings after a successful write.
+func (h *RoleHandler) invalidateRoleList(c *gin.Context) {
+	if err := h.cache.Invalidate(c.Request.Context()); err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("invalidate role list cache: %v", err)
+	}
+}
+
+// GetRole responds with a single role, including the extended and voice columns when the
+// schema has them. Private roles of other users are reported as missing.
+func (h *RoleHandler) GetRole(c *gin.Context) {
+	id, err := strconv.ParseInt(strings.TrimSpace(c.Param("id")), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid role id"))
+		return
+	}
+
+	role, err := h.roles.GetByID(c.Request.Context(), id)
+	if err == nil && !role.VisibleTo(currentUserID(c)) {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(c, apierr.New(apierr.CodeRoleNotFound, "role not found"))
+			return
 		}
+		writeError(c, apierr.New(apierr.CodeInternal, "query role failed"))
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// authorizeRoleWrite loads the role a write targets and checks the caller may change it:
+// admins may change any role, users only their own private roles. It writes 404 for roles
+// the caller cannot see and 403 for public roles.
+func (h *RoleHandler) authorizeRoleWrite(c *gin.Context, id int64) bool {
+	role, err := h.roles.GetByID(c.Request.Context(), id)
+	if err != nil {
+		h.writeRoleError(c, err)
+		return false
+	}
+	if isAdmin(c) {
+		return true
+	}
+	userID := currentUserID(c)
+	if !role.VisibleTo(userID) {
+		writeError(c, apierr.New(apierr.CodeRoleNotFound, "role not found"))
+		return false
+	}
+	if role.OwnerID != userID {
+		writeError(c, apierr.New(apierr.CodeNotOwner, "only the owner can modify this role"))
+		return false
+	}
+	return true
+}
+
+// bindRole decodes and validates the role body, writing 400 for malformed JSON and
+// 422 with {"errors":[{field, message}]} for invalid fields.
+func bindRole(c *gin.Context) (models.Role, bool) {
+	var input roles.Definition
+	if err := c.ShouldBindJSON(&input); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return models.Role{}, false
+	}
+	role := input.Role()
+	if errs := roles.Validate(role); len(errs) > 0 {
+		writeError(c, apierr.New(apierr.CodeValidationFailed, "invalid role").With("errors", errs))
+		return models.Role{}, false
+	}
+	return role, true
+}
+
+// CreateRole handles POST /api/roles and responds with the stored role. Roles created by
+// admins join the public catalog; roles created by users are private to them.
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	role, ok := bindRole(c)
+	if !ok {
+		return
+	}
+	if !isAdmin(c) {
+		role.OwnerID = currentUserID(c)
+	}
+
+	stored, err := h.roles.Create(c.Request.Context(), role)
+	if err != nil {
+		h.writeRoleError(c, err)
+		return
+	}
+	h.invalidateRoleList(c)
+	h.refreshEmbeddings(c, stored.ID)
+	c.JSON(http.StatusCreated, stored)
+}
+
+// UpdateRole handles PUT /api/roles/:id, replacing the whole record.
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	id, err := strconv.ParseInt(strings.TrimSpace(c.Param("id")), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid role id"))
+		return
+	}
+
+	role, ok := bindRole(c)
+	if !ok {
+		return
+	}
+	role.ID = id
+	if !h.authorizeRoleWrite(c, id) {
+		return
+	}
+
+	stored, err := h.roles.Update(c.Request.Context(), role)
+	if err != nil {
+		h.writeRoleError(c, err)
+		return
+	}
+	h.invalidateRoleList(c)
+	h.refreshEmbeddings(c, stored.ID)
+	c.JSON(http.StatusOK, stored)
+}
 
-		if len(tagClauses) > 0 {
-			clauses = append(clauses, "("+strings.Join(tagClauses, " OR ")+")")
+// DeleteRole handles DELETE /api/roles/:id. Roles are archived so conversations that
+// reference them keep working; ?hard=true removes the row instead.
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	id, err := strconv.ParseInt(strings.TrimSpace(c.Param("id")), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid role id"))
+		return
+	}
+	if !h.authorizeRoleWrite(c, id) {
+		return
+	}
+
+	if hard, _ := strconv.ParseBool(c.Query("hard")); hard {
+		err = h.roles.Delete(c.Request.Context(), id)
+	} else {
+		err = h.roles.Archive(c.Request.Context(), id)
+	}
+	if err != nil {
+		h.writeRoleError(c, err)
+		return
+	}
+	h.invalidateRoleList(c)
+	c.Status(http.StatusNoContent)
+}
+
+// avatarTypes maps the sniffed content types accepted for avatars to file extensions.
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// UploadAvatar handles POST /api/roles/:id/avatar with a multipart "avatar" image, stores
+// it in the blob store and saves its URL on the role.
+func (h *RoleHandler) UploadAvatar(c *gin.Context) {
+	id, err := strconv.ParseInt(strings.TrimSpace(c.Param("id")), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid role id"))
+		return
+	}
+	if h.blobs == nil {
+		writeError(c, apierr.New(apierr.CodeStorageDisabled, "avatar storage is not configured"))
+		return
+	}
+
+	maxBytes := int64(h.cfg.AvatarMaxBytes)
+	// leave room for the multipart envelope; the file itself is checked below
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+64<<10)
+	file, header, err := c.Request.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(c, apierr.New(apierr.CodePayloadTooLarge, "avatar too large").With("max_bytes", maxBytes))
+			return
 		}
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "multipart field avatar is required"))
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxBytes {
+		writeError(c, apierr.New(apierr.CodePayloadTooLarge, "avatar too large").With("max_bytes", maxBytes))
+		return
 	}
 
-	query := baseQuery
-	if len(clauses) > 0 {
-		query += " WHERE " + strings.Join(clauses, " AND ")
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "read avatar failed"))
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	ext, ok := avatarTypes[contentType]
+	if !ok {
+		writeError(c, apierr.New(apierr.CodeUnsupportedMedia, "avatar must be a png, jpeg, webp or gif image").WithDetail(contentType))
+		return
 	}
-	query += " ORDER BY id"
 
 	ctx := c.Request.Context()
-	rows, err := h.pool.Query(ctx, query, args...)
-	selectExtended := true
-	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UndefinedColumn {
-			selectExtended = false
-			legacyQuery := `SELECT id, name, domain, tags, bio FROM roles`
-			if len(clauses) > 0 {
-				legacyQuery += " WHERE " + strings.Join(clauses, " AND ")
+	if !h.authorizeRoleWrite(c, id) {
+		return
+	}
+
+	key := fmt.Sprintf("avatars/%d-%s%s", id, newSessionID()[:12], ext)
+	url, err := h.blobs.Put(ctx, key, io.MultiReader(bytes.NewReader(head[:n]), file), contentType)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("store avatar for role %d: %v", id, err)
+		writeError(c, apierr.New(apierr.CodeInternal, "store avatar failed"))
+		return
+	}
+
+	stored, err := h.roles.SetAvatar(ctx, id, url)
+	if err != nil {
+		if delErr := h.blobs.Delete(ctx, key); delErr != nil {
+			ctxlog.From(c.Request.Context(), h.logger).Warnf("remove orphaned avatar %s: %v", key, delErr)
+		}
+		h.writeRoleError(c, err)
+		return
+	}
+	h.invalidateRoleList(c)
+	c.JSON(http.StatusOK, stored)
+}
+
+
+// ExportRoles handles GET /api/roles/export, returning every role definition as a JSON
+// array suitable for POST /api/roles/import. ?include_archived=1 adds archived roles.
+func (h *RoleHandler) ExportRoles(c *gin.Context) {
+	defs, err := roles.Export(c.Request.Context(), h.roles, c.Query("include_archived") == "1")
+	if err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "export roles failed"))
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="roles.json"`)
+	c.JSON(http.StatusOK, defs)
+}
+
+// ImportRoles handles POST /api/roles/import with a JSON array of definitions, upserting
+// them by name. ?dry_run=1 reports what would change without writing.
+func (h *RoleHandler) ImportRoles(c *gin.Context) {
+	var defs []roles.Definition
+	if err := c.ShouldBindJSON(&defs); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	report, err := roles.Import(c.Request.Context(), h.roles, defs, dryRun)
+	if err != nil {
+		var fieldErrs roles.FieldErrors
+		if errors.As(err, &fieldErrs) {
+			writeError(c, apierr.New(apierr.CodeValidationFailed, "invalid roles").With("errors", fieldErrs))
+			return
+		}
+		h.writeRoleError(c, err)
+		return
+	}
+	if !dryRun && report.Created+report.Updated > 0 {
+		h.invalidateRoleList(c)
+		changed := make([]int64, 0, report.Created+report.Updated)
+		for _, result := range report.Results {
+			if result.Action != db.RoleImportUnchanged {
+				changed = append(changed, result.ID)
 			}
-			legacyQuery += " ORDER BY id"
-			rows, err = h.pool.Query(ctx, legacyQuery, args...)
 		}
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "query roles failed"})
+		h.refreshEmbeddings(c, changed...)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// GetRoleTags handles GET /api/roles/tags, listing the distinct tags of active roles with
+// how many roles carry each.
+func (h *RoleHandler) GetRoleTags(c *gin.Context) {
+	tags, err := h.roles.Tags(c.Request.Context())
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("list role tags: %v", err)
+		writeError(c, apierr.New(apierr.CodeInternal, "query role tags failed"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": tags, "total": len(tags)})
+}
+
+// GetFeaturedRoles handles GET /api/roles/featured?limit=, listing pinned roles first and
+// then the most talked-to roles by decayed usage score.
+func (h *RoleHandler) GetFeaturedRoles(c *gin.Context) {
+	limit := defaultFeaturedRoles
+	if raw := strings.TrimSpace(c.Query("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid limit"))
 			return
 		}
+		limit = min(n, maxFeaturedRoles)
 	}
-	defer rows.Close()
 
-	roles := make([]models.Role, 0)
-	for rows.Next() {
-		var role models.Role
-		if selectExtended {
-			if err := rows.Scan(&role.ID, &role.Name, &role.Domain, &role.Tags, &role.Bio, &role.Personality, &role.Background, &role.Languages, &role.Skills); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "scan role failed"})
-				return
-			}
-		} else {
-			if err := rows.Scan(&role.ID, &role.Name, &role.Domain, &role.Tags, &role.Bio); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "scan role failed"})
-				return
-			}
+	featured, err := h.stats.Featured(c.Request.Context(), limit)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("featured roles: %v", err)
+		writeError(c, apierr.New(apierr.CodeInternal, "query featured roles failed"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": featured, "total": len(featured)})
+}
+
+// RecommendRoles handles POST /api/roles/recommend with {"interest": text, "limit": n} and
+// responds with the roles visible to the caller that best match the interest, each with
+// its score and the reasons it matched.
+func (h *RoleHandler) RecommendRoles(c *gin.Context) {
+	var payload struct {
+		Interest string `json:"interest"`
+		Limit    int    `json:"limit"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	interest := strings.TrimSpace(payload.Interest)
+	if interest == "" {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "interest is required"))
+		return
+	}
+	if utf8.RuneCountInString(interest) > maxInterestRunes {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "interest is too long").With("max_runes", maxInterestRunes))
+		return
+	}
+	limit := defaultRecommendedRoles
+	if payload.Limit > 0 {
+		limit = min(payload.Limit, maxRecommendedRoles)
+	}
+
+	ctx := c.Request.Context()
+	candidates, err := h.roles.List(ctx, db.RoleFilter{Viewer: currentUserID(c)})
+	if err != nil {
+		ctxlog.From(ctx, h.logger).Warnf("list roles for recommendation: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "query roles failed"))
+		return
+	}
+	recommended, err := h.recommender.Recommend(ctx, interest, candidates, limit)
+	if err != nil {
+		ctxlog.From(ctx, h.logger).Warnf("recommend roles: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "recommend roles failed"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": recommended, "total": len(recommended)})
+}
+
+// SetRoleFeatured handles PUT /api/roles/:id/featured with {"featured": bool}, pinning or
+// unpinning the role at the top of the featured list.
+func (h *RoleHandler) SetRoleFeatured(c *gin.Context) {
+	id, err := strconv.ParseInt(strings.TrimSpace(c.Param("id")), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid role id"))
+		return
+	}
+	var payload struct {
+		Featured *bool `json:"featured"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil || payload.Featured == nil {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "featured is required"))
+		return
+	}
+
+	if err := h.stats.SetFeatured(c.Request.Context(), id, *payload.Featured); err != nil {
+		h.writeRoleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "featured": *payload.Featured})
+}
+
+// EnrichRole handles POST /api/admin/roles/:id/enrich and responds with the skills a backend
+// suggests for the role merged into its current ones. ?backend=heuristic (default) matches
+// keywords; ?backend=llm asks the chat model with the server token. The merged skills are
+// only saved with ?apply=true, and existing skills are never removed.
+func (h *RoleHandler) EnrichRole(c *gin.Context) {
+	id, err := strconv.ParseInt(strings.TrimSpace(c.Param("id")), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "invalid role id"))
+		return
+	}
+	apply, err := strconv.ParseBool(c.DefaultQuery("apply", "false"))
+	if err != nil {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "apply must be true or false"))
+		return
+	}
+	backend := strings.TrimSpace(c.Query("backend"))
+	token := strings.TrimSpace(h.cfg.QiniuAPIKey)
+	if backend == services.SkillBackendLLM && token == "" {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "QINIU_API_KEY is required for the llm backend"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	role, err := h.roles.GetByID(ctx, id)
+	if err != nil {
+		h.writeRoleError(c, err)
+		return
+	}
+	result, err := h.enricher.Enrich(ctx, backend, token, *role)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	applied := false
+	if apply && result.Changed() {
+		role.Skills = result.SkillsJSON()
+		if _, err := h.roles.Update(ctx, *role); err != nil {
+			h.writeRoleError(c, err)
+			return
 		}
-		roles = append(roles, role)
+		h.invalidateRoleList(c)
+		applied = true
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"role_id":   result.RoleID,
+		"backend":   result.Backend,
+		"current":   result.Current,
+		"suggested": result.Suggested,
+		"added":     result.Added,
+		"skills":    result.Skills,
+		"applied":   applied,
+	})
+}
 
-	if rows.Err() != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "iterate roles failed"})
+// ReloadRoleCaches handles POST /api/admin/reload. It drops the cached role listings and
+// the roles cached by ID, so direct edits to the roles table show up before the cache TTLs
+// expire, re-reads the global prompt files, and reports what each step did and how long it
+// took. Skills and prompt templates are compiled in and need no reload.
+func (h *RoleHandler) ReloadRoleCaches(c *gin.Context) {
+	ctx := c.Request.Context()
+	components, purged, err := h.reloadCaches(ctx)
+	if err != nil {
+		writeError(c, err)
 		return
 	}
+	ctxlog.From(ctx, h.logger).Infow("role caches reloaded", "by_id_purged", purged, "actor", adminActor(c))
+	c.JSON(http.StatusOK, gin.H{"components": components})
+}
+
+// RunCacheRefresh does what ReloadRoleCaches does every interval until ctx is cancelled,
+// bounding how long direct edits to the roles table and the prompt files go unseen.
+func (h *RoleHandler) RunCacheRefresh(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		_, purged, err := h.reloadCaches(ctx)
+		switch {
+		case err == nil:
+			h.logger.Debugw("role caches refreshed", "by_id_purged", purged)
+		case ctx.Err() == nil:
+			h.logger.Warnf("refresh role caches: %v", err)
+		}
+	}
+}
 
-	c.JSON(http.StatusOK, roles)
+// reloadCaches drops the cached roles and re-reads the global prompt, returning a report
+// entry per component and how many roles cached by ID it dropped.
+func (h *RoleHandler) reloadCaches(ctx context.Context) ([]gin.H, int, error) {
+	components := make([]gin.H, 0, 3)
+
+	start := time.Now()
+	if err := h.cache.Invalidate(ctx); err != nil {
+		return nil, 0, apierr.Wrap(err, apierr.CodeInternal, "invalidate role list cache failed")
+	}
+	components = append(components, gin.H{
+		"name":        "role_list",
+		"enabled":     h.cache != nil,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+
+	start = time.Now()
+	purged := 0
+	cached, ok := h.roles.(*db.CachedRoleRepository)
+	if ok {
+		var err error
+		if purged, err = cached.Purge(ctx); err != nil {
+			return nil, purged, apierr.Wrap(err, apierr.CodeInternal, "purge role cache failed")
+		}
+	}
+	components = append(components, gin.H{
+		"name":        "role_by_id",
+		"enabled":     ok,
+		"purged":      purged,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+
+	start = time.Now()
+	if h.globalPrompt != nil {
+		if err := h.globalPrompt.Reload(); err != nil {
+			return nil, purged, apierr.Wrap(err, apierr.CodeInternal, "reload global prompt failed")
+		}
+	}
+	prefix, suffix := h.globalPrompt.Text()
+	components = append(components, gin.H{
+		"name":        "global_prompt",
+		"enabled":     h.globalPrompt != nil,
+		"prefix":      prefix != "",
+		"suffix":      suffix != "",
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+	return components, purged, nil
+}
+
+func (h *RoleHandler) writeRoleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, db.ErrRoleNameTaken):
+		writeError(c, apierr.New(apierr.CodeRoleNameTaken, "role name already exists"))
+	case errors.Is(err, pgx.ErrNoRows):
+		writeError(c, apierr.New(apierr.CodeRoleNotFound, "role not found"))
+	default:
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "save role failed"))
+	}
 }
 
 func parseTagTerms(raw string) []string {
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// TestRoleWritesInvalidateCachedListings serves GET /api/roles from a Redis-backed cache and
+// checks that every write through the API is visible in the next listing.
+func TestRoleWritesInvalidateCachedListings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	repo := db.NewMemoryRoleRepository(models.Role{ID: 1, Name: "Socrates", Domain: "philosophy"})
+	cache := db.NewRoleListCache(kv.New(client, "test"), time.Hour)
+	h := NewRoleHandler(&config.Config{}, repo, cache, nil, nil, nil, nil, nil, zap.NewNop().Sugar())
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(adminActorKey, "test") })
+	router.GET("/api/roles", h.GetRoles)
+	router.POST("/api/roles", h.CreateRole)
+	router.PUT("/api/roles/:id", h.UpdateRole)
+	router.DELETE("/api/roles/:id", h.DeleteRole)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	names := func() []string {
+		t.Helper()
+		rec := serve(http.MethodGet, "/api/roles", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/roles = %d: %s", rec.Code, rec.Body)
+		}
+		var listed []models.Role
+		if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+			t.Fatalf("decode listing: %v", err)
+		}
+		out := make([]string, 0, len(listed))
+		for _, role := range listed {
+			out = append(out, role.Name)
+		}
+		return out
+	}
+
+	if got := names(); strings.Join(got, ",") != "Socrates" {
+		t.Fatalf("initial listing = %v", got)
+	}
+	// a write that bypasses the API is not seen until the cache is invalidated
+	if _, err := repo.Create(t.Context(), models.Role{Name: "Direct", Domain: "misc"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); strings.Join(got, ",") != "Socrates" {
+		t.Fatalf("listing was not served from the cache: %v", got)
+	}
+
+	steps := []struct {
+		name         string
+		method, path string
+		body         string
+		status       int
+		want         string
+	}{
+		{"create", http.MethodPost, "/api/roles", `{"name":"Confucius","domain":"philosophy"}`, http.StatusCreated, "Socrates,Direct,Confucius"},
+		{"update", http.MethodPut, "/api/roles/1", `{"name":"Plato","domain":"philosophy"}`, http.StatusOK, "Plato,Direct,Confucius"},
+		{"archive", http.MethodDelete, "/api/roles/1", "", http.StatusNoContent, "Direct,Confucius"},
+	}
+	for _, step := range steps {
+		rec := serve(step.method, step.path, step.body)
+		if rec.Code != step.status {
+			t.Fatalf("%s = %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		if got := strings.Join(names(), ","); got != step.want {
+			t.Errorf("listing after %s = %s, want %s", step.name, got, step.want)
+		}
+	}
+}
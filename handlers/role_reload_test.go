@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+// reloadFixture is a RoleHandler over Redis-backed role caches and a global prompt whose
+// files are at version.
+type reloadFixture struct {
+	handler *RoleHandler
+	repo    *db.MemoryRoleRepository
+	prompt  *services.GlobalPrompt
+	version atomic.Int64
+	router  *gin.Engine
+}
+
+func newReloadFixture(t *testing.T) *reloadFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	store := kv.New(client, "test")
+
+	f := &reloadFixture{repo: db.NewMemoryRoleRepository(
+		models.Role{ID: 1, Name: "Socrates", Domain: "philosophy"},
+		models.Role{ID: 2, Name: "Confucius", Domain: "philosophy"},
+	)}
+	f.prompt = services.NewGlobalPrompt(func() (string, string, error) {
+		version := f.version.Load()
+		return fmt.Sprintf("prefix v%d", version), fmt.Sprintf("suffix v%d", version), nil
+	})
+	cached := db.NewCachedRoleRepository(f.repo, store, time.Hour, zap.NewNop().Sugar())
+	f.handler = NewRoleHandler(&config.Config{}, cached, db.NewRoleListCache(store, time.Hour), nil, nil, nil, nil, nil, zap.NewNop().Sugar())
+	f.handler.ManageGlobalPrompt(f.prompt)
+
+	f.router = gin.New()
+	f.router.Use(func(c *gin.Context) { c.Set(adminActorKey, "test") })
+	f.router.GET("/api/roles", f.handler.GetRoles)
+	f.router.GET("/api/roles/:id", f.handler.GetRole)
+	f.router.POST("/api/admin/reload", f.handler.ReloadRoleCaches)
+	return f
+}
+
+func (f *reloadFixture) serve(method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+// roleName returns the name GET /api/roles/:id serves for id.
+func (f *reloadFixture) roleName(id int64) (string, error) {
+	rec := f.serve(http.MethodGet, fmt.Sprintf("/api/roles/%d", id))
+	if rec.Code != http.StatusOK {
+		return "", fmt.Errorf("GET role %d = %d: %s", id, rec.Code, rec.Body)
+	}
+	var role models.Role
+	if err := json.Unmarshal(rec.Body.Bytes(), &role); err != nil {
+		return "", err
+	}
+	return role.Name, nil
+}
+
+// rename changes a role behind the caches' back, as a script editing the table would.
+func (f *reloadFixture) rename(t *testing.T, id int64, name string) {
+	t.Helper()
+	role, err := f.repo.GetByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	role.Name = name
+	if _, err := f.repo.Update(context.Background(), *role); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReloadDuringReads reloads repeatedly while readers look roles up by ID, list them and
+// wrap prompts; run with -race. Readers must only ever see whole roles, whole listings and
+// a prefix and suffix of the same version.
+func TestReloadDuringReads(t *testing.T) {
+	f := newReloadFixture(t)
+	if err := f.prompt.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	const reloads = 10
+	stop := make(chan struct{})
+	errs := make(chan error, 64)
+	var readers sync.WaitGroup
+	for reader := range 3 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				var err error
+				switch reader % 3 {
+				case 0:
+					var name string
+					if name, err = f.roleName(1); err == nil && !strings.HasPrefix(name, "Socrates") {
+						err = fmt.Errorf("role 1 served as %q", name)
+					}
+				case 1:
+					rec := f.serve(http.MethodGet, "/api/roles")
+					var listed []models.Role
+					if rec.Code != http.StatusOK {
+						err = fmt.Errorf("GET /api/roles = %d: %s", rec.Code, rec.Body)
+					} else if err = json.Unmarshal(rec.Body.Bytes(), &listed); err == nil && len(listed) != 2 {
+						err = fmt.Errorf("listing has %d roles, want 2", len(listed))
+					}
+				case 2:
+					prefix, suffix := f.prompt.Text()
+					if strings.TrimPrefix(prefix, "prefix ") != strings.TrimPrefix(suffix, "suffix ") {
+						err = fmt.Errorf("prefix %q served with suffix %q", prefix, suffix)
+					}
+				}
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= reloads; i++ {
+		f.rename(t, 1, fmt.Sprintf("Socrates v%d", i))
+		f.version.Store(int64(i))
+		if rec := f.serve(http.MethodPost, "/api/admin/reload"); rec.Code != http.StatusOK {
+			t.Fatalf("reload %d = %d: %s", i, rec.Code, rec.Body)
+		}
+	}
+	close(stop)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// the last direct edit and prompt files are served once the reload returns
+	want := fmt.Sprintf("Socrates v%d", reloads)
+	if name, err := f.roleName(1); err != nil || name != want {
+		t.Errorf("role 1 after the reloads = %q (%v), want %q", name, err, want)
+	}
+	if got := f.prompt.Wrap("system"); got != fmt.Sprintf("prefix v%d\nsystem\nsuffix v%d", reloads, reloads) {
+		t.Errorf("Wrap after the reloads = %q, want version %d", got, reloads)
+	}
+}
+
+func TestRunCacheRefresh(t *testing.T) {
+	f := newReloadFixture(t)
+	if name, err := f.roleName(2); err != nil || name != "Confucius" {
+		t.Fatalf("role 2 = %q (%v), want Confucius cached", name, err)
+	}
+	f.rename(t, 2, "Kong Qiu")
+	if name, _ := f.roleName(2); name != "Confucius" {
+		t.Fatalf("role 2 = %q before any refresh, want the cached Confucius", name)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- f.handler.RunCacheRefresh(ctx, 10*time.Millisecond) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		name, err := f.roleName(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "Kong Qiu" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the direct edit was never served, want the refresh to drop the cached role")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if prefix, _ := f.prompt.Text(); prefix != "prefix v0" {
+		t.Errorf("prefix = %q after a refresh, want the prompt files re-read", prefix)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunCacheRefresh = %v on cancel, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RunCacheRefresh did not return once cancelled")
+	}
+}
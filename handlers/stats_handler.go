@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/metrics"
+	"github.com/wuwenbin0122/wwb.ai/services"
+	"go.uber.org/zap"
+)
+
+const (
+	roleStatsDays         = 7
+	defaultRoleStatsLimit = 20
+	maxRoleStatsLimit     = 200
+)
+
+// StatsHandler serves the read-only admin dashboard. Traffic figures come from the
+// in-process metrics.Stats and cover the instance answering; role figures come from the
+// usage_events day rows.
+type StatsHandler struct {
+	stats    *metrics.Stats
+	breakers []*services.CircuitBreaker
+	usage    *db.UsageStore
+	logger   *zap.SugaredLogger
+}
+
+// NewStatsHandler builds a StatsHandler.
+func NewStatsHandler(stats *metrics.Stats, breakers []*services.CircuitBreaker, usage *db.UsageStore, logger *zap.SugaredLogger) *StatsHandler {
+	return &StatsHandler{stats: stats, breakers: breakers, usage: usage, logger: logger}
+}
+
+// GetOverview handles GET /api/admin/stats/overview: requests and 5xx errors today, the
+// open WebSocket connections, the error rate of the last minutes and the state of each
+// upstream circuit breaker.
+func (h *StatsHandler) GetOverview(c *gin.Context) {
+	breakers := make(map[string]string, len(h.breakers))
+	for _, breaker := range h.breakers {
+		breakers[breaker.Name()] = breaker.State().String()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"traffic":  h.stats.Overview(),
+		"breakers": breakers,
+	})
+}
+
+// GetRoleStats handles GET /api/admin/stats/roles?limit= and responds with the chat
+// replies per role over the last seven UTC days, today included, busiest first.
+func (h *StatsHandler) GetRoleStats(c *gin.Context) {
+	limit := defaultRoleStatsLimit
+	if raw := strings.TrimSpace(c.Query("limit")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, "limit must be a positive integer"))
+			return
+		}
+		limit = min(parsed, maxRoleStatsLimit)
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -(roleStatsDays - 1))
+	roles, err := h.usage.ChatsByRole(c.Request.Context(), from, to, limit)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("chats by role failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to load role stats"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":  from.Format(time.DateOnly),
+		"to":    to.Format(time.DateOnly),
+		"roles": roles,
+	})
+}
+
+// GetLatency handles GET /api/admin/stats/latency and responds with the p50 and p95
+// latency of every route requested in the last minutes, busiest first.
+func (h *StatsHandler) GetLatency(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"window_seconds": int(h.stats.Window() / time.Second),
+		"routes":         h.stats.Latency(),
+	})
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPartialInterval is the minimum spacing between interim transcript events.
+const defaultPartialInterval = 200 * time.Millisecond
+
+// transcriptCoalescer rate-limits interim transcript events for a single ASR session.
+// At most one partial is emitted per interval (the newest one wins); final results are
+// always emitted immediately. Every emitted event carries a "delta" with the text appended
+// since the previous event, or the full text with "revised": true when the recognizer
+// rewrote earlier words. emit reports whether the event was delivered; after a dropped
+// event the next one resends the full text so client-side delta assembly stays correct.
+type transcriptCoalescer struct {
+	interval time.Duration
+	emit     func(gin.H) bool
+
+	mu          sync.Mutex
+	pending     gin.H
+	pendingText string
+	lastText    string
+	lastEmit    time.Time
+	timer       *time.Timer
+	stopped     bool
+	resync      bool
+}
+
+func newTranscriptCoalescer(interval time.Duration, emit func(gin.H) bool) *transcriptCoalescer {
+	if interval <= 0 {
+		interval = defaultPartialInterval
+	}
+	return &transcriptCoalescer{interval: interval, emit: emit}
+}
+
+// Push offers a transcript event built by the proxy; text and isFinal drive coalescing.
+func (tc *transcriptCoalescer) Push(event gin.H, text string, isFinal bool) {
+	tc.mu.Lock()
+	if tc.stopped {
+		tc.mu.Unlock()
+		return
+	}
+
+	if isFinal {
+		tc.cancelTimerLocked()
+		tc.pending = nil
+		out := tc.prepareLocked(event, text)
+		tc.mu.Unlock()
+		tc.deliver(out)
+		return
+	}
+
+	now := time.Now()
+	if tc.pending == nil && now.Sub(tc.lastEmit) >= tc.interval {
+		out := tc.prepareLocked(event, text)
+		tc.mu.Unlock()
+		tc.deliver(out)
+		return
+	}
+
+	// within the window: keep only the newest partial and flush it when the window closes
+	tc.pending = event
+	tc.pendingText = text
+	if tc.timer == nil {
+		wait := tc.interval - now.Sub(tc.lastEmit)
+		if wait < 0 {
+			wait = 0
+		}
+		tc.timer = time.AfterFunc(wait, tc.flushPending)
+	}
+	tc.mu.Unlock()
+}
+
+// Close emits any buffered partial and stops further emission.
+func (tc *transcriptCoalescer) Close() {
+	tc.flushPending()
+	tc.mu.Lock()
+	tc.stopped = true
+	tc.cancelTimerLocked()
+	tc.mu.Unlock()
+}
+
+func (tc *transcriptCoalescer) flushPending() {
+	tc.mu.Lock()
+	tc.timer = nil
+	if tc.pending == nil || tc.stopped {
+		tc.mu.Unlock()
+		return
+	}
+	out := tc.prepareLocked(tc.pending, tc.pendingText)
+	tc.pending = nil
+	tc.mu.Unlock()
+	tc.deliver(out)
+}
+
+func (tc *transcriptCoalescer) deliver(event gin.H) {
+	if tc.emit(event) {
+		return
+	}
+	tc.mu.Lock()
+	tc.resync = true
+	tc.mu.Unlock()
+}
+
+func (tc *transcriptCoalescer) prepareLocked(event gin.H, text string) gin.H {
+	if !tc.resync && strings.HasPrefix(text, tc.lastText) {
+		event["delta"] = text[len(tc.lastText):]
+	} else {
+		event["delta"] = text
+		event["revised"] = true
+		tc.resync = false
+	}
+	tc.lastText = text
+	tc.lastEmit = time.Now()
+	return event
+}
+
+func (tc *transcriptCoalescer) cancelTimerLocked() {
+	if tc.timer != nil {
+		tc.timer.Stop()
+		tc.timer = nil
+	}
+}
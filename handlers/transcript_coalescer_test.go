@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventLog collects the events a coalescer emits; accept decides whether each is delivered.
+type eventLog struct {
+	mu     sync.Mutex
+	events []gin.H
+	accept func(n int) bool
+}
+
+func (l *eventLog) emit(event gin.H) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	if l.accept != nil {
+		return l.accept(len(l.events))
+	}
+	return true
+}
+
+func (l *eventLog) snapshot() []gin.H {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]gin.H(nil), l.events...)
+}
+
+func (l *eventLog) waitFor(t *testing.T, n int) []gin.H {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if events := l.snapshot(); len(events) >= n {
+			return events
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("got %d events, want %d", len(l.snapshot()), n)
+	return nil
+}
+
+func partial(text string) gin.H {
+	return gin.H{"type": "transcript", "text": text, "is_final": false}
+}
+
+func TestTranscriptCoalescerKeepsNewestPartialPerWindow(t *testing.T) {
+	log := &eventLog{}
+	tc := newTranscriptCoalescer(50*time.Millisecond, log.emit)
+
+	tc.Push(partial("the"), "the", false)
+	tc.Push(partial("the quick"), "the quick", false)
+	tc.Push(partial("the quick brown"), "the quick brown", false)
+
+	events := log.waitFor(t, 2)
+	time.Sleep(80 * time.Millisecond)
+	if got := len(log.snapshot()); got != 2 {
+		t.Fatalf("got %d events, want the first partial and the newest one", got)
+	}
+	if events[0]["text"] != "the" || events[0]["delta"] != "the" {
+		t.Errorf("first event = %v, want the first partial with its full text as delta", events[0])
+	}
+	if events[1]["text"] != "the quick brown" || events[1]["delta"] != " quick brown" {
+		t.Errorf("second event = %v, want the newest partial with the appended text as delta", events[1])
+	}
+	if _, revised := events[1]["revised"]; revised {
+		t.Errorf("second event is marked revised: %v", events[1])
+	}
+}
+
+func TestTranscriptCoalescerFinalSupersedesPendingPartial(t *testing.T) {
+	log := &eventLog{}
+	tc := newTranscriptCoalescer(time.Hour, log.emit)
+
+	tc.Push(partial("hello"), "hello", false)
+	tc.Push(partial("hello wor"), "hello wor", false)
+	tc.Push(gin.H{"type": "transcript", "text": "hello world", "is_final": true}, "hello world", true)
+	tc.Close()
+
+	events := log.snapshot()
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want the first partial and the final: %v", len(events), events)
+	}
+	if events[1]["is_final"] != true || events[1]["delta"] != " world" {
+		t.Errorf("final event = %v, want the final with delta %q", events[1], " world")
+	}
+}
+
+func TestTranscriptCoalescerMarksRevisions(t *testing.T) {
+	log := &eventLog{}
+	tc := newTranscriptCoalescer(time.Nanosecond, log.emit)
+
+	tc.Push(partial("I scream"), "I scream", false)
+	time.Sleep(time.Millisecond)
+	tc.Push(partial("ice cream"), "ice cream", false)
+	events := log.waitFor(t, 2)
+
+	if events[1]["delta"] != "ice cream" || events[1]["revised"] != true {
+		t.Errorf("rewritten transcript = %v, want the full text marked revised", events[1])
+	}
+}
+
+func TestTranscriptCoalescerResyncsAfterDroppedEvent(t *testing.T) {
+	// the second event is dropped, as when the client send queue is full
+	log := &eventLog{accept: func(n int) bool { return n != 2 }}
+	tc := newTranscriptCoalescer(time.Nanosecond, log.emit)
+
+	for _, text := range []string{"one", "one two", "one two three"} {
+		tc.Push(partial(text), text, false)
+		time.Sleep(time.Millisecond)
+	}
+	events := log.waitFor(t, 3)
+
+	if events[2]["delta"] != "one two three" || events[2]["revised"] != true {
+		t.Errorf("event after a drop = %v, want the full text marked revised", events[2])
+	}
+}
+
+func TestTranscriptCoalescerCloseFlushesAndStops(t *testing.T) {
+	log := &eventLog{}
+	tc := newTranscriptCoalescer(time.Hour, log.emit)
+
+	tc.Push(partial("a"), "a", false)
+	tc.Push(partial("a b"), "a b", false)
+	tc.Close()
+	tc.Push(partial("a b c"), "a b c", false)
+
+	events := log.snapshot()
+	if len(events) != 2 || events[1]["text"] != "a b" {
+		t.Fatalf("events = %v, want the pending partial flushed by Close and nothing after", events)
+	}
+}
@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/jobs"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
+	"github.com/wuwenbin0122/wwb.ai/services"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
+	"go.uber.org/zap"
+)
+
+// TTSBatchJobType is the job type of asynchronous batches.
+const TTSBatchJobType = "tts_batch"
+
+const (
+	maxTTSBatchItems = 50
+	// ttsBatchItemTimeout bounds the synthesis of one line, limiter waits included.
+	ttsBatchItemTimeout = 90 * time.Second
+	// TTSBatchJobTimeout bounds one attempt at an asynchronous batch.
+	TTSBatchJobTimeout = 30 * time.Minute
+	// ttsBatchSaveInterval spaces the progress writes of an asynchronous batch, whose
+	// state grows with every finished line.
+	ttsBatchSaveInterval = 2 * time.Second
+)
+
+// TTSBatchHandler pre-renders many lines at once, synchronously or as a job polled by id.
+type TTSBatchHandler struct {
+	tts         *services.TTSService
+	jobs        *db.TTSBatchStore
+	queue       *jobs.Queue
+	limiter     *ratelimit.Limiter
+	usage       *db.UsageStore
+	tokens      *tokenresolver.Resolver
+	concurrency int
+	logger      *zap.SugaredLogger
+}
+
+// NewTTSBatchHandler builds a new TTSBatchHandler. Asynchronous batches run on queue, where
+// ProcessJob must be registered as TTSBatchJobType. Lines count against the same rate
+// limit as /api/audio/tts; usage may be nil to skip usage accounting.
+func NewTTSBatchHandler(cfg *config.Config, tts *services.TTSService, batches *db.TTSBatchStore, queue *jobs.Queue, limiter *ratelimit.Limiter, usage *db.UsageStore, logger *zap.SugaredLogger) *TTSBatchHandler {
+	concurrency := cfg.TTSBatchConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &TTSBatchHandler{
+		tts:         tts,
+		jobs:        batches,
+		queue:       queue,
+		limiter:     limiter,
+		usage:       usage,
+		tokens:      tokenresolver.New(cfg.QiniuTokenMode, cfg.QiniuAPIKey),
+		concurrency: concurrency,
+		logger:      logger,
+	}
+}
+
+type ttsBatchRequest struct {
+	Token    string             `json:"token"`
+	Encoding string             `json:"encoding"`
+	Items    []ttsBatchItemSpec `json:"items"`
+}
+
+type ttsBatchItemSpec struct {
+	ID        string  `json:"id"`
+	Text      string  `json:"text"`
+	VoiceType string  `json:"voice_type"`
+	Speed     float64 `json:"speed"`
+}
+
+// HandleBatch handles POST /api/audio/tts/batch with up to 50 {id, text, voice_type,
+// speed} items and responds with the outcome of each, in request order, and the total
+// time taken. A failing line does not fail the batch. With ?async=true it responds 202
+// with a job id right away; GET /api/audio/tts/batch/:id reports progress and results.
+func (h *TTSBatchHandler) HandleBatch(c *gin.Context) {
+	var req ttsBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+	if len(req.Items) == 0 || len(req.Items) > maxTTSBatchItems {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "items must hold 1 to 50 lines").With("max_items", maxTTSBatchItems))
+		return
+	}
+	seen := make(map[string]bool, len(req.Items))
+	for i := range req.Items {
+		item := &req.Items[i]
+		item.ID = strings.TrimSpace(item.ID)
+		if item.ID == "" {
+			item.ID = strconv.Itoa(i)
+		}
+		if seen[item.ID] {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, "item ids must be unique").With("id", item.ID))
+			return
+		}
+		seen[item.ID] = true
+	}
+
+	token := resolveQiniuToken(c, h.tokens, h.logger, req.Token)
+	if token == "" {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+	userID := currentUserID(c)
+	batch := ttsBatch{token: token, limiterKey: rateLimitKey(c, token), userID: userID, encoding: req.Encoding, items: req.Items}
+
+	if c.Query("async") != "true" {
+		started := time.Now()
+		results := h.run(c.Request.Context(), batch, nil)
+		succeeded, failed := countTTSBatch(results)
+		c.JSON(http.StatusOK, gin.H{
+			"items":       results,
+			"total":       len(results),
+			"succeeded":   succeeded,
+			"failed":      failed,
+			"duration_ms": time.Since(started).Milliseconds(),
+		})
+		return
+	}
+
+	if h.jobs == nil || h.queue == nil {
+		writeError(c, apierr.New(apierr.CodeFeatureDisabled, "async tts batches are unavailable"))
+		return
+	}
+	job := db.TTSBatchJob{
+		ID:        newSessionID(),
+		UserID:    userID,
+		Status:    db.TTSBatchPending,
+		Total:     len(req.Items),
+		Items:     []db.TTSBatchItem{},
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := h.jobs.Save(c.Request.Context(), job); err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("create tts batch job failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to create batch job"))
+		return
+	}
+
+	payload := ttsBatchPayload{
+		BatchID:    job.ID,
+		RequestID:  ctxlog.RequestID(c.Request.Context()),
+		UserID:     userID,
+		LimiterKey: batch.limiterKey,
+		Encoding:   req.Encoding,
+		Items:      req.Items,
+	}
+	// the server key is looked up again when the job runs rather than copied to Redis
+	if serverKey, _ := h.tokens.Server(); token != serverKey {
+		payload.Token = token
+	}
+	if _, err := h.queue.Enqueue(c.Request.Context(), TTSBatchJobType, payload); err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("enqueue tts batch job failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to create batch job"))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"id": job.ID, "status": job.Status, "total": job.Total})
+}
+
+// GetBatch handles GET /api/audio/tts/batch/:id and responds with the job state. A job
+// created by a signed-in user is only visible to that user.
+func (h *TTSBatchHandler) GetBatch(c *gin.Context) {
+	if h.jobs == nil {
+		writeError(c, apierr.New(apierr.CodeFeatureDisabled, "async tts batches are unavailable"))
+		return
+	}
+	job, ok, err := h.jobs.Load(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("load tts batch job failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to load batch job"))
+		return
+	}
+	if !ok || job.UserID != currentUserID(c) {
+		writeError(c, apierr.New(apierr.CodeBatchNotFound, "batch job not found"))
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+type ttsBatch struct {
+	token      string
+	limiterKey string
+	userID     string
+	encoding   string
+	items      []ttsBatchItemSpec
+}
+
+// ttsBatchPayload is the job payload of an asynchronous batch. Token is empty when the
+// batch runs on the server key.
+type ttsBatchPayload struct {
+	BatchID   string `json:"batch_id"`
+	RequestID string `json:"request_id,omitempty"`
+	Token     string `json:"token,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	// LimiterKey is the rate limit key of the caller that created the batch.
+	LimiterKey string             `json:"limiter_key,omitempty"`
+	Encoding   string             `json:"encoding,omitempty"`
+	Items      []ttsBatchItemSpec `json:"items"`
+}
+
+// ProcessJob is the jobs.Handler of TTSBatchJobType. It saves progress as lines finish;
+// a batch interrupted by shutdown is left running and starts over on its next attempt.
+func (h *TTSBatchHandler) ProcessJob(ctx context.Context, queued jobs.Job) error {
+	var payload ttsBatchPayload
+	if err := queued.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+	ctx = ctxlog.WithRequestID(ctx, payload.RequestID)
+	logger := ctxlog.From(ctx, h.logger)
+
+	job, ok, err := h.jobs.Load(ctx, payload.BatchID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return jobs.Permanent(fmt.Errorf("tts batch %s expired before it ran", payload.BatchID))
+	}
+	batch := ttsBatch{token: payload.Token, userID: payload.UserID, encoding: payload.Encoding, items: payload.Items}
+	if batch.token == "" {
+		batch.token, _ = h.tokens.Server()
+	}
+	batch.limiterKey = payload.LimiterKey
+	if batch.limiterKey == "" {
+		// queued before batches carried their key
+		batch.limiterKey = ttsBatchLimiterKey(batch.userID, batch.token)
+	}
+	started := time.Now()
+
+	var mu sync.Mutex
+	lastSave := time.Time{}
+	save := func(force bool) error {
+		if !force && time.Since(lastSave) < ttsBatchSaveInterval {
+			return nil
+		}
+		lastSave = time.Now()
+		job.DurationMS = time.Since(started).Milliseconds()
+		// the job context may be cancelled by now; the state must still be written
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		return h.jobs.Save(saveCtx, job)
+	}
+
+	job.Status = db.TTSBatchRunning
+	job.Items = []db.TTSBatchItem{}
+	job.Succeeded, job.Failed = 0, 0
+	if err := save(true); err != nil {
+		return err
+	}
+
+	results := h.run(ctx, batch, func(item db.TTSBatchItem) {
+		mu.Lock()
+		defer mu.Unlock()
+		job.Items = append(job.Items, item)
+		job.Succeeded, job.Failed = countTTSBatch(job.Items)
+		if err := save(false); err != nil {
+			logger.Warnf("save tts batch %s progress failed: %v", job.ID, err)
+		}
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	job.Status = db.TTSBatchDone
+	job.Items = results
+	job.Succeeded, job.Failed = countTTSBatch(results)
+	if err := save(true); err != nil {
+		return err
+	}
+	logger.Infof("tts batch %s done: %d succeeded, %d failed in %s", job.ID, job.Succeeded, job.Failed, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// run synthesizes the lines of batch, at most concurrency at a time, and returns their
+// outcomes in request order. onItem, when set, is called as each line finishes.
+func (h *TTSBatchHandler) run(ctx context.Context, batch ttsBatch, onItem func(db.TTSBatchItem)) []db.TTSBatchItem {
+	results := make([]db.TTSBatchItem, len(batch.items))
+	slots := make(chan struct{}, h.concurrency)
+	var wg sync.WaitGroup
+	for i, spec := range batch.items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				results[i] = h.synthesize(ctx, batch, spec)
+			case <-ctx.Done():
+				results[i] = failedTTSBatchItem(spec.ID, time.Now(), ctx.Err())
+			}
+			if onItem != nil {
+				onItem(results[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (h *TTSBatchHandler) synthesize(ctx context.Context, batch ttsBatch, spec ttsBatchItemSpec) db.TTSBatchItem {
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, ttsBatchItemTimeout)
+	defer cancel()
+
+	if strings.TrimSpace(spec.Text) == "" {
+		return failedTTSBatchItem(spec.ID, started, apierr.New(apierr.CodeInvalidRequest, "text is required"))
+	}
+	speech := services.TTSRequest{
+		Text:       spec.Text,
+		VoiceType:  spec.VoiceType,
+		Encoding:   batch.encoding,
+		SpeedRatio: spec.Speed,
+	}
+	if err := speech.Validate(); err != nil {
+		return failedTTSBatchItem(spec.ID, started, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid tts options"))
+	}
+	if err := h.acquire(ctx, batch.limiterKey); err != nil {
+		return failedTTSBatchItem(spec.ID, started, err)
+	}
+
+	result, err := h.tts.Synthesize(ctx, batch.token, h.tts.ResolveVoice(speech, nil))
+	if err != nil {
+		ctxlog.From(ctx, h.logger).Warnf("tts batch item %s failed: %v", spec.ID, err)
+		return failedTTSBatchItem(spec.ID, started, err)
+	}
+	h.usage.Record(ttsUsage(batch.userID, 0, spec.Text))
+
+	return db.TTSBatchItem{
+		ID:         spec.ID,
+		Status:     "ok",
+		Audio:      base64.StdEncoding.EncodeToString(result.Audio),
+		Encoding:   result.Encoding,
+		Duration:   result.Duration,
+		ReqID:      result.ReqID,
+		DurationMS: time.Since(started).Milliseconds(),
+	}
+}
+
+// ttsBatchLimiterKey is the rate limit key of a batch queued without one.
+func ttsBatchLimiterKey(userID, token string) string {
+	if userID != "" {
+		return "user:" + userID
+	}
+	return "token:" + token
+}
+
+// acquire takes a token from the TTS rate limit for key, waiting for the bucket to refill
+// as long as ctx allows.
+func (h *TTSBatchHandler) acquire(ctx context.Context, key string) error {
+	for {
+		decision := h.limiter.Allow(ctx, "tts", key)
+		if decision.Allowed {
+			return nil
+		}
+		wait := decision.RetryAfter
+		if wait <= 0 {
+			wait = time.Second
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return apierr.New(apierr.CodeRateLimited, "rate limit exceeded").With("retry_after", int((wait+time.Second-1)/time.Second))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func failedTTSBatchItem(id string, started time.Time, err error) db.TTSBatchItem {
+	return db.TTSBatchItem{
+		ID:         id,
+		Status:     "error",
+		Error:      err.Error(),
+		Code:       string(apierr.CodeOf(err, apierr.CodeUpstream)),
+		DurationMS: time.Since(started).Milliseconds(),
+	}
+}
+
+func countTTSBatch(items []db.TTSBatchItem) (succeeded, failed int) {
+	for _, item := range items {
+		if item.Status == "ok" {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
+	return succeeded, failed
+}
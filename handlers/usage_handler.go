@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/services"
+	"go.uber.org/zap"
+)
+
+const (
+	maxUsageRangeDays  = 366
+	defaultUsageGroups = 50
+	maxUsageGroups     = 500
+)
+
+// UsageHandler reports the upstream usage recorded by db.UsageStore.
+type UsageHandler struct {
+	usage  *db.UsageStore
+	logger *zap.SugaredLogger
+}
+
+// NewUsageHandler builds a new UsageHandler.
+func NewUsageHandler(usage *db.UsageStore, logger *zap.SugaredLogger) *UsageHandler {
+	return &UsageHandler{usage: usage, logger: logger}
+}
+
+// GetMyUsage handles GET /api/usage/me?from=&to= and responds with the caller's usage per
+// service and per day. Dates are UTC days (YYYY-MM-DD), both included, and default to the
+// current month.
+func (h *UsageHandler) GetMyUsage(c *gin.Context) {
+	from, to, ok := parseUsageRange(c)
+	if !ok {
+		return
+	}
+
+	summary, err := h.usage.Summary(c.Request.Context(), MustUserID(c), from, to)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("usage summary failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to load usage"))
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// ListUsage handles GET /api/admin/usage?from=&to=&group=&limit= and responds with the
+// usage of every user (group=user, the default), role (group=role) or user and role pair
+// (group=user_role), heaviest token users first.
+func (h *UsageHandler) ListUsage(c *gin.Context) {
+	from, to, ok := parseUsageRange(c)
+	if !ok {
+		return
+	}
+	group := strings.TrimSpace(c.DefaultQuery("group", db.UsageByUser))
+	if group != db.UsageByUser && group != db.UsageByRole && group != db.UsageByUserRole {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "group must be user, role or user_role"))
+		return
+	}
+	limit := defaultUsageGroups
+	if raw := strings.TrimSpace(c.Query("limit")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, "limit must be a positive integer"))
+			return
+		}
+		limit = min(parsed, maxUsageGroups)
+	}
+
+	groups, err := h.usage.Aggregate(c.Request.Context(), group, from, to, limit)
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("usage aggregate failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeInternal, "failed to load usage"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":  from.Format(time.DateOnly),
+		"to":    to.Format(time.DateOnly),
+		"group": group,
+		"usage": groups,
+	})
+}
+
+// parseUsageRange reads the from and to query dates, defaulting to the current UTC month
+// up to today. It writes 400 for malformed, reversed or overlong ranges.
+func parseUsageRange(c *gin.Context) (time.Time, time.Time, bool) {
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	for _, bound := range []struct {
+		name string
+		dest *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		raw := strings.TrimSpace(c.Query(bound.name))
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, bound.name+" must be a date (YYYY-MM-DD)"))
+			return time.Time{}, time.Time{}, false
+		}
+		*bound.dest = parsed
+	}
+	if to.Before(from) {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "from must not be after to"))
+		return time.Time{}, time.Time{}, false
+	}
+	if to.Sub(from) >= maxUsageRangeDays*24*time.Hour {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "date range is limited to "+strconv.Itoa(maxUsageRangeDays)+" days"))
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// chatUsage builds the usage event of a chat completion; usage is nil when the provider
+// did not report token counts.
+func chatUsage(userID string, roleID int64, usage *services.NLPUsage) db.UsageEvent {
+	event := db.UsageEvent{UserID: userID, RoleID: roleID, Service: db.UsageServiceChat}
+	if usage != nil {
+		event.PromptTokens = int64(usage.PromptTokens)
+		event.CompletionTokens = int64(usage.CompletionTokens)
+	}
+	return event
+}
+
+// ttsUsage builds the usage event of synthesizing text.
+func ttsUsage(userID string, roleID int64, text string) db.UsageEvent {
+	return db.UsageEvent{UserID: userID, RoleID: roleID, Service: db.UsageServiceTTS, TTSCharacters: int64(utf8.RuneCountInString(text))}
+}
+
+// asrUsage builds the usage event of transcribing durationMS of audio.
+func asrUsage(userID string, roleID int64, durationMS int) db.UsageEvent {
+	return db.UsageEvent{UserID: userID, RoleID: roleID, Service: db.UsageServiceASR, ASRMillis: int64(durationMS)}
+}
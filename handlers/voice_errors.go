@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+// voiceErrorMessages holds user-facing explanations per pipeline stage, keyed by language.
+var voiceErrorMessages = map[string]map[string]string{
+	"zh": {
+		services.VoiceStageASR:  "没有听清你说的话，请再说一遍。",
+		services.VoiceStageChat: "角色暂时无法回复，请稍后再试。",
+		services.VoiceStageTTS:  "回复已生成，但语音合成失败。",
+		"capacity":              "语音识别繁忙，请稍后重试。",
+		"no_speech":             "没有检测到语音，请靠近麦克风再说一次。",
+		"":                      "语音对话出现问题，请稍后再试。",
+	},
+	"en": {
+		services.VoiceStageASR:  "We couldn't make out what you said. Please try again.",
+		services.VoiceStageChat: "The character can't reply right now. Please try again shortly.",
+		services.VoiceStageTTS:  "The reply was generated but could not be spoken.",
+		"capacity":              "Speech recognition is busy. Please retry in a moment.",
+		"no_speech":             "No speech was detected. Please move closer to the microphone.",
+		"":                      "Something went wrong with the voice conversation.",
+	},
+}
+
+// describeVoiceError builds the structured error fields shared by the REST and WebSocket
+// voice endpoints: the failing stage, a short code, a localized message and whether
+// retrying the turn is likely to help.
+func describeVoiceError(err error, language string) gin.H {
+	stage, code, retryable := "", "", false
+
+	var stageErr *services.VoiceStageError
+	if errors.As(err, &stageErr) {
+		stage = stageErr.Stage
+		retryable = stageErr.Retryable()
+	}
+
+	messageKey := stage
+	switch {
+	case errors.Is(err, services.ErrTooManyStreams):
+		code, messageKey = "capacity", "capacity"
+	case errors.Is(err, services.ErrNoSpeech):
+		code, messageKey = "no_speech", "no_speech"
+	case errors.Is(err, services.ErrUpstreamUnavailable):
+		code = "upstream"
+	case statusFromError(err) == http.StatusGatewayTimeout:
+		code = "timeout"
+	default:
+		var upstream *services.UpstreamError
+		if errors.As(err, &upstream) {
+			code = "upstream"
+		}
+	}
+
+	return gin.H{
+		"stage":     stage,
+		"code":      code,
+		"message":   localizedVoiceMessage(messageKey, language),
+		"retryable": retryable,
+	}
+}
+
+func localizedVoiceMessage(key, language string) string {
+	lang := strings.ToLower(strings.TrimSpace(language))
+	if idx := strings.IndexAny(lang, "-_,;"); idx >= 0 {
+		lang = lang[:idx]
+	}
+	messages, ok := voiceErrorMessages[lang]
+	if !ok {
+		messages = voiceErrorMessages["zh"]
+	}
+	if message, ok := messages[key]; ok {
+		return message
+	}
+	return messages[""]
+}
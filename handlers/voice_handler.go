@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/ratelimit"
+	"github.com/wuwenbin0122/wwb.ai/services"
+	"github.com/wuwenbin0122/wwb.ai/tokenresolver"
+	"go.uber.org/zap"
+)
+
+// VoiceHandler serves the combined ASR → chat → TTS endpoints.
+type VoiceHandler struct {
+	cfg       *config.Config
+	roles     db.RoleRepository
+	pipeline  *services.VoicePipeline
+	usage     *db.UsageStore
+	limiter   *ratelimit.Limiter
+	languages LanguagePreferences
+	tokens    *tokenresolver.Resolver
+	logger    *zap.SugaredLogger
+}
+
+// NewVoiceHandler builds a new VoiceHandler; usage may be nil to skip usage accounting,
+// limiter nil to skip rate limiting and languages nil to ignore profile language preferences.
+// limiter should be the one the audio endpoints use, so a voice turn draws on the same
+// per-caller asr and tts buckets as separate /api/audio calls.
+func NewVoiceHandler(cfg *config.Config, roles db.RoleRepository, pipeline *services.VoicePipeline, usage *db.UsageStore, limiter *ratelimit.Limiter, languages LanguagePreferences, logger *zap.SugaredLogger) *VoiceHandler {
+	return &VoiceHandler{cfg: cfg, roles: roles, pipeline: pipeline, usage: usage, limiter: limiter, languages: languages, tokens: tokenresolver.New(cfg.QiniuTokenMode, cfg.QiniuAPIKey), logger: logger}
+}
+
+type voiceChatRequest struct {
+	Token           string              `json:"token"`
+	RoleID          int64               `json:"role_id"`
+	Language        string              `json:"language"`
+	Messages        []nlpMessagePayload `json:"messages"`
+	EnabledSkillIDs []string            `json:"enabled_skill_ids"`
+	AudioURL        string              `json:"audio_url"`
+	AudioBase64     string              `json:"audio_base64"`
+	AudioFormat     string              `json:"audio_format"`
+	SampleRate      int                 `json:"sample_rate"`
+	Channels        int                 `json:"channels"`
+	Bits            int                 `json:"bits"`
+	VoiceType       string              `json:"voice_type"`
+	Encoding        string              `json:"encoding"`
+	SpeedRatio      float64             `json:"speed_ratio"`
+	PitchRatio      float64             `json:"pitch_ratio"`
+	VolumeRatio     float64             `json:"volume_ratio"`
+	Emotion         string              `json:"emotion"`
+	TimeoutMS       int                 `json:"timeout_ms"`
+	ResponseFormat  string              `json:"response_format"`
+}
+
+// HandleVoiceChat transcribes the submitted audio, generates the role's reply and returns it as text and speech.
+func (h *VoiceHandler) HandleVoiceChat(c *gin.Context) {
+	var payload voiceChatRequest
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid request payload"))
+		return
+	}
+
+	if payload.RoleID <= 0 {
+		writeError(c, apierr.New(apierr.CodeInvalidRequest, "role_id is required"))
+		return
+	}
+	if _, err := services.NormalizeResponseFormat(payload.ResponseFormat); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid response_format"))
+		return
+	}
+
+	speech := services.TTSRequest{
+		VoiceType:   payload.VoiceType,
+		Encoding:    payload.Encoding,
+		SpeedRatio:  payload.SpeedRatio,
+		PitchRatio:  payload.PitchRatio,
+		VolumeRatio: payload.VolumeRatio,
+		Emotion:     payload.Emotion,
+	}
+	if err := speech.Validate(); err != nil {
+		writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid tts options"))
+		return
+	}
+
+	audio := services.ASRInput{
+		Format:     payload.AudioFormat,
+		URL:        strings.TrimSpace(payload.AudioURL),
+		SampleRate: payload.SampleRate,
+		Channels:   payload.Channels,
+		Bits:       payload.Bits,
+	}
+	if audio.URL == "" {
+		encoded := strings.TrimSpace(payload.AudioBase64)
+		if encoded == "" {
+			writeError(c, apierr.New(apierr.CodeInvalidRequest, "audio_url or audio_base64 is required"))
+			return
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			writeError(c, apierr.Wrap(err, apierr.CodeInvalidRequest, "invalid audio_base64"))
+			return
+		}
+		audio.Data = data
+	}
+
+	token := h.resolveToken(c, payload.Token)
+	if token == "" {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+	// a turn runs both a transcription and a synthesis upstream
+	if !allowRateLimited(c, h.limiter, "asr", token) || !allowRateLimited(c, h.limiter, "tts", token) {
+		return
+	}
+
+	timeout := 90 * time.Second
+	if payload.TimeoutMS > 0 {
+		timeout = time.Duration(payload.TimeoutMS) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	role, err := h.roles.GetByID(ctx, payload.RoleID)
+	if err == nil && !role.VisibleTo(currentUserID(c)) {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(c, apierr.New(apierr.CodeRoleNotFound, "role not found"))
+			return
+		}
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("fetch role failed: %v", err)
+		writeError(c, apierr.Wrap(err, apierr.CodeUpstream, "failed to load role"))
+		return
+	}
+
+	language, languageSource := negotiateLanguage(ctx, h.languages, h.logger, currentUserID(c), payload.Language, c.GetHeader("Accept-Language"), role.Languages)
+
+	audio.Language = language
+	audio.Hotwords = services.RoleHotwords(*role)
+
+	result, err := h.pipeline.RunTurn(ctx, token, services.VoiceTurnRequest{
+		Audio: audio,
+		Chat: services.NLPRequest{
+			Role:            *role,
+			Language:        language,
+			History:         normalizeNLPMessages(payload.Messages),
+			EnabledSkillIDs: payload.EnabledSkillIDs,
+			ResponseFormat:  payload.ResponseFormat,
+		},
+		Speech: h.pipeline.VoiceFor(speech, role),
+	})
+	if err != nil {
+		ctxlog.From(c.Request.Context(), h.logger).Warnf("voice chat failed: %v", err)
+		described := describeVoiceError(err, language)
+		// the localized message is meant for the user, so it replaces the generic one
+		apiErr := apierr.Wrap(err, apierr.CodeUpstream, described["message"].(string)).
+			With("stage", described["stage"]).
+			With("retryable", described["retryable"])
+		writeError(c, apiErr)
+		return
+	}
+	userID := currentUserID(c)
+	h.usage.Record(asrUsage(userID, role.ID, result.Transcript.DurationMS))
+	h.usage.Record(chatUsage(userID, role.ID, result.Reply.Usage))
+	h.usage.Record(ttsUsage(userID, role.ID, services.SanitizeSpeechText(result.Reply.SpeechText())))
+
+	response := gin.H{
+		"transcript":        result.Transcript.Text,
+		"transcript_reqid":  result.Transcript.ReqID,
+		"reply":             result.Reply.Reply,
+		"usage":             result.Reply.Usage,
+		"enabled_skill_ids": result.Reply.EnabledSkillIDs,
+		"audio":             base64.StdEncoding.EncodeToString(result.Speech.Audio),
+		"duration":          result.Speech.Duration,
+		"tts_reqid":         result.Speech.ReqID,
+		"structured":        result.Reply.Structured != nil,
+		"language":          language,
+		"language_source":   languageSource,
+	}
+	if result.Reply.Structured != nil {
+		response["structured_reply"] = result.Reply.Structured
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *VoiceHandler) resolveToken(c *gin.Context, explicit string) string {
+	return resolveQiniuToken(c, h.tokens, h.logger, explicit)
+}
+
+func (h *VoiceHandler) resolveTokenFromQuery(c *gin.Context) string {
+	return h.resolveToken(c, c.Query("token"))
+}
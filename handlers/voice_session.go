@@ -0,0 +1,503 @@
+package handlers
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+// Voice session states reported to the client through "state" events.
+const (
+	voiceStateListening = "listening"
+	voiceStateThinking  = "thinking"
+	voiceStateSpeaking  = "speaking"
+)
+
+// maxVoiceSessionHistory bounds the in-memory conversation kept per session.
+const maxVoiceSessionHistory = 40
+
+type voiceSessionMessage struct {
+	Type            string   `json:"type"`
+	Token           string   `json:"token"`
+	RoleID          int64    `json:"role_id"`
+	Language        string   `json:"language"`
+	EnabledSkillIDs []string `json:"enabled_skill_ids"`
+	VoiceType       string   `json:"voice_type"`
+	Encoding        string   `json:"encoding"`
+	SpeedRatio      float64  `json:"speed_ratio"`
+	PitchRatio      float64  `json:"pitch_ratio"`
+	VolumeRatio     float64  `json:"volume_ratio"`
+	Emotion         string   `json:"emotion"`
+	SampleRate      int      `json:"sampleRate"`
+	Channels        int      `json:"channels"`
+	Bits            int      `json:"bits"`
+}
+
+// voiceSession holds the per-connection state of a full-duplex voice conversation.
+type voiceSession struct {
+	h    *VoiceHandler
+	conn *websocket.Conn
+	ctx  context.Context
+
+	writeMu sync.Mutex
+
+	mu     sync.Mutex
+	token  string
+	userID string
+	// acceptLanguage is the Accept-Language header of the upgrade request.
+	acceptLanguage string
+	settings       voiceSessionMessage
+	role           *models.Role
+	language       string
+	history        []services.NLPMessage
+	stream         *services.ASRStream
+	turnCancel     context.CancelFunc
+	turnID         uint64
+	audioSeq       uint32
+	lastPartial    string
+}
+
+// HandleVoiceSession runs a full-duplex voice conversation over a single WebSocket.
+// The client streams PCM audio as binary frames; the server answers with JSON events
+// (transcript, reply, state) and synthesized speech as binary frames prefixed with a
+// 4-byte big-endian sequence number.
+func (h *VoiceHandler) HandleVoiceSession(c *gin.Context) {
+	token := h.resolveTokenFromQuery(c)
+	if token == "" {
+		writeError(c, apierr.New(apierr.CodeTokenMissing, "qiniu token is required"))
+		return
+	}
+
+	conn, err := asrUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		h.logger.Warnf("voice session websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	session := &voiceSession{h: h, conn: conn, ctx: ctx, token: token, userID: currentUserID(c), acceptLanguage: c.GetHeader("Accept-Language")}
+	session.settings.RoleID, _ = strconv.ParseInt(strings.TrimSpace(c.Query("role_id")), 10, 64)
+	session.settings.Language = strings.TrimSpace(c.Query("language"))
+	session.settings.VoiceType = strings.TrimSpace(c.Query("voice_type"))
+	session.settings.Encoding = strings.TrimSpace(c.Query("encoding"))
+	if skills := strings.TrimSpace(c.Query("skills")); skills != "" {
+		session.settings.EnabledSkillIDs = strings.Split(skills, ",")
+	}
+	defer session.close()
+
+	for {
+		msgType, payload, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				h.logger.Warnf("client voice session closed: %v", err)
+			}
+			return
+		}
+
+		switch msgType {
+		case websocket.TextMessage:
+			var msg voiceSessionMessage
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				session.sendError("invalid control message", err)
+				continue
+			}
+			session.handleControl(msg)
+		case websocket.BinaryMessage:
+			session.handleAudio(payload)
+		}
+	}
+}
+
+func (s *voiceSession) handleControl(msg voiceSessionMessage) {
+	switch strings.ToLower(strings.TrimSpace(msg.Type)) {
+	case "start":
+		s.start(msg)
+	case "utterance_end", "stop":
+		s.endUtterance()
+	case "barge-in", "barge_in":
+		s.cancelTurn()
+		s.sendJSON(gin.H{"type": "barge_in"})
+		s.sendState(voiceStateListening)
+	case "ping":
+		s.sendJSON(gin.H{"type": "pong"})
+	default:
+		s.sendError("unsupported control message", fmt.Errorf("%s", msg.Type))
+	}
+}
+
+func (s *voiceSession) start(msg voiceSessionMessage) {
+	s.mu.Lock()
+	settings := s.settings
+	s.mu.Unlock()
+
+	if msg.RoleID > 0 {
+		settings.RoleID = msg.RoleID
+	}
+	if v := strings.TrimSpace(msg.Language); v != "" {
+		settings.Language = v
+	}
+	if len(msg.EnabledSkillIDs) > 0 {
+		settings.EnabledSkillIDs = msg.EnabledSkillIDs
+	}
+	if v := strings.TrimSpace(msg.VoiceType); v != "" {
+		settings.VoiceType = v
+	}
+	if v := strings.TrimSpace(msg.Encoding); v != "" {
+		settings.Encoding = v
+	}
+	if msg.SpeedRatio > 0 {
+		settings.SpeedRatio = msg.SpeedRatio
+	}
+	if msg.PitchRatio > 0 {
+		settings.PitchRatio = msg.PitchRatio
+	}
+	if msg.VolumeRatio > 0 {
+		settings.VolumeRatio = msg.VolumeRatio
+	}
+	if v := strings.TrimSpace(msg.Emotion); v != "" {
+		settings.Emotion = v
+	}
+	if err := (services.TTSRequest{SpeedRatio: settings.SpeedRatio, PitchRatio: settings.PitchRatio, VolumeRatio: settings.VolumeRatio, Emotion: settings.Emotion}).Validate(); err != nil {
+		s.sendError("invalid tts options", err)
+		return
+	}
+	settings.SampleRate, settings.Channels, settings.Bits = msg.SampleRate, msg.Channels, msg.Bits
+	if settings.SampleRate <= 0 {
+		settings.SampleRate = 16000
+	}
+	if settings.Channels <= 0 {
+		settings.Channels = 1
+	}
+	if settings.Bits <= 0 {
+		settings.Bits = 16
+	}
+
+	if settings.RoleID <= 0 {
+		s.sendError("role_id is required", nil)
+		return
+	}
+
+	role, err := s.h.roles.GetByID(s.ctx, settings.RoleID)
+	if err == nil && !role.VisibleTo(s.userID) {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			s.sendError("role not found", nil)
+			return
+		}
+		s.sendError("failed to load role", err)
+		return
+	}
+
+	language, languageSource := negotiateLanguage(s.ctx, s.h.languages, s.h.logger, s.userID, settings.Language, s.acceptLanguage, role.Languages)
+
+	s.mu.Lock()
+	if candidate := strings.TrimSpace(msg.Token); candidate != "" && s.h.tokens.AcceptsClientTokens() {
+		s.token = candidate
+	}
+	s.settings = settings
+	s.role = role
+	s.language = language
+	s.history = nil
+	s.mu.Unlock()
+
+	s.sendJSON(gin.H{
+		"type":            "ready",
+		"role_id":         role.ID,
+		"language":        language,
+		"language_source": languageSource,
+		"sampleRate":      settings.SampleRate,
+		"channels":        settings.Channels,
+		"bits":            settings.Bits,
+	})
+	s.sendState(voiceStateListening)
+}
+
+func (s *voiceSession) handleAudio(chunk []byte) {
+	s.mu.Lock()
+	if s.role == nil {
+		s.mu.Unlock()
+		s.sendError("session not started", errors.New("start message required before audio"))
+		return
+	}
+	stream := s.stream
+	if stream == nil {
+		opened, err := s.h.pipeline.OpenStream(s.ctx, s.token, services.ASRStreamOptions{
+			SampleRate: s.settings.SampleRate,
+			Channels:   s.settings.Channels,
+			Bits:       s.settings.Bits,
+			Language:   s.language,
+			Hotwords:   services.RoleHotwords(*s.role),
+		})
+		if err != nil {
+			s.mu.Unlock()
+			s.sendError("open upstream stream", err)
+			return
+		}
+		stream = opened
+		s.stream = opened
+		s.lastPartial = ""
+		go s.readUpstream(opened)
+	}
+	s.mu.Unlock()
+
+	if err := stream.SendAudioChunk(chunk); err != nil {
+		s.sendError("forward audio chunk", err)
+		s.finishUtterance(stream, "")
+	}
+}
+
+// endUtterance asks the upstream recognizer to finalize; the transcript arrives through readUpstream.
+func (s *voiceSession) endUtterance() {
+	s.mu.Lock()
+	stream := s.stream
+	s.mu.Unlock()
+
+	if stream == nil {
+		return
+	}
+	if err := stream.SendStop(); err != nil {
+		s.sendError("send stop", err)
+		s.finishUtterance(stream, "")
+	}
+}
+
+func (s *voiceSession) readUpstream(stream *services.ASRStream) {
+	for {
+		received, err := stream.Recv()
+		if err != nil {
+			s.mu.Lock()
+			text := s.lastPartial
+			current := s.stream == stream
+			s.mu.Unlock()
+			if current {
+				s.finishUtterance(stream, text)
+			}
+			return
+		}
+		if received.Err != nil {
+			s.h.logger.Warnf("voice session parse upstream payload: %v", received.Err)
+			services.DebugHexDump(s.h.logger, "unparseable asr frame", received.Payload)
+			continue
+		}
+		transcript := received.Transcript
+		if transcript.Text == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		s.lastPartial = transcript.Text
+		s.mu.Unlock()
+
+		event := gin.H{"type": "transcript", "text": transcript.Text, "is_final": transcript.IsFinal}
+		if transcript.Confidence > 0 {
+			event["confidence"] = transcript.Confidence
+		}
+		if len(transcript.Words) > 0 {
+			event["words"] = transcript.Words
+		}
+		s.sendJSON(event)
+		if transcript.IsFinal {
+			s.mu.Lock()
+			roleID := s.role.ID
+			s.mu.Unlock()
+			s.h.usage.Record(asrUsage(s.userID, roleID, transcript.DurationMS))
+			// an upstream final result (VAD endpoint or reply to stop) closes the utterance
+			s.finishUtterance(stream, transcript.Text)
+			return
+		}
+	}
+}
+
+// finishUtterance retires the ASR stream and, when there is a transcript, starts a new turn.
+func (s *voiceSession) finishUtterance(stream *services.ASRStream, text string) {
+	s.mu.Lock()
+	if s.stream != stream {
+		s.mu.Unlock()
+		return
+	}
+	s.stream = nil
+	s.lastPartial = ""
+	s.mu.Unlock()
+	_ = stream.Close()
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		s.sendState(voiceStateListening)
+		return
+	}
+	s.startTurn(text)
+}
+
+func (s *voiceSession) startTurn(text string) {
+	s.cancelTurn()
+
+	s.mu.Lock()
+	turnCtx, cancel := context.WithCancel(s.ctx)
+	s.turnCancel = cancel
+	s.turnID++
+	turnID := s.turnID
+	role := *s.role
+	language := s.language
+	settings := s.settings
+	token := s.token
+	history := append([]services.NLPMessage(nil), s.history...)
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			if s.turnID == turnID {
+				s.turnCancel = nil
+			}
+			s.mu.Unlock()
+			cancel()
+		}()
+
+		s.sendState(voiceStateThinking)
+		reply, err := s.h.pipeline.Respond(turnCtx, token, services.NLPRequest{
+			Role:            role,
+			Language:        language,
+			History:         history,
+			UserMessage:     text,
+			EnabledSkillIDs: settings.EnabledSkillIDs,
+		})
+		if err != nil {
+			if turnCtx.Err() == nil {
+				s.sendError("chat completion failed", err)
+				s.sendState(voiceStateListening)
+			}
+			return
+		}
+		s.h.usage.Record(chatUsage(s.userID, role.ID, reply.Usage))
+
+		s.appendHistory(
+			services.NLPMessage{Role: "user", Content: text},
+			services.NLPMessage{Role: "assistant", Content: reply.Reply.Content},
+		)
+		s.sendJSON(gin.H{"type": "reply", "text": reply.Reply.Content})
+
+		s.sendState(voiceStateSpeaking)
+		// synthesize sentence by sentence so playback starts early and barge-in takes effect quickly
+		for _, sentence := range services.SplitSentences(services.SanitizeSpeechText(reply.SpeechText())) {
+			speech, err := s.h.pipeline.Speak(turnCtx, token, s.h.pipeline.VoiceFor(services.TTSRequest{
+				Text:        sentence,
+				VoiceType:   settings.VoiceType,
+				Encoding:    settings.Encoding,
+				SpeedRatio:  settings.SpeedRatio,
+				PitchRatio:  settings.PitchRatio,
+				VolumeRatio: settings.VolumeRatio,
+				Emotion:     settings.Emotion,
+			}, &role))
+			if turnCtx.Err() != nil {
+				return
+			}
+			if err != nil {
+				s.sendError("tts processing failed", err)
+				break
+			}
+			s.h.usage.Record(ttsUsage(s.userID, role.ID, sentence))
+			if err := s.sendAudio(speech.Audio); err != nil {
+				return
+			}
+		}
+
+		if turnCtx.Err() == nil {
+			s.sendJSON(gin.H{"type": "audio_end"})
+			s.sendState(voiceStateListening)
+		}
+	}()
+}
+
+func (s *voiceSession) cancelTurn() {
+	s.mu.Lock()
+	cancel := s.turnCancel
+	s.turnCancel = nil
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (s *voiceSession) appendHistory(msgs ...services.NLPMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append(s.history, msgs...)
+	if len(s.history) > maxVoiceSessionHistory {
+		s.history = append([]services.NLPMessage(nil), s.history[len(s.history)-maxVoiceSessionHistory:]...)
+	}
+}
+
+func (s *voiceSession) close() {
+	s.cancelTurn()
+	s.mu.Lock()
+	stream := s.stream
+	s.stream = nil
+	s.mu.Unlock()
+	if stream != nil {
+		_ = stream.Close()
+	}
+}
+
+func (s *voiceSession) sendAudio(audio []byte) error {
+	s.mu.Lock()
+	s.audioSeq++
+	seq := s.audioSeq
+	s.mu.Unlock()
+
+	frame := make([]byte, 4+len(audio))
+	binary.BigEndian.PutUint32(frame[:4], seq)
+	copy(frame[4:], audio)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+func (s *voiceSession) sendJSON(payload interface{}) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.conn.WriteJSON(payload); err != nil {
+		s.h.logger.Warnf("send voice session event failed: %v", err)
+	}
+}
+
+func (s *voiceSession) sendState(state string) {
+	s.sendJSON(gin.H{"type": "state", "state": state})
+}
+
+func (s *voiceSession) sendError(message string, detail error) {
+	errMsg := gin.H{"type": "error", "error": message}
+	if detail != nil {
+		errMsg["detail"] = detail.Error()
+		var stageErr *services.VoiceStageError
+		if errors.As(detail, &stageErr) {
+			s.mu.Lock()
+			language := s.language
+			s.mu.Unlock()
+			for key, value := range describeVoiceError(detail, language) {
+				errMsg[key] = value
+			}
+		}
+		s.h.logger.Warnf("voice session error: %s: %v", message, detail)
+	} else {
+		s.h.logger.Warnf("voice session error: %s", message)
+	}
+	s.sendJSON(errMsg)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultSendQueueSize = 64
+	wsWriteTimeout       = 10 * time.Second
+)
+
+// wsSendQueue serializes JSON frames to one client connection through a bounded queue
+// drained by a single writer goroutine, so producers never write to the socket directly.
+// Droppable frames (interim transcripts) are discarded when the client falls behind;
+// other frames wait for room until the connection context ends.
+type wsSendQueue struct {
+	conn   *websocket.Conn
+	ctx    context.Context
+	frames chan interface{}
+	done   chan struct{}
+	onErr  func(error)
+
+	// mu guards closed: senders hold the read lock so Close cannot close frames under them.
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+}
+
+func newWSSendQueue(ctx context.Context, conn *websocket.Conn, size int, onErr func(error)) *wsSendQueue {
+	if size <= 0 {
+		size = defaultSendQueueSize
+	}
+	q := &wsSendQueue{
+		conn:   conn,
+		ctx:    ctx,
+		frames: make(chan interface{}, size),
+		done:   make(chan struct{}),
+		onErr:  onErr,
+	}
+	go q.run()
+	return q
+}
+
+// Send queues a frame that must be delivered, waiting for room if necessary.
+func (q *wsSendQueue) Send(payload interface{}) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.frames <- payload:
+		return true
+	case <-q.ctx.Done():
+		return false
+	}
+}
+
+// Offer queues a frame only if there is room, reporting whether it was accepted.
+func (q *wsSendQueue) Offer(payload interface{}) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.frames <- payload:
+		return true
+	default:
+		q.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped reports how many frames Offer discarded.
+func (q *wsSendQueue) Dropped() int64 {
+	return q.dropped.Load()
+}
+
+// Close stops accepting frames and waits until the queued ones are written.
+func (q *wsSendQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.frames)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+func (q *wsSendQueue) run() {
+	defer close(q.done)
+	failed := false
+	for payload := range q.frames {
+		if failed {
+			continue
+		}
+		_ = q.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := q.conn.WriteJSON(payload); err != nil {
+			failed = true
+			if q.onErr != nil {
+				q.onErr(err)
+			}
+		}
+	}
+}
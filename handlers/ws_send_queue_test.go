@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type queuedFrame struct {
+	Kind     string `json:"kind"`
+	Producer int    `json:"producer"`
+	Seq      int    `json:"seq"`
+	Padding  string `json:"padding,omitempty"`
+}
+
+// TestWSSendQueueDropsInterimFramesForSlowClient has several producers share one queue
+// while the client stalls: every Send frame must arrive in per-producer order, and each
+// Offer frame is either delivered or counted as dropped.
+func TestWSSendQueueDropsInterimFramesForSlowClient(t *testing.T) {
+	const producers, finals, interims = 4, 8, 200
+	// large frames fill the socket buffers so the writer blocks while the client stalls
+	padding := strings.Repeat("x", 256<<10)
+
+	offered := make(chan int64, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := asrUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		queue := newWSSendQueue(context.Background(), conn, 4, func(err error) { t.Errorf("write: %v", err) })
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var accepted int64
+		for p := range producers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range interims {
+					if queue.Offer(queuedFrame{Kind: "interim", Producer: p, Seq: i}) {
+						mu.Lock()
+						accepted++
+						mu.Unlock()
+					}
+					if i%(interims/finals) == 0 {
+						if !queue.Send(queuedFrame{Kind: "final", Producer: p, Seq: i / (interims / finals), Padding: padding}) {
+							t.Errorf("Send of producer %d was refused", p)
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		queue.Close()
+		if queue.Send(queuedFrame{Kind: "final"}) || queue.Offer(queuedFrame{Kind: "interim"}) {
+			t.Error("closed queue accepted a frame")
+		}
+		if got := accepted + queue.Dropped(); got != producers*interims {
+			t.Errorf("accepted %d + dropped %d interim frames, want %d", accepted, queue.Dropped(), producers*interims)
+		}
+		offered <- queue.Dropped()
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	next := make(map[int]int)
+	for {
+		var frame queuedFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("read: %v", err)
+			}
+			break
+		}
+		if frame.Kind != "final" {
+			continue
+		}
+		if frame.Seq != next[frame.Producer] {
+			t.Fatalf("producer %d final %d arrived, want %d", frame.Producer, frame.Seq, next[frame.Producer])
+		}
+		next[frame.Producer]++
+	}
+	for p := range producers {
+		if next[p] != finals {
+			t.Errorf("producer %d delivered %d finals, want %d", p, next[p], finals)
+		}
+	}
+	if dropped := <-offered; dropped == 0 {
+		t.Error("no interim frame was dropped while the client stalled")
+	}
+}
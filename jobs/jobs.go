@@ -0,0 +1,203 @@
+// Package jobs runs background work outside of requests: handlers are registered per job
+// type at startup, jobs are enqueued by type with a JSON payload, and each type is worked
+// by its own pool on every instance. Queued and running jobs are kept in Redis, so jobs
+// queued before a restart, or running on an instance that died, are picked up again.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+)
+
+// leaseGrace is how long past its timeout a running job stays leased before another
+// instance may take it over.
+const leaseGrace = time.Minute
+
+// Outcomes of an attempt, as reported to the Observer.
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeRetried   = "retried"
+	OutcomeFailed    = "failed"
+	OutcomeRequeued  = "requeued"
+)
+
+// ErrUnknownType is returned by Enqueue for job types without a registered handler.
+var ErrUnknownType = errors.New("unknown job type")
+
+// Job is one unit of queued work.
+type Job struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	// Attempt counts the attempts started so far, this one included.
+	Attempt    int       `json:"attempt"`
+	LastError  string    `json:"last_error,omitempty"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+// Decode unmarshals the payload into v.
+func (j Job) Decode(v any) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return fmt.Errorf("decode %s job payload: %w", j.Type, err)
+	}
+	return nil
+}
+
+// Handler processes a job. A returned error schedules a retry unless it is Permanent or
+// the attempts are used up. ctx is cancelled when the attempt times out or the instance
+// shuts down; a job interrupted by shutdown is queued again without using an attempt.
+type Handler func(ctx context.Context, job Job) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// TypeOptions tunes the handling of one job type. Zero values fall back to defaults.
+type TypeOptions struct {
+	Workers     int           // jobs of the type run at once per instance (default 2)
+	MaxAttempts int           // attempts before a job is given up (default 5)
+	Timeout     time.Duration // bound on one attempt (default 10m)
+}
+
+func (o TypeOptions) withDefaults() TypeOptions {
+	if o.Workers <= 0 {
+		o.Workers = 2
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Minute
+	}
+	return o
+}
+
+// Observer receives queue measurements, typically to export them as metrics.
+type Observer interface {
+	// Depth reports the jobs of jobType waiting in the queue and running on this instance.
+	Depth(jobType string, queued, running int)
+	// Done reports a finished attempt and how long it ran.
+	Done(jobType, outcome string, took time.Duration)
+}
+
+// Options tunes a Queue. Zero values fall back to defaults.
+type Options struct {
+	InitialBackoff time.Duration // delay before the first retry, doubling after (default 5s)
+	MaxBackoff     time.Duration // cap for the retry delay (default 10m)
+	PollInterval   time.Duration // how often the queue is checked without a local enqueue (default 1s)
+	// DrainTimeout is how long Run waits on shutdown for running jobs to finish before
+	// cancelling them (default 8s, inside the server's 10s shutdown budget).
+	DrainTimeout time.Duration
+	// DeadLetters is how many given-up jobs are kept per type for inspection (default 100).
+	DeadLetters int
+	Observer    Observer
+}
+
+func (o Options) withDefaults() Options {
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = 5 * time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 10 * time.Minute
+	}
+	if o.MaxBackoff < o.InitialBackoff {
+		o.MaxBackoff = o.InitialBackoff
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	if o.DrainTimeout <= 0 {
+		o.DrainTimeout = 8 * time.Second
+	}
+	if o.DeadLetters <= 0 {
+		o.DeadLetters = 100
+	}
+	return o
+}
+
+type typeEntry struct {
+	name    string
+	handler Handler
+	opts    TypeOptions
+	wake    chan struct{}
+}
+
+// Queue dispatches jobs kept in Redis to the registered handlers.
+type Queue struct {
+	kv     *kv.Store
+	client *redis.Client
+	opts   Options
+	logger *zap.SugaredLogger
+
+	mu      sync.Mutex
+	types   map[string]*typeEntry
+	started bool
+}
+
+// New builds a queue on store. Handlers are registered with Register before Run.
+func New(store *kv.Store, opts Options, logger *zap.SugaredLogger) *Queue {
+	return &Queue{kv: store, client: store.Client(), opts: opts.withDefaults(), logger: logger, types: make(map[string]*typeEntry)}
+}
+
+// Register sets the handler of jobType. It must be called before Run; later calls are
+// ignored.
+func (q *Queue) Register(jobType string, handler Handler, opts TypeOptions) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.started {
+		q.logger.Warnf("job type %s registered after start, ignoring", jobType)
+		return
+	}
+	q.types[jobType] = &typeEntry{name: jobType, handler: handler, opts: opts.withDefaults(), wake: make(chan struct{}, 1)}
+}
+
+// Enqueue queues a job of jobType with payload marshalled to JSON and returns its id.
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any) (string, error) {
+	q.mu.Lock()
+	entry, ok := q.types[jobType]
+	q.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("enqueue %s: %w", jobType, ErrUnknownType)
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("encode %s job payload: %w", jobType, err)
+	}
+	job := Job{ID: newJobID(), Type: jobType, Payload: raw, EnqueuedAt: time.Now().UTC()}
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return "", fmt.Errorf("encode %s job: %w", jobType, err)
+	}
+	if !q.kv.Available() {
+		return "", fmt.Errorf("enqueue %s job: %w", jobType, kv.ErrUnavailable)
+	}
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, q.jobKey(job.ID), encoded, 0)
+		pipe.ZAdd(ctx, q.queuedKey(jobType), redis.Z{Score: float64(time.Now().UnixMilli()), Member: job.ID})
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("enqueue %s job: %w", jobType, err)
+	}
+
+	select {
+	case entry.wake <- struct{}{}:
+	default:
+	}
+	return job.ID, nil
+}
@@ -0,0 +1,291 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// claimScript moves up to ARGV[3] due jobs from the queue (KEYS[1]) to the leased set
+// (KEYS[2]), scored by their lease expiry, and returns their ids.
+var claimScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[3]))
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  redis.call('ZADD', KEYS[2], tonumber(ARGV[1]) + tonumber(ARGV[2]), id)
+end
+return ids
+`)
+
+// reclaimScript queues again the jobs whose lease in KEYS[1] expired, left behind by an
+// instance that stopped without finishing them, and returns how many there were.
+var reclaimScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  redis.call('ZADD', KEYS[2], ARGV[1], id)
+end
+return #ids
+`)
+
+func (q *Queue) jobKey(id string) string             { return q.kv.Key(kv.Jobs, "job", id) }
+func (q *Queue) queuedKey(jobType string) string     { return q.kv.Key(kv.Jobs, "queued", jobType) }
+func (q *Queue) leasedKey(jobType string) string     { return q.kv.Key(kv.Jobs, "leased", jobType) }
+func (q *Queue) deadLetterKey(jobType string) string { return q.kv.Key(kv.Jobs, "dead", jobType) }
+
+func newJobID() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// Run works the queue until ctx is cancelled, then stops taking jobs and drains: running
+// jobs get DrainTimeout to finish, after which they are cancelled and queued again. It
+// returns ctx.Err() once every job has stopped.
+func (q *Queue) Run(ctx context.Context) error {
+	q.mu.Lock()
+	q.started = true
+	types := make([]*typeEntry, 0, len(q.types))
+	for _, entry := range q.types {
+		types = append(types, entry)
+	}
+	q.mu.Unlock()
+
+	// jobs run on their own context so shutdown can give them DrainTimeout before cancelling
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+
+	var running sync.WaitGroup
+	var dispatchers sync.WaitGroup
+	for _, entry := range types {
+		dispatchers.Add(1)
+		go func() {
+			defer dispatchers.Done()
+			q.dispatch(ctx, jobCtx, entry, &running)
+		}()
+	}
+	dispatchers.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(q.opts.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		q.logger.Warnf("jobs still running after %s, cancelling them", q.opts.DrainTimeout)
+		cancelJobs()
+		<-done
+	}
+	return ctx.Err()
+}
+
+// dispatch claims jobs of entry while it has free workers, until ctx is cancelled.
+func (q *Queue) dispatch(ctx, jobCtx context.Context, entry *typeEntry, running *sync.WaitGroup) {
+	slots := make(chan struct{}, entry.opts.Workers)
+	lease := entry.opts.Timeout + leaseGrace
+	ticker := time.NewTicker(q.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// while Redis is down the queue idles instead of logging a failed claim per poll
+		if q.kv.Available() {
+			q.reclaim(ctx, entry)
+			for free := cap(slots) - len(slots); free > 0; free = cap(slots) - len(slots) {
+				ids, err := claimScript.Run(ctx, q.client, []string{q.queuedKey(entry.name), q.leasedKey(entry.name)},
+					time.Now().UnixMilli(), lease.Milliseconds(), free).StringSlice()
+				if err != nil {
+					if ctx.Err() == nil {
+						q.logger.Warnf("claim %s jobs failed: %v", entry.name, err)
+					}
+					break
+				}
+				if len(ids) == 0 {
+					break
+				}
+				for _, id := range ids {
+					slots <- struct{}{}
+					running.Add(1)
+					go func() {
+						defer running.Done()
+						defer func() { <-slots }()
+						q.execute(jobCtx, entry, id)
+					}()
+				}
+			}
+			q.observeDepth(ctx, entry, len(slots))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-entry.wake:
+		}
+	}
+}
+
+func (q *Queue) reclaim(ctx context.Context, entry *typeEntry) {
+	n, err := reclaimScript.Run(ctx, q.client, []string{q.leasedKey(entry.name), q.queuedKey(entry.name)}, time.Now().UnixMilli()).Int()
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Warnf("reclaim %s jobs failed: %v", entry.name, err)
+		}
+		return
+	}
+	if n > 0 {
+		q.logger.Warnf("queued %d %s jobs again after their lease expired", n, entry.name)
+	}
+}
+
+func (q *Queue) observeDepth(ctx context.Context, entry *typeEntry, running int) {
+	if q.opts.Observer == nil {
+		return
+	}
+	queued, err := q.client.ZCard(ctx, q.queuedKey(entry.name)).Result()
+	if err != nil {
+		return
+	}
+	q.opts.Observer.Depth(entry.name, int(queued), running)
+}
+
+// execute runs one attempt of the job id and records its outcome.
+func (q *Queue) execute(ctx context.Context, entry *typeEntry, id string) {
+	// bookkeeping outlives a cancelled job context
+	store := context.WithoutCancel(ctx)
+
+	raw, err := q.client.Get(store, q.jobKey(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			q.client.ZRem(store, q.leasedKey(entry.name), id)
+			return
+		}
+		q.logger.Warnf("load %s job %s failed, its lease will expire: %v", entry.name, id, err)
+		return
+	}
+	var job Job
+	if err := json.Unmarshal(raw, &job); err != nil {
+		q.logger.Errorf("decode %s job %s failed, dropping it: %v", entry.name, id, err)
+		q.finish(store, entry, id, raw)
+		return
+	}
+	job.Attempt++
+	// persist the attempt first so a crash mid-job still counts it
+	if err := q.save(store, job); err != nil {
+		q.logger.Warnf("save %s job %s failed: %v", entry.name, id, err)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, entry.opts.Timeout)
+	started := time.Now()
+	err = q.call(attemptCtx, entry, job)
+	took := time.Since(started)
+	cancel()
+
+	outcome := OutcomeSucceeded
+	var permanent permanentError
+	switch {
+	case err == nil:
+		q.finish(store, entry, id, nil)
+	case ctx.Err() != nil:
+		// cancelled by shutdown, not the job's fault
+		outcome = OutcomeRequeued
+		job.Attempt--
+		q.retry(store, entry, job, time.Now())
+		q.logger.Infof("%s job %s interrupted by shutdown, queued again", entry.name, id)
+	case errors.As(err, &permanent) || job.Attempt >= entry.opts.MaxAttempts:
+		outcome = OutcomeFailed
+		job.LastError = err.Error()
+		encoded, _ := json.Marshal(job)
+		q.finish(store, entry, id, encoded)
+		q.logger.Errorf("%s job %s failed after %d attempts: %v", entry.name, id, job.Attempt, err)
+	default:
+		outcome = OutcomeRetried
+		job.LastError = err.Error()
+		delay := q.backoff(job.Attempt)
+		q.retry(store, entry, job, time.Now().Add(delay))
+		q.logger.Warnf("%s job %s attempt %d failed, retrying in %s: %v", entry.name, id, job.Attempt, delay, err)
+	}
+	if q.opts.Observer != nil {
+		q.opts.Observer.Done(entry.name, outcome, took)
+	}
+}
+
+// call runs the handler, converting a panic into an error so it cannot take down the
+// worker pool.
+func (q *Queue) call(ctx context.Context, entry *typeEntry, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			q.logger.Errorf("%s job %s panicked: %v\n%s", entry.name, job.ID, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return entry.handler(ctx, job)
+}
+
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.opts.InitialBackoff
+	for i := 1; i < attempt && delay < q.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.opts.MaxBackoff {
+		delay = q.opts.MaxBackoff
+	}
+	return delay
+}
+
+func (q *Queue) save(ctx context.Context, job Job) error {
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.client.Set(ctx, q.jobKey(job.ID), encoded, 0).Err()
+}
+
+// retry saves job and queues it again at runAt.
+func (q *Queue) retry(ctx context.Context, entry *typeEntry, job Job, runAt time.Time) {
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		q.logger.Errorf("encode %s job %s failed: %v", entry.name, job.ID, err)
+		return
+	}
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, q.jobKey(job.ID), encoded, 0)
+		pipe.ZRem(ctx, q.leasedKey(entry.name), job.ID)
+		pipe.ZAdd(ctx, q.queuedKey(entry.name), redis.Z{Score: float64(runAt.UnixMilli()), Member: job.ID})
+		return nil
+	})
+	if err != nil {
+		q.logger.Warnf("requeue %s job %s failed, its lease will expire: %v", entry.name, job.ID, err)
+	}
+}
+
+// finish removes the job id; a non-nil deadLetter is kept in the type's dead letter list.
+func (q *Queue) finish(ctx context.Context, entry *typeEntry, id string, deadLetter []byte) {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, q.jobKey(id))
+		pipe.ZRem(ctx, q.leasedKey(entry.name), id)
+		if deadLetter != nil {
+			pipe.LPush(ctx, q.deadLetterKey(entry.name), deadLetter)
+			pipe.LTrim(ctx, q.deadLetterKey(entry.name), 0, int64(q.opts.DeadLetters-1))
+		}
+		return nil
+	})
+	if err != nil {
+		q.logger.Warnf("finish %s job %s failed: %v", entry.name, id, err)
+	}
+}
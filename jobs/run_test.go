@@ -0,0 +1,289 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// recordingObserver keeps the outcome of every finished attempt.
+type recordingObserver struct {
+	mu       sync.Mutex
+	outcomes []string
+}
+
+func (o *recordingObserver) Depth(string, int, int) {}
+
+func (o *recordingObserver) Done(_ string, outcome string, _ time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.outcomes = append(o.outcomes, outcome)
+}
+
+func (o *recordingObserver) Outcomes() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Clone(o.outcomes)
+}
+
+// newTestQueue builds a queue on a fresh miniredis that retries and polls within
+// milliseconds.
+func newTestQueue(t *testing.T, opts Options) (*Queue, *recordingObserver) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	observer := &recordingObserver{}
+	if opts.InitialBackoff == 0 {
+		opts.InitialBackoff = 5 * time.Millisecond
+	}
+	opts.PollInterval = 5 * time.Millisecond
+	opts.Observer = observer
+	return New(kv.New(client, "test"), opts, zap.NewNop().Sugar()), observer
+}
+
+// runQueue works q in the background; the returned stop cancels it and waits for Run.
+func runQueue(t *testing.T, q *Queue) (stop func() error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- q.Run(ctx) }()
+	var once sync.Once
+	var err error
+	stop = func() error {
+		once.Do(func() {
+			cancel()
+			err = <-result
+		})
+		return err
+	}
+	t.Cleanup(func() { _ = stop() })
+	return stop
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// deadLetters returns the given-up jobs of jobType, newest first.
+func deadLetters(t *testing.T, q *Queue, jobType string) []Job {
+	t.Helper()
+	raw, err := q.client.LRange(context.Background(), q.deadLetterKey(jobType), 0, -1).Result()
+	if err != nil {
+		t.Fatalf("read dead letters: %v", err)
+	}
+	jobs := make([]Job, len(raw))
+	for i, item := range raw {
+		if err := json.Unmarshal([]byte(item), &jobs[i]); err != nil {
+			t.Fatalf("decode dead letter: %v", err)
+		}
+	}
+	return jobs
+}
+
+func TestRetry(t *testing.T) {
+	q, observer := newTestQueue(t, Options{})
+	var mu sync.Mutex
+	var attempts []int
+	var payloads []string
+	q.Register("flaky", func(_ context.Context, job Job) error {
+		var payload struct{ Text string }
+		if err := job.Decode(&payload); err != nil {
+			return Permanent(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, job.Attempt)
+		payloads = append(payloads, payload.Text)
+		if job.Attempt < 3 {
+			return errors.New("upstream busy")
+		}
+		return nil
+	}, TypeOptions{MaxAttempts: 5})
+	runQueue(t, q)
+
+	id, err := q.Enqueue(context.Background(), "flaky", map[string]string{"Text": "hello"})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitFor(t, "the job to succeed", func() bool { return len(observer.Outcomes()) == 3 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(attempts, []int{1, 2, 3}) || !slices.Equal(payloads, []string{"hello", "hello", "hello"}) {
+		t.Errorf("attempts %v with payloads %v, want 1, 2, 3 with the enqueued payload", attempts, payloads)
+	}
+	if want := []string{OutcomeRetried, OutcomeRetried, OutcomeSucceeded}; !slices.Equal(observer.Outcomes(), want) {
+		t.Errorf("outcomes = %v, want %v", observer.Outcomes(), want)
+	}
+	if n, _ := q.client.Exists(context.Background(), q.jobKey(id)).Result(); n != 0 {
+		t.Error("a succeeded job is still stored")
+	}
+	if letters := deadLetters(t, q, "flaky"); len(letters) != 0 {
+		t.Errorf("dead letters = %+v, want none", letters)
+	}
+}
+
+func TestGivingUp(t *testing.T) {
+	cases := []struct {
+		name         string
+		err          error
+		wantOutcomes []string
+		wantAttempt  int
+	}{
+		{"attempts used up", errors.New("still failing"), []string{OutcomeRetried, OutcomeRetried, OutcomeFailed}, 3},
+		{"permanent error", Permanent(errors.New("bad input")), []string{OutcomeFailed}, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, observer := newTestQueue(t, Options{})
+			q.Register("doomed", func(context.Context, Job) error { return tc.err }, TypeOptions{MaxAttempts: 3})
+			runQueue(t, q)
+
+			if _, err := q.Enqueue(context.Background(), "doomed", nil); err != nil {
+				t.Fatalf("Enqueue: %v", err)
+			}
+			waitFor(t, "the job to be given up", func() bool { return len(deadLetters(t, q, "doomed")) == 1 })
+
+			if got := observer.Outcomes(); !slices.Equal(got, tc.wantOutcomes) {
+				t.Errorf("outcomes = %v, want %v", got, tc.wantOutcomes)
+			}
+			letter := deadLetters(t, q, "doomed")[0]
+			if letter.Attempt != tc.wantAttempt || letter.LastError != tc.err.Error() {
+				t.Errorf("dead letter = %+v, want attempt %d with %q", letter, tc.wantAttempt, tc.err)
+			}
+		})
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	q, observer := newTestQueue(t, Options{})
+	var calls sync.Map
+	q.Register("explosive", func(_ context.Context, job Job) error {
+		var payload struct{ Panic bool }
+		_ = job.Decode(&payload)
+		calls.Store(job.ID, job.Attempt)
+		if payload.Panic {
+			panic("nil map write")
+		}
+		return nil
+	}, TypeOptions{Workers: 1, MaxAttempts: 2})
+	runQueue(t, q)
+
+	ctx := context.Background()
+	if _, err := q.Enqueue(ctx, "explosive", map[string]bool{"Panic": true}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitFor(t, "the panicking job to be given up", func() bool { return len(deadLetters(t, q, "explosive")) == 1 })
+	letter := deadLetters(t, q, "explosive")[0]
+	if letter.Attempt != 2 || !strings.Contains(letter.LastError, "panic: nil map write") {
+		t.Errorf("dead letter = %+v, want the panic retried once then recorded", letter)
+	}
+
+	// the single worker survived the panics and takes the next job
+	id, err := q.Enqueue(ctx, "explosive", map[string]bool{"Panic": false})
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	waitFor(t, "the next job to run", func() bool { _, ok := calls.Load(id); return ok })
+	waitFor(t, "the next job to succeed", func() bool { return len(observer.Outcomes()) == 3 })
+	if want := []string{OutcomeRetried, OutcomeFailed, OutcomeSucceeded}; !slices.Equal(observer.Outcomes(), want) {
+		t.Errorf("outcomes = %v, want %v", observer.Outcomes(), want)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	t.Run("running job finishes within the drain timeout", func(t *testing.T) {
+		q, observer := newTestQueue(t, Options{DrainTimeout: 2 * time.Second})
+		started := make(chan struct{})
+		q.Register("slow", func(ctx context.Context, _ Job) error {
+			close(started)
+			// outlives the shutdown signal, but not the drain timeout
+			time.Sleep(50 * time.Millisecond)
+			return ctx.Err()
+		}, TypeOptions{})
+		stop := runQueue(t, q)
+
+		if _, err := q.Enqueue(context.Background(), "slow", nil); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		<-started
+		if err := stop(); !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+		if want := []string{OutcomeSucceeded}; !slices.Equal(observer.Outcomes(), want) {
+			t.Errorf("outcomes = %v, want the running job finished before Run returned", observer.Outcomes())
+		}
+	})
+
+	t.Run("job still running at the drain timeout is queued again", func(t *testing.T) {
+		q, observer := newTestQueue(t, Options{DrainTimeout: 20 * time.Millisecond})
+		started := make(chan struct{})
+		q.Register("stuck", func(ctx context.Context, _ Job) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}, TypeOptions{MaxAttempts: 1})
+		stop := runQueue(t, q)
+
+		id, err := q.Enqueue(context.Background(), "stuck", nil)
+		if err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		<-started
+		if err := stop(); !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+		if want := []string{OutcomeRequeued}; !slices.Equal(observer.Outcomes(), want) {
+			t.Errorf("outcomes = %v, want %v", observer.Outcomes(), want)
+		}
+
+		ctx := context.Background()
+		if score, err := q.client.ZScore(ctx, q.queuedKey("stuck"), id).Result(); err != nil || score == 0 {
+			t.Fatalf("job not queued again: %v", err)
+		}
+		if n, _ := q.client.ZCard(ctx, q.leasedKey("stuck")).Result(); n != 0 {
+			t.Errorf("%d jobs still leased after the drain", n)
+		}
+		raw, err := q.client.Get(ctx, q.jobKey(id)).Bytes()
+		if err != nil {
+			t.Fatalf("load job: %v", err)
+		}
+		var job Job
+		if err := json.Unmarshal(raw, &job); err != nil {
+			t.Fatalf("decode job: %v", err)
+		}
+		// the only attempt was interrupted, so the job can still run once
+		if job.Attempt != 0 {
+			t.Errorf("attempt = %d after an interrupted run, want 0", job.Attempt)
+		}
+		if letters := deadLetters(t, q, "stuck"); len(letters) != 0 {
+			t.Errorf("dead letters = %+v, want none", letters)
+		}
+	})
+}
+
+func TestEnqueueUnknownType(t *testing.T) {
+	q, _ := newTestQueue(t, Options{})
+	if _, err := q.Enqueue(context.Background(), "missing", nil); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Enqueue = %v, want ErrUnknownType", err)
+	}
+}
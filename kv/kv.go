@@ -0,0 +1,193 @@
+// Package kv namespaces the Redis keys of every feature, so several deployments can share
+// one Redis instance, and offers typed helpers for the common commands.
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Schema is the key space of one feature. Keys are built as
+// <REDIS_KEY_PREFIX><schema>:<part>:<part>…; the parts each schema takes are listed with it.
+type Schema string
+
+const (
+	// Jobs holds the background queue: job:<id>, queued:<type>, leased:<type>, dead:<type>.
+	Jobs Schema = "jobs"
+	// RateLimit holds token buckets: <scope>:<caller hash>.
+	RateLimit Schema = "rate_limit"
+	// ChatSlots holds the chats each caller has running: <caller hash>.
+	ChatSlots Schema = "rate_limit:concurrent"
+	// Lockout holds failure counters: <namespace>:<caller hash>.
+	Lockout Schema = "rate_limit:lockout"
+	// Tickets marks redeemed WebSocket tickets: <ticket id>.
+	Tickets Schema = "ws_ticket"
+	// Suggestions caches follow-up questions: <exchange hash>.
+	Suggestions Schema = "nlp:suggestions"
+	// VoiceCatalog caches TTS voice lists: <provider catalog>.
+	VoiceCatalog Schema = "tts:voices"
+	// QuotaUsed holds monthly usage hashes: <user id>:<yyyy-mm>.
+	QuotaUsed Schema = "quota:used"
+	// QuotaOverrides is the hash of per-user quota overrides, without parts.
+	QuotaOverrides Schema = "quota:overrides"
+	// FlagOverrides is the hash of feature flag overrides, without parts.
+	FlagOverrides Schema = "feature_flags:overrides"
+	// FlagAudit is the list of feature flag changes, without parts.
+	FlagAudit Schema = "feature_flags:audit"
+	// RoleUsagePending is the hash of chat counts awaiting a flush, without parts.
+	RoleUsagePending Schema = "role_usage:pending"
+	// RoleUsageFlushing holds claimed chat counts: <claim stamp>-<random>.
+	RoleUsageFlushing Schema = "role_usage:flushing"
+	// TTSBatch holds batch synthesis jobs: <job id>.
+	TTSBatch Schema = "tts_batch"
+	// ASRResume holds ASR stream snapshots: <session id>.
+	ASRResume Schema = "asr_resume"
+	// RoleByID caches roles looked up for chat: <role id>.
+	RoleByID Schema = "roles:id"
+	// RoleEpoch counts the invalidations of a cached role: <role id>.
+	RoleEpoch Schema = "roles:epoch"
+	// RoleList caches role listings: version, and v<version>:<query key>.
+	RoleList Schema = "roles:list"
+)
+
+// ErrUnavailable is returned by the helpers while Redis is known to be unreachable.
+var ErrUnavailable = errors.New("redis unavailable")
+
+// Store is a Redis client whose keys are namespaced by a deployment prefix.
+type Store struct {
+	client    *redis.Client
+	prefix    string
+	available func() bool
+}
+
+// New wraps client, prefixing every key with prefix (a colon is appended when missing). A
+// nil client gives a nil Store, which callers treat as Redis being unavailable.
+func New(client *redis.Client, prefix string) *Store {
+	if client == nil {
+		return nil
+	}
+	prefix = strings.TrimSpace(prefix)
+	if prefix != "" && !strings.HasSuffix(prefix, ":") {
+		prefix += ":"
+	}
+	return &Store{client: client, prefix: prefix}
+}
+
+// TrackAvailability makes Available consult available, typically the Available method of
+// a db.Dependency monitoring the server, so features degrade while Redis is down and
+// resume once it is back.
+func (s *Store) TrackAvailability(available func() bool) {
+	if s != nil {
+		s.available = available
+	}
+}
+
+// Available reports whether Redis can be used: false for a nil Store or while the tracked
+// dependency is down. Callers with an in-memory fallback check it before each command.
+func (s *Store) Available() bool {
+	return s != nil && (s.available == nil || s.available())
+}
+
+// Client returns the underlying client for commands without a helper here (pipelines,
+// scripts, hashes, sorted sets), nil for a nil Store; keys passed to it must come from Key.
+func (s *Store) Client() *redis.Client {
+	if s == nil {
+		return nil
+	}
+	return s.client
+}
+
+// Key returns the namespaced key of schema made of parts. A nil Store builds the key
+// without a prefix, for callers that fall back to process memory.
+func (s *Store) Key(schema Schema, parts ...string) string {
+	var b strings.Builder
+	if s != nil {
+		b.WriteString(s.prefix)
+	}
+	b.WriteString(string(schema))
+	for _, part := range parts {
+		b.WriteByte(':')
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// Pattern returns a SCAN pattern matching every key of schema that starts with parts.
+func (s *Store) Pattern(schema Schema, parts ...string) string {
+	return s.Key(schema, parts...) + ":*"
+}
+
+// Trim returns what follows the schema in key, a key built by Key or found by Pattern.
+func (s *Store) Trim(schema Schema, key string) string {
+	return strings.TrimPrefix(key, s.Key(schema)+":")
+}
+
+// Get returns the bytes stored at key; found is false when the key does not exist.
+func (s *Store) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if !s.Available() {
+		return nil, false, ErrUnavailable
+	}
+	raw, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return raw, true, nil
+}
+
+// Set stores value at key for ttl; 0 keeps it until deleted.
+func (s *Store) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if !s.Available() {
+		return ErrUnavailable
+	}
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Incr increments the counter at key and returns its new value.
+func (s *Store) Incr(ctx context.Context, key string) (int64, error) {
+	if !s.Available() {
+		return 0, ErrUnavailable
+	}
+	return s.client.Incr(ctx, key).Result()
+}
+
+// Del removes keys; missing keys are not an error.
+func (s *Store) Del(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if !s.Available() {
+		return ErrUnavailable
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
+
+// GetJSON decodes the JSON stored at key into dst; found is false when the key does not
+// exist, leaving dst untouched.
+func (s *Store) GetJSON(ctx context.Context, key string, dst any) (bool, error) {
+	raw, found, err := s.Get(ctx, key)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return false, fmt.Errorf("decode %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// SetJSON stores value encoded as JSON at key for ttl; 0 keeps it until deleted.
+func (s *Store) SetJSON(ctx context.Context, key string, value any, ttl time.Duration) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", key, err)
+	}
+	return s.Set(ctx, key, raw, ttl)
+}
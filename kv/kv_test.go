@@ -0,0 +1,189 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStore(t *testing.T, prefix string) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return New(client, prefix), server
+}
+
+func TestKey(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+
+	cases := []struct {
+		name   string
+		store  *Store
+		schema Schema
+		parts  []string
+		want   string
+	}{
+		{"no prefix", New(client, ""), RateLimit, []string{"tts", "abc"}, "rate_limit:tts:abc"},
+		{"prefix gets a colon", New(client, "staging"), RateLimit, []string{"tts", "abc"}, "staging:rate_limit:tts:abc"},
+		{"prefix with a colon", New(client, "staging:"), RateLimit, []string{"tts", "abc"}, "staging:rate_limit:tts:abc"},
+		{"prefix is trimmed", New(client, "  staging "), QuotaUsed, []string{"42", "2026-10"}, "staging:quota:used:42:2026-10"},
+		{"schema without parts", New(client, "staging"), FlagOverrides, nil, "staging:feature_flags:overrides"},
+		{"nil store", nil, Jobs, []string{"job", "1"}, "jobs:job:1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			key := tc.store.Key(tc.schema, tc.parts...)
+			if key != tc.want {
+				t.Fatalf("Key = %q, want %q", key, tc.want)
+			}
+			if got := tc.store.Pattern(tc.schema); got != tc.store.Key(tc.schema)+":*" {
+				t.Errorf("Pattern = %q, want the schema's keys", got)
+			}
+			if len(tc.parts) > 0 {
+				want := tc.parts[0]
+				for _, part := range tc.parts[1:] {
+					want += ":" + part
+				}
+				if got := tc.store.Trim(tc.schema, key); got != want {
+					t.Errorf("Trim = %q, want the parts %q back", got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestPrefixesIsolateDeployments checks two deployments sharing a Redis do not see each
+// other's keys.
+func TestPrefixesIsolateDeployments(t *testing.T) {
+	ctx := context.Background()
+	staging, server := newTestStore(t, "staging")
+	production := New(redis.NewClient(&redis.Options{Addr: server.Addr()}), "production")
+	defer production.Client().Close()
+
+	if err := staging.Set(ctx, staging.Key(RoleByID, "1"), []byte("staging role"), 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, found, err := production.Get(ctx, production.Key(RoleByID, "1")); err != nil || found {
+		t.Errorf("production found staging's key (err %v)", err)
+	}
+	if !server.Exists("staging:roles:id:1") || len(server.Keys()) != 1 {
+		t.Errorf("keys = %v, want only staging:roles:id:1", server.Keys())
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	type snapshot struct {
+		Session  string            `json:"session"`
+		Offset   int64             `json:"offset"`
+		Final    []string          `json:"final"`
+		Meta     map[string]string `json:"meta"`
+		Started  time.Time         `json:"started"`
+		Optional *float64          `json:"optional,omitempty"`
+	}
+	ctx := context.Background()
+	store, server := newTestStore(t, "test")
+	ratio := 0.5
+	want := snapshot{
+		Session:  "s-1",
+		Offset:   1 << 40,
+		Final:    []string{"你好", "world"},
+		Meta:     map[string]string{"lang": "zh"},
+		Started:  time.Date(2026, 10, 18, 8, 30, 0, 123, time.UTC),
+		Optional: &ratio,
+	}
+	key := store.Key(ASRResume, "s-1")
+
+	if err := store.SetJSON(ctx, key, want, time.Minute); err != nil {
+		t.Fatalf("SetJSON: %v", err)
+	}
+	var got snapshot
+	found, err := store.GetJSON(ctx, key, &got)
+	if err != nil || !found {
+		t.Fatalf("GetJSON = %t, %v, want the snapshot", found, err)
+	}
+	if got.Session != want.Session || got.Offset != want.Offset || len(got.Final) != 2 || got.Final[0] != "你好" ||
+		got.Meta["lang"] != "zh" || !got.Started.Equal(want.Started) || got.Optional == nil || *got.Optional != ratio {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+
+	server.FastForward(time.Minute)
+	untouched := snapshot{Session: "kept"}
+	if found, err := store.GetJSON(ctx, key, &untouched); err != nil || found || untouched.Session != "kept" {
+		t.Errorf("GetJSON after the ttl = %t, %v with %+v, want not found and dst untouched", found, err, untouched)
+	}
+
+	if err := store.Set(ctx, key, []byte("not json"), 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if found, err := store.GetJSON(ctx, key, &got); err == nil || found {
+		t.Errorf("GetJSON of a corrupt value = %t, %v, want a decode error", found, err)
+	}
+	if err := store.SetJSON(ctx, key, func() {}, 0); err == nil {
+		t.Error("SetJSON of an unencodable value succeeded")
+	}
+}
+
+func TestCounterAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t, "test")
+	key := store.Key(RoleEpoch, "7")
+
+	for want := int64(1); want <= 3; want++ {
+		if got, err := store.Incr(ctx, key); err != nil || got != want {
+			t.Fatalf("Incr = %d, %v, want %d", got, err, want)
+		}
+	}
+	if err := store.Del(ctx, key, store.Key(RoleEpoch, "missing")); err != nil {
+		t.Fatalf("Del: %v", err)
+	}
+	if _, found, err := store.Get(ctx, key); err != nil || found {
+		t.Errorf("Get after Del = %t, %v, want not found", found, err)
+	}
+	if err := store.Del(ctx); err != nil {
+		t.Errorf("Del without keys = %v, want nil", err)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	ctx := context.Background()
+	tracked, _ := newTestStore(t, "test")
+	up := true
+	tracked.TrackAvailability(func() bool { return up })
+
+	if err := tracked.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatalf("Set while up: %v", err)
+	}
+	up = false
+	var nilStore *Store
+	for name, store := range map[string]*Store{"tracked down": tracked, "nil": nilStore} {
+		if store.Available() {
+			t.Errorf("%s store is available", name)
+		}
+		if _, _, err := store.Get(ctx, "k"); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("%s Get = %v, want ErrUnavailable", name, err)
+		}
+		if err := store.SetJSON(ctx, "k", 1, 0); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("%s SetJSON = %v, want ErrUnavailable", name, err)
+		}
+		if _, err := store.Incr(ctx, "n"); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("%s Incr = %v, want ErrUnavailable", name, err)
+		}
+		if err := store.Del(ctx, "k"); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("%s Del = %v, want ErrUnavailable", name, err)
+		}
+	}
+	if nilStore.Client() != nil || New(nil, "test") != nil {
+		t.Error("a store without a client is not nil")
+	}
+
+	up = true
+	if raw, found, err := tracked.Get(ctx, "k"); err != nil || !found || string(raw) != "v" {
+		t.Errorf("Get once back up = %q, %t, %v, want the value written before", raw, found, err)
+	}
+}
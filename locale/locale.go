@@ -0,0 +1,125 @@
+// Package locale picks the language replies are written in, from what the request, the
+// user's profile, the Accept-Language header and the role say.
+package locale
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Known are the ISO 639-1 codes the service accepts for roles and user preferences.
+var Known = map[string]struct{}{
+	"zh": {}, "en": {}, "ja": {}, "ko": {}, "fr": {}, "de": {}, "es": {}, "it": {},
+	"pt": {}, "ru": {}, "ar": {}, "el": {}, "la": {}, "hi": {}, "nl": {}, "sv": {},
+	"pl": {}, "tr": {}, "vi": {}, "th": {}, "id": {}, "he": {}, "fa": {}, "uk": {},
+}
+
+// Base returns the lower-case primary subtag of a language tag: zh-TW and zh_Hant_TW
+// both give zh.
+func Base(tag string) string {
+	base := strings.ToLower(strings.TrimSpace(tag))
+	if idx := strings.IndexAny(base, "-_"); idx > 0 {
+		base = base[:idx]
+	}
+	return base
+}
+
+// Normalize returns the base code of tag and whether it is a Known language.
+func Normalize(tag string) (string, bool) {
+	base := Base(tag)
+	_, ok := Known[base]
+	return base, ok
+}
+
+// ParseAcceptLanguage returns the Known base languages of an Accept-Language header, most
+// preferred first. Ranges are ordered by q-value, keeping header order among equal ones;
+// region subtags are dropped (zh-TW counts as zh), each language is kept once at its best
+// q-value, and ranges with q=0, "*" or unknown languages are skipped.
+func ParseAcceptLanguage(header string) []string {
+	type ranged struct {
+		lang string
+		q    float64
+	}
+	var ranges []ranged
+	best := make(map[string]int)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		lang, ok := Normalize(fields[0])
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		if i, seen := best[lang]; seen {
+			ranges[i].q = max(ranges[i].q, q)
+			continue
+		}
+		best[lang] = len(ranges)
+		ranges = append(ranges, ranged{lang: lang, q: q})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	langs := make([]string, len(ranges))
+	for i, r := range ranges {
+		langs[i] = r.lang
+	}
+	return langs
+}
+
+// Sources of a negotiated language, from the most to the least specific.
+const (
+	SourceRequest        = "request"
+	SourceProfile        = "profile"
+	SourceAcceptLanguage = "accept_language"
+	SourceRole           = "role"
+	SourceDefault        = "default"
+)
+
+// Inputs are what a language can be negotiated from; empty fields are skipped.
+type Inputs struct {
+	// Request is the language the request asked for, used as given.
+	Request string
+	// Profile is the preferred language saved on the user's profile.
+	Profile string
+	// AcceptLanguage is the raw Accept-Language header.
+	AcceptLanguage string
+	// Role lists the languages of the role, its default first.
+	Role []string
+	// Default applies when nothing else does.
+	Default string
+}
+
+// Negotiate returns the reply language and where it came from: the request, then the
+// profile, then the most preferred known Accept-Language range, then the role's first
+// language and finally Default.
+func Negotiate(in Inputs) (string, string) {
+	if lang := strings.TrimSpace(in.Request); lang != "" {
+		return lang, SourceRequest
+	}
+	if lang, ok := Normalize(in.Profile); ok {
+		return lang, SourceProfile
+	}
+	if langs := ParseAcceptLanguage(in.AcceptLanguage); len(langs) > 0 {
+		return langs[0], SourceAcceptLanguage
+	}
+	if len(in.Role) > 0 {
+		if lang := strings.TrimSpace(in.Role[0]); lang != "" {
+			return lang, SourceRole
+		}
+	}
+	return in.Default, SourceDefault
+}
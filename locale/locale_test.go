@@ -0,0 +1,75 @@
+package locale
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBase(t *testing.T) {
+	cases := map[string]string{
+		"zh":         "zh",
+		"zh-TW":      "zh",
+		"zh_Hant_TW": "zh",
+		" EN-us ":    "en",
+		"":           "",
+		"-x":         "-x",
+	}
+	for tag, want := range cases {
+		if got := Base(tag); got != want {
+			t.Errorf("Base(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	cases := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"en", []string{"en"}},
+		{"zh-TW", []string{"zh"}},
+		{"zh-TW,zh;q=0.9,en-US;q=0.8,en;q=0.7", []string{"zh", "en"}},
+		{"en;q=0.5, fr, de;q=0.8", []string{"fr", "de", "en"}},
+		{"ja;q=0.8, ko;q=0.8", []string{"ja", "ko"}},
+		{"en;q=0.3, en-GB;q=0.9, fr;q=0.5", []string{"en", "fr"}},
+		{"fr;q=0, en", []string{"en"}},
+		{"*, xx, en;q=0.1", []string{"en"}},
+		{"de;q=oops, en;q=0.2", []string{"en"}},
+		{"de;q=1.5, en;q=0.2", []string{"en"}},
+		{"  es ; Q=0.4 ,pt;q=0.6", []string{"pt", "es"}},
+	}
+	for _, tc := range cases {
+		if got := ParseAcceptLanguage(tc.header); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseAcceptLanguage(%q) = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		name       string
+		in         Inputs
+		want       string
+		wantSource string
+	}{
+		{"request wins", Inputs{Request: "ja", Profile: "fr", AcceptLanguage: "de", Role: []string{"en"}, Default: "zh"}, "ja", SourceRequest},
+		{"request used as given", Inputs{Request: " pt-BR ", Profile: "fr"}, "pt-BR", SourceRequest},
+		{"profile over header", Inputs{Profile: "fr", AcceptLanguage: "de", Role: []string{"en"}, Default: "zh"}, "fr", SourceProfile},
+		{"profile region dropped", Inputs{Profile: "zh-TW", Role: []string{"en"}}, "zh", SourceProfile},
+		{"unknown profile skipped", Inputs{Profile: "klingon", AcceptLanguage: "de", Default: "zh"}, "de", SourceAcceptLanguage},
+		{"header by q-value", Inputs{AcceptLanguage: "en;q=0.4, zh-TW;q=0.9", Role: []string{"fr"}, Default: "zh"}, "zh", SourceAcceptLanguage},
+		{"header with only unknown ranges", Inputs{AcceptLanguage: "xx, *", Role: []string{"fr", "en"}, Default: "zh"}, "fr", SourceRole},
+		{"role", Inputs{Role: []string{" en ", "zh"}, Default: "zh"}, "en", SourceRole},
+		{"blank role language", Inputs{Role: []string{" "}, Default: "zh"}, "zh", SourceDefault},
+		{"default", Inputs{Default: "zh"}, "zh", SourceDefault},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, source := Negotiate(tc.in)
+			if got != tc.want || source != tc.wantSource {
+				t.Errorf("Negotiate = %q from %s, want %q from %s", got, source, tc.want, tc.wantSource)
+			}
+		})
+	}
+}
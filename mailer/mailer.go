@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer writes messages to the log instead of sending them, for development.
+type LogMailer struct {
+	logger *zap.SugaredLogger
+}
+
+// NewLogMailer returns a Mailer that logs every message at info level.
+func NewLogMailer(logger *zap.SugaredLogger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+// Send implements Mailer.
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	m.logger.Infow("mail not sent (log mailer)", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
@@ -0,0 +1,145 @@
+// Package memory lets roles remember durable facts about signed-in users, such as their
+// name or goals, beyond the recent messages a chat request carries. After each exchange
+// a cheap completion call extracts new facts in the background, and the most relevant
+// facts of the user and role are injected into later prompts.
+package memory
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"go.uber.org/zap"
+)
+
+const (
+	queueSize = 256
+	// knownFacts is how many stored facts extraction is shown, so it does not repeat them.
+	knownFacts     = 20
+	recallDeadline = 500 * time.Millisecond
+	extractLimit   = 20 * time.Second
+)
+
+// Store keeps the facts; *db.MemoryStore implements it.
+type Store interface {
+	EnsureIndexes(ctx context.Context) error
+	Remember(ctx context.Context, userID string, roleID int64, facts []string) (int, error)
+	Top(ctx context.Context, userID string, roleID int64, limit int) ([]models.Memory, error)
+}
+
+// Extractor finds new facts in an exchange; *services.MemoryExtractor implements it.
+type Extractor interface {
+	Extract(ctx context.Context, token, userMessage, reply string, known []string) ([]string, error)
+}
+
+// Exchange is one chat turn to learn from. Token is the Qiniu token the turn was served
+// with; extraction is billed to it as well.
+type Exchange struct {
+	UserID      string
+	RoleID      int64
+	Token       string
+	UserMessage string
+	Reply       string
+}
+
+// Service recalls and learns facts. Recall and Remember do nothing for anonymous callers,
+// when topK is 0 or on a nil Service.
+type Service struct {
+	store     Store
+	extractor Extractor
+	topK      int
+	queue     chan Exchange
+	logger    *zap.SugaredLogger
+}
+
+// NewService builds a Service injecting up to topK facts into each prompt.
+func NewService(store Store, extractor Extractor, topK int, logger *zap.SugaredLogger) *Service {
+	return &Service{store: store, extractor: extractor, topK: topK, queue: make(chan Exchange, queueSize), logger: logger}
+}
+
+func (s *Service) enabled() bool {
+	return s != nil && s.topK > 0
+}
+
+// Recall returns the most relevant facts of userID for roleID, most relevant first.
+// Failures are logged and recall nothing, so a slow store never fails a chat.
+func (s *Service) Recall(ctx context.Context, userID string, roleID int64) []string {
+	if !s.enabled() || userID == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, recallDeadline)
+	defer cancel()
+	memories, err := s.store.Top(ctx, userID, roleID, s.topK)
+	if err != nil {
+		s.logger.Warnf("recall memories of %s for role %d: %v", userID, roleID, err)
+		return nil
+	}
+	return facts(memories)
+}
+
+// Remember queues exchange for extraction. It never blocks; when the queue is full the
+// exchange is dropped and a warning logged.
+func (s *Service) Remember(exchange Exchange) {
+	if !s.enabled() || exchange.UserID == "" || strings.TrimSpace(exchange.Reply) == "" {
+		return
+	}
+	select {
+	case s.queue <- exchange:
+	default:
+		s.logger.Warnw("memory queue full, dropping exchange", "user_id", exchange.UserID, "role_id", exchange.RoleID)
+	}
+}
+
+// Run creates the store indexes and then extracts facts from queued exchanges until ctx
+// is done. Exchanges still queued then are dropped: each costs a model call, which must
+// not hold up shutdown.
+func (s *Service) Run(ctx context.Context) error {
+	if err := s.store.EnsureIndexes(ctx); err != nil {
+		s.logger.Warnf("ensure memory indexes: %v", err)
+	}
+	for {
+		select {
+		case exchange := <-s.queue:
+			s.learn(ctx, exchange)
+		case <-ctx.Done():
+			if n := len(s.queue); n > 0 {
+				s.logger.Infof("dropping %d exchanges queued for memory extraction", n)
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Service) learn(parent context.Context, exchange Exchange) {
+	ctx, cancel := context.WithTimeout(parent, extractLimit)
+	defer cancel()
+
+	known, err := s.store.Top(ctx, exchange.UserID, exchange.RoleID, knownFacts)
+	if err != nil {
+		s.logger.Warnf("load memories of %s for role %d: %v", exchange.UserID, exchange.RoleID, err)
+		return
+	}
+	extracted, err := s.extractor.Extract(ctx, exchange.Token, exchange.UserMessage, exchange.Reply, facts(known))
+	if err != nil {
+		s.logger.Warnf("extract memories of %s for role %d: %v", exchange.UserID, exchange.RoleID, err)
+		return
+	}
+	if len(extracted) == 0 {
+		return
+	}
+	added, err := s.store.Remember(ctx, exchange.UserID, exchange.RoleID, extracted)
+	if err != nil {
+		s.logger.Warnf("store memories of %s for role %d: %v", exchange.UserID, exchange.RoleID, err)
+		return
+	}
+	s.logger.Debugw("memories extracted", "user_id", exchange.UserID, "role_id", exchange.RoleID, "facts", len(extracted), "new", added)
+}
+
+func facts(memories []models.Memory) []string {
+	out := make([]string, len(memories))
+	for i, memory := range memories {
+		out[i] = memory.Fact
+	}
+	return out
+}
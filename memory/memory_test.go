@@ -0,0 +1,244 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// fakeStore keeps facts per user and role, deduplicated by db.MemoryKey and ranked by how
+// often they were seen, like *db.MemoryStore.
+type fakeStore struct {
+	mu       sync.Mutex
+	memories []models.Memory
+	err      error
+	indexed  bool
+}
+
+func (s *fakeStore) EnsureIndexes(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexed = true
+	return nil
+}
+
+func (s *fakeStore) Remember(_ context.Context, userID string, roleID int64, facts []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	added := 0
+next:
+	for _, fact := range facts {
+		key := db.MemoryKey(fact)
+		for i, memory := range s.memories {
+			if memory.UserID == userID && memory.RoleID == roleID && memory.Key == key {
+				s.memories[i].Seen++
+				continue next
+			}
+		}
+		s.memories = append(s.memories, models.Memory{UserID: userID, RoleID: roleID, Fact: fact, Key: key, Seen: 1})
+		added++
+	}
+	return added, nil
+}
+
+func (s *fakeStore) Top(_ context.Context, userID string, roleID int64, limit int) ([]models.Memory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var top []models.Memory
+	for _, memory := range s.memories {
+		if memory.UserID == userID && memory.RoleID == roleID {
+			top = append(top, memory)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Seen > top[j].Seen })
+	return top[:min(limit, len(top))], nil
+}
+
+type extraction struct {
+	token, userMessage, reply string
+	known                     []string
+}
+
+// fakeExtractor returns the facts scripted for each user message and records its calls.
+type fakeExtractor struct {
+	mu    sync.Mutex
+	facts map[string][]string
+	err   error
+	calls []extraction
+	done  chan struct{}
+}
+
+func (e *fakeExtractor) Extract(_ context.Context, token, userMessage, reply string, known []string) ([]string, error) {
+	e.mu.Lock()
+	e.calls = append(e.calls, extraction{token, userMessage, reply, known})
+	facts, err := e.facts[userMessage], e.err
+	e.mu.Unlock()
+	if e.done != nil {
+		e.done <- struct{}{}
+	}
+	return facts, err
+}
+
+func TestExtraction(t *testing.T) {
+	store := &fakeStore{}
+	extractor := &fakeExtractor{facts: map[string][]string{
+		"我叫小李，在学法语":    {"用户叫小李", "用户在学法语"},
+		"还是我，小李":       {"用户叫小李。"},
+		"what's up":    nil,
+		"I'm Bob, 30.": {"The user is called Bob"},
+	}}
+	s := NewService(store, extractor, 5, zap.NewNop().Sugar())
+	ctx := context.Background()
+
+	s.learn(ctx, Exchange{UserID: "alice", RoleID: 1, Token: "alice-token", UserMessage: "我叫小李，在学法语", Reply: "你好小李"})
+	s.learn(ctx, Exchange{UserID: "alice", RoleID: 1, Token: "alice-token", UserMessage: "还是我，小李", Reply: "记得你"})
+	s.learn(ctx, Exchange{UserID: "alice", RoleID: 1, UserMessage: "what's up", Reply: "not much"})
+	s.learn(ctx, Exchange{UserID: "bob", RoleID: 1, Token: "bob-token", UserMessage: "I'm Bob, 30.", Reply: "Hi Bob"})
+
+	// extraction is billed to the turn's token and shown only that user's known facts
+	calls := extractor.calls
+	if calls[0].token != "alice-token" || len(calls[0].known) != 0 || calls[0].reply != "你好小李" {
+		t.Errorf("first extraction = %+v, want alice's token and no known facts", calls[0])
+	}
+	if got := strings.Join(calls[1].known, ","); got != "用户叫小李,用户在学法语" {
+		t.Errorf("second extraction shown %s, want alice's two facts", got)
+	}
+	if calls[3].token != "bob-token" || len(calls[3].known) != 0 {
+		t.Errorf("bob's extraction = %+v, want bob's token and none of alice's facts", calls[3])
+	}
+
+	// a repeated fact is not stored twice but ranks higher
+	if got := s.Recall(ctx, "alice", 1); strings.Join(got, ",") != "用户叫小李,用户在学法语" {
+		t.Errorf("alice recalls %v, want the repeated fact first", got)
+	}
+	if len(store.memories) != 3 {
+		t.Errorf("%d facts stored, want 3", len(store.memories))
+	}
+}
+
+func TestExtractionFailures(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	store := &fakeStore{}
+	extractor := &fakeExtractor{facts: map[string][]string{"hi": {"fact"}}, err: errors.New("model overloaded")}
+	s := NewService(store, extractor, 5, zap.New(core).Sugar())
+
+	s.learn(context.Background(), Exchange{UserID: "alice", RoleID: 1, UserMessage: "hi", Reply: "hello"})
+	if len(store.memories) != 0 {
+		t.Errorf("stored %v after a failed extraction", store.memories)
+	}
+	store.err = errors.New("mongo down")
+	extractor.err = nil
+	s.learn(context.Background(), Exchange{UserID: "alice", RoleID: 1, UserMessage: "hi", Reply: "hello"})
+	if len(extractor.calls) != 1 {
+		t.Errorf("%d extractions, want none while the known facts cannot be loaded", len(extractor.calls)-1)
+	}
+	if got := logs.FilterMessageSnippet("alice").Len(); got != 2 {
+		t.Errorf("%d warnings, want one per failure", got)
+	}
+}
+
+func TestRecall(t *testing.T) {
+	store := &fakeStore{}
+	for i := range 4 {
+		facts := make([]string, 0, 4-i)
+		for j := i; j < 4; j++ {
+			facts = append(facts, fmt.Sprintf("fact %d", j))
+		}
+		// fact 3 is seen four times, fact 0 once
+		if _, err := store.Remember(context.Background(), "alice", 1, facts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Remember(context.Background(), "alice", 2, []string{"other role"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var none *Service
+	cases := []struct {
+		name    string
+		service *Service
+		userID  string
+		roleID  int64
+		want    string
+	}{
+		{"top facts, most seen first", NewService(store, nil, 3, zap.NewNop().Sugar()), "alice", 1, "fact 3,fact 2,fact 1"},
+		{"all when fewer than top k", NewService(store, nil, 10, zap.NewNop().Sugar()), "alice", 2, "other role"},
+		{"another user", NewService(store, nil, 3, zap.NewNop().Sugar()), "bob", 1, ""},
+		{"anonymous", NewService(store, nil, 3, zap.NewNop().Sugar()), "", 1, ""},
+		{"disabled", NewService(store, nil, 0, zap.NewNop().Sugar()), "alice", 1, ""},
+		{"nil service", none, "alice", 1, ""},
+	}
+	for _, tc := range cases {
+		if got := strings.Join(tc.service.Recall(context.Background(), tc.userID, tc.roleID), ","); got != tc.want {
+			t.Errorf("%s: Recall = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	store.err = errors.New("mongo down")
+	if got := NewService(store, nil, 3, zap.NewNop().Sugar()).Recall(context.Background(), "alice", 1); got != nil {
+		t.Errorf("Recall with the store down = %v, want nothing", got)
+	}
+}
+
+// TestRemember checks exchanges reach extraction through the queue only for signed-in
+// users with a reply, and that Run stops on cancel.
+func TestRemember(t *testing.T) {
+	store := &fakeStore{}
+	extractor := &fakeExtractor{facts: map[string][]string{"I'm Bob": {"The user is Bob"}}, done: make(chan struct{}, 8)}
+	s := NewService(store, extractor, 5, zap.NewNop().Sugar())
+
+	s.Remember(Exchange{UserID: "", RoleID: 1, UserMessage: "anonymous", Reply: "hello"})
+	s.Remember(Exchange{UserID: "bob", RoleID: 1, UserMessage: "no reply", Reply: "  "})
+	s.Remember(Exchange{UserID: "bob", RoleID: 1, UserMessage: "I'm Bob", Reply: "Hi Bob"})
+	if len(s.queue) != 1 {
+		t.Fatalf("%d exchanges queued, want only bob's answered one", len(s.queue))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	select {
+	case <-extractor.done:
+	case <-time.After(time.Second):
+		t.Fatal("queued exchange never extracted")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+	if !store.indexed {
+		t.Error("Run did not ensure the indexes")
+	}
+	if got := s.Recall(context.Background(), "bob", 1); len(got) != 1 || got[0] != "The user is Bob" {
+		t.Errorf("bob recalls %v, want the extracted fact", got)
+	}
+}
+
+func TestRememberDropsWhenFull(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	s := NewService(&fakeStore{}, &fakeExtractor{}, 5, zap.New(core).Sugar())
+	for range queueSize + 2 {
+		s.Remember(Exchange{UserID: "alice", RoleID: 1, UserMessage: "hi", Reply: "hello"})
+	}
+	if len(s.queue) != queueSize || logs.FilterMessage("memory queue full, dropping exchange").Len() != 2 {
+		t.Errorf("%d queued with %d drops logged, want %d queued and 2 drops", len(s.queue), logs.Len(), queueSize)
+	}
+}
@@ -0,0 +1,16 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// RegisterCircuitStates exports the state of each Qiniu circuit breaker, keyed by endpoint
+// class, as qiniu_circuit_state (0 closed, 1 half-open, 2 open). The gauges read state on
+// every scrape.
+func RegisterCircuitStates(registry *prometheus.Registry, states map[string]func() float64) {
+	for class, state := range states {
+		registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "qiniu_circuit_state",
+			Help:        "State of the Qiniu circuit breaker by endpoint class: 0 closed, 1 half-open, 2 open.",
+			ConstLabels: prometheus.Labels{"class": class},
+		}, state))
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Jobs exports background job queue measurements. It implements jobs.Observer.
+type Jobs struct {
+	depth    *prometheus.GaugeVec
+	duration *prometheus.HistogramVec
+}
+
+// NewJobs registers jobs_queue_depth and jobs_processing_seconds on registry.
+func NewJobs(registry *prometheus.Registry) *Jobs {
+	m := &Jobs{
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "jobs_queue_depth",
+			Help: "Background jobs by type: queued cluster-wide (state=queued) or running on this instance (state=running).",
+		}, []string{"type", "state"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "jobs_processing_seconds",
+			Help:    "Duration of background job attempts by type and outcome.",
+			Buckets: []float64{0.1, 0.5, 1, 5, 15, 60, 300, 900},
+		}, []string{"type", "outcome"}),
+	}
+	registry.MustRegister(m.depth, m.duration)
+	return m
+}
+
+// Depth records the queued and running jobs of jobType.
+func (m *Jobs) Depth(jobType string, queued, running int) {
+	m.depth.WithLabelValues(jobType, "queued").Set(float64(queued))
+	m.depth.WithLabelValues(jobType, "running").Set(float64(running))
+}
+
+// Done records a finished attempt.
+func (m *Jobs) Done(jobType, outcome string, took time.Duration) {
+	m.duration.WithLabelValues(jobType, outcome).Observe(took.Seconds())
+}
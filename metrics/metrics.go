@@ -0,0 +1,185 @@
+// Package metrics exports RED metrics (rate, errors, duration) for every HTTP route in the
+// Prometheus format.
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unmatchedRoute labels requests that matched no route, so probes of random paths share
+// one series.
+const unmatchedRoute = "unmatched"
+
+// HTTP holds the collectors of the HTTP middleware on a private registry, along with the
+// Go runtime and process collectors.
+type HTTP struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+	panics   *prometheus.CounterVec
+
+	wsConnections *prometheus.CounterVec
+	wsDuration    *prometheus.HistogramVec
+	wsActive      *prometheus.GaugeVec
+
+	stats *Stats
+}
+
+// NewHTTP builds and registers the collectors.
+func NewHTTP() *HTTP {
+	m := &HTTP{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route template, method and status code.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by route template and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "HTTP requests being served, by route template.",
+		}, []string{"route"}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_panics_total",
+			Help: "Handler panics recovered by the server, by route template.",
+		}, []string{"route"}),
+		wsConnections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "websocket_connections_total",
+			Help: "WebSocket upgrade requests by route template and handshake status.",
+		}, []string{"route", "status"}),
+		wsDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "websocket_connection_duration_seconds",
+			Help:    "Lifetime of WebSocket connections by route template.",
+			Buckets: []float64{1, 5, 15, 60, 300, 900, 1800, 3600},
+		}, []string{"route"}),
+		wsActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "websocket_connections_active",
+			Help: "Open WebSocket connections by route template.",
+		}, []string{"route"}),
+		stats: NewStats(),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.duration, m.inFlight, m.panics,
+		m.wsConnections, m.wsDuration, m.wsActive,
+	)
+	return m
+}
+
+// Registry returns the registry so other packages can add their own collectors.
+func (m *HTTP) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Stats returns the in-process figures of the admin dashboard, fed by Middleware.
+func (m *HTTP) Stats() *Stats {
+	return m.stats
+}
+
+// Middleware records every request. Routes are labeled by their template (/api/roles/:id)
+// rather than the raw path to keep cardinality bounded. WebSocket upgrades are tracked by
+// the websocket_* series instead, since their duration is the connection's lifetime.
+func (m *HTTP) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		start := time.Now()
+
+		if isWebSocketUpgrade(c.Request) {
+			writer := &hijackTracker{ResponseWriter: c.Writer}
+			c.Writer = writer
+			m.wsActive.WithLabelValues(route).Inc()
+			m.stats.WebSocketOpened()
+			c.Next()
+			m.stats.WebSocketClosed()
+			m.wsActive.WithLabelValues(route).Dec()
+
+			// a completed handshake hijacks the connection, so gin never sees the 101
+			status := c.Writer.Status()
+			if writer.hijacked {
+				status = http.StatusSwitchingProtocols
+				m.wsDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+			}
+			m.wsConnections.WithLabelValues(route, strconv.Itoa(status)).Inc()
+			return
+		}
+
+		inFlight := m.inFlight.WithLabelValues(route)
+		inFlight.Inc()
+		c.Next()
+		inFlight.Dec()
+
+		m.requests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		took := time.Since(start)
+		m.duration.WithLabelValues(route, c.Request.Method).Observe(took.Seconds())
+		m.stats.Observe(route, c.Request.Method, c.Writer.Status(), took)
+	}
+}
+
+// RecordPanic counts a recovered panic on route, a template as returned by
+// gin.Context.FullPath.
+func (m *HTTP) RecordPanic(route string) {
+	if route == "" {
+		route = unmatchedRoute
+	}
+	m.panics.WithLabelValues(route).Inc()
+}
+
+// Handler serves the registry in the Prometheus text format. When username is set the
+// scraper must send matching basic auth credentials.
+func (m *HTTP) Handler(username, password string) gin.HandlerFunc {
+	serve := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	user, pass := []byte(username), []byte(password)
+
+	return func(c *gin.Context) {
+		if len(user) > 0 {
+			gotUser, gotPass, ok := c.Request.BasicAuth()
+			// evaluate both comparisons so timing does not reveal which one failed
+			userOK := subtle.ConstantTimeCompare([]byte(gotUser), user) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(gotPass), pass) == 1
+			if !ok || !userOK || !passOK {
+				c.Header("WWW-Authenticate", `Basic realm="metrics"`)
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+		serve.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+type hijackTracker struct {
+	gin.ResponseWriter
+	hijacked bool
+}
+
+func (w *hijackTracker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+)
+
+// TestScrape serves simulated traffic through the middleware and checks the scraped
+// exposition carries the series it produced.
+func TestScrape(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewHTTP()
+	voice := NewVoicePipeline(m.Registry())
+	guard := NewPromptGuard(m.Registry())
+
+	router := gin.New()
+	router.Use(m.Middleware())
+	router.GET("/metrics", m.Handler("scraper", "secret"))
+	router.GET("/api/roles/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/api/voice/chat", func(c *gin.Context) {
+		// a turn failing at each stage, as the pipeline reports it
+		voice.StageFailed("asr", apierr.CodeNoSpeech)
+		voice.StageFailed("tts", apierr.CodeTokenInvalid)
+		voice.StageFailed("tts", apierr.CodeTokenInvalid)
+		c.Status(http.StatusBadGateway)
+	})
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	serve(httptest.NewRequest(http.MethodGet, "/api/roles/1", nil))
+	serve(httptest.NewRequest(http.MethodGet, "/api/roles/2", nil))
+	serve(httptest.NewRequest(http.MethodPost, "/api/voice/chat", nil))
+	serve(httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+	m.RecordPanic("/api/roles/:id")
+	guard.Flagged("jailbreak")
+
+	if rec := serve(httptest.NewRequest(http.MethodGet, "/metrics", nil)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("scrape without credentials = %d, want 401", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.SetBasicAuth("scraper", "secret")
+	rec := serve(req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape = %d, want 200", rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	exposition := string(body)
+
+	for _, series := range []string{
+		`http_requests_total{method="GET",route="/api/roles/:id",status="200"} 2`,
+		`http_requests_total{method="POST",route="/api/voice/chat",status="502"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/api/roles/:id"} 2`,
+		`http_requests_in_flight{route="/api/roles/:id"} 0`,
+		`http_panics_total{route="/api/roles/:id"} 1`,
+		`voice_pipeline_failures_total{code="NO_SPEECH",stage="asr"} 1`,
+		`voice_pipeline_failures_total{code="TOKEN_INVALID",stage="tts"} 2`,
+		`prompt_injection_flagged_total{pattern="jailbreak"} 1`,
+		`go_goroutines `,
+	} {
+		if !strings.Contains(exposition, series) {
+			t.Errorf("scrape lacks %s", series)
+		}
+	}
+	if strings.Contains(exposition, `route="/api/roles/1"`) {
+		t.Error("a route was labelled by its raw path rather than its template")
+	}
+}
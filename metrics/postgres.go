@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolStats samples pgxpool statistics into gauges labeled by pool name, to tell pool
+// exhaustion apart from slow upstreams when the API slows down.
+type PoolStats struct {
+	pools map[string]*pgxpool.Pool
+
+	acquired      *prometheus.GaugeVec
+	idle          *prometheus.GaugeVec
+	total         *prometheus.GaugeVec
+	max           *prometheus.GaugeVec
+	acquires      *prometheus.GaugeVec
+	emptyAcquires *prometheus.GaugeVec
+	waitSeconds   *prometheus.GaugeVec
+}
+
+// NewPoolStats registers the gauges on registry for pools, keyed by the name used as the
+// pool label ("primary", "replica"). Nil pools are skipped.
+func NewPoolStats(registry *prometheus.Registry, pools map[string]*pgxpool.Pool) *PoolStats {
+	gauge := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, []string{"pool"})
+	}
+	s := &PoolStats{
+		pools:         make(map[string]*pgxpool.Pool, len(pools)),
+		acquired:      gauge("db_pool_acquired_connections", "Postgres connections checked out of the pool."),
+		idle:          gauge("db_pool_idle_connections", "Idle Postgres connections in the pool."),
+		total:         gauge("db_pool_total_connections", "Postgres connections in the pool, including ones being opened."),
+		max:           gauge("db_pool_max_connections", "Maximum size of the Postgres pool."),
+		acquires:      gauge("db_pool_acquire_count", "Cumulative successful acquires from the Postgres pool."),
+		emptyAcquires: gauge("db_pool_empty_acquire_count", "Cumulative acquires that had to wait because the Postgres pool was empty."),
+		waitSeconds:   gauge("db_pool_acquire_wait_seconds", "Cumulative time spent waiting for a connection from an empty Postgres pool."),
+	}
+	for name, pool := range pools {
+		if pool != nil {
+			s.pools[name] = pool
+		}
+	}
+	registry.MustRegister(s.acquired, s.idle, s.total, s.max, s.acquires, s.emptyAcquires, s.waitSeconds)
+	return s
+}
+
+// Sample records the current statistics of every pool.
+func (s *PoolStats) Sample() {
+	for name, pool := range s.pools {
+		stat := pool.Stat()
+		s.acquired.WithLabelValues(name).Set(float64(stat.AcquiredConns()))
+		s.idle.WithLabelValues(name).Set(float64(stat.IdleConns()))
+		s.total.WithLabelValues(name).Set(float64(stat.TotalConns()))
+		s.max.WithLabelValues(name).Set(float64(stat.MaxConns()))
+		s.acquires.WithLabelValues(name).Set(float64(stat.AcquireCount()))
+		s.emptyAcquires.WithLabelValues(name).Set(float64(stat.EmptyAcquireCount()))
+		s.waitSeconds.WithLabelValues(name).Set(stat.EmptyAcquireWaitTime().Seconds())
+	}
+}
+
+// Run samples every interval until ctx is done; it is meant to run as a supervised worker.
+func (s *PoolStats) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.Sample()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			s.Sample()
+		}
+	}
+}
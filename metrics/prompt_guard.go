@@ -0,0 +1,25 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// PromptGuard counts prompt injection attempts. It implements services.PromptGuardObserver.
+type PromptGuard struct {
+	flagged *prometheus.CounterVec
+}
+
+// NewPromptGuard registers prompt_injection_flagged_total on registry.
+func NewPromptGuard(registry *prometheus.Registry) *PromptGuard {
+	m := &PromptGuard{
+		flagged: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "prompt_injection_flagged_total",
+			Help: "User messages sent to the chat model that matched a prompt injection pattern, by pattern.",
+		}, []string{"pattern"}),
+	}
+	registry.MustRegister(m.flagged)
+	return m
+}
+
+// Flagged counts a message matching pattern.
+func (m *PromptGuard) Flagged(pattern string) {
+	m.flagged.WithLabelValues(pattern).Inc()
+}
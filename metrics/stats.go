@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// statsWindow is how far back the dashboard error rate and latencies look.
+	statsWindow = 5 * time.Minute
+	statsSlots  = 10
+)
+
+// Stats keeps the figures of the admin dashboard in process, updated by the HTTP
+// middleware as requests complete, so reading them costs no query. They cover this
+// instance only and restart with it.
+type Stats struct {
+	started time.Time
+	now     func() time.Time
+
+	websockets atomic.Int64
+
+	mu          sync.Mutex
+	day         string
+	today       int64
+	todayErrors int64
+	requests    *SlidingHistogram
+	errors      *SlidingHistogram
+	routes      map[routeKey]*SlidingHistogram
+}
+
+type routeKey struct {
+	route, method string
+}
+
+// NewStats builds empty stats.
+func NewStats() *Stats {
+	return &Stats{
+		started: time.Now(),
+		now:     time.Now,
+		// a single bucket turns the histograms into sliding counters
+		requests: NewSlidingHistogram(statsWindow, statsSlots, nil),
+		errors:   NewSlidingHistogram(statsWindow, statsSlots, nil),
+		routes:   make(map[routeKey]*SlidingHistogram),
+	}
+}
+
+// Observe records a completed request on route; 5xx statuses count as errors.
+func (s *Stats) Observe(route, method string, status int, took time.Duration) {
+	now := s.now()
+	day := now.UTC().Format(time.DateOnly)
+	key := routeKey{route: route, method: method}
+
+	s.mu.Lock()
+	if s.day != day {
+		s.day, s.today, s.todayErrors = day, 0, 0
+	}
+	s.today++
+	if status >= 500 {
+		s.todayErrors++
+	}
+	latency, ok := s.routes[key]
+	if !ok {
+		latency = NewSlidingHistogram(statsWindow, statsSlots, LatencyBuckets)
+		s.routes[key] = latency
+	}
+	s.mu.Unlock()
+
+	s.requests.Observe(now, 1)
+	if status >= 500 {
+		s.errors.Observe(now, 1)
+	}
+	latency.Observe(now, took.Seconds())
+}
+
+// Window returns how far back the error rate and latencies look.
+func (s *Stats) Window() time.Duration {
+	return statsWindow
+}
+
+// WebSocketOpened counts a WebSocket connection as open until WebSocketClosed.
+func (s *Stats) WebSocketOpened() { s.websockets.Add(1) }
+
+// WebSocketClosed is the counterpart of WebSocketOpened.
+func (s *Stats) WebSocketClosed() { s.websockets.Add(-1) }
+
+// Overview is the traffic summary of the dashboard.
+type Overview struct {
+	Since            time.Time `json:"since"`
+	RequestsToday    int64     `json:"requests_today"`
+	ErrorsToday      int64     `json:"errors_today"`
+	ActiveWebSockets int64     `json:"active_websockets"`
+	WindowSeconds    int       `json:"window_seconds"`
+	WindowRequests   uint64    `json:"window_requests"`
+	WindowErrors     uint64    `json:"window_errors"`
+	// ErrorRate is the share of requests in the window answered with a 5xx status.
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// Overview returns the request counts of the current UTC day and of the sliding window.
+// Since is when counting started: midnight, or the process start on its first day.
+func (s *Stats) Overview() Overview {
+	now := s.now()
+	midnight := now.UTC().Truncate(24 * time.Hour)
+	overview := Overview{
+		Since:            midnight,
+		ActiveWebSockets: s.websockets.Load(),
+		WindowSeconds:    int(statsWindow / time.Second),
+		WindowRequests:   s.requests.Snapshot(now).Count,
+		WindowErrors:     s.errors.Snapshot(now).Count,
+	}
+	if s.started.After(midnight) {
+		overview.Since = s.started.UTC()
+	}
+
+	s.mu.Lock()
+	if s.day == now.UTC().Format(time.DateOnly) {
+		overview.RequestsToday, overview.ErrorsToday = s.today, s.todayErrors
+	}
+	s.mu.Unlock()
+
+	if overview.WindowRequests > 0 {
+		overview.ErrorRate = math.Round(float64(overview.WindowErrors)/float64(overview.WindowRequests)*10000) / 10000
+	}
+	return overview
+}
+
+// RouteLatency is the latency of one route over the sliding window.
+type RouteLatency struct {
+	Route  string  `json:"route"`
+	Method string  `json:"method"`
+	Count  uint64  `json:"count"`
+	P50MS  float64 `json:"p50_ms"`
+	P95MS  float64 `json:"p95_ms"`
+	MeanMS float64 `json:"mean_ms"`
+}
+
+// Latency returns the latency quantiles of every route requested within the window,
+// busiest first.
+func (s *Stats) Latency() []RouteLatency {
+	now := s.now()
+	s.mu.Lock()
+	keys := make([]routeKey, 0, len(s.routes))
+	histograms := make([]*SlidingHistogram, 0, len(s.routes))
+	for key, histogram := range s.routes {
+		keys = append(keys, key)
+		histograms = append(histograms, histogram)
+	}
+	s.mu.Unlock()
+
+	latencies := make([]RouteLatency, 0, len(keys))
+	for i, key := range keys {
+		snapshot := histograms[i].Snapshot(now)
+		if snapshot.Count == 0 {
+			continue
+		}
+		latencies = append(latencies, RouteLatency{
+			Route:  key.route,
+			Method: key.method,
+			Count:  snapshot.Count,
+			P50MS:  toMillis(snapshot.Quantile(0.5)),
+			P95MS:  toMillis(snapshot.Quantile(0.95)),
+			MeanMS: toMillis(snapshot.Sum / float64(snapshot.Count)),
+		})
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		if latencies[i].Count != latencies[j].Count {
+			return latencies[i].Count > latencies[j].Count
+		}
+		if latencies[i].Route != latencies[j].Route {
+			return latencies[i].Route < latencies[j].Route
+		}
+		return latencies[i].Method < latencies[j].Method
+	})
+	return latencies
+}
+
+func toMillis(seconds float64) float64 {
+	return math.Round(seconds*1000*100) / 100
+}
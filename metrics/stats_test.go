@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func approx(got, want float64) bool {
+	return math.Abs(got-want) < 1e-9
+}
+
+func TestQuantile(t *testing.T) {
+	bounds := []float64{0.1, 0.2, 0.5, 1}
+	h := NewSlidingHistogram(time.Minute, 6, bounds)
+	at := time.Unix(1_000_000, 0)
+	for range 50 {
+		h.Observe(at, 0.05)
+	}
+	for range 45 {
+		h.Observe(at, 0.15)
+	}
+	for range 5 {
+		h.Observe(at, 0.7)
+	}
+	snapshot := h.Snapshot(at)
+	if snapshot.Count != 100 || !approx(snapshot.Sum, 50*0.05+45*0.15+5*0.7) {
+		t.Fatalf("snapshot = %d observations summing %g, want 100 summing %g", snapshot.Count, snapshot.Sum, 50*0.05+45*0.15+5*0.7)
+	}
+
+	cases := []struct {
+		q    float64
+		want float64
+	}{
+		{0, 0},
+		{0.25, 0.05}, // halfway into the first bucket, from 0
+		{0.5, 0.1},   // the rank falls on the first bucket's bound
+		{0.95, 0.2},  // the last observation of the second bucket
+		{0.99, 0.9},  // the empty third bucket is skipped, 4/5 into the fourth
+		{1, 1},
+		{1.5, 1}, // clamped to 1
+		{-1, 0},  // clamped to 0
+	}
+	for _, tc := range cases {
+		if got := snapshot.Quantile(tc.q); !approx(got, tc.want) {
+			t.Errorf("Quantile(%g) = %g, want %g", tc.q, got, tc.want)
+		}
+	}
+
+	// ranks above every bound report the highest one
+	for range 100 {
+		h.Observe(at, 30)
+	}
+	if got := h.Snapshot(at).Quantile(0.95); got != 1 {
+		t.Errorf("Quantile(0.95) with most observations above every bound = %g, want 1", got)
+	}
+	if got := snapshot.Quantile(math.NaN()); !math.IsNaN(got) {
+		t.Errorf("Quantile(NaN) = %g, want NaN", got)
+	}
+}
+
+// TestSlidingHistogramRollover checks observations age out one slot at a time and a slot is
+// reused once the ring wraps onto it.
+func TestSlidingHistogramRollover(t *testing.T) {
+	// five 2s slots; start is on a slot boundary
+	h := NewSlidingHistogram(10*time.Second, 5, nil)
+	start := time.Unix(1_000_000, 0)
+	h.Observe(start, 1)
+	h.Observe(start.Add(3*time.Second), 1)
+	h.Observe(start.Add(9*time.Second), 1)
+
+	cases := []struct {
+		at   time.Duration
+		want uint64
+	}{
+		{0, 1}, // later slots are not counted in an earlier window
+		{9 * time.Second, 3},
+		{10 * time.Second, 2}, // the first slot left the window
+		{11 * time.Second, 2},
+		{12 * time.Second, 1},
+		{17 * time.Second, 1},
+		{18 * time.Second, 0},
+	}
+	for _, tc := range cases {
+		if got := h.Snapshot(start.Add(tc.at)).Count; got != tc.want {
+			t.Errorf("count at +%s = %d, want %d", tc.at, got, tc.want)
+		}
+	}
+
+	// the ring wraps onto the first slot: it is cleared, and late observations for the
+	// span it held are dropped rather than counted as current
+	h.Observe(start.Add(10*time.Second), 1)
+	h.Observe(start, 1)
+	if got := h.Snapshot(start.Add(10 * time.Second)); got.Count != 3 || got.Sum != 3 {
+		t.Errorf("snapshot after the wrap = %d summing %g, want 3 summing 3", got.Count, got.Sum)
+	}
+}
+
+func TestEmptyWindow(t *testing.T) {
+	snapshot := NewSlidingHistogram(time.Minute, 6, LatencyBuckets).Snapshot(time.Now())
+	if snapshot.Count != 0 || len(snapshot.Counts) != len(LatencyBuckets)+1 {
+		t.Errorf("empty snapshot = %+v, want no observations over every bucket", snapshot)
+	}
+	if got := snapshot.Quantile(0.5); !math.IsNaN(got) {
+		t.Errorf("Quantile of an empty window = %g, want NaN", got)
+	}
+	// a histogram without bounds counts, but has no quantiles
+	counter := NewSlidingHistogram(time.Minute, 0, nil)
+	counter.Observe(time.Now(), 1)
+	if got := counter.Snapshot(time.Now()); got.Count != 1 || !math.IsNaN(got.Quantile(0.5)) {
+		t.Errorf("counter snapshot = %d with median %g, want 1 with NaN", got.Count, got.Quantile(0.5))
+	}
+
+	s := NewStats()
+	if got := s.Latency(); len(got) != 0 {
+		t.Errorf("Latency before any request = %+v, want none", got)
+	}
+	if got := s.Overview(); got.WindowRequests != 0 || got.ErrorRate != 0 || got.RequestsToday != 0 {
+		t.Errorf("Overview before any request = %+v, want zeros", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	now := time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)
+	s := NewStats()
+	s.started = now.Add(-time.Hour)
+	s.now = func() time.Time { return now }
+
+	for range 18 {
+		s.Observe("/api/roles", http.MethodGet, http.StatusOK, 20*time.Millisecond)
+	}
+	for range 2 {
+		s.Observe("/api/roles", http.MethodGet, http.StatusOK, 300*time.Millisecond)
+	}
+	s.Observe("/api/nlp/chat", http.MethodPost, http.StatusBadGateway, 2*time.Second)
+	s.Observe("/api/nlp/chat", http.MethodGet, http.StatusNotFound, time.Millisecond)
+
+	latency := s.Latency()
+	want := []RouteLatency{
+		// p50 is 10/18 into the 10-25ms bucket, p95 half into the 250-500ms one
+		{Route: "/api/roles", Method: http.MethodGet, Count: 20, P50MS: 18.33, P95MS: 375, MeanMS: 48},
+		{Route: "/api/nlp/chat", Method: http.MethodGet, Count: 1, P50MS: 2.5, P95MS: 4.75, MeanMS: 1},
+		{Route: "/api/nlp/chat", Method: http.MethodPost, Count: 1, P50MS: 1750, P95MS: 2425, MeanMS: 2000},
+	}
+	if len(latency) != len(want) {
+		t.Fatalf("Latency = %+v, want %+v", latency, want)
+	}
+	for i := range want {
+		if latency[i] != want[i] {
+			t.Errorf("Latency[%d] = %+v, want %+v", i, latency[i], want[i])
+		}
+	}
+
+	overview := s.Overview()
+	if overview.WindowRequests != 22 || overview.WindowErrors != 1 || overview.ErrorRate != 0.0455 {
+		t.Errorf("window = %d requests, %d errors, rate %g, want 22, 1 and 0.0455", overview.WindowRequests, overview.WindowErrors, overview.ErrorRate)
+	}
+	if overview.RequestsToday != 22 || overview.ErrorsToday != 1 || !overview.Since.Equal(s.started) {
+		t.Errorf("today = %d requests, %d errors since %s, want 22 and 1 since the start", overview.RequestsToday, overview.ErrorsToday, overview.Since)
+	}
+
+	// the window empties while the day's counts stay
+	now = now.Add(statsWindow)
+	if got := s.Latency(); len(got) != 0 {
+		t.Errorf("Latency after the window = %+v, want none", got)
+	}
+	overview = s.Overview()
+	if overview.WindowRequests != 0 || overview.ErrorRate != 0 || overview.RequestsToday != 22 {
+		t.Errorf("Overview after the window = %+v, want an empty window and 22 requests today", overview)
+	}
+
+	// a new UTC day starts the counts over from midnight
+	now = time.Date(2026, 10, 19, 0, 0, 1, 0, time.UTC)
+	overview = s.Overview()
+	if overview.RequestsToday != 0 || !overview.Since.Equal(time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Overview the next day = %d requests since %s, want 0 since midnight", overview.RequestsToday, overview.Since)
+	}
+	s.Observe("/api/roles", http.MethodGet, http.StatusInternalServerError, time.Millisecond)
+	if overview = s.Overview(); overview.RequestsToday != 1 || overview.ErrorsToday != 1 {
+		t.Errorf("today = %d requests, %d errors, want 1 and 1", overview.RequestsToday, overview.ErrorsToday)
+	}
+}
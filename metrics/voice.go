@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+)
+
+// VoicePipeline counts failed voice pipeline stages. It implements
+// services.VoiceStageObserver.
+type VoicePipeline struct {
+	failures *prometheus.CounterVec
+}
+
+// NewVoicePipeline registers voice_pipeline_failures_total on registry.
+func NewVoicePipeline(registry *prometheus.Registry) *VoicePipeline {
+	m := &VoicePipeline{
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "voice_pipeline_failures_total",
+			Help: "Failed voice pipeline stages by stage (asr, chat, tts) and error code; cancelled turns are not counted.",
+		}, []string{"stage", "code"}),
+	}
+	registry.MustRegister(m.failures)
+	return m
+}
+
+// StageFailed counts a failure of stage reported with code.
+func (m *VoicePipeline) StageFailed(stage string, code apierr.Code) {
+	m.failures.WithLabelValues(stage, string(code)).Inc()
+}
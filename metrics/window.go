@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the buckets dashboard latencies are
+// counted in.
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// SlidingHistogram counts observations in fixed buckets over a sliding time window. The
+// window is split into slots of equal width kept in a ring; a slot is cleared when the ring
+// wraps onto it, so memory stays constant and old observations age out one slot at a time.
+type SlidingHistogram struct {
+	bounds []float64
+	width  time.Duration
+
+	mu    sync.Mutex
+	slots []histogramSlot
+}
+
+type histogramSlot struct {
+	// epoch numbers the slot's time span since the Unix epoch, -1 for an unused slot.
+	epoch  int64
+	counts []uint64 // one per bound, plus the +Inf bucket
+	sum    float64
+}
+
+// NewSlidingHistogram builds a histogram covering window in slots slots, counting
+// observations in buckets with the ascending upper bounds bounds.
+func NewSlidingHistogram(window time.Duration, slots int, bounds []float64) *SlidingHistogram {
+	slots = max(slots, 1)
+	h := &SlidingHistogram{
+		bounds: bounds,
+		width:  max(window/time.Duration(slots), time.Nanosecond),
+		slots:  make([]histogramSlot, slots),
+	}
+	for i := range h.slots {
+		h.slots[i] = histogramSlot{epoch: -1, counts: make([]uint64, len(bounds)+1)}
+	}
+	return h
+}
+
+// Observe counts value as observed at at.
+func (h *SlidingHistogram) Observe(at time.Time, value float64) {
+	epoch := at.UnixNano() / int64(h.width)
+	bucket := sort.SearchFloat64s(h.bounds, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	slot := &h.slots[int(epoch%int64(len(h.slots)))]
+	if slot.epoch != epoch {
+		if slot.epoch > epoch {
+			// older than the window the ring holds
+			return
+		}
+		clear(slot.counts)
+		slot.epoch, slot.sum = epoch, 0
+	}
+	slot.counts[bucket]++
+	slot.sum += value
+}
+
+// Snapshot sums the slots still inside the window ending at now.
+func (h *SlidingHistogram) Snapshot(now time.Time) HistogramSnapshot {
+	current := now.UnixNano() / int64(h.width)
+	oldest := current - int64(len(h.slots)) + 1
+
+	snapshot := HistogramSnapshot{Bounds: h.bounds, Counts: make([]uint64, len(h.bounds)+1)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, slot := range h.slots {
+		if slot.epoch < oldest || slot.epoch > current {
+			continue
+		}
+		for i, n := range slot.counts {
+			snapshot.Counts[i] += n
+			snapshot.Count += n
+		}
+		snapshot.Sum += slot.sum
+	}
+	return snapshot
+}
+
+// HistogramSnapshot is the bucket counts of a SlidingHistogram at one point in time.
+type HistogramSnapshot struct {
+	Bounds []float64
+	// Counts has one count per bound, observations up to the bound and above the previous
+	// one, and a last count for observations above every bound.
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+// Quantile estimates the q-quantile (0 ≤ q ≤ 1) like Prometheus' histogram_quantile: it
+// finds the bucket holding the rank and interpolates linearly inside it, taking 0 as the
+// lower bound of the first bucket. Ranks in the last, unbounded bucket report the highest
+// bound. An empty snapshot reports NaN.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 || len(s.Bounds) == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	q = min(max(q, 0), 1)
+	rank := q * float64(s.Count)
+
+	var below uint64
+	for i, n := range s.Counts {
+		if n == 0 || float64(below+n) < rank {
+			below += n
+			continue
+		}
+		if i == len(s.Bounds) {
+			return s.Bounds[len(s.Bounds)-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = s.Bounds[i-1]
+		}
+		return lower + (s.Bounds[i]-lower)*(rank-float64(below))/float64(n)
+	}
+	return s.Bounds[len(s.Bounds)-1]
+}
@@ -0,0 +1,129 @@
+// Package payloadlog records upstream request and response payloads for debugging, with
+// personal data redacted, in a bounded in-memory ring and a dedicated log.
+package payloadlog
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"go.uber.org/zap"
+)
+
+// DefaultSize is the ring size used when New is given a non-positive one.
+const DefaultSize = 200
+
+// Entry is one recorded upstream call.
+type Entry struct {
+	ID         int64           `json:"id"`
+	Time       time.Time       `json:"time"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Provider   string          `json:"provider"`
+	Endpoint   string          `json:"endpoint"`
+	Status     int             `json:"status,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+	Request    json.RawMessage `json:"request"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+type forceKey struct{}
+
+// Force marks ctx so its calls are recorded even when recording is not always on.
+func Force(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKey{}, true)
+}
+
+// Recorder keeps the most recent entries and writes each to its sink. A nil Recorder
+// records nothing.
+type Recorder struct {
+	always   bool
+	redactor *Redactor
+	sink     *zap.Logger
+
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	seq     int64
+}
+
+// New builds a Recorder keeping size entries. always records every call rather than only
+// those of forced contexts.
+func New(always bool, size int, redactor *Redactor, sink *zap.Logger) *Recorder {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Recorder{always: always, redactor: redactor, sink: sink, entries: make([]Entry, 0, size)}
+}
+
+// Always reports whether every call is recorded.
+func (r *Recorder) Always() bool {
+	return r != nil && r.always
+}
+
+// Active reports whether calls made with ctx are recorded; callers check it before
+// building an Entry.
+func (r *Recorder) Active(ctx context.Context) bool {
+	if r == nil {
+		return false
+	}
+	forced, _ := ctx.Value(forceKey{}).(bool)
+	return r.always || forced
+}
+
+// Record redacts entry, stamps it and stores it, evicting the oldest entry when full.
+func (r *Recorder) Record(ctx context.Context, entry Entry) {
+	if r == nil {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	entry.RequestID = ctxlog.RequestID(ctx)
+	entry.Request = r.redactor.RedactJSON(entry.Request)
+	entry.Response = r.redactor.RedactJSON(entry.Response)
+	entry.Error = r.redactor.Redact(entry.Error)
+
+	r.mu.Lock()
+	r.seq++
+	entry.ID = r.seq
+	if len(r.entries) < cap(r.entries) {
+		r.entries = append(r.entries, entry)
+	} else {
+		r.entries[r.next] = entry
+		r.next = (r.next + 1) % len(r.entries)
+	}
+	r.mu.Unlock()
+
+	r.sink.Info("upstream payload",
+		zap.Int64("id", entry.ID),
+		zap.String("request_id", entry.RequestID),
+		zap.String("provider", entry.Provider),
+		zap.String("endpoint", entry.Endpoint),
+		zap.Int("status", entry.Status),
+		zap.Int64("duration_ms", entry.DurationMS),
+		zap.ByteString("request", entry.Request),
+		zap.ByteString("response", entry.Response),
+		zap.String("error", entry.Error),
+	)
+}
+
+// Entries returns up to limit entries, newest first; limit <= 0 returns all of them.
+func (r *Recorder) Entries(limit int) []Entry {
+	if r == nil {
+		return []Entry{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	total := len(r.entries)
+	if limit <= 0 || limit > total {
+		limit = total
+	}
+	entries := make([]Entry, 0, limit)
+	for i := 0; i < limit; i++ {
+		// the newest entry sits just before next once the ring has wrapped
+		index := (r.next - 1 - i + 2*total) % total
+		entries = append(entries, r.entries[index])
+	}
+	return entries
+}
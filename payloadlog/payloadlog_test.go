@@ -0,0 +1,163 @@
+package payloadlog
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+)
+
+func TestRedact(t *testing.T) {
+	redactor, err := NewRedactor([]string{`sk-[A-Za-z0-9]{8,}`, `(?i)bearer\s+[a-z0-9._-]+`})
+	if err != nil {
+		t.Fatalf("NewRedactor: %v", err)
+	}
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"email", "mail alice@example.com today", "mail [email] today"},
+		{"email with tags and subdomains", "alice.smith+ai@mail.example.co.uk", "[email]"},
+		{"several emails", "a@b.io, c_d@e-f.org", "[email], [email]"},
+		{"mainland mobile", "我的手机号是13812345678", "我的手机号是[phone]"},
+		{"country code and spaces", "call +86 138 1234 5678 now", "call [phone] now"},
+		{"landline with area code", "(010) 6552-9988", "[phone]"},
+		{"dotted", "555.123.4567", "[phone]"},
+		{"short numbers kept", "room 1204, order 1234567", "room 1204, order 1234567"},
+		{"digits in an email go with it", "13812345678@qq.com", "[email]"},
+		{"custom pattern", "key sk-abcdef123456 leaked", "key [redacted] leaked"},
+		{"custom pattern with flags", "Authorization: BEARER abc.DEF-1", "Authorization: [redacted]"},
+		{"custom pattern too short", "sk-abc", "sk-abc"},
+		{"everything at once", "bob@example.com / 13812345678 / sk-abcdefgh", "[email] / [phone] / [redacted]"},
+		{"nothing to redact", "Hello, Socrates.", "Hello, Socrates."},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := redactor.Redact(tc.text); got != tc.want {
+				t.Errorf("Redact(%q) = %q, want %q", tc.text, got, tc.want)
+			}
+		})
+	}
+
+	if _, err := NewRedactor([]string{`valid`, `(unclosed`}); err == nil {
+		t.Error("NewRedactor accepted an invalid pattern")
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	redactor, err := NewRedactor([]string{`secret-\w+`})
+	if err != nil {
+		t.Fatalf("NewRedactor: %v", err)
+	}
+	cases := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"nested values",
+			`{"messages":[{"role":"user","content":"I am bob@example.com"}],"max_tokens":13812345678}`,
+			`{"max_tokens":13812345678,"messages":[{"content":"I am [email]","role":"user"}]}`},
+		{"object keys", `{"13812345678":{"secret-key":true}}`, `{"[phone]":{"[redacted]":true}}`},
+		{"top-level array", `["secret-one",null,1.5]`, `["[redacted]",null,1.5]`},
+		{"not JSON", `upstream said: call 13812345678`, `"upstream said: call [phone]"`},
+		{"empty", ``, ``},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(redactor.RedactJSON([]byte(tc.data))); got != tc.want {
+				t.Errorf("RedactJSON = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRingEviction(t *testing.T) {
+	redactor, _ := NewRedactor(nil)
+	core, logs := observer.New(zapcore.InfoLevel)
+	recorder := New(true, 3, redactor, zap.New(core))
+	ctx := ctxlog.WithRequestID(context.Background(), "req-1")
+
+	for i := 1; i <= 5; i++ {
+		recorder.Record(ctx, Entry{
+			Provider: "qiniu",
+			Endpoint: "/chat/completions",
+			Request:  []byte(fmt.Sprintf(`{"n":%d,"user":"u%d@example.com"}`, i, i)),
+			Error:    "callback 13812345678",
+		})
+	}
+
+	ids := func(entries []Entry) []int64 {
+		got := make([]int64, 0, len(entries))
+		for _, entry := range entries {
+			got = append(got, entry.ID)
+		}
+		return got
+	}
+	cases := []struct {
+		limit int
+		want  []int64
+	}{
+		{0, []int64{5, 4, 3}},
+		{2, []int64{5, 4}},
+		{10, []int64{5, 4, 3}},
+	}
+	for _, tc := range cases {
+		if got := ids(recorder.Entries(tc.limit)); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("Entries(%d) = %v, want %v", tc.limit, got, tc.want)
+		}
+	}
+
+	newest := recorder.Entries(1)[0]
+	if string(newest.Request) != `{"n":5,"user":"[email]"}` || newest.Error != "callback [phone]" {
+		t.Errorf("newest entry = %s / %q, want it redacted", newest.Request, newest.Error)
+	}
+	if newest.RequestID != "req-1" || newest.Time.IsZero() {
+		t.Errorf("newest entry stamped %q at %s, want req-1 and a time", newest.RequestID, newest.Time)
+	}
+
+	// the sink gets every entry, evicted or not, already redacted
+	entries := logs.All()
+	if len(entries) != 5 {
+		t.Fatalf("%d lines written to the sink, want 5", len(entries))
+	}
+	if got := entries[0].ContextMap()["request"]; got != `{"n":1,"user":"[email]"}` {
+		t.Errorf("first sink line request = %v, want it redacted", got)
+	}
+}
+
+func TestActive(t *testing.T) {
+	redactor, _ := NewRedactor(nil)
+	forced := Force(context.Background())
+	var none *Recorder
+
+	cases := []struct {
+		name     string
+		recorder *Recorder
+		ctx      context.Context
+		want     bool
+	}{
+		{"always", New(true, 0, redactor, zap.NewNop()), context.Background(), true},
+		{"on demand", New(false, 0, redactor, zap.NewNop()), context.Background(), false},
+		{"on demand and forced", New(false, 0, redactor, zap.NewNop()), forced, true},
+		{"nil recorder", none, forced, false},
+	}
+	for _, tc := range cases {
+		if got := tc.recorder.Active(tc.ctx); got != tc.want {
+			t.Errorf("%s: Active = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+
+	none.Record(forced, Entry{})
+	if got := none.Entries(0); got == nil || len(got) != 0 {
+		t.Errorf("nil recorder Entries = %v, want an empty slice", got)
+	}
+	if got := cap(New(true, 0, redactor, zap.NewNop()).entries); got != DefaultSize {
+		t.Errorf("ring size = %d without a size, want %d", got, DefaultSize)
+	}
+}
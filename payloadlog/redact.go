@@ -0,0 +1,94 @@
+package payloadlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Masks replacing redacted text.
+const (
+	emailMask  = "[email]"
+	phoneMask  = "[phone]"
+	customMask = "[redacted]"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	// phonePattern matches 8 to 15 digits, optionally after a country code and split by
+	// spaces, dots or dashes, which covers mainland mobile and landline numbers and most
+	// international formats.
+	phonePattern = regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{1,4}\)[\s.-]?)?\d(?:[\s.-]?\d){7,14}`)
+)
+
+type rule struct {
+	pattern *regexp.Regexp
+	mask    string
+}
+
+// Redactor masks email addresses, phone numbers and text matching extra patterns.
+type Redactor struct {
+	rules []rule
+}
+
+// NewRedactor builds a Redactor applying patterns, regular expressions in RE2 syntax,
+// after the built-in email and phone rules.
+func NewRedactor(patterns []string) (*Redactor, error) {
+	r := &Redactor{rules: []rule{{emailPattern, emailMask}, {phonePattern, phoneMask}}}
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile redaction pattern %q: %w", pattern, err)
+		}
+		r.rules = append(r.rules, rule{compiled, customMask})
+	}
+	return r, nil
+}
+
+// Redact returns text with every match masked.
+func (r *Redactor) Redact(text string) string {
+	for _, rule := range r.rules {
+		text = rule.pattern.ReplaceAllLiteralString(text, rule.mask)
+	}
+	return text
+}
+
+// RedactJSON masks the string values and object keys of a JSON document, leaving its
+// structure intact; data that is not JSON is redacted as text and returned as a JSON
+// string.
+func (r *Redactor) RedactJSON(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		quoted, _ := json.Marshal(r.Redact(string(data)))
+		return quoted
+	}
+	redacted, err := json.Marshal(r.redactValue(value))
+	if err != nil {
+		quoted, _ := json.Marshal(r.Redact(string(data)))
+		return quoted
+	}
+	return redacted
+}
+
+func (r *Redactor) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.Redact(v)
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.redactValue(item)
+		}
+		return v
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redacted[r.Redact(key)] = r.redactValue(item)
+		}
+		return redacted
+	default:
+		return v
+	}
+}
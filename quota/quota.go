@@ -0,0 +1,424 @@
+// Package quota enforces monthly limits on the upstream usage of signed-in users: chat
+// tokens, synthesized TTS characters and transcribed ASR minutes. Usage is counted per user
+// and UTC month in Redis as it is recorded, so checking a quota costs one round trip;
+// Reconcile periodically rebuilds the counters from the usage_events table, which stays
+// the authoritative record. Limits default to the configured values and can be
+// overridden per user.
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+)
+
+// Metrics a quota can limit.
+const (
+	MetricTokens        = "tokens"
+	MetricTTSCharacters = "tts_characters"
+	MetricASRMinutes    = "asr_minutes"
+)
+
+const (
+	// counters outlive their month so the previous month can still be inspected
+	usedKeyTTL    = 62 * 24 * time.Hour
+	redisDeadline = 300 * time.Millisecond
+
+	fieldTokens        = "tokens"
+	fieldTTSCharacters = "tts_characters"
+	fieldASRMillis     = "asr_ms"
+)
+
+// Limits are monthly quotas; 0 means unlimited.
+type Limits struct {
+	TokensPerMonth        int64 `json:"tokens_per_month"`
+	TTSCharactersPerMonth int64 `json:"tts_characters_per_month"`
+	ASRMinutesPerMonth    int64 `json:"asr_minutes_per_month"`
+}
+
+// Override replaces some of the default limits for one user; nil fields keep the default.
+type Override struct {
+	TokensPerMonth        *int64    `json:"tokens_per_month"`
+	TTSCharactersPerMonth *int64    `json:"tts_characters_per_month"`
+	ASRMinutesPerMonth    *int64    `json:"asr_minutes_per_month"`
+	UpdatedBy             string    `json:"updated_by,omitempty"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+func (l Limits) with(o *Override) Limits {
+	if o == nil {
+		return l
+	}
+	if o.TokensPerMonth != nil {
+		l.TokensPerMonth = *o.TokensPerMonth
+	}
+	if o.TTSCharactersPerMonth != nil {
+		l.TTSCharactersPerMonth = *o.TTSCharactersPerMonth
+	}
+	if o.ASRMinutesPerMonth != nil {
+		l.ASRMinutesPerMonth = *o.ASRMinutesPerMonth
+	}
+	return l
+}
+
+// Used is the usage counted against the quotas of one month.
+type Used struct {
+	Tokens        int64   `json:"tokens"`
+	TTSCharacters int64   `json:"tts_characters"`
+	ASRMinutes    float64 `json:"asr_minutes"`
+}
+
+// Status is a user's quota state for the current month.
+type Status struct {
+	UserID   string    `json:"user_id"`
+	Month    string    `json:"month"`
+	ResetsAt time.Time `json:"resets_at"`
+	Defaults Limits    `json:"defaults"`
+	Override *Override `json:"override"`
+	Limits   Limits    `json:"limits"`
+	Used     Used      `json:"used"`
+}
+
+// Service checks and counts quotas. Without Redis every check passes; Redis errors are
+// logged and let the request through, so an outage never blocks chat.
+type Service struct {
+	kv           *kv.Store
+	redis        *redis.Client
+	overridesKey string
+	pools        *db.PoolRouter
+	defaults     Limits
+	logger       *zap.SugaredLogger
+	now          func() time.Time
+}
+
+// NewService builds a service enforcing defaults for users without an override.
+func NewService(store *kv.Store, pools *db.PoolRouter, defaults Limits, logger *zap.SugaredLogger) *Service {
+	return &Service{
+		kv:           store,
+		redis:        store.Client(),
+		overridesKey: store.Key(kv.QuotaOverrides),
+		pools:        pools,
+		defaults:     defaults,
+		logger:       logger,
+		now:          time.Now,
+	}
+}
+
+// Check returns a QUOTA_EXCEEDED error when userID has used up the current month's quota
+// of any of metrics. The check runs before the upstream call, so the request that crosses
+// a limit is still served. Anonymous callers have no quota.
+func (s *Service) Check(ctx context.Context, userID string, metrics ...string) error {
+	if s == nil || !s.kv.Available() || userID == "" {
+		return nil
+	}
+	now := s.now().UTC()
+	ctx, cancel := context.WithTimeout(ctx, redisDeadline)
+	defer cancel()
+
+	pipe := s.redis.Pipeline()
+	usedCmd := pipe.HMGet(ctx, s.usedKey(userID, now), fieldTokens, fieldTTSCharacters, fieldASRMillis)
+	overrideCmd := pipe.HGet(ctx, s.overridesKey, userID)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		s.logger.Warnw("check quota failed, allowing request", "user_id", userID, "error", err)
+		return nil
+	}
+	override, err := decodeOverride(overrideCmd)
+	if err != nil {
+		s.logger.Warnw("decode quota override failed, using defaults", "user_id", userID, "error", err)
+	}
+	limits := s.defaults.with(override)
+	used := decodeUsed(usedCmd.Val())
+
+	for _, metric := range metrics {
+		var limit int64
+		var amount any
+		var exceeded bool
+		switch metric {
+		case MetricTokens:
+			limit, amount = limits.TokensPerMonth, used.Tokens
+			exceeded = limit > 0 && used.Tokens >= limit
+		case MetricTTSCharacters:
+			limit, amount = limits.TTSCharactersPerMonth, used.TTSCharacters
+			exceeded = limit > 0 && used.TTSCharacters >= limit
+		case MetricASRMinutes:
+			limit, amount = limits.ASRMinutesPerMonth, used.ASRMinutes
+			exceeded = limit > 0 && used.ASRMinutes >= float64(limit)
+		}
+		if exceeded {
+			return apierr.New(apierr.CodeQuotaExceeded, "monthly "+metric+" quota exceeded").
+				With("metric", metric).
+				With("quota", limit).
+				With("used", amount).
+				With("resets_at", nextMonth(now).Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// Count adds a recorded usage event to its user's counters for the month of the event.
+// It implements db.UsageCounter.
+func (s *Service) Count(event db.UsageEvent) {
+	if s == nil || !s.kv.Available() || event.UserID == "" {
+		return
+	}
+	at := event.At
+	if at.IsZero() {
+		at = s.now()
+	}
+	tokens := event.PromptTokens + event.CompletionTokens
+	if tokens == 0 && event.TTSCharacters == 0 && event.ASRMillis == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisDeadline)
+	defer cancel()
+	key := s.usedKey(event.UserID, at.UTC())
+	pipe := s.redis.Pipeline()
+	if tokens > 0 {
+		pipe.HIncrBy(ctx, key, fieldTokens, tokens)
+	}
+	if event.TTSCharacters > 0 {
+		pipe.HIncrBy(ctx, key, fieldTTSCharacters, event.TTSCharacters)
+	}
+	if event.ASRMillis > 0 {
+		pipe.HIncrBy(ctx, key, fieldASRMillis, event.ASRMillis)
+	}
+	pipe.Expire(ctx, key, usedKeyTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.logger.Warnw("count quota usage failed", "user_id", event.UserID, "error", err)
+	}
+}
+
+// Run reconciles the counters now and then every interval until ctx is done.
+func (s *Service) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Reconcile(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Warnf("reconcile quotas: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Reconcile overwrites the current month's counters with the usage_events totals, including
+// the rows users anonymized by erasing their data, and reloads the overrides into Redis. Usage still queued for the table when it runs is
+// missing from the counters until the next reconciliation.
+func (s *Service) Reconcile(ctx context.Context) error {
+	if !s.kv.Available() {
+		return nil
+	}
+	now := s.now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	rows, err := s.pools.Primary().Query(ctx, `SELECT user_id,
+			COALESCE(SUM(prompt_tokens + completion_tokens), 0)::bigint,
+			COALESCE(SUM(tts_characters), 0)::bigint, COALESCE(SUM(asr_ms), 0)::bigint
+		FROM usage_events WHERE day >= $1 AND user_id <> '' GROUP BY user_id`, monthStart)
+	if err != nil {
+		return fmt.Errorf("load monthly usage: %w", err)
+	}
+	totals := make(map[string]monthlyUsage)
+	for rows.Next() {
+		var userID string
+		var usage monthlyUsage
+		if err := rows.Scan(&userID, &usage.tokens, &usage.characters, &usage.asrMillis); err != nil {
+			rows.Close()
+			return fmt.Errorf("load monthly usage: scan: %w", err)
+		}
+		totals[userID] = usage
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("load monthly usage: %w", err)
+	}
+
+	pipe := s.redis.Pipeline()
+	for userID, usage := range totals {
+		if strings.HasPrefix(userID, db.ErasedUserPrefix) {
+			continue
+		}
+		// usage the user erased this month still counts against their quota
+		usage = usage.add(totals[db.ErasedUserID(userID)])
+		key := s.usedKey(userID, now)
+		pipe.HSet(ctx, key, fieldTokens, usage.tokens, fieldTTSCharacters, usage.characters, fieldASRMillis, usage.asrMillis)
+		pipe.Expire(ctx, key, usedKeyTTL)
+	}
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("store quota counters: %w", err)
+		}
+	}
+
+	overrides, err := s.loadOverrides(ctx, "")
+	if err != nil {
+		return err
+	}
+	tx := s.redis.TxPipeline()
+	tx.Del(ctx, s.overridesKey)
+	for userID, override := range overrides {
+		encoded, _ := json.Marshal(override)
+		tx.HSet(ctx, s.overridesKey, userID, encoded)
+	}
+	if _, err := tx.Exec(ctx); err != nil {
+		return fmt.Errorf("store quota overrides: %w", err)
+	}
+	return nil
+}
+
+// monthlyUsage is the usage_events total of one user_id over the current month.
+type monthlyUsage struct {
+	tokens, characters, asrMillis int64
+}
+
+func (u monthlyUsage) add(o monthlyUsage) monthlyUsage {
+	return monthlyUsage{u.tokens + o.tokens, u.characters + o.characters, u.asrMillis + o.asrMillis}
+}
+
+// Status returns the quota state of userID: the override is read from Postgres, the usage
+// from the counters checks are made against.
+func (s *Service) Status(ctx context.Context, userID string) (*Status, error) {
+	overrides, err := s.loadOverrides(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now().UTC()
+	status := &Status{
+		UserID:   userID,
+		Month:    now.Format("2006-01"),
+		ResetsAt: nextMonth(now),
+		Defaults: s.defaults,
+		Override: overrides[userID],
+	}
+	status.Limits = s.defaults.with(status.Override)
+	if s.redis != nil {
+		values, err := s.redis.HMGet(ctx, s.usedKey(userID, now), fieldTokens, fieldTTSCharacters, fieldASRMillis).Result()
+		if err != nil {
+			return nil, fmt.Errorf("load quota counters: %w", err)
+		}
+		status.Used = decodeUsed(values)
+	}
+	return status, nil
+}
+
+// SetOverride stores the override of userID. Redis is updated right away; if that fails
+// the next reconciliation picks the override up.
+func (s *Service) SetOverride(ctx context.Context, userID string, override Override, actor string) (*Override, error) {
+	err := s.pools.Primary().QueryRow(ctx, `INSERT INTO user_quotas (user_id, tokens_per_month,
+			tts_characters_per_month, asr_minutes_per_month, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, now())
+		ON CONFLICT (user_id) DO UPDATE SET tokens_per_month = EXCLUDED.tokens_per_month,
+			tts_characters_per_month = EXCLUDED.tts_characters_per_month,
+			asr_minutes_per_month = EXCLUDED.asr_minutes_per_month,
+			updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`,
+		userID, override.TokensPerMonth, override.TTSCharactersPerMonth, override.ASRMinutesPerMonth, actor,
+	).Scan(&override.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("store quota override: %w", err)
+	}
+	override.UpdatedBy = actor
+
+	if s.redis != nil {
+		encoded, _ := json.Marshal(override)
+		ctx, cancel := context.WithTimeout(ctx, redisDeadline)
+		defer cancel()
+		if err := s.redis.HSet(ctx, s.overridesKey, userID, encoded).Err(); err != nil {
+			s.logger.Warnw("cache quota override failed", "user_id", userID, "error", err)
+		}
+	}
+	return &override, nil
+}
+
+// ClearOverride drops the override of userID so the defaults apply again. It returns
+// pgx.ErrNoRows when there was none.
+func (s *Service) ClearOverride(ctx context.Context, userID string) error {
+	tag, err := s.pools.Primary().Exec(ctx, `DELETE FROM user_quotas WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("delete quota override: %w", err)
+	}
+	if s.redis != nil {
+		ctx, cancel := context.WithTimeout(ctx, redisDeadline)
+		defer cancel()
+		if err := s.redis.HDel(ctx, s.overridesKey, userID).Err(); err != nil {
+			s.logger.Warnw("drop cached quota override failed", "user_id", userID, "error", err)
+		}
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// loadOverrides reads the overrides of userID, or of every user when userID is "".
+func (s *Service) loadOverrides(ctx context.Context, userID string) (map[string]*Override, error) {
+	rows, err := s.pools.Primary().Query(ctx, `SELECT user_id, tokens_per_month, tts_characters_per_month,
+			asr_minutes_per_month, updated_by, updated_at
+		FROM user_quotas WHERE $1 = '' OR user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("load quota overrides: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]*Override)
+	for rows.Next() {
+		var id string
+		var override Override
+		if err := rows.Scan(&id, &override.TokensPerMonth, &override.TTSCharactersPerMonth,
+			&override.ASRMinutesPerMonth, &override.UpdatedBy, &override.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("load quota overrides: scan: %w", err)
+		}
+		overrides[id] = &override
+	}
+	return overrides, rows.Err()
+}
+
+func decodeOverride(cmd *redis.StringCmd) (*Override, error) {
+	raw, err := cmd.Bytes()
+	if err != nil {
+		// redis.Nil: no override
+		return nil, nil
+	}
+	var override Override
+	if err := json.Unmarshal(raw, &override); err != nil {
+		return nil, err
+	}
+	return &override, nil
+}
+
+func decodeUsed(values []any) Used {
+	field := func(i int) int64 {
+		if i >= len(values) {
+			return 0
+		}
+		raw, _ := values[i].(string)
+		n, _ := strconv.ParseInt(raw, 10, 64)
+		return n
+	}
+	return Used{Tokens: field(0), TTSCharacters: field(1), ASRMinutes: float64(field(2)) / 60000}
+}
+
+// usedKey is the counter hash of userID for the UTC month of at.
+func (s *Service) usedKey(userID string, at time.Time) string {
+	return s.kv.Key(kv.QuotaUsed, userID, at.Format("2006-01"))
+}
+
+// nextMonth returns the start of the UTC month after now.
+func nextMonth(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,181 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/migrations"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// newTestService returns a service over an in-process Redis whose clock starts at start.
+func newTestService(t *testing.T, limits Limits, start time.Time) (*Service, *fakeClock) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	clock := &fakeClock{now: start}
+	svc := NewService(kv.New(client, "test"), nil, limits, zap.NewNop().Sugar())
+	svc.now = clock.Now
+	return svc, clock
+}
+
+func TestQuotaRollsOverAtUTCMonthStart(t *testing.T) {
+	ctx := context.Background()
+	lastSecond := time.Date(2026, time.January, 31, 23, 59, 59, 0, time.UTC)
+	svc, clock := newTestService(t, Limits{TokensPerMonth: 100}, lastSecond)
+
+	svc.Count(db.UsageEvent{UserID: "u1", PromptTokens: 60, CompletionTokens: 40})
+	err := svc.Check(ctx, "u1", MetricTokens)
+	var apiErr *apierr.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != apierr.CodeQuotaExceeded {
+		t.Fatalf("Check at the limit = %v, want QUOTA_EXCEEDED", err)
+	}
+	if got := apiErr.Fields["resets_at"]; got != "2026-02-01T00:00:00Z" {
+		t.Errorf("resets_at = %v, want the start of February", got)
+	}
+	if err := svc.Check(ctx, "u2", MetricTokens); err != nil {
+		t.Errorf("another user was limited: %v", err)
+	}
+
+	clock.Set(lastSecond.Add(time.Second))
+	if err := svc.Check(ctx, "u1", MetricTokens); err != nil {
+		t.Fatalf("Check in the new month = %v, want the quota reset", err)
+	}
+
+	// usage recorded late still counts against the month it happened in
+	svc.Count(db.UsageEvent{UserID: "u1", At: lastSecond, PromptTokens: 500})
+	if err := svc.Check(ctx, "u1", MetricTokens); err != nil {
+		t.Errorf("January usage counted against February: %v", err)
+	}
+	svc.Count(db.UsageEvent{UserID: "u1", CompletionTokens: 100})
+	if err := svc.Check(ctx, "u1", MetricTokens); apierr.CodeOf(err, "") != apierr.CodeQuotaExceeded {
+		t.Errorf("Check after using February's quota = %v, want QUOTA_EXCEEDED", err)
+	}
+}
+
+func TestQuotaMonthIsUTC(t *testing.T) {
+	ctx := context.Background()
+	shanghai := time.FixedZone("CST", 8*60*60)
+	// already February in Shanghai, still January in UTC
+	localFebruary := time.Date(2026, time.February, 1, 7, 0, 0, 0, shanghai)
+	svc, clock := newTestService(t, Limits{TTSCharactersPerMonth: 10}, localFebruary)
+
+	svc.Count(db.UsageEvent{UserID: "u1", TTSCharacters: 10})
+	if err := svc.Check(ctx, "u1", MetricTTSCharacters); apierr.CodeOf(err, "") != apierr.CodeQuotaExceeded {
+		t.Fatalf("Check = %v, want QUOTA_EXCEEDED", err)
+	}
+	clock.Set(time.Date(2026, time.February, 1, 8, 0, 0, 0, shanghai))
+	if err := svc.Check(ctx, "u1", MetricTTSCharacters); err != nil {
+		t.Errorf("Check at 00:00 UTC = %v, want the quota reset", err)
+	}
+}
+
+func TestQuotaRollsOverIntoNewYear(t *testing.T) {
+	ctx := context.Background()
+	december := time.Date(2026, time.December, 31, 12, 0, 0, 0, time.UTC)
+	svc, clock := newTestService(t, Limits{ASRMinutesPerMonth: 1}, december)
+
+	svc.Count(db.UsageEvent{UserID: "u1", ASRMillis: 60_000})
+	err := svc.Check(ctx, "u1", MetricASRMinutes)
+	var apiErr *apierr.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Check = %v, want QUOTA_EXCEEDED", err)
+	}
+	if got := apiErr.Fields["resets_at"]; got != "2027-01-01T00:00:00Z" {
+		t.Errorf("resets_at = %v, want the next year", got)
+	}
+	clock.Set(time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC))
+	if err := svc.Check(ctx, "u1", MetricASRMinutes); err != nil {
+		t.Errorf("Check in January = %v, want the quota reset", err)
+	}
+}
+
+func TestQuotaWithoutRedisAllows(t *testing.T) {
+	var svc *Service
+	if err := svc.Check(context.Background(), "u1", MetricTokens); err != nil {
+		t.Errorf("nil service Check = %v", err)
+	}
+	svc.Count(db.UsageEvent{UserID: "u1", PromptTokens: 1})
+}
+
+// TestReconcileCountsErasedUsage erases part of a user's month and checks Reconcile still
+// counts it, so erasing data cannot reset a quota.
+func TestReconcileCountsErasedUsage(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	ctx := context.Background()
+	pool, err := db.NewPostgresPool(ctx, dsn, db.PostgresOptions{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if _, err := migrations.Up(ctx, pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	now := time.Now().UTC()
+	svc, _ := newTestService(t, Limits{TokensPerMonth: 100}, now)
+	svc.pools = db.NewPoolRouter(pool, nil, zap.NewNop().Sugar())
+	usage := db.NewUsageStore(svc.pools, nil, zap.NewNop().Sugar())
+	userID := fmt.Sprintf("quota-test-%d", now.UnixNano())
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), `DELETE FROM usage_events WHERE user_id = ANY($1)`,
+			[]string{userID, db.ErasedUserID(userID)})
+	})
+	insert := func(tokens int64) {
+		t.Helper()
+		_, err := pool.Exec(ctx, `INSERT INTO usage_events (user_id, service, day, requests, prompt_tokens)
+			VALUES ($1, 'chat', $2, 1, $3)
+			ON CONFLICT (user_id, day, service, role_id) DO UPDATE
+				SET prompt_tokens = usage_events.prompt_tokens + EXCLUDED.prompt_tokens`, userID, now, tokens)
+		if err != nil {
+			t.Fatalf("insert usage: %v", err)
+		}
+	}
+
+	insert(80)
+	if _, err := usage.DeleteByUser(ctx, userID); err != nil {
+		t.Fatalf("DeleteByUser: %v", err)
+	}
+	insert(20)
+	if err := svc.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if err := svc.Check(ctx, userID, MetricTokens); apierr.CodeOf(err, "") != apierr.CodeQuotaExceeded {
+		t.Errorf("Check after erasing usage = %v, want QUOTA_EXCEEDED", err)
+	}
+	if err := svc.Check(ctx, db.ErasedUserID(userID), MetricTokens); err != nil {
+		t.Errorf("the erased id got counters of its own: %v", err)
+	}
+}
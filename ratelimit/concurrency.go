@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+)
+
+// slotLease is how long a held slot survives without renewal, so the slots of a replica
+// that dies mid-stream free themselves. Holders renew every third of it.
+const slotLease = 30 * time.Second
+
+// acquireSlotScript drops expired slots, then adds ARGV[2] when fewer than ARGV[1] remain.
+// Slots are members of a sorted set scored by their expiry on the Redis clock.
+// Returns 1 when the slot was taken.
+var acquireSlotScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local lease = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+  return 0
+end
+redis.call('ZADD', KEYS[1], now + lease, ARGV[2])
+redis.call('PEXPIRE', KEYS[1], lease)
+return 1
+`)
+
+// renewSlotScript pushes back the expiry of slot ARGV[1] if it is still held.
+var renewSlotScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local lease = tonumber(ARGV[2])
+if redis.call('ZADD', KEYS[1], 'XX', 'CH', now + lease, ARGV[1]) == 0 and redis.call('ZSCORE', KEYS[1], ARGV[1]) == false then
+  return 0
+end
+redis.call('PEXPIRE', KEYS[1], lease)
+return 1
+`)
+
+// Concurrency caps how many requests of one caller run at once. Slots are leases in Redis
+// so the cap holds across replicas; without Redis, or when Redis fails, slots are counted
+// in process instead. A nil Concurrency admits everything.
+type Concurrency struct {
+	store     *kv.Store
+	limit     int
+	overrides map[string]int
+	logger    *zap.SugaredLogger
+
+	mu    sync.Mutex
+	local map[string]int
+}
+
+// NewConcurrency builds a limiter admitting limit concurrent requests per caller, or the
+// override of the caller's user ID; 0 means unlimited. It returns nil when neither limit
+// nor any override restricts anyone.
+func NewConcurrency(store *kv.Store, limit int, overrides map[string]int, logger *zap.SugaredLogger) *Concurrency {
+	restricted := limit > 0
+	for _, override := range overrides {
+		restricted = restricted || override > 0
+	}
+	if !restricted {
+		return nil
+	}
+	return &Concurrency{store: store, limit: limit, overrides: overrides, logger: logger, local: make(map[string]int)}
+}
+
+// Limit returns the concurrency limit of userID; 0 means unlimited.
+func (c *Concurrency) Limit(userID string) int {
+	if c == nil {
+		return 0
+	}
+	if override, ok := c.overrides[userID]; ok && userID != "" {
+		return override
+	}
+	return c.limit
+}
+
+// Acquire takes a slot for key, a caller identity limited as userID (empty for anonymous
+// callers). It returns the caller's limit, and ok false when all its slots are taken.
+// Otherwise release must be called exactly once when the request ends.
+func (c *Concurrency) Acquire(ctx context.Context, key, userID string) (release func(), limit int, ok bool) {
+	limit = c.Limit(userID)
+	if limit <= 0 {
+		return func() {}, limit, true
+	}
+	hashed := hashKey(key)
+
+	if c.store.Available() {
+		release, ok, err := c.acquireRedis(ctx, c.store.Key(kv.ChatSlots, hashed), limit)
+		if err == nil {
+			return release, limit, ok
+		}
+		c.logger.Warnf("concurrency redis acquire failed, counting locally: %v", err)
+	}
+	release, ok = c.acquireLocal(hashed, limit)
+	return release, limit, ok
+}
+
+func (c *Concurrency) acquireRedis(ctx context.Context, key string, limit int) (func(), bool, error) {
+	slot, err := newSlotID()
+	if err != nil {
+		return nil, false, err
+	}
+	acquireCtx, cancel := context.WithTimeout(ctx, redisDeadline)
+	taken, err := acquireSlotScript.Run(acquireCtx, c.store.Client(), []string{key}, limit, slot, slotLease.Milliseconds()).Int()
+	cancel()
+	if err != nil {
+		return nil, false, err
+	}
+	if taken != 1 {
+		return nil, false, nil
+	}
+
+	done := make(chan struct{})
+	go c.renew(key, slot, done)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			// the request context is often canceled by now: a client that went away is
+			// exactly the case whose slot must be freed
+			releaseCtx, cancel := context.WithTimeout(context.Background(), redisDeadline)
+			defer cancel()
+			if err := c.store.Client().ZRem(releaseCtx, key, slot).Err(); err != nil {
+				c.logger.Warnf("release concurrency slot failed, it expires in %s: %v", slotLease, err)
+			}
+		})
+	}, true, nil
+}
+
+// renew keeps slot leased until done is closed, so long streams keep their slot.
+func (c *Concurrency) renew(key, slot string, done <-chan struct{}) {
+	ticker := time.NewTicker(slotLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), redisDeadline)
+			err := renewSlotScript.Run(ctx, c.store.Client(), []string{key}, slot, slotLease.Milliseconds()).Err()
+			cancel()
+			if err != nil {
+				c.logger.Warnf("renew concurrency slot failed: %v", err)
+			}
+		}
+	}
+}
+
+func (c *Concurrency) acquireLocal(key string, limit int) (func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.local[key] >= limit {
+		return nil, false
+	}
+	c.local[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.local[key]--; c.local[key] <= 0 {
+				delete(c.local, key)
+			}
+		})
+	}, true
+}
+
+func newSlotID() (string, error) {
+	var raw [12]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw[:]), nil
+}
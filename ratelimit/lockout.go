@@ -0,0 +1,224 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+)
+
+// lockoutFailScript records one failure and, once the threshold is reached, locks the key
+// for base * 2^(failures - threshold), capped at max. The counter outlives the lockout by
+// window so a failure right after it ends doubles the next one.
+// Returns the lockout in milliseconds, 0 when not locked.
+var lockoutFailScript = redis.NewScript(`
+local threshold = tonumber(ARGV[1])
+local base = tonumber(ARGV[2])
+local max = tonumber(ARGV[3])
+local window = tonumber(ARGV[4])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local failures = redis.call('HINCRBY', KEYS[1], 'failures', 1)
+local lock = 0
+if failures >= threshold then
+  lock = math.floor(math.min(max, base * math.pow(2, math.min(failures - threshold, 40))))
+  redis.call('HSET', KEYS[1], 'until', now + lock)
+end
+redis.call('PEXPIRE', KEYS[1], lock + window)
+return lock
+`)
+
+// lockoutCheckScript returns the milliseconds left on the lockout of KEYS[1], 0 when free.
+var lockoutCheckScript = redis.NewScript(`
+local locked_until = tonumber(redis.call('HGET', KEYS[1], 'until'))
+if not locked_until then
+  return 0
+end
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+return math.max(0, locked_until - now)
+`)
+
+// LockoutPolicy configures a Lockout.
+type LockoutPolicy struct {
+	// Threshold is the number of consecutive failures that triggers the first lockout.
+	Threshold int
+	// Base is the first lockout; each further failure doubles it up to Max.
+	Base time.Duration
+	Max  time.Duration
+	// Window is how long failures are remembered after the last one (or after the lockout
+	// it caused ends).
+	Window time.Duration
+}
+
+// Lockout counts failures per key and locks keys out with exponential backoff, for example
+// after repeated wrong passwords. Like Limiter, state lives in Redis under a namespace and
+// falls back to process memory when Redis is missing or failing.
+type Lockout struct {
+	store     *kv.Store
+	namespace string
+	policy    LockoutPolicy
+	logger    *zap.SugaredLogger
+	now       func() time.Time
+
+	mu    sync.Mutex
+	local map[string]*lockState
+}
+
+type lockState struct {
+	failures int
+	until    time.Time
+	expires  time.Time
+}
+
+// NewLockout builds a lockout whose keys live under namespace, so independent limits (or
+// parallel tests) never share counters. It returns nil when policy.Threshold is not
+// positive; a nil Lockout never locks.
+func NewLockout(store *kv.Store, namespace string, policy LockoutPolicy, logger *zap.SugaredLogger) *Lockout {
+	if policy.Threshold <= 0 {
+		return nil
+	}
+	if policy.Base <= 0 {
+		policy.Base = time.Minute
+	}
+	if policy.Max < policy.Base {
+		policy.Max = policy.Base
+	}
+	if policy.Window <= 0 {
+		policy.Window = 15 * time.Minute
+	}
+	return &Lockout{
+		store:     store,
+		namespace: namespace,
+		policy:    policy,
+		logger:    logger,
+		now:       time.Now,
+		local:     make(map[string]*lockState),
+	}
+}
+
+// Locked returns how long key stays locked out, 0 when it may try again.
+func (l *Lockout) Locked(ctx context.Context, key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	lockKey := l.key(key)
+	if l.store.Available() {
+		ms, err := l.run(ctx, lockoutCheckScript, lockKey)
+		if err == nil {
+			return time.Duration(ms) * time.Millisecond
+		}
+		l.logger.Warnf("lockout redis check failed, using local state: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state := l.localStateLocked(lockKey, l.now())
+	if state == nil {
+		return 0
+	}
+	return positive(state.until.Sub(l.now()))
+}
+
+// Fail records a failure for key and returns the lockout it triggered, 0 when still under
+// the threshold.
+func (l *Lockout) Fail(ctx context.Context, key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	lockKey := l.key(key)
+	if l.store.Available() {
+		ms, err := l.run(ctx, lockoutFailScript, lockKey,
+			l.policy.Threshold, l.policy.Base.Milliseconds(), l.policy.Max.Milliseconds(), l.policy.Window.Milliseconds())
+		if err == nil {
+			return time.Duration(ms) * time.Millisecond
+		}
+		l.logger.Warnf("lockout redis update failed, using local state: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	state := l.localStateLocked(lockKey, now)
+	if state == nil {
+		if len(l.local) >= memoryPruneSize {
+			l.pruneLocked(now)
+		}
+		state = &lockState{}
+		l.local[lockKey] = state
+	}
+	state.failures++
+
+	var lock time.Duration
+	if state.failures >= l.policy.Threshold {
+		lock = l.policy.Base
+		for i := l.policy.Threshold; i < state.failures && lock < l.policy.Max; i++ {
+			lock *= 2
+		}
+		lock = time.Duration(math.Min(float64(lock), float64(l.policy.Max)))
+		state.until = now.Add(lock)
+	}
+	state.expires = now.Add(lock + l.policy.Window)
+	return lock
+}
+
+// Reset forgets the failures of key, typically after a successful attempt.
+func (l *Lockout) Reset(ctx context.Context, key string) {
+	if l == nil {
+		return
+	}
+	lockKey := l.key(key)
+	if l.store.Available() {
+		ctx, cancel := context.WithTimeout(ctx, redisDeadline)
+		defer cancel()
+		if err := l.store.Del(ctx, lockKey); err != nil {
+			l.logger.Warnf("lockout redis reset failed: %v", err)
+		}
+	}
+
+	l.mu.Lock()
+	delete(l.local, lockKey)
+	l.mu.Unlock()
+}
+
+func (l *Lockout) key(key string) string {
+	return l.store.Key(kv.Lockout, l.namespace, hashKey(key))
+}
+
+func (l *Lockout) run(ctx context.Context, script *redis.Script, key string, args ...interface{}) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisDeadline)
+	defer cancel()
+	return script.Run(ctx, l.store.Client(), []string{key}, args...).Int64()
+}
+
+// localStateLocked returns the in-memory state of key, dropping it once expired.
+func (l *Lockout) localStateLocked(key string, now time.Time) *lockState {
+	state, ok := l.local[key]
+	if !ok {
+		return nil
+	}
+	if !now.Before(state.expires) {
+		delete(l.local, key)
+		return nil
+	}
+	return state
+}
+
+func (l *Lockout) pruneLocked(now time.Time) {
+	for key, state := range l.local {
+		if !now.Before(state.expires) {
+			delete(l.local, key)
+		}
+	}
+}
+
+func positive(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+var testLockoutPolicy = LockoutPolicy{Threshold: 3, Base: time.Minute, Max: 4 * time.Minute, Window: 10 * time.Minute}
+
+// lockoutBackend builds a Lockout and a way to move its clock forward.
+type lockoutBackend struct {
+	name string
+	open func(t *testing.T) (*Lockout, func(time.Duration))
+}
+
+var lockoutBackends = []lockoutBackend{
+	{"memory", func(t *testing.T) (*Lockout, func(time.Duration)) {
+		l := NewLockout(nil, "test", testLockoutPolicy, zap.NewNop().Sugar())
+		now := time.Now()
+		l.now = func() time.Time { return now }
+		return l, func(d time.Duration) { now = now.Add(d) }
+	}},
+	{"redis", func(t *testing.T) (*Lockout, func(time.Duration)) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		now := time.Now()
+		server.SetTime(now)
+		l := NewLockout(kv.New(client, "test"), "test", testLockoutPolicy, zap.NewNop().Sugar())
+		return l, func(d time.Duration) {
+			// TIME in the scripts and key expiry are separate clocks in miniredis
+			now = now.Add(d)
+			server.SetTime(now)
+			server.FastForward(d)
+		}
+	}},
+}
+
+func TestLockoutThresholdAndBackoff(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range lockoutBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			l, advance := backend.open(t)
+
+			for i := 1; i < testLockoutPolicy.Threshold; i++ {
+				if lock := l.Fail(ctx, "alice"); lock != 0 {
+					t.Fatalf("failure %d locked for %s, want no lock below the threshold", i, lock)
+				}
+			}
+			if wait := l.Locked(ctx, "alice"); wait != 0 {
+				t.Fatalf("Locked below the threshold = %s", wait)
+			}
+
+			// each failure from the threshold on doubles the lock, capped at Max
+			for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+				if lock := l.Fail(ctx, "alice"); lock != want {
+					t.Fatalf("failure %d locked for %s, want %s", testLockoutPolicy.Threshold+i, lock, want)
+				}
+			}
+			if wait := l.Locked(ctx, "alice"); wait <= 3*time.Minute || wait > 4*time.Minute {
+				t.Errorf("Locked right after a 4m lock = %s", wait)
+			}
+			if wait := l.Locked(ctx, "bob"); wait != 0 {
+				t.Errorf("another key is locked for %s", wait)
+			}
+
+			advance(4*time.Minute + time.Second)
+			if wait := l.Locked(ctx, "alice"); wait != 0 {
+				t.Errorf("Locked after the lock window = %s, want 0", wait)
+			}
+			// failures are still remembered within Window, so the next one locks again
+			if lock := l.Fail(ctx, "alice"); lock != 4*time.Minute {
+				t.Errorf("failure after the lock ended locked for %s, want 4m", lock)
+			}
+		})
+	}
+}
+
+func TestLockoutForgetsFailuresAfterWindow(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range lockoutBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			l, advance := backend.open(t)
+
+			for range testLockoutPolicy.Threshold - 1 {
+				l.Fail(ctx, "alice")
+			}
+			advance(testLockoutPolicy.Window + time.Second)
+			if lock := l.Fail(ctx, "alice"); lock != 0 {
+				t.Errorf("failure after the window locked for %s, want the count restarted", lock)
+			}
+		})
+	}
+}
+
+func TestLockoutResetOnSuccess(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range lockoutBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			l, _ := backend.open(t)
+
+			for range testLockoutPolicy.Threshold {
+				l.Fail(ctx, "alice")
+			}
+			if l.Locked(ctx, "alice") == 0 {
+				t.Fatal("key is not locked at the threshold")
+			}
+			l.Reset(ctx, "alice")
+			if wait := l.Locked(ctx, "alice"); wait != 0 {
+				t.Errorf("Locked after Reset = %s", wait)
+			}
+			for i := 1; i < testLockoutPolicy.Threshold; i++ {
+				if lock := l.Fail(ctx, "alice"); lock != 0 {
+					t.Errorf("failure %d after Reset locked for %s, want the count restarted", i, lock)
+				}
+			}
+		})
+	}
+}
+
+func TestLockoutFallsBackWhenRedisFails(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	l := NewLockout(kv.New(client, "test"), "test", testLockoutPolicy, zap.NewNop().Sugar())
+	server.Close()
+
+	for range testLockoutPolicy.Threshold {
+		l.Fail(ctx, "alice")
+	}
+	if wait := l.Locked(ctx, "alice"); wait == 0 {
+		t.Error("key is not locked in memory while Redis is down")
+	}
+}
+
+func TestNilLockout(t *testing.T) {
+	ctx := context.Background()
+	l := NewLockout(nil, "test", LockoutPolicy{}, zap.NewNop().Sugar())
+	if l != nil {
+		t.Fatal("NewLockout with a zero threshold returned a lockout")
+	}
+	if lock := l.Fail(ctx, "alice"); lock != 0 {
+		t.Errorf("nil Lockout Fail = %s", lock)
+	}
+	if wait := l.Locked(ctx, "alice"); wait != 0 {
+		t.Errorf("nil Lockout Locked = %s", wait)
+	}
+	l.Reset(ctx, "alice")
+}
@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+)
+
+const (
+	redisDeadline = 300 * time.Millisecond
+	// memoryPruneSize bounds the in-memory fallback: past it refilled buckets are dropped,
+	// then the least recently used ones until a tenth of the room is free again.
+	memoryPruneSize = 10000
+)
+
+// tokenBucketScript refills and takes one token atomically. It uses the Redis clock so
+// replicas with skewed clocks share one consistent bucket.
+// Returns {allowed, retry_after_ms}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) + 1000)
+return {allowed, wait}
+`)
+
+// Decision is the outcome of a single Allow call.
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Limiter is a token bucket keyed by caller. Bucket state lives in Redis so the limit holds
+// across replicas; without Redis, or when Redis fails, an in-process bucket is used instead.
+type Limiter struct {
+	store  *kv.Store
+	rate   float64 // tokens per millisecond
+	burst  int
+	logger *zap.SugaredLogger
+
+	// now and maxBuckets are the clock and size cap of the in-memory fallback.
+	now        func() time.Time
+	maxBuckets int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// New builds a limiter allowing perMinute requests per key with bursts of up to burst.
+// It returns nil when perMinute is not positive; a nil Limiter allows everything.
+func New(store *kv.Store, perMinute, burst int, logger *zap.SugaredLogger) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &Limiter{
+		store:      store,
+		rate:       float64(perMinute) / float64(time.Minute/time.Millisecond),
+		burst:      burst,
+		logger:     logger,
+		now:        time.Now,
+		maxBuckets: memoryPruneSize,
+		buckets:    make(map[string]*bucket),
+	}
+}
+
+// Allow takes one token from the bucket of (scope, key).
+func (l *Limiter) Allow(ctx context.Context, scope, key string) Decision {
+	if l == nil {
+		return Decision{Allowed: true}
+	}
+	bucketKey := scope + ":" + hashKey(key)
+
+	if l.store.Available() {
+		decision, err := l.allowRedis(ctx, l.store.Key(kv.RateLimit, scope, hashKey(key)))
+		if err == nil {
+			return decision
+		}
+		l.logger.Warnf("rate limit redis check failed, using local bucket: %v", err)
+	}
+	return l.allowLocal(bucketKey, l.now())
+}
+
+func (l *Limiter) allowRedis(ctx context.Context, key string) (Decision, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisDeadline)
+	defer cancel()
+
+	values, err := tokenBucketScript.Run(ctx, l.store.Client(), []string{key}, l.rate, l.burst).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(values) < 2 {
+		return Decision{Allowed: true}, nil
+	}
+	return Decision{Allowed: values[0] == 1, RetryAfter: time.Duration(values[1]) * time.Millisecond}, nil
+}
+
+func (l *Limiter) allowLocal(key string, now time.Time) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxBuckets {
+			l.pruneLocked(now)
+		}
+		b = &bucket{tokens: float64(l.burst), at: now}
+		l.buckets[key] = b
+	}
+
+	elapsed := float64(now.Sub(b.at) / time.Millisecond)
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(l.burst), b.tokens+elapsed*l.rate)
+	}
+	b.at = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return Decision{Allowed: true}
+	}
+	wait := math.Ceil((1 - b.tokens) / l.rate)
+	return Decision{RetryAfter: time.Duration(wait) * time.Millisecond}
+}
+
+// pruneLocked drops buckets that would have refilled completely by now. When the callers
+// are all still refilling that frees nothing, so the least recently used buckets are then
+// evicted until a tenth of maxBuckets is free; an evicted caller starts over with a full
+// bucket.
+func (l *Limiter) pruneLocked(now time.Time) {
+	full := time.Duration(float64(l.burst)/l.rate) * time.Millisecond
+	for key, b := range l.buckets {
+		if now.Sub(b.at) >= full {
+			delete(l.buckets, key)
+		}
+	}
+
+	target := l.maxBuckets - max(l.maxBuckets/10, 1)
+	if len(l.buckets) <= target {
+		return
+	}
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return l.buckets[keys[i]].at.Before(l.buckets[keys[j]].at) })
+	for _, key := range keys[:len(keys)-target] {
+		delete(l.buckets, key)
+	}
+}
+
+// hashKey keeps raw Qiniu tokens out of Redis keys.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
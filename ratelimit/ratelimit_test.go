@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/kv"
+)
+
+// limiterBackend builds a Limiter allowing perMinute with burst and a way to move its
+// clock forward.
+type limiterBackend struct {
+	name string
+	open func(t *testing.T, perMinute, burst int) (*Limiter, func(time.Duration))
+}
+
+var limiterBackends = []limiterBackend{
+	{"memory", func(t *testing.T, perMinute, burst int) (*Limiter, func(time.Duration)) {
+		l := New(nil, perMinute, burst, zap.NewNop().Sugar())
+		now := time.Now()
+		l.now = func() time.Time { return now }
+		return l, func(d time.Duration) { now = now.Add(d) }
+	}},
+	{"redis", func(t *testing.T, perMinute, burst int) (*Limiter, func(time.Duration)) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		now := time.Now()
+		server.SetTime(now)
+		l := New(kv.New(client, "test"), perMinute, burst, zap.NewNop().Sugar())
+		l.now = func() time.Time {
+			t.Error("the local bucket was used while Redis was up")
+			return now
+		}
+		return l, func(d time.Duration) {
+			// TIME in the script and key expiry are separate clocks in miniredis
+			now = now.Add(d)
+			server.SetTime(now)
+			server.FastForward(d)
+		}
+	}},
+}
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range limiterBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			// one token a second, three at once
+			l, advance := backend.open(t, 60, 3)
+
+			for i := range 3 {
+				if d := l.Allow(ctx, "tts", "alice"); !d.Allowed {
+					t.Fatalf("request %d of the burst rejected", i+1)
+				}
+			}
+			d := l.Allow(ctx, "tts", "alice")
+			if d.Allowed {
+				t.Fatal("request past the burst allowed")
+			}
+			if d.RetryAfter <= 0 || d.RetryAfter > time.Second {
+				t.Errorf("RetryAfter = %s, want up to a second", d.RetryAfter)
+			}
+			if d := l.Allow(ctx, "tts", "bob"); !d.Allowed {
+				t.Error("another caller shares alice's bucket")
+			}
+			if d := l.Allow(ctx, "asr", "alice"); !d.Allowed {
+				t.Error("another scope shares alice's bucket")
+			}
+
+			advance(time.Second)
+			if d := l.Allow(ctx, "tts", "alice"); !d.Allowed {
+				t.Fatal("request after a token refilled rejected")
+			}
+			if d := l.Allow(ctx, "tts", "alice"); d.Allowed {
+				t.Fatal("second request after one refilled token allowed")
+			}
+
+			// a long pause refills to the burst, never past it
+			advance(time.Hour)
+			for i := range 3 {
+				if d := l.Allow(ctx, "tts", "alice"); !d.Allowed {
+					t.Fatalf("request %d after the window rejected", i+1)
+				}
+			}
+			if d := l.Allow(ctx, "tts", "alice"); d.Allowed {
+				t.Error("bucket refilled past its burst")
+			}
+		})
+	}
+}
+
+func TestLimiterFallsBackWhenRedisFails(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	l := New(kv.New(client, "test"), 60, 1, zap.NewNop().Sugar())
+	ctx := context.Background()
+
+	if d := l.Allow(ctx, "tts", "alice"); !d.Allowed {
+		t.Fatal("first request rejected")
+	}
+	server.Close()
+	// the local bucket starts full, then limits on its own
+	if d := l.Allow(ctx, "tts", "alice"); !d.Allowed {
+		t.Fatal("first request on the local bucket rejected")
+	}
+	if d := l.Allow(ctx, "tts", "alice"); d.Allowed {
+		t.Fatal("local bucket does not limit")
+	}
+}
+
+func TestLimiterEvictsWhenEveryBucketIsRefilling(t *testing.T) {
+	l := New(nil, 60, 1, zap.NewNop().Sugar())
+	l.maxBuckets = 10
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// every caller drains its bucket, so none has refilled when the cap is hit
+	for i := range 50 {
+		now = now.Add(time.Millisecond)
+		if d := l.Allow(ctx, "tts", fmt.Sprintf("caller-%d", i)); !d.Allowed {
+			t.Fatalf("first request of caller %d rejected", i)
+		}
+		if len(l.buckets) > l.maxBuckets {
+			t.Fatalf("%d buckets held after caller %d, want at most %d", len(l.buckets), i, l.maxBuckets)
+		}
+	}
+	// the most recent caller is still limited, the oldest was evicted and starts over
+	if d := l.Allow(ctx, "tts", "caller-49"); d.Allowed {
+		t.Error("most recent caller lost its bucket")
+	}
+	if d := l.Allow(ctx, "tts", "caller-0"); !d.Allowed {
+		t.Error("oldest caller was not evicted")
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := New(nil, 0, 5, zap.NewNop().Sugar())
+	if l != nil {
+		t.Fatal("New with no rate built a limiter")
+	}
+	for range 100 {
+		if d := l.Allow(context.Background(), "tts", "alice"); !d.Allowed {
+			t.Fatal("nil limiter rejected a request")
+		}
+	}
+}
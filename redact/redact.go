@@ -0,0 +1,113 @@
+// Package redact scrubs personal data (phone numbers, emails, ID card numbers) from
+// conversation text before it is stored.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Placeholders replacing redacted text.
+const (
+	PhonePlaceholder  = "[PHONE]"
+	EmailPlaceholder  = "[EMAIL]"
+	IDCardPlaceholder = "[ID_CARD]"
+	CustomPlaceholder = "[REDACTED]"
+)
+
+type rule struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}
+
+// builtinRules cover mainland China resident ID numbers (18 digits, or 15 for the old
+// format), mobile numbers with an optional +86, landlines with an area code, and email
+// addresses.
+var builtinRules = []rule{
+	{regexp.MustCompile(`\b[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`), IDCardPlaceholder},
+	{regexp.MustCompile(`\b[1-9]\d{7}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}\b`), IDCardPlaceholder},
+	{regexp.MustCompile(`(?:\+86[\s-]?|\b86[\s-]?|\b)1[3-9]\d(?:[\s-]?\d{4}){2}\b`), PhonePlaceholder},
+	{regexp.MustCompile(`\b0\d{2,3}[\s-]\d{7,8}\b`), PhonePlaceholder},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`), EmailPlaceholder},
+}
+
+// Redactor replaces personal data in text with typed placeholders. A nil Redactor leaves
+// text as it is.
+type Redactor struct {
+	rules []rule
+}
+
+// New builds a Redactor applying the built-in rules and then patterns, regular expressions
+// in RE2 syntax whose matches become [REDACTED].
+func New(patterns []string) (*Redactor, error) {
+	r := &Redactor{rules: append([]rule(nil), builtinRules...)}
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile redaction pattern %q: %w", pattern, err)
+		}
+		r.rules = append(r.rules, rule{compiled, CustomPlaceholder})
+	}
+	return r, nil
+}
+
+type match struct {
+	start, end, rule int
+}
+
+// Redact returns text with every match replaced by its placeholder. Matches of all rules
+// are found in the original text first, so a placeholder is never matched again; where
+// matches overlap, the one starting first wins, then the longest, then the earlier rule.
+func (r *Redactor) Redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	var matches []match
+	for i, rule := range r.rules {
+		for _, loc := range rule.pattern.FindAllStringIndex(text, -1) {
+			if loc[1] > loc[0] {
+				matches = append(matches, match{start: loc[0], end: loc[1], rule: i})
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return text
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		if a.end != b.end {
+			return a.end > b.end
+		}
+		return a.rule < b.rule
+	})
+
+	var b strings.Builder
+	b.Grow(len(text))
+	last := 0
+	for _, m := range matches {
+		if m.start < last {
+			continue
+		}
+		b.WriteString(text[last:m.start])
+		b.WriteString(r.rules[m.rule].placeholder)
+		last = m.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// RedactAll redacts every string of texts in place and returns it.
+func (r *Redactor) RedactAll(texts []string) []string {
+	if r == nil {
+		return texts
+	}
+	for i, text := range texts {
+		texts[i] = r.Redact(text)
+	}
+	return texts
+}
@@ -0,0 +1,95 @@
+package redact
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactBuiltinRules(t *testing.T) {
+	r, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"mobile", "call 13812345678 later", "call [PHONE] later"},
+		{"mobile in chinese text", "我的手机是13812345678，记一下", "我的手机是[PHONE]，记一下"},
+		{"mobile with country code", "+86 138-1234-5678", "[PHONE]"},
+		{"mobile grouped with spaces", "86 138 1234 5678", "[PHONE]"},
+		{"landline", "office 010-12345678", "office [PHONE]"},
+		{"landline with four digit area code", "0755 1234567", "[PHONE]"},
+		{"email", "mail a.b+tag@mail.example.com.cn now", "mail [EMAIL] now"},
+		{"email in chinese text", "邮箱是zhang_san@163.com。", "邮箱是[EMAIL]。"},
+		{"id card", "身份证11010519491231002X", "身份证[ID_CARD]"},
+		{"id card lowercase x", "110105194912310021 and 11010519491231002x", "[ID_CARD] and [ID_CARD]"},
+		{"old id card", "old id 110105491231002", "old id [ID_CARD]"},
+		{"several kinds", "13812345678 / a@b.io / 110105194912310021", "[PHONE] / [EMAIL] / [ID_CARD]"},
+
+		{"not a mobile prefix", "12812345678", "12812345678"},
+		{"too many digits", "138123456789", "138123456789"},
+		{"part of a longer number", "order 913812345678001", "order 913812345678001"},
+		{"id card with impossible month", "110105194913310021", "110105194913310021"},
+		{"year", "in 2024 we met", "in 2024 we met"},
+		{"price", "¥1380.50", "¥1380.50"},
+		{"at without domain", "meet @ noon, user@localhost", "meet @ noon, user@localhost"},
+		{"empty", "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := r.Redact(tc.text); got != tc.want {
+				t.Errorf("Redact(%q) = %q, want %q", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRedactCustomPatterns(t *testing.T) {
+	r, err := New([]string{`(?i)\bacct-\d+\b`, `\d{4}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// overlapping matches: the earliest wins, then the longest, so a phone number is not
+	// chopped up by the four-digit pattern
+	if got, want := r.Redact("ACCT-42 paid 13812345678 in 2024"), "[REDACTED] paid [PHONE] in [REDACTED]"; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+	// placeholders are never matched again
+	if got, want := r.Redact("[PHONE] 1234"), "[PHONE] [REDACTED]"; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+
+	if _, err := New([]string{`(`}); err == nil {
+		t.Error("New accepted an invalid pattern")
+	}
+}
+
+func TestRedactEmptyMatchesAreSkipped(t *testing.T) {
+	r, err := New([]string{`x*`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Redact("abc"); got != "abc" {
+		t.Errorf("Redact = %q, want empty matches ignored", got)
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	var r *Redactor
+	if got := r.Redact("13812345678"); got != "13812345678" {
+		t.Errorf("nil Redact = %q", got)
+	}
+	texts := []string{"a@b.io"}
+	if got := r.RedactAll(texts); !reflect.DeepEqual(got, []string{"a@b.io"}) {
+		t.Errorf("nil RedactAll = %q", got)
+	}
+
+	r, _ = New(nil)
+	texts = []string{"a@b.io", "plain"}
+	r.RedactAll(texts)
+	if !reflect.DeepEqual(texts, []string{"[EMAIL]", "plain"}) {
+		t.Errorf("RedactAll = %q, want the slice redacted in place", texts)
+	}
+}
@@ -0,0 +1,140 @@
+package roles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// Definition is the portable form of a role used by export and import bundles. It carries
+// no database ID or archive state, so a bundle can move between environments.
+type Definition struct {
+	Name           string          `json:"name"`
+	Domain         string          `json:"domain"`
+	Tags           models.TagList  `json:"tags"`
+	Bio            string          `json:"bio"`
+	Personality    json.RawMessage `json:"personality"`
+	Background     string          `json:"background"`
+	Languages      []string        `json:"languages"`
+	Skills         json.RawMessage `json:"skills"`
+	VoiceType      string          `json:"voice_type"`
+	SpeedRatio     float64         `json:"speed_ratio"`
+	AvatarURL      string          `json:"avatar_url"`
+	VoiceSampleURL string          `json:"voice_sample_url"`
+}
+
+// DefinitionFromRole converts a stored role to its portable form.
+func DefinitionFromRole(role models.Role) Definition {
+	return Definition{
+		Name:           role.Name,
+		Domain:         role.Domain,
+		Tags:           role.Tags,
+		Bio:            role.Bio,
+		Personality:    role.Personality,
+		Background:     role.Background,
+		Languages:      role.Languages,
+		Skills:         role.Skills,
+		VoiceType:      role.VoiceType,
+		SpeedRatio:     role.SpeedRatio,
+		AvatarURL:      role.AvatarURL,
+		VoiceSampleURL: role.VoiceSampleURL,
+	}
+}
+
+// Role converts d to a model, trimming text and defaulting empty JSON columns the way the
+// role API does.
+func (d Definition) Role() models.Role {
+	role := models.Role{
+		Name:           strings.TrimSpace(d.Name),
+		Domain:         strings.TrimSpace(d.Domain),
+		Tags:           models.NormalizeTags(d.Tags),
+		Bio:            strings.TrimSpace(d.Bio),
+		Personality:    d.Personality,
+		Background:     strings.TrimSpace(d.Background),
+		Languages:      d.Languages,
+		Skills:         d.Skills,
+		VoiceType:      strings.TrimSpace(d.VoiceType),
+		SpeedRatio:     d.SpeedRatio,
+		AvatarURL:      strings.TrimSpace(d.AvatarURL),
+		VoiceSampleURL: strings.TrimSpace(d.VoiceSampleURL),
+	}
+	if role.Languages == nil {
+		role.Languages = []string{}
+	}
+	if trimmed := bytes.TrimSpace(role.Personality); len(trimmed) == 0 || string(trimmed) == "null" {
+		role.Personality = json.RawMessage("{}")
+	}
+	if trimmed := bytes.TrimSpace(role.Skills); len(trimmed) == 0 || string(trimmed) == "null" {
+		role.Skills = json.RawMessage("[]")
+	}
+	return role
+}
+
+// Export returns the definitions of every role, sorted by name.
+func Export(ctx context.Context, repo db.RoleRepository, includeArchived bool) ([]Definition, error) {
+	stored, err := repo.List(ctx, db.RoleFilter{Sort: "name", IncludeArchived: includeArchived})
+	if err != nil {
+		return nil, err
+	}
+	defs := make([]Definition, 0, len(stored))
+	for _, role := range stored {
+		defs = append(defs, DefinitionFromRole(role))
+	}
+	return defs, nil
+}
+
+// ImportReport summarizes an import.
+type ImportReport struct {
+	DryRun    bool                  `json:"dry_run"`
+	Created   int                   `json:"created"`
+	Updated   int                   `json:"updated"`
+	Unchanged int                   `json:"unchanged"`
+	Results   []db.RoleImportResult `json:"results"`
+}
+
+// Import validates every definition and then upserts them by name in one transaction.
+// Any invalid definition or duplicate name rejects the whole bundle with FieldErrors whose
+// fields are prefixed by the definition index, e.g. "roles[2].name".
+func Import(ctx context.Context, repo db.RoleRepository, defs []Definition, dryRun bool) (*ImportReport, error) {
+	var errs FieldErrors
+	seen := make(map[string]int, len(defs))
+	batch := make([]models.Role, 0, len(defs))
+	for i, def := range defs {
+		role := def.Role()
+		for _, fe := range Validate(role) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("roles[%d].%s", i, fe.Field), Message: fe.Message})
+		}
+		if first, dup := seen[role.Name]; dup && role.Name != "" {
+			errs.add(fmt.Sprintf("roles[%d].name", i), "duplicates roles[%d]", first)
+		} else {
+			seen[role.Name] = i
+		}
+		batch = append(batch, role)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	results, err := repo.Import(ctx, batch, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: dryRun, Results: results}
+	for _, result := range results {
+		switch result.Action {
+		case db.RoleImportCreated:
+			report.Created++
+		case db.RoleImportUpdated:
+			report.Updated++
+		default:
+			report.Unchanged++
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,30 @@
+package roles
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// ParseDefinitions decodes a roles file: a list of definitions in the export bundle format,
+// written as JSON or, when name ends in .yaml or .yml, as YAML with the same keys.
+// Personality and skills may be given as YAML mappings and lists; they are stored as JSON.
+func ParseDefinitions(name string, data []byte) ([]Definition, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		converted, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+		data = converted
+	}
+
+	var defs []Definition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", name, err)
+	}
+	return defs, nil
+}
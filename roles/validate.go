@@ -0,0 +1,186 @@
+package roles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/locale"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+// Field limits enforced by Validate.
+const (
+	MaxNameLength       = 255
+	MaxDomainLength     = 255
+	MaxTags             = 20
+	MaxTagLength        = 50
+	MaxBioLength        = 2000
+	MaxBackgroundLength = 8000
+	MaxConstraints      = 20
+	MaxConstraintLength = 200
+	MaxLanguages        = 10
+	MaxURLLength        = 2048
+)
+
+// KnownLanguages are the ISO 639-1 codes a role may declare. Region suffixes such as
+// zh-CN are accepted and checked by their base code.
+var KnownLanguages = locale.Known
+
+// FieldError describes one invalid field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FieldErrors is the result of Validate; it is empty when the role is valid.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, fe := range e {
+		parts = append(parts, fe.Field+": "+fe.Message)
+	}
+	return "invalid role: " + strings.Join(parts, "; ")
+}
+
+func (e *FieldErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// personality and skill mirror the JSON shapes the prompt builder decodes.
+type personality struct {
+	Tone        string   `json:"tone"`
+	Style       string   `json:"style"`
+	Constraints []string `json:"constraints"`
+}
+
+type skill struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Validate checks role before it is stored, so bad input is rejected up front rather than
+// breaking the prompt builder later. It returns every problem found, or nil.
+func Validate(role models.Role) FieldErrors {
+	var errs FieldErrors
+
+	requiredText(&errs, "name", role.Name, MaxNameLength)
+	requiredText(&errs, "domain", role.Domain, MaxDomainLength)
+	if len(role.Tags) > MaxTags {
+		errs.add("tags", "at most %d tags are allowed", MaxTags)
+	}
+	for i, tag := range role.Tags {
+		maxText(&errs, fmt.Sprintf("tags[%d]", i), tag, MaxTagLength)
+	}
+	maxText(&errs, "bio", role.Bio, MaxBioLength)
+	maxText(&errs, "background", role.Background, MaxBackgroundLength)
+
+	if len(role.Languages) > MaxLanguages {
+		errs.add("languages", "at most %d languages are allowed", MaxLanguages)
+	}
+	for i, lang := range role.Languages {
+		if _, ok := locale.Normalize(lang); !ok {
+			errs.add(fmt.Sprintf("languages[%d]", i), "unknown language code %q", lang)
+		}
+	}
+
+	validatePersonality(&errs, role.Personality)
+	validateSkills(&errs, role.Skills)
+
+	mediaURL(&errs, "avatar_url", role.AvatarURL)
+	mediaURL(&errs, "voice_sample_url", role.VoiceSampleURL)
+
+	if role.SpeedRatio != 0 && (role.SpeedRatio < 0.2 || role.SpeedRatio > 3.0) {
+		errs.add("speed_ratio", "must be between 0.2 and 3.0")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func requiredText(errs *FieldErrors, field, value string, limit int) {
+	if strings.TrimSpace(value) == "" {
+		errs.add(field, "is required")
+		return
+	}
+	maxText(errs, field, value, limit)
+}
+
+func maxText(errs *FieldErrors, field, value string, limit int) {
+	if n := utf8.RuneCountInString(value); n > limit {
+		errs.add(field, "must be at most %d characters (got %d)", limit, n)
+	}
+}
+
+// mediaURL accepts absolute http(s) URLs and server-relative paths such as /static/avatars/1.png.
+func mediaURL(errs *FieldErrors, field, value string) {
+	if value == "" {
+		return
+	}
+	if len(value) > MaxURLLength {
+		errs.add(field, "must be at most %d characters", MaxURLLength)
+		return
+	}
+	if !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "/") {
+		errs.add(field, "must be an http(s) URL or a path starting with /")
+	}
+}
+
+func validatePersonality(errs *FieldErrors, raw json.RawMessage) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || string(trimmed) == "null" {
+		return
+	}
+
+	var p personality
+	if err := json.Unmarshal(trimmed, &p); err != nil {
+		errs.add("personality", "must be an object with tone, style and constraints")
+		return
+	}
+	if len(p.Constraints) > MaxConstraints {
+		errs.add("personality.constraints", "at most %d constraints are allowed", MaxConstraints)
+	}
+	for i, constraint := range p.Constraints {
+		field := fmt.Sprintf("personality.constraints[%d]", i)
+		if strings.TrimSpace(constraint) == "" {
+			errs.add(field, "must not be empty")
+			continue
+		}
+		maxText(errs, field, constraint, MaxConstraintLength)
+	}
+}
+
+func validateSkills(errs *FieldErrors, raw json.RawMessage) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || string(trimmed) == "null" {
+		return
+	}
+
+	var skills []skill
+	if err := json.Unmarshal(trimmed, &skills); err != nil {
+		errs.add("skills", "must be an array of {id, name} objects")
+		return
+	}
+	seen := make(map[string]struct{}, len(skills))
+	for i, s := range skills {
+		field := fmt.Sprintf("skills[%d].id", i)
+		id := strings.TrimSpace(s.ID)
+		switch {
+		case id == "":
+			errs.add(field, "is required")
+		case !services.KnownSkill(id):
+			errs.add(field, "unknown skill %q", id)
+		default:
+			if _, dup := seen[id]; dup {
+				errs.add(field, "duplicate skill %q", id)
+			}
+			seen[id] = struct{}{}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package roles
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+func validRole() models.Role {
+	return models.Role{
+		Name:        "Socrates",
+		Domain:      "philosophy",
+		Tags:        []string{"classic"},
+		Bio:         "Athenian philosopher.",
+		Languages:   []string{"zh-CN", "en"},
+		Personality: json.RawMessage(`{"tone":"calm","style":"questioning","constraints":["never lecture"]}`),
+		Skills:      json.RawMessage(`[{"id":"socratic_questions","name":"苏格拉底式提问"}]`),
+		AvatarURL:   "/static/avatars/socrates.png",
+		SpeedRatio:  1.0,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(*models.Role)
+		fields []string
+	}{
+		{"valid", func(*models.Role) {}, nil},
+		{"valid without optional fields", func(r *models.Role) {
+			*r = models.Role{Name: "Minimal", Domain: "misc"}
+		}, nil},
+		{"null json fields", func(r *models.Role) {
+			r.Personality, r.Skills = json.RawMessage("null"), json.RawMessage(" null ")
+		}, nil},
+		{"missing name and domain", func(r *models.Role) { r.Name, r.Domain = "  ", "" }, []string{"name", "domain"}},
+		{"name too long", func(r *models.Role) { r.Name = strings.Repeat("名", MaxNameLength+1) }, []string{"name"}},
+		{"name at the limit", func(r *models.Role) { r.Name = strings.Repeat("名", MaxNameLength) }, nil},
+		{"too many tags", func(r *models.Role) { r.Tags = make([]string, MaxTags+1) }, []string{"tags"}},
+		{"tag too long", func(r *models.Role) { r.Tags = []string{"ok", strings.Repeat("t", MaxTagLength+1)} }, []string{"tags[1]"}},
+		{"bio too long", func(r *models.Role) { r.Bio = strings.Repeat("b", MaxBioLength+1) }, []string{"bio"}},
+		{"background too long", func(r *models.Role) { r.Background = strings.Repeat("b", MaxBackgroundLength+1) }, []string{"background"}},
+		{"unknown language", func(r *models.Role) { r.Languages = []string{"en", "xx"} }, []string{"languages[1]"}},
+		{"too many languages", func(r *models.Role) {
+			r.Languages = []string{"en", "zh", "ja", "ko", "fr", "de", "es", "it", "ru", "pt", "ar"}
+		}, []string{"languages"}},
+		{"personality not an object", func(r *models.Role) { r.Personality = json.RawMessage(`"cheerful"`) }, []string{"personality"}},
+		{"empty constraint", func(r *models.Role) {
+			r.Personality = json.RawMessage(`{"constraints":["ok"," "]}`)
+		}, []string{"personality.constraints[1]"}},
+		{"constraint too long", func(r *models.Role) {
+			r.Personality = json.RawMessage(`{"constraints":["` + strings.Repeat("c", MaxConstraintLength+1) + `"]}`)
+		}, []string{"personality.constraints[0]"}},
+		{"skills not an array", func(r *models.Role) { r.Skills = json.RawMessage(`{"id":"x"}`) }, []string{"skills"}},
+		{"skill without id", func(r *models.Role) { r.Skills = json.RawMessage(`[{"name":"nameless"}]`) }, []string{"skills[0].id"}},
+		{"unknown skill", func(r *models.Role) { r.Skills = json.RawMessage(`[{"id":"telepathy"}]`) }, []string{"skills[0].id"}},
+		{"duplicate skill", func(r *models.Role) {
+			r.Skills = json.RawMessage(`[{"id":"socratic_questions"},{"id":"socratic_questions"}]`)
+		}, []string{"skills[1].id"}},
+		{"relative avatar url", func(r *models.Role) { r.AvatarURL = "avatars/1.png" }, []string{"avatar_url"}},
+		{"voice sample url too long", func(r *models.Role) {
+			r.VoiceSampleURL = "https://cdn.example.com/" + strings.Repeat("a", MaxURLLength)
+		}, []string{"voice_sample_url"}},
+		{"speed ratio out of range", func(r *models.Role) { r.SpeedRatio = 3.5 }, []string{"speed_ratio"}},
+		{"every problem is reported", func(r *models.Role) {
+			r.Name, r.SpeedRatio, r.AvatarURL = "", 0.1, "ftp://x"
+		}, []string{"name", "avatar_url", "speed_ratio"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			role := validRole()
+			tc.mutate(&role)
+			errs := Validate(role)
+
+			var fields []string
+			for _, fe := range errs {
+				fields = append(fields, fe.Field)
+			}
+			if !reflect.DeepEqual(fields, tc.fields) {
+				t.Errorf("Validate reported fields %v, want %v (%v)", fields, tc.fields, errs)
+			}
+			if tc.fields == nil && errs != nil {
+				t.Errorf("Validate returned a non-nil empty result for a valid role")
+			}
+		})
+	}
+}
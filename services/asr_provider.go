@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ASR provider names accepted by ASR_PROVIDER.
+const (
+	ASRProviderQiniu  = "qiniu"
+	ASRProviderOpenAI = "openai"
+)
+
+// ASRProvider is a speech recognition backend.
+type ASRProvider interface {
+	Name() string
+	// Recognize transcribes a whole recording, given by URL or inline data.
+	Recognize(ctx context.Context, token string, input ASRInput) (*ASRResult, error)
+	// OpenStream starts a session fed incrementally with raw PCM in the layout of opts.
+	OpenStream(ctx context.Context, token string, opts ASRStreamOptions) (*ASRStream, error)
+}
+
+// ASRStreamEvent is one message received on an ASRStream.
+type ASRStreamEvent struct {
+	Transcript Transcript
+	// Raw is the upstream result as JSON, when the provider has one.
+	Raw json.RawMessage
+	// Control is a text message the upstream sent instead of a result.
+	Control string
+	// Frame is the decoded Qiniu frame, for debugging; nil for other providers.
+	Frame *ASRFrame
+	// Err is set when the message could not be decoded, with Payload holding it as
+	// received; the stream stays usable.
+	Err     error
+	Payload []byte
+}
+
+// asrStreamConn is the provider side of an ASRStream.
+type asrStreamConn interface {
+	SendAudio(chunk []byte) error
+	SendStop() error
+	Recv() (*ASRStreamEvent, error)
+	Close() error
+}
+
+// ASRStream is an open streaming recognition session. Audio is sent with
+// SendAudioChunk, SendStop asks for the final result, and Recv returns results as they
+// arrive until the upstream ends the session, which it does after the final result of a
+// stop.
+type ASRStream struct {
+	conn asrStreamConn
+
+	releaseOnce sync.Once
+	release     func()
+}
+
+// SendAudioChunk forwards a chunk of PCM audio.
+func (s *ASRStream) SendAudioChunk(chunk []byte) error {
+	return s.conn.SendAudio(chunk)
+}
+
+// SendStop marks the end of the utterance.
+func (s *ASRStream) SendStop() error {
+	return s.conn.SendStop()
+}
+
+// Recv blocks until the next message. Errors end the stream.
+func (s *ASRStream) Recv() (*ASRStreamEvent, error) {
+	return s.conn.Recv()
+}
+
+// Close closes the ASR stream and its underlying connection, freeing its concurrency slot.
+func (s *ASRStream) Close() error {
+	err := s.conn.Close()
+	s.releaseOnce.Do(func() {
+		if s.release != nil {
+			s.release()
+		}
+	})
+	return err
+}
+
+// acquireStreamSlot takes a slot of the semaphore slots, failing fast with
+// ErrTooManyStreams rather than queueing: callers surface capacity errors to clients
+// immediately.
+func acquireStreamSlot(slots chan struct{}) (func(), error) {
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	default:
+		return nil, ErrTooManyStreams
+	}
+}
+
+// qiniuASRStream speaks Qiniu's binary ASR WebSocket protocol.
+type qiniuASRStream struct {
+	conn   *websocket.Conn
+	writer *ASRWSWriter
+}
+
+func (q *qiniuASRStream) SendAudio(chunk []byte) error { return q.writer.SendAudioChunk(chunk) }
+
+func (q *qiniuASRStream) SendStop() error { return q.writer.SendStop() }
+
+func (q *qiniuASRStream) Close() error { return q.conn.Close() }
+
+func (q *qiniuASRStream) Recv() (*ASRStreamEvent, error) {
+	for {
+		msgType, payload, err := q.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch msgType {
+		case websocket.BinaryMessage:
+			frame, err := ParseASRWSMessage(payload)
+			if err != nil {
+				return &ASRStreamEvent{Frame: frame, Err: err, Payload: payload}, nil
+			}
+			return &ASRStreamEvent{Transcript: ExtractTranscript(frame.Envelope), Raw: frame.JSON(), Frame: frame}, nil
+		case websocket.TextMessage:
+			if msg := strings.TrimSpace(string(payload)); msg != "" {
+				return &ASRStreamEvent{Control: msg}, nil
+			}
+		}
+	}
+}
+
+// errASRStreamStopped is returned when audio is sent after SendStop.
+var errASRStreamStopped = errors.New("asr stream already stopped")
@@ -5,23 +5,78 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
 	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
 	"github.com/wuwenbin0122/wwb.ai/db/models"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-// ASRInput captures the audio payload forwarded to Qiniu's ASR REST API.
+// ASRInput captures the audio payload forwarded to Qiniu's ASR API.
+// URL inputs go through the REST endpoint; inline Data (pcm or wav) is streamed over the WebSocket.
 type ASRInput struct {
-	Format string
-	URL    string
-	Data   []byte // ignored in REST-only implementation
+	Format     string
+	URL        string
+	Data       []byte
+	SampleRate int // raw pcm only; wav headers take precedence
+	Channels   int
+	Bits       int
+	Language   string   // optional recognition language hint, e.g. "zh" or "en"
+	Hotwords   []string // optional phrases to boost, normalized by NormalizeHotwords
+}
+
+// ASRStreamOptions configures a streaming ASR session.
+type ASRStreamOptions struct {
+	SampleRate int
+	Channels   int
+	Bits       int
+	Language   string
+	Hotwords   []string
+}
+
+// maxASRHotwords caps the hotword list forwarded upstream; longer lists dilute the boost.
+const maxASRHotwords = 50
+
+// NormalizeHotwords trims, de-duplicates (case-insensitively) and caps a hotword list.
+func NormalizeHotwords(words []string) []string {
+	seen := make(map[string]struct{}, len(words))
+	result := make([]string, 0, len(words))
+	for _, word := range words {
+		trimmed := strings.TrimSpace(word)
+		if trimmed == "" {
+			continue
+		}
+		key := strings.ToLower(trimmed)
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, trimmed)
+		if len(result) == maxASRHotwords {
+			break
+		}
+	}
+	return result
+}
+
+// RoleHotwords derives recognition hotwords from a role's name and skill names.
+func RoleHotwords(role models.Role) []string {
+	words := []string{role.Name}
+	for _, skill := range decodeRoleSkills(role.Skills) {
+		words = append(words, skill.Name)
+	}
+	return NormalizeHotwords(words)
 }
 
 // ASRResult represents the simplified transcription result returned by the ASR service.
@@ -29,32 +84,56 @@ type ASRResult struct {
 	ReqID      string          `json:"reqid"`
 	Text       string          `json:"text"`
 	DurationMS int             `json:"duration_ms"`
+	Confidence float64         `json:"confidence,omitempty"`
+	Words      []ASRWord       `json:"words,omitempty"`
 	Raw        json.RawMessage `json:"raw"`
 }
 
+// asrService is the Qiniu ASRProvider: REST for audio URLs, the binary WebSocket
+// protocol for inline audio and streams.
 type asrService struct {
 	baseURL string
 	model   string
 	client  httpDoer
+	breaker *CircuitBreaker
 	logger  *zap.SugaredLogger
+	// streamSlots is the ASRService semaphore; inline recognition takes a slot too.
+	streamSlots chan struct{}
 }
 
-// ASRStream represents an active WebSocket connection to Qiniu's ASR service.
-type ASRStream struct {
-	Conn   *websocket.Conn
-	Writer *ASRWSWriter
-}
+// ErrTooManyStreams is returned by OpenStream when the concurrent upstream stream limit is reached.
+var ErrTooManyStreams = apierr.New(apierr.CodeCapacity, "too many concurrent asr streams")
 
-// Close closes the ASR stream and its underlying connection.
-func (s *ASRStream) Close() error {
-	return s.Conn.Close()
-}
+// defaultMaxASRStreams applies when ASR_MAX_STREAMS is unset.
+const defaultMaxASRStreams = 100
 
-// ASRService exposes a REST-based transcription workflow.
-type ASRService struct{ inner *asrService }
+// ASRService transcribes speech with the provider selected by ASR_PROVIDER and bounds
+// the number of concurrent streams.
+type ASRService struct {
+	provider ASRProvider
+	// streamSlots is a semaphore bounding concurrent upstream streams.
+	streamSlots chan struct{}
+}
 
-// NewASRService constructs an ASR service configured for Qiniu's streaming API.
+// NewASRService constructs an ASR service for the configured provider, Qiniu's by default.
 func NewASRService(cfg *config.Config, logger *zap.SugaredLogger) *ASRService {
+	maxStreams := cfg.ASRMaxStreams
+	if maxStreams <= 0 {
+		maxStreams = defaultMaxASRStreams
+	}
+	slots := make(chan struct{}, maxStreams)
+
+	var provider ASRProvider
+	switch strings.ToLower(strings.TrimSpace(cfg.ASRProvider)) {
+	case ASRProviderOpenAI:
+		provider = NewOpenAIASRProvider(cfg, logger)
+	default:
+		provider = newQiniuASRProvider(cfg, slots, logger)
+	}
+	return &ASRService{provider: provider, streamSlots: slots}
+}
+
+func newQiniuASRProvider(cfg *config.Config, slots chan struct{}, logger *zap.SugaredLogger) *asrService {
 	base := strings.TrimRight(cfg.QiniuAPIBaseURL, "/")
 	if base == "" {
 		base = "https://openai.qiniu.com/v1"
@@ -63,36 +142,225 @@ func NewASRService(cfg *config.Config, logger *zap.SugaredLogger) *ASRService {
 	if model == "" {
 		model = "asr"
 	}
-	return &ASRService{inner: &asrService{baseURL: base, model: model, client: newDefaultHTTPClient(), logger: logger}}
+	breaker := newQiniuBreaker(cfg, BreakerASR)
+	return &asrService{
+		baseURL:     base,
+		model:       model,
+		client:      breakerDoer{next: newDefaultHTTPClient(), breaker: breaker},
+		breaker:     breaker,
+		logger:      logger,
+		streamSlots: slots,
+	}
+}
+
+// Provider returns the name of the ASR provider in use.
+func (s *ASRService) Provider() string {
+	return s.provider.Name()
+}
+
+// Breaker returns the circuit breaker guarding the ASR provider.
+func (s *ASRService) Breaker() *CircuitBreaker {
+	if guarded, ok := s.provider.(interface{ Breaker() *CircuitBreaker }); ok {
+		return guarded.Breaker()
+	}
+	return nil
+}
+
+// ActiveStreams reports how many upstream streams are currently open.
+func (s *ASRService) ActiveStreams() int {
+	return len(s.streamSlots)
 }
 
-// Recognize submits the provided audio (by URL) and returns the transcription text.
+// Recognize submits the provided audio (by URL or inline data) and returns the transcription text.
 func (s *ASRService) Recognize(ctx context.Context, token string, input ASRInput) (*ASRResult, error) {
-	return s.inner.recognizeREST(ctx, token, input)
+	return s.provider.Recognize(ctx, token, input)
+}
+
+// OpenStream starts a streaming session, or fails with ErrTooManyStreams when
+// ASR_MAX_STREAMS are open.
+func (s *ASRService) OpenStream(ctx context.Context, token string, opts ASRStreamOptions) (*ASRStream, error) {
+	release, err := acquireStreamSlot(s.streamSlots)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.provider.OpenStream(ctx, token, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	stream.release = release
+	return stream, nil
+}
+
+// Name implements ASRProvider.
+func (s *asrService) Name() string {
+	return ASRProviderQiniu
+}
+
+// Breaker returns the circuit breaker guarding the ASR API and its WebSocket.
+func (s *asrService) Breaker() *CircuitBreaker {
+	return s.breaker
+}
+
+// Recognize implements ASRProvider.
+func (s *asrService) Recognize(ctx context.Context, token string, input ASRInput) (*ASRResult, error) {
+	if strings.TrimSpace(input.URL) == "" && len(input.Data) > 0 {
+		return s.recognizeData(ctx, token, input)
+	}
+	return s.recognizeREST(ctx, token, input)
 }
 
 // OpenStream establishes a WebSocket connection to Qiniu's ASR service.
-func (s *ASRService) OpenStream(ctx context.Context, token string, sampleRate, channels, bits int) (*ASRStream, error) {
+func (s *asrService) OpenStream(ctx context.Context, token string, opts ASRStreamOptions) (*ASRStream, error) {
 	token = strings.TrimSpace(token)
 	if token == "" {
 		return nil, fmt.Errorf("authorization token is required")
 	}
 
-	wsURL := DeriveWebsocketURL(s.inner.baseURL) + "/voice/asr"
-	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, http.Header{
-		"Authorization": {"Bearer " + token},
-	})
+	wsURL := DeriveWebsocketURL(s.baseURL) + "/voice/asr"
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	setRequestID(ctx, header)
+	conn, err := dialWebsocket(ctx, s.breaker, wsURL, header)
 	if err != nil {
 		return nil, fmt.Errorf("connect to asr websocket: %w", err)
 	}
 
-	writer := NewASRWSWriter(conn, s.inner.logger, sampleRate, channels, bits)
-	if err := writer.SendConfig(s.inner.model); err != nil {
+	writer := NewASRWSWriter(conn, s.logger, opts.SampleRate, opts.Channels, opts.Bits)
+	if err := writer.SendConfig(s.model, strings.TrimSpace(opts.Language), NormalizeHotwords(opts.Hotwords)); err != nil {
 		_ = conn.Close()
 		return nil, fmt.Errorf("send asr config: %w", err)
 	}
 
-	return &ASRStream{Conn: conn, Writer: writer}, nil
+	return &ASRStream{conn: &qiniuASRStream{conn: conn, writer: writer}}, nil
+}
+
+// recognizeData streams inline PCM audio through the WebSocket API and waits for the transcript.
+// Qiniu streams the cumulative transcript, so the last non-empty text wins.
+func (s *asrService) recognizeData(ctx context.Context, token string, input ASRInput) (*ASRResult, error) {
+	pcm := input.Data
+	sampleRate, channels, bits := input.SampleRate, input.Channels, input.Bits
+
+	switch format := strings.ToLower(strings.TrimSpace(input.Format)); format {
+	case "", "pcm", "raw":
+	case "wav":
+		info, data, err := parseWAV(input.Data)
+		if err != nil {
+			return nil, fmt.Errorf("decode wav audio: %w", err)
+		}
+		pcm = data
+		sampleRate, channels, bits = info.SampleRate, info.Channels, info.Bits
+	default:
+		return nil, fmt.Errorf("inline audio must be pcm or wav, got %q (use audio_url instead)", format)
+	}
+	if sampleRate <= 0 {
+		sampleRate = 16000
+	}
+	if channels <= 0 {
+		channels = 1
+	}
+	if bits <= 0 {
+		bits = 16
+	}
+
+	release, err := acquireStreamSlot(s.streamSlots)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := s.OpenStream(ctx, token, ASRStreamOptions{
+		SampleRate: sampleRate,
+		Channels:   channels,
+		Bits:       bits,
+		Language:   input.Language,
+		Hotwords:   input.Hotwords,
+	})
+	if err != nil {
+		release()
+		return nil, err
+	}
+	stream.release = release
+	defer stream.Close()
+
+	// unblock the reader below once the caller's deadline expires
+	stopAfter := context.AfterFunc(ctx, func() { _ = stream.Close() })
+	defer stopAfter()
+
+	var stopSent atomic.Bool
+	type outcome struct {
+		result *ASRResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+
+	go func() {
+		result := &ASRResult{}
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				if result.Text != "" {
+					done <- outcome{result: result}
+					return
+				}
+				done <- outcome{err: fmt.Errorf("read asr stream: %w", err)}
+				return
+			}
+			if event.Err != nil {
+				ctxlog.From(ctx, s.logger).Warnf("parse asr stream payload: %v", event.Err)
+				DebugHexDump(s.logger, "unparseable asr frame", event.Payload)
+				continue
+			}
+			if event.Control != "" {
+				continue
+			}
+			transcript := event.Transcript
+			if transcript.Text != "" {
+				result.Text = transcript.Text
+				result.Confidence = transcript.Confidence
+				result.Words = transcript.Words
+			}
+			if transcript.DurationMS > 0 {
+				result.DurationMS = transcript.DurationMS
+			}
+			if len(event.Raw) > 0 {
+				result.Raw = event.Raw
+			}
+			if transcript.IsFinal && stopSent.Load() && result.Text != "" {
+				done <- outcome{result: result}
+				return
+			}
+		}
+	}()
+
+	// send ~100ms chunks, mirroring what browsers push through the proxy
+	chunkSize := sampleRate * channels * bits / 8 / 10
+	if chunkSize <= 0 {
+		chunkSize = 3200
+	}
+	for start := 0; start < len(pcm); start += chunkSize {
+		end := start + chunkSize
+		if end > len(pcm) {
+			end = len(pcm)
+		}
+		if err := stream.SendAudioChunk(pcm[start:end]); err != nil {
+			return nil, fmt.Errorf("send asr audio: %w", err)
+		}
+	}
+	if err := stream.SendStop(); err != nil {
+		return nil, fmt.Errorf("send asr stop: %w", err)
+	}
+	stopSent.Store(true)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case out := <-done:
+		if out.err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, out.err
+		}
+		return out.result, nil
+	}
 }
 
 func (s *asrService) recognizeREST(ctx context.Context, token string, input ASRInput) (*ASRResult, error) {
@@ -115,6 +383,9 @@ func (s *asrService) recognizeREST(ctx context.Context, token string, input ASRI
 		"model": s.model,
 		"audio": map[string]interface{}{"format": format, "url": url},
 	}
+	if hints := recognitionHints(input.Language, NormalizeHotwords(input.Hotwords)); len(hints) > 0 {
+		payload["request"] = hints
+	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -128,6 +399,7 @@ func (s *asrService) recognizeREST(ctx context.Context, token string, input ASRI
 	}
 	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req.Header)
 
 	resp, err := s.client.Do(req)
 	if err != nil {
@@ -152,7 +424,19 @@ func (s *asrService) recognizeREST(ctx context.Context, token string, input ASRI
 	}
 
 	text := strings.TrimSpace(envelope.Data.Result.Text)
-	return &ASRResult{ReqID: envelope.ReqID, Text: text, DurationMS: envelope.Data.AudioInfo.Duration, Raw: json.RawMessage(respBody)}, nil
+	result := &ASRResult{ReqID: envelope.ReqID, Text: text, DurationMS: envelope.Data.AudioInfo.Duration, Raw: json.RawMessage(respBody)}
+
+	// confidence and word timings are optional; decode them from the generic result object
+	var generic struct {
+		Data struct {
+			Result map[string]interface{} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &generic); err == nil && generic.Data.Result != nil {
+		result.Confidence, result.Words = parseResultDetails(generic.Data.Result)
+	}
+
+	return result, nil
 }
 
 // response envelopes (mirror previous implementation)
@@ -235,7 +519,26 @@ func NewASRWSWriter(conn *websocket.Conn, logger *zap.SugaredLogger, sampleRate,
 	return &ASRWSWriter{conn: conn, logger: logger, seq: 1, sampleRate: sampleRate, channels: channels, bits: bits}
 }
 
-func (w *ASRWSWriter) SendConfig(model string) error {
+// recognitionHints builds the optional language/hotword fields of the Qiniu request object.
+func recognitionHints(language string, hotwords []string) map[string]interface{} {
+	hints := make(map[string]interface{}, 2)
+	if language = strings.TrimSpace(language); language != "" {
+		hints["language"] = language
+	}
+	if len(hotwords) > 0 {
+		hints["hotwords"] = hotwords
+	}
+	return hints
+}
+
+func (w *ASRWSWriter) SendConfig(model, language string, hotwords []string) error {
+	request := map[string]interface{}{
+		"model_name":  model,
+		"enable_punc": true,
+	}
+	for key, value := range recognitionHints(language, hotwords) {
+		request[key] = value
+	}
 	req := map[string]interface{}{
 		"user": map[string]interface{}{"uid": "local"},
 		"audio": map[string]interface{}{
@@ -245,10 +548,7 @@ func (w *ASRWSWriter) SendConfig(model string) error {
 			"channel":     w.channels,
 			"codec":       "raw",
 		},
-		"request": map[string]interface{}{
-			"model_name":  model,
-			"enable_punc": true,
-		},
+		"request": request,
 	}
 	payload, err := json.Marshal(req)
 	if err != nil {
@@ -296,10 +596,38 @@ func (w *ASRWSWriter) sendFrame(messageType byte, payload []byte, compress bool)
 	return w.conn.WriteMessage(websocket.BinaryMessage, frame)
 }
 
-// ParseASRWSMessage parses a Qiniu ASR WS binary response into a generic envelope and raw JSON payload if present.
-func ParseASRWSMessage(data []byte) (map[string]interface{}, []byte, error) {
+// ASRFrame is one decoded binary frame from Qiniu's ASR WebSocket.
+type ASRFrame struct {
+	MessageType   byte
+	Flags         byte
+	Serialization byte
+	Compression   byte
+	Sequence      int32
+	HasSequence   bool
+	// Payload is the frame body after sequence/size stripping and decompression.
+	Payload []byte
+	// Envelope is the decoded JSON payload; nil when the payload is not (valid) JSON.
+	Envelope map[string]interface{}
+	// Diagnostics explains anything unexpected met while decoding.
+	Diagnostics []string
+	// Raw is a copy of the complete frame as received.
+	Raw []byte
+}
+
+// JSON returns the payload when it decoded as JSON, or nil.
+func (f *ASRFrame) JSON() json.RawMessage {
+	if f == nil || f.Envelope == nil || f.Serialization != 0x01 {
+		return nil
+	}
+	return json.RawMessage(f.Payload)
+}
+
+// ParseASRWSMessage decodes a Qiniu ASR WS binary response. The returned frame is non-nil
+// whenever the header could be read, even if err reports that the payload could not be
+// decompressed or decoded, so callers can still inspect or forward it.
+func ParseASRWSMessage(data []byte) (*ASRFrame, error) {
 	if len(data) < 4 {
-		return nil, nil, fmt.Errorf("binary message too short")
+		return nil, fmt.Errorf("binary message too short (%d bytes)", len(data))
 	}
 	headerSize := int(data[0] & 0x0F)
 	if headerSize <= 0 {
@@ -307,65 +635,110 @@ func ParseASRWSMessage(data []byte) (map[string]interface{}, []byte, error) {
 	}
 	baseOffset := headerSize * 4
 	if len(data) < baseOffset {
-		return nil, nil, fmt.Errorf("invalid header size")
+		return nil, fmt.Errorf("invalid header size %d for %d byte frame", headerSize, len(data))
+	}
+
+	frame := &ASRFrame{
+		MessageType:   data[1] >> 4,
+		Flags:         data[1] & 0x0F,
+		Serialization: data[2] >> 4,
+		Compression:   data[2] & 0x0F,
+		Raw:           append([]byte(nil), data...),
+	}
+	fail := func(format string, args ...interface{}) (*ASRFrame, error) {
+		err := fmt.Errorf(format, args...)
+		frame.Diagnostics = append(frame.Diagnostics, err.Error())
+		return frame, err
 	}
-	flags := data[1] & 0x0F
-	messageType := data[1] >> 4
-	serialization := data[2] >> 4
-	compression := data[2] & 0x0F
 
 	payload := data[baseOffset:]
-	if flags&0x01 == 0x01 {
+	if frame.Flags&0x01 == 0x01 {
 		if len(payload) < 4 {
-			return nil, nil, fmt.Errorf("payload missing sequence")
+			return fail("payload missing sequence")
 		}
+		frame.Sequence = int32(binary.BigEndian.Uint32(payload[:4]))
+		frame.HasSequence = true
 		payload = payload[4:]
 	}
-	if messageType == 0x09 && len(payload) >= 4 {
+	if frame.MessageType == 0x09 && len(payload) >= 4 {
 		size := int(binary.BigEndian.Uint32(payload[:4]))
-		if size <= len(payload)-4 {
-			payload = payload[4 : 4+size]
-		} else {
-			return nil, nil, fmt.Errorf("payload size mismatch")
+		if size > len(payload)-4 {
+			return fail("payload size mismatch: header says %d, frame has %d", size, len(payload)-4)
 		}
+		payload = payload[4 : 4+size]
 	}
-	if compression == 0x01 {
+	if frame.Compression == 0x01 {
 		zr, err := gzip.NewReader(bytes.NewReader(payload))
 		if err != nil {
-			return nil, nil, err
+			return fail("open gzip payload: %w", err)
 		}
 		var buf bytes.Buffer
 		if _, err := io.Copy(&buf, zr); err != nil {
-			return nil, nil, err
+			return fail("decompress payload: %w", err)
 		}
 		_ = zr.Close()
 		payload = buf.Bytes()
 	}
-	if serialization == 0x01 {
-		// 检查payload是否可能是有效的JSON（以'{'或'['开头）
-		if len(payload) > 0 && (payload[0] == '{' || payload[0] == '[') {
-			var envelope map[string]interface{}
-			if err := json.Unmarshal(payload, &envelope); err != nil {
-				// JSON解析失败，返回错误但不中断程序
-				return nil, append([]byte(nil), payload...), fmt.Errorf("failed to parse JSON: %w", err)
-			}
-			return envelope, append([]byte(nil), payload...), nil
-		}
-		// 不是有效的JSON格式，返回一个包含原始数据的通用envelope
-		envelope := map[string]interface{}{
-			"text": string(payload),
-			"raw_binary": true,
-		}
-		return envelope, append([]byte(nil), payload...), nil
+	frame.Payload = append([]byte(nil), payload...)
+
+	if frame.Serialization != 0x01 {
+		frame.Envelope = map[string]interface{}{"text": string(payload)}
+		return frame, nil
+	}
+	if len(payload) == 0 || (payload[0] != '{' && payload[0] != '[') {
+		// declared JSON but plainly is not: keep the text so transcripts are not lost
+		frame.Diagnostics = append(frame.Diagnostics, "payload declared JSON but is not")
+		frame.Envelope = map[string]interface{}{"text": string(payload), "raw_binary": true}
+		return frame, nil
+	}
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return fail("decode JSON payload: %w", err)
+	}
+	frame.Envelope = envelope
+	return frame, nil
+}
+
+// maxHexDumpBytes bounds DebugHexDump output for large audio-bearing frames.
+const maxHexDumpBytes = 512
+
+// DebugHexDump logs a hex dump of data at debug level. The dump is only built when the
+// logger has debug enabled, so it is free in production.
+func DebugHexDump(logger *zap.SugaredLogger, label string, data []byte) {
+	if logger == nil || !logger.Desugar().Core().Enabled(zapcore.DebugLevel) {
+		return
+	}
+	dump := data
+	suffix := ""
+	if len(dump) > maxHexDumpBytes {
+		dump = dump[:maxHexDumpBytes]
+		suffix = fmt.Sprintf("... (%d more bytes)", len(data)-maxHexDumpBytes)
 	}
-	envelope := map[string]interface{}{"text": string(payload)}
-	return envelope, append([]byte(nil), payload...), nil
+	logger.Debugf("%s (%d bytes):\n%s%s", label, len(data), hex.Dump(dump), suffix)
 }
 
-// ExtractTranscript attempts to derive a text transcript and completion flag from a Qiniu ASR envelope.
-func ExtractTranscript(envelope map[string]interface{}) (text string, isFinal bool, durationMS int) {
+// ASRWord is a recognized word (or CJK character) with its timing inside the audio.
+type ASRWord struct {
+	Text    string `json:"text"`
+	StartMS int    `json:"start_ms"`
+	EndMS   int    `json:"end_ms"`
+}
+
+// Transcript is the normalized view of a single ASR result payload.
+type Transcript struct {
+	Text       string
+	IsFinal    bool
+	DurationMS int
+	Confidence float64
+	Words      []ASRWord
+}
+
+// ExtractTranscript attempts to derive a text transcript, completion flag and, when the
+// upstream provides them, confidence and word timings from a Qiniu ASR envelope.
+func ExtractTranscript(envelope map[string]interface{}) Transcript {
+	var t Transcript
 	if envelope == nil {
-		return "", false, 0
+		return t
 	}
 
 	var result map[string]interface{}
@@ -385,38 +758,131 @@ func ExtractTranscript(envelope map[string]interface{}) (text string, isFinal bo
 
 	if result != nil {
 		if v, ok := result["text"].(string); ok {
-			text = strings.TrimSpace(v)
+			t.Text = strings.TrimSpace(v)
 		} else if v, ok := result["best_text"].(string); ok {
-			text = strings.TrimSpace(v)
+			t.Text = strings.TrimSpace(v)
 		}
 		if v, ok := result["is_final"].(bool); ok {
-			isFinal = v
+			t.IsFinal = v
 		} else if v, ok := result["final"].(bool); ok {
-			isFinal = v
+			t.IsFinal = v
 		} else if v, ok := result["type"].(string); ok {
 			if strings.EqualFold(v, "final") || strings.EqualFold(v, "end") {
-				isFinal = true
+				t.IsFinal = true
 			}
 		}
 		if v, ok := result["duration"].(float64); ok {
-			durationMS = int(v)
+			t.DurationMS = int(v)
 		} else if v, ok := result["duration_ms"].(float64); ok {
-			durationMS = int(v)
+			t.DurationMS = int(v)
 		} else if v, ok := result["segment_time"].(float64); ok {
-			durationMS = int(v * 1000)
+			t.DurationMS = int(v * 1000)
 		}
+		t.Confidence, t.Words = parseResultDetails(result)
 	}
 
-	if text == "" {
+	if t.Text == "" {
 		if v, ok := envelope["text"].(string); ok {
-			text = strings.TrimSpace(v)
+			t.Text = strings.TrimSpace(v)
 		}
 	}
-	if !isFinal {
+	if !t.IsFinal {
 		if v, ok := envelope["is_final"].(bool); ok {
-			isFinal = v
+			t.IsFinal = v
+		}
+	}
+
+	return t
+}
+
+// parseResultDetails extracts the optional confidence score and word timings from a result object.
+// Words may sit directly on the result or inside result.utterances[]; confidence may be on the
+// result, in result.additions, or only per utterance (then averaged). Missing data yields zero values.
+func parseResultDetails(result map[string]interface{}) (float64, []ASRWord) {
+	confidence, hasConfidence := numberField(result, "confidence")
+	if !hasConfidence {
+		if additions, ok := result["additions"].(map[string]interface{}); ok {
+			confidence, hasConfidence = numberField(additions, "confidence")
+		}
+	}
+
+	words := parseWords(result["words"])
+	if utterances, ok := result["utterances"].([]interface{}); ok {
+		var (
+			sum   float64
+			count int
+		)
+		for _, item := range utterances {
+			utterance, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			words = append(words, parseWords(utterance["words"])...)
+			if v, ok := numberField(utterance, "confidence"); ok {
+				sum += v
+				count++
+			}
+		}
+		if !hasConfidence && count > 0 {
+			confidence = sum / float64(count)
+		}
+	}
+
+	if len(words) == 0 {
+		words = nil
+	}
+	return confidence, words
+}
+
+func parseWords(raw interface{}) []ASRWord {
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	words := make([]ASRWord, 0, len(items))
+	for _, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		text, _ := entry["text"].(string)
+		if text == "" {
+			text, _ = entry["word"].(string)
 		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		word := ASRWord{Text: text}
+		for _, key := range []string{"start_time", "start_ms", "start"} {
+			if v, ok := numberField(entry, key); ok {
+				word.StartMS = int(v)
+				break
+			}
+		}
+		for _, key := range []string{"end_time", "end_ms", "end"} {
+			if v, ok := numberField(entry, key); ok {
+				word.EndMS = int(v)
+				break
+			}
+		}
+		words = append(words, word)
 	}
+	return words
+}
 
-	return text, isFinal, durationMS
+// numberField reads a numeric field that upstream sometimes encodes as a string.
+func numberField(m map[string]interface{}, key string) (float64, bool) {
+	switch v := m[key].(type) {
+	case float64:
+		return v, true
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, false
+		}
+		return parsed, true
+	}
+	return 0, false
 }
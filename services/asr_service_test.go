@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type stubStreamConn struct {
+	closes *atomic.Int32
+}
+
+func (c stubStreamConn) SendAudio([]byte) error         { return nil }
+func (c stubStreamConn) SendStop() error                { return nil }
+func (c stubStreamConn) Recv() (*ASRStreamEvent, error) { return nil, errors.New("no results") }
+func (c stubStreamConn) Close() error                   { c.closes.Add(1); return nil }
+
+type stubASRProvider struct {
+	closes  atomic.Int32
+	openErr error
+}
+
+func (p *stubASRProvider) Name() string { return "stub" }
+
+func (p *stubASRProvider) Recognize(context.Context, string, ASRInput) (*ASRResult, error) {
+	return &ASRResult{}, nil
+}
+
+func (p *stubASRProvider) OpenStream(context.Context, string, ASRStreamOptions) (*ASRStream, error) {
+	if p.openErr != nil {
+		return nil, p.openErr
+	}
+	return &ASRStream{conn: stubStreamConn{closes: &p.closes}}, nil
+}
+
+func TestASRServiceCapsConcurrentStreams(t *testing.T) {
+	const limit, callers = 3, 32
+	provider := &stubASRProvider{}
+	svc := &ASRService{provider: provider, streamSlots: make(chan struct{}, limit)}
+
+	var (
+		mu       sync.Mutex
+		opened   []*ASRStream
+		rejected int
+		wg       sync.WaitGroup
+	)
+	start := make(chan struct{})
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			stream, err := svc.OpenStream(context.Background(), "token", ASRStreamOptions{})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				opened = append(opened, stream)
+			case errors.Is(err, ErrTooManyStreams):
+				rejected++
+			default:
+				t.Errorf("OpenStream: %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if len(opened) != limit || rejected != callers-limit {
+		t.Fatalf("opened %d and rejected %d streams, want %d and %d", len(opened), rejected, limit, callers-limit)
+	}
+	if got := svc.ActiveStreams(); got != limit {
+		t.Fatalf("ActiveStreams() = %d, want %d", got, limit)
+	}
+
+	// closing twice must free the slot only once
+	for _, stream := range opened {
+		_ = stream.Close()
+		_ = stream.Close()
+	}
+	if got := svc.ActiveStreams(); got != 0 {
+		t.Fatalf("ActiveStreams() = %d after closing every stream, want 0", got)
+	}
+	if got := provider.closes.Load(); got != 2*limit {
+		t.Errorf("provider connections closed %d times, want %d", got, 2*limit)
+	}
+	stream, err := svc.OpenStream(context.Background(), "token", ASRStreamOptions{})
+	if err != nil {
+		t.Fatalf("OpenStream after release: %v", err)
+	}
+	_ = stream.Close()
+}
+
+func TestASRServiceReleasesSlotWhenOpenFails(t *testing.T) {
+	provider := &stubASRProvider{openErr: errors.New("upstream down")}
+	svc := &ASRService{provider: provider, streamSlots: make(chan struct{}, 1)}
+
+	for range 3 {
+		if _, err := svc.OpenStream(context.Background(), "token", ASRStreamOptions{}); err == nil || errors.Is(err, ErrTooManyStreams) {
+			t.Fatalf("OpenStream error = %v, want the provider error", err)
+		}
+	}
+	if got := svc.ActiveStreams(); got != 0 {
+		t.Fatalf("ActiveStreams() = %d after failed opens, want 0", got)
+	}
+}
@@ -0,0 +1,80 @@
+//go:build capture
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gen2brain/malgo"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"go.uber.org/zap"
+)
+
+// RunCaptureASR runs the microphone ASR loop on a miniaudio (malgo) capture device.
+// It honours the same RaylibASRConfig knobs as RunRaylibASR and needs no raylib toolchain.
+func RunCaptureASR(ctx context.Context, cfg *config.Config, nlp *NLPService, role models.Role, lang string, rc RaylibASRConfig, logger *zap.SugaredLogger) error {
+	loop := newMicLoop(cfg, nlp, role, lang, rc, os.Stdout, logger)
+
+	mctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return fmt.Errorf("init audio context: %w", err)
+	}
+	defer func() {
+		_ = mctx.Uninit()
+		mctx.Free()
+	}()
+
+	deviceConfig := malgo.DefaultDeviceConfig(malgo.Capture)
+	deviceConfig.Capture.Format = malgo.FormatS16
+	deviceConfig.Capture.Channels = uint32(loop.rc.Channels)
+	deviceConfig.SampleRate = uint32(loop.rc.SampleRate)
+
+	if hint := strings.ToLower(strings.TrimSpace(rc.DeviceHint)); hint != "" {
+		devices, err := mctx.Devices(malgo.Capture)
+		if err != nil {
+			return fmt.Errorf("list capture devices: %w", err)
+		}
+		found := false
+		for i := range devices {
+			if strings.Contains(strings.ToLower(devices[i].Name()), hint) {
+				deviceConfig.Capture.DeviceID = devices[i].ID.Pointer()
+				logger.Infof("using capture device %q", devices[i].Name())
+				found = true
+				break
+			}
+		}
+		if !found {
+			logger.Warnf("no capture device matches %q, using the default device", rc.DeviceHint)
+		}
+	}
+
+	frames := make(chan []byte, 64)
+	callbacks := malgo.DeviceCallbacks{
+		Data: func(_, input []byte, _ uint32) {
+			chunk := append([]byte(nil), input...)
+			select {
+			case frames <- chunk:
+			default:
+				// the loop fell behind (e.g. waiting on a reply); drop audio rather than stall the device
+			}
+		},
+	}
+
+	device, err := malgo.InitDevice(mctx.Context, deviceConfig, callbacks)
+	if err != nil {
+		return fmt.Errorf("init capture device: %w", err)
+	}
+	defer device.Uninit()
+
+	if err := device.Start(); err != nil {
+		return fmt.Errorf("start capture device: %w", err)
+	}
+	defer func() { _ = device.Stop() }()
+
+	fmt.Fprintf(os.Stdout, "listening at %d Hz, %d channel(s); press Ctrl+C to quit\n", loop.rc.SampleRate, loop.rc.Channels)
+	return loop.run(ctx, frames)
+}
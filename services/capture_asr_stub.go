@@ -0,0 +1,18 @@
+//go:build !capture
+
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"go.uber.org/zap"
+)
+
+// RunCaptureASR is available only when built with -tags capture.
+// The default build provides a stub that returns an informative error.
+func RunCaptureASR(ctx context.Context, cfg *config.Config, nlp *NLPService, role models.Role, lang string, rc RaylibASRConfig, logger *zap.SugaredLogger) error {
+	return fmt.Errorf("RunCaptureASR requires build tag 'capture' (-tags capture)")
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/payloadlog"
+	"go.uber.org/zap"
+)
+
+// QiniuProvider is the name of the built-in chat provider configured by the QINIU settings.
+const QiniuProvider = "qiniu"
+
+// ChatCompletionRequest is a provider-neutral chat completion call.
+type ChatCompletionRequest struct {
+	Messages []NLPMessage
+	// Temperature, MaxTokens and TopP are left to the provider when nil, 0 and nil.
+	Temperature *float64
+	MaxTokens   int
+	TopP        *float64
+	// JSON asks for a JSON object reply; providers without JSON mode ignore it and rely
+	// on the prompt.
+	JSON bool
+}
+
+// ChatCompletion is the reply of a chat completion call.
+type ChatCompletion struct {
+	Message NLPMessage
+	// Usage is nil when the provider did not report token counts.
+	Usage        *NLPUsage
+	FinishReason string
+	// Raw is the response body of unstreamed calls.
+	Raw json.RawMessage
+}
+
+// ChatProvider is a chat completion backend.
+type ChatProvider interface {
+	Name() string
+	// Complete returns the whole reply at once. token is the caller's credential; providers
+	// configured with their own key ignore it.
+	Complete(ctx context.Context, token string, req ChatCompletionRequest) (*ChatCompletion, error)
+	// Stream passes each piece of the reply to onDelta as it is generated and returns the
+	// whole reply. An error from onDelta aborts the call and is returned.
+	Stream(ctx context.Context, token string, req ChatCompletionRequest, onDelta func(content string) error) (*ChatCompletion, error)
+}
+
+// OpenAIProvider is a ChatProvider for any OpenAI-compatible /chat/completions endpoint,
+// such as Qiniu, the official OpenAI API or a local vLLM server.
+type OpenAIProvider struct {
+	name         string
+	baseURL      string
+	model        string
+	apiKey       string
+	authHeader   string
+	authScheme   string
+	jsonMode     bool
+	client       httpDoer
+	streamClient httpDoer
+	breaker      *CircuitBreaker
+	logger       *zap.SugaredLogger
+	// payloads records calls for debugging; nil records nothing.
+	payloads *payloadlog.Recorder
+}
+
+// NewOpenAIProvider builds a provider from its configuration, guarded by breaker.
+func NewOpenAIProvider(cfg config.ChatProviderConfig, breaker *CircuitBreaker, logger *zap.SugaredLogger) *OpenAIProvider {
+	return &OpenAIProvider{
+		name:         cfg.Name,
+		baseURL:      strings.TrimRight(cfg.BaseURL, "/"),
+		model:        cfg.Model,
+		apiKey:       cfg.APIKey,
+		authHeader:   cfg.AuthHeader,
+		authScheme:   cfg.AuthScheme,
+		jsonMode:     cfg.JSONMode,
+		client:       breakerDoer{next: newDefaultHTTPClient(), breaker: breaker},
+		streamClient: breakerDoer{next: newStreamingHTTPClient(), breaker: breaker},
+		breaker:      breaker,
+		logger:       logger,
+	}
+}
+
+// Name returns the name requests select the provider by.
+func (p *OpenAIProvider) Name() string {
+	return p.name
+}
+
+// Breaker returns the circuit breaker guarding the provider.
+func (p *OpenAIProvider) Breaker() *CircuitBreaker {
+	return p.breaker
+}
+
+// UsesCallerToken reports whether calls authenticate with the caller's token, which is
+// then required, rather than a configured key or no credential at all.
+func (p *OpenAIProvider) UsesCallerToken() bool {
+	return p.apiKey == "" && p.authHeader != ""
+}
+
+// record passes a call to the payload recorder when it is active for ctx. response is the
+// body received, if any.
+func (p *OpenAIProvider) record(ctx context.Context, payload nlpAPIRequest, started time.Time, status int, response []byte, err error) {
+	if !p.payloads.Active(ctx) {
+		return
+	}
+	request, _ := json.Marshal(payload)
+	entry := payloadlog.Entry{
+		Provider:   p.name,
+		Endpoint:   p.baseURL + "/chat/completions",
+		Status:     status,
+		DurationMS: time.Since(started).Milliseconds(),
+		Request:    request,
+		Response:   response,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	p.payloads.Record(ctx, entry)
+}
+
+func (p *OpenAIProvider) payload(req ChatCompletionRequest) nlpAPIRequest {
+	payload := nlpAPIRequest{
+		Model:       p.model,
+		Messages:    req.Messages,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		TopP:        req.TopP,
+	}
+	if req.JSON && p.jsonMode {
+		payload.ResponseFormat = &nlpResponseFormat{Type: "json_object"}
+	}
+	return payload
+}
+
+func (p *OpenAIProvider) newRequest(ctx context.Context, token string, payload nlpAPIRequest) (*http.Request, error) {
+	credential := p.apiKey
+	if credential == "" {
+		credential = strings.TrimSpace(token)
+	}
+	if credential == "" && p.UsesCallerToken() {
+		return nil, fmt.Errorf("authorization token is required")
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal chat payload: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create chat request: %w", err)
+	}
+	if p.authHeader != "" && credential != "" {
+		value := credential
+		if p.authScheme != "" {
+			value = p.authScheme + " " + credential
+		}
+		request.Header.Set(p.authHeader, value)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, request.Header)
+	return request, nil
+}
+
+// Complete implements ChatProvider.
+func (p *OpenAIProvider) Complete(ctx context.Context, token string, req ChatCompletionRequest) (*ChatCompletion, error) {
+	payload := p.payload(req)
+	request, err := p.newRequest(ctx, token, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	response, err := p.client.Do(request)
+	if err != nil {
+		p.record(ctx, payload, started, 0, nil, err)
+		ctxlog.From(ctx, p.logger).Warnf("call %s chat api: %v", p.name, err)
+		return nil, fmt.Errorf("call chat api: %w", err)
+	}
+	defer response.Body.Close()
+
+	respBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		p.record(ctx, payload, started, response.StatusCode, nil, err)
+		return nil, fmt.Errorf("read chat response: %w", err)
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		apiErr := buildQiniuAPIError(response.StatusCode, respBody)
+		p.record(ctx, payload, started, response.StatusCode, respBody, apiErr)
+		ctxlog.From(ctx, p.logger).Warnf("%s chat api returned %d: %v", p.name, response.StatusCode, apiErr)
+		return nil, apiErr
+	}
+	p.record(ctx, payload, started, response.StatusCode, respBody, nil)
+
+	var apiResp nlpAPIResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		return nil, fmt.Errorf("decode chat response: %w", err)
+	}
+
+	if apiResp.Error != nil && apiResp.Error.Message != "" {
+		return nil, fmt.Errorf("%s chat error: %s", p.name, apiResp.Error.Message)
+	}
+
+	if len(apiResp.Choices) == 0 {
+		return nil, fmt.Errorf("chat response contained no choices")
+	}
+
+	choice := apiResp.Choices[0]
+	if strings.TrimSpace(choice.Message.Role) == "" {
+		choice.Message.Role = "assistant"
+	}
+	return &ChatCompletion{Message: choice.Message, Usage: apiResp.Usage, FinishReason: choice.FinishReason, Raw: respBody}, nil
+}
+
+// Stream implements ChatProvider with server-sent events. Cancelling ctx aborts the call
+// and returns ctx.Err(). The payload recorder sees the assembled reply rather than the
+// events.
+func (p *OpenAIProvider) Stream(ctx context.Context, token string, req ChatCompletionRequest, onDelta func(content string) error) (result *ChatCompletion, err error) {
+	payload := p.payload(req)
+	payload.Stream = true
+	payload.StreamOptions = &nlpStreamOptions{IncludeUsage: true}
+
+	// cancelling stops reading and closes the upstream connection
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	request, err := p.newRequest(ctx, token, payload)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "text/event-stream")
+
+	started := time.Now()
+	var (
+		status   int
+		received []byte
+	)
+	defer func() {
+		if result != nil {
+			received, _ = json.Marshal(map[string]interface{}{
+				"message":       result.Message,
+				"usage":         result.Usage,
+				"finish_reason": result.FinishReason,
+			})
+		}
+		p.record(ctx, payload, started, status, received, err)
+	}()
+
+	response, err := p.streamClient.Do(request)
+	if err != nil {
+		ctxlog.From(ctx, p.logger).Warnf("call %s chat api: %v", p.name, err)
+		return nil, fmt.Errorf("call chat api: %w", err)
+	}
+	defer response.Body.Close()
+	status = response.StatusCode
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+		received = respBody
+		apiErr := buildQiniuAPIError(response.StatusCode, respBody)
+		ctxlog.From(ctx, p.logger).Warnf("%s chat api returned %d: %v", p.name, response.StatusCode, apiErr)
+		return nil, apiErr
+	}
+
+	result = &ChatCompletion{Message: NLPMessage{Role: "assistant"}}
+	var reply strings.Builder
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			// blank separators, comments and event or id fields
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk nlpStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("decode chat stream: %w", err)
+		}
+		if chunk.Error != nil && chunk.Error.Message != "" {
+			return nil, fmt.Errorf("%s chat error: %s", p.name, chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			result.Usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				result.FinishReason = choice.FinishReason
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			reply.WriteString(choice.Delta.Content)
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("read chat stream: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Message.Content = reply.String()
+	return result, nil
+}
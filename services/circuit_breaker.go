@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/config"
+)
+
+// Qiniu endpoint classes, each behind its own circuit breaker.
+const (
+	BreakerChat = "chat"
+	BreakerASR  = "asr"
+	BreakerTTS  = "tts"
+	// BreakerLLMPrefix prefixes the breaker names of the chat providers besides Qiniu,
+	// which share the QINIU_BREAKER settings.
+	BreakerLLMPrefix = "llm_"
+)
+
+// ErrUpstreamUnavailable is returned without calling Qiniu while the circuit of an
+// endpoint class is open.
+var ErrUpstreamUnavailable = apierr.New(apierr.CodeUpstreamUnavailable, "qiniu is unavailable, retry later")
+
+// BreakerState is the state of a CircuitBreaker; its value is what the
+// qiniu_circuit_state gauge reports.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half_open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+type breakerOutcome int
+
+const (
+	breakerSuccess breakerOutcome = iota
+	breakerFailure
+	// breakerIgnored is a call that says nothing about the upstream, such as one the
+	// caller cancelled.
+	breakerIgnored
+)
+
+// CircuitBreaker fails calls to one Qiniu endpoint class fast once it keeps failing.
+// threshold consecutive failures open the circuit; after cooldown a single probe call is
+// let through (half-open) and closes the circuit again when it succeeds. A nil breaker, or
+// one with a non-positive threshold, lets every call through.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker builds a closed breaker for the endpoint class name.
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{name: name, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// newQiniuBreaker builds the breaker of an endpoint class from the QINIU_BREAKER settings.
+func newQiniuBreaker(cfg *config.Config, name string) *CircuitBreaker {
+	return NewCircuitBreaker(name, cfg.QiniuBreakerThreshold, time.Duration(cfg.QiniuBreakerCooldownSeconds)*time.Second)
+}
+
+// Name returns the endpoint class the breaker guards.
+func (b *CircuitBreaker) Name() string {
+	return b.name
+}
+
+// State returns the current state; an open circuit whose cool-down has passed reports
+// half-open, as the next call will probe.
+func (b *CircuitBreaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Ping fails while the circuit is not closed, for the readiness checks.
+func (b *CircuitBreaker) Ping(context.Context) error {
+	switch state := b.State(); state {
+	case BreakerClosed:
+		return nil
+	default:
+		return fmt.Errorf("circuit %s", state)
+	}
+}
+
+// allow reserves a call. It returns ErrUpstreamUnavailable when the circuit is open, or
+// half-open with its probe still running; otherwise the caller reports the outcome of the
+// call with done.
+func (b *CircuitBreaker) allow() (func(breakerOutcome), error) {
+	if b == nil || b.threshold <= 0 {
+		return func(breakerOutcome) {}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	probe := false
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return nil, fmt.Errorf("%s circuit open: %w", b.name, ErrUpstreamUnavailable)
+		}
+		b.state = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return nil, fmt.Errorf("%s circuit half-open: %w", b.name, ErrUpstreamUnavailable)
+		}
+		b.probing, probe = true, true
+	}
+
+	var once sync.Once
+	return func(outcome breakerOutcome) {
+		once.Do(func() { b.record(probe, outcome) })
+	}, nil
+}
+
+func (b *CircuitBreaker) record(probe bool, outcome breakerOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+
+	switch outcome {
+	case breakerSuccess:
+		b.state, b.failures = BreakerClosed, 0
+	case breakerFailure:
+		b.failures++
+		// a failed probe reopens at once; calls let through before the circuit opened
+		// only count
+		if probe || (b.state == BreakerClosed && b.failures >= b.threshold) {
+			b.state, b.openedAt = BreakerOpen, b.now()
+		}
+	}
+}
+
+// breakerOutcomeOf classifies a call to Qiniu: transport errors and 5xx responses are
+// failures. Client errors such as a rejected token or a per-token rate limit are the
+// caller's, and a call the caller cancelled says nothing about the upstream.
+func breakerOutcomeOf(ctx context.Context, statusCode int, err error) breakerOutcome {
+	switch {
+	case statusCode >= http.StatusInternalServerError:
+		return breakerFailure
+	case statusCode > 0:
+		return breakerSuccess
+	case err == nil:
+		return breakerSuccess
+	case ctx.Err() != nil:
+		return breakerIgnored
+	default:
+		return breakerFailure
+	}
+}
+
+// breakerDoer guards an httpDoer with a CircuitBreaker.
+type breakerDoer struct {
+	next    httpDoer
+	breaker *CircuitBreaker
+}
+
+func (d breakerDoer) Do(req *http.Request) (*http.Response, error) {
+	done, err := d.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.next.Do(req)
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+	done(breakerOutcomeOf(req.Context(), statusCode, err))
+	return resp, err
+}
+
+// dialWebsocket dials a Qiniu WebSocket through breaker. A handshake rejected with a
+// client error counts as a success, as Qiniu answered.
+func dialWebsocket(ctx context.Context, breaker *CircuitBreaker, url string, header http.Header) (*websocket.Conn, error) {
+	done, err := breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, url, header)
+	statusCode := 0
+	if err != nil && errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+		statusCode = resp.StatusCode
+	}
+	done(breakerOutcomeOf(ctx, statusCode, err))
+	return conn, err
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestBreaker returns a breaker whose clock only moves when the returned advance is called.
+func newTestBreaker(threshold int, cooldown time.Duration) (*CircuitBreaker, func(time.Duration)) {
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker("test", threshold, cooldown)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+// call reserves a call and reports outcome, failing the test when the breaker refuses it.
+func call(t *testing.T, b *CircuitBreaker, outcome breakerOutcome) {
+	t.Helper()
+	done, err := b.allow()
+	if err != nil {
+		t.Fatalf("allow in state %s: %v", b.State(), err)
+	}
+	done(outcome)
+}
+
+func assertState(t *testing.T, b *CircuitBreaker, want BreakerState) {
+	t.Helper()
+	if got := b.State(); got != want {
+		t.Fatalf("state = %s, want %s", got, want)
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	b, advance := newTestBreaker(3, 30*time.Second)
+
+	call(t, b, breakerFailure)
+	call(t, b, breakerFailure)
+	call(t, b, breakerSuccess)
+	call(t, b, breakerFailure)
+	call(t, b, breakerFailure)
+	assertState(t, b, BreakerClosed)
+
+	call(t, b, breakerFailure)
+	assertState(t, b, BreakerOpen)
+	if _, err := b.allow(); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("allow while open = %v, want ErrUpstreamUnavailable", err)
+	}
+	if err := b.Ping(context.Background()); err == nil {
+		t.Error("Ping succeeded while the circuit is open")
+	}
+
+	advance(29 * time.Second)
+	assertState(t, b, BreakerOpen)
+	advance(time.Second)
+	assertState(t, b, BreakerHalfOpen)
+
+	probe, err := b.allow()
+	if err != nil {
+		t.Fatalf("probe refused: %v", err)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("second call during the probe = %v, want ErrUpstreamUnavailable", err)
+	}
+	probe(breakerFailure)
+	assertState(t, b, BreakerOpen)
+
+	advance(30 * time.Second)
+	call(t, b, breakerSuccess)
+	assertState(t, b, BreakerClosed)
+	if err := b.Ping(context.Background()); err != nil {
+		t.Errorf("Ping after closing = %v", err)
+	}
+
+	// the failure count restarted, so it takes the full threshold to open again
+	call(t, b, breakerFailure)
+	call(t, b, breakerFailure)
+	assertState(t, b, BreakerClosed)
+}
+
+func TestCircuitBreakerIgnoredProbeKeepsHalfOpen(t *testing.T) {
+	b, advance := newTestBreaker(1, time.Minute)
+	call(t, b, breakerFailure)
+	advance(time.Minute)
+
+	call(t, b, breakerIgnored)
+	assertState(t, b, BreakerHalfOpen)
+	// the ignored probe released the slot, so the next call probes again
+	call(t, b, breakerSuccess)
+	assertState(t, b, BreakerClosed)
+}
+
+func TestCircuitBreakerLateFailuresOnlyCount(t *testing.T) {
+	b, advance := newTestBreaker(1, time.Minute)
+	before, err := b.allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	call(t, b, breakerFailure)
+	advance(time.Minute)
+	probe, err := b.allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a call let through before the circuit opened must not reopen it, nor free the probe slot
+	before(breakerFailure)
+	before(breakerSuccess)
+	assertState(t, b, BreakerHalfOpen)
+	if _, err := b.allow(); err == nil {
+		t.Fatal("a second probe was let through")
+	}
+	probe(breakerSuccess)
+	assertState(t, b, BreakerClosed)
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	var nilBreaker *CircuitBreaker
+	disabled, _ := newTestBreaker(0, time.Minute)
+	for _, b := range []*CircuitBreaker{nilBreaker, disabled} {
+		for range 5 {
+			call(t, b, breakerFailure)
+		}
+		assertState(t, b, BreakerClosed)
+	}
+}
+
+func TestBreakerOutcomeOf(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	transport := errors.New("connection refused")
+	cases := []struct {
+		name   string
+		ctx    context.Context
+		status int
+		err    error
+		want   breakerOutcome
+	}{
+		{"ok", context.Background(), http.StatusOK, nil, breakerSuccess},
+		{"client error", context.Background(), http.StatusTooManyRequests, nil, breakerSuccess},
+		{"server error", context.Background(), http.StatusBadGateway, nil, breakerFailure},
+		{"transport error", context.Background(), 0, transport, breakerFailure},
+		{"canceled by caller", canceled, 0, context.Canceled, breakerIgnored},
+		{"server error after cancel", canceled, http.StatusServiceUnavailable, nil, breakerFailure},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := breakerOutcomeOf(tc.ctx, tc.status, tc.err); got != tc.want {
+				t.Errorf("breakerOutcomeOf = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+type stubDoer struct {
+	status int
+	calls  int
+}
+
+func (d *stubDoer) Do(*http.Request) (*http.Response, error) {
+	d.calls++
+	return &http.Response{StatusCode: d.status, Body: http.NoBody}, nil
+}
+
+func TestBreakerDoerFailsFastWhileOpen(t *testing.T) {
+	b, _ := newTestBreaker(2, time.Minute)
+	next := &stubDoer{status: http.StatusInternalServerError}
+	doer := breakerDoer{next: next, breaker: b}
+	req, _ := http.NewRequest(http.MethodGet, "http://upstream.invalid", nil)
+
+	for range 2 {
+		if _, err := doer.Do(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := doer.Do(req); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("Do while open = %v, want ErrUpstreamUnavailable", err)
+	}
+	if next.calls != 2 {
+		t.Errorf("upstream called %d times, want 2", next.calls)
+	}
+}
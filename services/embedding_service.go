@@ -0,0 +1,130 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"go.uber.org/zap"
+)
+
+// maxEmbeddingBatch bounds the inputs of one /embeddings call.
+const maxEmbeddingBatch = 64
+
+// EmbeddingService is an Embedder calling an OpenAI-compatible /embeddings endpoint, by
+// default Qiniu's with the server key.
+type EmbeddingService struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  httpDoer
+	logger  *zap.SugaredLogger
+}
+
+// NewEmbeddingService builds the service from the EMBEDDING settings, or returns nil when
+// no EMBEDDING_MODEL is configured.
+func NewEmbeddingService(cfg *config.Config, logger *zap.SugaredLogger) *EmbeddingService {
+	model := strings.TrimSpace(cfg.EmbeddingModel)
+	if model == "" {
+		return nil
+	}
+	return &EmbeddingService{
+		baseURL: strings.TrimRight(cfg.EmbeddingBaseURL, "/"),
+		apiKey:  cfg.EmbeddingAPIKey,
+		model:   model,
+		client:  newHTTPClientWithTimeout(30 * time.Second),
+		logger:  logger,
+	}
+}
+
+// Model returns the embedding model, which vectors are only comparable within.
+func (s *EmbeddingService) Model() string {
+	return s.model
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed implements Embedder, batching texts to keep requests small.
+func (s *EmbeddingService) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbeddingBatch {
+		end := min(start+maxEmbeddingBatch, len(texts))
+		batch, err := s.embed(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+func (s *EmbeddingService) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(embeddingRequest{Model: s.model, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("marshal embedding payload: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create embedding request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+	setRequestID(ctx, request.Header)
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		ctxlog.From(ctx, s.logger).Warnf("call embedding api: %v", err)
+		return nil, fmt.Errorf("call embedding api: %w", err)
+	}
+	defer response.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(response.Body, 32<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read embedding response: %w", err)
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		apiErr := buildQiniuAPIError(response.StatusCode, respBody)
+		ctxlog.From(ctx, s.logger).Warnf("embedding api returned %d: %v", response.StatusCode, apiErr)
+		return nil, apiErr
+	}
+
+	var decoded embeddingResponse
+	if err := json.Unmarshal(respBody, &decoded); err != nil {
+		return nil, fmt.Errorf("decode embedding response: %w", err)
+	}
+	if len(decoded.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding api returned %d vectors for %d inputs", len(decoded.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, item := range decoded.Data {
+		if item.Index < 0 || item.Index >= len(texts) || len(item.Embedding) == 0 {
+			return nil, fmt.Errorf("embedding api returned an invalid vector at index %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	for i, vector := range vectors {
+		if vector == nil {
+			return nil, fmt.Errorf("embedding api returned no vector for input %d", i)
+		}
+	}
+	return vectors, nil
+}
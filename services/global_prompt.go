@@ -0,0 +1,56 @@
+package services
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// GlobalPrompt holds the deployment-wide text put before and after every role's system
+// prompt, typically guardrails such as topics to avoid. A nil GlobalPrompt adds nothing.
+type GlobalPrompt struct {
+	load    func() (prefix, suffix string, err error)
+	current atomic.Pointer[globalPromptText]
+}
+
+type globalPromptText struct {
+	prefix, suffix string
+}
+
+// NewGlobalPrompt returns a GlobalPrompt read by load, empty until Reload succeeds.
+func NewGlobalPrompt(load func() (prefix, suffix string, err error)) *GlobalPrompt {
+	g := &GlobalPrompt{load: load}
+	g.current.Store(&globalPromptText{})
+	return g
+}
+
+// Reload reads the prefix and suffix again; on error the previous text stays in use.
+func (g *GlobalPrompt) Reload() error {
+	prefix, suffix, err := g.load()
+	if err != nil {
+		return err
+	}
+	g.current.Store(&globalPromptText{prefix: strings.TrimSpace(prefix), suffix: strings.TrimSpace(suffix)})
+	return nil
+}
+
+// Text returns the prefix and suffix in use.
+func (g *GlobalPrompt) Text() (prefix, suffix string) {
+	if g == nil {
+		return "", ""
+	}
+	text := g.current.Load()
+	return text.prefix, text.suffix
+}
+
+// Wrap returns systemPrompt between the prefix and the suffix, each on lines of its own;
+// empty ones are left out.
+func (g *GlobalPrompt) Wrap(systemPrompt string) string {
+	prefix, suffix := g.Text()
+	parts := make([]string, 0, 3)
+	for _, part := range []string{prefix, systemPrompt, suffix} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
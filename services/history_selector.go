@@ -0,0 +1,209 @@
+package services
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// History strategies, set by NLP_HISTORY_STRATEGY.
+const (
+	// HistoryRecent keeps the latest messages verbatim and summarizes the rest.
+	HistoryRecent = "recent"
+	// HistoryImportance keeps the highest scoring exchanges, whatever their age.
+	HistoryImportance = "importance"
+)
+
+// HistorySelector picks which messages of a long history are kept verbatim; the rest are
+// summarized. history holds no empty messages.
+type HistorySelector interface {
+	// Select returns the ascending indexes of at most keep messages of history to keep.
+	Select(history []NLPMessage, keep int) []int
+}
+
+// NewHistorySelector returns the selector of strategy, RecentHistory for unknown ones.
+// tokenBudget caps the tokens an ImportanceHistory keeps; 0 caps only the message count.
+func NewHistorySelector(strategy string, tokenBudget int) HistorySelector {
+	if strategy == HistoryImportance {
+		return ImportanceHistory{Scorer: DefaultMessageScorer, TokenBudget: tokenBudget}
+	}
+	return RecentHistory{}
+}
+
+// RecentHistory keeps the last keep messages.
+type RecentHistory struct{}
+
+func (RecentHistory) Select(history []NLPMessage, keep int) []int {
+	start := max(len(history)-keep, 0)
+	indexes := make([]int, 0, len(history)-start)
+	for i := start; i < len(history); i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// MessageScorer rates how much message i of history matters to the rest of the
+// conversation; higher scores are kept first.
+type MessageScorer interface {
+	Score(history []NLPMessage, i int) float64
+}
+
+// ImportanceWeights scores a message as the weighted sum of four signals, each between 0
+// and 1: its recency, its length (saturating at 100 tokens), whether it asks a question,
+// and the share of its terms that later messages repeat.
+type ImportanceWeights struct {
+	Recency   float64
+	Length    float64
+	Question  float64
+	Reference float64
+}
+
+// DefaultMessageScorer favors questions and messages the conversation keeps coming back
+// to, such as the user's original request, over recency alone.
+var DefaultMessageScorer = ImportanceWeights{Recency: 1, Length: 0.5, Question: 1, Reference: 1.5}
+
+func (w ImportanceWeights) Score(history []NLPMessage, i int) float64 {
+	msg := history[i]
+	recency := float64(i+1) / float64(len(history))
+	length := math.Min(float64(EstimatePromptTokens([]NLPMessage{msg}))/100, 1)
+	question := 0.0
+	if isQuestion(msg.Content) {
+		question = 1
+	}
+
+	reference := 0.0
+	if terms := lexicalTerms(msg.Content); len(terms) > 0 {
+		later := make(map[string]struct{})
+		for _, next := range history[i+1:] {
+			for term := range lexicalTerms(next.Content) {
+				later[term] = struct{}{}
+			}
+		}
+		shared := 0
+		for term := range terms {
+			if _, ok := later[term]; ok {
+				shared++
+			}
+		}
+		reference = float64(shared) / float64(len(terms))
+	}
+
+	return w.Recency*recency + w.Length*length + w.Question*question + w.Reference*reference
+}
+
+// ImportanceHistory keeps whole exchanges, a user message with the replies that follow
+// it, so a reply is never kept without the message it answers. Exchanges are taken by
+// their best message's score while they fit in keep messages and TokenBudget tokens, then
+// restored to chronological order. The latest exchange is taken first whenever it fits.
+type ImportanceHistory struct {
+	Scorer MessageScorer
+	// TokenBudget caps the estimated tokens kept; 0 caps only the message count.
+	TokenBudget int
+}
+
+type historyExchange struct {
+	start, end int
+	score      float64
+	tokens     int
+}
+
+func (h ImportanceHistory) Select(history []NLPMessage, keep int) []int {
+	scorer := h.Scorer
+	if scorer == nil {
+		scorer = DefaultMessageScorer
+	}
+
+	var exchanges []historyExchange
+	for i := 0; i < len(history); {
+		end := i + 1
+		for end < len(history) && !strings.EqualFold(history[end].Role, "user") {
+			end++
+		}
+		exchange := historyExchange{start: i, end: end, score: math.Inf(-1), tokens: EstimatePromptTokens(history[i:end])}
+		for j := i; j < end; j++ {
+			exchange.score = math.Max(exchange.score, scorer.Score(history, j))
+		}
+		exchanges = append(exchanges, exchange)
+		i = end
+	}
+	if len(exchanges) == 0 {
+		return nil
+	}
+
+	// the latest exchange leads, the rest by score with later ones winning ties
+	ranked := slices.Clone(exchanges[:len(exchanges)-1])
+	slices.SortStableFunc(ranked, func(a, b historyExchange) int {
+		if a.score != b.score {
+			if a.score > b.score {
+				return -1
+			}
+			return 1
+		}
+		return b.start - a.start
+	})
+	ranked = slices.Insert(ranked, 0, exchanges[len(exchanges)-1])
+
+	var taken []historyExchange
+	count, tokens := 0, 0
+	for _, exchange := range ranked {
+		size := exchange.end - exchange.start
+		if count+size > keep || (h.TokenBudget > 0 && tokens+exchange.tokens > h.TokenBudget) {
+			continue
+		}
+		taken = append(taken, exchange)
+		count += size
+		tokens += exchange.tokens
+	}
+	slices.SortFunc(taken, func(a, b historyExchange) int { return a.start - b.start })
+
+	indexes := make([]int, 0, count)
+	for _, exchange := range taken {
+		for i := exchange.start; i < exchange.end; i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// isQuestion reports whether text asks something: a question mark, or a Chinese
+// sentence-final question particle.
+func isQuestion(text string) bool {
+	if strings.ContainsAny(text, "?？") {
+		return true
+	}
+	trimmed := strings.TrimRightFunc(text, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsPunct(r) })
+	return strings.HasSuffix(trimmed, "吗") || strings.HasSuffix(trimmed, "呢")
+}
+
+// lexicalTerms splits text into comparable terms: lowercase words of at least three
+// letters, and pairs of adjacent Han characters, since Chinese is written unspaced.
+func lexicalTerms(text string) map[string]struct{} {
+	terms := make(map[string]struct{})
+	var word []rune
+	var prevHan rune
+	flush := func() {
+		if len(word) >= 3 {
+			terms[string(word)] = struct{}{}
+		}
+		word = word[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		if unicode.Is(unicode.Han, r) {
+			flush()
+			if prevHan != 0 {
+				terms[string([]rune{prevHan, r})] = struct{}{}
+			}
+			prevHan = r
+			continue
+		}
+		prevHan = 0
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+	}
+	flush()
+	return terms
+}
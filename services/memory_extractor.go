@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+)
+
+const (
+	// maxMemoriesPerExchange bounds the facts kept from one exchange.
+	maxMemoriesPerExchange = 5
+	maxMemoryRunes         = 60
+)
+
+const memoryExtractionPrompt = "你负责为角色扮演对话记住用户的长期信息。从下面这轮对话中提取关于用户的持久事实，例如姓名、身份、目标、经历和偏好；" +
+	"忽略寒暄、一次性的问题和助手说的内容，也不要重复已知事实。每条事实以“用户”开头，不超过30字。" +
+	"只输出 JSON：{\"facts\": [\"...\"]}，没有可记的事实时输出 {\"facts\": []}。"
+
+// MemoryExtractor pulls durable facts about the user out of a chat exchange with a cheap
+// completion call.
+type MemoryExtractor struct {
+	nlp ChatCompleter
+}
+
+// NewMemoryExtractor builds an extractor calling nlp.
+func NewMemoryExtractor(nlp ChatCompleter) *MemoryExtractor {
+	return &MemoryExtractor{nlp: nlp}
+}
+
+// Extract returns the new facts in the exchange of userMessage and reply; known facts are
+// shown to the model so it does not repeat them.
+func (e *MemoryExtractor) Extract(ctx context.Context, token, userMessage, reply string, known []string) ([]string, error) {
+	system := memoryExtractionPrompt
+	if len(known) > 0 {
+		system += "\n\n已知事实：\n- " + strings.Join(known, "\n- ")
+	}
+	answer, err := e.nlp.Complete(ctx, token, []NLPMessage{
+		{Role: "system", Content: system},
+		{Role: "user", Content: "用户：" + strings.TrimSpace(userMessage) + "\n助手：" + strings.TrimSpace(reply)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ParseMemoryAnswer(answer)
+}
+
+// ParseMemoryAnswer extracts facts from a model answer: {"facts": [...]} or a bare array
+// of strings, optionally inside a Markdown code fence or surrounded by prose. Blank facts
+// are dropped and long ones truncated; an answer with no JSON at all is an UPSTREAM_ERROR.
+func ParseMemoryAnswer(answer string) ([]string, error) {
+	payload, ok := extractJSON(answer)
+	if !ok {
+		return nil, apierr.New(apierr.CodeUpstream, "memory extraction is not JSON").WithDetail(truncateRunes(answer, 200))
+	}
+	if payload[0] == '{' {
+		var wrapped struct {
+			Facts json.RawMessage `json:"facts"`
+		}
+		if err := json.Unmarshal(payload, &wrapped); err != nil || len(wrapped.Facts) == 0 {
+			return nil, apierr.New(apierr.CodeUpstream, "memory extraction has no facts list").WithDetail(truncateRunes(answer, 200))
+		}
+		payload = wrapped.Facts
+	}
+
+	var items []string
+	if err := json.Unmarshal(payload, &items); err != nil {
+		return nil, apierr.New(apierr.CodeUpstream, "memory extraction has no facts list").WithDetail(truncateRunes(answer, 200))
+	}
+	facts := make([]string, 0, len(items))
+	for _, item := range items {
+		if fact := strings.TrimSpace(item); fact != "" {
+			facts = append(facts, truncateRunes(fact, maxMemoryRunes))
+		}
+		if len(facts) == maxMemoriesPerExchange {
+			break
+		}
+	}
+	return facts, nil
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"go.uber.org/zap"
+)
+
+// micLoop turns a stream of captured PCM frames into spoken turns: speech above the
+// silence threshold opens an ASR stream, SilenceMs of quiet closes it, and the final
+// transcript is answered by the NLP service. Capture backends only supply frames.
+type micLoop struct {
+	cfg     *config.Config
+	asr     *ASRService
+	nlp     *NLPService
+	role    models.Role
+	lang    string
+	rc      RaylibASRConfig
+	out     io.Writer
+	logger  *zap.SugaredLogger
+	history []NLPMessage
+}
+
+func newMicLoop(cfg *config.Config, nlp *NLPService, role models.Role, lang string, rc RaylibASRConfig, out io.Writer, logger *zap.SugaredLogger) *micLoop {
+	if rc.SampleRate <= 0 {
+		rc.SampleRate = 16000
+	}
+	if rc.Channels <= 0 {
+		rc.Channels = 1
+	}
+	rc.Bits = 16
+	if rc.SilenceThreshold <= 0 {
+		rc.SilenceThreshold = 0.02
+	}
+	if rc.SilenceMs <= 0 {
+		rc.SilenceMs = 800
+	}
+	return &micLoop{
+		cfg:    cfg,
+		asr:    NewASRService(cfg, logger),
+		nlp:    nlp,
+		role:   role,
+		lang:   lang,
+		rc:     rc,
+		out:    out,
+		logger: logger,
+	}
+}
+
+// run consumes frames until ctx ends or frames is closed.
+func (l *micLoop) run(ctx context.Context, frames <-chan []byte) error {
+	token := strings.TrimSpace(l.cfg.QiniuAPIKey)
+	if token == "" {
+		return fmt.Errorf("QINIU_API_KEY is required for microphone ASR")
+	}
+
+	var (
+		stream    *ASRStream
+		finals    chan string
+		silentFor time.Duration
+	)
+	closeStream := func() {
+		if stream == nil {
+			return
+		}
+		if err := stream.SendStop(); err != nil {
+			l.logger.Warnf("send asr stop: %v", err)
+		}
+		select {
+		case text := <-finals:
+			l.respond(ctx, token, text)
+		case <-time.After(5 * time.Second):
+			l.logger.Warnf("timed out waiting for final transcript")
+		case <-ctx.Done():
+		}
+		_ = stream.Close()
+		stream, finals, silentFor = nil, nil, 0
+	}
+	defer func() {
+		if stream != nil {
+			_ = stream.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case frame, ok := <-frames:
+			if !ok {
+				closeStream()
+				return nil
+			}
+			voiced := pcmRMS(frame) >= l.rc.SilenceThreshold
+			if stream == nil {
+				if !voiced {
+					continue
+				}
+				opened, err := l.asr.OpenStream(ctx, token, ASRStreamOptions{
+					SampleRate: l.rc.SampleRate,
+					Channels:   l.rc.Channels,
+					Bits:       l.rc.Bits,
+					Language:   l.lang,
+					Hotwords:   RoleHotwords(l.role),
+				})
+				if err != nil {
+					return fmt.Errorf("open asr stream: %w", err)
+				}
+				stream = opened
+				finals = make(chan string, 1)
+				go l.readTranscripts(opened, finals)
+			}
+
+			if err := stream.SendAudioChunk(frame); err != nil {
+				return fmt.Errorf("send audio chunk: %w", err)
+			}
+			if voiced {
+				silentFor = 0
+				continue
+			}
+			silentFor += pcmFrameDuration(len(frame), l.rc)
+			if silentFor >= time.Duration(l.rc.SilenceMs)*time.Millisecond {
+				closeStream()
+			}
+		}
+	}
+}
+
+// readTranscripts prints interim results in place and delivers the last text once the stream ends.
+func (l *micLoop) readTranscripts(stream *ASRStream, finals chan<- string) {
+	last := ""
+	defer func() { finals <- last }()
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return
+		}
+		if event.Err != nil {
+			l.logger.Warnf("parse asr message: %v", event.Err)
+			DebugHexDump(l.logger, "unparseable asr frame", event.Payload)
+			continue
+		}
+		if event.Control != "" {
+			continue
+		}
+		transcript := event.Transcript
+		if transcript.Text != "" {
+			last = transcript.Text
+		}
+		if transcript.IsFinal {
+			fmt.Fprintf(l.out, "\r\033[K[final] %s\n", last)
+			return
+		}
+		fmt.Fprintf(l.out, "\r\033[K[...] %s", last)
+	}
+}
+
+func (l *micLoop) respond(ctx context.Context, token, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	reply, err := l.nlp.GenerateReply(ctx, token, NLPRequest{
+		Role:        l.role,
+		Language:    l.lang,
+		History:     l.history,
+		UserMessage: text,
+	})
+	if err != nil {
+		fmt.Fprintf(l.out, "[error] %v\n", err)
+		return
+	}
+	fmt.Fprintf(l.out, "[%s] %s\n", l.role.Name, reply.Reply.Content)
+
+	l.history = append(l.history,
+		NLPMessage{Role: "user", Content: text},
+		NLPMessage{Role: "assistant", Content: reply.Reply.Content},
+	)
+	if len(l.history) > 20 {
+		l.history = l.history[len(l.history)-20:]
+	}
+}
+
+// pcmRMS returns the normalized (0..1) RMS level of little-endian 16-bit PCM.
+func pcmRMS(frame []byte) float64 {
+	samples := len(frame) / 2
+	if samples == 0 {
+		return 0
+	}
+	var sum float64
+	for i := 0; i+1 < len(frame); i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(frame[i:]))) / math.MaxInt16
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(samples))
+}
+
+func pcmFrameDuration(size int, rc RaylibASRConfig) time.Duration {
+	bytesPerSecond := rc.SampleRate * rc.Channels * rc.Bits / 8
+	if bytesPerSecond <= 0 {
+		return 0
+	}
+	return time.Duration(size) * time.Second / time.Duration(bytesPerSecond)
+}
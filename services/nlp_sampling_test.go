@@ -0,0 +1,108 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+func TestComposePromptSampling(t *testing.T) {
+	float := func(v float64) *float64 { return &v }
+	integer := func(v int) *int { return &v }
+	configured := &config.Config{QiniuNLPTemperature: 0.8, QiniuNLPMaxTokens: 256, QiniuNLPTopP: 0.9}
+
+	cases := []struct {
+		name          string
+		cfg           *config.Config
+		req           NLPRequest
+		skills        []string
+		wantTemp      *float64
+		wantMaxTokens int
+		wantTopP      *float64
+		wantDefaulted []string
+	}{
+		{
+			name:          "omitted takes the defaults",
+			cfg:           configured,
+			wantTemp:      float(0.8),
+			wantMaxTokens: 256,
+			wantTopP:      float(0.9),
+			wantDefaulted: []string{"temperature", "max_tokens", "top_p"},
+		},
+		{
+			name:     "explicit zero is kept",
+			cfg:      configured,
+			req:      NLPRequest{Temperature: float(0), MaxTokens: integer(0), TopP: float(0)},
+			wantTemp: float(0),
+			wantTopP: float(0),
+		},
+		{
+			name:          "explicit values win",
+			cfg:           configured,
+			req:           NLPRequest{Temperature: float(1.2), MaxTokens: integer(64)},
+			wantTemp:      float(1.2),
+			wantMaxTokens: 64,
+			wantTopP:      float(0.9),
+			wantDefaulted: []string{"top_p"},
+		},
+		{
+			name: "no defaults leaves them to the provider",
+			cfg:  &config.Config{},
+		},
+		{
+			name:          "skills adjust an explicit zero",
+			cfg:           configured,
+			req:           NLPRequest{Temperature: float(0)},
+			skills:        []string{"emo_stabilizer"},
+			wantTemp:      float(0.1),
+			wantMaxTokens: 256,
+			wantTopP:      float(0.9),
+			wantDefaulted: []string{"max_tokens", "top_p"},
+		},
+		{
+			name:     "skills start an omitted temperature from the skill base",
+			cfg:      &config.Config{},
+			skills:   []string{"citation_mode"},
+			wantTemp: float(0.4),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewNLPService(tc.cfg, zap.NewNop().Sugar())
+			req := tc.req
+			req.Role = models.Role{ID: 1, Name: "Sage"}
+			req.UserMessage = "Hello."
+			req.EnabledSkillIDs = tc.skills
+			req.DisableAutoSkills = true
+
+			prompt, err := svc.ComposePrompt(req)
+			if err != nil {
+				t.Fatalf("ComposePrompt: %v", err)
+			}
+			got := prompt.Sampling
+			if !reflect.DeepEqual(got.Temperature, tc.wantTemp) {
+				t.Errorf("temperature = %v, want %v", deref(got.Temperature), deref(tc.wantTemp))
+			}
+			if got.MaxTokens != tc.wantMaxTokens {
+				t.Errorf("max_tokens = %d, want %d", got.MaxTokens, tc.wantMaxTokens)
+			}
+			if !reflect.DeepEqual(got.TopP, tc.wantTopP) {
+				t.Errorf("top_p = %v, want %v", deref(got.TopP), deref(tc.wantTopP))
+			}
+			if !reflect.DeepEqual(got.Defaulted, tc.wantDefaulted) {
+				t.Errorf("defaulted = %v, want %v", got.Defaulted, tc.wantDefaulted)
+			}
+		})
+	}
+}
+
+func deref(v *float64) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
@@ -5,23 +5,33 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"math"
+	"slices"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 
+	"github.com/wuwenbin0122/wwb.ai/apierr"
 	"github.com/wuwenbin0122/wwb.ai/config"
 	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"github.com/wuwenbin0122/wwb.ai/payloadlog"
 	"go.uber.org/zap"
 )
 
 const (
 	defaultSummaryThreshold  = 8
 	defaultRecentMessageKeep = 4
-	defaultLanguage          = "zh"
 	maxSummaryRuneLength     = 120
+	// defaultSkillTemperature is the temperature skill deltas apply to when the request
+	// leaves it to the provider.
+	defaultSkillTemperature = 0.7
+	maxTemperature          = 2.0
 )
 
+// DefaultLanguage is the reply language of requests that neither set one nor have one
+// negotiated for them.
+const DefaultLanguage = "zh"
+
 type NLPMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
@@ -41,8 +51,24 @@ type NLPRequest struct {
 	EnabledSkillIDs    []string
 	SummaryThreshold   int
 	RecentMessageCount int
-	Temperature        float64
-	MaxTokens          int
+	// Temperature, MaxTokens and TopP are the requested sampling parameters; nil takes the
+	// deployment default, while an explicit 0 is kept (greedy sampling for temperature, no
+	// cap for max tokens).
+	Temperature *float64
+	MaxTokens   *int
+	TopP        *float64
+	// Memories are facts remembered about the user, most relevant first. ComposePrompt
+	// injects as many as the token budget allows.
+	Memories []string
+	// ResponseFormat is ResponseFormatText (the default when empty) or
+	// ResponseFormatStructured, which asks for a StructuredReply.
+	ResponseFormat string
+	// Provider names the chat provider to call; empty selects the configured default.
+	Provider string
+	// Sentiment is how the user sounds in the conversation, nil when not analyzed. A
+	// distressed user turns emo_stabilizer on unless DisableAutoSkills is set.
+	Sentiment         *Sentiment
+	DisableAutoSkills bool
 }
 
 type NLPResponse struct {
@@ -53,13 +79,38 @@ type NLPResponse struct {
 	SystemPrompt    string          `json:"system_prompt"`
 	HistorySummary  string          `json:"history_summary"`
 	EnabledSkillIDs []string        `json:"enabled_skill_ids"`
+	Memories        []string        `json:"memories,omitempty"`
+	Sampling        NLPSampling     `json:"sampling"`
+	// Structured is set when a structured reply was requested and parsed; Reply.Content
+	// then holds its speech. A reply that fails to parse is returned as plain text.
+	Structured *StructuredReply `json:"structured,omitempty"`
+	// Provider is the chat provider that generated the reply.
+	Provider string `json:"provider"`
 }
 
 type NLPService struct {
-	baseURL string
-	model   string
-	client  httpDoer
-	logger  *zap.SugaredLogger
+	providers       map[string]ChatProvider
+	defaultProvider string
+	maxPromptTokens int
+	// sentimentThreshold is the rolling sentiment at or below whose negation skills are
+	// auto-enabled; 0 disables them.
+	sentimentThreshold float64
+	// guardMode and guardNormalize configure the prompt guard run on user messages;
+	// guardObserver counts what it flags in messages sent upstream.
+	guardMode      string
+	guardNormalize bool
+	guardObserver  PromptGuardObserver
+	// defaultTemperature, defaultMaxTokens and defaultTopP replace the sampling parameters
+	// a request leaves unset; zero leaves them to the provider.
+	defaultTemperature float64
+	defaultMaxTokens   int
+	defaultTopP        float64
+	// historySelector picks the history messages kept verbatim once a conversation is
+	// long enough to be summarized.
+	historySelector HistorySelector
+	// globalPrompt wraps every system prompt in the deployment-wide prefix and suffix.
+	globalPrompt *GlobalPrompt
+	logger       *zap.SugaredLogger
 }
 
 func NewNLPService(cfg *config.Config, logger *zap.SugaredLogger) *NLPService {
@@ -73,28 +124,186 @@ func NewNLPService(cfg *config.Config, logger *zap.SugaredLogger) *NLPService {
 		model = "doubao-1.5-vision-pro"
 	}
 
+	providers := map[string]ChatProvider{
+		QiniuProvider: NewOpenAIProvider(config.ChatProviderConfig{
+			Name:       QiniuProvider,
+			BaseURL:    base,
+			Model:      model,
+			AuthHeader: "Authorization",
+			AuthScheme: "Bearer",
+			JSONMode:   cfg.QiniuNLPJSONMode,
+		}, newQiniuBreaker(cfg, BreakerChat), logger),
+	}
+	for _, provider := range cfg.ChatProviders {
+		// each backend fails on its own, so each gets its own circuit
+		providers[provider.Name] = NewOpenAIProvider(provider, newQiniuBreaker(cfg, BreakerLLMPrefix+provider.Name), logger)
+	}
+
+	defaultProvider := cfg.ChatProviderDefault
+	if defaultProvider == "" {
+		defaultProvider = QiniuProvider
+	}
+	globalPrompt := NewGlobalPrompt(cfg.GlobalPrompt)
+	if err := globalPrompt.Reload(); err != nil {
+		logger.Warnf("load global prompt, chats go without it: %v", err)
+	}
 	return &NLPService{
-		baseURL: base,
-		model:   model,
-		client:  newDefaultHTTPClient(),
-		logger:  logger,
+		providers:          providers,
+		defaultProvider:    defaultProvider,
+		maxPromptTokens:    cfg.NLPMaxPromptTokens,
+		sentimentThreshold: float64(cfg.SentimentAutoSkillThreshold) / 100,
+		guardMode:          cfg.PromptGuard,
+		guardNormalize:     cfg.PromptGuardNormalize,
+		defaultTemperature: cfg.QiniuNLPTemperature,
+		defaultMaxTokens:   cfg.QiniuNLPMaxTokens,
+		defaultTopP:        cfg.QiniuNLPTopP,
+		historySelector:    NewHistorySelector(cfg.NLPHistoryStrategy, cfg.NLPHistoryTokenBudget),
+		globalPrompt:       globalPrompt,
+		logger:             logger,
 	}
 }
 
-func (s *NLPService) GenerateReply(ctx context.Context, token string, req NLPRequest) (*NLPResponse, error) {
-	token = strings.TrimSpace(token)
-	if token == "" {
-		return nil, fmt.Errorf("authorization token is required")
+// Provider returns the chat provider called name, or the default one when name is empty.
+// An unknown name is an INVALID_REQUEST.
+func (s *NLPService) Provider(name string) (ChatProvider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = s.defaultProvider
+	}
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, apierr.New(apierr.CodeInvalidRequest, "unknown chat provider").With("provider", name)
+	}
+	return provider, nil
+}
+
+// RequiresToken reports whether calls to the provider called name need the caller's
+// token; providers with their own key or no authentication do not.
+func (s *NLPService) RequiresToken(name string) bool {
+	provider, err := s.Provider(name)
+	if err != nil {
+		return true
+	}
+	caller, ok := provider.(interface{ UsesCallerToken() bool })
+	return !ok || caller.UsesCallerToken()
+}
+
+// Breaker returns the circuit breaker guarding the Qiniu chat API.
+func (s *NLPService) Breaker() *CircuitBreaker {
+	return s.providers[QiniuProvider].(*OpenAIProvider).Breaker()
+}
+
+// Breakers returns the circuit breakers of every chat provider, Qiniu's first.
+func (s *NLPService) Breakers() []*CircuitBreaker {
+	breakers := []*CircuitBreaker{s.Breaker()}
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
 	}
+	slices.Sort(names)
+	for _, name := range names {
+		if provider, ok := s.providers[name].(*OpenAIProvider); ok && name != QiniuProvider {
+			breakers = append(breakers, provider.Breaker())
+		}
+	}
+	return breakers
+}
+
+// RecordPayloads makes every chat provider pass its calls to recorder, which decides per
+// call whether to keep them.
+func (s *NLPService) RecordPayloads(recorder *payloadlog.Recorder) {
+	for _, provider := range s.providers {
+		if openAI, ok := provider.(*OpenAIProvider); ok {
+			openAI.payloads = recorder
+		}
+	}
+}
+
+// GlobalPrompt returns the deployment-wide prompt text, for reloading it.
+func (s *NLPService) GlobalPrompt() *GlobalPrompt {
+	return s.globalPrompt
+}
+
+// SelectHistory replaces the configured history strategy with selector.
+func (s *NLPService) SelectHistory(selector HistorySelector) {
+	s.historySelector = selector
+}
+
+// ObservePromptGuard makes GenerateReply and StreamReply report the injection patterns
+// found in the messages they send to observer.
+func (s *NLPService) ObservePromptGuard(observer PromptGuardObserver) {
+	s.guardObserver = observer
+}
+
+func (s *NLPService) observeGuard(report PromptGuardReport) {
+	if s.guardObserver == nil {
+		return
+	}
+	for _, flag := range report.Flags {
+		s.guardObserver.Flagged(flag)
+	}
+}
 
-	userInput := strings.TrimSpace(req.UserMessage)
-	if userInput == "" {
+// NLPPrompt is the fully composed prompt for a chat request, before it is sent upstream.
+type NLPPrompt struct {
+	Messages        []NLPMessage
+	SystemPrompt    string
+	HistorySummary  string
+	EnabledSkillIDs []string
+	// GlobalPrefix and GlobalSuffix are the deployment-wide text SystemPrompt starts and
+	// ends with, empty when none is configured.
+	GlobalPrefix string
+	GlobalSuffix string
+	// AutoEnabledSkillIDs lists the skills among EnabledSkillIDs that the conversation's
+	// sentiment turned on rather than the request.
+	AutoEnabledSkillIDs []string
+	// UnknownSkillIDs lists requested skill ids that were ignored because the role does not define them.
+	UnknownSkillIDs []string
+	// SummarizedMessages counts history messages folded into HistorySummary.
+	SummarizedMessages int
+	// Memories are the remembered facts injected; DroppedMemories counts the less relevant
+	// ones left out to fit the token budget.
+	Memories        []string
+	DroppedMemories int
+	EstimatedTokens int
+	// Sampling holds the parameters sent upstream once the enabled skills adjusted them.
+	Sampling NLPSampling
+	// ResponseFormat is the normalized NLPRequest.ResponseFormat.
+	ResponseFormat string
+	// Guard reports injection patterns found in the user message and how it was sanitized.
+	Guard PromptGuardReport
+}
+
+// NLPSampling are the sampling parameters of a chat call; a nil temperature or top_p and
+// zero max tokens are left to the provider.
+type NLPSampling struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	// Defaulted names the parameters taken from the deployment defaults because the
+	// request left them unset.
+	Defaulted []string `json:"defaulted,omitempty"`
+}
+
+// ErrPromptTooLarge is returned when the composed prompt exceeds the configured token budget.
+var ErrPromptTooLarge = apierr.New(apierr.CodePromptTooLarge, "prompt exceeds token budget")
+
+// ComposePrompt applies every request rule (skills, language, history summarization and the
+// token budget) and builds the prompt without calling the provider. GenerateReply uses it,
+// so a request that composes cleanly here is exactly one the chat endpoint will send.
+func (s *NLPService) ComposePrompt(req NLPRequest) (*NLPPrompt, error) {
+	userInput, guard := guardUserMessage(strings.TrimSpace(req.UserMessage), s.guardMode, s.guardNormalize)
+	if strings.TrimSpace(userInput) == "" {
 		return nil, fmt.Errorf("user message cannot be empty")
 	}
+	responseFormat, err := NormalizeResponseFormat(req.ResponseFormat)
+	if err != nil {
+		return nil, err
+	}
 
 	lang := strings.TrimSpace(req.Language)
 	if lang == "" {
-		lang = defaultLanguage
+		lang = DefaultLanguage
 	}
 
 	summaryThreshold := req.SummaryThreshold
@@ -120,17 +329,24 @@ func (s *NLPService) GenerateReply(ctx context.Context, token string, req NLPReq
 		skillIndex[skill.ID] = skill
 	}
 
-    enabledIDs := filterSkillIDs(req.EnabledSkillIDs, skillIndex)
-    // If client does not specify skills, default to all skills defined on the role
-    if len(req.EnabledSkillIDs) == 0 && len(skillIndex) > 0 {
-        enabledIDs = make([]string, 0, len(skillIndex))
-        for id := range skillIndex {
-            enabledIDs = append(enabledIDs, id)
-        }
-    }
+	enabledIDs := filterSkillIDs(req.EnabledSkillIDs, skillIndex)
+	// If client does not specify skills, default to all skills defined on the role
+	if len(req.EnabledSkillIDs) == 0 && len(skillIndex) > 0 {
+		enabledIDs = make([]string, 0, len(skillIndex))
+		for id := range skillIndex {
+			enabledIDs = append(enabledIDs, id)
+		}
+	}
+	autoIDs := autoEnabledSkills(req, enabledIDs, s.sentimentThreshold)
+	enabledIDs = append(enabledIDs, autoIDs...)
 	enabledNames := make([]string, 0, len(enabledIDs))
 	for _, id := range enabledIDs {
-		enabledNames = append(enabledNames, skillIndex[id].Name)
+		name := skillIndex[id].Name
+		if name == "" {
+			// auto-enabled skills need not be defined on the role
+			name = skillHooks[id].name
+		}
+		enabledNames = append(enabledNames, name)
 	}
 
 	enabledCSV := "无"
@@ -144,88 +360,249 @@ func (s *NLPService) GenerateReply(ctx context.Context, token string, req NLPReq
 	}
 
 	systemPrompt := buildSystemPrompt(req.Role.Name, persona, strings.TrimSpace(req.Role.Background), enabledCSV, lang, skillDirectives)
+	if responseFormat == ResponseFormatStructured {
+		systemPrompt += "\n" + structuredReplyInstruction
+	}
+	if guard.Quoted {
+		systemPrompt += "\n" + injectionDirective
+	}
+	globalPrefix, globalSuffix := s.globalPrompt.Text()
+	systemPrompt = s.globalPrompt.Wrap(systemPrompt)
 
-	historySummary, preservedHistory := splitHistory(req.History, summaryThreshold, recentKeep, req.Role.Name)
+	historySummary, preservedHistory := splitHistory(req.History, summaryThreshold, recentKeep, req.Role.Name, s.historySelector)
 
-	promptMessages := make([]NLPMessage, 0, 2+len(preservedHistory))
+	promptMessages := make([]NLPMessage, 0, 3+len(preservedHistory))
 	promptMessages = append(promptMessages, NLPMessage{Role: "system", Content: systemPrompt})
 	if historySummary != "" {
 		promptMessages = append(promptMessages, NLPMessage{Role: "system", Content: "历史摘要：\n" + historySummary})
 	}
 	promptMessages = append(promptMessages, preservedHistory...)
 	promptMessages = append(promptMessages, NLPMessage{Role: "user", Content: userInput})
+	memories, droppedMemories := s.fitMemories(promptMessages, req.Memories)
+	if len(memories) > 0 {
+		// right after the role prompt, ahead of the history the facts were learned from
+		promptMessages = slices.Insert(promptMessages, 1, memoryMessage(memories))
+	}
 
-	requestPayload := nlpAPIRequest{
-		Model:    s.model,
-		Messages: promptMessages,
+	prompt := &NLPPrompt{
+		Messages:            promptMessages,
+		SystemPrompt:        systemPrompt,
+		GlobalPrefix:        globalPrefix,
+		GlobalSuffix:        globalSuffix,
+		HistorySummary:      historySummary,
+		EnabledSkillIDs:     enabledIDs,
+		AutoEnabledSkillIDs: autoIDs,
+		UnknownSkillIDs:     unknownSkillIDs(req.EnabledSkillIDs, enabledIDs),
+		Memories:            memories,
+		DroppedMemories:     droppedMemories,
+		EstimatedTokens:     EstimatePromptTokens(promptMessages),
+		Sampling:            s.sampling(enabledIDs, req),
+		ResponseFormat:      responseFormat,
+		Guard:               guard,
 	}
-	if req.Temperature > 0 {
-		requestPayload.Temperature = req.Temperature
+	if historySummary != "" {
+		prompt.SummarizedMessages = countNonEmpty(req.History) - len(preservedHistory)
 	}
-	if req.MaxTokens > 0 {
-		requestPayload.MaxTokens = req.MaxTokens
+
+	if s.maxPromptTokens > 0 && prompt.EstimatedTokens > s.maxPromptTokens {
+		return prompt, fmt.Errorf("%w: estimated %d tokens, limit %d", ErrPromptTooLarge, prompt.EstimatedTokens, s.maxPromptTokens)
 	}
 
-	body, err := json.Marshal(requestPayload)
-	if err != nil {
-		return nil, fmt.Errorf("marshal chat payload: %w", err)
+	return prompt, nil
+}
+
+// fitMemories returns the leading memories that fit in the token budget next to messages,
+// and how many were left out. Memories never push a prompt over the budget: the least
+// relevant are left out first, and all of them when the prompt is over budget already.
+func (s *NLPService) fitMemories(messages []NLPMessage, memories []string) ([]string, int) {
+	facts := make([]string, 0, len(memories))
+	for _, memory := range memories {
+		if fact := strings.TrimSpace(memory); fact != "" {
+			facts = append(facts, fact)
+		}
+	}
+	if s.maxPromptTokens <= 0 {
+		return facts, 0
+	}
+	base := EstimatePromptTokens(messages)
+	for n := len(facts); n > 0; n-- {
+		if base+EstimatePromptTokens([]NLPMessage{memoryMessage(facts[:n])}) <= s.maxPromptTokens {
+			return facts[:n], len(facts) - n
+		}
 	}
+	return nil, len(facts)
+}
 
-	endpoint := s.baseURL + "/chat/completions"
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("create chat request: %w", err)
+func memoryMessage(facts []string) NLPMessage {
+	return NLPMessage{Role: "system", Content: "关于用户的记忆（来自以往对话）：\n- " + strings.Join(facts, "\n- ")}
+}
+
+// MaxPromptTokens reports the prompt token budget; 0 means unlimited.
+func (s *NLPService) MaxPromptTokens() int {
+	return s.maxPromptTokens
+}
+
+// EstimatePromptTokens approximates the provider's token count without a tokenizer:
+// CJK characters count as one token each, other text as one token per four bytes,
+// plus a small per-message overhead for role framing.
+func EstimatePromptTokens(messages []NLPMessage) int {
+	const perMessageOverhead = 4
+
+	total := 0
+	for _, msg := range messages {
+		total += perMessageOverhead
+		other := 0
+		for _, r := range msg.Content {
+			if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+				total++
+				continue
+			}
+			other += utf8.RuneLen(r)
+		}
+		total += (other + 3) / 4
+	}
+	return total
+}
+
+func unknownSkillIDs(requested, enabled []string) []string {
+	accepted := make(map[string]struct{}, len(enabled))
+	for _, id := range enabled {
+		accepted[id] = struct{}{}
 	}
 
-	request.Header.Set("Authorization", "Bearer "+token)
-	request.Header.Set("Content-Type", "application/json")
+	var unknown []string
+	for _, id := range requested {
+		trimmed := strings.TrimSpace(id)
+		if trimmed == "" {
+			continue
+		}
+		if _, ok := accepted[trimmed]; ok {
+			continue
+		}
+		accepted[trimmed] = struct{}{}
+		unknown = append(unknown, trimmed)
+	}
+	return unknown
+}
+
+func countNonEmpty(messages []NLPMessage) int {
+	count := 0
+	for _, msg := range messages {
+		if strings.TrimSpace(msg.Content) != "" {
+			count++
+		}
+	}
+	return count
+}
 
-	response, err := s.client.Do(request)
+func (s *NLPService) GenerateReply(ctx context.Context, token string, req NLPRequest) (*NLPResponse, error) {
+	provider, err := s.Provider(req.Provider)
 	if err != nil {
-		return nil, fmt.Errorf("call chat api: %w", err)
+		return nil, err
 	}
-	defer response.Body.Close()
 
-	respBody, err := io.ReadAll(response.Body)
+	prompt, err := s.ComposePrompt(req)
 	if err != nil {
-		return nil, fmt.Errorf("read chat response: %w", err)
+		return nil, err
 	}
+	s.observeGuard(prompt.Guard)
+	promptMessages := prompt.Messages
 
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return nil, buildQiniuAPIError(response.StatusCode, respBody)
+	completion, err := provider.Complete(ctx, token, prompt.completionRequest())
+	if err != nil {
+		return nil, err
 	}
 
-	var apiResp nlpAPIResponse
-	if err := json.Unmarshal(respBody, &apiResp); err != nil {
-		return nil, fmt.Errorf("decode chat response: %w", err)
+	result := &NLPResponse{
+		Reply:           completion.Message,
+		Usage:           completion.Usage,
+		Raw:             completion.Raw,
+		PromptMessages:  promptMessages,
+		SystemPrompt:    prompt.SystemPrompt,
+		HistorySummary:  prompt.HistorySummary,
+		EnabledSkillIDs: prompt.EnabledSkillIDs,
+		Memories:        prompt.Memories,
+		Sampling:        prompt.Sampling,
+		Provider:        provider.Name(),
+	}
+	if prompt.ResponseFormat == ResponseFormatStructured {
+		result.Structured = s.parseStructured(ctx, &result.Reply)
 	}
 
-	if apiResp.Error != nil && apiResp.Error.Message != "" {
-		return nil, fmt.Errorf("qiniu chat error: %s", apiResp.Error.Message)
+	return result, nil
+}
+
+// Complete sends messages to the default chat model as they are, without a role prompt,
+// and returns the text of the first choice. It is for internal tooling such as
+// SkillEnricher.
+func (s *NLPService) Complete(ctx context.Context, token string, messages []NLPMessage) (string, error) {
+	provider, err := s.Provider("")
+	if err != nil {
+		return "", err
 	}
+	completion, err := provider.Complete(ctx, token, ChatCompletionRequest{Messages: messages})
+	if err != nil {
+		return "", err
+	}
+	return completion.Message.Content, nil
+}
+
+// NLPStreamResult is the outcome of a streamed reply.
+type NLPStreamResult struct {
+	Reply NLPMessage
+	// Usage is nil when the provider did not report token counts.
+	Usage        *NLPUsage
+	FinishReason string
+	Sampling     NLPSampling
+	// Structured is set as in NLPResponse; the deltas carry the raw JSON.
+	Structured *StructuredReply
+	Provider   string
+}
 
-	if len(apiResp.Choices) == 0 {
-		return nil, fmt.Errorf("chat response contained no choices")
+// StreamReply is GenerateReply with the reply streamed: onDelta receives each piece of
+// content as the model generates it, and the result holds the whole reply. An error from
+// onDelta aborts the upstream call and is returned; so does cancelling ctx.
+func (s *NLPService) StreamReply(ctx context.Context, token string, req NLPRequest, onDelta func(content string) error) (*NLPStreamResult, error) {
+	provider, err := s.Provider(req.Provider)
+	if err != nil {
+		return nil, err
 	}
 
-	reply := apiResp.Choices[0].Message
-	if strings.TrimSpace(reply.Role) == "" {
-		reply.Role = "assistant"
+	prompt, err := s.ComposePrompt(req)
+	if err != nil {
+		return nil, err
 	}
+	s.observeGuard(prompt.Guard)
 
-	result := &NLPResponse{
-		Reply:           reply,
-		Usage:           apiResp.Usage,
-		Raw:             json.RawMessage(respBody),
-		PromptMessages:  promptMessages,
-		SystemPrompt:    systemPrompt,
-		HistorySummary:  historySummary,
-		EnabledSkillIDs: enabledIDs,
+	completion, err := provider.Stream(ctx, token, prompt.completionRequest(), onDelta)
+	if err != nil {
+		return nil, err
 	}
 
+	result := &NLPStreamResult{
+		Reply:        completion.Message,
+		Usage:        completion.Usage,
+		FinishReason: completion.FinishReason,
+		Sampling:     prompt.Sampling,
+		Provider:     provider.Name(),
+	}
+	if prompt.ResponseFormat == ResponseFormatStructured {
+		result.Structured = s.parseStructured(ctx, &result.Reply)
+	}
 	return result, nil
 }
 
+// completionRequest is the upstream call for the prompt.
+func (p *NLPPrompt) completionRequest() ChatCompletionRequest {
+	return ChatCompletionRequest{
+		Messages:    p.Messages,
+		Temperature: p.Sampling.Temperature,
+		MaxTokens:   p.Sampling.MaxTokens,
+		TopP:        p.Sampling.TopP,
+		JSON:        p.ResponseFormat == ResponseFormatStructured,
+	}
+}
+
 type rolePersonality struct {
 	Tone        string   `json:"tone"`
 	Style       string   `json:"style"`
@@ -334,7 +711,7 @@ func buildSystemPrompt(roleName string, persona rolePersonality, background, ena
 
 	lang = strings.TrimSpace(lang)
 	if lang == "" {
-		lang = defaultLanguage
+		lang = DefaultLanguage
 	}
 
 	var builder strings.Builder
@@ -378,7 +755,9 @@ func filterNonEmpty(values []string) []string {
 	return result
 }
 
-func splitHistory(history []NLPMessage, threshold, recentKeep int, assistantName string) (string, []NLPMessage) {
+// splitHistory summarizes a history longer than threshold messages, keeping the
+// recentKeep messages picked by selector verbatim.
+func splitHistory(history []NLPMessage, threshold, recentKeep int, assistantName string, selector HistorySelector) (string, []NLPMessage) {
 	cleaned := make([]NLPMessage, 0, len(history))
 	for _, msg := range history {
 		content := strings.TrimSpace(msg.Content)
@@ -399,19 +778,24 @@ func splitHistory(history []NLPMessage, threshold, recentKeep int, assistantName
 	if recentKeep <= 0 {
 		recentKeep = defaultRecentMessageKeep
 	}
-	if recentKeep >= len(cleaned) {
-		recentKeep = len(cleaned)
+	if selector == nil {
+		selector = RecentHistory{}
 	}
 
-	summaryCutoff := len(cleaned) - recentKeep
-	if summaryCutoff < 0 {
-		summaryCutoff = 0
+	kept := selector.Select(cleaned, recentKeep)
+	preserved := make([]NLPMessage, 0, len(kept))
+	summarized := make([]NLPMessage, 0, len(cleaned)-len(kept))
+	next := 0
+	for i, msg := range cleaned {
+		if next < len(kept) && kept[next] == i {
+			preserved = append(preserved, msg)
+			next++
+			continue
+		}
+		summarized = append(summarized, msg)
 	}
 
-	summary := summariseMessages(cleaned[:summaryCutoff], assistantName)
-	preserved := append([]NLPMessage(nil), cleaned[summaryCutoff:]...)
-
-	return summary, preserved
+	return summariseMessages(summarized, assistantName), preserved
 }
 
 func summariseMessages(messages []NLPMessage, assistantName string) string {
@@ -472,21 +856,41 @@ func truncateRunes(input string, max int) string {
 }
 
 type skillDirective struct {
+	// name is the display name stored with the skill; summary tells what it does, for
+	// tools choosing skills for a role.
+	name          string
+	summary       string
 	systemPrompts []string
 	userRewrite   func(string) string
+	// temperatureDelta and maxTokensDelta adjust sampling while the skill is enabled.
+	temperatureDelta float64
+	maxTokensDelta   int
+}
+
+// KnownSkill reports whether id is a skill the prompt builder implements.
+func KnownSkill(id string) bool {
+	_, ok := skillHooks[id]
+	return ok
 }
 
 var skillHooks = map[string]skillDirective{
 	"socratic_questions": {
+		name:    "苏格拉底式提问",
+		summary: "Guides the user with step-by-step clarifying questions instead of giving conclusions; suits philosophers, teachers, coaches and mentors.",
 		systemPrompts: []string{
 			"每次回复至少提出 2 个循序渐进的问题，引导对方澄清定义/例外/依据。",
 			"当该技能开启时，请采用结构化输出：先一句简短回应；随后以‘想一想：’列出 Q1、Q2（必要时 Q3）；最后一行给出下一步建议。",
 		},
 	},
 	"citation_mode": {
+		name:    "引用原典",
+		summary: "Cites sources (author, work, chapter) and flags uncertainty; suits historians, scholars, scientists and detectives.",
 		systemPrompts: []string{
 			"若引用，请给出简短来源（作者/著作名/篇章）。无法确定时不要杜撰，提示‘可能来源’并告知不确定性。",
 		},
+		// sources must not be made up, and citing them takes room
+		temperatureDelta: -0.3,
+		maxTokensDelta:   200,
 		userRewrite: func(input string) string {
 			note := "[请注明出处（作者/著作名/篇章）；不确定时提示可能来源并说明不确定性]"
 			if strings.Contains(input, note) {
@@ -499,9 +903,12 @@ var skillHooks = map[string]skillDirective{
 		},
 	},
 	"emo_stabilizer": {
+		name:    "情绪稳定器",
+		summary: "Acknowledges anxiety or frustration first, then offers one to three small actionable steps; suits counselors and supportive, warm characters.",
 		systemPrompts: []string{
 			"检测到焦虑/沮丧情绪时，先进行共情反映（用‘我听到…’/‘我理解…’），再给出 1-3 个可执行小步骤。",
 		},
+		temperatureDelta: 0.1,
 	},
 }
 
@@ -521,11 +928,101 @@ func applySkillHooks(enabledIDs []string, userInput string) ([]string, string) {
 	return filterNonEmpty(directives), modified
 }
 
+// sampling resolves the sampling parameters of req: those it leaves nil take the
+// deployment defaults (QINIU_NLP_TEMPERATURE, QINIU_NLP_MAX_TOKENS, QINIU_NLP_TOP_P), and
+// the enabled skills then adjust temperature and max tokens.
+func (s *NLPService) sampling(enabledIDs []string, req NLPRequest) NLPSampling {
+	temperature, topP := req.Temperature, req.TopP
+	var maxTokens int
+	var defaulted []string
+	if temperature == nil && s.defaultTemperature > 0 {
+		temperature = &s.defaultTemperature
+		defaulted = append(defaulted, "temperature")
+	}
+	if req.MaxTokens != nil {
+		maxTokens = *req.MaxTokens
+	} else if s.defaultMaxTokens > 0 {
+		maxTokens = s.defaultMaxTokens
+		defaulted = append(defaulted, "max_tokens")
+	}
+	if topP == nil && s.defaultTopP > 0 {
+		topP = &s.defaultTopP
+		defaulted = append(defaulted, "top_p")
+	}
+
+	sampling := skillSampling(enabledIDs, temperature, maxTokens)
+	if topP != nil {
+		value := *topP
+		sampling.TopP = &value
+	}
+	sampling.Defaulted = defaulted
+	return sampling
+}
+
+// skillSampling applies the sampling deltas of the enabled skills to the requested
+// temperature and max tokens. Deltas of several skills are summed, in skill ID order so the
+// result does not depend on the order they were enabled in, and then added and clamped
+// once: temperature to [0, 2] and max tokens to at least 1. A nil temperature, left to the
+// provider, starts from defaultSkillTemperature when a skill adjusts it; a max tokens of 0
+// stays unlimited.
+func skillSampling(enabledIDs []string, requested *float64, maxTokens int) NLPSampling {
+	ids := slices.Clone(enabledIDs)
+	slices.Sort(ids)
+	var temperatureDelta float64
+	var maxTokensDelta int
+	for _, id := range slices.Compact(ids) {
+		hook := skillHooks[id]
+		temperatureDelta += hook.temperatureDelta
+		maxTokensDelta += hook.maxTokensDelta
+	}
+
+	sampling := NLPSampling{MaxTokens: maxTokens}
+	if requested != nil || temperatureDelta != 0 {
+		temperature := defaultSkillTemperature
+		if requested != nil {
+			temperature = *requested
+		}
+		if temperatureDelta != 0 {
+			// round away float noise such as 0.7-0.3 = 0.39999999999999997
+			temperature = math.Round(min(max(temperature+temperatureDelta, 0), maxTemperature)*100) / 100
+		}
+		sampling.Temperature = &temperature
+	}
+	if maxTokensDelta != 0 && sampling.MaxTokens > 0 {
+		sampling.MaxTokens = max(sampling.MaxTokens+maxTokensDelta, 1)
+	}
+	return sampling
+}
+
 type nlpAPIRequest struct {
-	Model       string       `json:"model"`
-	Messages    []NLPMessage `json:"messages"`
-	Temperature float64      `json:"temperature,omitempty"`
-	MaxTokens   int          `json:"max_tokens,omitempty"`
+	Model          string             `json:"model"`
+	Messages       []NLPMessage       `json:"messages"`
+	Temperature    *float64           `json:"temperature,omitempty"`
+	MaxTokens      int                `json:"max_tokens,omitempty"`
+	TopP           *float64           `json:"top_p,omitempty"`
+	ResponseFormat *nlpResponseFormat `json:"response_format,omitempty"`
+	Stream         bool               `json:"stream,omitempty"`
+	StreamOptions  *nlpStreamOptions  `json:"stream_options,omitempty"`
+}
+
+// nlpResponseFormat is the OpenAI-compatible response_format; "json_object" constrains
+// the model to emit valid JSON.
+type nlpResponseFormat struct {
+	Type string `json:"type"`
+}
+
+type nlpStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// nlpStreamChunk is one server-sent event of a streamed chat completion.
+type nlpStreamChunk struct {
+	Choices []struct {
+		Delta        NLPMessage `json:"delta"`
+		FinishReason string     `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *NLPUsage      `json:"usage"`
+	Error *qiniuAPIError `json:"error,omitempty"`
 }
 
 type nlpAPIChoice struct {
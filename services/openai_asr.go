@@ -0,0 +1,300 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"go.uber.org/zap"
+)
+
+const (
+	// BreakerOpenAIASR names the breaker of the OpenAI-compatible ASR provider.
+	BreakerOpenAIASR = "openai_asr"
+	// maxTranscriptionBytes is the upload limit of OpenAI's /audio/transcriptions.
+	maxTranscriptionBytes = 25 << 20
+)
+
+// OpenAIASRProvider is an ASRProvider for OpenAI-compatible /audio/transcriptions
+// endpoints, such as OpenAI's Whisper API or a local whisper-server. These only
+// transcribe whole recordings, so a stream buffers its audio and is transcribed once
+// stopped, delivering a single final result.
+type OpenAIASRProvider struct {
+	baseURL  string
+	model    string
+	apiKey   string
+	client   httpDoer
+	download httpDoer
+	breaker  *CircuitBreaker
+	logger   *zap.SugaredLogger
+}
+
+// NewOpenAIASRProvider builds the provider from the ASR_OPENAI settings. The configured
+// key authenticates every call; the caller's Qiniu token is never forwarded.
+func NewOpenAIASRProvider(cfg *config.Config, logger *zap.SugaredLogger) *OpenAIASRProvider {
+	breaker := newQiniuBreaker(cfg, BreakerOpenAIASR)
+	return &OpenAIASRProvider{
+		baseURL:  strings.TrimRight(cfg.ASROpenAIBaseURL, "/"),
+		model:    cfg.ASROpenAIModel,
+		apiKey:   cfg.ASROpenAIAPIKey,
+		client:   breakerDoer{next: newDefaultHTTPClient(), breaker: breaker},
+		download: newDefaultHTTPClient(),
+		breaker:  breaker,
+		logger:   logger,
+	}
+}
+
+// Name implements ASRProvider.
+func (p *OpenAIASRProvider) Name() string {
+	return ASRProviderOpenAI
+}
+
+// Breaker returns the circuit breaker guarding the transcription endpoint.
+func (p *OpenAIASRProvider) Breaker() *CircuitBreaker {
+	return p.breaker
+}
+
+// Recognize implements ASRProvider. Audio given by URL is downloaded first, as the
+// endpoint only accepts uploads; raw PCM is wrapped in a WAV header.
+func (p *OpenAIASRProvider) Recognize(ctx context.Context, _ string, input ASRInput) (*ASRResult, error) {
+	format := strings.ToLower(strings.TrimSpace(input.Format))
+	data := input.Data
+	if source := strings.TrimSpace(input.URL); source != "" {
+		downloaded, err := p.fetch(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		data = downloaded
+		if format == "" {
+			if parsed, err := url.Parse(source); err == nil {
+				format = strings.TrimPrefix(strings.ToLower(path.Ext(parsed.Path)), ".")
+			}
+		}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("audio_url or audio data is required")
+	}
+
+	switch format {
+	case "pcm", "raw":
+		data = encodeWAV(pcmLayout(input.SampleRate, input.Channels, input.Bits), data)
+		format = "wav"
+	case "":
+		format = "mp3"
+	}
+	return p.transcribe(ctx, data, "audio."+format, input.Language, input.Hotwords)
+}
+
+// OpenStream implements ASRProvider.
+func (p *OpenAIASRProvider) OpenStream(ctx context.Context, _ string, opts ASRStreamOptions) (*ASRStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &ASRStream{conn: &openAIASRStream{
+		provider: p,
+		ctx:      ctx,
+		cancel:   cancel,
+		opts:     opts,
+		results:  make(chan asrStreamOutcome, 1),
+	}}, nil
+}
+
+func (p *OpenAIASRProvider) fetch(ctx context.Context, source string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create audio download: %w", err)
+	}
+	response, err := p.download.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("download audio: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, fmt.Errorf("download audio: status %d", response.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxTranscriptionBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("download audio: %w", err)
+	}
+	if len(data) > maxTranscriptionBytes {
+		return nil, fmt.Errorf("audio exceeds %d bytes", maxTranscriptionBytes)
+	}
+	return data, nil
+}
+
+// transcribe uploads audio as filename, whose extension tells the server its format.
+// Hotwords are passed as the prompt, which biases Whisper towards their spelling.
+func (p *OpenAIASRProvider) transcribe(ctx context.Context, audio []byte, filename, language string, hotwords []string) (*ASRResult, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := map[string]string{"model": p.model, "response_format": "verbose_json"}
+	if language = strings.TrimSpace(language); language != "" {
+		fields["language"] = language
+	}
+	if words := NormalizeHotwords(hotwords); len(words) > 0 {
+		fields["prompt"] = strings.Join(words, ", ")
+	}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return nil, fmt.Errorf("build transcription form: %w", err)
+		}
+	}
+	file, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("build transcription form: %w", err)
+	}
+	if _, err := file.Write(audio); err != nil {
+		return nil, fmt.Errorf("build transcription form: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("build transcription form: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/audio/transcriptions", &body)
+	if err != nil {
+		return nil, fmt.Errorf("create transcription request: %w", err)
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	if p.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	setRequestID(ctx, request.Header)
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		ctxlog.From(ctx, p.logger).Warnf("call transcription api: %v", err)
+		return nil, fmt.Errorf("call transcription api: %w", err)
+	}
+	defer response.Body.Close()
+
+	respBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read transcription response: %w", err)
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		apiErr := buildQiniuAPIError(response.StatusCode, respBody)
+		ctxlog.From(ctx, p.logger).Warnf("transcription api returned %d: %v", response.StatusCode, apiErr)
+		return nil, apiErr
+	}
+
+	var decoded struct {
+		Text     string  `json:"text"`
+		Duration float64 `json:"duration"`
+		Words    []struct {
+			Word  string  `json:"word"`
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+		} `json:"words"`
+	}
+	if err := json.Unmarshal(respBody, &decoded); err != nil {
+		return nil, fmt.Errorf("decode transcription response: %w", err)
+	}
+	result := &ASRResult{
+		ReqID:      response.Header.Get("X-Request-Id"),
+		Text:       strings.TrimSpace(decoded.Text),
+		DurationMS: int(math.Round(decoded.Duration * 1000)),
+		Raw:        json.RawMessage(respBody),
+	}
+	for _, word := range decoded.Words {
+		result.Words = append(result.Words, ASRWord{
+			Text:    strings.TrimSpace(word.Word),
+			StartMS: int(math.Round(word.Start * 1000)),
+			EndMS:   int(math.Round(word.End * 1000)),
+		})
+	}
+	return result, nil
+}
+
+func pcmLayout(sampleRate, channels, bits int) wavFormat {
+	if sampleRate <= 0 {
+		sampleRate = 16000
+	}
+	if channels <= 0 {
+		channels = 1
+	}
+	if bits <= 0 {
+		bits = 16
+	}
+	return wavFormat{SampleRate: sampleRate, Channels: channels, Bits: bits}
+}
+
+type asrStreamOutcome struct {
+	result *ASRResult
+	err    error
+}
+
+// openAIASRStream buffers the PCM of an utterance and transcribes it on SendStop.
+type openAIASRStream struct {
+	provider *OpenAIASRProvider
+	ctx      context.Context
+	cancel   context.CancelFunc
+	opts     ASRStreamOptions
+
+	mu      sync.Mutex
+	pcm     bytes.Buffer
+	stopped bool
+	// results delivers the transcription once and is then closed.
+	results chan asrStreamOutcome
+}
+
+func (s *openAIASRStream) SendAudio(chunk []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return errASRStreamStopped
+	}
+	if s.pcm.Len()+len(chunk) > maxTranscriptionBytes {
+		return fmt.Errorf("utterance exceeds %d bytes", maxTranscriptionBytes)
+	}
+	s.pcm.Write(chunk)
+	return nil
+}
+
+func (s *openAIASRStream) SendStop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+	audio := encodeWAV(pcmLayout(s.opts.SampleRate, s.opts.Channels, s.opts.Bits), s.pcm.Bytes())
+	go func() {
+		defer close(s.results)
+		result, err := s.provider.transcribe(s.ctx, audio, "audio.wav", s.opts.Language, s.opts.Hotwords)
+		s.results <- asrStreamOutcome{result: result, err: err}
+	}()
+	return nil
+}
+
+func (s *openAIASRStream) Recv() (*ASRStreamEvent, error) {
+	select {
+	case outcome, ok := <-s.results:
+		if !ok {
+			return nil, io.EOF
+		}
+		if outcome.err != nil {
+			return nil, outcome.err
+		}
+		result := outcome.result
+		return &ASRStreamEvent{
+			Transcript: Transcript{Text: result.Text, IsFinal: true, DurationMS: result.DurationMS, Words: result.Words},
+			Raw:        result.Raw,
+		}, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *openAIASRStream) Close() error {
+	s.cancel()
+	return nil
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"go.uber.org/zap"
+)
+
+const (
+	// BreakerOpenAITTS names the breaker of the OpenAI-compatible TTS provider.
+	BreakerOpenAITTS = "openai_tts"
+	// maxSpeechInputChars is the input limit of OpenAI's /audio/speech.
+	maxSpeechInputChars = 4096
+	// openAIPCMSampleRate is the layout of the raw pcm format of /audio/speech: 24kHz,
+	// 16-bit mono.
+	openAIPCMSampleRate = 24000
+)
+
+// OpenAITTSProvider is a TTSProvider for OpenAI-compatible /audio/speech endpoints, such
+// as OpenAI's own API or an Edge TTS bridge. These offer no voice list, so the voices
+// are the configured TTS_OPENAI_VOICES.
+type OpenAITTSProvider struct {
+	baseURL       string
+	model         string
+	apiKey        string
+	defaultVoice  string
+	defaultFormat string
+	voices        []string
+	maxChars      int
+	client        httpDoer
+	streamClient  httpDoer
+	breaker       *CircuitBreaker
+	logger        *zap.SugaredLogger
+}
+
+// NewOpenAITTSProvider builds the provider from the TTS_OPENAI settings. The configured
+// key authenticates every call; the caller's Qiniu token is never forwarded.
+func NewOpenAITTSProvider(cfg *config.Config, logger *zap.SugaredLogger) *OpenAITTSProvider {
+	maxChars := cfg.TTSMaxChars
+	if maxChars <= 0 || maxChars > maxSpeechInputChars {
+		maxChars = maxSpeechInputChars
+	}
+	format := strings.TrimSpace(cfg.QiniuTTSFormat)
+	if format == "" {
+		format = "mp3"
+	}
+	breaker := newQiniuBreaker(cfg, BreakerOpenAITTS)
+	return &OpenAITTSProvider{
+		baseURL:       strings.TrimRight(cfg.TTSOpenAIBaseURL, "/"),
+		model:         cfg.TTSOpenAIModel,
+		apiKey:        cfg.TTSOpenAIAPIKey,
+		defaultVoice:  cfg.TTSOpenAIVoice,
+		defaultFormat: format,
+		voices:        cfg.TTSOpenAIVoices,
+		maxChars:      maxChars,
+		client:        breakerDoer{next: newHTTPClientWithTimeout(60 * time.Second), breaker: breaker},
+		streamClient:  breakerDoer{next: newStreamingHTTPClient(), breaker: breaker},
+		breaker:       breaker,
+		logger:        logger,
+	}
+}
+
+// Name implements TTSProvider.
+func (p *OpenAITTSProvider) Name() string {
+	return TTSProviderOpenAI
+}
+
+// Breaker returns the circuit breaker guarding the speech endpoint.
+func (p *OpenAITTSProvider) Breaker() *CircuitBreaker {
+	return p.breaker
+}
+
+// ListVoices implements TTSProvider with the configured voices.
+func (p *OpenAITTSProvider) ListVoices(context.Context, string) ([]VoiceInfo, error) {
+	voices := make([]VoiceInfo, 0, len(p.voices))
+	for _, voice := range p.voices {
+		voices = append(voices, VoiceInfo{VoiceName: voice, VoiceType: voice, Category: TTSProviderOpenAI})
+	}
+	return voices, nil
+}
+
+// openAISpeechRequest is the body of /audio/speech.
+type openAISpeechRequest struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format,omitempty"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
+// prepare validates req and returns the speech request for it, without input, the text
+// split to fit the input limit and the encoding reported to the caller. Pitch, volume and
+// emotion have no equivalent and are ignored.
+func (p *OpenAITTSProvider) prepare(req TTSRequest) (openAISpeechRequest, []string, string, error) {
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		return openAISpeechRequest{}, nil, "", fmt.Errorf("tts text cannot be empty")
+	}
+	if err := req.Validate(); err != nil {
+		return openAISpeechRequest{}, nil, "", err
+	}
+
+	voice := strings.TrimSpace(req.VoiceType)
+	if voice == "" {
+		voice = p.defaultVoice
+	}
+	encoding := strings.ToLower(strings.TrimSpace(req.Encoding))
+	if encoding == "" {
+		encoding = p.defaultFormat
+	}
+	speech := openAISpeechRequest{
+		Model:          p.model,
+		Voice:          voice,
+		ResponseFormat: speechFormat(encoding),
+		Speed:          req.SpeedRatio,
+	}
+	return speech, ChunkText(text, p.maxChars), encoding, nil
+}
+
+// speechFormat maps a TTS encoding to the response_format of /audio/speech, whose opus
+// output is Ogg-contained.
+func speechFormat(encoding string) string {
+	switch encoding {
+	case "ogg", "ogg_opus":
+		return "opus"
+	case "mpeg":
+		return "mp3"
+	default:
+		return encoding
+	}
+}
+
+// Synthesize implements TTSProvider. Text over the input limit is synthesized chunk by
+// chunk and joined like Qiniu's.
+func (p *OpenAITTSProvider) Synthesize(ctx context.Context, _ string, req TTSRequest) (*TTSResult, error) {
+	speech, chunks, encoding, err := p.prepare(req)
+	if err != nil {
+		return nil, err
+	}
+
+	synthesize := func(ctx context.Context, text string) (*TTSResult, error) {
+		speech := speech
+		speech.Input = text
+		return p.synthesizeChunk(ctx, speech)
+	}
+	var result *TTSResult
+	if len(chunks) == 1 {
+		result, err = synthesize(ctx, chunks[0])
+	} else {
+		result, err = synthesizeChunks(ctx, chunks, encoding, synthesize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Encoding = encoding
+	return result, nil
+}
+
+// SynthesizeStream implements TTSStreamer, passing the audio on as the endpoint sends it.
+// Chunked text is streamed call after call, which only yields valid audio for formats
+// that concatenate, so WAV is refused for text over the input limit.
+func (p *OpenAITTSProvider) SynthesizeStream(ctx context.Context, _ string, req TTSRequest, onAudio func(chunk []byte) error) (*TTSResult, error) {
+	speech, chunks, encoding, err := p.prepare(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) > 1 && speech.ResponseFormat == "wav" {
+		return nil, apierr.New(apierr.CodeInvalidRequest, "wav cannot be streamed for text this long")
+	}
+
+	reqIDs := make([]string, 0, len(chunks))
+	buf := make([]byte, 32<<10)
+	for i, chunk := range chunks {
+		speech.Input = chunk
+		response, err := p.call(ctx, p.streamClient, speech)
+		if err != nil {
+			return nil, fmt.Errorf("synthesize chunk %d/%d: %w", i+1, len(chunks), err)
+		}
+		reqIDs = append(reqIDs, response.Header.Get("X-Request-Id"))
+		err = copyAudio(response.Body, buf, onAudio)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &TTSResult{
+		ReqID:    strings.Join(reqIDs, ","),
+		Raw:      p.raw(speech),
+		Encoding: encoding,
+	}, nil
+}
+
+func copyAudio(body io.Reader, buf []byte, onAudio func(chunk []byte) error) error {
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if cbErr := onAudio(buf[:n]); cbErr != nil {
+				return cbErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read speech stream: %w", err)
+		}
+	}
+}
+
+func (p *OpenAITTSProvider) synthesizeChunk(ctx context.Context, speech openAISpeechRequest) (*TTSResult, error) {
+	response, err := p.call(ctx, p.client, speech)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	audio, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read speech response: %w", err)
+	}
+	if len(audio) == 0 {
+		return nil, fmt.Errorf("speech response contained no audio data")
+	}
+	return &TTSResult{
+		ReqID:    response.Header.Get("X-Request-Id"),
+		Audio:    audio,
+		Duration: speechDuration(speech.ResponseFormat, audio),
+		Raw:      p.raw(speech),
+	}, nil
+}
+
+// call posts speech and returns the successful response, whose body the caller closes.
+func (p *OpenAITTSProvider) call(ctx context.Context, client httpDoer, speech openAISpeechRequest) (*http.Response, error) {
+	body, err := json.Marshal(speech)
+	if err != nil {
+		return nil, fmt.Errorf("marshal speech payload: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create speech request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	setRequestID(ctx, request.Header)
+
+	response, err := client.Do(request)
+	if err != nil {
+		ctxlog.From(ctx, p.logger).Warnf("call speech api: %v", err)
+		return nil, fmt.Errorf("call speech api: %w", err)
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		defer response.Body.Close()
+		respBody, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+		apiErr := buildQiniuAPIError(response.StatusCode, respBody)
+		ctxlog.From(ctx, p.logger).Warnf("speech api returned %d: %v", response.StatusCode, apiErr)
+		return nil, apiErr
+	}
+	return response, nil
+}
+
+// raw describes the call in place of the JSON envelope Qiniu returns, as the endpoint
+// answers with bare audio.
+func (p *OpenAITTSProvider) raw(speech openAISpeechRequest) json.RawMessage {
+	raw, _ := json.Marshal(map[string]string{
+		"provider":        TTSProviderOpenAI,
+		"model":           speech.Model,
+		"voice":           speech.Voice,
+		"response_format": speech.ResponseFormat,
+	})
+	return raw
+}
+
+// speechDuration returns the length in milliseconds of WAV and PCM audio, which is not
+// reported by the endpoint, and "" for compressed formats.
+func speechDuration(format string, audio []byte) string {
+	layout := wavFormat{SampleRate: openAIPCMSampleRate, Channels: 1, Bits: 16}
+	pcm := audio
+	switch format {
+	case "pcm":
+	case "wav":
+		var err error
+		if layout, pcm, err = parseWAV(audio); err != nil {
+			return ""
+		}
+	default:
+		return ""
+	}
+	bytesPerSecond := layout.SampleRate * layout.Channels * layout.Bits / 8
+	if bytesPerSecond <= 0 {
+		return ""
+	}
+	return strconv.Itoa(len(pcm) * 1000 / bytesPerSecond)
+}
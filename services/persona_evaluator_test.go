@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// stubReplier answers every probe through reply and records the requests.
+type stubReplier struct {
+	mu       sync.Mutex
+	requests []NLPRequest
+	reply    func(ctx context.Context, req NLPRequest) (string, error)
+}
+
+func (s *stubReplier) GenerateReply(ctx context.Context, token string, req NLPRequest) (*NLPResponse, error) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+	content, err := s.reply(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &NLPResponse{Reply: NLPMessage{Role: "assistant", Content: content}}, nil
+}
+
+// stubJudge answers every judgement through verdict, given the user message of the judge
+// prompt.
+type stubJudge struct {
+	verdict func(ctx context.Context, sample string) (string, error)
+}
+
+func (s stubJudge) Complete(ctx context.Context, token string, messages []NLPMessage) (string, error) {
+	return s.verdict(ctx, messages[len(messages)-1].Content)
+}
+
+var evaluatedRole = models.Role{ID: 7, Name: "Test Sage", Domain: "testing", Bio: "A patient sage.", Languages: []string{"en"}}
+
+func TestPersonaEvaluatorScores(t *testing.T) {
+	replier := &stubReplier{reply: func(_ context.Context, req NLPRequest) (string, error) {
+		return "As the sage, I say: " + req.UserMessage, nil
+	}}
+	judge := stubJudge{verdict: func(_ context.Context, sample string) (string, error) {
+		if !strings.Contains(sample, "As the sage, I say") || !strings.Contains(sample, `"name":"Test Sage"`) {
+			t.Errorf("judge sample lacks the reply or the role: %s", sample)
+		}
+		if strings.Contains(sample, `"probe_kind":"off_topic"`) {
+			// the judge marks the off-topic probe down and overshoots the scale
+			return `{"persona_consistency": 2, "constraint_adherence": 0, "language_correctness": 9}`, nil
+		}
+		return "```json\n" + `{"persona_consistency": 4, "constraint_adherence": 5, "language_correctness": 5, "comment": "fine"}` + "\n```", nil
+	}}
+	evaluator := NewPersonaEvaluator(replier, judge, 2, time.Minute)
+
+	evaluation := evaluator.Evaluate(context.Background(), "token", evaluatedRole, "en", DefaultPersonaProbes("en"))
+
+	if evaluation.RoleID != evaluatedRole.ID || evaluation.Language != "en" || evaluation.TimedOut {
+		t.Fatalf("evaluation of role %d in %q (timed out %t)", evaluation.RoleID, evaluation.Language, evaluation.TimedOut)
+	}
+	if evaluation.Scored != 4 || len(evaluation.Probes) != 4 {
+		t.Fatalf("scored %d of %d probes, want 4 of 4", evaluation.Scored, len(evaluation.Probes))
+	}
+	for i, result := range evaluation.Probes {
+		if result.Probe != DefaultPersonaProbes("en")[i] {
+			t.Errorf("probe %d is %+v, want the battery order kept", i, result.Probe)
+		}
+		if result.Error != "" || result.Scores == nil || result.Reply != "As the sage, I say: "+result.Probe.Prompt {
+			t.Errorf("probe %s: error %q, scores %v, reply %q", result.Probe.ID, result.Error, result.Scores, result.Reply)
+		}
+	}
+	offTopic := evaluation.Probes[3]
+	if want := (PersonaScores{PersonaConsistency: 2, ConstraintAdherence: 1, LanguageCorrectness: 5}); *offTopic.Scores != want {
+		t.Errorf("off-topic scores %+v, want %+v clamped", *offTopic.Scores, want)
+	}
+	if evaluation.Probes[0].Comment != "fine" {
+		t.Errorf("comment %q, want the judge's", evaluation.Probes[0].Comment)
+	}
+	want := PersonaScores{PersonaConsistency: 3.5, ConstraintAdherence: 4, LanguageCorrectness: 5}
+	if evaluation.Scores == nil || *evaluation.Scores != want {
+		t.Errorf("averages %v, want %+v", evaluation.Scores, want)
+	}
+
+	if len(replier.requests) != 4 {
+		t.Fatalf("replier got %d requests, want 4", len(replier.requests))
+	}
+	for _, req := range replier.requests {
+		if req.Role.ID != evaluatedRole.ID || req.Language != "en" || !req.DisableAutoSkills {
+			t.Errorf("reply request for role %d in %q with auto skills disabled %t", req.Role.ID, req.Language, req.DisableAutoSkills)
+		}
+	}
+}
+
+func TestPersonaEvaluatorFailures(t *testing.T) {
+	probes := []PersonaProbe{
+		{ID: "ok", Kind: "custom", Prompt: "fine"},
+		{ID: "reply", Kind: "custom", Prompt: "reply fails"},
+		{ID: "judge", Kind: "custom", Prompt: "judge fails"},
+		{ID: "verdict", Kind: "custom", Prompt: "verdict unreadable"},
+	}
+	replier := &stubReplier{reply: func(_ context.Context, req NLPRequest) (string, error) {
+		if req.UserMessage == "reply fails" {
+			return "", errors.New("upstream down")
+		}
+		return "reply to " + req.UserMessage, nil
+	}}
+	judge := stubJudge{verdict: func(_ context.Context, sample string) (string, error) {
+		switch {
+		case strings.Contains(sample, "judge fails"):
+			return "", errors.New("judge down")
+		case strings.Contains(sample, "verdict unreadable"):
+			return "I would rather not grade this.", nil
+		}
+		return `{"persona_consistency": 5, "constraint_adherence": 5, "language_correctness": 5}`, nil
+	}}
+	evaluation := NewPersonaEvaluator(replier, judge, 4, time.Minute).Evaluate(context.Background(), "token", evaluatedRole, "en", probes)
+
+	cases := []struct {
+		id        string
+		error     string
+		reply     bool
+		hasScores bool
+	}{
+		{"ok", "", true, true},
+		{"reply", "reply failed: upstream down", false, false},
+		{"judge", "judge failed: judge down", true, false},
+		{"verdict", "judge failed:", true, false},
+	}
+	for i, tc := range cases {
+		result := evaluation.Probes[i]
+		if result.Probe.ID != tc.id {
+			t.Fatalf("probe %d is %s, want %s", i, result.Probe.ID, tc.id)
+		}
+		if tc.error == "" && result.Error != "" || !strings.HasPrefix(result.Error, tc.error) {
+			t.Errorf("probe %s error %q, want %q", tc.id, result.Error, tc.error)
+		}
+		if (result.Reply != "") != tc.reply || (result.Scores != nil) != tc.hasScores {
+			t.Errorf("probe %s kept reply %q and scores %v", tc.id, result.Reply, result.Scores)
+		}
+	}
+	if evaluation.Scored != 1 || evaluation.Scores == nil || evaluation.Scores.PersonaConsistency != 5 {
+		t.Errorf("summary scored %d with averages %v, want only the ok probe", evaluation.Scored, evaluation.Scores)
+	}
+
+	replier.reply = func(context.Context, NLPRequest) (string, error) { return "", errors.New("upstream down") }
+	evaluation = NewPersonaEvaluator(replier, judge, 4, time.Minute).Evaluate(context.Background(), "token", evaluatedRole, "en", probes)
+	if evaluation.Scored != 0 || evaluation.Scores != nil {
+		t.Errorf("summary scored %d with averages %v, want none", evaluation.Scored, evaluation.Scores)
+	}
+}
+
+func TestPersonaEvaluatorTimeout(t *testing.T) {
+	replier := &stubReplier{reply: func(ctx context.Context, _ NLPRequest) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}}
+	judge := stubJudge{verdict: func(context.Context, string) (string, error) {
+		t.Error("judge called for a reply that never came")
+		return "", nil
+	}}
+	evaluation := NewPersonaEvaluator(replier, judge, 1, 20*time.Millisecond).Evaluate(context.Background(), "token", evaluatedRole, "en", DefaultPersonaProbes("en"))
+
+	if !evaluation.TimedOut || evaluation.Scored != 0 || evaluation.Scores != nil {
+		t.Fatalf("timed out %t, scored %d with averages %v", evaluation.TimedOut, evaluation.Scored, evaluation.Scores)
+	}
+	for _, result := range evaluation.Probes {
+		if !strings.Contains(result.Error, context.DeadlineExceeded.Error()) {
+			t.Errorf("probe %s error %q, want the deadline", result.Probe.ID, result.Error)
+		}
+	}
+}
+
+func TestParsePersonaVerdict(t *testing.T) {
+	cases := []struct {
+		name    string
+		answer  string
+		want    PersonaScores
+		comment string
+		wantErr bool
+	}{
+		{name: "bare json", answer: `{"persona_consistency": 3, "constraint_adherence": 4, "language_correctness": 5, "comment": "ok"}`, want: PersonaScores{3, 4, 5}, comment: "ok"},
+		{name: "fenced", answer: "Here you go:\n```json\n{\"persona_consistency\": 2.5, \"constraint_adherence\": 1, \"language_correctness\": 4}\n```", want: PersonaScores{2.5, 1, 4}},
+		{name: "clamped", answer: `{"persona_consistency": -3, "constraint_adherence": 0, "language_correctness": 12}`, want: PersonaScores{1, 1, 5}},
+		{name: "prose only", answer: "I would rather not grade this.", wantErr: true},
+		{name: "array", answer: `[1, 2, 3]`, wantErr: true},
+		{name: "missing score", answer: `{"persona_consistency": 3, "constraint_adherence": 4}`, wantErr: true},
+		{name: "score not a number", answer: `{"persona_consistency": "high", "constraint_adherence": 4, "language_correctness": 5}`, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			verdict, err := ParsePersonaVerdict(tc.answer)
+			if tc.wantErr {
+				if apierr.CodeOf(err, "") != apierr.CodeUpstream {
+					t.Fatalf("err = %v, want an UPSTREAM_ERROR", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePersonaVerdict: %v", err)
+			}
+			if verdict.Scores != tc.want || verdict.Comment != tc.comment {
+				t.Errorf("verdict %+v %q, want %+v %q", verdict.Scores, verdict.Comment, tc.want, tc.comment)
+			}
+		})
+	}
+}
+
+func TestValidatePersonaProbes(t *testing.T) {
+	probes, err := ValidatePersonaProbes([]PersonaProbe{
+		{Prompt: "  Tell me a riddle.  "},
+		{ID: "mine", Kind: "greeting", Prompt: "Hello"},
+	}, 3)
+	if err != nil {
+		t.Fatalf("ValidatePersonaProbes: %v", err)
+	}
+	want := []PersonaProbe{
+		{ID: "probe_1", Kind: "custom", Prompt: "Tell me a riddle."},
+		{ID: "mine", Kind: "greeting", Prompt: "Hello"},
+	}
+	for i := range want {
+		if probes[i] != want[i] {
+			t.Errorf("probe %d = %+v, want %+v", i, probes[i], want[i])
+		}
+	}
+
+	if _, err := ValidatePersonaProbes([]PersonaProbe{{Prompt: "a"}, {Prompt: "b"}}, 1); apierr.CodeOf(err, "") != apierr.CodeInvalidRequest {
+		t.Errorf("too many probes: err = %v, want INVALID_REQUEST", err)
+	}
+	if _, err := ValidatePersonaProbes([]PersonaProbe{{ID: "blank", Prompt: "   "}}, 3); apierr.CodeOf(err, "") != apierr.CodeInvalidRequest {
+		t.Errorf("blank prompt: err = %v, want INVALID_REQUEST", err)
+	}
+}
+
+func TestDefaultPersonaProbes(t *testing.T) {
+	if probes := DefaultPersonaProbes("zh-CN"); len(probes) != 4 || probes[0].Prompt != defaultPersonaProbes["zh"][0].Prompt {
+		t.Errorf("zh-CN battery %+v, want the Chinese one", probes)
+	}
+	if probes := DefaultPersonaProbes("fr"); len(probes) != 4 || probes[0].Prompt != defaultPersonaProbes["en"][0].Prompt {
+		t.Errorf("fr battery %+v, want the English fallback", probes)
+	}
+	probes := DefaultPersonaProbes("en")
+	probes[0].Prompt = "changed"
+	if defaultPersonaProbes["en"][0].Prompt == "changed" {
+		t.Error("DefaultPersonaProbes shares its battery with callers")
+	}
+}
@@ -1,11 +1,15 @@
 package services
 
 import (
+    "context"
     "encoding/json"
     "fmt"
     "net/http"
     "strings"
     "time"
+
+    "github.com/wuwenbin0122/wwb.ai/apierr"
+    "github.com/wuwenbin0122/wwb.ai/ctxlog"
 )
 
 const qiniuHTTPTimeout = 20 * time.Second
@@ -19,8 +23,59 @@ type qiniuAPIError struct {
 	Message string `json:"message,omitempty"`
 }
 
+// UnmarshalJSON accepts the error shapes of OpenAI-compatible backends besides Qiniu's
+// {"code","message"}: a numeric or null code, a type standing in for a missing code, as
+// OpenAI sends, and a bare message string.
+func (e *qiniuAPIError) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		*e = qiniuAPIError{Message: message}
+		return nil
+	}
+	var raw struct {
+		Code    json.RawMessage `json:"code"`
+		Type    string          `json:"type"`
+		Message string          `json:"message"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*e = qiniuAPIError{Code: rawErrorCode(raw.Code), Message: raw.Message}
+	if e.Code == "" {
+		e.Code = raw.Type
+	}
+	return nil
+}
+
+func rawErrorCode(raw json.RawMessage) string {
+	var code string
+	if err := json.Unmarshal(raw, &code); err == nil {
+		return code
+	}
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return number.String()
+	}
+	return ""
+}
+
+// qiniuErrorEnvelope is an error response: {"error": {...}} from Qiniu and OpenAI, or the
+// flat {"object": "error", "message", "type", "code"} of vLLM and {"detail": "..."} of
+// FastAPI-based servers.
 type qiniuErrorEnvelope struct {
-	Error *qiniuAPIError `json:"error,omitempty"`
+	Error   *qiniuAPIError  `json:"error,omitempty"`
+	Code    json.RawMessage `json:"code,omitempty"`
+	Type    string          `json:"type,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// setRequestID forwards the request ID of ctx upstream so a failed call can be matched
+// with Qiniu's logs.
+func setRequestID(ctx context.Context, header http.Header) {
+	if id := ctxlog.RequestID(ctx); id != "" {
+		header.Set(ctxlog.Header, id)
+	}
 }
 
 func newDefaultHTTPClient() *http.Client {
@@ -36,6 +91,15 @@ func newHTTPClientWithTimeout(d time.Duration) *http.Client {
     return &http.Client{Timeout: d}
 }
 
+// newStreamingHTTPClient builds a client for streamed responses, which may legitimately
+// take longer than qiniuHTTPTimeout to finish. Only the wait for the response headers is
+// bounded; the caller's context bounds the rest.
+func newStreamingHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = qiniuHTTPTimeout
+	return &http.Client{Transport: transport}
+}
+
 func decodeQiniuError(body []byte) *qiniuAPIError {
 	if len(body) == 0 {
 		return nil
@@ -47,24 +111,59 @@ func decodeQiniuError(body []byte) *qiniuAPIError {
 	}
 
 	if envelope.Error == nil {
-		return nil
+		flat := qiniuAPIError{Code: rawErrorCode(envelope.Code), Message: envelope.Message}
+		if flat.Code == "" {
+			flat.Code = envelope.Type
+		}
+		if flat.Message == "" && len(envelope.Detail) > 0 {
+			var detail string
+			if json.Unmarshal(envelope.Detail, &detail) != nil {
+				detail = string(envelope.Detail)
+			}
+			flat.Message = detail
+		}
+		if flat.Code == "" && flat.Message == "" {
+			return nil
+		}
+		envelope.Error = &flat
 	}
 
 	envelope.Error.Message = strings.TrimSpace(envelope.Error.Message)
 	return envelope.Error
 }
 
+// UpstreamError is a non-2xx response from a Qiniu API.
+type UpstreamError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *UpstreamError) Error() string {
+	switch {
+	case e.Code != "" && e.Message != "":
+		return fmt.Sprintf("qiniu api error (%d, %s): %s", e.StatusCode, e.Code, e.Message)
+	case e.Code != "":
+		return fmt.Sprintf("qiniu api error (%d, %s)", e.StatusCode, e.Code)
+	default:
+		return fmt.Sprintf("qiniu api error (%d): %s", e.StatusCode, e.Message)
+	}
+}
+
+// Temporary reports whether the same request may succeed later (rate limits and server errors).
+func (e *UpstreamError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// buildQiniuAPIError returns an *UpstreamError classified for API clients: a rejected
+// credential is the caller's Qiniu token, timeouts and rate limits keep their meaning and
+// anything else is a generic upstream failure.
 func buildQiniuAPIError(statusCode int, body []byte) error {
-	if apiErr := decodeQiniuError(body); apiErr != nil {
-		if apiErr.Code != "" && apiErr.Message != "" {
-			return fmt.Errorf("qiniu api error (%d, %s): %s", statusCode, apiErr.Code, apiErr.Message)
-		}
-		if apiErr.Message != "" {
-			return fmt.Errorf("qiniu api error (%d): %s", statusCode, apiErr.Message)
-		}
-		if apiErr.Code != "" {
-			return fmt.Errorf("qiniu api error (%d, %s)", statusCode, apiErr.Code)
-		}
+	apiErr := &UpstreamError{StatusCode: statusCode}
+	if decoded := decodeQiniuError(body); decoded != nil && (decoded.Code != "" || decoded.Message != "") {
+		apiErr.Code = decoded.Code
+		apiErr.Message = decoded.Message
+		return classifyUpstreamError(apiErr)
 	}
 
 	snippet := strings.TrimSpace(string(body))
@@ -74,6 +173,22 @@ func buildQiniuAPIError(statusCode int, body []byte) error {
 	if len(snippet) > 256 {
 		snippet = snippet[:256]
 	}
+	apiErr.Message = snippet
+
+	return classifyUpstreamError(apiErr)
+}
 
-	return fmt.Errorf("qiniu api error (%d): %s", statusCode, snippet)
+func classifyUpstreamError(err *UpstreamError) error {
+	switch err.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return apierr.Wrap(err, apierr.CodeTokenInvalid, "qiniu rejected the token")
+	case http.StatusTooManyRequests:
+		return apierr.Wrap(err, apierr.CodeUpstreamRateLimited, "qiniu rate limit exceeded")
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return apierr.Wrap(err, apierr.CodeUpstreamTimeout, "qiniu timed out")
+	case http.StatusRequestEntityTooLarge:
+		return apierr.Wrap(err, apierr.CodePayloadTooLarge, "request too large for qiniu")
+	default:
+		return apierr.Wrap(err, apierr.CodeUpstream, "qiniu request failed")
+	}
 }
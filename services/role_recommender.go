@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// Embedder turns texts into vectors whose cosine similarity reflects how related the
+// texts are. Vectors of one Embedder are comparable with each other only.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// BagOfWordsEmbedder is an Embedder without a model: Tokenize's terms are hashed into a
+// fixed number of dimensions and counted. It only sees shared words, but needs no upstream.
+type BagOfWordsEmbedder struct {
+	Dims int // defaults to 1024
+}
+
+// Embed implements Embedder with L2-normalized term counts.
+func (e BagOfWordsEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	dims := e.Dims
+	if dims <= 0 {
+		dims = 1024
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, dims)
+		for _, term := range Tokenize(text) {
+			hash := fnv.New32a()
+			hash.Write([]byte(term))
+			vector[hash.Sum32()%uint32(dims)]++
+		}
+		normalize(vector)
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func normalize(vector []float32) {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range vector {
+		vector[i] /= norm
+	}
+}
+
+// cosine returns the cosine similarity of a and b, 0 when either is zero or their
+// lengths differ.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// englishStopwords are dropped by Tokenize as they match nearly every role.
+var englishStopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "to": true, "of": true, "in": true,
+	"on": true, "for": true, "with": true, "i": true, "want": true, "my": true, "me": true,
+	"is": true, "are": true, "be": true, "about": true, "like": true, "practice": true,
+}
+
+// Tokenize splits text into matching terms: lowercase Latin words and numbers, singular
+// and minus common English stopwords, and the character bigrams of Chinese runs (a lone character
+// stays a unigram), since Chinese has no spaces to split words on.
+func Tokenize(text string) []string {
+	var terms []string
+	var word []rune
+	var han []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			term := string(word)
+			// a crude plural stem, so "interviews" matches "interview"
+			if len(term) > 3 && strings.HasSuffix(term, "s") && !strings.HasSuffix(term, "ss") {
+				term = term[:len(term)-1]
+			}
+			if !englishStopwords[term] {
+				terms = append(terms, term)
+			}
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		switch len(han) {
+		case 0:
+		case 1:
+			terms = append(terms, string(han))
+		default:
+			for i := 0; i+1 < len(han); i++ {
+				terms = append(terms, string(han[i:i+2]))
+			}
+		}
+		han = han[:0]
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return terms
+}
+
+// Fields matched by RoleRecommender, with the weight of a term found in each. A query
+// term counts once, for the heaviest field it appears in.
+var recommendFields = []struct {
+	name   string
+	weight float64
+	text   func(models.Role) string
+}{
+	{"name", 3, func(r models.Role) string { return r.Name }},
+	{"tags", 3, func(r models.Role) string { return strings.Join(r.Tags, " ") }},
+	{"domain", 2, func(r models.Role) string { return r.Domain }},
+	{"bio", 1, func(r models.Role) string { return r.Bio }},
+}
+
+// recommendMaxWeight is the heaviest field weight, which a query term matching the name
+// or tags earns.
+const recommendMaxWeight = 3
+
+// Weights of the two scores in the hybrid score when an Embedder is set.
+const (
+	keywordScoreWeight    = 0.6
+	similarityScoreWeight = 0.4
+)
+
+// MatchReason explains part of a recommendation: the query terms found in a role field,
+// or, for the "similarity" field, the embedding similarity.
+type MatchReason struct {
+	Field string   `json:"field"`
+	Terms []string `json:"terms,omitempty"`
+	Score float64  `json:"score,omitempty"`
+}
+
+// RoleRecommendation is a role matched to an interest, with its score in [0, 1].
+type RoleRecommendation struct {
+	Role    models.Role   `json:"role"`
+	Score   float64       `json:"score"`
+	Reasons []MatchReason `json:"reasons"`
+}
+
+// RoleRecommender ranks roles against a free-text interest by keyword overlap with their
+// name, tags, domain and bio, blended with embedding similarity when it has an Embedder.
+type RoleRecommender struct {
+	embedder Embedder
+}
+
+// NewRoleRecommender builds a recommender; embedder may be nil for keyword scoring only.
+func NewRoleRecommender(embedder Embedder) *RoleRecommender {
+	return &RoleRecommender{embedder: embedder}
+}
+
+// Recommend scores roles against interest and returns the best limit of them, best first.
+// Roles sharing nothing with the interest are left out.
+func (r *RoleRecommender) Recommend(ctx context.Context, interest string, roles []models.Role, limit int) ([]RoleRecommendation, error) {
+	if len(roles) == 0 {
+		return []RoleRecommendation{}, nil
+	}
+	indexes := make([]roleTerms, len(roles))
+	vocabulary := make(map[string]bool)
+	for i, role := range roles {
+		indexes[i] = indexRole(role)
+		for _, terms := range indexes[i] {
+			for term := range terms {
+				vocabulary[term] = true
+			}
+		}
+	}
+	// terms no role knows, such as the bigram across two Chinese words, count against none
+	var queryTerms []string
+	for _, term := range uniqueTerms(Tokenize(interest)) {
+		if vocabulary[term] {
+			queryTerms = append(queryTerms, term)
+		}
+	}
+	if len(queryTerms) == 0 {
+		return []RoleRecommendation{}, nil
+	}
+
+	var similarities []float64
+	if r.embedder != nil {
+		texts := make([]string, 0, len(roles)+1)
+		texts = append(texts, interest)
+		for _, role := range roles {
+			texts = append(texts, roleEmbeddingText(role))
+		}
+		vectors, err := r.embedder.Embed(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("embed roles: %w", err)
+		}
+		if len(vectors) != len(texts) {
+			return nil, fmt.Errorf("embed roles: got %d vectors for %d texts", len(vectors), len(texts))
+		}
+		similarities = make([]float64, len(roles))
+		for i := range roles {
+			similarities[i] = math.Max(0, cosine(vectors[0], vectors[i+1]))
+		}
+	}
+
+	recommendations := make([]RoleRecommendation, 0, len(roles))
+	for i, role := range roles {
+		keyword, reasons := keywordScore(queryTerms, indexes[i])
+		score := keyword
+		if similarities != nil {
+			score = keywordScoreWeight*keyword + similarityScoreWeight*similarities[i]
+			if similarities[i] > 0 {
+				reasons = append(reasons, MatchReason{Field: "similarity", Score: round3(similarities[i])})
+			}
+		}
+		if score <= 0 {
+			continue
+		}
+		recommendations = append(recommendations, RoleRecommendation{Role: role, Score: round3(score), Reasons: reasons})
+	}
+
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		return recommendations[i].Score > recommendations[j].Score
+	})
+	if limit > 0 && len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+	return recommendations, nil
+}
+
+// roleTerms holds the terms of each of recommendFields of a role.
+type roleTerms []map[string]bool
+
+func indexRole(role models.Role) roleTerms {
+	index := make(roleTerms, len(recommendFields))
+	for i, field := range recommendFields {
+		index[i] = make(map[string]bool)
+		for _, term := range Tokenize(field.text(role)) {
+			index[i][term] = true
+		}
+	}
+	return index
+}
+
+// keywordScore returns the share of the best possible keyword score a role with
+// fieldTerms reaches for queryTerms, and the terms found per field.
+func keywordScore(queryTerms []string, fieldTerms roleTerms) (float64, []MatchReason) {
+
+	matched := make([][]string, len(recommendFields))
+	var total float64
+	for _, term := range queryTerms {
+		best := -1
+		for i := range recommendFields {
+			if fieldTerms[i][term] {
+				matched[i] = append(matched[i], term)
+				if best < 0 || recommendFields[i].weight > recommendFields[best].weight {
+					best = i
+				}
+			}
+		}
+		if best >= 0 {
+			total += recommendFields[best].weight
+		}
+	}
+
+	reasons := make([]MatchReason, 0, len(recommendFields))
+	for i, terms := range matched {
+		if len(terms) > 0 {
+			reasons = append(reasons, MatchReason{Field: recommendFields[i].name, Terms: terms})
+		}
+	}
+	return total / (float64(len(queryTerms)) * recommendMaxWeight), reasons
+}
+
+// roleEmbeddingText is the text a role is embedded as.
+func roleEmbeddingText(role models.Role) string {
+	return strings.Join([]string{role.Name, role.Domain, strings.Join(role.Tags, " "), role.Bio}, "\n")
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	return unique
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
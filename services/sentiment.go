@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"go.uber.org/zap"
+)
+
+// Backend names accepted by NewSentimentClassifier.
+const (
+	SentimentBackendLexicon = "lexicon"
+	SentimentBackendLLM     = "llm"
+	SentimentBackendNone    = "none"
+)
+
+const (
+	// sentimentWindow is how many of the latest user messages the rolling score covers.
+	sentimentWindow = 6
+	// sentimentDecay weighs the latest message against the rolling score of the earlier ones.
+	sentimentDecay = 0.6
+	// sentimentLabelCutoff separates neutral from negative and positive labels.
+	sentimentLabelCutoff = 0.2
+	// emoStabilizerSkill is the skill auto-enabled when the user sounds distressed.
+	emoStabilizerSkill = "emo_stabilizer"
+)
+
+// Sentiment is how the user sounds in a conversation, from -1 (distressed) to 1 (upbeat).
+type Sentiment struct {
+	// Score rates the latest user message.
+	Score float64 `json:"score"`
+	// Rolling rates the conversation: an exponentially weighted average over the latest
+	// user messages, so one gloomy word does not flip it.
+	Rolling float64 `json:"rolling"`
+	// Label is negative, neutral or positive, after Rolling.
+	Label string `json:"label"`
+	// Source is the backend that scored the latest message.
+	Source string `json:"source"`
+}
+
+// SentimentClassifier scores the sentiment of user messages. The lexicon backend matches
+// weighted Chinese and English cue words; the llm backend asks the chat model about the
+// latest message and falls back to the lexicon when that fails. Earlier messages are always
+// scored with the lexicon, which costs nothing.
+type SentimentClassifier struct {
+	backend string
+	nlp     ChatCompleter
+	token   string
+	timeout time.Duration
+	logger  *zap.SugaredLogger
+}
+
+// NewSentimentClassifier builds a classifier for backend; token is the credential of llm
+// calls, which are bounded by timeout. It returns nil for the none backend, and a nil
+// classifier reports no sentiment.
+func NewSentimentClassifier(backend string, nlp ChatCompleter, token string, timeout time.Duration, logger *zap.SugaredLogger) *SentimentClassifier {
+	if backend == SentimentBackendNone {
+		return nil
+	}
+	if backend == "" {
+		backend = SentimentBackendLexicon
+	}
+	return &SentimentClassifier{backend: backend, nlp: nlp, token: strings.TrimSpace(token), timeout: timeout, logger: logger}
+}
+
+// Analyze returns the sentiment of a conversation whose latest user message is message.
+// With offline set the llm backend is not called, as for dry runs.
+func (c *SentimentClassifier) Analyze(ctx context.Context, history []NLPMessage, message string, offline bool) *Sentiment {
+	if c == nil || strings.TrimSpace(message) == "" {
+		return nil
+	}
+	var earlier []float64
+	for _, msg := range history {
+		if strings.EqualFold(msg.Role, "user") && strings.TrimSpace(msg.Content) != "" {
+			earlier = append(earlier, ScoreSentiment(msg.Content))
+		}
+	}
+	if len(earlier) > sentimentWindow-1 {
+		earlier = earlier[len(earlier)-(sentimentWindow-1):]
+	}
+
+	sentiment := &Sentiment{Score: ScoreSentiment(message), Source: SentimentBackendLexicon}
+	if c.backend == SentimentBackendLLM && c.token != "" && !offline {
+		if score, err := c.classify(ctx, message); err != nil {
+			ctxlog.From(ctx, c.logger).Warnf("classify sentiment: %v", err)
+		} else {
+			sentiment.Score, sentiment.Source = score, SentimentBackendLLM
+		}
+	}
+	sentiment.Rolling = RollingSentiment(append(earlier, sentiment.Score))
+	sentiment.Label = sentimentLabel(sentiment.Rolling)
+	return sentiment
+}
+
+func (c *SentimentClassifier) classify(ctx context.Context, message string) (float64, error) {
+	callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	answer, err := c.nlp.Complete(callCtx, c.token, []NLPMessage{
+		{Role: "system", Content: "You rate how the user feels in a chat message, from -1 (distressed, anxious, sad or angry) " +
+			"through 0 (neutral) to 1 (happy or relieved). Answer with JSON only: {\"score\": <number>}."},
+		{Role: "user", Content: truncateRunes(strings.TrimSpace(message), 1000)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ParseSentimentAnswer(answer)
+}
+
+// ParseSentimentAnswer extracts the score from a model answer of the form {"score": x},
+// optionally fenced or surrounded by prose, clamped to [-1, 1].
+func ParseSentimentAnswer(answer string) (float64, error) {
+	payload, ok := extractJSON(answer)
+	if !ok || payload[0] != '{' {
+		return 0, apierr.New(apierr.CodeUpstream, "sentiment answer is not JSON").WithDetail(truncateRunes(answer, 200))
+	}
+	var parsed struct {
+		Score *float64 `json:"score"`
+	}
+	if err := json.Unmarshal(payload, &parsed); err != nil || parsed.Score == nil || math.IsNaN(*parsed.Score) {
+		return 0, apierr.New(apierr.CodeUpstream, "sentiment answer has no score").WithDetail(truncateRunes(answer, 200))
+	}
+	return min(max(*parsed.Score, -1), 1), nil
+}
+
+// RollingSentiment averages message scores, oldest first, weighing each message
+// sentimentDecay against everything before it.
+func RollingSentiment(scores []float64) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	rolling := scores[0]
+	for _, score := range scores[1:] {
+		rolling = sentimentDecay*score + (1-sentimentDecay)*rolling
+	}
+	return math.Round(rolling*1000) / 1000
+}
+
+func sentimentLabel(score float64) string {
+	switch {
+	case score <= -sentimentLabelCutoff:
+		return "negative"
+	case score >= sentimentLabelCutoff:
+		return "positive"
+	}
+	return "neutral"
+}
+
+// autoEnabledSkills returns the skills the conversation's sentiment turns on besides
+// enabled: emo_stabilizer once the rolling score is at or below -threshold.
+func autoEnabledSkills(req NLPRequest, enabled []string, threshold float64) []string {
+	if req.DisableAutoSkills || req.Sentiment == nil || threshold <= 0 {
+		return nil
+	}
+	if req.Sentiment.Rolling > -threshold {
+		return nil
+	}
+	for _, id := range enabled {
+		if id == emoStabilizerSkill {
+			return nil
+		}
+	}
+	return []string{emoStabilizerSkill}
+}
+
+// sentimentCue is a lexicon entry; negative weights mark distress.
+type sentimentCue struct {
+	term   string
+	weight float64
+}
+
+var (
+	chineseSentimentCues = sortedCues(map[string]float64{
+		"焦虑": -1, "难过": -1, "伤心": -1, "沮丧": -1, "崩溃": -1.5, "绝望": -1.5, "害怕": -1, "担心": -0.8,
+		"痛苦": -1.2, "压力": -0.6, "烦": -0.6, "累": -0.5, "心累": -1, "失眠": -0.8, "孤独": -1, "抑郁": -1.5,
+		"想哭": -1.2, "哭": -0.8, "失败": -0.8, "糟糕": -0.8, "讨厌": -0.8, "生气": -0.8, "愤怒": -1, "无助": -1.2,
+		"迷茫": -0.8, "紧张": -0.6, "受不了": -1.2, "撑不下去": -1.5, "不想活": -2, "委屈": -1, "失望": -0.8,
+		"后悔": -0.6, "恐慌": -1.2, "烦躁": -0.8, "郁闷": -0.8, "心慌": -1,
+		"开心": 1, "高兴": 1, "快乐": 1, "谢谢": 0.5, "感谢": 0.5, "不错": 0.6, "很好": 0.6, "太好了": 1,
+		"喜欢": 0.6, "兴奋": 0.8, "轻松": 0.6, "满意": 0.6, "棒": 0.6, "放心": 0.6, "安心": 0.6,
+	})
+	englishSentimentCues = map[string]float64{
+		"anxious": -1, "anxiety": -1, "sad": -1, "depressed": -1.5, "hopeless": -1.5, "stressed": -0.8,
+		"stress": -0.6, "overwhelmed": -1.2, "scared": -1, "afraid": -1, "worried": -0.8, "worry": -0.6,
+		"lonely": -1, "upset": -0.8, "angry": -0.8, "frustrated": -0.8, "terrible": -0.8, "awful": -0.8,
+		"miserable": -1.2, "cry": -0.8, "crying": -1, "panic": -1.2, "exhausted": -0.8, "tired": -0.5,
+		"hate": -0.8, "failed": -0.6, "failure": -0.8, "hurt": -0.8, "nervous": -0.6, "helpless": -1.2,
+		"happy": 1, "glad": 0.8, "great": 0.6, "thanks": 0.5, "thank": 0.5, "good": 0.4, "love": 0.6,
+		"excited": 0.8, "relieved": 0.8, "awesome": 0.8, "calm": 0.5,
+	}
+	chineseNegations    = []rune("不没别")
+	chineseIntensifiers = []string{"非常", "特别", "超级", "很", "太", "好", "真", "最"}
+	englishNegations    = map[string]bool{"not": true, "no": true, "never": true, "dont": true, "don't": true, "isn't": true, "wasn't": true, "can't": true, "cannot": true}
+	englishIntensifiers = map[string]bool{"so": true, "very": true, "really": true, "extremely": true, "too": true, "super": true}
+)
+
+func sortedCues(weights map[string]float64) []sentimentCue {
+	cues := make([]sentimentCue, 0, len(weights))
+	for term, weight := range weights {
+		cues = append(cues, sentimentCue{term: term, weight: weight})
+	}
+	// longer terms first, so 心累 is matched before 累
+	sort.Slice(cues, func(i, j int) bool {
+		li, lj := len([]rune(cues[i].term)), len([]rune(cues[j].term))
+		if li != lj {
+			return li > lj
+		}
+		return cues[i].term < cues[j].term
+	})
+	return cues
+}
+
+// ScoreSentiment rates text from -1 to 1 with the cue lexicon. A negation right before a
+// cue flips it (不开心, not happy) and an intensifier strengthens it by half (很焦虑, so
+// tired). Text without cues scores 0.
+func ScoreSentiment(text string) float64 {
+	var positive, negative float64
+	add := func(weight float64) {
+		if weight < 0 {
+			negative -= weight
+		} else {
+			positive += weight
+		}
+	}
+
+	lower := strings.ToLower(text)
+	words := strings.FieldsFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) && r != '\'' })
+	for i, word := range words {
+		weight, ok := englishSentimentCues[word]
+		if !ok {
+			continue
+		}
+		for back := 1; back <= 2 && i-back >= 0; back++ {
+			prev := words[i-back]
+			if englishNegations[prev] || strings.HasSuffix(prev, "n't") {
+				weight = -weight / 2
+				break
+			}
+			if englishIntensifiers[prev] {
+				weight *= 1.5
+			}
+		}
+		add(weight)
+	}
+
+	runes := []rune(lower)
+	used := make([]bool, len(runes))
+	for _, cue := range chineseSentimentCues {
+		term := []rune(cue.term)
+		for start := 0; start+len(term) <= len(runes); start++ {
+			if used[start] || string(runes[start:start+len(term)]) != cue.term {
+				continue
+			}
+			for k := range term {
+				used[start+k] = true
+			}
+			weight := cue.weight
+			if start > 0 && containsRune(chineseNegations, runes[start-1]) && !used[start-1] {
+				weight = -weight / 2
+			} else if hasIntensifier(runes[:start]) {
+				weight *= 1.5
+			}
+			add(weight)
+		}
+	}
+
+	if positive == 0 && negative == 0 {
+		return 0
+	}
+	return math.Round((positive-negative)/(positive+negative+1)*1000) / 1000
+}
+
+func hasIntensifier(before []rune) bool {
+	prefix := string(before)
+	for _, word := range chineseIntensifiers {
+		if strings.HasSuffix(prefix, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsRune(runes []rune, r rune) bool {
+	for _, candidate := range runes {
+		if candidate == r {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// Skill is an entry of a role's skills column.
+type Skill struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// SkillInfo describes a skill the prompt builder implements.
+type SkillInfo struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// SkillCatalog lists the implemented skills, sorted by ID.
+func SkillCatalog() []SkillInfo {
+	catalog := make([]SkillInfo, 0, len(skillHooks))
+	for id, hook := range skillHooks {
+		catalog = append(catalog, SkillInfo{ID: id, Name: hook.name, Description: hook.summary})
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].ID < catalog[j].ID })
+	return catalog
+}
+
+// SkillSuggester proposes skill IDs for a role. Suggestions may repeat skills the role
+// already has; SkillEnricher merges them.
+type SkillSuggester interface {
+	SuggestSkills(ctx context.Context, token string, role models.Role) ([]string, error)
+}
+
+// Backend names accepted by SkillEnricher.Enrich.
+const (
+	SkillBackendHeuristic = "heuristic"
+	SkillBackendLLM       = "llm"
+)
+
+// SkillEnrichment is the outcome of enriching one role: the skills it has, those a
+// backend suggested, and the merged list, which keeps every existing skill.
+type SkillEnrichment struct {
+	RoleID    int64    `json:"role_id"`
+	Backend   string   `json:"backend"`
+	Current   []Skill  `json:"current"`
+	Suggested []string `json:"suggested"`
+	Added     []Skill  `json:"added"`
+	Skills    []Skill  `json:"skills"`
+}
+
+// Changed reports whether the merged skills differ from the current ones.
+func (e *SkillEnrichment) Changed() bool {
+	return len(e.Added) > 0
+}
+
+// SkillsJSON encodes the merged skills for the roles table.
+func (e *SkillEnrichment) SkillsJSON() json.RawMessage {
+	encoded, _ := json.Marshal(e.Skills)
+	return encoded
+}
+
+// SkillEnricher suggests skills for roles and merges them with the ones already set,
+// never removing or renaming a skill the role has.
+type SkillEnricher struct {
+	backends map[string]SkillSuggester
+}
+
+// NewSkillEnricher builds an enricher with the keyword heuristic and, when nlp is not nil,
+// the LLM backend.
+func NewSkillEnricher(nlp ChatCompleter) *SkillEnricher {
+	backends := map[string]SkillSuggester{SkillBackendHeuristic: HeuristicSkillSuggester{}}
+	if nlp != nil {
+		backends[SkillBackendLLM] = &LLMSkillSuggester{nlp: nlp}
+	}
+	return &SkillEnricher{backends: backends}
+}
+
+// Enrich runs backend ("" for the heuristic) on role. token authenticates LLM calls.
+func (e *SkillEnricher) Enrich(ctx context.Context, backend, token string, role models.Role) (*SkillEnrichment, error) {
+	if backend == "" {
+		backend = SkillBackendHeuristic
+	}
+	suggester, ok := e.backends[backend]
+	if !ok {
+		return nil, apierr.New(apierr.CodeInvalidRequest, fmt.Sprintf("unknown skill backend %q", backend))
+	}
+	suggested, err := suggester.SuggestSkills(ctx, token, role)
+	if err != nil {
+		return nil, err
+	}
+
+	current := ParseRoleSkills(role.Skills)
+	result := &SkillEnrichment{
+		RoleID:    role.ID,
+		Backend:   backend,
+		Current:   current,
+		Suggested: suggested,
+		Added:     []Skill{},
+		Skills:    append([]Skill{}, current...),
+	}
+	have := make(map[string]bool, len(current))
+	for _, skill := range current {
+		have[skill.ID] = true
+	}
+	for _, id := range suggested {
+		if have[id] || !KnownSkill(id) {
+			continue
+		}
+		have[id] = true
+		skill := Skill{ID: id, Name: skillHooks[id].name}
+		result.Added = append(result.Added, skill)
+		result.Skills = append(result.Skills, skill)
+	}
+	return result, nil
+}
+
+// ParseRoleSkills decodes a skills column, dropping entries without an ID and repeated
+// IDs, and naming unnamed skills after the catalog. Unparsable input yields no skills.
+func ParseRoleSkills(raw json.RawMessage) []Skill {
+	skills := []Skill{}
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || string(trimmed) == "null" {
+		return skills
+	}
+	var decoded []Skill
+	if err := json.Unmarshal(trimmed, &decoded); err != nil {
+		return skills
+	}
+	seen := make(map[string]bool, len(decoded))
+	for _, skill := range decoded {
+		skill.ID = strings.TrimSpace(skill.ID)
+		if skill.ID == "" || seen[skill.ID] {
+			continue
+		}
+		seen[skill.ID] = true
+		if strings.TrimSpace(skill.Name) == "" {
+			skill.Name = skillDisplayName(skill.ID)
+		}
+		skills = append(skills, skill)
+	}
+	return skills
+}
+
+func skillDisplayName(id string) string {
+	if hook, ok := skillHooks[id]; ok && hook.name != "" {
+		return hook.name
+	}
+	return id
+}
+
+// HeuristicSkillSuggester matches keywords in a role's name, domain, tags and bio.
+type HeuristicSkillSuggester struct{}
+
+// skillKeywords maps skill IDs to the (lowercase) keywords that suggest them; English
+// keywords match substrings, so "investigat" covers investigator and investigation.
+var skillKeywords = []struct {
+	id       string
+	keywords []string
+}{
+	{"socratic_questions", []string{"philosophy", "philosopher", "teacher", "coach", "mentor", "哲学", "老师", "教练", "导师",
+		"socrates", "plato", "aristotle", "confucius", "苏格拉底", "柏拉图", "亚里士多德", "孔子", "sherlock", "holmes", "福尔摩斯"}},
+	{"citation_mode", []string{"historian", "history", "scientist", "science", "research", "paper", "detective", "investigat",
+		"历史", "学者", "科研", "论文", "侦探", "socrates", "plato", "aristotle", "confucius", "苏格拉底", "柏拉图", "亚里士多德", "孔子",
+		"sherlock", "holmes", "福尔摩斯"}},
+	{"emo_stabilizer", []string{"psych", "therap", "counsel", "support", "coach", "mentor", "friendly", "brave",
+		"心理", "咨询", "支持", "安抚", "勇敢", "温暖", "mulan", "harry", "木兰", "哈利"}},
+}
+
+// SuggestSkills implements SkillSuggester.
+func (HeuristicSkillSuggester) SuggestSkills(_ context.Context, _ string, role models.Role) ([]string, error) {
+	text := strings.ToLower(strings.Join([]string{role.Name, role.Domain, strings.Join(role.Tags, " "), role.Bio}, " "))
+	suggested := make([]string, 0, len(skillKeywords))
+	for _, rule := range skillKeywords {
+		for _, keyword := range rule.keywords {
+			if strings.Contains(text, keyword) {
+				suggested = append(suggested, rule.id)
+				break
+			}
+		}
+	}
+	return suggested, nil
+}
+
+// ChatCompleter sends a raw prompt to a chat model; NLPService implements it.
+type ChatCompleter interface {
+	Complete(ctx context.Context, token string, messages []NLPMessage) (string, error)
+}
+
+// LLMSkillSuggester asks the chat model to pick skills from the catalog for a role.
+type LLMSkillSuggester struct {
+	nlp ChatCompleter
+}
+
+// NewLLMSkillSuggester builds a suggester calling nlp.
+func NewLLMSkillSuggester(nlp ChatCompleter) *LLMSkillSuggester {
+	return &LLMSkillSuggester{nlp: nlp}
+}
+
+// SuggestSkills implements SkillSuggester.
+func (s *LLMSkillSuggester) SuggestSkills(ctx context.Context, token string, role models.Role) ([]string, error) {
+	catalog, err := json.Marshal(SkillCatalog())
+	if err != nil {
+		return nil, err
+	}
+	profile, err := json.Marshal(map[string]any{
+		"name":       role.Name,
+		"domain":     role.Domain,
+		"tags":       role.Tags,
+		"bio":        role.Bio,
+		"background": role.Background,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := s.nlp.Complete(ctx, token, []NLPMessage{
+		{Role: "system", Content: "You assign conversation skills to role-play characters. Choose only skills from the catalog " +
+			"that fit the character, possibly none. Answer with JSON only, in the form {\"skills\": [\"<skill id>\", ...]}.\n\nCatalog:\n" +
+			string(catalog)},
+		{Role: "user", Content: string(profile)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ParseSkillAnswer(answer)
+}
+
+// ParseSkillAnswer extracts skill IDs from a model answer. It accepts {"skills": [...]} or
+// a bare array, of IDs or of {"id": ...} objects, optionally inside a Markdown code fence
+// or surrounded by prose. IDs missing from the catalog are dropped; an answer with no JSON
+// at all is an UPSTREAM_ERROR.
+func ParseSkillAnswer(answer string) ([]string, error) {
+	payload, ok := extractJSON(answer)
+	if !ok {
+		return nil, apierr.New(apierr.CodeUpstream, "skill suggestion is not JSON").WithDetail(truncateRunes(answer, 200))
+	}
+
+	var wrapped struct {
+		Skills json.RawMessage `json:"skills"`
+	}
+	if payload[0] == '{' {
+		if err := json.Unmarshal(payload, &wrapped); err != nil || len(wrapped.Skills) == 0 {
+			return nil, apierr.New(apierr.CodeUpstream, "skill suggestion has no skills list").WithDetail(truncateRunes(answer, 200))
+		}
+		payload = wrapped.Skills
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(payload, &items); err != nil {
+		return nil, apierr.New(apierr.CodeUpstream, "skill suggestion has no skills list").WithDetail(truncateRunes(answer, 200))
+	}
+	ids := make([]string, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		var id string
+		if err := json.Unmarshal(item, &id); err != nil {
+			var skill Skill
+			if err := json.Unmarshal(item, &skill); err != nil {
+				continue
+			}
+			id = skill.ID
+		}
+		id = strings.TrimSpace(id)
+		if !KnownSkill(id) || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// extractJSON returns the first JSON object or array in text that decodes cleanly.
+func extractJSON(text string) ([]byte, bool) {
+	for start := 0; start < len(text); start++ {
+		if text[start] != '{' && text[start] != '[' {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(text[start:]))
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == nil {
+			return value, true
+		}
+	}
+	return nil, false
+}
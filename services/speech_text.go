@@ -0,0 +1,61 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	fencedCodePattern  = regexp.MustCompile("(?s)```.*?```")
+	inlineCodePattern  = regexp.MustCompile("`([^`]*)`")
+	imagePattern       = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	linkPattern        = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	emphasisPattern    = regexp.MustCompile(`(\*\*|__|\*|~~)([^*_~]+?)(\*\*|__|\*|~~)`)
+	headingPattern     = regexp.MustCompile(`^#{1,6}\s+`)
+	bulletPattern      = regexp.MustCompile(`^([-*+•·●▪]|\d+[.)])\s+`)
+	blockquotePattern  = regexp.MustCompile(`^>+\s?`)
+	tableRulePattern   = regexp.MustCompile(`^\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?$`)
+	horizontalRule     = regexp.MustCompile(`^([-*_]\s*){3,}$`)
+	leftoverMarkupChar = strings.NewReplacer("**", "", "__", "", "~~", "", "`", "")
+)
+
+// SanitizeSpeechText strips Markdown artifacts from LLM output so TTS does not read
+// asterisks, hashes or code aloud. Fenced code blocks are dropped entirely; links and
+// inline code keep their text; list markers, headings and quotes lose their prefix.
+func SanitizeSpeechText(text string) string {
+	if text == "" {
+		return ""
+	}
+
+	text = fencedCodePattern.ReplaceAllString(text, "\n")
+	text = imagePattern.ReplaceAllString(text, "")
+	text = linkPattern.ReplaceAllString(text, "$1")
+	text = inlineCodePattern.ReplaceAllString(text, "$1")
+
+	lines := strings.Split(text, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || horizontalRule.MatchString(line) || tableRulePattern.MatchString(line) {
+			continue
+		}
+		line = blockquotePattern.ReplaceAllString(line, "")
+		line = headingPattern.ReplaceAllString(line, "")
+		line = bulletPattern.ReplaceAllString(line, "")
+		if strings.HasPrefix(line, "|") || strings.HasSuffix(line, "|") {
+			cells := strings.FieldsFunc(line, func(r rune) bool { return r == '|' })
+			for i := range cells {
+				cells[i] = strings.TrimSpace(cells[i])
+			}
+			line = strings.Join(cells, "，")
+		}
+		line = emphasisPattern.ReplaceAllString(line, "$2")
+		line = leftoverMarkupChar.Replace(line)
+		line = strings.TrimSpace(line)
+		if line != "" {
+			kept = append(kept, line)
+		}
+	}
+
+	return strings.Join(kept, "\n")
+}
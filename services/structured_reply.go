@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+)
+
+// Response formats of a chat reply.
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatStructured = "structured"
+)
+
+// structuredReplyInstruction is appended to the system prompt of structured requests.
+const structuredReplyInstruction = "输出格式：只输出一个 JSON 对象，不要输出任何其他文字或代码块标记，结构为 " +
+	"{\"speech\": \"角色说出口的话\", \"actions\": [\"动作或神态描写\"], \"followups\": [\"用户可能想接着问的问题\"]}。" +
+	"speech 只包含会被朗读的台词，不含动作描写；actions 与 followups 可以为空数组，followups 最多 3 条。"
+
+// StructuredReply is a reply segmented for client-side rendering: the spoken text, stage
+// directions and suggested follow-up questions.
+type StructuredReply struct {
+	Speech    string   `json:"speech"`
+	Actions   []string `json:"actions"`
+	Followups []string `json:"followups"`
+}
+
+// NormalizeResponseFormat validates format, mapping "" to ResponseFormatText.
+func NormalizeResponseFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", ResponseFormatText:
+		return ResponseFormatText, nil
+	case ResponseFormatStructured:
+		return ResponseFormatStructured, nil
+	default:
+		return "", fmt.Errorf("response_format must be %q or %q", ResponseFormatText, ResponseFormatStructured)
+	}
+}
+
+// parseStructured parses the structured reply in reply and replaces its content with the
+// speech. When parsing fails reply is left as it is and nil returned.
+func (s *NLPService) parseStructured(ctx context.Context, reply *NLPMessage) *StructuredReply {
+	structured, ok := ParseStructuredReply(reply.Content)
+	if !ok {
+		ctxlog.From(ctx, s.logger).Warnf("structured reply could not be parsed, returning it as text (%d bytes)", len(reply.Content))
+		return nil
+	}
+	reply.Content = structured.Speech
+	return structured
+}
+
+// ParseStructuredReply parses a model answer to a structured request. It tolerates what
+// models commonly get wrong: Markdown code fences, prose around the object, trailing
+// commas and raw newlines inside strings. It reports false when no object with a
+// non-empty speech can be recovered.
+func ParseStructuredReply(answer string) (*StructuredReply, bool) {
+	start := strings.IndexByte(answer, '{')
+	end := strings.LastIndexByte(answer, '}')
+	if start < 0 || end < start {
+		return nil, false
+	}
+
+	var decoded struct {
+		Speech    string   `json:"speech"`
+		Actions   []string `json:"actions"`
+		Followups []string `json:"followups"`
+		FollowUps []string `json:"follow_ups"`
+	}
+	if err := json.Unmarshal([]byte(repairJSON(answer[start:end+1])), &decoded); err != nil {
+		return nil, false
+	}
+	reply := &StructuredReply{
+		Speech:    strings.TrimSpace(decoded.Speech),
+		Actions:   filterNonEmpty(decoded.Actions),
+		Followups: filterNonEmpty(append(decoded.Followups, decoded.FollowUps...)),
+	}
+	if reply.Speech == "" {
+		return nil, false
+	}
+	return reply, true
+}
+
+// repairJSON drops commas that directly precede a closing bracket and escapes raw line
+// breaks and tabs inside strings.
+func repairJSON(raw string) string {
+	var b strings.Builder
+	b.Grow(len(raw))
+	inString, escaped := false, false
+	for i := 0; i < len(raw); i++ {
+		ch := raw[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			case ch == '\n':
+				b.WriteString(`\n`)
+				continue
+			case ch == '\r':
+				continue
+			case ch == '\t':
+				b.WriteString(`\t`)
+				continue
+			}
+			b.WriteByte(ch)
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case ',':
+			next := strings.TrimLeft(raw[i+1:], " \t\r\n")
+			if next != "" && (next[0] == '}' || next[0] == ']') {
+				continue
+			}
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// SpeechText returns the text to synthesize for the reply: the speech of a structured
+// reply, the whole reply otherwise.
+func (r *NLPResponse) SpeechText() string {
+	if r.Structured != nil {
+		return r.Structured.Speech
+	}
+	return r.Reply.Content
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+)
+
+const (
+	suggestionCount         = 3
+	maxSuggestionRunes      = 40
+	suggestionCacheTTL      = 24 * time.Hour
+	suggestionCacheDeadline = 300 * time.Millisecond
+)
+
+const suggestionPrompt = "你为角色扮演对话生成推荐追问。根据下面这轮对话，写出用户接下来最可能想问角色的 3 个简短问题，" +
+	"每个不超过20字，使用语言代码 %s 对应的语言。只输出 JSON：{\"suggestions\": [\"...\", \"...\", \"...\"]}。"
+
+// SuggestionGenerator proposes follow-up questions for a reply with a small secondary
+// completion call. Suggestions are cached in Redis by a hash of the exchange, so asking
+// again for the same reply costs nothing.
+type SuggestionGenerator struct {
+	nlp     ChatCompleter
+	cache   *kv.Store
+	timeout time.Duration
+	logger  *zap.SugaredLogger
+}
+
+// NewSuggestionGenerator builds a generator calling nlp, each call bounded by timeout;
+// cache may be nil to disable the cache.
+func NewSuggestionGenerator(nlp ChatCompleter, cache *kv.Store, timeout time.Duration, logger *zap.SugaredLogger) *SuggestionGenerator {
+	return &SuggestionGenerator{nlp: nlp, cache: cache, timeout: timeout, logger: logger}
+}
+
+// Suggest returns up to three follow-up questions to reply, in language. Suggestions are
+// optional, so failures are logged and return none; Suggest never fails the caller.
+func (g *SuggestionGenerator) Suggest(ctx context.Context, token, language, userMessage, reply string) []string {
+	if g == nil || strings.TrimSpace(reply) == "" {
+		return nil
+	}
+	language = strings.TrimSpace(language)
+	if language == "" {
+		language = DefaultLanguage
+	}
+	key := g.cache.Key(kv.Suggestions, exchangeHash(language, userMessage, reply))
+	if suggestions, ok := g.readCache(ctx, key); ok {
+		return suggestions
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	answer, err := g.nlp.Complete(callCtx, token, []NLPMessage{
+		{Role: "system", Content: fmt.Sprintf(suggestionPrompt, language)},
+		{Role: "user", Content: "用户：" + strings.TrimSpace(userMessage) + "\n角色：" + strings.TrimSpace(reply)},
+	})
+	if err != nil {
+		ctxlog.From(ctx, g.logger).Warnf("generate suggestions: %v", err)
+		return nil
+	}
+	suggestions, err := ParseSuggestions(answer)
+	if err != nil {
+		ctxlog.From(ctx, g.logger).Warnf("parse suggestions: %v", err)
+		return nil
+	}
+	g.writeCache(ctx, key, suggestions)
+	return suggestions
+}
+
+func (g *SuggestionGenerator) readCache(ctx context.Context, key string) ([]string, bool) {
+	if !g.cache.Available() {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, suggestionCacheDeadline)
+	defer cancel()
+	var suggestions []string
+	if found, err := g.cache.GetJSON(ctx, key, &suggestions); err != nil || !found {
+		return nil, false
+	}
+	return suggestions, true
+}
+
+func (g *SuggestionGenerator) writeCache(ctx context.Context, key string, suggestions []string) {
+	if !g.cache.Available() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, suggestionCacheDeadline)
+	defer cancel()
+	if err := g.cache.SetJSON(ctx, key, suggestions, suggestionCacheTTL); err != nil {
+		ctxlog.From(ctx, g.logger).Debugf("cache suggestions: %v", err)
+	}
+}
+
+func exchangeHash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseSuggestions extracts follow-up questions from a model answer: {"suggestions": [...]}
+// or a bare array of strings, optionally fenced or surrounded by prose, or else a numbered
+// or bulleted list, one question per line. At most three are kept, long ones truncated; an
+// answer with none is an UPSTREAM_ERROR.
+func ParseSuggestions(answer string) ([]string, error) {
+	var items []string
+	if payload, ok := extractJSON(answer); ok {
+		if payload[0] == '{' {
+			var wrapped struct {
+				Suggestions []string `json:"suggestions"`
+				Questions   []string `json:"questions"`
+			}
+			if json.Unmarshal(payload, &wrapped) == nil {
+				items = append(wrapped.Suggestions, wrapped.Questions...)
+			}
+		} else {
+			_ = json.Unmarshal(payload, &items)
+		}
+	}
+	if len(items) == 0 {
+		items = listItems(answer)
+	}
+
+	suggestions := make([]string, 0, suggestionCount)
+	for _, item := range items {
+		item = strings.Trim(strings.TrimSpace(item), `"'“”‘’`)
+		if item == "" {
+			continue
+		}
+		suggestions = append(suggestions, truncateRunes(item, maxSuggestionRunes))
+		if len(suggestions) == suggestionCount {
+			break
+		}
+	}
+	if len(suggestions) == 0 {
+		return nil, apierr.New(apierr.CodeUpstream, "answer has no suggestions").WithDetail(truncateRunes(answer, 200))
+	}
+	return suggestions, nil
+}
+
+// listItems returns the items of a numbered or bulleted list in text. Lines without a
+// list marker, such as an introduction, are skipped unless no line has one.
+func listItems(text string) []string {
+	var marked, plain []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "```") {
+			continue
+		}
+		if item, ok := cutListMarker(line); ok {
+			marked = append(marked, item)
+		} else {
+			plain = append(plain, line)
+		}
+	}
+	if len(marked) > 0 {
+		return marked
+	}
+	return plain
+}
+
+// cutListMarker strips a leading "1.", "1)", "1、", "(1)", "（1）", "-", "*" or "•".
+func cutListMarker(line string) (string, bool) {
+	for _, bullet := range []string{"-", "*", "•", "·"} {
+		if rest, ok := strings.CutPrefix(line, bullet); ok {
+			return strings.TrimSpace(rest), true
+		}
+	}
+	rest := line
+	for _, open := range []string{"(", "（"} {
+		rest = strings.TrimPrefix(rest, open)
+	}
+	digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+	if digits == 0 {
+		return line, false
+	}
+	rest = rest[digits:]
+	for _, sep := range []string{".", ")", "）", "、", ":", "："} {
+		if after, ok := strings.CutPrefix(rest, sep); ok {
+			return strings.TrimSpace(after), true
+		}
+	}
+	return line, false
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SplitSentences breaks text into sentences on CJK and Latin terminators (。！？!?.\n),
+// keeping the terminator with its sentence. A '.' only ends a sentence when followed by
+// whitespace or the end of input, so decimals and abbreviations such as "3.14" stay intact.
+func SplitSentences(text string) []string {
+	runes := []rune(text)
+	sentences := make([]string, 0, 4)
+	start := 0
+
+	flush := func(end int) {
+		sentence := strings.TrimSpace(string(runes[start:end]))
+		if sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = end
+	}
+
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '。', '！', '？', '!', '?', '\n':
+			// keep runs of terminators and closing quotes together, e.g. "真的？！" or "好。」"
+			end := i + 1
+			for end < len(runes) && isSentenceTrailer(runes[end]) {
+				end++
+			}
+			flush(end)
+			i = end - 1
+		case '.':
+			if i+1 == len(runes) || unicode.IsSpace(runes[i+1]) {
+				flush(i + 1)
+			}
+		}
+	}
+	flush(len(runes))
+
+	return sentences
+}
+
+func isSentenceTrailer(r rune) bool {
+	switch r {
+	case '。', '！', '？', '!', '?', '”', '」', '』', '"', '\'', '）', ')':
+		return true
+	}
+	return false
+}
+
+// ChunkText packs sentences into chunks of at most limit runes for length-limited
+// upstream APIs. Sentences longer than limit are split at clause punctuation or
+// whitespace when possible, and hard-cut at limit otherwise.
+func ChunkText(text string, limit int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if limit <= 0 || len([]rune(text)) <= limit {
+		return []string{text}
+	}
+
+	chunks := make([]string, 0, 4)
+	var current []rune
+	flush := func() {
+		if chunk := strings.TrimSpace(string(current)); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current = current[:0]
+	}
+
+	for _, sentence := range SplitSentences(text) {
+		for _, piece := range splitLongSentence([]rune(sentence), limit) {
+			// Latin sentences were separated by whitespace that SplitSentences trimmed
+			sep := 0
+			if len(current) > 0 && needsSpace(current[len(current)-1], piece[0]) {
+				sep = 1
+			}
+			if len(current)+sep+len(piece) > limit {
+				flush()
+				sep = 0
+			}
+			if sep == 1 {
+				current = append(current, ' ')
+			}
+			current = append(current, piece...)
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+func splitLongSentence(sentence []rune, limit int) [][]rune {
+	var pieces [][]rune
+	for len(sentence) > limit {
+		cut := -1
+		for i := limit; i > limit/2; i-- {
+			if isClauseBreak(sentence[i-1]) {
+				cut = i
+				break
+			}
+		}
+		if cut < 0 {
+			cut = limit
+		}
+		if piece := []rune(strings.TrimSpace(string(sentence[:cut]))); len(piece) > 0 {
+			pieces = append(pieces, piece)
+		}
+		sentence = []rune(strings.TrimLeftFunc(string(sentence[cut:]), unicode.IsSpace))
+	}
+	if len(sentence) > 0 {
+		pieces = append(pieces, sentence)
+	}
+	return pieces
+}
+
+func isClauseBreak(r rune) bool {
+	switch r {
+	case '，', '、', '；', '：', ',', ';', ':', ' ':
+		return true
+	}
+	return false
+}
+
+func needsSpace(prev, next rune) bool {
+	return prev < unicode.MaxASCII && next < unicode.MaxASCII
+}
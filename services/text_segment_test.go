@@ -0,0 +1,66 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitSentences(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", []string{}},
+		{"whitespace only", " \n\t ", []string{}},
+		{"no terminator", "just a fragment", []string{"just a fragment"}},
+		{"latin", "Hello there. How are you? Fine!", []string{"Hello there.", "How are you?", "Fine!"}},
+		{"decimal and abbreviation", "Pi is 3.14 roughly. e.g.this stays", []string{"Pi is 3.14 roughly.", "e.g.this stays"}},
+		{"cjk", "你好。今天天气怎么样？很好！", []string{"你好。", "今天天气怎么样？", "很好！"}},
+		{"terminator runs", "真的？！太好了。。", []string{"真的？！", "太好了。。"}},
+		{"closing quotes", "他说：“走吧。”然后离开了。「好。」", []string{"他说：“走吧。”", "然后离开了。", "「好。」"}},
+		{"newlines", "line one\nline two\n\nline three", []string{"line one", "line two", "line three"}},
+		{"mixed", "Hi! 我是小明。Nice to meet you.", []string{"Hi!", "我是小明。", "Nice to meet you."}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := SplitSentences(tc.text); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("SplitSentences(%q) = %q, want %q", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	cases := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"empty", "", 10, nil},
+		{"whitespace only", "  \n ", 10, nil},
+		{"fits", "Short text.", 50, []string{"Short text."}},
+		{"no limit", "One. Two. Three.", 0, []string{"One. Two. Three."}},
+		{"packs latin sentences", "One two. Three four. Five six.", 20, []string{"One two. Three four.", "Five six."}},
+		{"packs cjk sentences", "你好。今天天气很好。我们去公园吧。", 10, []string{"你好。今天天气很好。", "我们去公园吧。"}},
+		{"overlong cjk sentence splits at clause", "春天来了，花儿开了，鸟儿在树上唱歌，孩子们在草地上玩耍。", 12, []string{"春天来了，花儿开了，", "鸟儿在树上唱歌，", "孩子们在草地上玩耍。"}},
+		{"overlong latin sentence splits at whitespace", "alpha beta gamma delta epsilon", 12, []string{"alpha beta", "gamma delta", "epsilon"}},
+		{"no break point is hard cut", strings.Repeat("字", 25), 10, []string{strings.Repeat("字", 10), strings.Repeat("字", 10), strings.Repeat("字", 5)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ChunkText(tc.text, tc.limit)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("ChunkText(%q, %d) = %q, want %q", tc.text, tc.limit, got, tc.want)
+			}
+			for _, chunk := range got {
+				if tc.limit > 0 && utf8.RuneCountInString(chunk) > tc.limit {
+					t.Errorf("chunk %q has %d runes, over the limit %d", chunk, utf8.RuneCountInString(chunk), tc.limit)
+				}
+			}
+		})
+	}
+}
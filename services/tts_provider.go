@@ -0,0 +1,25 @@
+package services
+
+import "context"
+
+// TTS provider names accepted by TTS_PROVIDER.
+const (
+	TTSProviderQiniu  = "qiniu"
+	TTSProviderOpenAI = "openai"
+)
+
+// TTSProvider is a speech synthesis backend.
+type TTSProvider interface {
+	Name() string
+	// Synthesize renders req, whose voice is already mapped to the provider's voice names.
+	Synthesize(ctx context.Context, token string, req TTSRequest) (*TTSResult, error)
+	// ListVoices returns the voices req.VoiceType may name.
+	ListVoices(ctx context.Context, token string) ([]VoiceInfo, error)
+}
+
+// TTSStreamer is implemented by providers that can deliver audio while it is being
+// synthesized. onAudio receives the audio in order; an error from it aborts the call and
+// is returned. The result carries no Audio.
+type TTSStreamer interface {
+	SynthesizeStream(ctx context.Context, token string, req TTSRequest, onAudio func(chunk []byte) error) (*TTSResult, error)
+}
@@ -8,19 +8,66 @@ import (
     "fmt"
     "io"
     "net/http"
+    "strconv"
     "strings"
+    "sync"
     "time"
+    "unicode/utf8"
 
+    "github.com/wuwenbin0122/wwb.ai/apierr"
     "github.com/wuwenbin0122/wwb.ai/config"
+    "github.com/wuwenbin0122/wwb.ai/db/models"
     "go.uber.org/zap"
 )
 
-// TTSRequest encapsulates a synthesis task forwarded to Qiniu.
+// TTSRequest encapsulates a synthesis task forwarded to the TTS provider.
 type TTSRequest struct {
 	Text       string
 	VoiceType  string
 	Encoding   string
 	SpeedRatio float64
+	// PitchRatio, VolumeRatio and Emotion are forwarded only when set.
+	PitchRatio  float64
+	VolumeRatio float64
+	Emotion     string
+}
+
+// Accepted ranges for the prosody ratios; zero means "use the upstream default".
+const (
+	minSpeedRatio  = 0.2
+	maxSpeedRatio  = 3.0
+	minPitchRatio  = 0.1
+	maxPitchRatio  = 3.0
+	minVolumeRatio = 0.1
+	maxVolumeRatio = 3.0
+)
+
+// ErrInvalidTTSOptions marks a synthesis request with out-of-range prosody settings.
+var ErrInvalidTTSOptions = apierr.New(apierr.CodeInvalidRequest, "invalid tts options")
+
+// Validate checks the prosody settings; zero values are left for the defaults.
+func (r TTSRequest) Validate() error {
+	checks := []struct {
+		name     string
+		value    float64
+		min, max float64
+	}{
+		{"speed_ratio", r.SpeedRatio, minSpeedRatio, maxSpeedRatio},
+		{"pitch_ratio", r.PitchRatio, minPitchRatio, maxPitchRatio},
+		{"volume_ratio", r.VolumeRatio, minVolumeRatio, maxVolumeRatio},
+	}
+	for _, check := range checks {
+		if check.value == 0 {
+			continue
+		}
+		if check.value < check.min || check.value > check.max {
+			return fmt.Errorf("%w: %s must be between %.1f and %.1f", ErrInvalidTTSOptions, check.name, check.min, check.max)
+		}
+	}
+	if len(r.Emotion) > 32 {
+		return fmt.Errorf("%w: emotion is too long", ErrInvalidTTSOptions)
+	}
+	return validTTSEncoding(r.Encoding)
 }
 
 // TTSResult is the simplified response returned to the caller.
@@ -29,32 +76,71 @@ type TTSResult struct {
 	Audio    []byte          `json:"audio"`
 	Duration string          `json:"duration"`
 	Raw      json.RawMessage `json:"raw"`
+	// Encoding is the audio encoding actually requested upstream (mp3, wav, ogg...).
+	Encoding string `json:"encoding"`
 }
 
-// VoiceInfo describes a voice returned by /voice/list.
+// VoiceInfo describes a voice offered by the TTS provider.
 type VoiceInfo struct {
 	VoiceName string `json:"voice_name"`
 	VoiceType string `json:"voice_type"`
 	URL       string `json:"url"`
 	Category  string `json:"category"`
 	UpdateMS  int64  `json:"updatetime"`
+	Language  string `json:"language,omitempty"`
 }
 
+// ttsService is the Qiniu TTSProvider.
 type ttsService struct {
 	baseURL       string
 	defaultVoice  string
 	defaultFormat string
+	maxChars      int
 	client        httpDoer
+	breaker       *CircuitBreaker
 	logger        *zap.SugaredLogger
 }
 
-// TTSService exposes convenience wrappers over Qiniu's RESTful TTS API.
+// voiceListCacheTTL bounds how long a /voice/list result is reused.
+const voiceListCacheTTL = 10 * time.Minute
+
+// TTSService synthesizes speech with the provider selected by TTS_PROVIDER. Voices are
+// named as roles store them and mapped to the provider's own names by the
+// TTS_VOICE_ALIASES_<PROVIDER> table.
 type TTSService struct {
-	inner *ttsService
+	provider     TTSProvider
+	defaultVoice string
+	aliases      map[string]string
+	// catalogKey identifies the provider's voice list in shared caches.
+	catalogKey string
+	logger     *zap.SugaredLogger
+
+	voicesMu     sync.Mutex
+	voices       []VoiceInfo
+	voicesAt     time.Time
+	warnedVoices sync.Map
 }
 
-// NewTTSService constructs a TTSService configured with defaults from cfg.
+// NewTTSService constructs a TTSService for the configured provider, Qiniu's by default.
 func NewTTSService(cfg *config.Config, logger *zap.SugaredLogger) *TTSService {
+	name := strings.ToLower(strings.TrimSpace(cfg.TTSProvider))
+	service := &TTSService{aliases: cfg.TTSVoiceAliases[name], logger: logger}
+	switch name {
+	case TTSProviderOpenAI:
+		provider := NewOpenAITTSProvider(cfg, logger)
+		service.provider = provider
+		service.defaultVoice = provider.defaultVoice
+		service.catalogKey = TTSProviderOpenAI + ":" + provider.baseURL
+	default:
+		provider := newQiniuTTSProvider(cfg, logger)
+		service.provider = provider
+		service.defaultVoice = provider.defaultVoice
+		service.catalogKey = provider.baseURL
+	}
+	return service
+}
+
+func newQiniuTTSProvider(cfg *config.Config, logger *zap.SugaredLogger) *ttsService {
 	base := strings.TrimRight(cfg.QiniuAPIBaseURL, "/")
 	if base == "" {
 		base = "https://openai.qiniu.com/v1"
@@ -72,29 +158,151 @@ func NewTTSService(cfg *config.Config, logger *zap.SugaredLogger) *TTSService {
 
     // TTS responses can be slower; use a longer HTTP timeout to avoid premature 504s.
     ttsHTTPClient := newHTTPClientWithTimeout(60 * time.Second)
-
-    return &TTSService{
-        inner: &ttsService{
-            baseURL:       base,
-            defaultVoice:  voice,
-            defaultFormat: format,
-            client:        ttsHTTPClient,
-            logger:        logger,
-        },
+    breaker := newQiniuBreaker(cfg, BreakerTTS)
+
+    return &ttsService{
+        baseURL:       base,
+        defaultVoice:  voice,
+        defaultFormat: format,
+        maxChars:      cfg.TTSMaxChars,
+        client:        breakerDoer{next: ttsHTTPClient, breaker: breaker},
+        breaker:       breaker,
+        logger:        logger,
     }
 }
 
-// Synthesize sends text-to-speech request to Qiniu and returns the synthesized audio bytes.
+// Provider returns the name of the TTS provider in use.
+func (s *TTSService) Provider() string {
+	return s.provider.Name()
+}
+
+// Breaker returns the circuit breaker guarding the TTS provider.
+func (s *TTSService) Breaker() *CircuitBreaker {
+	if guarded, ok := s.provider.(interface{ Breaker() *CircuitBreaker }); ok {
+		return guarded.Breaker()
+	}
+	return nil
+}
+
+// Synthesize renders req with the provider and returns the synthesized audio bytes.
 func (s *TTSService) Synthesize(ctx context.Context, token string, req TTSRequest) (*TTSResult, error) {
-	return s.inner.synthesize(ctx, token, req)
+	req.VoiceType = s.providerVoice(req.VoiceType)
+	return s.provider.Synthesize(ctx, token, req)
 }
 
-// ListVoices fetches available TTS voices.
+// SynthesizeStream passes the audio of req to onAudio as it is synthesized, in one piece
+// when the provider cannot stream. The result carries no Audio.
+func (s *TTSService) SynthesizeStream(ctx context.Context, token string, req TTSRequest, onAudio func(chunk []byte) error) (*TTSResult, error) {
+	req.VoiceType = s.providerVoice(req.VoiceType)
+	if streamer, ok := s.provider.(TTSStreamer); ok {
+		return streamer.SynthesizeStream(ctx, token, req, onAudio)
+	}
+	result, err := s.provider.Synthesize(ctx, token, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := onAudio(result.Audio); err != nil {
+		return nil, err
+	}
+	result.Audio = nil
+	return result, nil
+}
+
+// providerVoice maps a voice to the provider's name for it, if aliased.
+func (s *TTSService) providerVoice(voice string) string {
+	voice = strings.TrimSpace(voice)
+	if alias, ok := s.aliases[voice]; ok {
+		return alias
+	}
+	return voice
+}
+
+// ListVoices fetches available TTS voices, reusing a recent result when possible.
 func (s *TTSService) ListVoices(ctx context.Context, token string) ([]VoiceInfo, error) {
-	return s.inner.listVoices(ctx, token)
+	s.voicesMu.Lock()
+	if s.voices != nil && time.Since(s.voicesAt) < voiceListCacheTTL {
+		cached := s.voices
+		s.voicesMu.Unlock()
+		return cached, nil
+	}
+	s.voicesMu.Unlock()
+
+	return s.RefreshVoices(ctx, token)
+}
+
+// RefreshVoices fetches the voice list from the provider, bypassing and then updating the in-process cache.
+func (s *TTSService) RefreshVoices(ctx context.Context, token string) ([]VoiceInfo, error) {
+	voices, err := s.provider.ListVoices(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	s.voicesMu.Lock()
+	s.voices = voices
+	s.voicesAt = time.Now()
+	s.voicesMu.Unlock()
+	return voices, nil
+}
+
+// ResolveVoice fills in the voice and speed of req by priority: the request's own values,
+// then the role's configured voice, then the service default. A voice missing from the
+// cached voice list, after alias mapping, is logged (once per voice) but still used.
+func (s *TTSService) ResolveVoice(req TTSRequest, role *models.Role) TTSRequest {
+	req.VoiceType = strings.TrimSpace(req.VoiceType)
+	if req.VoiceType == "" && role != nil {
+		req.VoiceType = strings.TrimSpace(role.VoiceType)
+	}
+	if req.VoiceType == "" {
+		req.VoiceType = s.defaultVoice
+	}
+	if req.SpeedRatio <= 0 && role != nil && role.SpeedRatio > 0 {
+		req.SpeedRatio = role.SpeedRatio
+	}
+
+	s.checkVoice(s.providerVoice(req.VoiceType))
+	return req
+}
+
+// ValidateDefaultVoice loads the voice list and warns when the configured default voice is not offered.
+func (s *TTSService) ValidateDefaultVoice(ctx context.Context, token string) error {
+	if _, err := s.ListVoices(ctx, token); err != nil {
+		return err
+	}
+	s.checkVoice(s.providerVoice(s.defaultVoice))
+	return nil
+}
+
+func (s *TTSService) checkVoice(voice string) {
+	s.voicesMu.Lock()
+	voices := s.voices
+	s.voicesMu.Unlock()
+	if len(voices) == 0 {
+		// nothing cached yet, cannot validate
+		return
+	}
+
+	for _, info := range voices {
+		if info.VoiceType == voice {
+			return
+		}
+	}
+	if _, warned := s.warnedVoices.LoadOrStore(voice, struct{}{}); !warned {
+		s.logger.Warnf("tts voice %q is not offered by the %s provider; synthesis may fail", voice, s.provider.Name())
+	}
+}
+
+// Name implements TTSProvider.
+func (s *ttsService) Name() string {
+	return TTSProviderQiniu
+}
+
+// Breaker returns the circuit breaker guarding the TTS API.
+func (s *ttsService) Breaker() *CircuitBreaker {
+	return s.breaker
 }
 
-func (s *ttsService) synthesize(ctx context.Context, token string, req TTSRequest) (*TTSResult, error) {
+// Synthesize implements TTSProvider with Qiniu's /voice/tts.
+func (s *ttsService) Synthesize(ctx context.Context, token string, req TTSRequest) (*TTSResult, error) {
 	if strings.TrimSpace(token) == "" {
 		return nil, fmt.Errorf("authorization token is required")
 	}
@@ -103,6 +311,9 @@ func (s *ttsService) synthesize(ctx context.Context, token string, req TTSReques
 	if text == "" {
 		return nil, fmt.Errorf("tts text cannot be empty")
 	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
 
 	voice := strings.TrimSpace(req.VoiceType)
 	if voice == "" {
@@ -119,12 +330,158 @@ func (s *ttsService) synthesize(ctx context.Context, token string, req TTSReques
 		speed = 1.0
 	}
 
+	params := ttsAudioParams{
+		voice:    voice,
+		encoding: encoding,
+		speed:    speed,
+		pitch:    req.PitchRatio,
+		volume:   req.VolumeRatio,
+		emotion:  strings.TrimSpace(req.Emotion),
+	}
+
+	var (
+		result *TTSResult
+		err    error
+	)
+	chunks := ChunkText(text, s.maxChars)
+	if len(chunks) <= 1 {
+		result, err = s.synthesizeChunk(ctx, token, text, params)
+	} else {
+		result, err = synthesizeChunks(ctx, chunks, encoding, func(ctx context.Context, text string) (*TTSResult, error) {
+			return s.synthesizeChunk(ctx, token, text, params)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Encoding = encoding
+	return result, nil
+}
+
+// AudioContentType maps a TTS encoding to its MIME type.
+func AudioContentType(encoding string) string {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "mp3", "mpeg":
+		return "audio/mpeg"
+	case "wav", "pcm":
+		return "audio/wav"
+	case "ogg", "ogg_opus", "opus":
+		return "audio/ogg"
+	case "aac":
+		return "audio/aac"
+	case "flac":
+		return "audio/flac"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// ttsChunkInfo is the per-chunk breakdown reported in Raw for chunked synthesis.
+type ttsChunkInfo struct {
+	Index    int    `json:"index"`
+	Chars    int    `json:"chars"`
+	ReqID    string `json:"reqid"`
+	Duration string `json:"duration"`
+	Bytes    int    `json:"bytes"`
+}
+
+// synthesizeChunks synthesizes over-long text piece by piece with synthesize, in order, and
+// joins the audio. PCM and MP3/OGG streams are concatenated as-is; WAV chunks are merged
+// under a single header.
+func synthesizeChunks(ctx context.Context, chunks []string, encoding string, synthesize func(ctx context.Context, text string) (*TTSResult, error)) (*TTSResult, error) {
+	var (
+		audio      []byte
+		wavPCM     []byte
+		wavFmt     wavFormat
+		totalMS    int
+		durationOK = true
+		infos      = make([]ttsChunkInfo, 0, len(chunks))
+		reqIDs     = make([]string, 0, len(chunks))
+	)
+	isWAV := strings.EqualFold(encoding, "wav")
+
+	for i, chunk := range chunks {
+		part, err := synthesize(ctx, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("synthesize chunk %d/%d: %w", i+1, len(chunks), err)
+		}
+
+		if isWAV {
+			format, pcm, err := parseWAV(part.Audio)
+			if err != nil {
+				return nil, fmt.Errorf("decode wav chunk %d: %w", i+1, err)
+			}
+			wavFmt = format
+			wavPCM = append(wavPCM, pcm...)
+		} else {
+			audio = append(audio, part.Audio...)
+		}
+
+		if ms, err := strconv.Atoi(strings.TrimSpace(part.Duration)); err == nil {
+			totalMS += ms
+		} else {
+			durationOK = false
+		}
+		reqIDs = append(reqIDs, part.ReqID)
+		infos = append(infos, ttsChunkInfo{
+			Index:    i,
+			Chars:    utf8.RuneCountInString(chunk),
+			ReqID:    part.ReqID,
+			Duration: part.Duration,
+			Bytes:    len(part.Audio),
+		})
+	}
+	if isWAV {
+		audio = encodeWAV(wavFmt, wavPCM)
+	}
+
+	raw, err := json.Marshal(map[string]interface{}{"chunked": true, "chunks": infos})
+	if err != nil {
+		return nil, fmt.Errorf("marshal chunk breakdown: %w", err)
+	}
+
+	result := &TTSResult{
+		ReqID: strings.Join(reqIDs, ","),
+		Audio: audio,
+		Raw:   raw,
+	}
+	if durationOK {
+		result.Duration = strconv.Itoa(totalMS)
+	}
+	return result, nil
+}
+
+// ttsAudioParams are the resolved "audio" settings sent with every chunk.
+type ttsAudioParams struct {
+	voice    string
+	encoding string
+	speed    float64
+	pitch    float64
+	volume   float64
+	emotion  string
+}
+
+func (p ttsAudioParams) payload() map[string]interface{} {
+	audio := map[string]interface{}{
+		"voice_type":  p.voice,
+		"encoding":    p.encoding,
+		"speed_ratio": p.speed,
+	}
+	if p.pitch > 0 {
+		audio["pitch_ratio"] = p.pitch
+	}
+	if p.volume > 0 {
+		audio["volume_ratio"] = p.volume
+	}
+	if p.emotion != "" {
+		audio["emotion"] = p.emotion
+	}
+	return audio
+}
+
+func (s *ttsService) synthesizeChunk(ctx context.Context, token, text string, params ttsAudioParams) (*TTSResult, error) {
 	payload := map[string]interface{}{
-		"audio": map[string]interface{}{
-			"voice_type":  voice,
-			"encoding":    encoding,
-			"speed_ratio": speed,
-		},
+		"audio": params.payload(),
 		"request": map[string]interface{}{
 			"text": text,
 		},
@@ -142,6 +499,7 @@ func (s *ttsService) synthesize(ctx context.Context, token string, req TTSReques
 	}
 
 	reqHTTP.Header.Set("Authorization", "Bearer "+strings.TrimSpace(token))
+	setRequestID(ctx, reqHTTP.Header)
 	reqHTTP.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.client.Do(reqHTTP)
@@ -187,7 +545,8 @@ func (s *ttsService) synthesize(ctx context.Context, token string, req TTSReques
 	return result, nil
 }
 
-func (s *ttsService) listVoices(ctx context.Context, token string) ([]VoiceInfo, error) {
+// ListVoices implements TTSProvider with Qiniu's /voice/list.
+func (s *ttsService) ListVoices(ctx context.Context, token string) ([]VoiceInfo, error) {
 	if strings.TrimSpace(token) == "" {
 		return nil, fmt.Errorf("authorization token is required")
 	}
@@ -199,6 +558,7 @@ func (s *ttsService) listVoices(ctx context.Context, token string) ([]VoiceInfo,
 	}
 
 	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(token))
+	setRequestID(ctx, req.Header)
 
 	resp, err := s.client.Do(req)
 	if err != nil {
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TTS playback targets a TTS request may name as its target hint.
+const (
+	TTSTargetWeb     = "web"
+	TTSTargetIOS     = "ios"
+	TTSTargetAndroid = "android"
+)
+
+// DefaultTTSTarget is the target of requests that name none.
+const DefaultTTSTarget = TTSTargetWeb
+
+// TTSTargets lists the playback targets, for error responses.
+var TTSTargets = []string{TTSTargetWeb, TTSTargetIOS, TTSTargetAndroid}
+
+// TTSEncodings are the encodings a TTS request may ask for: those AudioContentType can
+// label, aliases included.
+var TTSEncodings = []string{"mp3", "mpeg", "wav", "pcm", "ogg", "ogg_opus", "opus", "aac", "flac"}
+
+// playableEncodings lists, per target, the encoding families it plays natively, the safe
+// default first. Safari (and so every iOS browser) plays no Ogg.
+var playableEncodings = map[string][]string{
+	TTSTargetWeb:     {"mp3", "wav", "aac", "flac"},
+	TTSTargetIOS:     {"mp3", "aac", "wav"},
+	TTSTargetAndroid: {"mp3", "ogg", "aac", "wav", "flac"},
+}
+
+// ttsPCMLayout is the layout raw pcm from the TTS providers is taken to have: 24kHz,
+// 16-bit mono.
+var ttsPCMLayout = wavFormat{SampleRate: openAIPCMSampleRate, Channels: 1, Bits: 16}
+
+// validTTSEncoding returns an ErrInvalidTTSOptions error listing TTSEncodings when
+// encoding is set but not one of them.
+func validTTSEncoding(encoding string) error {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" || slices.Contains(TTSEncodings, encoding) {
+		return nil
+	}
+	return fmt.Errorf("%w: encoding %q is not supported, use one of %s", ErrInvalidTTSOptions, encoding, strings.Join(TTSEncodings, ", "))
+}
+
+// PlaybackEncoding returns the encoding to request for a client playing on target. An
+// encoding the target plays is kept, anything else (including none) becomes the target's
+// safe default; pcm is kept too, since PreparePlayback turns it into WAV. An empty target
+// is DefaultTTSTarget.
+func PlaybackEncoding(target, encoding string) (string, error) {
+	if err := validTTSEncoding(encoding); err != nil {
+		return "", err
+	}
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	target = strings.ToLower(strings.TrimSpace(target))
+	if target == "" {
+		target = DefaultTTSTarget
+	}
+	playable, ok := playableEncodings[target]
+	if !ok {
+		return "", fmt.Errorf("%w: target %q is not supported, use one of %s", ErrInvalidTTSOptions, target, strings.Join(TTSTargets, ", "))
+	}
+	if encoding == "pcm" || slices.Contains(playable, encodingFamily(encoding)) {
+		return encoding, nil
+	}
+	return playable[0], nil
+}
+
+// encodingFamily folds the aliases of an encoding onto one name.
+func encodingFamily(encoding string) string {
+	switch encoding {
+	case "mpeg":
+		return "mp3"
+	case "ogg_opus", "opus":
+		return "ogg"
+	default:
+		return encoding
+	}
+}
+
+// PreparePlayback makes result directly playable: headerless pcm is wrapped in a WAV
+// header. Other encodings are left alone.
+func PreparePlayback(result *TTSResult) {
+	if result == nil || !strings.EqualFold(result.Encoding, "pcm") {
+		return
+	}
+	result.Audio = WrapPCM(result.Audio, ttsPCMLayout.SampleRate, ttsPCMLayout.Channels)
+	result.Encoding = "wav"
+}
+
+// WrapPCM prefixes raw 16-bit samples recorded at sampleRate over channels with a
+// RIFF/WAVE header.
+func WrapPCM(pcm []byte, sampleRate, channels int) []byte {
+	return encodeWAV(wavFormat{SampleRate: sampleRate, Channels: channels, Bits: 16}, pcm)
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestPlaybackEncoding(t *testing.T) {
+	// every requested encoding against every target, empty ones included
+	want := map[string]map[string]string{
+		"": {
+			"": "mp3", "mp3": "mp3", "mpeg": "mpeg", "wav": "wav", "pcm": "pcm", "ogg": "mp3",
+			"ogg_opus": "mp3", "opus": "mp3", "aac": "aac", "flac": "flac",
+		},
+		TTSTargetWeb: {
+			"": "mp3", "mp3": "mp3", "mpeg": "mpeg", "wav": "wav", "pcm": "pcm", "ogg": "mp3",
+			"ogg_opus": "mp3", "opus": "mp3", "aac": "aac", "flac": "flac",
+		},
+		TTSTargetIOS: {
+			"": "mp3", "mp3": "mp3", "mpeg": "mpeg", "wav": "wav", "pcm": "pcm", "ogg": "mp3",
+			"ogg_opus": "mp3", "opus": "mp3", "aac": "aac", "flac": "mp3",
+		},
+		TTSTargetAndroid: {
+			"": "mp3", "mp3": "mp3", "mpeg": "mpeg", "wav": "wav", "pcm": "pcm", "ogg": "ogg",
+			"ogg_opus": "ogg_opus", "opus": "opus", "aac": "aac", "flac": "flac",
+		},
+	}
+	for target, encodings := range want {
+		if len(encodings) != len(TTSEncodings)+1 {
+			t.Fatalf("target %q: the matrix misses encodings", target)
+		}
+		for encoding, expected := range encodings {
+			got, err := PlaybackEncoding(target, encoding)
+			if err != nil || got != expected {
+				t.Errorf("PlaybackEncoding(%q, %q) = %q, %v; want %q", target, encoding, got, err, expected)
+			}
+		}
+	}
+
+	if got, err := PlaybackEncoding(" IOS ", " OGG "); err != nil || got != "mp3" {
+		t.Errorf("PlaybackEncoding ignores case and spaces: got %q, %v", got, err)
+	}
+	if _, err := PlaybackEncoding("desktop", "mp3"); !errors.Is(err, ErrInvalidTTSOptions) {
+		t.Errorf("unknown target = %v, want ErrInvalidTTSOptions", err)
+	}
+	if _, err := PlaybackEncoding("web", "midi"); !errors.Is(err, ErrInvalidTTSOptions) {
+		t.Errorf("unknown encoding = %v, want ErrInvalidTTSOptions", err)
+	}
+}
+
+func TestWrapPCM(t *testing.T) {
+	pcm := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	for _, layout := range []struct{ rate, channels int }{{24000, 1}, {16000, 1}, {44100, 2}} {
+		wav := WrapPCM(pcm, layout.rate, layout.channels)
+		if len(wav) != 44+len(pcm) || !bytes.Equal(wav[44:], pcm) {
+			t.Fatalf("%d Hz x%d: the samples do not follow a 44 byte header", layout.rate, layout.channels)
+		}
+		le := binary.LittleEndian
+		header := []struct {
+			name      string
+			got, want uint32
+		}{
+			{"RIFF size", le.Uint32(wav[4:8]), uint32(36 + len(pcm))},
+			{"fmt size", le.Uint32(wav[16:20]), 16},
+			{"format", uint32(le.Uint16(wav[20:22])), 1},
+			{"channels", uint32(le.Uint16(wav[22:24])), uint32(layout.channels)},
+			{"sample rate", le.Uint32(wav[24:28]), uint32(layout.rate)},
+			{"byte rate", le.Uint32(wav[28:32]), uint32(layout.rate * layout.channels * 2)},
+			{"block align", uint32(le.Uint16(wav[32:34])), uint32(layout.channels * 2)},
+			{"bits", uint32(le.Uint16(wav[34:36])), 16},
+			{"data size", le.Uint32(wav[40:44]), uint32(len(pcm))},
+		}
+		if string(wav[0:4]) != "RIFF" || string(wav[8:16]) != "WAVEfmt " || string(wav[36:40]) != "data" {
+			t.Errorf("%d Hz x%d: chunk ids = %q", layout.rate, layout.channels, wav[:40])
+		}
+		for _, field := range header {
+			if field.got != field.want {
+				t.Errorf("%d Hz x%d: %s = %d, want %d", layout.rate, layout.channels, field.name, field.got, field.want)
+			}
+		}
+
+		format, data, err := parseWAV(wav)
+		if err != nil || format != (wavFormat{SampleRate: layout.rate, Channels: layout.channels, Bits: 16}) || !bytes.Equal(data, pcm) {
+			t.Errorf("%d Hz x%d: parseWAV = %+v, %v, %v", layout.rate, layout.channels, format, data, err)
+		}
+	}
+}
+
+func TestPreparePlayback(t *testing.T) {
+	result := &TTSResult{Audio: []byte{0, 0}, Encoding: "PCM"}
+	PreparePlayback(result)
+	format, _, err := parseWAV(result.Audio)
+	if err != nil || result.Encoding != "wav" || format != ttsPCMLayout {
+		t.Errorf("PreparePlayback of pcm = %+v in %q, %v; want %+v WAV", format, result.Encoding, err, ttsPCMLayout)
+	}
+
+	mp3 := &TTSResult{Audio: []byte("ID3"), Encoding: "mp3"}
+	PreparePlayback(mp3)
+	if string(mp3.Audio) != "ID3" || mp3.Encoding != "mp3" {
+		t.Errorf("PreparePlayback changed mp3 audio: %+v", mp3)
+	}
+	PreparePlayback(nil)
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wuwenbin0122/wwb.ai/ctxlog"
+	"github.com/wuwenbin0122/wwb.ai/kv"
+	"go.uber.org/zap"
+)
+
+const (
+	voiceCatalogTTL      = time.Hour
+	voiceCatalogDeadline = 300 * time.Millisecond
+)
+
+// VoiceFilter narrows a voice catalog; empty fields match everything.
+type VoiceFilter struct {
+	Category string
+	Language string
+	Query    string
+}
+
+// VoiceCatalog serves the TTS voice list from a shared Redis cache, falling back to the
+// provider's live list whenever the cache is cold, unreachable or bypassed.
+type VoiceCatalog struct {
+	tts    *TTSService
+	cache  *kv.Store
+	key    string
+	logger *zap.SugaredLogger
+}
+
+// NewVoiceCatalog builds a catalog; cache may be nil to disable the shared cache.
+func NewVoiceCatalog(tts *TTSService, cache *kv.Store, logger *zap.SugaredLogger) *VoiceCatalog {
+	return &VoiceCatalog{
+		tts:    tts,
+		cache:  cache,
+		key:    cache.Key(kv.VoiceCatalog, tts.catalogKey),
+		logger: logger,
+	}
+}
+
+// List returns the normalized catalog sorted by category then name. refresh skips every cache.
+func (c *VoiceCatalog) List(ctx context.Context, token string, refresh bool) ([]VoiceInfo, error) {
+	if !refresh {
+		if voices, ok := c.readCache(ctx); ok {
+			return voices, nil
+		}
+	}
+
+	var (
+		voices []VoiceInfo
+		err    error
+	)
+	if refresh {
+		voices, err = c.tts.RefreshVoices(ctx, token)
+	} else {
+		voices, err = c.tts.ListVoices(ctx, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	voices = normalizeVoices(voices)
+	c.writeCache(ctx, voices)
+	return voices, nil
+}
+
+func (c *VoiceCatalog) readCache(ctx context.Context) ([]VoiceInfo, bool) {
+	if !c.cache.Available() {
+		return nil, false
+	}
+	readCtx, cancel := context.WithTimeout(ctx, voiceCatalogDeadline)
+	defer cancel()
+
+	var voices []VoiceInfo
+	found, err := c.cache.GetJSON(readCtx, c.key, &voices)
+	if err != nil {
+		ctxlog.From(ctx, c.logger).Warnf("read voice catalog cache, calling upstream: %v", err)
+		return nil, false
+	}
+	return voices, found
+}
+
+func (c *VoiceCatalog) writeCache(ctx context.Context, voices []VoiceInfo) {
+	if !c.cache.Available() {
+		return
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, voiceCatalogDeadline)
+	defer cancel()
+	if err := c.cache.SetJSON(writeCtx, c.key, voices, voiceCatalogTTL); err != nil {
+		ctxlog.From(ctx, c.logger).Warnf("write voice catalog cache: %v", err)
+	}
+}
+
+// FilterVoices applies f to voices, preserving order. Matching is case-insensitive;
+// Query matches the voice name or type as a substring.
+func FilterVoices(voices []VoiceInfo, f VoiceFilter) []VoiceInfo {
+	category := strings.ToLower(strings.TrimSpace(f.Category))
+	language := strings.ToLower(strings.TrimSpace(f.Language))
+	query := strings.ToLower(strings.TrimSpace(f.Query))
+
+	result := make([]VoiceInfo, 0, len(voices))
+	for _, voice := range voices {
+		if category != "" && strings.ToLower(voice.Category) != category {
+			continue
+		}
+		if language != "" && voice.Language != language {
+			continue
+		}
+		if query != "" &&
+			!strings.Contains(strings.ToLower(voice.VoiceName), query) &&
+			!strings.Contains(strings.ToLower(voice.VoiceType), query) {
+			continue
+		}
+		result = append(result, voice)
+	}
+	return result
+}
+
+// normalizeVoices trims fields, derives the language from the voice type
+// (e.g. qiniu_zh_female_tmjxxy -> zh) and sorts by category, then name.
+func normalizeVoices(voices []VoiceInfo) []VoiceInfo {
+	result := make([]VoiceInfo, 0, len(voices))
+	for _, voice := range voices {
+		voice.VoiceType = strings.TrimSpace(voice.VoiceType)
+		if voice.VoiceType == "" {
+			continue
+		}
+		voice.VoiceName = strings.TrimSpace(voice.VoiceName)
+		if voice.VoiceName == "" {
+			voice.VoiceName = voice.VoiceType
+		}
+		voice.Category = strings.TrimSpace(voice.Category)
+		if voice.Language == "" {
+			if parts := strings.Split(voice.VoiceType, "_"); len(parts) >= 3 {
+				voice.Language = strings.ToLower(parts[1])
+			}
+		}
+		result = append(result, voice)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Category != result[j].Category {
+			return result[i].Category < result[j].Category
+		}
+		return result[i].VoiceName < result[j].VoiceName
+	})
+	return result
+}
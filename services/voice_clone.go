@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/wuwenbin0122/wwb.ai/config"
+)
+
+// Custom voice states. Cloning runs asynchronously upstream: a voice is pending until the
+// provider reports it ready or failed.
+const (
+	VoiceClonePending = "pending"
+	VoiceCloneReady   = "ready"
+	VoiceCloneFailed  = "failed"
+)
+
+// VoiceClone is the upstream state of a custom voice.
+type VoiceClone struct {
+	VoiceType string `json:"voice_type"`
+	Status    string `json:"status"`
+	// Message explains a failure, as the provider put it.
+	Message string `json:"message,omitempty"`
+}
+
+// VoiceCloneClient registers custom voices with Qiniu's voice clone API: POST
+// /voice/clone starts cloning a voice from reference audio, GET /voice/clone/{voice_type}
+// reports its progress and DELETE /voice/clone/{voice_type} removes it.
+type VoiceCloneClient struct {
+	baseURL string
+	client  httpDoer
+}
+
+// NewVoiceCloneClient builds a client for the configured Qiniu API.
+func NewVoiceCloneClient(cfg *config.Config) *VoiceCloneClient {
+	base := strings.TrimRight(cfg.QiniuAPIBaseURL, "/")
+	if base == "" {
+		base = "https://openai.qiniu.com/v1"
+	}
+	return &VoiceCloneClient{baseURL: base, client: newDefaultHTTPClient()}
+}
+
+type voiceCloneAPIRequest struct {
+	VoiceName string `json:"voice_name"`
+	AudioURL  string `json:"audio_url"`
+}
+
+type voiceCloneAPIResponse struct {
+	VoiceType string `json:"voice_type"`
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+}
+
+// Create starts cloning a voice called name from the reference audio at audioURL.
+func (c *VoiceCloneClient) Create(ctx context.Context, token, name, audioURL string) (*VoiceClone, error) {
+	body, err := json.Marshal(voiceCloneAPIRequest{VoiceName: name, AudioURL: audioURL})
+	if err != nil {
+		return nil, fmt.Errorf("encode voice clone request: %w", err)
+	}
+	clone, err := c.call(ctx, token, http.MethodPost, "/voice/clone", body)
+	if err != nil {
+		return nil, err
+	}
+	if clone.VoiceType == "" {
+		return nil, fmt.Errorf("voice clone response has no voice_type")
+	}
+	return clone, nil
+}
+
+// Status reports the progress of cloning voiceType.
+func (c *VoiceCloneClient) Status(ctx context.Context, token, voiceType string) (*VoiceClone, error) {
+	clone, err := c.call(ctx, token, http.MethodGet, "/voice/clone/"+url.PathEscape(voiceType), nil)
+	if err != nil {
+		return nil, err
+	}
+	if clone.VoiceType == "" {
+		clone.VoiceType = voiceType
+	}
+	return clone, nil
+}
+
+// Delete removes voiceType upstream; a voice the provider no longer knows is not an error.
+func (c *VoiceCloneClient) Delete(ctx context.Context, token, voiceType string) error {
+	_, err := c.call(ctx, token, http.MethodDelete, "/voice/clone/"+url.PathEscape(voiceType), nil)
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) && upstream.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func (c *VoiceCloneClient) call(ctx context.Context, token, method, path string, body []byte) (*VoiceClone, error) {
+	if strings.TrimSpace(token) == "" {
+		return nil, fmt.Errorf("authorization token is required")
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("create voice clone request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(token))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	setRequestID(ctx, req.Header)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("call voice clone api: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read voice clone response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, buildQiniuAPIError(resp.StatusCode, raw)
+	}
+
+	var decoded voiceCloneAPIResponse
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			return nil, fmt.Errorf("decode voice clone response: %w", err)
+		}
+	}
+	return &VoiceClone{
+		VoiceType: strings.TrimSpace(decoded.VoiceType),
+		Status:    voiceCloneStatus(decoded.Status),
+		Message:   strings.TrimSpace(decoded.Message),
+	}, nil
+}
+
+// voiceCloneStatus folds the provider's status names onto the VoiceClone states; anything
+// unrecognized is still in progress.
+func voiceCloneStatus(status string) string {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "ready", "success", "succeeded", "done", "completed", "active":
+		return VoiceCloneReady
+	case "failed", "fail", "error", "rejected":
+		return VoiceCloneFailed
+	default:
+		return VoiceClonePending
+	}
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wuwenbin0122/wwb.ai/apierr"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+)
+
+// Voice pipeline stage names reported when a turn fails.
+const (
+	VoiceStageASR  = "asr"
+	VoiceStageChat = "chat"
+	VoiceStageTTS  = "tts"
+)
+
+// ErrNoSpeech is returned by the ASR stage when the audio contained no recognizable speech.
+var ErrNoSpeech = apierr.New(apierr.CodeNoSpeech, "no speech recognized")
+
+// VoiceStageObserver is told about every failed pipeline stage with the code the error is
+// reported with; metrics.VoicePipeline implements it.
+type VoiceStageObserver interface {
+	StageFailed(stage string, code apierr.Code)
+}
+
+// VoiceStageError records which stage of the voice pipeline failed.
+type VoiceStageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *VoiceStageError) Error() string {
+	return fmt.Sprintf("voice pipeline %s stage: %v", e.Stage, e.Err)
+}
+
+func (e *VoiceStageError) Unwrap() error { return e.Err }
+
+// Retryable reports whether running the same turn again is likely to succeed:
+// timeouts, capacity limits, rate limits, open circuits and upstream 5xx are transient; bad input,
+// auth failures and silent audio are not.
+func (e *VoiceStageError) Retryable() bool {
+	if errors.Is(e.Err, ErrNoSpeech) {
+		return false
+	}
+	if errors.Is(e.Err, context.DeadlineExceeded) || errors.Is(e.Err, ErrTooManyStreams) ||
+		errors.Is(e.Err, ErrUpstreamUnavailable) {
+		return true
+	}
+	var upstream *UpstreamError
+	if errors.As(e.Err, &upstream) {
+		return upstream.Temporary()
+	}
+	return false
+}
+
+func (p *VoicePipeline) stageError(stage string, err error) error {
+	// cancellations are the caller hanging up or barging in, not failures
+	if p.observer != nil && !errors.Is(err, context.Canceled) {
+		p.observer.StageFailed(stage, apierr.CodeOf(err, apierr.CodeUpstream))
+	}
+	return &VoiceStageError{Stage: stage, Err: err}
+}
+
+// VoiceTurnRequest describes one spoken turn: the audio to transcribe, the chat context and the speech settings.
+// Chat.UserMessage and Speech.Text are filled in by the pipeline.
+type VoiceTurnRequest struct {
+	Audio  ASRInput
+	Chat   NLPRequest
+	Speech TTSRequest
+}
+
+// VoiceTurnResult bundles the outputs of every stage of a voice turn.
+type VoiceTurnResult struct {
+	Transcript *ASRResult
+	Reply      *NLPResponse
+	Speech     *TTSResult
+}
+
+// VoicePipeline chains ASR, chat completion and TTS into a single call.
+type VoicePipeline struct {
+	asr      *ASRService
+	nlp      *NLPService
+	tts      *TTSService
+	observer VoiceStageObserver
+}
+
+// NewVoicePipeline wires the three voice services together.
+func NewVoicePipeline(asr *ASRService, nlp *NLPService, tts *TTSService) *VoicePipeline {
+	return &VoicePipeline{asr: asr, nlp: nlp, tts: tts}
+}
+
+// ObserveFailures reports every stage failure of the pipeline to observer.
+func (p *VoicePipeline) ObserveFailures(observer VoiceStageObserver) {
+	p.observer = observer
+}
+
+// RunTurn transcribes the audio, generates the role's reply and synthesizes it.
+// All stages share ctx, so a single deadline bounds the whole turn.
+func (p *VoicePipeline) RunTurn(ctx context.Context, token string, req VoiceTurnRequest) (*VoiceTurnResult, error) {
+	transcript, err := p.Transcribe(ctx, token, req.Audio)
+	if err != nil {
+		return nil, err
+	}
+
+	chatReq := req.Chat
+	chatReq.UserMessage = transcript.Text
+	reply, err := p.Respond(ctx, token, chatReq)
+	if err != nil {
+		return nil, err
+	}
+
+	speechReq := req.Speech
+	// strip Markdown so the voice does not read list markers and asterisks aloud; of a
+	// structured reply only the speech is read, never the actions
+	speechReq.Text = SanitizeSpeechText(reply.SpeechText())
+	speech, err := p.Speak(ctx, token, speechReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VoiceTurnResult{Transcript: transcript, Reply: reply, Speech: speech}, nil
+}
+
+// Transcribe runs the ASR stage and rejects empty transcripts.
+func (p *VoicePipeline) Transcribe(ctx context.Context, token string, input ASRInput) (*ASRResult, error) {
+	transcript, err := p.asr.Recognize(ctx, token, input)
+	if err != nil {
+		return nil, p.stageError(VoiceStageASR, err)
+	}
+	if strings.TrimSpace(transcript.Text) == "" {
+		return nil, p.stageError(VoiceStageASR, ErrNoSpeech)
+	}
+	return transcript, nil
+}
+
+// OpenStream opens a streaming ASR session for callers that feed audio incrementally.
+func (p *VoicePipeline) OpenStream(ctx context.Context, token string, opts ASRStreamOptions) (*ASRStream, error) {
+	stream, err := p.asr.OpenStream(ctx, token, opts)
+	if err != nil {
+		return nil, p.stageError(VoiceStageASR, err)
+	}
+	return stream, nil
+}
+
+// Respond runs the chat stage.
+func (p *VoicePipeline) Respond(ctx context.Context, token string, req NLPRequest) (*NLPResponse, error) {
+	reply, err := p.nlp.GenerateReply(ctx, token, req)
+	if err != nil {
+		return nil, p.stageError(VoiceStageChat, err)
+	}
+	return reply, nil
+}
+
+// VoiceFor resolves the speech settings for role; see TTSService.ResolveVoice.
+func (p *VoicePipeline) VoiceFor(req TTSRequest, role *models.Role) TTSRequest {
+	return p.tts.ResolveVoice(req, role)
+}
+
+// Speak runs the TTS stage.
+func (p *VoicePipeline) Speak(ctx context.Context, token string, req TTSRequest) (*TTSResult, error) {
+	speech, err := p.tts.Synthesize(ctx, token, req)
+	if err != nil {
+		return nil, p.stageError(VoiceStageTTS, err)
+	}
+	return speech, nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// wavFormat captures the PCM layout declared by a WAV file's fmt chunk.
+type wavFormat struct {
+	SampleRate int
+	Channels   int
+	Bits       int
+}
+
+// parseWAV walks the RIFF chunks of a WAV file and returns its PCM layout and raw sample data.
+func parseWAV(data []byte) (wavFormat, []byte, error) {
+	var format wavFormat
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return format, nil, fmt.Errorf("not a RIFF/WAVE file")
+	}
+
+	var (
+		pcm     []byte
+		haveFmt bool
+	)
+	offset := 12
+	for offset+8 <= len(data) {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := offset + 8
+		end := body + size
+		if size < 0 || end > len(data) {
+			// tolerate truncated trailing chunks (common for streamed recordings)
+			end = len(data)
+		}
+
+		switch id {
+		case "fmt ":
+			if end-body < 16 {
+				return format, nil, fmt.Errorf("fmt chunk too short")
+			}
+			if audioFormat := binary.LittleEndian.Uint16(data[body : body+2]); audioFormat != 1 {
+				return format, nil, fmt.Errorf("unsupported wav encoding %d (only PCM)", audioFormat)
+			}
+			format.Channels = int(binary.LittleEndian.Uint16(data[body+2 : body+4]))
+			format.SampleRate = int(binary.LittleEndian.Uint32(data[body+4 : body+8]))
+			format.Bits = int(binary.LittleEndian.Uint16(data[body+14 : body+16]))
+			haveFmt = true
+		case "data":
+			pcm = data[body:end]
+		}
+
+		// chunks are word aligned
+		offset = end + (size & 1)
+	}
+
+	if !haveFmt {
+		return format, nil, fmt.Errorf("wav fmt chunk missing")
+	}
+	if pcm == nil {
+		return format, nil, fmt.Errorf("wav data chunk missing")
+	}
+
+	return format, pcm, nil
+}
+
+// encodeWAV wraps PCM samples in a canonical 44-byte RIFF/WAVE header.
+func encodeWAV(format wavFormat, pcm []byte) []byte {
+	blockAlign := format.Channels * format.Bits / 8
+	out := make([]byte, 44, 44+len(pcm))
+	copy(out[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(out[4:8], uint32(36+len(pcm)))
+	copy(out[8:12], "WAVE")
+	copy(out[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(out[16:20], 16)
+	binary.LittleEndian.PutUint16(out[20:22], 1)
+	binary.LittleEndian.PutUint16(out[22:24], uint16(format.Channels))
+	binary.LittleEndian.PutUint32(out[24:28], uint32(format.SampleRate))
+	binary.LittleEndian.PutUint32(out[28:32], uint32(format.SampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(out[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(out[34:36], uint16(format.Bits))
+	copy(out[36:40], "data")
+	binary.LittleEndian.PutUint32(out[40:44], uint32(len(pcm)))
+	return append(out, pcm...)
+}
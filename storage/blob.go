@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BlobStore persists uploaded files and returns the URL they are served from. Keys are
+// slash-separated relative paths such as "avatars/12-ab34.png".
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) (string, error)
+	Delete(ctx context.Context, key string) error
+	// PresignPut lets a client upload key directly to the store, bypassing the API server.
+	// Stores that cannot do that return ErrPresignUnsupported.
+	PresignPut(ctx context.Context, key string, constraints UploadConstraints) (*PresignedUpload, error)
+}
+
+// UploadConstraints are signed into a presigned upload, so the store rejects uploads that
+// break them.
+type UploadConstraints struct {
+	ContentType string
+	// Size is the exact byte count of the upload; callers check it against their limit
+	// before presigning.
+	Size int64
+	// Expiry is how long the upload URL stays valid.
+	Expiry time.Duration
+}
+
+// PresignedUpload is an upload URL a client sends the file to with Method and Headers.
+type PresignedUpload struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// Headers must be sent exactly as given; they are part of the signature.
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	// DownloadURL is where the object can be fetched once uploaded.
+	DownloadURL string `json:"download_url"`
+}
+
+var (
+	// ErrInvalidKey is returned for keys that are empty or escape the store root.
+	ErrInvalidKey = errors.New("invalid blob key")
+	// ErrPresignUnsupported is returned by stores that only accept uploads through the server.
+	ErrPresignUnsupported = errors.New("blob store does not support presigned uploads")
+)
+
+// LocalStore writes blobs under a directory on disk; the server exposes that directory
+// under baseURL (for example /static).
+type LocalStore struct {
+	root    string
+	baseURL string
+}
+
+// NewLocalStore creates root if needed and returns a store serving from baseURL.
+func NewLocalStore(root, baseURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("create blob dir: %w", err)
+	}
+	return &LocalStore{root: root, baseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+// Root returns the directory blobs are written to.
+func (s *LocalStore) Root() string {
+	return s.root
+}
+
+func (s *LocalStore) resolve(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || strings.Contains(key, "..") {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+// Put writes body to key atomically (temp file plus rename) and returns its URL.
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, _ string) (string, error) {
+	target, err := s.resolve(key)
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", fmt.Errorf("create blob dir: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("write blob: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", fmt.Errorf("write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return "", fmt.Errorf("store blob: %w", err)
+	}
+
+	return s.baseURL + "/" + strings.TrimPrefix(path.Clean("/"+key), "/"), nil
+}
+
+// Delete removes key; a missing blob is not an error.
+func (s *LocalStore) Delete(_ context.Context, key string) error {
+	target, err := s.resolve(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete blob: %w", err)
+	}
+	return nil
+}
+
+// PresignPut is not supported: local blobs can only be written through the server.
+func (s *LocalStore) PresignPut(context.Context, string, UploadConstraints) (*PresignedUpload, error) {
+	return nil, ErrPresignUnsupported
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// maxPresignExpiry is the longest validity SigV4 allows a presigned URL.
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// S3Config configures an S3-compatible bucket.
+type S3Config struct {
+	// Endpoint is the service URL, such as https://s3.us-east-1.amazonaws.com.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the URL path instead of the host name.
+	PathStyle bool
+	// PublicBaseURL serves objects publicly; when empty, objects are addressed on the
+	// endpoint and download URLs are presigned for DownloadExpiry.
+	PublicBaseURL  string
+	DownloadExpiry time.Duration
+}
+
+// KodoEndpoint returns the S3-compatible endpoint of a Qiniu Kodo region such as cn-east-1.
+func KodoEndpoint(region string) string {
+	return "https://s3." + region + ".qiniucs.com"
+}
+
+// S3Store keeps blobs in an S3-compatible bucket, signing requests with AWS Signature
+// Version 4. Qiniu Kodo is served through its S3-compatible API.
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Store returns a store for the bucket cfg describes.
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("s3 region, bucket and keys are required")
+	}
+	if cfg.DownloadExpiry <= 0 {
+		cfg.DownloadExpiry = time.Hour
+	}
+	cfg.PublicBaseURL = strings.TrimRight(cfg.PublicBaseURL, "/")
+	return &S3Store{cfg: cfg, endpoint: endpoint, client: &http.Client{Timeout: 60 * time.Second}, now: time.Now}, nil
+}
+
+// objectURL returns the scheme and host an object is addressed on, and its escaped path.
+func (s *S3Store) objectURL(key string) (string, string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || strings.Contains(key, "..") {
+		return "", "", ErrInvalidKey
+	}
+	host := s.endpoint.Host
+	objectPath := uriEncode(clean, false)
+	if s.cfg.PathStyle {
+		objectPath = "/" + uriEncode(s.cfg.Bucket, true) + objectPath
+	} else {
+		host = s.cfg.Bucket + "." + host
+	}
+	return host, strings.TrimRight(s.endpoint.Path, "/") + objectPath, nil
+}
+
+// Put uploads body to key and returns its public URL.
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, contentType string) (string, error) {
+	// S3 needs the length up front; blobs written through the server are small
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("read blob: %w", err)
+	}
+	headers := map[string]string{"content-type": contentType}
+	if contentType == "" {
+		headers = nil
+	}
+	if err := s.do(ctx, http.MethodPut, key, headers, data); err != nil {
+		return "", fmt.Errorf("store blob: %w", err)
+	}
+	return s.publicURL(key), nil
+}
+
+// Delete removes key; S3 does not report missing objects.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := s.do(ctx, http.MethodDelete, key, nil, nil); err != nil {
+		return fmt.Errorf("delete blob: %w", err)
+	}
+	return nil
+}
+
+// PresignPut signs a PUT of key whose Content-Type and Content-Length must match
+// constraints, valid for constraints.Expiry.
+func (s *S3Store) PresignPut(_ context.Context, key string, constraints UploadConstraints) (*PresignedUpload, error) {
+	if constraints.Size <= 0 {
+		return nil, errors.New("upload size must be positive")
+	}
+	headers := map[string]string{
+		"content-length": strconv.FormatInt(constraints.Size, 10),
+		"content-type":   constraints.ContentType,
+	}
+	now := s.now().UTC()
+	expiry := min(max(constraints.Expiry, time.Second), maxPresignExpiry)
+	uploadURL, err := s.presign(http.MethodPut, key, headers, now, expiry)
+	if err != nil {
+		return nil, err
+	}
+	downloadURL, err := s.downloadURL(key, now)
+	if err != nil {
+		return nil, err
+	}
+	return &PresignedUpload{
+		Method:      http.MethodPut,
+		URL:         uploadURL,
+		Headers:     map[string]string{"Content-Type": constraints.ContentType, "Content-Length": headers["content-length"]},
+		ExpiresAt:   now.Add(expiry),
+		DownloadURL: downloadURL,
+	}, nil
+}
+
+func (s *S3Store) downloadURL(key string, now time.Time) (string, error) {
+	if s.cfg.PublicBaseURL != "" {
+		return s.publicURL(key), nil
+	}
+	return s.presign(http.MethodGet, key, nil, now, min(s.cfg.DownloadExpiry, maxPresignExpiry))
+}
+
+func (s *S3Store) publicURL(key string) string {
+	if s.cfg.PublicBaseURL != "" {
+		return s.cfg.PublicBaseURL + uriEncode(path.Clean("/"+key), false)
+	}
+	host, objectPath, err := s.objectURL(key)
+	if err != nil {
+		return ""
+	}
+	return s.endpoint.Scheme + "://" + host + objectPath
+}
+
+// presign returns a URL for method on key, signing headers (lower-case names) and host
+// in the query string.
+func (s *S3Store) presign(method, key string, headers map[string]string, now time.Time, expiry time.Duration) (string, error) {
+	host, objectPath, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	signed := withHost(headers, host)
+	names := sortedNames(signed)
+	amzDate := now.Format("20060102T150405Z")
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry/time.Second)))
+	query.Set("X-Amz-SignedHeaders", strings.Join(names, ";"))
+	canonicalQuery := canonicalQueryString(query)
+
+	signature := s.sign(now, canonicalRequest(method, objectPath, canonicalQuery, signed, names, unsignedPayload))
+	return s.endpoint.Scheme + "://" + host + objectPath + "?" + canonicalQuery + "&X-Amz-Signature=" + signature, nil
+}
+
+// do sends an authenticated request for key with body as its payload.
+func (s *S3Store) do(ctx context.Context, method, key string, headers map[string]string, body []byte) error {
+	host, objectPath, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	now := s.now().UTC()
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+
+	signed := withHost(headers, host)
+	signed["x-amz-content-sha256"] = payloadHash
+	signed["x-amz-date"] = now.Format("20060102T150405Z")
+	names := sortedNames(signed)
+	signature := s.sign(now, canonicalRequest(method, objectPath, "", signed, names, payloadHash))
+
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint.Scheme+"://"+host+objectPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name != "host" {
+			req.Header.Set(name, signed[name])
+		}
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.cfg.AccessKey, s.scope(now), strings.Join(names, ";"), signature))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: status %d: %s", method, key, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (s *S3Store) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// sign returns the SigV4 signature of a canonical request made at now.
+func (s *S3Store) sign(now time.Time, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := sigV4Algorithm + "\n" + now.Format("20060102T150405Z") + "\n" + s.scope(now) + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalRequest(method, objectPath, query string, headers map[string]string, names []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(method + "\n" + objectPath + "\n" + query + "\n")
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	b.WriteString("\n" + strings.Join(names, ";") + "\n" + payloadHash)
+	return b.String()
+}
+
+func withHost(headers map[string]string, host string) map[string]string {
+	signed := make(map[string]string, len(headers)+3)
+	for name, value := range headers {
+		signed[strings.ToLower(name)] = value
+	}
+	signed["host"] = host
+	return signed
+}
+
+func sortedNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// canonicalQueryString sorts and encodes query the way SigV4 expects.
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and slashes unless
+// encodeSlash is set.
+func uriEncode(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
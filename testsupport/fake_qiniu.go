@@ -0,0 +1,418 @@
+// Package testsupport runs the server against a fake Qiniu API, so handlers and services
+// can be exercised end to end without credentials: FakeQiniu answers the endpoints the
+// server calls from a script, and Harness boots the full router against it for the
+// end-to-end tests in app.
+package testsupport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wuwenbin0122/wwb.ai/services"
+)
+
+// Endpoints of FakeQiniu, for Fail and Calls.
+const (
+	EndpointChat      = "chat"
+	EndpointTTS       = "tts"
+	EndpointVoiceList = "voice_list"
+	EndpointASR       = "asr"
+	EndpointASRStream = "asr_stream"
+)
+
+// Failure is an injected upstream failure. Status answers with that HTTP status and Body
+// (a Qiniu error envelope when empty); Delay holds the response back first; Drop closes
+// the connection without answering.
+type Failure struct {
+	Status int
+	Body   string
+	Delay  time.Duration
+	Drop   bool
+}
+
+// Request is a call FakeQiniu received.
+type Request struct {
+	Header http.Header
+	Body   []byte
+}
+
+// FakeQiniu is an HTTP and WebSocket server answering /chat/completions (streamed and
+// not), /voice/tts, /voice/list and /voice/asr (REST and WebSocket) from scriptable
+// responses. Failures queued with Fail are served first, one per call.
+type FakeQiniu struct {
+	// URL is the API base, to be used as QINIU_API_BASE_URL.
+	URL    string
+	server *httptest.Server
+
+	mu         sync.Mutex
+	replies    []string
+	rules      []chatRule
+	audio      []byte
+	voices     []services.VoiceInfo
+	transcript string
+	failures   map[string][]Failure
+	calls      map[string][]Request
+}
+
+// NewFakeQiniu starts a fake with default answers: the chat reply "Hello from the fake
+// Qiniu.", a few bytes of audio, one voice and the transcript "hello world". Close
+// stops it.
+func NewFakeQiniu() *FakeQiniu {
+	f := &FakeQiniu{
+		replies:    []string{"Hello from the fake Qiniu."},
+		audio:      []byte("fake-mp3-audio"),
+		voices:     []services.VoiceInfo{{VoiceName: "Fake Voice", VoiceType: "fake_voice", Category: "test"}},
+		transcript: "hello world",
+		failures:   make(map[string][]Failure),
+		calls:      make(map[string][]Request),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat/completions", f.handleChat)
+	mux.HandleFunc("/voice/tts", f.handleTTS)
+	mux.HandleFunc("/voice/list", f.handleVoiceList)
+	mux.HandleFunc("/voice/asr", f.handleASR)
+	f.server = httptest.NewServer(mux)
+	f.URL = f.server.URL
+	return f
+}
+
+// Close stops the server.
+func (f *FakeQiniu) Close() {
+	f.server.Close()
+}
+
+// SetChatReplies scripts the chat replies: each call takes the next one and the last one
+// repeats.
+func (f *FakeQiniu) SetChatReplies(replies ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append([]string(nil), replies...)
+}
+
+// chatRule answers the chat calls whose messages contain match.
+type chatRule struct {
+	match string
+	reply string
+}
+
+// ReplyTo answers every chat call with a message containing match with reply, ahead of
+// the scripted replies, so concurrent calls of different kinds get the right answer. The
+// earliest matching rule wins.
+func (f *FakeQiniu) ReplyTo(match, reply string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, chatRule{match: match, reply: reply})
+}
+
+// SetAudio sets the audio /voice/tts returns.
+func (f *FakeQiniu) SetAudio(audio []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.audio = append([]byte(nil), audio...)
+}
+
+// SetVoices sets the list /voice/list returns.
+func (f *FakeQiniu) SetVoices(voices ...services.VoiceInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.voices = append([]services.VoiceInfo(nil), voices...)
+}
+
+// SetTranscript sets the text recognized from any audio.
+func (f *FakeQiniu) SetTranscript(text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.transcript = text
+}
+
+// Fail queues failures for the next calls of endpoint, one per call.
+func (f *FakeQiniu) Fail(endpoint string, failures ...Failure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[endpoint] = append(f.failures[endpoint], failures...)
+}
+
+// Calls returns the requests endpoint received, oldest first.
+func (f *FakeQiniu) Calls(endpoint string) []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.calls[endpoint]...)
+}
+
+// begin records a call of endpoint and serves its next queued failure, if any. It
+// returns false when the failure answered the call.
+func (f *FakeQiniu) begin(endpoint string, w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.calls[endpoint] = append(f.calls[endpoint], Request{Header: r.Header.Clone(), Body: body})
+	var failure *Failure
+	if queued := f.failures[endpoint]; len(queued) > 0 {
+		failure = &queued[0]
+		f.failures[endpoint] = queued[1:]
+	}
+	f.mu.Unlock()
+
+	if failure == nil {
+		return body, true
+	}
+	if failure.Delay > 0 {
+		select {
+		case <-time.After(failure.Delay):
+		case <-r.Context().Done():
+			return nil, false
+		}
+	}
+	if failure.Drop {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				_ = conn.Close()
+				return nil, false
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+	status := failure.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	message := failure.Body
+	if message == "" {
+		message = fmt.Sprintf(`{"error":{"message":"injected %s failure","code":%d}}`, endpoint, status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, message)
+	return nil, false
+}
+
+func (f *FakeQiniu) nextReply(contents []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rule := range f.rules {
+		for _, content := range contents {
+			if strings.Contains(content, rule.match) {
+				return rule.reply
+			}
+		}
+	}
+	reply := f.replies[0]
+	if len(f.replies) > 1 {
+		f.replies = f.replies[1:]
+	}
+	return reply
+}
+
+func (f *FakeQiniu) handleChat(w http.ResponseWriter, r *http.Request) {
+	body, ok := f.begin(EndpointChat, w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Model    string `json:"model"`
+		Stream   bool   `json:"stream"`
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, `{"error":{"message":"invalid json"}}`, http.StatusBadRequest)
+		return
+	}
+	contents := make([]string, 0, len(req.Messages))
+	promptTokens := 0
+	for _, message := range req.Messages {
+		contents = append(contents, message.Content)
+		promptTokens += len(strings.Fields(message.Content))
+	}
+	reply := f.nextReply(contents)
+	usage := map[string]int{
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": len(strings.Fields(reply)),
+		"total_tokens":      promptTokens + len(strings.Fields(reply)),
+	}
+
+	if !req.Stream {
+		writeJSON(w, map[string]any{
+			"id":      "chatcmpl-fake",
+			"object":  "chat.completion",
+			"created": time.Now().Unix(),
+			"model":   req.Model,
+			"choices": []map[string]any{{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": reply},
+				"finish_reason": "stop",
+			}},
+			"usage": usage,
+		})
+		return
+	}
+
+	// one event per word, then the usage and the end marker
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	send := func(chunk any) {
+		encoded, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", encoded)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	words := strings.SplitAfter(reply, " ")
+	for i, word := range words {
+		choice := map[string]any{"index": 0, "delta": map[string]string{"role": "assistant", "content": word}}
+		if i == len(words)-1 {
+			choice["finish_reason"] = "stop"
+		}
+		send(map[string]any{"id": "chatcmpl-fake", "choices": []any{choice}})
+	}
+	send(map[string]any{"id": "chatcmpl-fake", "choices": []any{}, "usage": usage})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func (f *FakeQiniu) handleTTS(w http.ResponseWriter, r *http.Request) {
+	if _, ok := f.begin(EndpointTTS, w, r); !ok {
+		return
+	}
+	f.mu.Lock()
+	audio := f.audio
+	f.mu.Unlock()
+	writeJSON(w, map[string]any{
+		"reqid":     "tts-fake",
+		"operation": "query",
+		"sequence":  -1,
+		"data":      base64.StdEncoding.EncodeToString(audio),
+		"addition":  map[string]string{"duration": "1000"},
+	})
+}
+
+func (f *FakeQiniu) handleVoiceList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := f.begin(EndpointVoiceList, w, r); !ok {
+		return
+	}
+	f.mu.Lock()
+	voices := f.voices
+	f.mu.Unlock()
+	writeJSON(w, voices)
+}
+
+func (f *FakeQiniu) handleASR(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		f.handleASRStream(w, r)
+		return
+	}
+	if _, ok := f.begin(EndpointASR, w, r); !ok {
+		return
+	}
+	f.mu.Lock()
+	transcript := f.transcript
+	f.mu.Unlock()
+	writeJSON(w, map[string]any{
+		"reqid":     "asr-fake",
+		"operation": "asr",
+		"data": map[string]any{
+			"audio_info": map[string]int{"duration": 1000},
+			"result":     map[string]any{"text": transcript, "additions": map[string]string{"duration": "1000"}},
+		},
+	})
+}
+
+var fakeUpgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// handleASRStream speaks Qiniu's binary ASR protocol: after the config frame, every audio
+// frame is answered with an interim result revealing more of the transcript, and the stop
+// frame with the final result, after which the server closes.
+func (f *FakeQiniu) handleASRStream(w http.ResponseWriter, r *http.Request) {
+	if _, ok := f.begin(EndpointASRStream, w, r); !ok {
+		return
+	}
+	conn, err := fakeUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	f.mu.Lock()
+	words := strings.Fields(f.transcript)
+	f.mu.Unlock()
+
+	var seq int32
+	send := func(text string, final bool) error {
+		seq++
+		payload, _ := json.Marshal(map[string]any{"result": map[string]any{"text": text, "is_final": final}})
+		return conn.WriteMessage(websocket.BinaryMessage, asrServerFrame(seq, payload))
+	}
+	audioFrames := 0
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		messageType, err := asrClientMessageType(data)
+		if err != nil {
+			return
+		}
+		switch messageType {
+		case 2:
+			audioFrames++
+			shown := min(audioFrames, len(words))
+			if err := send(strings.Join(words[:shown], " "), false); err != nil {
+				return
+			}
+		case 4:
+			_ = send(strings.Join(words, " "), true)
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return
+		}
+	}
+}
+
+// asrClientMessageType returns the message type of a frame the server's ASR writer sent,
+// after checking its payload decompresses.
+func asrClientMessageType(data []byte) (byte, error) {
+	if len(data) < 4 {
+		return 0, fmt.Errorf("frame too short")
+	}
+	messageType := data[1] >> 4
+	if messageType == 4 || len(data) < 12 {
+		return messageType, nil
+	}
+	size := int(binary.BigEndian.Uint32(data[8:12]))
+	if size > len(data)-12 {
+		return 0, fmt.Errorf("frame size mismatch")
+	}
+	if data[2]&0x0F == 0x01 {
+		zr, err := gzip.NewReader(bytes.NewReader(data[12 : 12+size]))
+		if err != nil {
+			return 0, err
+		}
+		if _, err := io.Copy(io.Discard, zr); err != nil {
+			return 0, err
+		}
+	}
+	return messageType, nil
+}
+
+// asrServerFrame encodes a full server response frame: JSON, uncompressed, with a
+// sequence number and payload size.
+func asrServerFrame(seq int32, payload []byte) []byte {
+	frame := []byte{(1 << 4) | 1, (0x09 << 4) | 1, 1 << 4, 0}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(seq))
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
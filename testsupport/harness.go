@@ -0,0 +1,202 @@
+package testsupport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/wuwenbin0122/wwb.ai/app"
+	"github.com/wuwenbin0122/wwb.ai/config"
+	"github.com/wuwenbin0122/wwb.ai/db"
+	"github.com/wuwenbin0122/wwb.ai/db/models"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// HarnessQiniuKey is the server-side Qiniu key of a Harness; the fake expects it as the
+// bearer credential of every call.
+const HarnessQiniuKey = "fake-qiniu-key"
+
+// HarnessAdminToken is the admin token of a Harness.
+const HarnessAdminToken = "fake-admin-token"
+
+// harnessMongoURI points at a port nothing listens on.
+const harnessMongoURI = "mongodb://127.0.0.1:1/?connect=direct"
+
+// ScenarioRole is the role the end-to-end tests seed their harness with.
+var ScenarioRole = models.Role{
+	ID:        1,
+	Name:      "Test Sage",
+	Domain:    "testing",
+	Bio:       "A patient sage who answers test questions.",
+	Languages: []string{"en"},
+}
+
+// Harness is the full router, built by app.New and app.RegisterRoutes, serving over HTTP
+// against a FakeQiniu. Roles live in memory and there is no Postgres, Mongo or Redis:
+// features needing them degrade as they do when those are down, and routes that read
+// Postgres directly are out of its reach. Background workers are not started.
+type Harness struct {
+	// URL is the base of the server, for HTTP and WebSocket clients.
+	URL       string
+	Qiniu     *FakeQiniu
+	Roles     *db.MemoryRoleRepository
+	Config    *config.Config
+	Container *app.Container
+	// Routes lists every route the router registered.
+	Routes gin.RoutesInfo
+
+	server  *httptest.Server
+	mongo   *mongo.Client
+	blobDir string
+}
+
+// NewHarness boots the server with roles. The configuration starts from the process
+// environment and points at the fake with server-side tokens; configure, when not nil,
+// adjusts it before the server is built.
+func NewHarness(configure func(*config.Config), roles ...models.Role) (*Harness, error) {
+	blobDir, err := os.MkdirTemp("", "wwb-harness-")
+	if err != nil {
+		return nil, fmt.Errorf("create blob dir: %w", err)
+	}
+	qiniu := NewFakeQiniu()
+
+	cfg := config.FromEnv()
+	cfg.QiniuAPIBaseURL = qiniu.URL
+	cfg.QiniuAPIKey = HarnessQiniuKey
+	cfg.QiniuTokenMode = "server"
+	cfg.AdminToken = HarnessAdminToken
+	cfg.TTSProvider = "qiniu"
+	cfg.ASRProvider = "qiniu"
+	cfg.ChatProviders = nil
+	cfg.ChatProviderDefault = "qiniu"
+	cfg.EmbeddingModel = ""
+	cfg.BlobBackend = "local"
+	cfg.BlobDir = blobDir
+	if configure != nil {
+		configure(cfg)
+	}
+
+	// the client dials lazily and the dependency is never checked, so the Mongo stores
+	// report ErrUnavailable as they do while Mongo is down
+	mongoClient, err := db.OpenMongoClient(context.Background(), harnessMongoURI)
+	if err != nil {
+		qiniu.Close()
+		_ = os.RemoveAll(blobDir)
+		return nil, err
+	}
+
+	gin.SetMode(gin.TestMode)
+	repo := db.NewMemoryRoleRepository(roles...)
+	container := app.New(cfg, zap.NewNop().Sugar(), app.Clients{Mongo: mongoClient, Roles: repo})
+	router := gin.New()
+	app.RegisterRoutes(router, container)
+	server := httptest.NewServer(router)
+
+	return &Harness{
+		URL:       server.URL,
+		Qiniu:     qiniu,
+		Roles:     repo,
+		Config:    cfg,
+		Container: container,
+		Routes:    router.Routes(),
+		server:    server,
+		mongo:     mongoClient,
+		blobDir:   blobDir,
+	}, nil
+}
+
+// Close stops the server and the fake and removes the temporary files.
+func (h *Harness) Close() {
+	h.server.Close()
+	h.Qiniu.Close()
+	_ = h.mongo.Disconnect(context.Background())
+	_ = os.RemoveAll(h.blobDir)
+}
+
+// Response is a response of the server, read in full.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// JSON decodes the body into v.
+func (r *Response) JSON(v any) error {
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		return fmt.Errorf("decode %d response %q: %w", r.Status, truncate(r.Body), err)
+	}
+	return nil
+}
+
+// ErrorCode returns the apierr code of an error response, "" for other bodies.
+func (r *Response) ErrorCode() string {
+	var body struct {
+		Code string `json:"code"`
+	}
+	_ = json.Unmarshal(r.Body, &body)
+	return body.Code
+}
+
+// Do sends a request with body encoded as JSON unless it is nil, and reads the response.
+// header adds headers; it may be nil.
+func (h *Harness) Do(method, path string, body any, header http.Header) (*Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encode request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, h.URL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read %s %s: %w", method, path, err)
+	}
+	return &Response{Status: resp.StatusCode, Header: resp.Header, Body: raw}, nil
+}
+
+// Dial opens a WebSocket to path on the server. header adds headers to the upgrade
+// request; it may be nil.
+func (h *Harness) Dial(path string, header http.Header) (*websocket.Conn, error) {
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(h.URL, "http")+path, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("dial %s: %w (status %d)", path, err, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("dial %s: %w", path, err)
+	}
+	return conn, nil
+}
+
+func truncate(body []byte) string {
+	const limit = 300
+	if len(body) > limit {
+		return string(body[:limit]) + "..."
+	}
+	return string(body)
+}
@@ -0,0 +1,77 @@
+// Package tokenresolver picks the Qiniu credential a request is served with, under the
+// QINIU_TOKEN_MODE policy.
+package tokenresolver
+
+import "strings"
+
+// Token modes accepted by QINIU_TOKEN_MODE.
+const (
+	// ModeServer ignores client tokens and always uses the server key, which then never
+	// leaves the server and browsers need no credential at all.
+	ModeServer = "server"
+	// ModeClient requires a client token and never falls back to the server key.
+	ModeClient = "client"
+	// ModeEither prefers a client token and falls back to the server key.
+	ModeEither = "either"
+)
+
+// Sources of a resolved token, as logged.
+const (
+	SourceClient = "client"
+	SourceServer = "server"
+	SourceNone   = "none"
+)
+
+// Resolver applies a token mode.
+type Resolver struct {
+	mode      string
+	serverKey string
+}
+
+// New builds a Resolver for mode, ModeEither when unrecognized, with the server key.
+func New(mode, serverKey string) *Resolver {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case ModeServer, ModeClient:
+	default:
+		mode = ModeEither
+	}
+	return &Resolver{mode: mode, serverKey: strings.TrimSpace(serverKey)}
+}
+
+// Mode returns the mode in effect.
+func (r *Resolver) Mode() string {
+	return r.mode
+}
+
+// Server returns the server key regardless of the mode, for requests whose credential
+// was exchanged server-side, and where it came from.
+func (r *Resolver) Server() (token, source string) {
+	if r.serverKey == "" {
+		return "", SourceNone
+	}
+	return r.serverKey, SourceServer
+}
+
+// AcceptsClientTokens reports whether client-supplied tokens are used at all, including
+// ones sent after a connection is established.
+func (r *Resolver) AcceptsClientTokens() bool {
+	return r.mode != ModeServer
+}
+
+// Resolve returns the token for a request whose client-supplied candidates are given in
+// order of precedence, and where it came from. The token is empty, with SourceNone, when
+// the mode leaves none to use.
+func (r *Resolver) Resolve(candidates ...string) (token, source string) {
+	if r.mode != ModeServer {
+		for _, candidate := range candidates {
+			if candidate = strings.TrimSpace(candidate); candidate != "" {
+				return candidate, SourceClient
+			}
+		}
+	}
+	if r.mode != ModeClient && r.serverKey != "" {
+		return r.serverKey, SourceServer
+	}
+	return "", SourceNone
+}
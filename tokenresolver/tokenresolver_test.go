@@ -0,0 +1,63 @@
+package tokenresolver
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	type resolution struct{ token, source string }
+	cases := []struct {
+		name       string
+		mode       string
+		serverKey  string
+		candidates []string
+		want       resolution
+	}{
+		{"server: no client token", ModeServer, "server-key", nil, resolution{"server-key", SourceServer}},
+		{"server: client token ignored", ModeServer, "server-key", []string{"client-token"}, resolution{"server-key", SourceServer}},
+		{"server: no key", ModeServer, "", []string{"client-token"}, resolution{"", SourceNone}},
+
+		{"client: first candidate wins", ModeClient, "server-key", []string{"body-token", "header-token"}, resolution{"body-token", SourceClient}},
+		{"client: blank candidates skipped", ModeClient, "server-key", []string{"  ", "header-token"}, resolution{"header-token", SourceClient}},
+		{"client: candidate trimmed", ModeClient, "server-key", []string{" body-token "}, resolution{"body-token", SourceClient}},
+		{"client: never the server key", ModeClient, "server-key", nil, resolution{"", SourceNone}},
+
+		{"either: client token preferred", ModeEither, "server-key", []string{"", "header-token"}, resolution{"header-token", SourceClient}},
+		{"either: falls back to the server key", ModeEither, "server-key", []string{""}, resolution{"server-key", SourceServer}},
+		{"either: nothing to use", ModeEither, "", nil, resolution{"", SourceNone}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, source := New(tc.mode, tc.serverKey).Resolve(tc.candidates...)
+			if got := (resolution{token, source}); got != tc.want {
+				t.Errorf("Resolve = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		mode        string
+		want        string
+		wantClients bool
+	}{
+		{"server", ModeServer, false},
+		{" Server ", ModeServer, false},
+		{"client", ModeClient, true},
+		{"either", ModeEither, true},
+		{"", ModeEither, true},
+		{"bogus", ModeEither, true},
+	}
+	for _, tc := range cases {
+		r := New(tc.mode, " server-key ")
+		if r.Mode() != tc.want || r.AcceptsClientTokens() != tc.wantClients {
+			t.Errorf("New(%q) = mode %s accepting client tokens %t, want %s and %t", tc.mode, r.Mode(), r.AcceptsClientTokens(), tc.want, tc.wantClients)
+		}
+		// the server key serves exchanged credentials whatever the mode
+		if token, source := r.Server(); token != "server-key" || source != SourceServer {
+			t.Errorf("New(%q).Server() = %q from %s, want the trimmed server key", tc.mode, token, source)
+		}
+	}
+	if token, source := New(ModeServer, "").Server(); token != "" || source != SourceNone {
+		t.Errorf("Server without a key = %q from %s, want none", token, source)
+	}
+}
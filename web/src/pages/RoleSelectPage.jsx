@@ -103,8 +103,7 @@ const RolesCatalog = ({
                             <h3>{role.name}</h3>
                             <p>{role.bio || "暂无简介"}</p>
                             <div className="tags">
-                                {(role.tags || "")
-                                    .split(",")
+                                {(Array.isArray(role.tags) ? role.tags : (role.tags || "").split(","))
                                     .map((tag) => tag.trim())
                                     .filter(Boolean)
                                     .slice(0, 4)
@@ -0,0 +1,267 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Worker is a long-running background consumer managed by a Supervisor.
+// Run must block until ctx is cancelled; returning earlier counts as a crash.
+type Worker interface {
+	Name() string
+	Run(ctx context.Context) error
+}
+
+type funcWorker struct {
+	name string
+	run  func(context.Context) error
+}
+
+func (w funcWorker) Name() string                  { return w.name }
+func (w funcWorker) Run(ctx context.Context) error { return w.run(ctx) }
+
+// Func adapts a plain function into a Worker.
+func Func(name string, run func(context.Context) error) Worker {
+	return funcWorker{name: name, run: run}
+}
+
+// Worker lifecycle states reported by Stats.
+const (
+	StatePending = "pending"
+	StateRunning = "running"
+	StateBackoff = "backoff"
+	StateStopped = "stopped"
+	StateFailed  = "failed"
+)
+
+// Options tunes restart behaviour. Zero values fall back to defaults.
+type Options struct {
+	InitialBackoff time.Duration // delay before the first restart (default 1s)
+	MaxBackoff     time.Duration // cap for the doubling backoff (default 30s)
+	// A worker that exits more than CrashLoopThreshold times within CrashLoopWindow
+	// is considered crash-looping and is not restarted again (defaults 5 / 1m).
+	CrashLoopThreshold int
+	CrashLoopWindow    time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 30 * time.Second
+	}
+	if o.MaxBackoff < o.InitialBackoff {
+		o.MaxBackoff = o.InitialBackoff
+	}
+	if o.CrashLoopThreshold <= 0 {
+		o.CrashLoopThreshold = 5
+	}
+	if o.CrashLoopWindow <= 0 {
+		o.CrashLoopWindow = time.Minute
+	}
+	return o
+}
+
+// Stats is a point-in-time snapshot of one supervised worker.
+type Stats struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Restarts  int    `json:"restarts"`
+	Panics    int    `json:"panics"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+type entry struct {
+	worker   Worker
+	cancel   context.CancelFunc
+	done     chan struct{}
+	state    string
+	restarts int
+	panics   int
+	lastErr  error
+}
+
+// Supervisor starts workers, restarts them with backoff when they exit unexpectedly
+// and stops them in reverse registration order.
+type Supervisor struct {
+	opts   Options
+	logger *zap.SugaredLogger
+	// now and after are the clock and timer of restarts and crash-loop detection.
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+
+	mu      sync.Mutex
+	entries []*entry
+	baseCtx context.Context
+	started bool
+	stopped bool
+}
+
+// NewSupervisor creates an idle supervisor.
+func NewSupervisor(logger *zap.SugaredLogger, opts Options) *Supervisor {
+	return &Supervisor{opts: opts.withDefaults(), logger: logger, now: time.Now, after: time.After}
+}
+
+// Add registers a worker. Workers added after Start are launched immediately.
+func (s *Supervisor) Add(w Worker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+	e := &entry{worker: w, state: StatePending}
+	s.entries = append(s.entries, e)
+	if s.started {
+		s.launchLocked(e)
+	}
+}
+
+// Start launches every registered worker. ctx bounds the lifetime of all workers.
+func (s *Supervisor) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started || s.stopped {
+		return
+	}
+	s.started = true
+	s.baseCtx = ctx
+	for _, e := range s.entries {
+		s.launchLocked(e)
+	}
+}
+
+// Stop cancels workers one by one in reverse order, waiting for each to exit
+// before moving on. It returns ctx.Err() if the deadline expires first.
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	entries := append([]*entry(nil), s.entries...)
+	s.mu.Unlock()
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.cancel == nil {
+			continue
+		}
+		e.cancel()
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return fmt.Errorf("stop worker %s: %w", e.worker.Name(), ctx.Err())
+		}
+	}
+	return nil
+}
+
+// Stats reports the current state of each worker in registration order.
+func (s *Supervisor) Stats() []Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]Stats, 0, len(s.entries))
+	for _, e := range s.entries {
+		st := Stats{Name: e.worker.Name(), State: e.state, Restarts: e.restarts, Panics: e.panics}
+		if e.lastErr != nil {
+			st.LastError = e.lastErr.Error()
+		}
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+func (s *Supervisor) launchLocked(e *entry) {
+	ctx, cancel := context.WithCancel(s.baseCtx)
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	go s.supervise(ctx, e)
+}
+
+func (s *Supervisor) supervise(ctx context.Context, e *entry) {
+	defer close(e.done)
+
+	name := e.worker.Name()
+	backoff := s.opts.InitialBackoff
+	var exits []time.Time
+
+	for {
+		s.setState(e, StateRunning, nil)
+		s.logger.Infof("worker %s started", name)
+
+		startedAt := s.now()
+		err := s.runOnce(ctx, e)
+		if ctx.Err() != nil {
+			s.setState(e, StateStopped, err)
+			s.logger.Infof("worker %s stopped", name)
+			return
+		}
+		if err == nil {
+			err = errors.New("exited without error")
+		}
+
+		now := s.now()
+		if now.Sub(startedAt) >= s.opts.CrashLoopWindow {
+			// a long healthy run resets the backoff
+			backoff = s.opts.InitialBackoff
+		}
+		exits = append(exits, now)
+		for len(exits) > 0 && now.Sub(exits[0]) > s.opts.CrashLoopWindow {
+			exits = exits[1:]
+		}
+		if len(exits) > s.opts.CrashLoopThreshold {
+			s.setState(e, StateFailed, err)
+			s.logger.Errorf("worker %s is crash-looping (%d exits within %s), giving up: %v", name, len(exits), s.opts.CrashLoopWindow, err)
+			return
+		}
+
+		s.setState(e, StateBackoff, err)
+		s.logger.Warnf("worker %s exited unexpectedly, restarting in %s: %v", name, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			s.setState(e, StateStopped, err)
+			s.logger.Infof("worker %s stopped", name)
+			return
+		case <-s.after(backoff):
+		}
+
+		backoff *= 2
+		if backoff > s.opts.MaxBackoff {
+			backoff = s.opts.MaxBackoff
+		}
+		s.mu.Lock()
+		e.restarts++
+		s.mu.Unlock()
+	}
+}
+
+// runOnce executes the worker, converting a panic into an error.
+func (s *Supervisor) runOnce(ctx context.Context, e *entry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.mu.Lock()
+			e.panics++
+			s.mu.Unlock()
+			s.logger.Errorf("worker %s panicked: %v\n%s", e.worker.Name(), r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return e.worker.Run(ctx)
+}
+
+func (s *Supervisor) setState(e *entry, state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.state = state
+	if err != nil {
+		e.lastErr = err
+	}
+}
@@ -0,0 +1,276 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeClock is a supervisor clock that only moves when told to. Its timers fire at once,
+// recording the delay they were asked for.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	delays []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delays = append(c.delays, d)
+	fired := make(chan time.Time, 1)
+	fired <- c.now
+	return fired
+}
+
+func (c *fakeClock) Delays() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.delays)
+}
+
+// fakeWorker runs step for every start, numbered from 1, and once steps run out blocks
+// until it is stopped.
+type fakeWorker struct {
+	name string
+	step func(ctx context.Context, run int) error
+	// onStop is called as the worker returns from a cancellation.
+	onStop func(name string)
+
+	mu   sync.Mutex
+	runs int
+}
+
+func (w *fakeWorker) Name() string { return w.name }
+
+func (w *fakeWorker) Run(ctx context.Context) error {
+	w.mu.Lock()
+	w.runs++
+	run := w.runs
+	w.mu.Unlock()
+
+	if w.step != nil {
+		if err := w.step(ctx, run); err != nil {
+			return err
+		}
+	}
+	<-ctx.Done()
+	if w.onStop != nil {
+		w.onStop(w.name)
+	}
+	return nil
+}
+
+func (w *fakeWorker) Runs() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.runs
+}
+
+func newTestSupervisor(opts Options) (*Supervisor, *fakeClock) {
+	clock := newFakeClock()
+	s := NewSupervisor(zap.NewNop().Sugar(), opts)
+	s.now = clock.Now
+	s.after = clock.After
+	return s, clock
+}
+
+// waitForState polls until the first worker of s is in state.
+func waitForState(t *testing.T, s *Supervisor, state string) Stats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats := s.Stats()[0]
+		if stats.State == state {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker %s is %s, want %s", stats.Name, stats.State, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func stopSupervisor(t *testing.T, s *Supervisor) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Errorf("Stop: %v", err)
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	s, clock := newTestSupervisor(Options{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond, CrashLoopThreshold: 100})
+	worker := &fakeWorker{name: "flaky", step: func(_ context.Context, run int) error {
+		switch {
+		case run == 3:
+			panic("boom")
+		case run <= 4:
+			return fmt.Errorf("crash %d", run)
+		}
+		return nil
+	}}
+	s.Add(worker)
+	s.Start(context.Background())
+	defer stopSupervisor(t, s)
+
+	for worker.Runs() < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	stats := waitForState(t, s, StateRunning)
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond}
+	if got := clock.Delays(); !slices.Equal(got, want) {
+		t.Errorf("restart delays = %v, want %v doubling up to the cap", got, want)
+	}
+	if stats.Restarts != 4 || stats.Panics != 1 || stats.LastError != "crash 4" {
+		t.Errorf("stats = %+v, want 4 restarts, 1 panic and the last crash", stats)
+	}
+}
+
+func TestBackoffResetsAfterHealthyRun(t *testing.T) {
+	s, clock := newTestSupervisor(Options{InitialBackoff: 10 * time.Millisecond, MaxBackoff: time.Second, CrashLoopWindow: time.Minute})
+	worker := &fakeWorker{name: "steady", step: func(_ context.Context, run int) error {
+		switch run {
+		case 1, 2:
+			return errors.New("crash")
+		case 3:
+			// a run as long as the crash-loop window is healthy
+			clock.Advance(time.Minute)
+			return errors.New("crash after a long run")
+		}
+		return nil
+	}}
+	s.Add(worker)
+	s.Start(context.Background())
+	defer stopSupervisor(t, s)
+
+	for worker.Runs() < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	waitForState(t, s, StateRunning)
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 10 * time.Millisecond}
+	if got := clock.Delays(); !slices.Equal(got, want) {
+		t.Errorf("restart delays = %v, want %v with the backoff reset by the long run", got, want)
+	}
+}
+
+func TestCrashLoopSuppression(t *testing.T) {
+	t.Run("exits within the window", func(t *testing.T) {
+		s, _ := newTestSupervisor(Options{InitialBackoff: time.Millisecond, CrashLoopThreshold: 3, CrashLoopWindow: time.Minute})
+		worker := &fakeWorker{name: "broken", step: func(context.Context, int) error { return errors.New("bad config") }}
+		s.Add(worker)
+		s.Start(context.Background())
+		defer stopSupervisor(t, s)
+
+		stats := waitForState(t, s, StateFailed)
+		// the worker is given up on rather than restarted forever
+		time.Sleep(10 * time.Millisecond)
+		if worker.Runs() != 4 || stats.Restarts != 3 || stats.LastError != "bad config" {
+			t.Errorf("%d runs with stats %+v, want 4 runs and 3 restarts before giving up", worker.Runs(), stats)
+		}
+	})
+
+	t.Run("exits spread beyond the window", func(t *testing.T) {
+		s, clock := newTestSupervisor(Options{InitialBackoff: time.Millisecond, CrashLoopThreshold: 3, CrashLoopWindow: time.Minute})
+		worker := &fakeWorker{name: "occasional", step: func(_ context.Context, run int) error {
+			if run > 8 {
+				return nil
+			}
+			clock.Advance(30 * time.Second)
+			return errors.New("transient")
+		}}
+		s.Add(worker)
+		s.Start(context.Background())
+		defer stopSupervisor(t, s)
+
+		for worker.Runs() < 9 {
+			time.Sleep(time.Millisecond)
+		}
+		if stats := waitForState(t, s, StateRunning); stats.Restarts != 8 {
+			t.Errorf("stats = %+v, want 8 restarts and still running", stats)
+		}
+	})
+}
+
+func TestOrderedShutdown(t *testing.T) {
+	var mu sync.Mutex
+	var stopped []string
+	onStop := func(name string) {
+		// a slow exit must still finish before the next worker is told to stop
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		stopped = append(stopped, name)
+		mu.Unlock()
+	}
+
+	s, _ := newTestSupervisor(Options{})
+	for _, name := range []string{"first", "second"} {
+		s.Add(&fakeWorker{name: name, onStop: onStop})
+	}
+	s.Start(context.Background())
+	// added after Start, so launched at once and stopped first
+	late := &fakeWorker{name: "late", onStop: onStop}
+	s.Add(late)
+	for late.Runs() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stopSupervisor(t, s)
+	if want := []string{"late", "second", "first"}; !slices.Equal(stopped, want) {
+		t.Errorf("stop order = %v, want %v", stopped, want)
+	}
+	for _, stats := range s.Stats() {
+		if stats.State != StateStopped || stats.Restarts != 0 {
+			t.Errorf("%s = %+v after Stop, want stopped without restarts", stats.Name, stats)
+		}
+	}
+
+	s.Add(&fakeWorker{name: "too late"})
+	if n := len(s.Stats()); n != 3 {
+		t.Errorf("%d workers after adding one to a stopped supervisor, want 3", n)
+	}
+}
+
+func TestStopDeadline(t *testing.T) {
+	s, _ := newTestSupervisor(Options{})
+	release := make(chan struct{})
+	defer close(release)
+	s.Add(&fakeWorker{name: "stubborn", step: func(context.Context, int) error {
+		// ignores cancellation until the test ends
+		<-release
+		return nil
+	}})
+	s.Start(context.Background())
+	waitForState(t, s, StateRunning)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := s.Stop(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "stubborn") {
+		t.Errorf("Stop = %v, want the deadline naming the stubborn worker", err)
+	}
+}